@@ -0,0 +1,7 @@
+//go:build linux
+
+package buildtags
+
+func platformName() string {
+    return "linux"
+}
@@ -0,0 +1,6 @@
+package buildtags
+
+// Platform reports the platform the binary was built for.
+func Platform() string {
+    return "platform: " + platformName()
+}
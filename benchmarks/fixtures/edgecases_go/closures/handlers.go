@@ -0,0 +1,26 @@
+package closures
+
+import (
+    "strings"
+
+    "edgecases_go/textutil"
+)
+
+// handlers maps a command to its implementation.
+var handlers = map[string]func(string) string{
+    "upper": func(s string) string { return strings.ToUpper(s) },
+    "greet": func(s string) string { return textutil.Greet(s) },
+    "audit": func(s string) string { return audit(s) },
+}
+
+// Dispatch runs the handler registered for cmd.
+func Dispatch(cmd, arg string) string {
+    if h, ok := handlers[cmd]; ok {
+        return h(arg)
+    }
+    return audit(arg)
+}
+
+func audit(s string) string {
+    return "audited " + s
+}
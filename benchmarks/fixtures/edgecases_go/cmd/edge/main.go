@@ -0,0 +1,28 @@
+package main
+
+import (
+    "fmt"
+
+    "edgecases_go/buildtags"
+    "edgecases_go/closures"
+    "edgecases_go/dotimport"
+    "edgecases_go/embedding"
+    "edgecases_go/generics"
+    "edgecases_go/legacy"
+)
+
+func main() {
+    fmt.Println(generics.Totals([]int{1, 2, 3}))
+
+    var rw embedding.ReadWriter = &embedding.Buffer{}
+    rw.Write([]byte("data"))
+
+    svc := embedding.NewService("api", 8080)
+    fmt.Println(svc.Start(), svc.Describe())
+    defer svc.Stop()
+
+    fmt.Println(buildtags.Platform())
+    fmt.Println(dotimport.Welcome("gopher"))
+    fmt.Println(closures.Dispatch("greet", "gopher"))
+    fmt.Println(legacy.Greet("gopher"), legacy.Describe())
+}
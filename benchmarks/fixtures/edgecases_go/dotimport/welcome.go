@@ -0,0 +1,10 @@
+package dotimport
+
+import (
+    . "edgecases_go/textutil"
+)
+
+// Welcome calls Greet and Shout unqualified, through the dot import.
+func Welcome(name string) string {
+    return Shout(Greet(name))
+}
@@ -0,0 +1,35 @@
+package embedding
+
+// Reader reads bytes.
+type Reader interface {
+    Read(p []byte) (int, error)
+}
+
+// Writer writes bytes.
+type Writer interface {
+    Write(p []byte) (int, error)
+}
+
+// ReadWriter groups Reader and Writer by embedding them.
+type ReadWriter interface {
+    Reader
+    Writer
+}
+
+// Buffer implements ReadWriter without naming it.
+type Buffer struct {
+    data []byte
+}
+
+// Read copies buffered bytes into p.
+func (b *Buffer) Read(p []byte) (int, error) {
+    n := copy(p, b.data)
+    b.data = b.data[n:]
+    return n, nil
+}
+
+// Write appends p to the buffer.
+func (b *Buffer) Write(p []byte) (int, error) {
+    b.data = append(b.data, p...)
+    return len(p), nil
+}
@@ -0,0 +1,37 @@
+package embedding
+
+// Base carries what every service has.
+type Base struct {
+    name string
+}
+
+// Describe names the service.
+func (b *Base) Describe() string {
+    return "service " + b.name
+}
+
+// Close releases the service.
+func (b *Base) Close() error {
+    return nil
+}
+
+// Service embeds *Base, so Describe and Close are promoted to it.
+type Service struct {
+    *Base
+    port int
+}
+
+// NewService builds a service listening on port.
+func NewService(name string, port int) *Service {
+    return &Service{Base: &Base{name: name}, port: port}
+}
+
+// Start calls the promoted Describe through the outer value.
+func (s *Service) Start() string {
+    return s.Describe()
+}
+
+// Stop calls Close through the embedded field explicitly.
+func (s *Service) Stop() error {
+    return s.Base.Close()
+}
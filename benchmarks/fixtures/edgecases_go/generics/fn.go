@@ -0,0 +1,40 @@
+package generics
+
+// Map applies f to every element of xs.
+func Map[T, U any](xs []T, f func(T) U) []U {
+    out := make([]U, 0, len(xs))
+    for _, x := range xs {
+        out = append(out, f(x))
+    }
+    return out
+}
+
+// Sum adds up xs.
+func Sum[N Number](xs []N) N {
+    var total N
+    for _, x := range xs {
+        total += x
+    }
+    return total
+}
+
+// Pair holds two values of possibly different types.
+type Pair[K comparable, V any] struct {
+    Key   K
+    Value V
+}
+
+// Totals exercises inferred and explicit instantiation.
+func Totals(values []int) int {
+    s := NewStack[int]()
+    for _, v := range values {
+        s.Push(v)
+    }
+    doubled := Map(values, double)
+    p := Pair[string, int]{Key: "sum", Value: Sum(doubled)}
+    return p.Value + Sum[int](values) + s.Len()
+}
+
+func double(x int) int {
+    return 2 * x
+}
@@ -0,0 +1,37 @@
+package generics
+
+// Number is the constraint of the numeric helpers.
+type Number interface {
+    ~int | ~int64 | ~float64
+}
+
+// Stack is a last-in, first-out collection.
+type Stack[T any] struct {
+    items []T
+}
+
+// NewStack returns an empty stack.
+func NewStack[T any]() *Stack[T] {
+    return &Stack[T]{}
+}
+
+// Push adds v on top of the stack.
+func (s *Stack[T]) Push(v T) {
+    s.items = append(s.items, v)
+}
+
+// Pop removes and returns the top of the stack.
+func (s *Stack[T]) Pop() (T, bool) {
+    var zero T
+    if len(s.items) == 0 {
+        return zero, false
+    }
+    top := s.items[len(s.items)-1]
+    s.items = s.items[:len(s.items)-1]
+    return top, true
+}
+
+// Len reports the number of items.
+func (s *Stack[T]) Len() int {
+    return len(s.items)
+}
@@ -0,0 +1,3 @@
+module edgecases_go
+
+go 1.21
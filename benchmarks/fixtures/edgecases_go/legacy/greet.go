@@ -0,0 +1,11 @@
+package legacy
+
+// Greet is an older greeting with the same name as textutil.Greet.
+func Greet(name string) string {
+    return "hi " + name
+}
+
+// Describe has the same name as the promoted embedding.Base.Describe.
+func Describe() string {
+    return "legacy"
+}
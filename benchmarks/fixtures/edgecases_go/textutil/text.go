@@ -0,0 +1,13 @@
+package textutil
+
+import "strings"
+
+// Greet builds a greeting.
+func Greet(name string) string {
+    return "hello, " + name
+}
+
+// Shout upper-cases s.
+func Shout(s string) string {
+    return strings.ToUpper(s) + "!"
+}
@@ -0,0 +1,226 @@
+#!/usr/bin/env python3
+"""Generate synthetic repositories of configurable size for scale benchmarks.
+
+The webapp fixtures are small (~50 files). This generates a Go or Python
+project with any number of files, laid out as a package tree whose depth and
+fan-out are tunable, where every function calls a few functions of packages
+generated before its own (so Go imports never form a cycle) and some call a
+shared logger, giving the graph both long chains and a high-fan-in hub.
+
+Output goes to OUT/fixtures/<name>/ with queries in OUT/ground_truth/<name>.json,
+the layout `cartog bench` expects:
+
+    ./benchmarks/fixtures/gen_synthetic.py /tmp/synth --files 100000 --lang go
+    cartog bench --fixtures-dir /tmp/synth/fixtures --iterations 5 --index-runs 1
+
+Generation is deterministic for a given seed and parameters.
+"""
+
+import argparse
+import json
+import os
+import random
+import shutil
+
+
+def package_tree(depth, fan_out, wanted):
+    """Package paths breadth first, root first, at most `wanted` of them."""
+    packages = ["core"]
+    level = [""]
+    for _ in range(depth):
+        next_level = []
+        for parent in level:
+            for i in range(fan_out):
+                path = f"{parent}/p{i}" if parent else f"p{i}"
+                packages.append(path)
+                next_level.append(path)
+                if len(packages) >= wanted:
+                    return packages
+        level = next_level
+    return packages
+
+
+def assign_files(files, packages):
+    """(package index, file name) for each file, spread evenly over packages."""
+    per_package = -(-files // len(packages))
+    out = []
+    for n in range(files):
+        pkg = min(n // per_package, len(packages) - 1)
+        out.append((pkg, f"f{n}"))
+    return out
+
+
+def go_file(pkg_path, n, funcs, calls):
+    pkg_name = pkg_path.rsplit("/", 1)[-1]
+    imports = sorted({p for p, _ in calls_flat(calls) if p != pkg_path})
+    aliases = {p: f"i{k}" for k, p in enumerate(imports)}
+    lines = [f"package {pkg_name}", ""]
+    if imports:
+        lines.append("import (")
+        for p in imports:
+            lines.append(f'\t{aliases[p]} "synth/{p}"')
+        lines.append(")")
+        lines.append("")
+    lines += [
+        f"// T{n} holds the state of unit {n}.",
+        f"type T{n} struct {{",
+        "\tID    int",
+        "\tLabel string",
+        "}",
+        "",
+        "// Run processes the unit.",
+        f"func (t *T{n}) Run(x int) int {{",
+        f"\treturn F{n}_0(x + t.ID)",
+        "}",
+        "",
+    ]
+    for k in range(funcs):
+        lines.append(f"// F{n}_{k} is step {k} of unit {n}.")
+        lines.append(f"func F{n}_{k}(x int) int {{")
+        if k + 1 < funcs:
+            lines.append(f"\tx = F{n}_{k + 1}(x)")
+        for p, fn in calls[k]:
+            prefix = f"{aliases[p]}." if p != pkg_path else ""
+            lines.append(f"\tx += {prefix}{fn}(x)")
+        lines.append("\treturn x")
+        lines.append("}")
+        lines.append("")
+    return "\n".join(lines)
+
+
+def py_file(pkg_path, n, funcs, calls):
+    imports = sorted({(p, module_of(fn), fn) for p, fn in calls_flat(calls)})
+    lines = [f'"""Unit {n}."""', ""]
+    for p, module, fn in imports:
+        lines.append(f"from {p.replace('/', '.')}.{module} import {fn}")
+    if imports:
+        lines.append("")
+    lines += [
+        "",
+        f"class T{n}:",
+        f'    """State of unit {n}."""',
+        "",
+        "    def __init__(self, ident: int, label: str):",
+        "        self.ident = ident",
+        "        self.label = label",
+        "",
+        "    def run(self, x: int) -> int:",
+        f"        return F{n}_0(x + self.ident)",
+        "",
+    ]
+    for k in range(funcs):
+        lines.append("")
+        lines.append(f"def F{n}_{k}(x: int) -> int:")
+        lines.append(f'    """Step {k} of unit {n}."""')
+        if k + 1 < funcs:
+            lines.append(f"    x = F{n}_{k + 1}(x)")
+        for _, fn in calls[k]:
+            lines.append(f"    x += {fn}(x)")
+        lines.append("    return x")
+        lines.append("")
+    return "\n".join(lines)
+
+
+def calls_flat(calls):
+    return [c for per_func in calls for c in per_func]
+
+
+def module_of(fn):
+    """Python module defining `fn`: `F12_3` lives in f12.py, `Log` in logger.py."""
+    return "logger" if fn == "Log" else fn.split("_")[0].lower()
+
+
+LOGGER = {
+    "go": "package core\n\n// Log records a value; most units call it.\nfunc Log(x int) int {\n\treturn x\n}\n",
+    "py": '"""Shared logger."""\n\n\ndef Log(x: int) -> int:\n    """Record a value; most units call it."""\n    return x\n',
+}
+
+
+def generate(out, lang, files, depth, fan_out, funcs, calls_per_func, seed):
+    rng = random.Random(seed)
+    name = f"synth_{lang}_{files}"
+    root = os.path.join(out, "fixtures", name)
+    if os.path.exists(root):
+        shutil.rmtree(root)
+
+    packages = package_tree(depth, fan_out, max(1, files // 20))
+    layout = assign_files(files, packages)
+    ext = "go" if lang == "go" else "py"
+
+    for pkg in packages:
+        os.makedirs(os.path.join(root, pkg), exist_ok=True)
+        if lang == "py":
+            open(os.path.join(root, pkg, "__init__.py"), "w").close()
+    if lang == "go":
+        with open(os.path.join(root, "go.mod"), "w") as f:
+            f.write("module synth\n\ngo 1.21\n")
+    with open(os.path.join(root, "core", f"logger.{ext}"), "w") as f:
+        f.write(LOGGER[lang])
+
+    first_of_package = {}
+    for n, (pkg, fname) in enumerate(layout):
+        # Only files of earlier packages are call targets.
+        callable_files = first_of_package.setdefault(pkg, n)
+        calls = []
+        for _ in range(funcs):
+            per_func = []
+            for _ in range(calls_per_func if callable_files else 0):
+                t = rng.randrange(callable_files)
+                t_pkg = packages[layout[t][0]]
+                per_func.append((t_pkg, f"F{t}_{rng.randrange(funcs)}"))
+            if rng.random() < 0.3:
+                per_func.append(("core", "Log"))
+            calls.append(per_func)
+        pkg_path = packages[pkg]
+        render = go_file if lang == "go" else py_file
+        with open(os.path.join(root, pkg_path, f"{fname}.{ext}"), "w") as f:
+            f.write(render(pkg_path, n, funcs, calls))
+
+    write_queries(out, name, lang, layout, packages, funcs)
+    return root, len(packages)
+
+
+def write_queries(out, name, lang, layout, packages, funcs):
+    last = len(layout) - 1
+    ext = "go" if lang == "go" else "py"
+    mid_pkg, mid_file = layout[last // 2]
+    queries = {
+        "01_find_callers": {"description": "Who calls the shared logger?", "query": "refs Log --kind calls"},
+        "02_file_structure": {
+            "description": "Outline of a file in the middle of the tree",
+            "query": f"outline {packages[mid_pkg]}/{mid_file}.{ext}",
+        },
+        "03_refactor_impact": {"description": "Impact of an early, widely reached function", "query": f"impact F0_{funcs - 1} --depth 3"},
+        "05_trace_call_chain": {"description": "What does the last unit call?", "query": f"callees F{last}_0"},
+        "08_symbol_search": {"description": "Prefix search across many units", "query": "search F1"},
+        "stats": {"description": "Index statistics", "query": "stats"},
+    }
+    gt = os.path.join(out, "ground_truth")
+    os.makedirs(gt, exist_ok=True)
+    with open(os.path.join(gt, f"{name}.json"), "w") as f:
+        json.dump(queries, f, indent=2)
+        f.write("\n")
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.split("\n\n")[0])
+    parser.add_argument("out", help="directory to write fixtures/ and ground_truth/ into")
+    parser.add_argument("--files", type=int, default=10_000, help="source files (default: 10000)")
+    parser.add_argument("--lang", choices=["go", "py"], default="go")
+    parser.add_argument("--depth", type=int, default=4, help="package nesting depth (default: 4)")
+    parser.add_argument("--fan-out", type=int, default=8, help="subpackages per package (default: 8)")
+    parser.add_argument("--funcs", type=int, default=5, help="functions per file (default: 5)")
+    parser.add_argument("--calls", type=int, default=2, help="cross-file calls per function (default: 2)")
+    parser.add_argument("--seed", type=int, default=1)
+    args = parser.parse_args()
+    if args.files < 1 or args.funcs < 1 or args.depth < 0 or args.fan_out < 1:
+        parser.error("--files, --funcs and --fan-out must be positive, --depth non-negative")
+
+    root, packages = generate(
+        args.out, args.lang, args.files, args.depth, args.fan_out, args.funcs, args.calls, args.seed
+    )
+    print(f"  CREATED: {root} ({args.files} files in {packages} packages)")
+
+
+if __name__ == "__main__":
+    main()
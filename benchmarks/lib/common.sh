@@ -52,6 +52,23 @@ count_lines() {
     fi
 }
 
+# Milliseconds since the epoch. GNU date has %N; BSD date (macOS) does not.
+now_ms() {
+    local ns
+    ns=$(date +%s%N)
+    if [[ "$ns" == *N ]]; then
+        perl -MTime::HiRes=time -e 'printf "%d\n", time * 1000'
+    else
+        echo $(( ns / 1000000 ))
+    fi
+}
+
+# Wall time per approach for the current question, in ms. Commands of one
+# approach add up; emit_result_json reports and resets them.
+NAIVE_MS=0
+BEST_MS=0
+CARTOG_MS=0
+
 # Approximate token count (bytes / 4 is a common heuristic for English/code)
 count_tokens() {
     local bytes
@@ -69,7 +86,14 @@ run_grep() {
     local fixture_dir="$2"
     shift 2
 
+    local start
+    start=$(now_ms)
     GREP_OUTPUT=$(cd "$fixture_dir" && eval "$@" 2>/dev/null | grep -v '\.cartog\.db' || true)
+    local elapsed=$(( $(now_ms) - start ))
+    case "$label" in
+        naive) NAIVE_MS=$((NAIVE_MS + elapsed)) ;;
+        *)     BEST_MS=$((BEST_MS + elapsed)) ;;
+    esac
     GREP_TOKENS=$(count_tokens "$GREP_OUTPUT")
     GREP_LINES=$(count_lines "$GREP_OUTPUT")
 }
@@ -83,7 +107,10 @@ run_cartog_cmd() {
     shift
 
     # Human-readable output for token comparison (what agent actually processes)
+    local start
+    start=$(now_ms)
     CARTOG_OUTPUT=$(cd "$fixture_dir" && $CARTOG "$@" 2>/dev/null || true)
+    CARTOG_MS=$((CARTOG_MS + $(now_ms) - start))
     CARTOG_TOKENS=$(count_tokens "$CARTOG_OUTPUT")
     CARTOG_LINES=$(count_lines "$CARTOG_OUTPUT")
 
@@ -92,13 +119,17 @@ run_cartog_cmd() {
 }
 
 # Run a cat command (simulating "read entire file") and capture metrics.
+# Its time counts toward the naive approach.
 # Usage: run_cat <fixture_dir> <file>
 # Sets: CAT_OUTPUT, CAT_TOKENS, CAT_LINES
 run_cat() {
     local fixture_dir="$1"
     local file="$2"
 
+    local start
+    start=$(now_ms)
     CAT_OUTPUT=$(cat "$fixture_dir/$file" 2>/dev/null || true)
+    NAIVE_MS=$((NAIVE_MS + $(now_ms) - start))
     CAT_TOKENS=$(count_tokens "$CAT_OUTPUT")
     CAT_LINES=$(count_lines "$CAT_OUTPUT")
 }
@@ -170,8 +201,24 @@ print_comparison() {
         "$reduction" >&2
 }
 
+# ── Raw outputs for other tokenizers ──
+
+# Save the text each approach produced for a question, so tokenize.py can
+# count it under other tokenizers than the bytes/4 estimate. Does nothing
+# unless run.sh was given --tokenizers (which sets OUTPUTS_DIR).
+# Usage: save_outputs <scenario> <lang> <naive_out> <best_out> <cartog_out>
+save_outputs() {
+    [ -n "${OUTPUTS_DIR:-}" ] || return 0
+    local prefix="$OUTPUTS_DIR/$1.$2"
+    printf '%s' "$3" > "$prefix.naive.txt"
+    printf '%s' "$4" > "$prefix.best.txt"
+    printf '%s' "$5" > "$prefix.cartog.txt"
+}
+
 # ── JSON output for results file ──
 
+# Print one result line, with the wall time accumulated since the last one.
+
 emit_result_json() {
     local scenario="$1" lang="$2"
     local naive_tok="$3" naive_recall="$4"
@@ -179,6 +226,9 @@ emit_result_json() {
     local cartog_tok="$7" cartog_recall="$8"
 
     cat <<EOF
-{"scenario":"${scenario}","lang":"${lang}","naive_tokens":${naive_tok},"naive_recall":${naive_recall},"best_tokens":${best_tok},"best_recall":${best_recall},"cartog_tokens":${cartog_tok},"cartog_recall":${cartog_recall}}
+{"scenario":"${scenario}","lang":"${lang}","naive_tokens":${naive_tok},"naive_recall":${naive_recall},"naive_ms":${NAIVE_MS},"best_tokens":${best_tok},"best_recall":${best_recall},"best_ms":${BEST_MS},"cartog_tokens":${cartog_tok},"cartog_recall":${cartog_recall},"cartog_ms":${CARTOG_MS}}
 EOF
+    NAIVE_MS=0
+    BEST_MS=0
+    CARTOG_MS=0
 }
@@ -0,0 +1,210 @@
+#!/usr/bin/env bash
+# report.sh — Comparative token/latency report from run.sh results.
+#
+# Reads results/latest.jsonl (one line per question and fixture) and writes:
+#   results/summary.json  totals per scenario and overall
+#   results/report.md     the same as Markdown tables
+#   results/report.html   a self-contained page with charts, to attach to a PR
+# When results/tokenizers.jsonl exists (run.sh --tokenizers), the report also
+# has a scenario x tokenizer matrix of token counts and reductions. When
+# results/bench.json exists (run.sh --bench, or `cartog --json bench`), the
+# HTML page also charts its latency percentiles, index size and index time.
+# It then prints the overall figures. With --update-readme, the comparison table
+# in the top-level README (between the cartog-benchmark markers) is rewritten
+# from the same numbers, so the headline figures always come from a run.
+#
+# "grep/cat" is the naive approach of each scenario: what an agent does
+# without an index. Times are wall clock per question, process start included.
+#
+# Usage:
+#   ./benchmarks/report.sh                            # report on results/latest.jsonl
+#   ./benchmarks/report.sh --input results/old.jsonl  # another results file
+#   ./benchmarks/report.sh --update-readme            # also refresh README.md
+#   ./benchmarks/report.sh --tokens results/tok.jsonl # another tokenizer file
+#   ./benchmarks/report.sh --bench results/main.json  # another cartog bench report
+#
+# Prerequisites: jq
+
+set -euo pipefail
+
+export LC_NUMERIC=C
+
+BENCH_DIR="$(cd "$(dirname "$0")" && pwd)"
+PROJECT_ROOT="$(cd "$BENCH_DIR/.." && pwd)"
+RESULTS_DIR="$BENCH_DIR/results"
+INPUT="$RESULTS_DIR/latest.jsonl"
+TOKENS="$RESULTS_DIR/tokenizers.jsonl"
+BENCH="$RESULTS_DIR/bench.json"
+UPDATE_README=false
+
+BEGIN_MARKER="<!-- cartog-benchmark:begin -->"
+END_MARKER="<!-- cartog-benchmark:end -->"
+
+while [[ $# -gt 0 ]]; do
+    case $1 in
+        --input) INPUT="$2"; shift 2 ;;
+        --tokens) TOKENS="$2"; shift 2 ;;
+        --bench) BENCH="$2"; shift 2 ;;
+        --update-readme) UPDATE_README=true; shift ;;
+        -h|--help)
+            echo "Usage: $0 [--input FILE] [--tokens FILE] [--bench FILE] [--update-readme]"
+            exit 0
+            ;;
+        *) echo "Unknown option: $1"; exit 1 ;;
+    esac
+done
+
+if ! command -v jq &>/dev/null; then
+    echo "jq is required for the report" >&2
+    exit 1
+fi
+if [ ! -s "$INPUT" ]; then
+    echo "No results in $INPUT; run ./benchmarks/run.sh first" >&2
+    exit 1
+fi
+
+mkdir -p "$RESULTS_DIR"
+
+# ── Aggregate ──
+
+# Per scenario and overall: mean tokens, recall and ms for each approach.
+jq -s '
+    def mean(f): if length == 0 then 0 else (map(f // 0) | add) / length end;
+    def totals: {
+        questions: length,
+        naive_tokens: mean(.naive_tokens), best_tokens: mean(.best_tokens), cartog_tokens: mean(.cartog_tokens),
+        naive_recall: mean(.naive_recall), best_recall: mean(.best_recall), cartog_recall: mean(.cartog_recall),
+        naive_ms: mean(.naive_ms), best_ms: mean(.best_ms), cartog_ms: mean(.cartog_ms)
+    } | .reduction = (if .naive_tokens > 0 then (1 - .cartog_tokens / .naive_tokens) * 100 else 0 end)
+      | .best_reduction = (if .best_tokens > 0 then (1 - .cartog_tokens / .best_tokens) * 100 else 0 end);
+    {
+        scenarios: (map(.scenario) | unique | length),
+        languages: (map(.lang) | unique | length),
+        overall: totals,
+        by_scenario: (group_by(.scenario) | map({scenario: .[0].scenario} + totals)),
+        by_language: (group_by(.lang) | map({lang: .[0].lang} + totals))
+    }
+' "$INPUT" > "$RESULTS_DIR/summary.json"
+
+SUMMARY="$RESULTS_DIR/summary.json"
+
+# Per tokenizer: mean tokens and reduction per scenario and overall.
+MATRIX="$RESULTS_DIR/tokenizers.json"
+rm -f "$MATRIX"
+if [ -s "$TOKENS" ]; then
+    jq -s '
+        def mean(f): if length == 0 then 0 else (map(f // 0) | add) / length end;
+        def totals: {
+            naive_tokens: mean(.naive_tokens), best_tokens: mean(.best_tokens), cartog_tokens: mean(.cartog_tokens)
+        } | .reduction = (if .naive_tokens > 0 then (1 - .cartog_tokens / .naive_tokens) * 100 else 0 end);
+        {
+            tokenizers: (map(.tokenizer) | unique),
+            overall: (group_by(.tokenizer) | map({tokenizer: .[0].tokenizer} + totals)),
+            by_scenario: (group_by(.scenario) | map({
+                scenario: .[0].scenario,
+                tokenizers: (group_by(.tokenizer) | map({tokenizer: .[0].tokenizer} + totals))
+            }))
+        }
+    ' "$TOKENS" > "$MATRIX"
+fi
+
+# ── Markdown report ──
+
+{
+    echo "# cartog vs grep/cat"
+    echo ""
+    jq -r '"\(.overall.questions) questions: \(.scenarios) scenarios across \(.languages) fixtures. Tokens, recall and wall time are means per question."' "$SUMMARY"
+    echo ""
+    echo "| Scenario | grep/cat tokens | cartog tokens | Reduction | grep/cat recall | cartog recall | grep/cat ms | cartog ms |"
+    echo "|---|---:|---:|---:|---:|---:|---:|---:|"
+    jq -r '
+        def row(name): "| \(name) | \(.naive_tokens | round) | \(.cartog_tokens | round) | \(.reduction | round)% | \(.naive_recall | round)% | \(.cartog_recall | round)% | \(.naive_ms | round) | \(.cartog_ms | round) |";
+        (.by_scenario[] | row(.scenario)), (.overall | row("**All**"))
+    ' "$SUMMARY"
+    echo ""
+    echo "| Fixture | grep/cat tokens | cartog tokens | Reduction | grep/cat recall | cartog recall | grep/cat ms | cartog ms |"
+    echo "|---|---:|---:|---:|---:|---:|---:|---:|"
+    jq -r '.by_language[] | "| \(.lang) | \(.naive_tokens | round) | \(.cartog_tokens | round) | \(.reduction | round)% | \(.naive_recall | round)% | \(.cartog_recall | round)% | \(.naive_ms | round) | \(.cartog_ms | round) |"' "$SUMMARY"
+    if [ -s "$MATRIX" ]; then
+        echo ""
+        echo "## Tokens by tokenizer"
+        echo ""
+        echo "Mean tokens per question, grep/cat → cartog, and the reduction, counted by each tokenizer."
+        echo ""
+        jq -r '
+            def cell: "\(.naive_tokens | round) → \(.cartog_tokens | round) (\(.reduction | round)%)";
+            .tokenizers as $t
+            | "| Scenario | " + ($t | join(" | ")) + " |",
+              "|---|" + ($t | map("---:") | join("|")) + "|",
+              (.by_scenario[] | "| \(.scenario) | " + ([.tokenizers[] | cell] | join(" | ")) + " |"),
+              "| **All** | " + ([.overall[] | cell] | join(" | ")) + " |"
+        ' "$MATRIX"
+    fi
+} > "$RESULTS_DIR/report.md"
+
+# ── HTML report ──
+
+# The template reads its data from an inline JSON block; "</" is escaped so
+# no string in it can close the <script> element.
+html_data=$(jq -nc \
+    --slurpfile summary "$SUMMARY" \
+    --argjson tokenizers "$( [ -s "$MATRIX" ] && cat "$MATRIX" || echo null )" \
+    --argjson bench "$( [ -s "$BENCH" ] && cat "$BENCH" || echo null )" \
+    '{summary: $summary[0], tokenizers: $tokenizers, bench: $bench}' | sed 's#</#<\\/#g')
+DATA="$html_data" awk '
+    $0 == "__CARTOG_BENCH_DATA__" { print ENVIRON["DATA"]; next }
+    { print }
+' "$BENCH_DIR/lib/report.html" > "$RESULTS_DIR/report.html"
+
+# ── Terminal summary ──
+
+jq -r '.overall |
+    "  Tokens per question:  grep/cat=\(.naive_tokens | round)  best grep=\(.best_tokens | round)  cartog=\(.cartog_tokens | round)",
+    "  Token reduction vs grep/cat: \(.reduction * 10 | round / 10)%",
+    "  Token reduction vs best:     \(.best_reduction * 10 | round / 10)%",
+    "",
+    "  Avg recall:  grep/cat=\(.naive_recall * 10 | round / 10)%  best=\(.best_recall * 10 | round / 10)%  cartog=\(.cartog_recall * 10 | round / 10)%",
+    "  Wall time per question:  grep/cat=\(.naive_ms | round) ms  best=\(.best_ms | round) ms  cartog=\(.cartog_ms | round) ms"
+' "$SUMMARY"
+if [ -s "$MATRIX" ]; then
+    echo ""
+    jq -r '.overall[] | "  \(.tokenizer | . + ":" | .[0:8])  grep/cat=\(.naive_tokens | round)  cartog=\(.cartog_tokens | round)  reduction=\(.reduction * 10 | round / 10)%"' "$MATRIX"
+fi
+echo ""
+echo "  Report: $RESULTS_DIR/report.md"
+echo "          $RESULTS_DIR/report.html"
+
+# ── README table ──
+
+if [ "$UPDATE_README" = true ]; then
+    readme="$PROJECT_ROOT/README.md"
+    if ! grep -q "$BEGIN_MARKER" "$readme" || ! grep -q "$END_MARKER" "$readme"; then
+        echo "README.md has no $BEGIN_MARKER ... $END_MARKER section" >&2
+        exit 1
+    fi
+    table=$(jq -r '
+        def commas: tostring | if length > 3 then (.[:-3] | commas) + "," + .[-3:] else . end;
+        def tens: (. / 10 | round) * 10 | commas;
+        (.overall) as $o
+        | ([.by_scenario[] | select(.naive_tokens > 0)] | sort_by(-.reduction) | .[:3]) as $top
+        | "| | grep/cat workflow | cartog |",
+          "|---|---|---|",
+          "| **Tokens per query** | ~\($o.naive_tokens | tens) | ~\($o.cartog_tokens | tens) (**\($o.reduction | round)% fewer**) |",
+          "| **Recall** (completeness) | \($o.naive_recall | round)% | \($o.cartog_recall | round)% |",
+          "| **Wall time per question** | \($o.naive_ms | round) ms | \($o.cartog_ms | round) ms |",
+          "| **Privacy** | n/a | **100% local** — no remote calls |",
+          "| **Transitive analysis** | impossible | `impact --depth 3` traces callers-of-callers |",
+          "",
+          "Largest token reductions: " + ([$top[] | "\(.scenario | sub("^[0-9]+_"; "") | gsub("_"; " ")) (\(.reduction | round)%, grep recall \(.naive_recall | round)% vs \(.cartog_recall | round)% cartog)"] | join(", ")) + ".",
+          "",
+          "Measured across \(.scenarios) scenarios, \(.languages) languages by `benchmarks/run.sh` ([full benchmark suite](benchmarks/))."
+    ' "$SUMMARY")
+    tmp="$readme.tmp.$$"
+    TABLE="$table" awk -v begin="$BEGIN_MARKER" -v end="$END_MARKER" '
+        $0 == begin { print; print ENVIRON["TABLE"]; skip = 1; next }
+        $0 == end   { skip = 0 }
+        !skip
+    ' "$readme" > "$tmp"
+    mv "$tmp" "$readme"
+    echo "  README.md comparison table updated"
+fi
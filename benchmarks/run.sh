@@ -12,6 +12,13 @@
 #   ./benchmarks/run.sh --fixture go     # Run only Go fixtures
 #   ./benchmarks/run.sh --fixture rs     # Run only Rust fixtures
 #   ./benchmarks/run.sh --fixture rb     # Run only Ruby fixtures
+#   ./benchmarks/run.sh --update-readme  # Also refresh the README comparison table
+#   ./benchmarks/run.sh --tokenizers approx,o200k,claude  # Token matrix (see tokenize.py)
+#   ./benchmarks/run.sh --bench          # Also run `cartog bench` (latency percentiles, index size)
+#
+# Each question is answered twice, through cartog and through grep/cat, timing
+# both; report.sh turns the results into results/report.md and a self-contained
+# results/report.html with charts.
 
 set -euo pipefail
 
@@ -21,6 +28,8 @@ BENCH_DIR="$(cd "$(dirname "$0")" && pwd)"
 PROJECT_ROOT="$(cd "$BENCH_DIR/.." && pwd)"
 RESULTS_DIR="$BENCH_DIR/results"
 RESULTS_FILE="$RESULTS_DIR/latest.jsonl"
+TOKENS_FILE="$RESULTS_DIR/tokenizers.jsonl"
+BENCH_FILE="$RESULTS_DIR/bench.json"
 
 # Colors
 RED='\033[0;31m'
@@ -33,12 +42,18 @@ NC='\033[0m'
 # Parse args
 SCENARIO_FILTER=""
 export FIXTURE_FILTER=""
+REPORT_ARGS=()
+TOKENIZERS=""
+RUN_BENCH=false
 while [[ $# -gt 0 ]]; do
     case $1 in
         --scenario) SCENARIO_FILTER="$2"; shift 2 ;;
         --fixture)  FIXTURE_FILTER="$2"; shift 2 ;;
+        --update-readme) REPORT_ARGS+=(--update-readme); shift ;;
+        --tokenizers) TOKENIZERS="$2"; shift 2 ;;
+        --bench) RUN_BENCH=true; shift ;;
         -h|--help)
-            echo "Usage: $0 [--scenario NN] [--fixture py|ts|go|rs|rb]"
+            echo "Usage: $0 [--scenario NN] [--fixture py|ts|go|rs|rb] [--update-readme] [--tokenizers LIST] [--bench]"
             exit 0
             ;;
         *) echo "Unknown option: $1"; exit 1 ;;
@@ -98,6 +113,14 @@ echo ""
 # Clear results
 mkdir -p "$RESULTS_DIR"
 > "$RESULTS_FILE"
+rm -f "$TOKENS_FILE" "$BENCH_FILE"
+
+# With --tokenizers, scenarios save their raw outputs for tokenize.py
+if [ -n "$TOKENIZERS" ]; then
+    export OUTPUTS_DIR="$RESULTS_DIR/outputs"
+    rm -rf "$OUTPUTS_DIR"
+    mkdir -p "$OUTPUTS_DIR"
+fi
 
 echo -e "${BOLD}Running scenarios...${NC}"
 printf "  ${BOLD}%-22s | %-27s | %-27s | %-27s | %s${NC}\n" \
@@ -118,60 +141,33 @@ done
 
 echo ""
 
+if [ -n "$TOKENIZERS" ]; then
+    echo -e "${BOLD}Counting tokens...${NC}"
+    python3 "$BENCH_DIR/tokenize.py" "$OUTPUTS_DIR" --tokenizers "$TOKENIZERS" --out "$TOKENS_FILE"
+    echo ""
+fi
+
+if [ "$RUN_BENCH" = true ]; then
+    echo -e "${BOLD}Running cartog bench...${NC}"
+    bench_args=(--fixtures-dir "$BENCH_DIR/fixtures")
+    [ -n "$FIXTURE_FILTER" ] && bench_args+=(--fixture "$FIXTURE_FILTER")
+    if "$CARTOG" --json bench "${bench_args[@]}" > "$BENCH_FILE"; then
+        echo "  Latency and index size: $BENCH_FILE"
+    else
+        echo -e "${YELLOW}  cartog bench failed; the report will not chart latency${NC}"
+        rm -f "$BENCH_FILE"
+    fi
+    echo ""
+fi
+
 # ── Summary ──
 
 echo -e "${BOLD}=== Summary ===${NC}"
 
 if [ -s "$RESULTS_FILE" ] && command -v jq &>/dev/null; then
-    total_naive=0
-    total_best=0
-    total_cartog=0
-    total_naive_recall=0
-    total_best_recall=0
-    total_cartog_recall=0
-    count=0
-
-    while IFS= read -r line; do
-        [ -z "$line" ] && continue
-        nt=$(echo "$line" | jq -r '.naive_tokens // 0')
-        bt=$(echo "$line" | jq -r '.best_tokens // 0')
-        ct=$(echo "$line" | jq -r '.cartog_tokens // 0')
-        nr=$(echo "$line" | jq -r '.naive_recall // 0')
-        br=$(echo "$line" | jq -r '.best_recall // 0')
-        cr=$(echo "$line" | jq -r '.cartog_recall // 0')
-
-        total_naive=$((total_naive + nt))
-        total_best=$((total_best + bt))
-        total_cartog=$((total_cartog + ct))
-        total_naive_recall=$(awk "BEGIN { print $total_naive_recall + $nr }")
-        total_best_recall=$(awk "BEGIN { print $total_best_recall + $br }")
-        total_cartog_recall=$(awk "BEGIN { print $total_cartog_recall + $cr }")
-        count=$((count + 1))
-    done < "$RESULTS_FILE"
-
-    if [ "$count" -gt 0 ]; then
-        avg_naive_recall=$(awk "BEGIN { printf \"%.1f\", $total_naive_recall / $count }")
-        avg_best_recall=$(awk "BEGIN { printf \"%.1f\", $total_best_recall / $count }")
-        avg_cartog_recall=$(awk "BEGIN { printf \"%.1f\", $total_cartog_recall / $count }")
-
-        naive_reduction="n/a"
-        best_reduction="n/a"
-        if [ "$total_naive" -gt 0 ]; then
-            naive_reduction=$(awk "BEGIN { printf \"%.1f\", (1 - $total_cartog / $total_naive) * 100 }")
-        fi
-        if [ "$total_best" -gt 0 ]; then
-            best_reduction=$(awk "BEGIN { printf \"%.1f\", (1 - $total_cartog / $total_best) * 100 }")
-        fi
-
-        echo ""
-        echo "  Total tokens:  naive=$total_naive  best=$total_best  cartog=$total_cartog"
-        echo "  Token reduction vs naive: ${naive_reduction}%"
-        echo "  Token reduction vs best:  ${best_reduction}%"
-        echo ""
-        echo "  Avg recall:  naive=${avg_naive_recall}%  best=${avg_best_recall}%  cartog=${avg_cartog_recall}%"
-        echo ""
-        echo "  Results saved to: $RESULTS_FILE"
-    fi
+    echo ""
+    "$BENCH_DIR/report.sh" --input "$RESULTS_FILE" "${REPORT_ARGS[@]+"${REPORT_ARGS[@]}"}"
+    echo "  Results saved to: $RESULTS_FILE"
 else
     echo "  No results collected. Check that cartog is installed and fixtures are indexed."
 fi
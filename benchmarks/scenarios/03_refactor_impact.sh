@@ -49,6 +49,8 @@ run_scenario() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
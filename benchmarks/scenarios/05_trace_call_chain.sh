@@ -23,27 +23,33 @@ run_scenario() {
     echo -e "  ${CYAN}[$fixture_name]${NC} Trace call chain from $entry_fn" >&2
 
     # ── Naive grep: search for each function name in sequence ──
-    local out1 out2 out3
+    local out1 out2 out3 start
+    start=$(now_ms)
     out1=$(cd "$fixture_dir" && grep -rn "$entry_fn" . 2>/dev/null || true)
     out2=$(cd "$fixture_dir" && grep -rn "$mid_fn" . 2>/dev/null || true)
     out3=$(cd "$fixture_dir" && grep -rn "$leaf_fn" . 2>/dev/null || true)
+    NAIVE_MS=$(( $(now_ms) - start ))
     local naive_out="${out1}${out2}${out3}"
     local naive_tok=$(count_tokens "$naive_out")
     local naive_cmds=3
 
     # ── Best-effort grep: targeted search for call sites ──
+    start=$(now_ms)
     out1=$(cd "$fixture_dir" && grep -rn "${entry_fn}\|${mid_fn}(" . 2>/dev/null | grep -v "def \|fn " || true)
     out2=$(cd "$fixture_dir" && grep -rn "${mid_fn}\|${leaf_fn}(" . 2>/dev/null | grep -v "def \|fn " || true)
     out3=$(cd "$fixture_dir" && grep -rn "${leaf_fn}(" . 2>/dev/null | grep -v "def \|fn " || true)
+    BEST_MS=$(( $(now_ms) - start ))
     local best_out="${out1}${out2}${out3}"
     local best_tok=$(count_tokens "$best_out")
     local best_cmds=3
 
     # ── Cartog: sequential callees (human-readable for tokens) ──
     local c1 c2 c3 cj1 cj2 cj3
+    start=$(now_ms)
     c1=$(cd "$fixture_dir" && $CARTOG callees "$entry_fn" 2>/dev/null || true)
     c2=$(cd "$fixture_dir" && $CARTOG callees "$mid_fn" 2>/dev/null || true)
     c3=$(cd "$fixture_dir" && $CARTOG callees "$leaf_fn" 2>/dev/null || true)
+    CARTOG_MS=$(( $(now_ms) - start ))
     local cartog_out="${c1}${c2}${c3}"
     local cartog_tok=$(count_tokens "$cartog_out")
     local cartog_cmds=3
@@ -65,6 +71,8 @@ run_scenario() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
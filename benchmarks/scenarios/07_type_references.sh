@@ -50,6 +50,8 @@ run_scenario_py() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
@@ -94,6 +96,8 @@ run_scenario_rs() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
@@ -138,6 +142,8 @@ run_scenario_rb() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
@@ -182,6 +188,8 @@ run_scenario_ts() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
@@ -226,6 +234,8 @@ run_scenario_go() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
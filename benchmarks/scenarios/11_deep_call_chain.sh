@@ -26,8 +26,10 @@ run_scenario() {
     local naive_out=""
     local naive_cmds=${#chain[@]}
     for fn in "${chain[@]}"; do
-        local out
+        local out start
+        start=$(now_ms)
         out=$(cd "$fixture_dir" && grep -rn "$fn" . 2>/dev/null || true)
+        NAIVE_MS=$((NAIVE_MS + $(now_ms) - start))
         naive_out="${naive_out}${out}"
     done
     local naive_tok=$(count_tokens "$naive_out")
@@ -36,8 +38,10 @@ run_scenario() {
     local best_out=""
     local best_cmds=${#chain[@]}
     for fn in "${chain[@]}"; do
-        local out
+        local out start
+        start=$(now_ms)
         out=$(cd "$fixture_dir" && grep -rn "${fn}(" . 2>/dev/null | grep -v "def \|fn \|func \|function " || true)
+        BEST_MS=$((BEST_MS + $(now_ms) - start))
         best_out="${best_out}${out}"
     done
     local best_tok=$(count_tokens "$best_out")
@@ -47,8 +51,10 @@ run_scenario() {
     local cartog_json_out=""
     local cartog_cmds=${#chain[@]}
     for fn in "${chain[@]}"; do
-        local c cj
+        local c cj start
+        start=$(now_ms)
         c=$(cd "$fixture_dir" && $CARTOG callees "$fn" 2>/dev/null || true)
+        CARTOG_MS=$((CARTOG_MS + $(now_ms) - start))
         cj=$(cd "$fixture_dir" && $CARTOG --json callees "$fn" 2>/dev/null || true)
         cartog_out="${cartog_out}${c}"
         cartog_json_out="${cartog_json_out}${cj}"
@@ -66,6 +72,8 @@ run_scenario() {
         "$best_tok" "$best_cmds" "$best_recall" \
         "$cartog_tok" "$cartog_cmds" "$cartog_recall"
 
+    save_outputs "$SCENARIO" "$fixture_name" "$naive_out" "$best_out" "$cartog_out"
+
     emit_result_json "$SCENARIO" "$fixture_name" \
         "$naive_tok" "$naive_recall" \
         "$best_tok" "$best_recall" \
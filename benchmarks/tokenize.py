@@ -0,0 +1,146 @@
+#!/usr/bin/env python3
+"""Count benchmark outputs under several tokenizers.
+
+run.sh estimates tokens as bytes / 4. Real tokenizers disagree with that and
+with each other, and code (symbols, paths, punctuation) is where they differ
+most, so "tokens saved" depends on which model reads the output. With
+`run.sh --tokenizers`, every scenario saves the text each approach produced
+to results/outputs/<scenario>.<fixture>.<approach>.txt; this script counts
+those files and writes one JSON line per question and tokenizer:
+
+    {"scenario": "01_find_callers", "lang": "webapp_py", "tokenizer": "o200k",
+     "naive_tokens": 391, "best_tokens": 262, "cartog_tokens": 88}
+
+report.sh turns the lines into a scenario x tokenizer matrix.
+
+Tokenizers:
+    approx   bytes / 4, the estimate run.sh uses (no dependency)
+    cl100k   OpenAI cl100k_base, GPT-4 and GPT-3.5 (pip install tiktoken)
+    o200k    OpenAI o200k_base, GPT-4o and later (pip install tiktoken)
+    claude   Anthropic token counting API (needs ANTHROPIC_API_KEY; sends the
+             outputs, which are fixture code, to api.anthropic.com)
+
+A tokenizer that is not available is skipped with a warning.
+"""
+
+import argparse
+import json
+import os
+import sys
+import urllib.request
+
+APPROACHES = ("naive", "best", "cartog")
+DEFAULT_CLAUDE_MODEL = "claude-sonnet-4-5"
+
+
+def approx():
+    return lambda text: (len(text.encode()) + 3) // 4
+
+
+def tiktoken_encoding(name):
+    try:
+        import tiktoken
+    except ImportError:
+        return None
+    encoding = tiktoken.get_encoding(name)
+    return lambda text: len(encoding.encode(text, disallowed_special=()))
+
+
+def claude(model):
+    key = os.environ.get("ANTHROPIC_API_KEY")
+    if not key:
+        return None
+
+    def count_request(text):
+        body = json.dumps({"model": model, "messages": [{"role": "user", "content": text}]})
+        request = urllib.request.Request(
+            "https://api.anthropic.com/v1/messages/count_tokens",
+            data=body.encode(),
+            headers={
+                "x-api-key": key,
+                "anthropic-version": "2023-06-01",
+                "content-type": "application/json",
+            },
+        )
+        with urllib.request.urlopen(request, timeout=30) as response:
+            return json.load(response)["input_tokens"]
+
+    # The count includes the message framing; measure it once and take it off.
+    overhead = count_request(".") - 1
+    return lambda text: max(count_request(text) - overhead, 0)
+
+
+def load_tokenizers(names, claude_model):
+    loaders = {
+        "approx": approx,
+        "cl100k": lambda: tiktoken_encoding("cl100k_base"),
+        "o200k": lambda: tiktoken_encoding("o200k_base"),
+        "claude": lambda: claude(claude_model),
+    }
+    hints = {
+        "cl100k": "pip install tiktoken",
+        "o200k": "pip install tiktoken",
+        "claude": "set ANTHROPIC_API_KEY",
+    }
+    tokenizers = {}
+    for name in names:
+        if name not in loaders:
+            sys.exit(f"unknown tokenizer '{name}' (expected one of: {', '.join(loaders)})")
+        try:
+            count = loaders[name]()
+        except OSError as e:
+            print(f"  skipping tokenizer {name}: {e}", file=sys.stderr)
+            continue
+        if count is None:
+            print(f"  skipping tokenizer {name}: {hints[name]}", file=sys.stderr)
+            continue
+        tokenizers[name] = count
+    return tokenizers
+
+
+def questions(outputs_dir):
+    """(scenario, lang) pairs that have all three approach files."""
+    found = {}
+    for entry in os.listdir(outputs_dir):
+        parts = entry.rsplit(".", 3)
+        if len(parts) == 4 and parts[2] in APPROACHES and parts[3] == "txt":
+            found.setdefault((parts[0], parts[1]), set()).add(parts[2])
+    return sorted(q for q, approaches in found.items() if len(approaches) == len(APPROACHES))
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.split("\n\n")[0])
+    parser.add_argument("outputs", help="directory of saved outputs (results/outputs)")
+    parser.add_argument("--tokenizers", default="approx,cl100k,o200k,claude", help="comma-separated list")
+    parser.add_argument("--out", required=True, help="JSON lines file to write")
+    parser.add_argument("--claude-model", default=os.environ.get("CARTOG_BENCH_CLAUDE_MODEL", DEFAULT_CLAUDE_MODEL))
+    args = parser.parse_args()
+
+    names = [n.strip() for n in args.tokenizers.split(",") if n.strip()]
+    tokenizers = load_tokenizers(names, args.claude_model)
+    cache = {}
+
+    with open(args.out, "w") as out:
+        for scenario, lang in questions(args.outputs):
+            texts = {}
+            for approach in APPROACHES:
+                path = os.path.join(args.outputs, f"{scenario}.{lang}.{approach}.txt")
+                with open(path, encoding="utf-8", errors="replace") as f:
+                    texts[approach] = f.read()
+            for name, count in tokenizers.items():
+                row = {"scenario": scenario, "lang": lang, "tokenizer": name}
+                for approach, text in texts.items():
+                    if not text:
+                        row[f"{approach}_tokens"] = 0
+                        continue
+                    key = (name, text)
+                    if key not in cache:
+                        cache[key] = count(text)
+                    row[f"{approach}_tokens"] = cache[key]
+                out.write(json.dumps(row) + "\n")
+
+    print(f"  Token counts ({', '.join(tokenizers) or 'none'}): {args.out}", file=sys.stderr)
+
+
+if __name__ == "__main__":
+    main()
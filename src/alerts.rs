@@ -0,0 +1,366 @@
+//! Alerts when a change reaches a watched symbol.
+//!
+//! Configured in the root `.cartog.toml`:
+//!
+//! ```toml
+//! [alerts]
+//! symbols = ["validate_token", "charge_card"]
+//! packages = ["internal/billing/**"]
+//! depth = 3
+//! notify = true
+//!
+//! [hooks]
+//! on_watched_impact = ["./scripts/page-owner.sh"]
+//! ```
+//!
+//! After each re-index by `cartog watch` (or `serve --watch`), every symbol the
+//! run added, removed or modified is followed through its dependents, as
+//! `cartog impact` does, up to `depth` levels. Each watched symbol reached
+//! raises an alert: one named in `symbols`, or defined in a file `packages`
+//! matches. Alerts go to the `on_watched_impact` hooks and, with `notify`, to a
+//! desktop notification (`notify-send` on Linux, `osascript` on macOS).
+
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+use anyhow::{Context, Result};
+use globset::{Glob, GlobMatcher};
+use serde::{Deserialize, Serialize};
+use tracing::{debug, warn};
+
+use crate::db::Database;
+use crate::hooks::{self, HooksSection, SymbolChange};
+
+/// Dependents followed from a change when `depth` is not set.
+pub const DEFAULT_DEPTH: u32 = 3;
+
+/// `[alerts]`: what to watch and how to say it was reached.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct AlertsSection {
+    /// Symbol names to watch.
+    pub symbols: Vec<String>,
+    /// Globs of files whose symbols are all watched.
+    pub packages: Vec<String>,
+    /// Levels of dependents followed from a change.
+    pub depth: u32,
+    /// Also show a desktop notification.
+    pub notify: bool,
+}
+
+impl Default for AlertsSection {
+    fn default() -> Self {
+        Self {
+            symbols: Vec::new(),
+            packages: Vec::new(),
+            depth: DEFAULT_DEPTH,
+            notify: false,
+        }
+    }
+}
+
+impl AlertsSection {
+    pub fn is_empty(&self) -> bool {
+        self.symbols.is_empty() && self.packages.is_empty()
+    }
+}
+
+/// A watched symbol reached by a change.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Alert {
+    pub symbol: String,
+    pub file_path: String,
+    pub line: u32,
+    /// The `symbols` or `packages` entry that watches it.
+    pub watched: String,
+    /// The change that reached it.
+    pub cause: SymbolChange,
+    /// Edges from the changed symbol to this one; 0 when it changed itself.
+    pub depth: u32,
+}
+
+/// JSON sent to `on_watched_impact` hooks.
+#[derive(Debug, Serialize)]
+struct AlertPayload<'a> {
+    event: &'static str,
+    root: String,
+    alerts: &'a [Alert],
+}
+
+/// Watched symbols reached by `changes`, nearest first for each.
+pub fn find(db: &Database, alerts: &AlertsSection, changes: &[SymbolChange]) -> Result<Vec<Alert>> {
+    if alerts.is_empty() {
+        return Ok(Vec::new());
+    }
+    let packages: Vec<(&str, GlobMatcher)> = alerts
+        .packages
+        .iter()
+        .map(|p| {
+            Glob::new(p)
+                .map(|g| (p.as_str(), g.compile_matcher()))
+                .with_context(|| format!("invalid glob '{p}' in [alerts] packages"))
+        })
+        .collect::<Result<_>>()?;
+    let watched_by = |name: &str, file: &str| -> Option<String> {
+        alerts
+            .symbols
+            .iter()
+            .find(|s| *s == name)
+            .cloned()
+            .or_else(|| {
+                packages
+                    .iter()
+                    .find(|(_, m)| m.is_match(file))
+                    .map(|(p, _)| p.to_string())
+            })
+    };
+
+    let mut found: Vec<Alert> = Vec::new();
+    let mut add = |alert: Alert| {
+        let same = found.iter_mut().find(|a| {
+            (&a.symbol, &a.file_path, &a.cause.name, &a.cause.file_path)
+                == (
+                    &alert.symbol,
+                    &alert.file_path,
+                    &alert.cause.name,
+                    &alert.cause.file_path,
+                )
+        });
+        match same {
+            Some(a) if alert.depth < a.depth => *a = alert,
+            Some(_) => {}
+            None => found.push(alert),
+        }
+    };
+    for change in changes {
+        if let Some(watched) = watched_by(&change.name, &change.file_path) {
+            add(Alert {
+                symbol: change.name.clone(),
+                file_path: change.file_path.clone(),
+                line: change.line,
+                watched,
+                cause: change.clone(),
+                depth: 0,
+            });
+        }
+        if alerts.depth == 0 {
+            continue;
+        }
+        for (edge, depth) in db.impact(&change.name, alerts.depth)? {
+            let Some(source) = db.get_symbol(&edge.source_id)? else {
+                continue;
+            };
+            if let Some(watched) = watched_by(&source.name, &source.file_path) {
+                add(Alert {
+                    symbol: source.name,
+                    file_path: source.file_path,
+                    line: source.start_line,
+                    watched,
+                    cause: change.clone(),
+                    depth,
+                });
+            }
+        }
+    }
+    Ok(found)
+}
+
+/// Run the `on_watched_impact` hooks and show the notification for `found`.
+pub fn raise(hooks: &HooksSection, config: &AlertsSection, root: &Path, found: &[Alert]) {
+    if found.is_empty() {
+        return;
+    }
+    for alert in found {
+        warn!(
+            symbol = %alert.symbol,
+            file = %alert.file_path,
+            cause = %alert.cause.name,
+            depth = alert.depth,
+            "change reaches watched symbol"
+        );
+    }
+    if !hooks.on_watched_impact.is_empty() {
+        let payload = AlertPayload {
+            event: "watched_impact",
+            root: root.to_string_lossy().into_owned(),
+            alerts: found,
+        };
+        match serde_json::to_string(&payload) {
+            Ok(body) => hooks::run_all(&hooks.on_watched_impact, payload.event, &body, root),
+            Err(e) => warn!(error = %e, "failed to serialize alert payload"),
+        }
+    }
+    if config.notify {
+        if let Err(e) = notify(found) {
+            warn!(error = %format!("{e:#}"), "desktop notification failed");
+        }
+    }
+}
+
+/// Title and body of the notification for `found`.
+fn summary(found: &[Alert]) -> (String, String) {
+    let title = match found.len() {
+        1 => format!("cartog: {} is affected", found[0].symbol),
+        n => format!("cartog: {n} watched symbols affected"),
+    };
+    let body: Vec<String> = found
+        .iter()
+        .map(|a| {
+            if a.depth == 0 {
+                format!("{} was {}", a.symbol, change_verb(a))
+            } else {
+                format!(
+                    "{} ← {} {} ({})",
+                    a.symbol,
+                    a.cause.name,
+                    change_verb(a),
+                    a.cause.file_path
+                )
+            }
+        })
+        .collect();
+    (title, body.join("\n"))
+}
+
+fn change_verb(alert: &Alert) -> &'static str {
+    match alert.cause.change {
+        hooks::ChangeKind::Added => "added",
+        hooks::ChangeKind::Removed => "removed",
+        hooks::ChangeKind::Modified => "modified",
+    }
+}
+
+fn notify(found: &[Alert]) -> Result<()> {
+    if !crate::capabilities::shell_allowed() {
+        anyhow::bail!("notifications are forbidden by the server's capabilities");
+    }
+    let (title, body) = summary(found);
+    let mut command = if cfg!(target_os = "macos") {
+        let quote = |s: &str| s.replace('\\', "\\\\").replace('"', "\\\"");
+        let mut c = Command::new("osascript");
+        c.arg("-e").arg(format!(
+            "display notification \"{}\" with title \"{}\"",
+            quote(&body),
+            quote(&title)
+        ));
+        c
+    } else if cfg!(unix) {
+        let mut c = Command::new("notify-send");
+        c.arg(&title).arg(&body);
+        c
+    } else {
+        debug!("no desktop notifier on this platform");
+        return Ok(());
+    };
+    let status = command
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status()
+        .context("failed to start the desktop notifier")?;
+    anyhow::ensure!(status.success(), "desktop notifier exited with {status}");
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::hooks::ChangeKind;
+    use crate::types::{Edge, EdgeKind, Symbol, SymbolKind};
+
+    #[test]
+    fn test_changes_reaching_watched_symbols() {
+        let db = Database::open_memory().unwrap();
+        let parse = Symbol::new(
+            "parse_claims",
+            SymbolKind::Function,
+            "auth/jwt.py",
+            1,
+            5,
+            0,
+            10,
+        );
+        let validate = Symbol::new(
+            "validate_token",
+            SymbolKind::Function,
+            "auth/tokens.py",
+            1,
+            5,
+            0,
+            10,
+        );
+        let charge = Symbol::new(
+            "charge",
+            SymbolKind::Function,
+            "billing/pay.py",
+            1,
+            5,
+            0,
+            10,
+        );
+        for s in [&parse, &validate, &charge] {
+            db.insert_symbol(s).unwrap();
+        }
+        db.insert_edges(&[
+            Edge::new(
+                validate.id.clone(),
+                "parse_claims",
+                EdgeKind::Calls,
+                "auth/tokens.py",
+                2,
+            ),
+            Edge::new(
+                charge.id.clone(),
+                "validate_token",
+                EdgeKind::Calls,
+                "billing/pay.py",
+                3,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let config = AlertsSection {
+            symbols: vec!["validate_token".into()],
+            packages: vec!["billing/**".into()],
+            ..AlertsSection::default()
+        };
+        let change = SymbolChange {
+            change: ChangeKind::Modified,
+            name: "parse_claims".into(),
+            kind: SymbolKind::Function,
+            file_path: "auth/jwt.py".into(),
+            line: 1,
+        };
+        let found = find(&db, &config, std::slice::from_ref(&change)).unwrap();
+        let got: Vec<(&str, &str, u32)> = found
+            .iter()
+            .map(|a| (a.symbol.as_str(), a.watched.as_str(), a.depth))
+            .collect();
+        assert_eq!(
+            got,
+            [
+                ("validate_token", "validate_token", 1),
+                ("charge", "billing/**", 2)
+            ]
+        );
+
+        let shallow = AlertsSection {
+            depth: 1,
+            ..config.clone()
+        };
+        assert_eq!(
+            find(&db, &shallow, std::slice::from_ref(&change))
+                .unwrap()
+                .len(),
+            1
+        );
+
+        let (title, body) = summary(&found);
+        assert_eq!(title, "cartog: 2 watched symbols affected");
+        assert!(body.starts_with("validate_token ← parse_claims modified (auth/jwt.py)"));
+
+        assert!(find(&db, &AlertsSection::default(), &[change])
+            .unwrap()
+            .is_empty());
+    }
+}
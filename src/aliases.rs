@@ -0,0 +1,151 @@
+//! Command aliases from `[aliases]`.
+//!
+//! ```toml
+//! [aliases]
+//! imp = "impact --depth 2"
+//! calls = "refs --kind calls --json"
+//! ```
+//!
+//! `cartog imp validate_token` runs `cartog impact --depth 2 validate_token`:
+//! the alias in command position is replaced by its words, and the arguments
+//! after it follow, so they can add arguments and flags. Words are split on
+//! whitespace; single or double quotes keep spaces in one word. A built-in
+//! command always wins over an alias of the same name, and an alias cannot
+//! expand to another alias.
+//!
+//! Aliases come with the repository's `.cartog.toml`, so they may not grant
+//! trust: flags like `--allow-hooks` must be typed on the command line.
+
+use std::ffi::OsString;
+
+use anyhow::{bail, Result};
+
+/// Index of the command word in `args` (program name first): the first
+/// argument not starting with `-`, since global flags take no value.
+pub fn command_position(args: &[OsString]) -> Option<usize> {
+    args.iter()
+        .enumerate()
+        .skip(1)
+        .find(|(_, arg)| !arg.to_string_lossy().starts_with('-'))
+        .map(|(i, _)| i)
+}
+
+/// Flags an alias may not contain, since they grant trust the repository's
+/// config should not hand itself.
+const TRUST_FLAGS: &[&str] = &["--allow-hooks"];
+
+/// `args` with the word at `at` replaced by the words of `definition`.
+pub fn expand(args: &[OsString], at: usize, definition: &str) -> Result<Vec<OsString>> {
+    let words = words(definition)?;
+    if words.is_empty() {
+        bail!("alias '{}' is empty", args[at].to_string_lossy());
+    }
+    let mut expanded = args[..at].to_vec();
+    expanded.extend(words.into_iter().map(OsString::from));
+    expanded.extend_from_slice(&args[at + 1..]);
+    Ok(expanded)
+}
+
+/// The words of an alias definition, refusing the [`TRUST_FLAGS`].
+pub fn words(definition: &str) -> Result<Vec<String>> {
+    let words = split(definition)?;
+    let trusting = words.iter().find(|word| {
+        TRUST_FLAGS.iter().any(|flag| {
+            word.strip_prefix(flag)
+                .is_some_and(|rest| rest.is_empty() || rest.starts_with('='))
+        })
+    });
+    if let Some(flag) = trusting {
+        bail!("{flag} cannot come from an alias; pass it on the command line");
+    }
+    Ok(words)
+}
+
+/// Split an alias definition into words, as a shell would without expansions.
+pub fn split(definition: &str) -> Result<Vec<String>> {
+    let mut words = Vec::new();
+    let mut word: Option<String> = None;
+    let mut quote: Option<char> = None;
+    for c in definition.chars() {
+        match (quote, c) {
+            (Some(q), c) if c == q => quote = None,
+            (Some(_), c) => word.get_or_insert_with(String::new).push(c),
+            (None, '"' | '\'') => {
+                quote = Some(c);
+                word.get_or_insert_with(String::new);
+            }
+            (None, c) if c.is_whitespace() => words.extend(word.take()),
+            (None, c) => word.get_or_insert_with(String::new).push(c),
+        }
+    }
+    if let Some(q) = quote {
+        bail!("unterminated {q} in '{definition}'");
+    }
+    words.extend(word);
+    Ok(words)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn args(words: &[&str]) -> Vec<OsString> {
+        words.iter().map(OsString::from).collect()
+    }
+
+    #[test]
+    fn test_expand_alias_in_command_position() {
+        let argv = args(&[
+            "cartog",
+            "--json",
+            "imp",
+            "validate_token",
+            "--kind",
+            "calls",
+        ]);
+        let at = command_position(&argv).unwrap();
+        assert_eq!(at, 2);
+        assert_eq!(
+            expand(&argv, at, "impact --depth 2").unwrap(),
+            args(&[
+                "cartog",
+                "--json",
+                "impact",
+                "--depth",
+                "2",
+                "validate_token",
+                "--kind",
+                "calls"
+            ])
+        );
+        assert_eq!(command_position(&args(&["cartog", "--json"])), None);
+
+        assert_eq!(
+            split(r#"rag search "error handling" --kind ''"#).unwrap(),
+            ["rag", "search", "error handling", "--kind", ""]
+        );
+        assert!(split("search 'oops").is_err());
+        assert!(expand(&argv, at, "  ").is_err());
+    }
+
+    #[test]
+    fn test_alias_cannot_allow_hooks() {
+        let argv = args(&["cartog", "reindex"]);
+        for definition in [
+            "index . --allow-hooks",
+            "--allow-hooks index .",
+            "index . '--allow-hooks'",
+            "index . --allow-hooks=true",
+        ] {
+            let err = expand(&argv, 1, definition).unwrap_err();
+            assert!(
+                err.to_string().contains("--allow-hooks"),
+                "{definition}: {err}"
+            );
+        }
+        assert_eq!(
+            expand(&argv, 1, "index . --allow-hooksy").unwrap(),
+            args(&["cartog", "index", ".", "--allow-hooksy"])
+        );
+    }
+}
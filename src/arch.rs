@@ -0,0 +1,117 @@
+//! Architecture rules: check indexed edges against the `[[arch.rules]]` boundaries
+//! declared in `.cartog.toml`.
+//!
+//! Each rule names the files it constrains (`from`) and what they may not depend on
+//! (`deny`) or may only depend on (`allow`). Resolved edges are checked on the file
+//! of their target; unresolved imports on their module path, against `deny` only.
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::config::{Breach, ProjectConfig};
+use crate::db::Database;
+use crate::types::EdgeKind;
+
+/// An edge that crosses a declared boundary.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Violation {
+    /// The rule's `name`, or `<dir>/.cartog.toml#<n>` (1-based) when it has none.
+    pub rule: String,
+    pub breach: Breach,
+    pub kind: EdgeKind,
+    /// Name of the symbol the edge starts from.
+    pub source: String,
+    pub file: String,
+    pub line: u32,
+    /// The name as written at the use site.
+    pub target: String,
+    /// `None` for unresolved (e.g. third-party) imports.
+    pub target_file: Option<String>,
+}
+
+/// Every edge in the index that breaks a rule, ordered by file and line.
+///
+/// Edges within one file never count: a boundary is between files.
+pub fn check(db: &Database, config: &ProjectConfig) -> Result<Vec<Violation>> {
+    let mut violations = Vec::new();
+    if !config.has_arch_rules() {
+        return Ok(violations);
+    }
+    for (edge, source, target_file) in db.edges_with_endpoints()? {
+        if target_file.as_deref() == Some(edge.file_path.as_str()) {
+            continue;
+        }
+        let module = (target_file.is_none() && edge.kind == EdgeKind::Imports)
+            .then_some(edge.target_name.as_str());
+        for (rule, breach) in
+            config.arch_breaches(&edge.file_path, target_file.as_deref(), module, edge.kind)
+        {
+            violations.push(Violation {
+                rule: rule.to_string(),
+                breach,
+                kind: edge.kind,
+                source: source.clone(),
+                file: edge.file_path.clone(),
+                line: edge.line,
+                target: edge.target_name.clone(),
+                target_file: target_file.clone(),
+            });
+        }
+    }
+    Ok(violations)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::ConfigFile;
+    use crate::types::{Edge, Symbol, SymbolKind};
+
+    #[test]
+    fn test_check_reports_offending_edges() {
+        let db = Database::open_memory().unwrap();
+        let sym = |name: &str, kind, file: &str, line: u32| {
+            Symbol::new(name, kind, file, line, line + 5, 0, 100)
+        };
+        let model = "app/models/user.py";
+        let user = sym("User", SymbolKind::Class, model, 3);
+        let save = sym("save", SymbolKind::Method, model, 10);
+        let audit = sym("audit", SymbolKind::Function, "app/services/log.py", 1);
+        db.insert_symbols(&[user.clone(), save.clone(), audit])
+            .unwrap();
+        let edge = |source: &Symbol, target: &str, kind, line| {
+            Edge::new(&source.id, target, kind, model, line)
+        };
+        db.insert_edges(&[
+            edge(&save, "audit", EdgeKind::Calls, 12),
+            edge(&save, "User", EdgeKind::References, 13),
+            edge(&user, "app.services.mail", EdgeKind::Imports, 1),
+            edge(&user, "requests", EdgeKind::Imports, 2),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let raw = r#"
+            [[arch.rules]]
+            from = ["app/models/**"]
+            deny = ["app/services/**"]
+        "#;
+        let config =
+            ProjectConfig::from_files(vec![(String::new(), ConfigFile::parse(raw).unwrap())])
+                .unwrap();
+        let violations = check(&db, &config).unwrap();
+        let found: Vec<_> = violations
+            .iter()
+            .map(|v| (v.line, v.target.as_str(), v.target_file.as_deref()))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (1, "app.services.mail", None),
+                (12, "audit", Some("app/services/log.py")),
+            ]
+        );
+        assert_eq!(violations[0].rule, ".cartog.toml#1");
+        assert_eq!(violations[1].source, "save");
+    }
+}
@@ -0,0 +1,193 @@
+//! Audit log of the queries `cartog serve` answers.
+//!
+//! With `--audit FILE`, every tool call is appended to FILE as one JSON line:
+//! when, which session and client, the tool and its arguments, whether it
+//! succeeded, and how big the result was in bytes and estimated tokens. Once the
+//! file would grow past its size limit it is rotated like logrotate does: FILE
+//! becomes FILE.1, FILE.1 becomes FILE.2, and the oldest beyond `keep` is removed.
+
+use std::fs::{File, OpenOptions};
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::Mutex;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::git;
+
+/// One answered tool call.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct AuditEntry {
+    /// UTC time the call finished, RFC 3339.
+    pub at: String,
+    pub session: u64,
+    /// Name of the client's token, when it presented one.
+    pub client: Option<String>,
+    pub tool: String,
+    pub args: Value,
+    pub ok: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    /// Bytes of the result sent back.
+    pub result_bytes: usize,
+    /// Estimated tokens of the result, as charged to session budgets.
+    pub tokens: u32,
+    pub duration_ms: f64,
+}
+
+#[derive(Debug)]
+struct Output {
+    file: File,
+    size: u64,
+}
+
+#[derive(Debug)]
+pub struct AuditLog {
+    path: PathBuf,
+    max_bytes: u64,
+    keep: u32,
+    output: Mutex<Option<Output>>,
+}
+
+impl AuditLog {
+    /// Append to `path`, rotating it once it would exceed `max_bytes` and keeping
+    /// `keep` older files.
+    pub fn open(path: &Path, max_bytes: u64, keep: u32) -> Result<Self> {
+        let log = Self {
+            path: path.to_path_buf(),
+            max_bytes: max_bytes.max(1),
+            keep,
+            output: Mutex::new(None),
+        };
+        *log.output.lock().unwrap_or_else(|e| e.into_inner()) = Some(log.open_file()?);
+        Ok(log)
+    }
+
+    fn open_file(&self) -> Result<Output> {
+        if let Some(dir) = self.path.parent().filter(|d| !d.as_os_str().is_empty()) {
+            std::fs::create_dir_all(dir)
+                .with_context(|| format!("cannot create {}", dir.display()))?;
+        }
+        let file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&self.path)
+            .with_context(|| format!("cannot open audit log {}", self.path.display()))?;
+        let size = file.metadata()?.len();
+        Ok(Output { file, size })
+    }
+
+    fn rotated(&self, n: u32) -> PathBuf {
+        let mut name = self.path.clone().into_os_string();
+        name.push(format!(".{n}"));
+        PathBuf::from(name)
+    }
+
+    /// Shift FILE.n to FILE.n+1, dropping the oldest, then FILE to FILE.1.
+    fn rotate(&self) -> Result<()> {
+        if self.keep == 0 {
+            std::fs::remove_file(&self.path)?;
+            return Ok(());
+        }
+        let _ = std::fs::remove_file(self.rotated(self.keep));
+        for n in (1..self.keep).rev() {
+            let from = self.rotated(n);
+            if from.exists() {
+                std::fs::rename(&from, self.rotated(n + 1))?;
+            }
+        }
+        std::fs::rename(&self.path, self.rotated(1))?;
+        Ok(())
+    }
+
+    /// Append `entry`, rotating first if it would overflow the file.
+    pub fn record(&self, entry: &AuditEntry) -> Result<()> {
+        let mut line = serde_json::to_string(entry)?;
+        line.push('\n');
+        let mut output = self.output.lock().unwrap_or_else(|e| e.into_inner());
+        let full = output.as_ref().map_or(true, |o| {
+            o.size > 0 && o.size + line.len() as u64 > self.max_bytes
+        });
+        if full {
+            if output.take().is_some() {
+                self.rotate()
+                    .with_context(|| format!("cannot rotate {}", self.path.display()))?;
+            }
+            *output = Some(self.open_file()?);
+        }
+        let Some(out) = output.as_mut() else {
+            return Ok(());
+        };
+        out.file.write_all(line.as_bytes())?;
+        out.size += line.len() as u64;
+        Ok(())
+    }
+}
+
+/// The current UTC time, RFC 3339 to the second.
+pub fn now_rfc3339() -> String {
+    let secs = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |d| d.as_secs() as i64);
+    format_rfc3339(secs)
+}
+
+fn format_rfc3339(secs: i64) -> String {
+    let time = secs.rem_euclid(86_400);
+    format!(
+        "{}T{:02}:{:02}:{:02}Z",
+        git::format_epoch_date(secs),
+        time / 3600,
+        time % 3600 / 60,
+        time % 60
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(tool: &str) -> AuditEntry {
+        AuditEntry {
+            at: format_rfc3339(1_700_000_000),
+            session: 1,
+            client: Some("ci".to_string()),
+            tool: tool.to_string(),
+            args: serde_json::json!({ "name": "validate_token" }),
+            ok: true,
+            error: None,
+            result_bytes: 120,
+            tokens: 30,
+            duration_ms: 1.5,
+        }
+    }
+
+    #[test]
+    fn test_audit_log_rotates() {
+        let dir = std::env::temp_dir().join(format!("cartog-audit-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        let path = dir.join("audit/queries.jsonl");
+        let line_len = serde_json::to_string(&entry("cartog_refs")).unwrap().len() as u64 + 1;
+        // Room for two lines per file, and one rotated file kept.
+        let log = AuditLog::open(&path, line_len * 2, 1).unwrap();
+        for _ in 0..5 {
+            log.record(&entry("cartog_refs")).unwrap();
+        }
+
+        let current = std::fs::read_to_string(&path).unwrap();
+        assert_eq!(current.lines().count(), 1);
+        let previous = std::fs::read_to_string(dir.join("audit/queries.jsonl.1")).unwrap();
+        assert_eq!(previous.lines().count(), 2);
+        assert!(!dir.join("audit/queries.jsonl.2").exists());
+
+        let logged: Value = serde_json::from_str(current.lines().next().unwrap()).unwrap();
+        assert_eq!(logged["at"], "2023-11-14T22:13:20Z");
+        assert_eq!(logged["client"], "ci");
+        assert_eq!(logged["args"]["name"], "validate_token");
+        assert!(logged.get("error").is_none());
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
@@ -0,0 +1,214 @@
+//! Bearer tokens for `cartog serve --listen`.
+//!
+//! A tokens file holds one token per line: the secret, its access level (`read`
+//! or `admin`), an optional name used in logs and rate limits, and optional
+//! `qps=N` and `concurrent=N` limits overriding the server's. Blank lines and `#`
+//! comments are skipped. A client presents its token on a first line,
+//! `Authorization: Bearer <token>`, before the MCP stream starts. Read tokens may
+//! call every query tool; only admin tokens may rebuild the index.
+
+use std::path::Path;
+use std::str::FromStr;
+
+use anyhow::{bail, Context, Result};
+use serde::Serialize;
+
+use crate::ratelimit::Limits;
+
+/// What a client may do. Admin includes read.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Access {
+    Read,
+    /// Stdio clients and unauthenticated servers have full access.
+    #[default]
+    Admin,
+}
+
+impl Access {
+    pub fn allows(self, needed: Access) -> bool {
+        self >= needed
+    }
+}
+
+impl FromStr for Access {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "read" => Ok(Self::Read),
+            "admin" => Ok(Self::Admin),
+            _ => bail!("unknown access '{s}', expected read or admin"),
+        }
+    }
+}
+
+/// Who a token belongs to and what it may do.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Grant {
+    pub name: String,
+    pub access: Access,
+    /// Limits set on this token; the server's apply where unset.
+    pub limits: Limits,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct Tokens {
+    grants: Vec<(String, Grant)>,
+}
+
+impl Tokens {
+    pub fn load(path: &Path) -> Result<Self> {
+        let text = std::fs::read_to_string(path)
+            .with_context(|| format!("cannot read {}", path.display()))?;
+        Self::parse(&text).with_context(|| format!("invalid {}", path.display()))
+    }
+
+    pub fn parse(text: &str) -> Result<Self> {
+        let mut grants: Vec<(String, Grant)> = Vec::new();
+        for (i, line) in text.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let fields: Vec<&str> = line.split_whitespace().collect();
+            let [secret, access, rest @ ..] = fields.as_slice() else {
+                bail!(
+                    "line {}: expected `<token> <read|admin> [name] [qps=N] [concurrent=N]`",
+                    i + 1
+                );
+            };
+            // Secrets may end in `=` padding; options only follow them.
+            let (name, options) = match rest {
+                [name, options @ ..] if !name.contains('=') => (name.to_string(), options),
+                _ => (format!("token-{}", i + 1), rest),
+            };
+            let access = access.parse().with_context(|| format!("line {}", i + 1))?;
+            let limits = parse_limits(options).with_context(|| format!("line {}", i + 1))?;
+            if grants.iter().any(|(s, _)| s == secret) {
+                bail!("line {}: duplicate token", i + 1);
+            }
+            grants.push((
+                secret.to_string(),
+                Grant {
+                    name,
+                    access,
+                    limits,
+                },
+            ));
+        }
+        if grants.is_empty() {
+            bail!("no tokens");
+        }
+        Ok(Self { grants })
+    }
+
+    /// The grant of `token`. Every secret is compared in full, so timing doesn't
+    /// tell how much of a guess matched.
+    pub fn authenticate(&self, token: &str) -> Option<&Grant> {
+        let mut found = None;
+        for (secret, grant) in &self.grants {
+            if constant_time_eq(secret.as_bytes(), token.as_bytes()) {
+                found = Some(grant);
+            }
+        }
+        found
+    }
+}
+
+fn parse_limits(options: &[&str]) -> Result<Limits> {
+    let mut limits = Limits::default();
+    for option in options {
+        let (key, value) = option.split_once('=').unwrap_or((option, ""));
+        match key {
+            "qps" => {
+                let qps: f64 = value
+                    .parse()
+                    .ok()
+                    .filter(|q: &f64| *q > 0.0)
+                    .with_context(|| format!("qps must be a positive number, not '{value}'"))?;
+                limits.qps = Some(qps);
+            }
+            "concurrent" => {
+                let concurrent: u32 =
+                    value
+                        .parse()
+                        .ok()
+                        .filter(|c: &u32| *c > 0)
+                        .with_context(|| {
+                            format!("concurrent must be a positive integer, not '{value}'")
+                        })?;
+                limits.concurrent = Some(concurrent);
+            }
+            _ => bail!("unknown option '{key}', expected qps or concurrent"),
+        }
+    }
+    Ok(limits)
+}
+
+fn constant_time_eq(a: &[u8], b: &[u8]) -> bool {
+    a.len() == b.len() && a.iter().zip(b).fold(0, |diff, (x, y)| diff | (x ^ y)) == 0
+}
+
+/// The token of an `Authorization: Bearer <token>` line; the header name is
+/// optional.
+pub fn bearer_token(line: &str) -> Option<&str> {
+    let line = line.trim();
+    let value = match line.split_once(':') {
+        Some((header, value)) if header.trim().eq_ignore_ascii_case("authorization") => {
+            value.trim()
+        }
+        _ => line,
+    };
+    let (scheme, token) = value.split_once(char::is_whitespace)?;
+    let token = token.trim();
+    (scheme.eq_ignore_ascii_case("bearer") && !token.is_empty()).then_some(token)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tokens_grant_access() {
+        let tokens = Tokens::parse(
+            "# shared dev box
+s3cr3t-ci     read   ci   qps=2 concurrent=1
+s3cr3t-ops=   admin
+",
+        )
+        .unwrap();
+        let ci = tokens.authenticate("s3cr3t-ci").unwrap();
+        assert_eq!((ci.name.as_str(), ci.access), ("ci", Access::Read));
+        assert_eq!(
+            ci.limits,
+            Limits {
+                qps: Some(2.0),
+                concurrent: Some(1)
+            }
+        );
+        let ops = tokens.authenticate("s3cr3t-ops=").unwrap();
+        assert_eq!((ops.name.as_str(), ops.access), ("token-3", Access::Admin));
+        assert_eq!(ops.limits, Limits::default());
+        assert!(tokens.authenticate("s3cr3t").is_none());
+        assert!(tokens.authenticate("").is_none());
+
+        assert!(Access::Admin.allows(Access::Read));
+        assert!(!Access::Read.allows(Access::Admin));
+
+        assert!(Tokens::parse("t read\nt admin\n").is_err());
+        assert!(Tokens::parse("t write\n").is_err());
+        assert!(Tokens::parse("t read qps=0\n").is_err());
+        assert!(Tokens::parse("t read burst=3\n").is_err());
+        assert!(Tokens::parse("# none\n").is_err());
+    }
+
+    #[test]
+    fn test_bearer_token() {
+        assert_eq!(bearer_token("Authorization: Bearer abc\r\n"), Some("abc"));
+        assert_eq!(bearer_token("bearer abc"), Some("abc"));
+        assert_eq!(bearer_token("Authorization: Basic abc"), None);
+        assert_eq!(bearer_token("Bearer "), None);
+        assert_eq!(bearer_token("{\"jsonrpc\":\"2.0\"}"), None);
+    }
+}
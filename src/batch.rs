@@ -0,0 +1,173 @@
+//! Several queries answered in one round trip, for `cartog_batch`.
+//!
+//! An agent usually needs a handful of related lookups per step: a search, then
+//! the refs and callees of what it found. A batch runs them on one connection,
+//! in the order given, written like macro steps (`{"run": "refs", "name":
+//! "validate_token"}`, see [`crate::macros`]) without placeholders. The results
+//! share dedup: a hit an earlier query already returned is left out of later
+//! ones and only counted. They also share a token budget: hits are kept in
+//! order until the next one would pass it, and every hit after that is dropped.
+
+use std::collections::HashSet;
+
+use anyhow::{bail, Result};
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::macros::{self, MacroHit, MacroStep};
+use crate::session::estimate_tokens;
+
+/// Most queries one batch may hold.
+pub const MAX_QUERIES: usize = 16;
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BatchEntry {
+    pub query: &'static str,
+    pub target: String,
+    pub hits: Vec<MacroHit>,
+    /// Hits left out because an earlier query returned them.
+    pub duplicates: usize,
+    /// Hits left out to stay within the budget.
+    pub dropped: usize,
+    /// Why the query failed; the other queries still run.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BatchResult {
+    pub results: Vec<BatchEntry>,
+    /// Estimated tokens of the hits returned.
+    pub tokens: u32,
+    /// The budget ran out before every hit fit.
+    pub truncated: bool,
+}
+
+/// Run `steps` in order, keeping the hits `keep` accepts within `budget` tokens.
+pub fn run(
+    db: &Database,
+    steps: &[MacroStep],
+    budget: Option<u32>,
+    keep: impl Fn(&MacroHit) -> bool,
+) -> Result<BatchResult> {
+    if steps.is_empty() {
+        bail!("a batch needs at least one query");
+    }
+    if steps.len() > MAX_QUERIES {
+        bail!(
+            "a batch holds at most {MAX_QUERIES} queries, got {}",
+            steps.len()
+        );
+    }
+
+    let mut seen: HashSet<MacroHit> = HashSet::new();
+    let mut tokens: u32 = 0;
+    let mut truncated = false;
+    let mut results = Vec::with_capacity(steps.len());
+    for step in steps {
+        let mut entry = BatchEntry {
+            query: step.query.label(),
+            target: step.query.target().to_string(),
+            hits: Vec::new(),
+            duplicates: 0,
+            dropped: 0,
+            error: None,
+        };
+        let found = step
+            .files_matcher()
+            .and_then(|files| {
+                let mut hits = macros::execute(db, &step.query, step.query.target())?;
+                if let Some(files) = files {
+                    hits.retain(|h| files.is_match(&h.file_path));
+                }
+                Ok(hits)
+            })
+            .map_err(|e| format!("{e:#}"));
+        let hits = match found {
+            Ok(hits) => hits,
+            Err(e) => {
+                entry.error = Some(e);
+                results.push(entry);
+                continue;
+            }
+        };
+        for hit in hits.into_iter().filter(|h| keep(h)) {
+            if seen.contains(&hit) {
+                entry.duplicates += 1;
+                continue;
+            }
+            let cost = serde_json::to_string(&hit).map_or(0, |json| estimate_tokens(&json));
+            if truncated || budget.is_some_and(|b| tokens.saturating_add(cost) > b) {
+                truncated = true;
+                entry.dropped += 1;
+                continue;
+            }
+            tokens += cost;
+            seen.insert(hit.clone());
+            entry.hits.push(hit);
+        }
+        results.push(entry);
+    }
+    Ok(BatchResult {
+        results,
+        tokens,
+        truncated,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, Symbol, SymbolKind};
+
+    fn steps(json: &str) -> Vec<MacroStep> {
+        serde_json::from_str(json).unwrap()
+    }
+
+    #[test]
+    fn test_batch_dedups_and_shares_budget() {
+        let db = Database::open_memory().unwrap();
+        let handler = Symbol::new("get_user", SymbolKind::Function, "api.py", 1, 3, 0, 10);
+        let loader = Symbol::new("load_user", SymbolKind::Function, "svc.py", 1, 3, 0, 10);
+        db.insert_symbol(&handler).unwrap();
+        db.insert_symbol(&loader).unwrap();
+        db.insert_edges(&[Edge::new(
+            handler.id.clone(),
+            "load_user",
+            EdgeKind::Calls,
+            "api.py",
+            2,
+        )])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let batch = steps(
+            r#"[
+                {"run": "search", "query": "user"},
+                {"run": "search", "query": "load"},
+                {"run": "callees", "name": "get_user"},
+                {"run": "refs", "name": "nowhere", "files": "["}
+            ]"#,
+        );
+        let result = run(&db, &batch, None, |_| true).unwrap();
+        assert_eq!(result.results[0].hits.len(), 2);
+        assert_eq!(result.results[1].hits.len(), 0);
+        assert_eq!(result.results[1].duplicates, 1);
+        assert_eq!(result.results[2].hits[0].name, "load_user");
+        assert!(result.results[3].error.is_some());
+        assert!(!result.truncated);
+
+        let one_hit = result.results[0].hits[0].clone();
+        let budget = estimate_tokens(&serde_json::to_string(&one_hit).unwrap());
+        let result = run(&db, &batch, Some(budget), |_| true).unwrap();
+        assert_eq!(result.results[0].hits.len(), 1);
+        assert_eq!(result.results[0].dropped, 1);
+        assert_eq!(result.results[2].dropped, 1);
+        assert!(result.truncated);
+
+        let scoped = run(&db, &batch[..1], None, |h| h.file_path == "svc.py").unwrap();
+        assert_eq!(scoped.results[0].hits[0].name, "load_user");
+
+        assert!(run(&db, &[], None, |_| true).is_err());
+    }
+}
@@ -0,0 +1,994 @@
+//! `cartog bench`: index and query performance on the benchmark fixtures.
+//!
+//! Every measurement runs a cartog binary as a subprocess on a private copy of the
+//! fixture, so the numbers include process start-up — the cost an agent pays per
+//! call — and any two binaries (or a saved report) can be compared like for like.
+//!
+//! Queries with expected results in the ground truth are also checked for
+//! accuracy: the share of expected names found in their `--json` output, the
+//! recall `benchmarks/run.sh` reports. With a regression threshold, the report
+//! lists every fixture whose index time, p50 latency or accuracy got worse by
+//! more than it, so `cartog bench` can gate a change locally.
+//!
+//! With `mutate`, each index round is followed by an incremental one: a probe
+//! function is appended to that many source files, `cartog index` runs again
+//! without `--force`, and a search for the probes must find all of them. That
+//! is the latency watch-mode users feel, which full-index time does not show.
+//!
+//! With `repo`, the fixtures are replaced by any project, a local path or a git
+//! URL (shallow-cloned). It has no ground truth, so its queries are derived from
+//! an index of it (the most-called function, the biggest file, ...), and only
+//! time and size are reported.
+
+use std::collections::{HashMap, HashSet};
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use std::time::Instant;
+
+use anyhow::{bail, Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::db::DB_FILE;
+
+pub const DEFAULT_FIXTURES_DIR: &str = "benchmarks/fixtures";
+
+/// Label for runs of the binary under test.
+pub const CURRENT: &str = "current";
+/// Label for runs of the baseline binary, or of a loaded previous report.
+pub const BASELINE: &str = "baseline";
+
+#[derive(Debug, Clone)]
+pub struct BenchConfig {
+    pub fixtures_dir: PathBuf,
+    /// Fixture directory names (`webapp_go`) or their suffixes (`go`). Empty = all.
+    pub fixtures: Vec<String>,
+    pub current: PathBuf,
+    pub baseline: Option<PathBuf>,
+    /// Timed executions of each query.
+    pub iterations: u32,
+    /// Full index runs per fixture; the median is reported.
+    pub index_runs: u32,
+    /// Source files changed before each incremental index run. 0 = no incremental runs.
+    pub mutate: usize,
+    /// Benchmark this project (path or git URL) instead of the fixtures.
+    pub repo: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct QueryLatency {
+    pub query: String,
+    pub p50_ms: f64,
+    pub p90_ms: f64,
+    pub p99_ms: f64,
+    /// Percent of the expected names found in the output, for queries with
+    /// expected results.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub recall: Option<f64>,
+}
+
+/// Results for one fixture under one binary.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FixtureRun {
+    pub fixture: String,
+    pub binary: String,
+    pub index_ms: f64,
+    /// Database plus WAL size after a full index.
+    pub index_bytes: u64,
+    /// Percentiles across every sample of every query.
+    pub p50_ms: f64,
+    pub p90_ms: f64,
+    pub p99_ms: f64,
+    /// Mean recall of the queries with expected results, in percent.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub accuracy: Option<f64>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub incremental: Option<IncrementalRun>,
+    pub queries: Vec<QueryLatency>,
+}
+
+/// Re-index after changing a few files, medians over the index runs.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct IncrementalRun {
+    pub files_changed: usize,
+    /// `cartog index .` after the change.
+    pub index_ms: f64,
+    /// From writing the files to a search that finds every new function:
+    /// write, incremental index and query.
+    pub fresh_ms: f64,
+    /// Rounds whose search missed a new function after the index run.
+    pub stale_runs: u32,
+}
+
+/// Relative change of the current binary against the baseline, in percent.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchDelta {
+    pub fixture: String,
+    pub index_ms_pct: f64,
+    pub index_bytes_pct: f64,
+    pub p50_pct: f64,
+    pub p99_pct: f64,
+    /// Missing when either side has no accuracy (an older saved report).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub accuracy_pct: Option<f64>,
+    /// Incremental index time; missing unless both sides ran with `mutate`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub incremental_ms_pct: Option<f64>,
+}
+
+/// A metric that got worse than the baseline by more than the threshold.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Regression {
+    pub fixture: String,
+    /// `index_ms`, `p50_ms`, `incremental_ms` or `accuracy`.
+    pub metric: String,
+    pub baseline: f64,
+    pub current: f64,
+    /// Relative change in percent; positive is slower, negative is less accurate.
+    pub change_pct: f64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct BenchReport {
+    pub iterations: u32,
+    pub runs: Vec<FixtureRun>,
+    pub deltas: Vec<BenchDelta>,
+    /// Threshold the regressions were checked against, in percent.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub fail_on_regression: Option<f64>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub regressions: Vec<Regression>,
+}
+
+impl BenchReport {
+    /// Use the `current` runs of a previously saved report as this report's baseline.
+    pub fn compare_with(&mut self, previous: BenchReport) {
+        self.runs.extend(
+            previous
+                .runs
+                .into_iter()
+                .filter(|r| r.binary == CURRENT)
+                .map(|r| FixtureRun {
+                    binary: BASELINE.to_string(),
+                    ..r
+                }),
+        );
+        self.deltas = deltas(&self.runs);
+    }
+
+    /// Record every delta worse than `threshold` percent in `regressions`.
+    pub fn check_regressions(&mut self, threshold: f64) {
+        self.fail_on_regression = Some(threshold);
+        self.regressions = regressions(&self.runs, &self.deltas, threshold);
+    }
+}
+
+/// Parse a threshold such as `10%` or `10` into percent.
+pub fn parse_percent(s: &str) -> std::result::Result<f64, String> {
+    let value: f64 = s
+        .trim()
+        .trim_end_matches('%')
+        .parse()
+        .map_err(|_| format!("'{s}' is not a percentage such as 10%"))?;
+    if !value.is_finite() || value < 0.0 {
+        return Err(format!("'{s}' must be a non-negative percentage"));
+    }
+    Ok(value)
+}
+
+/// Run every selected fixture against the current binary and, if set, the baseline.
+pub fn run(config: &BenchConfig) -> Result<BenchReport> {
+    anyhow::ensure!(config.iterations > 0, "iterations must be at least 1");
+    anyhow::ensure!(config.index_runs > 0, "index runs must be at least 1");
+
+    let mut binaries = vec![(CURRENT, config.current.as_path())];
+    if let Some(baseline) = &config.baseline {
+        binaries.push((BASELINE, baseline.as_path()));
+    }
+
+    let mut runs = Vec::new();
+    if let Some(repo) = &config.repo {
+        let (name, source, clone) = resolve_repo(repo)?;
+        let result = derive_queries_for(&source).and_then(|queries| {
+            for (label, binary) in &binaries {
+                runs.push(run_fixture(
+                    config, &source, &name, label, binary, &queries,
+                )?);
+            }
+            Ok(())
+        });
+        if let Some(clone) = clone {
+            let _ = std::fs::remove_dir_all(clone);
+        }
+        result?;
+    } else {
+        let fixtures = select_fixtures(&config.fixtures_dir, &config.fixtures)?;
+        for fixture in &fixtures {
+            let queries = load_queries(&config.fixtures_dir, fixture)?;
+            let source = config.fixtures_dir.join(fixture);
+            for (label, binary) in &binaries {
+                runs.push(run_fixture(
+                    config, &source, fixture, label, binary, &queries,
+                )?);
+            }
+        }
+    }
+    let deltas = deltas(&runs);
+    Ok(BenchReport {
+        iterations: config.iterations,
+        runs,
+        deltas,
+        fail_on_regression: None,
+        regressions: Vec::new(),
+    })
+}
+
+fn run_fixture(
+    config: &BenchConfig,
+    source: &Path,
+    fixture: &str,
+    label: &str,
+    binary: &Path,
+    queries: &[BenchQuery],
+) -> Result<FixtureRun> {
+    let work = std::env::temp_dir().join(format!(
+        "cartog-bench-{}-{fixture}-{label}",
+        std::process::id()
+    ));
+    let _ = std::fs::remove_dir_all(&work);
+    copy_dir(source, &work)?;
+    let result = measure(config, &work, fixture, label, binary, queries);
+    let _ = std::fs::remove_dir_all(&work);
+    result
+}
+
+fn measure(
+    config: &BenchConfig,
+    work: &Path,
+    fixture: &str,
+    label: &str,
+    binary: &Path,
+    queries: &[BenchQuery],
+) -> Result<FixtureRun> {
+    let mut index_samples = Vec::new();
+    for _ in 0..config.index_runs {
+        remove_index(work);
+        index_samples.push(time_command(binary, work, &["index", "."])?);
+    }
+    index_samples.sort_by(f64::total_cmp);
+    let index_bytes = index_size(work);
+    let incremental = if config.mutate > 0 {
+        Some(measure_incremental(config, work, binary)?)
+    } else {
+        None
+    };
+
+    let mut all_samples = Vec::new();
+    let mut latencies = Vec::new();
+    for query in queries {
+        let args: Vec<&str> = query.args.iter().map(String::as_str).collect();
+        let mut samples = Vec::with_capacity(config.iterations as usize);
+        for _ in 0..config.iterations {
+            samples.push(time_command(binary, work, &args)?);
+        }
+        samples.sort_by(f64::total_cmp);
+        let recall = if query.expected.is_empty() {
+            None
+        } else {
+            let json = json_output(binary, work, &args)?;
+            Some(recall(&json, &query.expected))
+        };
+        latencies.push(QueryLatency {
+            query: query.args.join(" "),
+            p50_ms: percentile(&samples, 50.0),
+            p90_ms: percentile(&samples, 90.0),
+            p99_ms: percentile(&samples, 99.0),
+            recall,
+        });
+        all_samples.extend(samples);
+    }
+    all_samples.sort_by(f64::total_cmp);
+    let recalls: Vec<f64> = latencies.iter().filter_map(|q| q.recall).collect();
+    let accuracy =
+        (!recalls.is_empty()).then(|| recalls.iter().sum::<f64>() / recalls.len() as f64);
+
+    Ok(FixtureRun {
+        fixture: fixture.to_string(),
+        binary: label.to_string(),
+        index_ms: percentile(&index_samples, 50.0),
+        index_bytes,
+        p50_ms: percentile(&all_samples, 50.0),
+        p90_ms: percentile(&all_samples, 90.0),
+        p99_ms: percentile(&all_samples, 99.0),
+        accuracy,
+        incremental,
+        queries: latencies,
+    })
+}
+
+/// Incremental re-index rounds on an indexed copy. Each round changes the
+/// next `config.mutate` source files, so rounds do not repeat a file until
+/// every file has been changed once.
+fn measure_incremental(config: &BenchConfig, work: &Path, binary: &Path) -> Result<IncrementalRun> {
+    let files = source_files(work);
+    anyhow::ensure!(
+        !files.is_empty(),
+        "no source files to change in {}",
+        work.display()
+    );
+    let count = config.mutate.min(files.len());
+
+    let mut index_samples = Vec::new();
+    let mut fresh_samples = Vec::new();
+    let mut stale_runs = 0;
+    for round in 0..config.index_runs as usize {
+        let prefix = format!("cartog_bench_probe_{round}_");
+        let mut probes = Vec::with_capacity(count);
+        let started = Instant::now();
+        for i in 0..count {
+            let file = &files[(round * count + i) % files.len()];
+            let name = format!("{prefix}{i}");
+            append_probe(file, &name)?;
+            probes.push(name);
+        }
+        index_samples.push(time_command(binary, work, &["index", "."])?);
+        let found = json_output(binary, work, &["search", &prefix, "--limit", "100"])?;
+        let fresh = started.elapsed().as_secs_f64() * 1000.0;
+        // Search returns at most 100 symbols.
+        if symbol_names(&found).len() >= probes.len().min(100) {
+            fresh_samples.push(fresh);
+        } else {
+            stale_runs += 1;
+        }
+    }
+    index_samples.sort_by(f64::total_cmp);
+    fresh_samples.sort_by(f64::total_cmp);
+    Ok(IncrementalRun {
+        files_changed: count,
+        index_ms: percentile(&index_samples, 50.0),
+        fresh_ms: percentile(&fresh_samples, 50.0),
+        stale_runs,
+    })
+}
+
+/// Distinct `name` fields of a `--json` symbol list.
+fn symbol_names(json: &str) -> HashSet<String> {
+    serde_json::from_str::<Vec<serde_json::Value>>(json)
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|s| s.get("name")?.as_str().map(str::to_string))
+        .collect()
+}
+
+/// Source files `probe_snippet` can extend, in path order.
+fn source_files(dir: &Path) -> Vec<PathBuf> {
+    let mut files: Vec<PathBuf> = walkdir::WalkDir::new(dir)
+        .into_iter()
+        .filter_map(|e| e.ok())
+        .filter(|e| e.file_type().is_file())
+        .map(|e| e.into_path())
+        .filter(|p| probe_snippet(p, "x").is_some())
+        .collect();
+    files.sort();
+    files
+}
+
+/// A function named `name`, in the language of `path`, to append to it.
+fn probe_snippet(path: &Path, name: &str) -> Option<String> {
+    let snippet = match path.extension()?.to_str()? {
+        "py" => format!("\n\ndef {name}():\n    return 0\n"),
+        "go" => format!("\nfunc {name}() int {{\n\treturn 0\n}}\n"),
+        "rs" => format!("\nfn {name}() -> i32 {{\n    0\n}}\n"),
+        "rb" => format!("\ndef {name}\n  0\nend\n"),
+        "ts" | "tsx" | "js" | "jsx" => format!("\nfunction {name}() {{\n  return 0;\n}}\n"),
+        _ => return None,
+    };
+    Some(snippet)
+}
+
+fn append_probe(path: &Path, name: &str) -> Result<()> {
+    use std::io::Write;
+    let snippet = probe_snippet(path, name).unwrap_or_default();
+    std::fs::OpenOptions::new()
+        .append(true)
+        .open(path)
+        .and_then(|mut f| f.write_all(snippet.as_bytes()))
+        .with_context(|| format!("failed to change {}", path.display()))
+}
+
+/// Stdout of `binary --json args`, which must succeed.
+fn json_output(binary: &Path, dir: &Path, args: &[&str]) -> Result<String> {
+    let out = Command::new(binary)
+        .arg("--json")
+        .args(args)
+        .current_dir(dir)
+        .stderr(Stdio::null())
+        .output()
+        .with_context(|| format!("failed to run {}", binary.display()))?;
+    if !out.status.success() {
+        bail!(
+            "`{} --json {}` failed in {} ({})",
+            binary.display(),
+            args.join(" "),
+            dir.display(),
+            out.status
+        );
+    }
+    Ok(String::from_utf8_lossy(&out.stdout).into_owned())
+}
+
+/// Percent of `expected` names that appear in `output`.
+fn recall(output: &str, expected: &[String]) -> f64 {
+    let found = expected
+        .iter()
+        .filter(|e| output.contains(e.as_str()))
+        .count();
+    found as f64 / expected.len() as f64 * 100.0
+}
+
+/// Wall time of one successful run, in milliseconds.
+fn time_command(binary: &Path, dir: &Path, args: &[&str]) -> Result<f64> {
+    let started = Instant::now();
+    let status = Command::new(binary)
+        .args(args)
+        .current_dir(dir)
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status()
+        .with_context(|| format!("failed to run {}", binary.display()))?;
+    let elapsed = started.elapsed().as_secs_f64() * 1000.0;
+    if !status.success() {
+        bail!(
+            "`{} {}` failed in {} ({status})",
+            binary.display(),
+            args.join(" "),
+            dir.display()
+        );
+    }
+    Ok(elapsed)
+}
+
+fn index_files(dir: &Path) -> [PathBuf; 3] {
+    [
+        dir.join(DB_FILE),
+        dir.join(format!("{DB_FILE}-wal")),
+        dir.join(format!("{DB_FILE}-shm")),
+    ]
+}
+
+fn remove_index(dir: &Path) {
+    for path in index_files(dir) {
+        let _ = std::fs::remove_file(path);
+    }
+}
+
+fn index_size(dir: &Path) -> u64 {
+    index_files(dir)[..2]
+        .iter()
+        .filter_map(|p| std::fs::metadata(p).ok())
+        .map(|m| m.len())
+        .sum()
+}
+
+/// Nearest-rank percentile of an ascending slice. Zero for an empty slice.
+pub fn percentile(sorted: &[f64], p: f64) -> f64 {
+    if sorted.is_empty() {
+        return 0.0;
+    }
+    let rank = (p / 100.0 * sorted.len() as f64).ceil() as usize;
+    sorted[rank.clamp(1, sorted.len()) - 1]
+}
+
+fn select_fixtures(dir: &Path, wanted: &[String]) -> Result<Vec<String>> {
+    let mut available: Vec<String> = std::fs::read_dir(dir)
+        .with_context(|| format!("cannot read fixtures directory {}", dir.display()))?
+        .filter_map(|e| e.ok())
+        .filter(|e| e.path().is_dir())
+        .map(|e| e.file_name().to_string_lossy().into_owned())
+        .collect();
+    available.sort();
+    if wanted.is_empty() {
+        return Ok(available);
+    }
+    wanted
+        .iter()
+        .map(|w| {
+            let long = format!("webapp_{w}");
+            available
+                .iter()
+                .find(|a| *a == w || **a == long)
+                .cloned()
+                .with_context(|| format!("unknown fixture '{w}' in {}", dir.display()))
+        })
+        .collect()
+}
+
+/// A timed query and the names its output should contain.
+struct BenchQuery {
+    args: Vec<String>,
+    expected: Vec<String>,
+}
+
+/// Queries for a fixture, taken from `../ground_truth/<fixture>.json`.
+///
+/// `rag` queries are skipped (they need downloaded models). Without a ground-truth
+/// file, only `stats` is timed.
+fn load_queries(fixtures_dir: &Path, fixture: &str) -> Result<Vec<BenchQuery>> {
+    let path = fixtures_dir
+        .parent()
+        .unwrap_or(fixtures_dir)
+        .join("ground_truth")
+        .join(format!("{fixture}.json"));
+    let Ok(raw) = std::fs::read_to_string(&path) else {
+        return Ok(vec![BenchQuery {
+            args: vec!["stats".to_string()],
+            expected: Vec::new(),
+        }]);
+    };
+    let truth: serde_json::Value = serde_json::from_str(&raw)
+        .with_context(|| format!("invalid ground truth {}", path.display()))?;
+    let mut expected = parse_expected(&truth);
+    Ok(parse_queries(&truth)
+        .into_iter()
+        .map(|args| BenchQuery {
+            expected: expected.remove(&args.join(" ")).unwrap_or_default(),
+            args,
+        })
+        .collect())
+}
+
+fn parse_queries(truth: &serde_json::Value) -> Vec<Vec<String>> {
+    let Some(scenarios) = truth.as_object() else {
+        return Vec::new();
+    };
+    let mut queries: Vec<Vec<String>> = scenarios
+        .values()
+        .filter_map(|s| s.get("query")?.as_str())
+        .map(|q| q.split_whitespace().map(str::to_string).collect::<Vec<_>>())
+        .filter(|q| q.first().is_some_and(|cmd| cmd != "rag"))
+        .collect();
+    queries.sort();
+    queries.dedup();
+    queries
+}
+
+/// Expected names per query line: the `expected` and `expected_refs` entries of
+/// each scenario, as plain strings or their `source`, `name` or `callee` field.
+fn parse_expected(truth: &serde_json::Value) -> HashMap<String, Vec<String>> {
+    let mut expected: HashMap<String, Vec<String>> = HashMap::new();
+    let Some(scenarios) = truth.as_object() else {
+        return expected;
+    };
+    for scenario in scenarios.values() {
+        let Some(query) = scenario.get("query").and_then(|q| q.as_str()) else {
+            continue;
+        };
+        let key = query.split_whitespace().collect::<Vec<_>>().join(" ");
+        let items = ["expected", "expected_refs"]
+            .iter()
+            .filter_map(|field| scenario.get(field)?.as_array())
+            .flatten()
+            .filter_map(|item| match item {
+                serde_json::Value::String(s) => Some(s.clone()),
+                _ => ["source", "name", "callee"]
+                    .iter()
+                    .find_map(|f| item.get(f)?.as_str())
+                    .map(str::to_string),
+            });
+        let names = expected.entry(key).or_default();
+        for item in items {
+            if !names.contains(&item) {
+                names.push(item);
+            }
+        }
+    }
+    expected
+}
+
+/// Name and source directory of `--repo`, plus the clone to delete afterwards
+/// when it was a git URL.
+fn resolve_repo(repo: &str) -> Result<(String, PathBuf, Option<PathBuf>)> {
+    let name = repo
+        .trim_end_matches('/')
+        .trim_end_matches(".git")
+        .rsplit(['/', ':'])
+        .next()
+        .filter(|n| !n.is_empty())
+        .unwrap_or("repo")
+        .to_string();
+    if !is_git_url(repo) {
+        let path = PathBuf::from(repo);
+        anyhow::ensure!(path.is_dir(), "{repo} is not a directory or a git URL");
+        let name = match std::fs::canonicalize(&path)?.file_name() {
+            Some(n) => n.to_string_lossy().into_owned(),
+            None => name,
+        };
+        return Ok((name, path, None));
+    }
+
+    let clone = std::env::temp_dir().join(format!("cartog-bench-{}-clone", std::process::id()));
+    let _ = std::fs::remove_dir_all(&clone);
+    let status = Command::new("git")
+        .args(["clone", "--quiet", "--depth", "1", repo])
+        .arg(&clone)
+        .status()
+        .context("failed to run git")?;
+    if !status.success() {
+        let _ = std::fs::remove_dir_all(&clone);
+        bail!("git clone {repo} failed ({status})");
+    }
+    Ok((name, clone.clone(), Some(clone)))
+}
+
+fn is_git_url(repo: &str) -> bool {
+    repo.contains("://") || (repo.starts_with("git@") && repo.contains(':'))
+}
+
+fn derive_queries_for(source: &Path) -> Result<Vec<BenchQuery>> {
+    let db = crate::db::Database::open_memory()?;
+    crate::indexer::index_directory(&db, source, true)
+        .with_context(|| format!("failed to index {}", source.display()))?;
+    derive_queries(&db)
+}
+
+/// The standard query battery for a project without ground truth: callers and
+/// impact of the most-called symbol, callees of the symbol making the most
+/// calls, the most-extended type's hierarchy, outline and deps of the file
+/// with the most symbols, a prefix search, and stats.
+fn derive_queries(db: &crate::db::Database) -> Result<Vec<BenchQuery>> {
+    use crate::types::EdgeKind;
+
+    let symbols = db.all_symbols()?;
+    let edges = db.all_edges()?;
+    let name_of: HashMap<&str, &str> = symbols
+        .iter()
+        .map(|s| (s.id.as_str(), s.name.as_str()))
+        .collect();
+
+    // Most frequent key; ties go to the smallest, so the battery is stable.
+    fn top<'a>(keys: impl Iterator<Item = &'a str>) -> Option<&'a str> {
+        let mut counts: HashMap<&str, usize> = HashMap::new();
+        for k in keys {
+            *counts.entry(k).or_default() += 1;
+        }
+        counts
+            .into_iter()
+            .max_by(|a, b| a.1.cmp(&b.1).then(b.0.cmp(a.0)))
+            .map(|(k, _)| k)
+    }
+
+    let calls = || edges.iter().filter(|e| e.kind == EdgeKind::Calls);
+    let most_called = top(calls()
+        .filter_map(|e| e.target_id.as_deref())
+        .filter_map(|id| name_of.get(id).copied()));
+    let most_calling = top(calls().filter_map(|e| name_of.get(e.source_id.as_str()).copied()));
+    let most_extended = top(edges
+        .iter()
+        .filter(|e| e.kind == EdgeKind::Inherits)
+        .map(|e| e.target_name.as_str()));
+    let biggest_file = top(symbols.iter().map(|s| s.file_path.as_str()));
+
+    let mut queries: Vec<Vec<String>> = Vec::new();
+    let mut add = |words: &[&str]| queries.push(words.iter().map(|w| w.to_string()).collect());
+    if let Some(name) = most_called {
+        add(&["refs", name, "--kind", "calls"]);
+        add(&["impact", name, "--depth", "3"]);
+        let prefix: String = name.chars().take(4).collect();
+        add(&["search", &prefix]);
+    }
+    if let Some(name) = most_calling {
+        add(&["callees", name]);
+    }
+    if let Some(name) = most_extended {
+        add(&["hierarchy", name]);
+    }
+    if let Some(file) = biggest_file {
+        add(&["outline", file]);
+        add(&["deps", file]);
+    }
+    add(&["stats"]);
+
+    Ok(queries
+        .into_iter()
+        .map(|args| BenchQuery {
+            args,
+            expected: Vec::new(),
+        })
+        .collect())
+}
+
+fn deltas(runs: &[FixtureRun]) -> Vec<BenchDelta> {
+    let pct = |new: f64, old: f64| {
+        if old == 0.0 {
+            0.0
+        } else {
+            (new - old) / old * 100.0
+        }
+    };
+    runs.iter()
+        .filter(|r| r.binary == CURRENT)
+        .filter_map(|cur| {
+            let base = runs
+                .iter()
+                .find(|r| r.binary == BASELINE && r.fixture == cur.fixture)?;
+            Some(BenchDelta {
+                fixture: cur.fixture.clone(),
+                index_ms_pct: pct(cur.index_ms, base.index_ms),
+                index_bytes_pct: pct(cur.index_bytes as f64, base.index_bytes as f64),
+                p50_pct: pct(cur.p50_ms, base.p50_ms),
+                p99_pct: pct(cur.p99_ms, base.p99_ms),
+                accuracy_pct: cur
+                    .accuracy
+                    .zip(base.accuracy)
+                    .map(|(new, old)| pct(new, old)),
+                incremental_ms_pct: cur
+                    .incremental
+                    .as_ref()
+                    .zip(base.incremental.as_ref())
+                    .map(|(new, old)| pct(new.index_ms, old.index_ms)),
+            })
+        })
+        .collect()
+}
+
+fn regressions(runs: &[FixtureRun], deltas: &[BenchDelta], threshold: f64) -> Vec<Regression> {
+    let find = |fixture: &str, binary: &str| {
+        runs.iter()
+            .find(|r| r.fixture == fixture && r.binary == binary)
+    };
+    let mut out = Vec::new();
+    for d in deltas {
+        let (Some(cur), Some(base)) = (find(&d.fixture, CURRENT), find(&d.fixture, BASELINE))
+        else {
+            continue;
+        };
+        // (metric, baseline, current, change, how much worse): slower is
+        // worse for times, lower is worse for accuracy.
+        let mut metrics = vec![
+            (
+                "index_ms",
+                base.index_ms,
+                cur.index_ms,
+                d.index_ms_pct,
+                d.index_ms_pct,
+            ),
+            ("p50_ms", base.p50_ms, cur.p50_ms, d.p50_pct, d.p50_pct),
+        ];
+        if let (Some(change), Some(old), Some(new)) =
+            (d.incremental_ms_pct, &base.incremental, &cur.incremental)
+        {
+            metrics.push(("incremental_ms", old.index_ms, new.index_ms, change, change));
+        }
+        if let (Some(change), Some(old), Some(new)) = (d.accuracy_pct, base.accuracy, cur.accuracy)
+        {
+            metrics.push(("accuracy", old, new, change, -change));
+        }
+        out.extend(metrics.into_iter().filter(|m| m.4 > threshold).map(
+            |(metric, baseline, current, change_pct, _)| Regression {
+                fixture: d.fixture.clone(),
+                metric: metric.to_string(),
+                baseline,
+                current,
+                change_pct,
+            },
+        ));
+    }
+    out
+}
+
+/// Copy a project, leaving out `.git` and any existing index.
+fn copy_dir(src: &Path, dst: &Path) -> Result<()> {
+    std::fs::create_dir_all(dst).with_context(|| format!("failed to create {}", dst.display()))?;
+    for entry in std::fs::read_dir(src).with_context(|| format!("cannot read {}", src.display()))? {
+        let entry = entry?;
+        let file_name = entry.file_name();
+        let file_name = file_name.to_string_lossy();
+        if file_name == ".git" || file_name.starts_with(DB_FILE) {
+            continue;
+        }
+        let target = dst.join(entry.file_name());
+        if entry.file_type()?.is_dir() {
+            copy_dir(&entry.path(), &target)?;
+        } else {
+            std::fs::copy(entry.path(), &target)
+                .with_context(|| format!("failed to copy {}", entry.path().display()))?;
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_percentile_nearest_rank() {
+        let samples: Vec<f64> = (1..=10).map(f64::from).collect();
+        assert_eq!(percentile(&samples, 50.0), 5.0);
+        assert_eq!(percentile(&samples, 90.0), 9.0);
+        assert_eq!(percentile(&samples, 99.0), 10.0);
+        assert_eq!(percentile(&[3.0], 99.0), 3.0);
+        assert_eq!(percentile(&[], 50.0), 0.0);
+    }
+
+    #[test]
+    fn test_parse_queries_skips_rag_and_dedups() {
+        let truth = serde_json::json!({
+            "01": { "query": "refs ValidateToken --kind calls" },
+            "02": { "query": "outline internal/auth/service.go" },
+            "03": { "query": "rag search \"validate token\"" },
+            "04": { "query": "refs ValidateToken --kind calls" },
+            "05": { "description": "no query" }
+        });
+        let queries = parse_queries(&truth);
+        assert_eq!(
+            queries,
+            vec![
+                vec!["outline", "internal/auth/service.go"],
+                vec!["refs", "ValidateToken", "--kind", "calls"],
+            ]
+        );
+    }
+
+    #[test]
+    fn test_deltas_pair_current_with_baseline() {
+        let run = |binary: &str, index_ms: f64, p50: f64| FixtureRun {
+            fixture: "webapp_go".to_string(),
+            binary: binary.to_string(),
+            index_ms,
+            index_bytes: 1000,
+            p50_ms: p50,
+            p90_ms: p50,
+            p99_ms: p50,
+            accuracy: None,
+            incremental: None,
+            queries: Vec::new(),
+        };
+        let runs = vec![run(CURRENT, 90.0, 5.0), run(BASELINE, 100.0, 4.0)];
+        let d = deltas(&runs);
+        assert_eq!(d.len(), 1);
+        assert!((d[0].index_ms_pct + 10.0).abs() < 1e-9);
+        assert!((d[0].p50_pct - 25.0).abs() < 1e-9);
+        assert_eq!(d[0].index_bytes_pct, 0.0);
+    }
+
+    #[test]
+    fn test_regressions_over_threshold() {
+        let run = |binary: &str, index_ms: f64, p50: f64, accuracy: f64| FixtureRun {
+            fixture: "webapp_go".to_string(),
+            binary: binary.to_string(),
+            index_ms,
+            index_bytes: 1000,
+            p50_ms: p50,
+            p90_ms: p50,
+            p99_ms: p50,
+            accuracy: Some(accuracy),
+            incremental: None,
+            queries: Vec::new(),
+        };
+        let mut report = BenchReport {
+            iterations: 1,
+            runs: vec![
+                run(CURRENT, 105.0, 6.0, 80.0),
+                run(BASELINE, 100.0, 5.0, 100.0),
+            ],
+            deltas: Vec::new(),
+            fail_on_regression: None,
+            regressions: Vec::new(),
+        };
+        report.deltas = deltas(&report.runs);
+        report.check_regressions(10.0);
+
+        // Index time is 5% slower (within 10%); p50 is 20% slower and accuracy 20% lower.
+        let metrics: Vec<&str> = report
+            .regressions
+            .iter()
+            .map(|r| r.metric.as_str())
+            .collect();
+        assert_eq!(metrics, ["p50_ms", "accuracy"]);
+        assert!((report.regressions[1].change_pct + 20.0).abs() < 1e-9);
+
+        report.check_regressions(25.0);
+        assert!(report.regressions.is_empty());
+
+        assert_eq!(parse_percent("10%"), Ok(10.0));
+        assert_eq!(parse_percent("2.5"), Ok(2.5));
+        assert!(parse_percent("-1%").is_err());
+        assert!(parse_percent("ten").is_err());
+    }
+
+    #[test]
+    fn test_parse_expected_collects_names_per_query() {
+        let truth = serde_json::json!({
+            "01": {
+                "query": "refs  ValidateToken --kind calls",
+                "expected": [{ "source": "GetCurrentUser", "file": "a.go" }, { "name": "Login" }]
+            },
+            "03": { "query": "impact AuthService", "expected_refs": ["NewAuthService", "cmd/main.go"] },
+            "05": { "query_sequence": ["callees Login"], "expected_chain": [{ "callee": "x" }] }
+        });
+        let expected = parse_expected(&truth);
+        assert_eq!(expected.len(), 2);
+        assert_eq!(
+            expected["refs ValidateToken --kind calls"],
+            ["GetCurrentUser", "Login"]
+        );
+        assert_eq!(
+            expected["impact AuthService"],
+            ["NewAuthService", "cmd/main.go"]
+        );
+        assert_eq!(
+            recall(
+                "GetCurrentUser only",
+                &expected["refs ValidateToken --kind calls"]
+            ),
+            50.0
+        );
+    }
+
+    #[test]
+    fn test_probe_appended_in_file_language() {
+        let dir = std::env::temp_dir().join(format!("cartog-bench-probe-{}", std::process::id()));
+        std::fs::create_dir_all(dir.join("pkg")).unwrap();
+        std::fs::write(dir.join("pkg/a.go"), "package pkg\n").unwrap();
+        std::fs::write(dir.join("b.py"), "x = 1\n").unwrap();
+        std::fs::write(dir.join("notes.md"), "# notes\n").unwrap();
+
+        let files = source_files(&dir);
+        assert_eq!(files, vec![dir.join("b.py"), dir.join("pkg/a.go")]);
+
+        append_probe(&files[1], "cartog_bench_probe_0_0").unwrap();
+        let go = std::fs::read_to_string(&files[1]).unwrap();
+        assert!(go.ends_with("func cartog_bench_probe_0_0() int {\n\treturn 0\n}\n"));
+
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_derive_queries_from_index() {
+        let dir = Path::new(env!("CARGO_MANIFEST_DIR"))
+            .join(DEFAULT_FIXTURES_DIR)
+            .join("webapp_go");
+        let queries: Vec<String> = derive_queries_for(&dir)
+            .unwrap()
+            .into_iter()
+            .map(|q| q.args.join(" "))
+            .collect();
+        let commands: Vec<&str> = queries
+            .iter()
+            .map(|q| q.split(' ').next().unwrap())
+            .collect();
+        assert_eq!(
+            commands,
+            [
+                "refs",
+                "impact",
+                "search",
+                "callees",
+                "hierarchy",
+                "outline",
+                "deps",
+                "stats"
+            ]
+        );
+        assert!(queries[0].ends_with("--kind calls"));
+    }
+
+    #[test]
+    fn test_repo_names_and_urls() {
+        assert!(is_git_url("https://github.com/jrollin/cartog.git"));
+        assert!(is_git_url("git@github.com:jrollin/cartog.git"));
+        assert!(!is_git_url("../cartog"));
+        let (name, _, clone) = resolve_repo(env!("CARGO_MANIFEST_DIR")).unwrap();
+        assert!(clone.is_none());
+        assert!(!name.is_empty());
+        assert!(resolve_repo("/no/such/dir").is_err());
+    }
+
+    #[test]
+    fn test_select_fixtures_accepts_short_names() {
+        let dir = Path::new(env!("CARGO_MANIFEST_DIR")).join(DEFAULT_FIXTURES_DIR);
+        let selected = select_fixtures(&dir, &["go".to_string()]).unwrap();
+        assert_eq!(selected, vec!["webapp_go"]);
+        assert!(select_fixtures(&dir, &["cobol".to_string()]).is_err());
+    }
+}
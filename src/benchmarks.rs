@@ -0,0 +1,256 @@
+//! Go benchmark inventory: `Benchmark*` functions, what they exercise, and which
+//! of them to run after changing a symbol.
+//!
+//! A benchmark is a function in a `_test.go` file named `Benchmark` followed by
+//! anything but a lower-case letter, taking a `*testing.B`, as `go test` finds
+//! them. What it exercises is read off resolved call edges, so calls made inside
+//! `b.Run` closures count towards the benchmark.
+
+use std::collections::{BTreeMap, HashMap, HashSet, VecDeque};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::Symbol;
+
+/// A benchmark and the indexed symbols it calls directly.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Benchmark {
+    pub symbol: Symbol,
+    pub exercises: Vec<String>,
+}
+
+/// A benchmark that reaches the changed symbol, and the calls on the way.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BenchmarkHit {
+    pub symbol: Symbol,
+    /// Calls between the benchmark and the symbol: 1 when it calls it directly.
+    pub depth: u32,
+    /// Names from the benchmark's first callee down to the symbol.
+    pub via: Vec<String>,
+}
+
+/// Whether `name` is one `go test -bench` runs.
+pub fn is_benchmark_name(name: &str) -> bool {
+    name.strip_prefix("Benchmark")
+        .is_some_and(|rest| !rest.starts_with(|c: char| c.is_lowercase()))
+}
+
+/// Every benchmark with what it calls.
+pub fn inventory(db: &Database) -> Result<Vec<Benchmark>> {
+    let mut benchmarks = Vec::new();
+    for symbol in db.benchmarks()? {
+        if !is_benchmark_name(&symbol.name) {
+            continue;
+        }
+        let mut exercises = Vec::new();
+        for edge in db.callees(&symbol.name)? {
+            if edge.source_id == symbol.id
+                && edge.target_id.is_some()
+                && !exercises.contains(&edge.target_name)
+            {
+                exercises.push(edge.target_name);
+            }
+        }
+        benchmarks.push(Benchmark { symbol, exercises });
+    }
+    Ok(benchmarks)
+}
+
+/// Benchmarks calling `name` within `depth` calls, nearest first. Walks callers
+/// breadth-first from each definition, so each benchmark comes with its shortest
+/// path.
+pub fn covering(db: &Database, name: &str, depth: u32) -> Result<Vec<BenchmarkHit>> {
+    let benchmarks: HashSet<String> = db
+        .benchmarks()?
+        .into_iter()
+        .filter(|s| is_benchmark_name(&s.name))
+        .map(|s| s.id)
+        .collect();
+    let mut hits: BTreeMap<String, BenchmarkHit> = BTreeMap::new();
+    // Symbol id -> the names from it down to `name`.
+    let mut paths: HashMap<String, Vec<String>> = HashMap::new();
+    let mut queue = VecDeque::new();
+    for definition in db.find_definitions(name)? {
+        paths.insert(definition.id.clone(), vec![definition.name.clone()]);
+        queue.push_back((definition.id, 0));
+    }
+    while let Some((id, d)) = queue.pop_front() {
+        if d >= depth {
+            continue;
+        }
+        let via = paths[&id].clone();
+        for (caller, _) in db.callers(&id)? {
+            if paths.contains_key(&caller.id) {
+                continue;
+            }
+            let mut path = vec![caller.name.clone()];
+            path.extend(via.iter().cloned());
+            paths.insert(caller.id.clone(), path);
+            if benchmarks.contains(&caller.id) {
+                hits.entry(caller.id.clone()).or_insert(BenchmarkHit {
+                    symbol: caller.clone(),
+                    depth: d + 1,
+                    via: via.clone(),
+                });
+            }
+            queue.push_back((caller.id, d + 1));
+        }
+    }
+    let mut hits: Vec<_> = hits.into_values().collect();
+    hits.sort_by(|a, b| {
+        (a.depth, &a.symbol.file_path, a.symbol.start_line).cmp(&(
+            b.depth,
+            &b.symbol.file_path,
+            b.symbol.start_line,
+        ))
+    });
+    Ok(hits)
+}
+
+/// `go test` invocations running exactly `benchmarks`, one per package directory.
+pub fn run_commands(benchmarks: &[&Symbol]) -> Vec<String> {
+    let mut by_package: BTreeMap<&str, Vec<&str>> = BTreeMap::new();
+    for symbol in benchmarks {
+        let dir = symbol
+            .file_path
+            .rsplit_once('/')
+            .map_or(".", |(dir, _)| dir);
+        let names = by_package.entry(dir).or_default();
+        if !names.contains(&symbol.name.as_str()) {
+            names.push(&symbol.name);
+        }
+    }
+    by_package
+        .into_iter()
+        .map(|(dir, names)| {
+            let package = if dir == "." {
+                ".".to_string()
+            } else {
+                format!("./{dir}")
+            };
+            format!(
+                "go test -run '^$' -bench '^({})$' {package}",
+                names.join("|")
+            )
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, SymbolKind};
+
+    #[test]
+    fn test_benchmarks_reaching_a_symbol() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, file: &str, line: u32, signature: &str| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 5, 0, 100)
+                .with_signature(Some(signature.to_string()))
+        };
+        let charge = func("Charge", "billing/charge.go", 3, "(amount int) error");
+        let checkout = func("Checkout", "billing/checkout.go", 3, "() error");
+        let bench_charge = func(
+            "BenchmarkCharge",
+            "billing/charge_test.go",
+            5,
+            "(b *testing.B)",
+        );
+        let bench_checkout = func(
+            "BenchmarkCheckout",
+            "billing/checkout_test.go",
+            5,
+            "(b *testing.B)",
+        );
+        // Not benchmarks: lower case after the prefix, and no *testing.B.
+        let helper = func(
+            "Benchmarkhelper",
+            "billing/charge_test.go",
+            20,
+            "(b *testing.B)",
+        );
+        let setup = func("BenchmarkSetup", "billing/charge_test.go", 30, "()");
+        db.insert_symbols(&[
+            charge,
+            checkout.clone(),
+            bench_charge.clone(),
+            bench_checkout.clone(),
+            helper.clone(),
+            setup.clone(),
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &checkout.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/checkout.go",
+                5,
+            ),
+            Edge::new(
+                &bench_charge.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/charge_test.go",
+                7,
+            ),
+            Edge::new(
+                &bench_charge.id,
+                "ResetTimer",
+                EdgeKind::Calls,
+                "billing/charge_test.go",
+                6,
+            ),
+            Edge::new(
+                &bench_checkout.id,
+                "Checkout",
+                EdgeKind::Calls,
+                "billing/checkout_test.go",
+                7,
+            ),
+            Edge::new(
+                &helper.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/charge_test.go",
+                22,
+            ),
+            Edge::new(
+                &setup.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/charge_test.go",
+                32,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let all = inventory(&db).unwrap();
+        assert_eq!(all.len(), 2);
+        assert_eq!(all[0].symbol.name, "BenchmarkCharge");
+        assert_eq!(all[0].exercises, ["Charge"]);
+
+        let hits = covering(&db, "Charge", 5).unwrap();
+        let found: Vec<_> = hits
+            .iter()
+            .map(|h| (h.symbol.name.as_str(), h.depth, h.via.join(" > ")))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("BenchmarkCharge", 1, "Charge".to_string()),
+                ("BenchmarkCheckout", 2, "Checkout > Charge".to_string()),
+            ]
+        );
+        assert_eq!(covering(&db, "Charge", 1).unwrap().len(), 1);
+
+        let symbols: Vec<&Symbol> = hits.iter().map(|h| &h.symbol).collect();
+        assert_eq!(
+            run_commands(&symbols),
+            ["go test -run '^$' -bench '^(BenchmarkCharge|BenchmarkCheckout)$' ./billing"]
+        );
+    }
+}
@@ -0,0 +1,103 @@
+use std::collections::hash_map::DefaultHasher;
+use std::hash::{Hash, Hasher};
+
+/// Fixed-size Bloom filter for fast negative membership checks.
+///
+/// `contains` never returns `false` for an inserted item; it may return `true`
+/// for an item that was never inserted, at roughly the configured rate.
+#[derive(Debug, Clone)]
+pub struct BloomFilter {
+    bits: Vec<u64>,
+    num_bits: u64,
+    num_hashes: u32,
+}
+
+impl BloomFilter {
+    /// Size a filter for `expected_items` at the given false-positive rate.
+    pub fn new(expected_items: usize, false_positive_rate: f64) -> Self {
+        let n = expected_items.max(1) as f64;
+        let p = false_positive_rate.clamp(1e-9, 0.5);
+        let ln2 = std::f64::consts::LN_2;
+        let num_bits = ((-n * p.ln()) / (ln2 * ln2)).ceil().max(64.0) as u64;
+        let num_hashes = ((num_bits as f64 / n) * ln2).round().clamp(1.0, 16.0) as u32;
+        Self {
+            bits: vec![0; ((num_bits + 63) / 64) as usize],
+            num_bits,
+            num_hashes,
+        }
+    }
+
+    pub fn insert<T: Hash + ?Sized>(&mut self, item: &T) {
+        let (h1, h2) = hash_pair(item);
+        for i in 0..self.num_hashes {
+            let bit = self.bit_index(h1, h2, i);
+            self.bits[(bit / 64) as usize] |= 1 << (bit % 64);
+        }
+    }
+
+    /// `false` means definitely absent; `true` means probably present.
+    pub fn contains<T: Hash + ?Sized>(&self, item: &T) -> bool {
+        let (h1, h2) = hash_pair(item);
+        (0..self.num_hashes).all(|i| {
+            let bit = self.bit_index(h1, h2, i);
+            self.bits[(bit / 64) as usize] & (1 << (bit % 64)) != 0
+        })
+    }
+
+    /// Kirsch–Mitzenmacher double hashing: `h1 + i·h2` simulates `k` independent hashes.
+    fn bit_index(&self, h1: u64, h2: u64, i: u32) -> u64 {
+        h1.wrapping_add(u64::from(i).wrapping_mul(h2)) % self.num_bits
+    }
+}
+
+impl<'a> FromIterator<&'a str> for BloomFilter {
+    /// Build a filter (1% false positives) from a collected set of strings.
+    fn from_iter<I: IntoIterator<Item = &'a str>>(iter: I) -> Self {
+        let items: Vec<&str> = iter.into_iter().collect();
+        let mut filter = Self::new(items.len(), 0.01);
+        for item in items {
+            filter.insert(item);
+        }
+        filter
+    }
+}
+
+fn hash_pair<T: Hash + ?Sized>(item: &T) -> (u64, u64) {
+    let mut a = DefaultHasher::new();
+    item.hash(&mut a);
+    let h1 = a.finish();
+    let mut b = DefaultHasher::new();
+    h1.hash(&mut b);
+    item.hash(&mut b);
+    // An even step would only ever touch half the bits when `num_bits` is even.
+    (h1, b.finish() | 1)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_inserted_items_are_always_found() {
+        let names: Vec<String> = (0..5000).map(|i| format!("symbol_{i}")).collect();
+        let filter: BloomFilter = names.iter().map(String::as_str).collect();
+        assert!(names.iter().all(|n| filter.contains(n.as_str())));
+    }
+
+    #[test]
+    fn test_false_positive_rate_is_bounded() {
+        let names: Vec<String> = (0..5000).map(|i| format!("symbol_{i}")).collect();
+        let filter: BloomFilter = names.iter().map(String::as_str).collect();
+        let false_positives = (0..10_000)
+            .filter(|i| filter.contains(format!("external_{i}").as_str()))
+            .count();
+        // Configured for 1%; allow generous slack for hash variance.
+        assert!(false_positives < 300, "{false_positives} false positives");
+    }
+
+    #[test]
+    fn test_empty_filter_rejects_everything() {
+        let filter = BloomFilter::new(0, 0.01);
+        assert!(!filter.contains("anything"));
+    }
+}
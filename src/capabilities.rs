@@ -0,0 +1,151 @@
+//! What `cartog serve` may do besides reading the index.
+//!
+//! `--capabilities` lists the operations allowed, comma-separated, or is `all`
+//! (the default) or `none` for a read-only sandbox:
+//!
+//! - `index`: rebuild the code graph or the embeddings, `--watch` included.
+//!   Without it the index is opened read-only.
+//! - `shell`: run processes, meaning git for symbol history and the stale-index
+//!   hint, and hook commands.
+//! - `files`: write files besides the index, meaning the warm snapshot and the
+//!   embedding model cache that semantic search fills on first use.
+//!
+//! Without `shell`, spawning is also switched off for the whole process where
+//! git and hooks are started, so no code path can get around it.
+
+use std::fmt;
+use std::str::FromStr;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use anyhow::{bail, Result};
+use serde::Serialize;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Capability {
+    Index,
+    Shell,
+    Files,
+}
+
+impl Capability {
+    pub const ALL: [Capability; 3] = [Self::Index, Self::Shell, Self::Files];
+
+    pub fn name(self) -> &'static str {
+        match self {
+            Self::Index => "index",
+            Self::Shell => "shell",
+            Self::Files => "files",
+        }
+    }
+}
+
+impl fmt::Display for Capability {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.name())
+    }
+}
+
+impl FromStr for Capability {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match Self::ALL.into_iter().find(|c| c.name() == s) {
+            Some(c) => Ok(c),
+            None => bail!("unknown capability '{s}', expected index, shell or files"),
+        }
+    }
+}
+
+/// The set of capabilities a server runs with.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Capabilities {
+    allowed: [bool; 3],
+}
+
+impl Default for Capabilities {
+    fn default() -> Self {
+        Self { allowed: [true; 3] }
+    }
+}
+
+impl Capabilities {
+    /// The read-only sandbox.
+    pub const NONE: Capabilities = Capabilities {
+        allowed: [false; 3],
+    };
+
+    pub fn allows(self, capability: Capability) -> bool {
+        self.allowed[capability as usize]
+    }
+
+    pub fn allowed(self) -> Vec<Capability> {
+        Capability::ALL
+            .into_iter()
+            .filter(|c| self.allows(*c))
+            .collect()
+    }
+}
+
+impl fmt::Display for Capabilities {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let names: Vec<&str> = self.allowed().into_iter().map(Capability::name).collect();
+        if names.is_empty() {
+            f.write_str("none")
+        } else {
+            f.write_str(&names.join(","))
+        }
+    }
+}
+
+impl FromStr for Capabilities {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s.trim() {
+            "all" => return Ok(Self::default()),
+            "none" | "" => return Ok(Self::NONE),
+            _ => {}
+        }
+        let mut capabilities = Self::NONE;
+        for name in s.split(',') {
+            let capability: Capability = name.trim().parse()?;
+            capabilities.allowed[capability as usize] = true;
+        }
+        Ok(capabilities)
+    }
+}
+
+static SHELL_FORBIDDEN: AtomicBool = AtomicBool::new(false);
+
+/// Refuse to spawn processes for the rest of this process's life.
+pub fn forbid_shell() {
+    SHELL_FORBIDDEN.store(true, Ordering::Relaxed);
+}
+
+pub fn shell_allowed() -> bool {
+    !SHELL_FORBIDDEN.load(Ordering::Relaxed)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_capabilities() {
+        let all: Capabilities = "all".parse().unwrap();
+        assert_eq!(all, Capabilities::default());
+        assert_eq!(all.to_string(), "index,shell,files");
+
+        let none: Capabilities = "none".parse().unwrap();
+        assert!(Capability::ALL.into_iter().all(|c| !none.allows(c)));
+        assert_eq!(none.to_string(), "none");
+
+        let some: Capabilities = "files, index".parse().unwrap();
+        assert!(some.allows(Capability::Index) && some.allows(Capability::Files));
+        assert!(!some.allows(Capability::Shell));
+        assert_eq!(some.allowed(), [Capability::Index, Capability::Files]);
+
+        assert!("index,network".parse::<Capabilities>().is_err());
+    }
+}
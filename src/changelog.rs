@@ -0,0 +1,180 @@
+//! Release-notes draft: symbol additions, removals and signature changes between
+//! two revisions, grouped by package.
+//!
+//! Built on `diff::diff_refs`, so each side is a git revision (indexed in a
+//! temporary worktree, or taken from the snapshot cache) or an index file. Body
+//! changes and imports are left out: they rarely belong in release notes.
+
+use std::collections::BTreeMap;
+use std::fmt::Write as _;
+
+use serde::Serialize;
+
+use crate::diff::{ChangeKind, IndexDiff, SymbolChange};
+use crate::doc::package_of;
+use crate::types::SymbolKind;
+
+/// The changes to one package.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct PackageChanges {
+    pub package: String,
+    pub added: Vec<SymbolChange>,
+    pub removed: Vec<SymbolChange>,
+    pub signature_changed: Vec<SymbolChange>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct Changelog {
+    pub from: String,
+    pub to: String,
+    /// Packages with at least one change, by path.
+    pub packages: Vec<PackageChanges>,
+}
+
+/// Group `diff` by package, `depth` leading path segments (0: full directory).
+pub fn from_diff(diff: &IndexDiff, depth: usize) -> Changelog {
+    let mut packages: BTreeMap<String, PackageChanges> = BTreeMap::new();
+    for change in &diff.symbols {
+        if change.kind == SymbolKind::Import {
+            continue;
+        }
+        let package = package_of(&change.file_path, depth);
+        let entry = packages
+            .entry(package.clone())
+            .or_insert_with(|| PackageChanges {
+                package,
+                ..PackageChanges::default()
+            });
+        match change.change {
+            ChangeKind::Added => entry.added.push(change.clone()),
+            ChangeKind::Removed => entry.removed.push(change.clone()),
+            ChangeKind::SignatureChanged => entry.signature_changed.push(change.clone()),
+            ChangeKind::BodyChanged => {}
+        }
+    }
+    Changelog {
+        from: diff.from.clone(),
+        to: diff.to.clone(),
+        packages: packages
+            .into_values()
+            .filter(|p| {
+                !(p.added.is_empty() && p.removed.is_empty() && p.signature_changed.is_empty())
+            })
+            .collect(),
+    }
+}
+
+/// The changelog as Markdown, one section per package.
+pub fn render_markdown(changelog: &Changelog) -> String {
+    let mut out = format!("## Changes from {} to {}\n", changelog.from, changelog.to);
+    if changelog.packages.is_empty() {
+        out.push_str("\nNo symbols added, removed or re-signed.\n");
+    }
+    let with_signature = |c: &SymbolChange, signature: Option<&str>| {
+        format!(
+            "{} `{}{}`",
+            c.kind,
+            c.qualified_name,
+            signature.unwrap_or("")
+        )
+    };
+    for p in &changelog.packages {
+        let _ = write!(out, "\n### `{}`\n", p.package);
+        if !p.added.is_empty() {
+            out.push_str("\n**Added**\n\n");
+            for c in &p.added {
+                let _ = writeln!(out, "- {}", with_signature(c, c.new_signature.as_deref()));
+            }
+        }
+        if !p.removed.is_empty() {
+            out.push_str("\n**Removed**\n\n");
+            for c in &p.removed {
+                let _ = writeln!(out, "- {}", with_signature(c, c.old_signature.as_deref()));
+            }
+        }
+        if !p.signature_changed.is_empty() {
+            out.push_str("\n**Signature changed**\n\n");
+            for c in &p.signature_changed {
+                let _ = writeln!(
+                    out,
+                    "- {} `{}`: `{}` → `{}`",
+                    c.kind,
+                    c.qualified_name,
+                    c.old_signature.as_deref().unwrap_or(""),
+                    c.new_signature.as_deref().unwrap_or("")
+                );
+            }
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn change(kind: ChangeKind, name: &str, file: &str, old: &str, new: &str) -> SymbolChange {
+        let sig = |s: &str| (!s.is_empty()).then(|| s.to_string());
+        SymbolChange {
+            change: kind,
+            qualified_name: name.to_string(),
+            kind: SymbolKind::Function,
+            file_path: file.to_string(),
+            line: 1,
+            old_signature: sig(old),
+            new_signature: sig(new),
+        }
+    }
+
+    #[test]
+    fn test_changes_grouped_by_package() {
+        let mut import = change(ChangeKind::Added, "fmt", "pay/charge.go", "", "");
+        import.kind = SymbolKind::Import;
+        let diff = IndexDiff {
+            from: "v1.1.0".to_string(),
+            to: "v1.2.0".to_string(),
+            symbols: vec![
+                change(
+                    ChangeKind::Added,
+                    "Refund",
+                    "pay/refund.go",
+                    "",
+                    "(id string) error",
+                ),
+                change(
+                    ChangeKind::SignatureChanged,
+                    "Charge",
+                    "pay/charge.go",
+                    "(amount int) error",
+                    "(ctx context.Context, amount int) error",
+                ),
+                change(ChangeKind::BodyChanged, "Audit", "audit/log.go", "()", "()"),
+                change(ChangeKind::Removed, "Legacy", "api/v0/old.go", "()", ""),
+                import,
+            ],
+            ..IndexDiff::default()
+        };
+        let changelog = from_diff(&diff, 0);
+        let packages: Vec<_> = changelog
+            .packages
+            .iter()
+            .map(|p| {
+                (
+                    p.package.as_str(),
+                    p.added.len(),
+                    p.removed.len(),
+                    p.signature_changed.len(),
+                )
+            })
+            .collect();
+        assert_eq!(packages, [("api/v0", 0, 1, 0), ("pay", 1, 0, 1)]);
+
+        let markdown = render_markdown(&changelog);
+        assert!(markdown.starts_with("## Changes from v1.1.0 to v1.2.0\n"));
+        assert!(markdown.contains("- function `Refund(id string) error`\n"));
+        assert!(markdown.contains(
+            "- function `Charge`: `(amount int) error` → `(ctx context.Context, amount int) error`"
+        ));
+        assert_eq!(from_diff(&diff, 1).packages.len(), 2);
+    }
+}
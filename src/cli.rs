@@ -1,6 +1,13 @@
 use clap::{Parser, Subcommand, ValueEnum};
 
-use crate::types::{EdgeKind, SymbolKind};
+use crate::capabilities::Capabilities;
+use crate::completions::{self, Shell};
+use crate::db::{ComplexityMetric, RankMetric};
+use crate::federation::Mount;
+use crate::hotspots::Granularity;
+use crate::init::McpClient;
+use crate::table::TableFormat;
+use crate::types::{EdgeKind, LogLevel, SymbolKind};
 
 #[derive(Debug, Parser)]
 #[command(name = "cartog")]
@@ -13,16 +20,72 @@ pub struct Cli {
     /// Output as JSON
     #[arg(long, global = true)]
     pub json: bool,
+
+    /// Report SQL statements, query plans, cache hits and per-stage timing on stderr
+    #[arg(long, global = true)]
+    pub explain: bool,
+
+    /// Print long output directly instead of paging it on a terminal
+    #[arg(long, global = true)]
+    pub no_pager: bool,
+
+    /// Print the IDs of the symbols found, one per line, for another command's --stdin-ids
+    #[arg(long, global = true, conflicts_with = "json")]
+    pub ids: bool,
+
+    /// Run the [hooks] of .cartog.toml after indexing. Off by default, since the
+    /// config comes with the repository
+    #[arg(long, global = true)]
+    pub allow_hooks: bool,
+}
+
+impl Command {
+    /// Whether human output may go through the pager: not for commands that
+    /// prompt, run until stopped, report progress, or feed the shell.
+    pub fn pages(&self) -> bool {
+        !matches!(
+            self,
+            Self::Init { .. }
+                | Self::Index { .. }
+                | Self::Watch { .. }
+                | Self::Serve { .. }
+                | Self::Completions { .. }
+                | Self::Complete { .. }
+                | Self::Profile(_)
+                | Self::Bench { .. }
+                | Self::Rag(RagCommand::Index { .. } | RagCommand::Setup)
+        )
+    }
+
+    /// Whether `--ids` applies: the command's result is a list of symbols.
+    pub fn writes_ids(&self) -> bool {
+        matches!(
+            self,
+            Self::Search { .. }
+                | Self::Callees { .. }
+                | Self::Impact { .. }
+                | Self::Refs {
+                    globals_only: false,
+                    ..
+                }
+                | Self::Rank { .. }
+                | Self::Reachable { .. }
+                | Self::Recent { .. }
+                | Self::Untested { .. }
+        )
+    }
 }
 
 /// Filter for symbol kinds in the search command.
 #[derive(Debug, Clone, Copy, ValueEnum)]
 pub enum SymbolKindFilter {
+    #[value(alias = "func")]
     Function,
     Class,
     Method,
     Variable,
     Import,
+    Flag,
 }
 
 impl From<SymbolKindFilter> for SymbolKind {
@@ -33,6 +96,7 @@ impl From<SymbolKindFilter> for SymbolKind {
             SymbolKindFilter::Method => SymbolKind::Method,
             SymbolKindFilter::Variable => SymbolKind::Variable,
             SymbolKindFilter::Import => SymbolKind::Import,
+            SymbolKindFilter::Flag => SymbolKind::Flag,
         }
     }
 }
@@ -59,8 +123,165 @@ impl From<EdgeKindFilter> for EdgeKind {
     }
 }
 
+/// Granularity for the hotspots command.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum HotspotGranularity {
+    Function,
+    Package,
+}
+
+impl From<HotspotGranularity> for Granularity {
+    fn from(g: HotspotGranularity) -> Self {
+        match g {
+            HotspotGranularity::Function => Granularity::Function,
+            HotspotGranularity::Package => Granularity::Package,
+        }
+    }
+}
+
+/// Rendering of `outline --package`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum OutlineFormat {
+    Text,
+    Markdown,
+}
+
+/// Generated mocks and fakes in `hierarchy --doubles`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum DoublesFilter {
+    Include,
+    Exclude,
+    Only,
+}
+
+/// Ranking key for `metrics complexity`.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum ComplexityMetricArg {
+    Cyclomatic,
+    Cognitive,
+}
+
+impl From<ComplexityMetricArg> for ComplexityMetric {
+    fn from(m: ComplexityMetricArg) -> Self {
+        match m {
+            ComplexityMetricArg::Cyclomatic => ComplexityMetric::Cyclomatic,
+            ComplexityMetricArg::Cognitive => ComplexityMetric::Cognitive,
+        }
+    }
+}
+
+/// Ranking key for `rank`.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum RankMetricArg {
+    Pagerank,
+    Betweenness,
+}
+
+impl From<RankMetricArg> for RankMetric {
+    fn from(m: RankMetricArg) -> Self {
+        match m {
+            RankMetricArg::Pagerank => RankMetric::PageRank,
+            RankMetricArg::Betweenness => RankMetric::Betweenness,
+        }
+    }
+}
+
+/// Delimited output of list commands.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum TableFormatArg {
+    Csv,
+    Tsv,
+}
+
+impl From<TableFormatArg> for TableFormat {
+    fn from(f: TableFormatArg) -> Self {
+        match f {
+            TableFormatArg::Csv => TableFormat::Csv,
+            TableFormatArg::Tsv => TableFormat::Tsv,
+        }
+    }
+}
+
+/// Minimum level for `logs --level`.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum LogLevelArg {
+    Trace,
+    Debug,
+    Info,
+    Warn,
+    Error,
+    Fatal,
+    Panic,
+}
+
+impl From<LogLevelArg> for LogLevel {
+    fn from(l: LogLevelArg) -> Self {
+        match l {
+            LogLevelArg::Trace => LogLevel::Trace,
+            LogLevelArg::Debug => LogLevel::Debug,
+            LogLevelArg::Info => LogLevel::Info,
+            LogLevelArg::Warn => LogLevel::Warn,
+            LogLevelArg::Error => LogLevel::Error,
+            LogLevelArg::Fatal => LogLevel::Fatal,
+            LogLevelArg::Panic => LogLevel::Panic,
+        }
+    }
+}
+
+/// MCP client for `init --mcp`.
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum McpClientArg {
+    ClaudeCode,
+    Cursor,
+}
+
+impl From<McpClientArg> for McpClient {
+    fn from(c: McpClientArg) -> Self {
+        match c {
+            McpClientArg::ClaudeCode => McpClient::ClaudeCode,
+            McpClientArg::Cursor => McpClient::Cursor,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, ValueEnum)]
+pub enum ShellArg {
+    Bash,
+    Zsh,
+    Fish,
+}
+
+impl From<ShellArg> for Shell {
+    fn from(s: ShellArg) -> Self {
+        match s {
+            ShellArg::Bash => Shell::Bash,
+            ShellArg::Zsh => Shell::Zsh,
+            ShellArg::Fish => Shell::Fish,
+        }
+    }
+}
+
 #[derive(Debug, Subcommand)]
 pub enum Command {
+    /// Create a .cartog.toml from the languages and layout found in the project
+    Init {
+        /// Project root (defaults to current directory)
+        #[arg(default_value = ".")]
+        path: String,
+
+        /// Accept every proposal without asking
+        #[arg(long, short)]
+        yes: bool,
+
+        /// Replace an existing .cartog.toml
+        #[arg(long)]
+        force: bool,
+
+        /// Also print MCP server setup for this client (repeatable)
+        #[arg(long, value_enum)]
+        mcp: Vec<McpClientArg>,
+    },
+
     /// Build or rebuild the code graph index
     Index {
         /// Directory to index (defaults to current directory)
@@ -70,59 +291,560 @@ pub enum Command {
         /// Force full re-index, bypassing change detection
         #[arg(long)]
         force: bool,
+
+        /// Rebuild from scratch into a new index and swap it in when done, so a
+        /// running `cartog serve` keeps answering from the old one meanwhile
+        #[arg(long)]
+        swap: bool,
+
+        /// List the files that would be indexed and those skipped, with the rule
+        /// responsible, and estimate the index size and build time; writes nothing
+        #[arg(long, conflicts_with = "swap")]
+        dry_run: bool,
+
+        /// Parser threads (defaults to the number of CPUs)
+        #[arg(long)]
+        jobs: Option<usize>,
+
+        /// Memory cap in MiB for parsed files awaiting write; beyond it they spill to disk
+        #[arg(long, default_value = "512")]
+        max_memory: usize,
     },
 
     /// Show symbols and structure of a file
     Outline {
         /// File path to outline
-        file: String,
+        #[arg(required_unless_present = "package", conflicts_with = "package")]
+        file: Option<String>,
+
+        /// Summarize a package instead: exported API, key internal types,
+        /// dependencies and dependents of the files directly in this directory
+        #[arg(long)]
+        package: Option<String>,
+
+        /// Output format for --package
+        #[arg(long, value_enum, default_value = "text", requires = "package")]
+        format: OutlineFormat,
+
+        /// Write the --package summary to this file instead of stdout
+        #[arg(long, requires = "package")]
+        export: Option<String>,
+
+        /// Annotate each symbol with its last author and commit date (git blame)
+        #[arg(long)]
+        with_blame: bool,
+
+        /// Only symbols carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
+
+        /// Mark symbols added, modified or removed since this git revision
+        #[arg(long, value_name = "REV", conflicts_with_all = ["export", "with_blame", "tag"])]
+        diff: Option<String>,
     },
 
     /// Find what a symbol calls
     Callees {
         /// Symbol name to search for
+        #[arg(required_unless_present = "stdin_ids")]
+        name: Option<String>,
+
+        /// Read symbol IDs from stdin (see --ids) in addition to the name
+        #[arg(long)]
+        stdin_ids: bool,
+
+        /// Only callees carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
+    },
+
+    /// Mermaid sequence diagram of the calls a function makes, or of the call
+    /// path to --to
+    Sequence {
+        /// Function or method to start from
         name: String,
+
+        /// Draw only the shortest call path from `name` to this symbol
+        #[arg(long)]
+        to: Option<String>,
+
+        /// Maximum number of calls to follow
+        #[arg(long, default_value = "3")]
+        depth: u32,
     },
 
     /// Transitive impact analysis — what breaks if this changes?
     Impact {
         /// Symbol name to analyze
-        name: String,
+        #[arg(required_unless_present = "stdin_ids")]
+        name: Option<String>,
+
+        /// Read symbol IDs from stdin (see --ids) in addition to the name
+        #[arg(long)]
+        stdin_ids: bool,
 
         /// Maximum depth of transitive analysis
         #[arg(long, default_value = "3")]
         depth: u32,
+
+        /// Only dependents carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
     },
 
     /// All references to a symbol (calls, imports, inherits, references, raises)
     Refs {
         /// Symbol name to search for
-        name: String,
+        #[arg(required_unless_present_any = ["globals_only", "stdin_ids"])]
+        name: Option<String>,
+
+        /// Read symbol IDs from stdin (see --ids) in addition to the name
+        #[arg(long, conflicts_with = "globals_only")]
+        stdin_ids: bool,
 
         /// Filter by edge kind
-        #[arg(long)]
+        #[arg(long, conflicts_with = "globals_only")]
         kind: Option<EdgeKindFilter>,
+
+        /// Annotate each reference with its last author and commit date (git blame)
+        #[arg(long, conflicts_with = "globals_only")]
+        with_blame: bool,
+
+        /// Reads and writes of package-level variables (Go), all or only NAME's
+        #[arg(long)]
+        globals_only: bool,
+
+        /// Only references from symbols carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
     },
 
     /// Show inheritance hierarchy for a class
     Hierarchy {
         /// Class name
         name: String,
+
+        /// Generated mocks and fakes (gomock, mockery, counterfeiter): include, exclude or only them
+        #[arg(long, value_enum, default_value = "include")]
+        doubles: DoublesFilter,
     },
 
-    /// File-level import dependencies
+    /// File-level import dependencies, or `deps usage` for external modules
+    #[command(args_conflicts_with_subcommands = true, subcommand_negates_reqs = true)]
     Deps {
+        #[command(subcommand)]
+        command: Option<DepsCommand>,
+
         /// File path
-        file: String,
+        #[arg(required = true)]
+        file: Option<String>,
     },
 
     /// Index statistics summary
     Stats,
 
+    /// List symbol tags with their counts, or the symbols carrying one tag
+    Tags {
+        /// Tag to list symbols for
+        tag: Option<String>,
+    },
+
+    /// Run a query macro from .cartog.toml (lists macros when no name is given)
+    Macro {
+        /// Macro name
+        name: Option<String>,
+
+        /// Macro arguments, in the order of its `params`
+        args: Vec<String>,
+    },
+
+    /// Check or inspect .cartog.toml files
+    #[command(subcommand)]
+    Config(ConfigCommand),
+
+    /// Check the index against rules declared in .cartog.toml (exits non-zero on violations)
+    #[command(subcommand)]
+    Check(CheckCommand),
+
+    /// Symbol-level diff between two snapshots (git revisions or index files)
+    Diff {
+        /// Old snapshot: git revision (branch, tag, SHA) or path to an index file
+        from: String,
+
+        /// New snapshot: git revision or path to an index file
+        #[arg(default_value = "HEAD")]
+        to: String,
+    },
+
+    /// Release-notes draft: symbols added, removed or re-signed between two
+    /// revisions, grouped by package (Markdown)
+    Changelog {
+        /// Previous release: git revision (tag, branch, SHA) or path to an index file
+        from: String,
+
+        /// New release: git revision or path to an index file
+        #[arg(default_value = "HEAD")]
+        to: String,
+
+        /// Leading path segments that name a package (0: every directory)
+        #[arg(long, default_value = "0")]
+        depth: usize,
+    },
+
+    /// Commits that modified a symbol (git log -L over its line range)
+    History {
+        /// Symbol name (or `Parent.name` to disambiguate methods)
+        name: String,
+
+        /// Maximum commits to list per definition
+        #[arg(long, default_value = "20")]
+        limit: u32,
+    },
+
+    /// Rank code that changes often and is heavily depended upon (churn × fan-in)
+    Hotspots {
+        /// Rank functions/methods or packages (directories)
+        #[arg(long, value_enum, default_value = "function")]
+        by: HotspotGranularity,
+
+        /// Only count commits more recent than this (git --since syntax, e.g. "6 months ago")
+        #[arg(long)]
+        since: Option<String>,
+
+        /// Maximum results to return
+        #[arg(long, default_value = "20")]
+        limit: u32,
+    },
+
+    /// Symbols changed in the last commits, with how many symbols depend on each
+    Recent {
+        /// Number of commits, counted along the first-parent chain of HEAD
+        #[arg(long, default_value = "10")]
+        commits: u32,
+
+        /// Maximum depth of the dependents counted
+        #[arg(long, default_value = "3")]
+        depth: u32,
+    },
+
+    /// Most central symbols: PageRank or betweenness over calls and references, computed at index time
+    Rank {
+        /// Number of symbols to list
+        #[arg(long, default_value = "20")]
+        top: u32,
+
+        /// Filter by symbol kind
+        #[arg(long)]
+        kind: Option<SymbolKindFilter>,
+
+        /// Centrality to rank by
+        #[arg(long, value_enum, default_value = "pagerank")]
+        by: RankMetricArg,
+    },
+
+    /// Find duplicated and near-duplicated functions, grouped around a canonical copy
+    Dupes {
+        /// Ignore functions shorter than this many lines
+        #[arg(long, default_value = "10")]
+        min_lines: u32,
+
+        /// Minimum estimated token overlap (0-1) for near-duplicates
+        #[arg(long, default_value = "0.8")]
+        similarity: f64,
+
+        /// Maximum groups to list
+        #[arg(long, default_value = "20")]
+        limit: u32,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// List channels and sync primitives, or the functions using one (Go)
+    Concurrency {
+        /// Channel, mutex or wait group (e.g. `jobs`, `p.mu`)
+        name: Option<String>,
+    },
+
+    /// Config struct fields with the code using them and the config file keys they load from (Go)
+    ConfigKeys {
+        /// Field, or struct and field (e.g. `RedisHost`, `Config.RedisHost`)
+        name: Option<String>,
+    },
+
+    /// Feature flags and the code that checks them
+    Flags {
+        /// Flag name (e.g. `new-checkout`)
+        name: Option<String>,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// Log statements: level, component and message template, found from a log line
+    Logs {
+        /// A log line or part of a message; matched against templates with their
+        /// placeholders filled in
+        #[arg(long)]
+        grep: Option<String>,
+
+        /// Only statements at this level or above
+        #[arg(long, value_enum)]
+        level: Option<LogLevelArg>,
+
+        /// Maximum number of callers to follow upward from each match (with --grep)
+        #[arg(long, default_value = "3")]
+        depth: u32,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// TODO, FIXME, HACK and XXX comments with their symbol, age and owner, oldest first
+    Todos {
+        /// Only this marker (e.g. `FIXME`)
+        #[arg(long)]
+        marker: Option<String>,
+
+        /// Only comments assigned to, or last changed by, this person (name or email)
+        #[arg(long)]
+        owner: Option<String>,
+
+        /// Only comments last changed at least this many days ago
+        #[arg(long)]
+        older_than: Option<u32>,
+
+        /// Skip git blame: no ages, owners from `TODO(name)` only
+        #[arg(long, conflicts_with = "older_than")]
+        no_blame: bool,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// Import a Go cover profile (`go test -coverprofile`) and attach statement
+    /// coverage to functions and methods
+    Coverage {
+        /// Cover profile to import; replaces any earlier import
+        profile: String,
+    },
+
+    /// Release snapshots of the package graph: record one, list them, or trace a
+    /// dependency across them
+    Snapshot {
+        /// Record the current package graph under this tag (e.g. `v1.2.0`)
+        #[arg(long)]
+        tag: Option<String>,
+
+        /// Show direct dependencies between two packages (or their subpackages)
+        /// in every snapshot and the current index
+        #[arg(long, num_args = 2, value_names = ["FROM", "TO"], conflicts_with = "tag")]
+        depends: Option<Vec<String>>,
+    },
+
+    /// Symbols marked `Deprecated:` with every remaining use and its CODEOWNERS owner
+    Deprecations {
+        /// Only symbols or uses owned by this CODEOWNERS owner (e.g. `@acme/payments`)
+        #[arg(long)]
+        owner: Option<String>,
+
+        /// Store today's totals in the index and show the burndown so far
+        #[arg(long, conflicts_with = "owner")]
+        record: bool,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// Go benchmarks: all of them with what they call, or those reaching a symbol
+    Benchmarks {
+        /// Symbol about to change; lists the benchmarks that call it
+        name: Option<String>,
+
+        /// Maximum calls between a benchmark and the symbol
+        #[arg(long, default_value = "5")]
+        depth: u32,
+    },
+
+    /// Go tests most likely to exercise a symbol, ranked by confidence from
+    /// calls, test names, package and loaded coverage
+    TestsFor {
+        /// Symbol name
+        name: String,
+
+        /// Maximum calls between a test and the symbol
+        #[arg(long, default_value = "3")]
+        depth: u32,
+
+        /// Maximum tests listed
+        #[arg(long, default_value = "10")]
+        limit: usize,
+    },
+
+    /// Exported Go functions and methods no test reaches, most central first
+    Untested {
+        /// Only this package (directory, not its subdirectories)
+        #[arg(long)]
+        package: Option<String>,
+
+        /// Maximum calls between test code and a symbol
+        #[arg(long, default_value = "3")]
+        depth: u32,
+
+        /// Maximum symbols listed
+        #[arg(long, default_value = "30")]
+        limit: usize,
+    },
+
+    /// Guided reading list for new engineers: entry points, core services and
+    /// data models with source excerpts, cut to a token budget (Markdown)
+    Tour {
+        /// Estimated tokens the document may take
+        #[arg(long, default_value = "4000")]
+        budget: u32,
+
+        /// Write the document here instead of stdout (e.g. `docs/tour.md`)
+        #[arg(long)]
+        output: Option<String>,
+    },
+
+    /// HTTP route table: method, path, handler and middleware (Go)
+    Routes {
+        /// Only routes whose path starts with this (e.g. `/api/v1`)
+        prefix: Option<String>,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// Constants of a type with their values, e.g. the members of an iota enum (Go)
+    Const {
+        /// The type, e.g. `PaymentStatus` or `models.PaymentStatus`
+        type_name: String,
+    },
+
+    /// Where values of a type are created: composite literals, `new`, and the
+    /// constructors and factories returning it with their call sites (Go)
+    Constructs {
+        /// The type, e.g. `Store` or `store.Store`
+        type_name: String,
+    },
+
+    /// Go package initialization order: blank imports, variable initializers and
+    /// init functions, with what each calls and writes
+    Inits {
+        /// Only this package (directory) and the packages it imports
+        package: Option<String>,
+
+        /// Also list packages that do nothing at initialization
+        #[arg(long)]
+        all: bool,
+    },
+
+    /// Shortest import chains explaining why one Go package depends on another
+    WhyDepends {
+        /// The depending package: a directory (`.` for the root) or import path
+        from: String,
+
+        /// The package depended on: a directory or any import path, which also
+        /// covers the packages below it
+        to: String,
+
+        /// Maximum chains listed
+        #[arg(long, default_value = "5")]
+        limit: usize,
+    },
+
+    /// Symbols reachable from entry points over calls and references, or the
+    /// unreachable ones (default entries: main and init functions, exported API)
+    Reachable {
+        /// Entry points to start from (repeatable)
+        #[arg(long = "from")]
+        from: Vec<String>,
+
+        /// List what nothing reaches instead
+        #[arg(long)]
+        unreachable: bool,
+
+        /// Write the symbols listed as CSV or TSV rows instead
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+
+    /// Error handling: error propagation and unrecovered panics
+    #[command(subcommand)]
+    Errors(ErrorsCommand),
+
+    /// Code metrics computed at index time
+    #[command(subcommand)]
+    Metrics(MetricsCommand),
+
+    /// Generate documentation from the index
+    #[command(subcommand)]
+    Doc(DocCommand),
+
+    /// Pull-request review helpers
+    #[command(subcommand)]
+    Pr(PrCommand),
+
+    /// Profile an operation: CPU time, heap (heap-profile builds), span timeline and slowest SQL
+    #[command(subcommand)]
+    Profile(ProfileCommand),
+
+    /// Benchmark index time, query latency and index size on the fixture suites
+    Bench {
+        /// Fixtures to run, e.g. `go` or `webapp_go` (repeatable; default: all)
+        #[arg(long = "fixture")]
+        fixtures: Vec<String>,
+
+        /// Benchmark this project instead of the fixtures: a directory or a
+        /// git URL (shallow-cloned). Queries are derived from its index, and
+        /// only time and size are measured.
+        #[arg(long, value_name = "PATH|URL", conflicts_with = "fixtures")]
+        repo: Option<String>,
+
+        /// Directory holding the fixture projects
+        #[arg(long, default_value = "benchmarks/fixtures")]
+        fixtures_dir: String,
+
+        /// Baseline to compare against: a cartog binary, or a `.json` report
+        /// saved with `cartog --json bench`
+        #[arg(long)]
+        baseline: Option<String>,
+
+        /// Previous `cartog --json bench` report to compare against
+        #[arg(long, conflicts_with = "baseline")]
+        compare: Option<String>,
+
+        /// Exit non-zero when index time, p50 latency or accuracy is worse than
+        /// the baseline by more than this, e.g. `10%`
+        #[arg(long, value_name = "PERCENT", value_parser = crate::bench::parse_percent)]
+        fail_on_regression: Option<f64>,
+
+        /// Timed runs of each query
+        #[arg(long, default_value = "20")]
+        iterations: u32,
+
+        /// Full index runs per fixture (median is reported)
+        #[arg(long, default_value = "3")]
+        index_runs: u32,
+
+        /// Also time incremental re-indexing after changing this many files
+        #[arg(long, value_name = "N", default_value = "0")]
+        mutate: usize,
+    },
+
     /// Search symbols by name (case-insensitive prefix + substring match)
     Search {
-        /// Query string to match against symbol names
-        query: String,
+        /// Query string to match against symbol names (optional with --uncovered)
+        #[arg(required_unless_present = "uncovered")]
+        query: Option<String>,
 
         /// Filter by symbol kind
         #[arg(long)]
@@ -132,11 +854,61 @@ pub enum Command {
         #[arg(long)]
         file: Option<String>,
 
+        /// Only symbols carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
+
+        /// Only functions and methods with at least this cyclomatic complexity
+        #[arg(long)]
+        min_complexity: Option<u32>,
+
+        /// Only symbols in files directly inside this directory (a Go package)
+        #[arg(long)]
+        package: Option<String>,
+
+        /// Only functions and methods no test ran (see `cartog coverage`)
+        #[arg(long)]
+        uncovered: bool,
+
         /// Maximum results to return (default: 30, max: 100)
         #[arg(long, default_value = "30")]
         limit: u32,
     },
 
+    /// grep -F that answers identifiers from the index and scans only what it doesn't cover
+    Grep {
+        /// Literal string to find
+        pattern: String,
+
+        /// Files or directories to search
+        #[arg(default_value = ".")]
+        paths: Vec<String>,
+
+        /// Ignore case
+        #[arg(short, long)]
+        ignore_case: bool,
+
+        /// Match whole words only
+        #[arg(short, long)]
+        word_regexp: bool,
+
+        /// Print only the paths of files with a match
+        #[arg(short = 'l', long)]
+        files_with_matches: bool,
+
+        /// Read every file instead of asking the index
+        #[arg(long)]
+        scan: bool,
+
+        /// Accepted for grep compatibility; the search is always recursive
+        #[arg(short, hide = true)]
+        recursive: bool,
+
+        /// Accepted for grep compatibility; line numbers are always printed
+        #[arg(short = 'n', hide = true)]
+        line_number: bool,
+    },
+
     /// Watch for file changes and auto-re-index
     Watch {
         /// Directory to watch (defaults to current directory)
@@ -165,11 +937,307 @@ pub enum Command {
         /// Enable automatic RAG embedding when watching
         #[arg(long)]
         rag: bool,
+
+        /// MiB of the index to memory-map (default: twice the index size, min 256; 0 disables)
+        #[arg(long)]
+        mmap: Option<u64>,
+
+        /// Read the whole index once at startup so early queries don't page-fault
+        #[arg(long)]
+        prewarm: bool,
+
+        /// Serve many MCP clients on this TCP address (e.g. 127.0.0.1:7777) instead of stdio
+        #[arg(long, value_name = "ADDR")]
+        listen: Option<std::net::SocketAddr>,
+
+        /// Require a bearer token from a file of `<token> <read|admin> [name]` lines
+        #[arg(long, value_name = "FILE", requires = "listen")]
+        tokens: Option<String>,
+
+        /// Serve over TLS with this PEM certificate chain
+        #[arg(long, value_name = "FILE", requires_all = ["listen", "tls_key"])]
+        tls_cert: Option<String>,
+
+        /// PEM private key of --tls-cert
+        #[arg(long, value_name = "FILE", requires = "tls_cert")]
+        tls_key: Option<String>,
+
+        /// Require client certificates signed by this PEM CA (mutual TLS)
+        #[arg(long, value_name = "FILE", requires = "tls_cert")]
+        tls_client_ca: Option<String>,
+
+        /// Queries per second each client may make (a token's qps= overrides it)
+        #[arg(long, requires = "listen")]
+        qps: Option<f64>,
+
+        /// Queries each client may run at once (a token's concurrent= overrides it)
+        #[arg(long, requires = "listen")]
+        max_concurrent: Option<u32>,
+
+        /// What the server may do besides reading the index: all, none, or a list of index,shell,files
+        #[arg(long, value_name = "LIST", default_value = "all")]
+        capabilities: Capabilities,
+
+        /// Serve Prometheus metrics at http://ADDR/metrics (e.g. 127.0.0.1:9464)
+        #[arg(long, value_name = "ADDR")]
+        metrics: Option<std::net::SocketAddr>,
+
+        /// Log every answered tool call (client, tool, args, result size) to this JSONL file
+        #[arg(long, value_name = "FILE")]
+        audit: Option<String>,
+
+        /// MiB the audit log may reach before it is rotated
+        #[arg(long, default_value = "64", requires = "audit")]
+        audit_max_mib: u64,
+
+        /// Rotated audit logs kept (FILE.1 is the newest)
+        #[arg(long, default_value = "5", requires = "audit")]
+        audit_keep: u32,
+
+        /// Also serve the index of another repository under a namespace (repeatable);
+        /// tools select it with `repo`
+        #[arg(long = "mount", value_name = "NAME=PATH")]
+        mounts: Vec<Mount>,
     },
 
     /// Semantic code search (RAG pipeline)
     #[command(subcommand)]
     Rag(RagCommand),
+
+    /// Print a shell completion script; symbol arguments complete from the index
+    Completions {
+        #[arg(value_enum)]
+        shell: ShellArg,
+    },
+
+    /// Symbol names starting with a prefix, one per line, for shell completion
+    #[command(hide = true)]
+    Complete {
+        #[arg(default_value = "")]
+        prefix: String,
+
+        #[arg(long, default_value_t = completions::DEFAULT_COMPLETIONS)]
+        limit: u32,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum ErrorsCommand {
+    /// Show how errors from a function propagate up its callers
+    Trace {
+        /// Function or method name (e.g. `Pool.GetConnection`)
+        name: String,
+
+        /// Maximum number of callers to follow upward
+        #[arg(long, default_value = "5")]
+        depth: u32,
+    },
+
+    /// Show where a Go error type or sentinel is created, returned, wrapped,
+    /// checked with errors.Is/As, and handled
+    Lifecycle {
+        /// Error type or sentinel variable (e.g. `ExpiredTokenError`, `ErrNotFound`)
+        name: String,
+
+        /// Maximum number of callers to follow upward from where it is created
+        #[arg(long, default_value = "5")]
+        depth: u32,
+    },
+
+    /// List call paths from entry points to a panic with no recover on the way
+    Panics {
+        /// Entry points to start from (repeatable; default: functions nothing calls)
+        #[arg(long = "from")]
+        from: Vec<String>,
+
+        /// Start from the functions carrying this tag
+        #[arg(long, conflicts_with = "from")]
+        tag: Option<String>,
+
+        /// Maximum number of calls to follow
+        #[arg(long, default_value = "10")]
+        depth: u32,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum MetricsCommand {
+    /// Rank functions and methods by cyclomatic or cognitive complexity
+    Complexity {
+        /// Number of functions to list
+        #[arg(long, default_value = "20")]
+        top: u32,
+
+        /// Metric to rank by
+        #[arg(long, value_enum, default_value = "cognitive")]
+        by: ComplexityMetricArg,
+
+        /// Only functions in this file
+        #[arg(long)]
+        file: Option<String>,
+
+        /// Write CSV or TSV rows instead, for spreadsheets
+        #[arg(long, value_enum)]
+        format: Option<TableFormatArg>,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum DepsCommand {
+    /// External modules: importing files, call counts and most called APIs
+    Usage {
+        /// Show only modules whose path contains this
+        module: Option<String>,
+
+        /// Include the Go standard library
+        #[arg(long)]
+        std: bool,
+
+        /// Maximum number of modules
+        #[arg(long, default_value = "30")]
+        limit: usize,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum DocCommand {
+    /// Architecture overview in Markdown: packages, dependency diagram (Mermaid),
+    /// key types and entry points
+    Architecture {
+        /// Write the document here instead of stdout (e.g. `docs/architecture.md`)
+        #[arg(long)]
+        output: Option<String>,
+
+        /// Leading path segments that name a package (0: every directory)
+        #[arg(long, default_value = "2")]
+        depth: usize,
+
+        /// Number of most referenced types to list
+        #[arg(long, default_value = "15")]
+        key_types: usize,
+    },
+
+    /// Glossary of the most referenced types in Markdown: doc comments and how
+    /// they use each other
+    Glossary {
+        /// Write the document here instead of stdout (e.g. `docs/glossary.md`)
+        #[arg(long)]
+        output: Option<String>,
+
+        /// Number of terms
+        #[arg(long, default_value = "30")]
+        limit: usize,
+    },
+
+    /// Dependencies section for a README: package graph (Mermaid), package
+    /// dependency table and most imported external modules
+    Dependencies {
+        /// Rewrite the section between the cartog:dependencies markers in this file
+        /// (appended when the markers are missing)
+        #[arg(long)]
+        update: Option<String>,
+
+        /// With --update, change nothing and fail if the section is out of date
+        #[arg(long, requires = "update")]
+        check: bool,
+
+        /// Leading path segments that name a package (0: every directory)
+        #[arg(long, default_value = "2")]
+        depth: usize,
+
+        /// Number of external modules to list
+        #[arg(long, default_value = "10")]
+        externals: usize,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum ConfigCommand {
+    /// Check every .cartog.toml for errors, unknown keys, conflicts and dead globs,
+    /// then print the resolved configuration
+    Validate {
+        /// Project root (defaults to current directory)
+        #[arg(default_value = ".")]
+        path: String,
+    },
+
+    /// Print the JSON Schema of .cartog.toml, for editor completion and CI checks
+    Schema,
+}
+
+#[derive(Debug, Subcommand)]
+pub enum CheckCommand {
+    /// Report edges that break the [[arch.rules]] dependency boundaries
+    Arch,
+
+    /// Report Go functions that do I/O or start a fresh context while a caller has one
+    Ctx {
+        /// Maximum number of context-less callers to look through
+        #[arg(long, default_value = "5")]
+        depth: u32,
+    },
+
+    /// List the uses of deprecated symbols, in the project and its Go dependencies,
+    /// with counts per package (exits non-zero above --max)
+    Deprecated {
+        /// Directory of a Go module the project depends on (its index, or the
+        /// sources, which are indexed in memory); repeatable
+        #[arg(long = "dep", value_name = "PATH")]
+        deps: Vec<String>,
+
+        /// Number of uses tolerated before failing
+        #[arg(long, default_value = "0")]
+        max: u32,
+    },
+
+    /// List Go uses of unsafe, reflect and //go:linkname with their callers
+    Unsafe {
+        /// Levels of callers to show above each use
+        #[arg(long, default_value = "2")]
+        depth: u32,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum PrCommand {
+    /// Index head and base, diff them and precompute the impact of every change
+    Prepare {
+        /// Base revision the pull request targets (e.g. `main`, `origin/main`)
+        base: String,
+
+        /// Maximum impact depth for changed symbols
+        #[arg(long, default_value = "3")]
+        depth: u32,
+    },
+}
+
+#[derive(Debug, Subcommand)]
+pub enum ProfileCommand {
+    /// Profile an index run
+    Index {
+        /// Directory to index (defaults to current directory)
+        #[arg(default_value = ".")]
+        path: String,
+
+        /// Force full re-index, ignoring cache
+        #[arg(long)]
+        force: bool,
+
+        /// Output directory (default: .cartog/profile/<timestamp>-index)
+        #[arg(long)]
+        out: Option<String>,
+    },
+
+    /// Profile a query command, e.g. `cartog profile query -- refs validate_token`
+    Query {
+        /// Output directory (default: .cartog/profile/<timestamp>-query)
+        #[arg(long)]
+        out: Option<String>,
+
+        /// The cartog command to profile, with its arguments
+        #[arg(required = true, trailing_var_arg = true, allow_hyphen_values = true)]
+        args: Vec<String>,
+    },
 }
 
 #[derive(Debug, Subcommand)]
@@ -197,6 +1265,10 @@ pub enum RagCommand {
         #[arg(long)]
         kind: Option<SymbolKindFilter>,
 
+        /// Only symbols carrying this tag (see [tags] in .cartog.toml)
+        #[arg(long)]
+        tag: Option<String>,
+
         /// Maximum results to return
         #[arg(long, default_value = "10")]
         limit: u32,
@@ -1,22 +1,108 @@
+use std::collections::{BTreeMap, BTreeSet, HashSet};
 use std::path::{Path, PathBuf};
 use std::time::Duration;
 
 use anyhow::{Context, Result};
 use serde::Serialize;
 
-use crate::cli::{EdgeKindFilter, SymbolKindFilter};
-use crate::db::{Database, DB_FILE, MAX_SEARCH_LIMIT};
-use crate::indexer;
+use crate::arch;
+use crate::bench::{self, BenchConfig, BenchReport};
+use crate::benchmarks;
+use crate::changelog;
+use crate::cli::{
+    Cli, ComplexityMetricArg, DoublesFilter, EdgeKindFilter, HotspotGranularity, LogLevelArg,
+    OutlineFormat, RankMetricArg, SymbolKindFilter, TableFormatArg,
+};
+use crate::completions::{self, Shell};
+use crate::config::{self, Breach, ProjectConfig, CONFIG_FILE};
+use crate::config_keys;
+use crate::constructs;
+use crate::coverage;
+use crate::ctx;
+use crate::db::{Database, SearchFilter, DB_FILE, MAX_SEARCH_LIMIT};
+use crate::deprecations;
+use crate::deps_usage;
+use crate::diff::{self, ChangeKind};
+use crate::doc;
+use crate::dupes;
+use crate::errors::{self, ErrorStep};
+use crate::explain::{self, ExplainReport};
+use crate::git::{self, BlameInfo};
+use crate::grep::{self, GrepMode, GrepOptions, Source};
+use crate::history::{self, BlameCache};
+use crate::hotspots;
+use crate::indexer::{self, SkipReason};
+use crate::init::{self, McpClient, Plan};
+use crate::inits;
+use crate::languages::detect_language;
+use crate::logs::{self, CallStep};
+use crate::macros;
+use crate::otel;
+use crate::owners::CodeOwners;
+use crate::pager;
+use crate::panics;
+use crate::pipe::{self, Target};
+use crate::pipeline::PipelineConfig;
+use crate::pr;
+use crate::profile::{self, CpuTime, ProfileReport, SpanTrace};
 use crate::rag;
-use crate::types::{EdgeKind, SymbolKind};
+use crate::reachable;
+use crate::recent;
+use crate::sequence;
+use crate::snapshot;
+use crate::stdlib::{self, StdSymbol};
+use crate::table;
+use crate::tests_for;
+use crate::todos::{self, TodoFilter};
+use crate::tour;
+use crate::types::{
+    Centrality, Complexity, Constant, Coverage, Edge, EdgeKind, Route, Symbol, SymbolKind,
+    SyncSite, VariableAccess,
+};
+use crate::unsafe_audit;
+use crate::untested;
+use crate::validate::{self, Severity};
+use crate::vendor;
 use crate::watch::{self, WatchConfig};
+use crate::why_depends;
+use crate::wire::{self, WireSite};
 
 fn open_db() -> Result<Database> {
-    Database::open(DB_FILE).context("Failed to open cartog database")
+    let db = Database::open(DB_FILE).context("Failed to open cartog database")?;
+    if explain::is_enabled() {
+        db.enable_explain();
+    }
+    if otel::is_enabled() {
+        db.enable_otel();
+    }
+    explain::mark("open");
+    Ok(db)
+}
+
+/// Open the database for a query, warning on stderr if the index was built
+/// from a different branch or commit than the current checkout.
+fn open_query_db() -> Result<Database> {
+    let db = open_db()?;
+    if let Ok(Some(stale)) = indexer::staleness(&db, Path::new(".")) {
+        eprintln!("warning: {stale}");
+    }
+    explain::mark("staleness");
+    Ok(db)
+}
+
+/// A line of code from `file`, highlighted when it is headed for the pager.
+fn snippet(file: &str, line: &str) -> String {
+    if pager::color() {
+        pager::highlight(line, detect_language(Path::new(file)))
+    } else {
+        line.to_string()
+    }
 }
 
 /// Print `data` as pretty JSON if `json` is true, otherwise call `human_fmt`.
 fn output<T: Serialize>(data: &T, json: bool, human_fmt: impl FnOnce(&T)) -> Result<()> {
+    explain::mark("query");
+    let _format = tracing::info_span!("format", json).entered();
     if json {
         println!("{}", serde_json::to_string_pretty(data)?);
     } else {
@@ -25,16 +111,119 @@ fn output<T: Serialize>(data: &T, json: bool, human_fmt: impl FnOnce(&T)) -> Res
     Ok(())
 }
 
+#[derive(Serialize)]
+struct InitReport {
+    path: String,
+    plan: Plan,
+    diagnostics: Vec<validate::Diagnostic>,
+    mcp: Vec<String>,
+}
+
+/// Write a starter `.cartog.toml`, asking about each proposal unless `yes`.
+pub fn cmd_init(
+    path: &str,
+    yes: bool,
+    force: bool,
+    mut clients: Vec<McpClient>,
+    json: bool,
+) -> Result<()> {
+    use std::io::IsTerminal;
+
+    let root = Path::new(path);
+    let config_path = root.join(CONFIG_FILE);
+    anyhow::ensure!(
+        force || !config_path.exists(),
+        "{} already exists (use --force to replace it)",
+        config_path.display()
+    );
+    if let Ok(user) = config::user_config() {
+        for client in user.editor.mcp {
+            if !clients.contains(&client) {
+                clients.push(client);
+            }
+        }
+    }
+    let mut plan = Plan::detect(root);
+    if !yes {
+        let stdin = std::io::stdin();
+        anyhow::ensure!(
+            stdin.is_terminal(),
+            "stdin is not a terminal; pass --yes to accept every proposal"
+        );
+        // Prompts go to stderr so `--json` output stays parseable.
+        for client in init::interview(&mut plan, &mut stdin.lock(), &mut std::io::stderr())? {
+            if !clients.contains(&client) {
+                clients.push(client);
+            }
+        }
+    }
+    let written = init::write(root, &plan.render(), force)?;
+    let diagnostics = validate::validate(root)?.diagnostics;
+
+    let report = InitReport {
+        path: written.display().to_string(),
+        plan,
+        diagnostics,
+        mcp: clients.into_iter().map(init::mcp_snippet).collect(),
+    };
+    output(&report, json, |r| {
+        println!("Wrote {}", r.path);
+        if r.plan.is_empty() {
+            println!("  no generated code, tests or fixtures found; edit it to taste");
+        }
+        for p in &r.plan.ignore {
+            println!("  ignore      {} ({} files)", p.pattern, p.files);
+        }
+        for p in &r.plan.tests {
+            println!("  rank down   {} ({} files)", p.pattern, p.files);
+        }
+        for p in &r.plan.fixtures {
+            println!("  symbols     {} ({} files)", p.pattern, p.files);
+        }
+        for d in &r.diagnostics {
+            println!("  {:?}: {} {}", d.severity, d.key, d.message);
+        }
+        for snippet in &r.mcp {
+            println!("\n{snippet}");
+        }
+        println!("Next: cartog index");
+    })
+}
+
 /// Build or rebuild the code graph index.
-pub fn cmd_index(path: &str, force: bool, json: bool) -> Result<()> {
+pub fn cmd_index(
+    path: &str,
+    force: bool,
+    swap: bool,
+    jobs: Option<usize>,
+    max_memory_mib: usize,
+    json: bool,
+) -> Result<()> {
     let root = Path::new(path);
-    let db = open_db()?;
 
-    let result = indexer::index_directory(&db, root, force)?;
+    let mut config = PipelineConfig {
+        memory_cap: max_memory_mib.saturating_mul(1024 * 1024),
+        ..PipelineConfig::default()
+    };
+    if let Some(jobs) = jobs {
+        config.jobs = jobs;
+    }
+    let result = if swap {
+        indexer::index_generation(Path::new(DB_FILE), |db| {
+            indexer::index_directory_with(db, root, true, &config)
+        })?
+    } else {
+        indexer::index_directory_with(&open_db()?, root, force, &config)?
+    };
 
     output(&result, json, |r| {
+        let restored = if r.files_restored > 0 {
+            format!(", {} restored from the parse cache", r.files_restored)
+        } else {
+            String::new()
+        };
         println!(
-            "Indexed {} files ({} skipped, {} removed)",
+            "Indexed {} files ({} skipped, {} removed{restored})",
             r.files_indexed, r.files_skipped, r.files_removed
         );
         println!(
@@ -44,28 +233,98 @@ pub fn cmd_index(path: &str, force: bool, json: bool) -> Result<()> {
     })
 }
 
+/// Report what `cartog index` would include and skip, without indexing.
+pub fn cmd_index_plan(path: &str, jobs: Option<usize>, json: bool) -> Result<()> {
+    let jobs = jobs.unwrap_or_else(|| PipelineConfig::default().jobs);
+    let plan = indexer::plan_index(Path::new(path), jobs)?;
+
+    output(&plan, json, |p| {
+        let mib = |bytes: u64| bytes as f64 / (1024.0 * 1024.0);
+        let mut languages: BTreeMap<&str, usize> = BTreeMap::new();
+        for file in &p.included {
+            println!("  index  {}", file.path);
+            *languages.entry(file.language.as_str()).or_default() += 1;
+        }
+        let mut unsupported = 0;
+        for skip in &p.skipped {
+            let why = match skip.reason {
+                SkipReason::Unsupported => {
+                    unsupported += 1;
+                    continue;
+                }
+                SkipReason::BuiltinDir => "always skipped",
+                SkipReason::Ignored => "ignored by",
+                SkipReason::LanguageDisabled => "language disabled:",
+            };
+            match &skip.rule {
+                Some(rule) => println!("  skip   {}  ({why} {rule})", skip.path),
+                None => println!("  skip   {}  ({why})", skip.path),
+            }
+        }
+        let by_language: Vec<String> = languages
+            .iter()
+            .map(|(lang, n)| format!("{lang} {n}"))
+            .collect();
+        println!(
+            "Would index {} files, {:.1} MiB ({})",
+            p.included.len(),
+            mib(p.included_bytes),
+            by_language.join(", ")
+        );
+        if unsupported > 0 {
+            println!("  {unsupported} files in no supported language skipped (--json lists them)");
+        }
+        println!(
+            "  estimated index ~{:.1} MiB, built in ~{:.1}s on {} parser threads",
+            mib(p.estimated_index_bytes),
+            p.estimated_seconds,
+            p.jobs
+        );
+    })
+}
+
 /// Show symbols and structure of a file.
-pub fn cmd_outline(file: &str, json: bool) -> Result<()> {
-    let db = open_db()?;
-    let symbols = db.outline(file)?;
+pub fn cmd_outline(file: &str, with_blame: bool, tag: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let tagged = db.tag_filter(tag)?;
+    let mut symbols = db.outline(file)?;
+    symbols.retain(|sym| tagged.keeps(&sym.id));
+    if let Some(first) = db.syntax_errors(file)?.first() {
+        eprintln!(
+            "warning: {file} has syntax errors (first at line {}: {}); symbols may be incomplete",
+            first.line, first.message
+        );
+    }
 
-    output(&symbols, json, |syms| {
+    let mut blame = with_blame.then(|| BlameCache::new(Path::new(".")));
+    let blamed: Vec<WithBlame<'_, Symbol>> = symbols
+        .iter()
+        .map(|sym| WithBlame {
+            item: sym,
+            blame: blame
+                .as_mut()
+                .and_then(|b| b.last_change(&sym.file_path, sym.start_line, sym.end_line)),
+        })
+        .collect();
+
+    output(&blamed, json, |syms| {
         if syms.is_empty() {
             println!("No symbols found in {file}");
             return;
         }
-        for sym in syms {
+        for WithBlame { item: sym, blame } in syms {
             let indent = if sym.parent_id.is_some() { "  " } else { "" };
             let async_prefix = if sym.is_async { "async " } else { "" };
+            let suffix = blame_suffix(blame.as_ref());
             match sym.kind {
                 SymbolKind::Import => {
                     let text = sym.signature.as_deref().unwrap_or(&sym.name);
-                    println!("{indent}{text}  L{}", sym.start_line);
+                    println!("{indent}{text}  L{}{suffix}", sym.start_line);
                 }
                 _ => {
                     let sig = sym.signature.as_deref().unwrap_or("");
                     println!(
-                        "{indent}{async_prefix}{kind} {name}{sig}  L{start}-{end}",
+                        "{indent}{async_prefix}{kind} {name}{sig}  L{start}-{end}{suffix}",
                         kind = sym.kind,
                         name = sym.name,
                         start = sym.start_line,
@@ -77,77 +336,466 @@ pub fn cmd_outline(file: &str, json: bool) -> Result<()> {
     })
 }
 
-/// Find what a symbol calls.
-pub fn cmd_callees(name: &str, json: bool) -> Result<()> {
-    let db = open_db()?;
-    let edges = db.callees(name)?;
+/// Outline of a file or package with symbols changed since `rev` marked.
+pub fn cmd_outline_diff(
+    rev: &str,
+    file: Option<&str>,
+    package: Option<&str>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let package = package.map(|p| p.trim_end_matches('/'));
+    let in_scope = |path: &str| match (file, package) {
+        (Some(file), _) => path == file,
+        (None, Some(package)) => doc::package_of(path, 0) == package,
+        (None, None) => false,
+    };
+    let mut outline = diff::with_snapshot_db(Path::new("."), rev, |old| {
+        diff::outline_against(old, &db, in_scope)
+    })?;
+    outline.rev = rev.to_string();
 
-    output(&edges, json, |edges| {
-        if edges.is_empty() {
+    output(&outline, json, |o| {
+        let modified =
+            o.count(|c| matches!(c, ChangeKind::SignatureChanged | ChangeKind::BodyChanged));
+        println!(
+            "since {rev}: {} added, {modified} modified, {} removed",
+            o.count(|c| c == ChangeKind::Added),
+            o.removed.len(),
+        );
+        let files: BTreeSet<&str> = o
+            .symbols
+            .iter()
+            .map(|e| e.symbol.file_path.as_str())
+            .chain(o.removed.iter().map(|c| c.file_path.as_str()))
+            .collect();
+        if files.is_empty() {
+            println!(
+                "No symbols found in {}",
+                file.or(package).unwrap_or_default()
+            );
+        }
+        for path in files {
+            println!("{path}");
+            for e in o.symbols.iter().filter(|e| e.symbol.file_path == path) {
+                let sym = &e.symbol;
+                let marker = e.change.map_or(' ', |c| c.marker());
+                let indent = if sym.parent_id.is_some() { "  " } else { "" };
+                match sym.kind {
+                    SymbolKind::Import => {
+                        let text = sym.signature.as_deref().unwrap_or(&sym.name);
+                        println!("{marker} {indent}{text}  L{}", sym.start_line);
+                    }
+                    _ => println!(
+                        "{marker} {indent}{kind} {name}{sig}  L{start}-{end}",
+                        kind = sym.kind,
+                        name = sym.name,
+                        sig = sym.signature.as_deref().unwrap_or(""),
+                        start = sym.start_line,
+                        end = sym.end_line,
+                    ),
+                }
+            }
+            for c in o.removed.iter().filter(|c| c.file_path == path) {
+                println!(
+                    "- {kind} {name}{sig}  (was L{line})",
+                    kind = c.kind,
+                    name = c.qualified_name,
+                    sig = c.old_signature.as_deref().unwrap_or(""),
+                    line = c.line,
+                );
+            }
+        }
+    })
+}
+
+/// Print the dependencies section, or bring the one in `update` up to date.
+pub fn cmd_doc_dependencies(
+    update: Option<&str>,
+    check: bool,
+    depth: usize,
+    externals: usize,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let dependencies = doc::dependencies(&db, depth)?;
+    let externals = doc::externals(&db, externals)?;
+    if json {
+        let data = serde_json::json!({
+            "dependencies": dependencies,
+            "externals": externals,
+        });
+        println!("{}", serde_json::to_string_pretty(&data)?);
+        return Ok(());
+    }
+    let body = doc::render_dependencies(&dependencies, &externals);
+    let Some(path) = update else {
+        print!("{body}");
+        return Ok(());
+    };
+    let current = match std::fs::read_to_string(path) {
+        Ok(text) => text,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound && !check => String::new(),
+        Err(e) => return Err(e).with_context(|| format!("cannot read {path}")),
+    };
+    let updated = doc::replace_section(&current, &body)?;
+    if updated == current {
+        eprintln!("{path}: dependencies section is up to date");
+    } else if check {
+        anyhow::bail!("{path}: dependencies section is out of date; run `cartog doc dependencies --update {path}`");
+    } else {
+        std::fs::write(path, updated).with_context(|| format!("cannot write {path}"))?;
+        eprintln!("{path}: dependencies section updated");
+    }
+    Ok(())
+}
+
+/// Summary of one package, printed or written to `export`.
+pub fn cmd_outline_package(
+    package: &str,
+    format: OutlineFormat,
+    export: Option<&str>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let summary = doc::package_summary(&db, package)?;
+    anyhow::ensure!(
+        !summary.files.is_empty(),
+        "no indexed files directly in {package}"
+    );
+    let rendered = if json {
+        serde_json::to_string_pretty(&summary)?
+    } else if format == OutlineFormat::Markdown {
+        doc::render_package_markdown(&summary)
+    } else {
+        doc::render_package_text(&summary)
+    };
+    match export {
+        Some(path) => {
+            write_document(path, &rendered)?;
+            eprintln!("Wrote {path}");
+        }
+        None => print!("{rendered}"),
+    }
+    Ok(())
+}
+
+/// A query result annotated with its last git change (`--with-blame`).
+#[derive(Serialize)]
+struct WithBlame<'a, T: Serialize> {
+    #[serde(flatten)]
+    item: &'a T,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    blame: Option<BlameInfo>,
+}
+
+/// `"  (author, date, sha)"` for human output, empty without blame.
+fn blame_suffix(blame: Option<&BlameInfo>) -> String {
+    match blame {
+        Some(b) => format!(
+            "  ({author}, {date}, {short})",
+            author = b.author,
+            date = b.date,
+            short = &b.sha[..b.sha.len().min(8)],
+        ),
+        None => String::new(),
+    }
+}
+
+/// What a query runs for: `name`, then the symbols of the IDs on stdin with
+/// `stdin_ids`.
+pub fn query_targets(name: Option<String>, stdin_ids: bool) -> Result<Vec<Target>> {
+    let mut targets: Vec<Target> = name.into_iter().map(Target::Name).collect();
+    if stdin_ids {
+        let db = open_query_db()?;
+        targets.extend(pipe::id_targets(&db, &pipe::read_stdin_ids()?)?);
+    }
+    Ok(targets)
+}
+
+/// The targets of a query, for messages.
+fn targets_label(targets: &[Target]) -> String {
+    let labels: Vec<&str> = targets.iter().map(Target::as_str).collect();
+    labels.join("', '")
+}
+
+/// Find what the `targets` call.
+pub fn cmd_callees(targets: &[Target], tag: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let name = targets_label(targets);
+    let mut edges = Vec::new();
+    for target in targets {
+        edges.extend(match target {
+            Target::Name(name) => db.callees(name)?,
+            Target::Id(id) => db.callees_of_id(id)?,
+        });
+    }
+    if tag.is_some() {
+        // Unresolved callees have no symbol, so they carry no tags.
+        let tagged = db.tag_filter(tag)?;
+        edges.retain(|e| e.target_id.as_deref().is_some_and(|id| tagged.keeps(id)));
+    }
+    if pipe::ids_enabled() {
+        return pipe::write_ids(edges.iter().filter_map(|e| e.target_id.as_deref()));
+    }
+
+    // Unresolved calls into the standard library, with their documentation.
+    let go_mod = cwd_go_mod();
+    let mut imports = BTreeMap::new();
+    let mut callees = Vec::with_capacity(edges.len());
+    for edge in &edges {
+        let stdlib = match edge.target_id {
+            Some(_) => None,
+            None => {
+                if !imports.contains_key(&edge.file_path) {
+                    let found = stdlib::file_imports(&db, &edge.file_path, &go_mod)?;
+                    imports.insert(edge.file_path.clone(), found);
+                }
+                stdlib::call_target(&edge.target_name, &imports[&edge.file_path])
+            }
+        };
+        callees.push(Callee { edge, stdlib });
+    }
+
+    output(&callees, json, |callees| {
+        if callees.is_empty() {
             println!("No callees found for '{name}'");
             return;
         }
-        for edge in edges {
+        for Callee { edge, stdlib } in callees {
+            // Resolved into a vendored dependency.
+            let tier = if edge.target_id.as_deref().is_some_and(vendor::is_vendored) {
+                "  [external]"
+            } else {
+                ""
+            };
             println!(
-                "{target}  {file}:{line}",
+                "{target}  {file}:{line}{tier}",
                 target = edge.target_name,
                 file = edge.file_path,
                 line = edge.line,
             );
+            if let Some(std) = stdlib {
+                print_std_symbol(std);
+            }
         }
     })
 }
 
-/// Transitive impact analysis — what breaks if this changes?
-pub fn cmd_impact(name: &str, depth: u32, json: bool) -> Result<()> {
-    let db = open_db()?;
-    let results = db.impact(name, depth)?;
+#[derive(Serialize)]
+struct Callee<'a> {
+    #[serde(flatten)]
+    edge: &'a Edge,
+    /// Set when the call goes into the Go standard library.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    stdlib: Option<StdSymbol>,
+}
+
+fn print_std_symbol(std: &StdSymbol) {
+    match &std.signature {
+        Some(signature) => println!("  std {}: {signature}  {}", std.package, std.url),
+        None => println!("  std {}.{}  {}", std.package, std.name, std.url),
+    }
+}
+
+/// The `go.mod` of the working directory; empty outside a Go module.
+fn cwd_go_mod() -> deps_usage::GoMod {
+    match std::fs::read_to_string("go.mod") {
+        Ok(text) => deps_usage::parse_go_mod(&text),
+        Err(_) => deps_usage::GoMod::default(),
+    }
+}
+
+/// Mermaid sequence diagram of the calls from `name`, or of its path to `to`.
+pub fn cmd_sequence(name: &str, to: Option<&str>, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let sequence = match to {
+        Some(to) => sequence::path(&db, name, to, depth)?.with_context(|| {
+            format!("no call path from '{name}' to '{to}' within {depth} calls")
+        })?,
+        None => sequence::from_function(&db, name, depth)?
+            .with_context(|| format!("no definition of '{name}'"))?,
+    };
+
+    output(&sequence, json, |s| {
+        print!("{}", sequence::render_mermaid(s))
+    })
+}
+
+/// Transitive impact analysis — what breaks if the `targets` change?
+pub fn cmd_impact(targets: &[Target], depth: u32, tag: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let tagged = db.tag_filter(tag)?;
+    let name = targets_label(targets);
+    // Dependents shared by several targets are listed once, at the first depth found.
+    let mut results: Vec<(Edge, u32)> = Vec::new();
+    let mut seen = HashSet::new();
+    for target in targets {
+        let found = match target {
+            Target::Name(name) => db.impact(name, depth)?,
+            Target::Id(id) => db.impact_of_id(id, depth)?,
+        };
+        for (edge, d) in found {
+            let key = (
+                edge.source_id.clone(),
+                edge.target_name.clone(),
+                edge.kind,
+                edge.line,
+            );
+            if tagged.keeps(&edge.source_id) && seen.insert(key) {
+                results.push((edge, d));
+            }
+        }
+    }
+    // A `Type.Field` also reaches the wire formats its struct is serialized to,
+    // and whatever depends on the functions doing it.
+    let mut wire_sites: Vec<(WireSite, Vec<(Edge, u32)>)> = Vec::new();
+    for target in targets {
+        let Target::Name(name) = target else {
+            continue;
+        };
+        for site in wire::field_sites(&db, name)? {
+            if !tagged.keeps(&site.function.id) {
+                continue;
+            }
+            let mut dependents = db.impact(&site.function.name, depth.saturating_sub(1))?;
+            dependents.retain(|(edge, _)| tagged.keeps(&edge.source_id));
+            wire_sites.push((site, dependents));
+        }
+    }
+    let mut ids: Vec<String> = results.iter().map(|(e, _)| e.source_id.clone()).collect();
+    for (site, dependents) in &wire_sites {
+        ids.push(site.function.id.clone());
+        ids.extend(dependents.iter().map(|(e, _)| e.source_id.clone()));
+    }
+    if pipe::ids_enabled() {
+        return pipe::write_ids(ids.iter().map(String::as_str));
+    }
+    let coverage = db.coverage_of(&ids)?;
 
     if json {
-        let items: Vec<_> = results
+        let mut items: Vec<_> = results
             .iter()
             .map(|(edge, d)| {
                 serde_json::json!({
                     "edge": edge,
                     "depth": d,
+                    "coverage": coverage.get(&edge.source_id),
                 })
             })
             .collect();
+        for (site, dependents) in &wire_sites {
+            let s = &site.serialization;
+            let edge = Edge::new(
+                &site.function.id,
+                &s.type_name,
+                EdgeKind::References,
+                &site.function.file_path,
+                s.line,
+            );
+            items.push(serde_json::json!({
+                "edge": edge,
+                "depth": 1,
+                "wire": { "format": s.format, "key": site.key, "decode": s.decode },
+            }));
+            items.extend(dependents.iter().map(|(edge, d)| {
+                serde_json::json!({
+                    "edge": edge,
+                    "depth": d + 1,
+                    "coverage": coverage.get(&edge.source_id),
+                })
+            }));
+        }
         println!("{}", serde_json::to_string_pretty(&items)?);
     } else {
-        if results.is_empty() {
+        if results.is_empty() && wire_sites.is_empty() {
             println!("No impact found for '{name}'");
             return Ok(());
         }
-        for (edge, depth) in &results {
-            let indent = "  ".repeat(*depth as usize);
+        let print_edge = |edge: &Edge, depth: u32| {
+            let indent = "  ".repeat(depth as usize);
+            let covered = coverage
+                .get(&edge.source_id)
+                .map(|c| format!("  {:.0}% covered", c.percent()))
+                .unwrap_or_default();
             println!(
-                "{indent}{kind}  {source}  {file}:{line}",
+                "{indent}{kind}  {source}  {file}:{line}{covered}",
                 kind = edge.kind,
                 source = edge.source_id,
                 file = edge.file_path,
                 line = edge.line,
             );
+        };
+        for (edge, depth) in &results {
+            print_edge(edge, *depth);
+        }
+        for (site, dependents) in &wire_sites {
+            let s = &site.serialization;
+            println!(
+                "  wire {format} \"{key}\" {direction}  {function}  {file}:{line}",
+                format = s.format,
+                key = site.key,
+                direction = if s.decode { "decode" } else { "encode" },
+                function = site.function.id,
+                file = site.function.file_path,
+                line = s.line,
+            );
+            for (edge, depth) in dependents {
+                print_edge(edge, depth + 1);
+            }
         }
     }
 
     Ok(())
 }
 
-/// All references to a symbol (calls, imports, inherits, references, raises).
-pub fn cmd_refs(name: &str, kind: Option<EdgeKindFilter>, json: bool) -> Result<()> {
-    let db = open_db()?;
+/// All references to the `targets` (calls, imports, inherits, references, raises).
+pub fn cmd_refs(
+    targets: &[Target],
+    kind: Option<EdgeKindFilter>,
+    with_blame: bool,
+    tag: Option<&str>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
     let kind_filter = kind.map(EdgeKind::from);
-    let results = db.refs(name, kind_filter)?;
+    let tagged = db.tag_filter(tag)?;
+    let name = targets_label(targets);
+    let mut results = Vec::new();
+    for target in targets {
+        results.extend(match target {
+            Target::Name(name) => db.refs(name, kind_filter)?,
+            Target::Id(id) => db.refs_to_id(id, kind_filter)?,
+        });
+    }
+    results.retain(|(edge, _)| tagged.keeps(&edge.source_id));
+    if pipe::ids_enabled() {
+        return pipe::write_ids(results.iter().map(|(edge, _)| edge.source_id.as_str()));
+    }
+
+    let mut blame = with_blame.then(|| BlameCache::new(Path::new(".")));
+    let blames: Vec<Option<BlameInfo>> = results
+        .iter()
+        .map(|(edge, _)| {
+            blame
+                .as_mut()
+                .and_then(|b| b.last_change(&edge.file_path, edge.line, edge.line))
+        })
+        .collect();
 
     if json {
         let items: Vec<_> = results
             .iter()
-            .map(|(edge, sym)| {
-                serde_json::json!({
+            .zip(&blames)
+            .map(|((edge, sym), blame)| {
+                let mut item = serde_json::json!({
                     "edge": edge,
                     "source": sym,
-                })
+                });
+                if let Some(b) = blame {
+                    item["blame"] = serde_json::json!(b);
+                }
+                item
             })
             .collect();
         println!("{}", serde_json::to_string_pretty(&items)?);
@@ -156,17 +804,18 @@ pub fn cmd_refs(name: &str, kind: Option<EdgeKindFilter>, json: bool) -> Result<
             println!("No references found for '{name}'");
             return Ok(());
         }
-        for (edge, sym) in &results {
+        for ((edge, sym), blame) in results.iter().zip(&blames) {
             let source_name = sym
                 .as_ref()
                 .map(|s| s.name.as_str())
                 .unwrap_or(&edge.source_id);
             println!(
-                "{kind}  {source}  {file}:{line}",
+                "{kind}  {source}  {file}:{line}{suffix}",
                 kind = edge.kind,
                 source = source_name,
                 file = edge.file_path,
                 line = edge.line,
+                suffix = blame_suffix(blame.as_ref()),
             );
         }
     }
@@ -174,19 +823,109 @@ pub fn cmd_refs(name: &str, kind: Option<EdgeKindFilter>, json: bool) -> Result<
     Ok(())
 }
 
+#[derive(Serialize)]
+struct GlobalUse {
+    global: Symbol,
+    function: Symbol,
+    #[serde(flatten)]
+    access: VariableAccess,
+}
+
+/// Reads and writes of package-level variables, all or only `name`'s.
+pub fn cmd_refs_globals(name: Option<&str>, tag: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let tagged = db.tag_filter(tag)?;
+    let uses: Vec<_> = db
+        .global_accesses(name)?
+        .into_iter()
+        .filter(|(_, function, _)| tagged.keeps(&function.id))
+        .map(|(global, function, access)| GlobalUse {
+            global,
+            function,
+            access,
+        })
+        .collect();
+    output(&uses, json, |uses| {
+        if uses.is_empty() {
+            match name {
+                Some(name) => println!("No accesses to a global '{name}'"),
+                None => println!("No global variable accesses indexed."),
+            }
+        }
+        let mut global = "";
+        for u in uses {
+            if u.global.id != global {
+                global = u.global.id.as_str();
+                println!(
+                    "{}  {}:{}",
+                    u.global.name, u.global.file_path, u.global.start_line
+                );
+            }
+            let f = &u.function;
+            println!(
+                "  {:<5} {} {}  {}:{}",
+                if u.access.write { "write" } else { "read" },
+                f.kind,
+                f.name,
+                f.file_path,
+                u.access.line
+            );
+        }
+    })
+}
+
 /// Show inheritance hierarchy for a class.
-pub fn cmd_hierarchy(name: &str, json: bool) -> Result<()> {
-    let db = open_db()?;
-    let pairs = db.hierarchy(name)?;
+pub fn cmd_hierarchy(name: &str, doubles: DoublesFilter, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let mut pairs = db.hierarchy(name)?;
+    // Generated mocks and fakes, so test doubles read apart from implementations.
+    let fakes = db.test_doubles(name)?;
+    let generator = |child: &str, parent: &str| {
+        fakes
+            .iter()
+            .find(|(sym, d)| sym.name == child && d.interface == parent)
+            .map(|(_, d)| d.generator.as_str())
+    };
+    match doubles {
+        DoublesFilter::Include => {}
+        DoublesFilter::Exclude => pairs.retain(|(c, p)| generator(c, p).is_none()),
+        DoublesFilter::Only => pairs.retain(|(c, p)| generator(c, p).is_some()),
+    }
+    let assertions = db.type_assertions(name)?;
+    // Go type assertions behind a pair, so a downcast reads apart from embedding.
+    let sites = |child: &str, parent: &str| {
+        assertions
+            .iter()
+            .filter(|(_, a)| a.type_name == child && a.interface.as_deref() == Some(parent))
+            .collect::<Vec<_>>()
+    };
 
     if json {
         let items: Vec<_> = pairs
             .iter()
             .map(|(child, parent)| {
-                serde_json::json!({
+                let mut item = serde_json::json!({
                     "child": child,
                     "parent": parent,
-                })
+                });
+                let asserted: Vec<_> = sites(child, parent)
+                    .into_iter()
+                    .map(|(sym, a)| {
+                        serde_json::json!({
+                            "symbol": sym.name,
+                            "file_path": sym.file_path,
+                            "line": a.line,
+                            "type_switch": a.type_switch,
+                        })
+                    })
+                    .collect();
+                if !asserted.is_empty() {
+                    item["asserted_in"] = serde_json::Value::Array(asserted);
+                }
+                if let Some(generator) = generator(child, parent) {
+                    item["double"] = generator.into();
+                }
+                item
             })
             .collect();
         println!("{}", serde_json::to_string_pretty(&items)?);
@@ -196,7 +935,18 @@ pub fn cmd_hierarchy(name: &str, json: bool) -> Result<()> {
             return Ok(());
         }
         for (child, parent) in &pairs {
-            println!("{child} -> {parent}");
+            match generator(child, parent) {
+                Some(generator) => println!("{child} -> {parent}  ({generator} double)"),
+                None => println!("{child} -> {parent}"),
+            }
+            for (sym, a) in sites(child, parent) {
+                let how = if a.type_switch {
+                    "type switch"
+                } else {
+                    "assertion"
+                };
+                println!("  {how} in {}  {}:{}", sym.name, sym.file_path, a.line);
+            }
         }
     }
 
@@ -205,7 +955,7 @@ pub fn cmd_hierarchy(name: &str, json: bool) -> Result<()> {
 
 /// File-level import dependencies.
 pub fn cmd_deps(file: &str, json: bool) -> Result<()> {
-    let db = open_db()?;
+    let db = open_query_db()?;
     let edges = db.file_deps(file)?;
 
     output(&edges, json, |edges| {
@@ -223,22 +973,88 @@ pub fn cmd_deps(file: &str, json: bool) -> Result<()> {
     })
 }
 
+/// External modules with where and how much they are used.
+pub fn cmd_deps_usage(
+    module: Option<&str>,
+    include_stdlib: bool,
+    limit: usize,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let go_mod = cwd_go_mod();
+    let mut report = deps_usage::usage(&db, &go_mod, include_stdlib)?;
+    if let Some(module) = module {
+        report.retain(|m| m.module.contains(module));
+    }
+    report.truncate(limit);
+
+    output(&report, json, |report| {
+        if report.is_empty() {
+            println!("No external modules found");
+            return;
+        }
+        for m in report {
+            let version = m
+                .version
+                .as_ref()
+                .map(|v| format!(" {v}"))
+                .unwrap_or_default();
+            let replacement = m
+                .replacement
+                .as_ref()
+                .map(|r| format!(" => {r}"))
+                .unwrap_or_default();
+            println!(
+                "{}{version}{replacement}{}  {} calls  {} files",
+                m.module,
+                if m.stdlib { " (std)" } else { "" },
+                m.calls,
+                m.files.len()
+            );
+            // The full breakdown only when narrowed to some modules.
+            if module.is_none() {
+                continue;
+            }
+            for f in &m.files {
+                println!("  {}  {}", f.file, f.calls);
+            }
+            for api in &m.apis {
+                println!("  {}()  {}", api.name, api.calls);
+            }
+        }
+    })
+}
+
 /// Search for symbols by name (case-insensitive prefix + substring match).
 pub fn cmd_search(
     query: &str,
     kind: Option<SymbolKindFilter>,
-    file: Option<&str>,
+    filter: SearchFilter<'_>,
     limit: u32,
     json: bool,
 ) -> Result<()> {
-    let db = open_db()?;
-    let kind_filter = kind.map(crate::types::SymbolKind::from);
+    let db = open_query_db()?;
+    let filter = SearchFilter {
+        kind: kind.map(crate::types::SymbolKind::from),
+        ..filter
+    };
     let limit = limit.min(MAX_SEARCH_LIMIT);
-    let symbols = db.search(query, kind_filter, file, limit)?;
+    let symbols = db.search_filtered(query, &filter, limit)?;
+    if pipe::ids_enabled() {
+        return pipe::write_ids(symbols.iter().map(|s| s.id.as_str()));
+    }
 
     output(&symbols, json, |syms| {
         if syms.is_empty() {
-            println!("No symbols found matching '{query}'");
+            if query.is_empty() {
+                println!("No symbols found");
+            } else {
+                println!("No symbols found matching '{query}'");
+                // Standard library functions are not in the index.
+                for std in stdlib::lookup(query) {
+                    print_std_symbol(&std);
+                }
+            }
             return;
         }
         for sym in syms {
@@ -253,9 +1069,96 @@ pub fn cmd_search(
     })
 }
 
+/// Search files for a literal string, from the index where it can answer.
+pub fn cmd_grep(
+    pattern: &str,
+    paths: &[String],
+    opts: GrepOptions,
+    files_only: bool,
+    json: bool,
+) -> Result<()> {
+    // Grep works without an index, so only an existing one is opened.
+    let db = if opts.scan || !Path::new(DB_FILE).exists() {
+        None
+    } else {
+        Some(open_query_db()?)
+    };
+    let result = grep::grep(db.as_ref(), Path::new("."), pattern, paths, opts)?;
+
+    output(&result, json, |r| {
+        if files_only {
+            let mut last = None;
+            for m in &r.matches {
+                if last != Some(&m.path) {
+                    println!("{}", m.path);
+                    last = Some(&m.path);
+                }
+            }
+        } else {
+            for m in &r.matches {
+                println!("{}:{}:{}", m.path, m.line, snippet(&m.path, &m.text));
+            }
+        }
+        // On stderr, so stdout stays what grep would print.
+        let from_index = r
+            .matches
+            .iter()
+            .filter(|m| m.source == Source::Index)
+            .count();
+        match r.mode {
+            GrepMode::Index => eprintln!(
+                "cartog grep: {} matches from the index ({} files)",
+                r.matches.len(),
+                r.indexed_files
+            ),
+            GrepMode::Mixed => eprintln!(
+                "cartog grep: {from_index} matches from the index ({} files), {} from scanning {} unindexed or changed files",
+                r.indexed_files,
+                r.matches.len() - from_index,
+                r.scanned_files
+            ),
+            GrepMode::Scan => eprintln!(
+                "cartog grep: {} matches from scanning {} files ({})",
+                r.matches.len(),
+                r.scanned_files,
+                r.fallback.as_deref().unwrap_or("")
+            ),
+        }
+    })?;
+    if result.matches.is_empty() {
+        anyhow::bail!("no matches for '{pattern}'");
+    }
+    Ok(())
+}
+
+/// Print the completion script for `shell`.
+pub fn cmd_completions(shell: Shell) -> Result<()> {
+    let mut cmd = <Cli as clap::CommandFactory>::command();
+    print!("{}", completions::script(shell, &mut cmd));
+    Ok(())
+}
+
+/// Symbol names starting with `prefix`, for completion scripts. Prints nothing
+/// when there is no index: a failed tab press should stay silent.
+pub fn cmd_complete(prefix: &str, limit: u32) -> Result<()> {
+    let Ok(db) = Database::open_read_only(DB_FILE) else {
+        return Ok(());
+    };
+    use std::io::Write;
+
+    let names = db.complete_names(prefix, limit).unwrap_or_default();
+    let mut out = std::io::stdout().lock();
+    for name in names {
+        if writeln!(out, "{name}").is_err() {
+            break;
+        }
+    }
+    Ok(())
+}
+
 /// Index statistics summary.
 pub fn cmd_stats(json: bool) -> Result<()> {
-    let db = open_db()?;
+    let db = open_query_db()?;
     let stats = db.stats()?;
 
     output(&stats, json, |stats| {
@@ -277,11 +1180,1867 @@ pub fn cmd_stats(json: bool) -> Result<()> {
                 println!("  {kind}: {count}");
             }
         }
+        if !stats.branches.is_empty() {
+            println!("Branch states:");
+            for b in &stats.branches {
+                println!(
+                    "  {}  {}  {}  {} cached files",
+                    b.branch,
+                    &b.commit[..b.commit.len().min(8)],
+                    git::format_epoch_date(b.indexed_at as i64),
+                    b.cached_files
+                );
+            }
+        }
+        if !stats.degraded_files.is_empty() {
+            println!("Degraded (syntax errors, symbols may be incomplete):");
+            for file in &stats.degraded_files {
+                println!(
+                    "  {path}: {errors} errors, first at line {line}: {message}",
+                    path = file.path,
+                    errors = file.errors,
+                    line = file.first.line,
+                    message = file.first.message,
+                );
+            }
+        }
     })
 }
 
-// ── RAG Commands ──
-
+/// List tags with their symbol counts, or the symbols carrying `tag`.
+pub fn cmd_tags(tag: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let Some(tag) = tag else {
+        let counts: BTreeMap<String, u32> = db.tag_counts()?.into_iter().collect();
+        return output(&counts, json, |counts| {
+            if counts.is_empty() {
+                println!("No tagged symbols. Add [tags.<label>] to .cartog.toml and re-index.");
+            }
+            for (tag, count) in counts {
+                println!("{tag}  {count}");
+            }
+        });
+    };
+    let symbols = db.tagged_symbols(tag)?;
+    output(&symbols, json, |syms| {
+        if syms.is_empty() {
+            println!("No symbols tagged '{tag}'");
+        }
+        for sym in syms {
+            println!(
+                "{kind}  {name}  {file}:{line}",
+                kind = sym.kind,
+                name = sym.name,
+                file = sym.file_path,
+                line = sym.start_line,
+            );
+        }
+    })
+}
+
+#[derive(Serialize)]
+struct SyncObject {
+    object: String,
+    functions: u32,
+    sites: u32,
+}
+
+#[derive(Serialize)]
+struct SyncUse {
+    #[serde(flatten)]
+    site: SyncSite,
+    function: Symbol,
+}
+
+/// Channels and sync primitives with their usage counts, or every use of one.
+pub fn cmd_concurrency(name: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let Some(name) = name else {
+        let objects: Vec<_> = db
+            .sync_objects()?
+            .into_iter()
+            .map(|(object, functions, sites)| SyncObject {
+                object,
+                functions,
+                sites,
+            })
+            .collect();
+        return output(&objects, json, |objects| {
+            if objects.is_empty() {
+                println!("No channel or sync primitive uses indexed.");
+            }
+            for o in objects {
+                println!("{}  {} functions, {} sites", o.object, o.functions, o.sites);
+            }
+        });
+    };
+    let uses: Vec<_> = db
+        .sync_sites(name)?
+        .into_iter()
+        .map(|(function, site)| SyncUse { site, function })
+        .collect();
+    output(&uses, json, |uses| {
+        if uses.is_empty() {
+            println!("No uses of '{name}'");
+        }
+        let mut object = "";
+        for u in uses {
+            if u.site.object != object {
+                object = u.site.object.as_str();
+                println!("{object}");
+            }
+            let f = &u.function;
+            println!(
+                "  {:<8} {} {}  {}:{}",
+                u.site.op.as_str(),
+                f.kind,
+                f.name,
+                f.file_path,
+                u.site.line
+            );
+        }
+    })
+}
+
+#[derive(Serialize)]
+struct FlagCheck {
+    symbol: Symbol,
+    line: u32,
+}
+
+#[derive(Serialize)]
+struct Flag {
+    flag: String,
+    checks: Vec<FlagCheck>,
+}
+
+/// Feature flags with the symbols that check them, all or only `name`.
+pub fn cmd_flags(name: Option<&str>, format: Option<TableFormatArg>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let mut flags: Vec<Flag> = Vec::new();
+    for (flag, symbol, line) in db.flag_checks(name)? {
+        let check = FlagCheck { symbol, line };
+        match flags.last_mut() {
+            Some(last) if last.flag == flag => last.checks.push(check),
+            _ => flags.push(Flag {
+                flag,
+                checks: vec![check],
+            }),
+        }
+    }
+    if let Some(format) = format {
+        let rows = flags.iter().flat_map(|f| {
+            f.checks.iter().map(|c| {
+                vec![
+                    f.flag.clone(),
+                    c.symbol.kind.to_string(),
+                    c.symbol.name.clone(),
+                    c.symbol.file_path.clone(),
+                    c.line.to_string(),
+                ]
+            })
+        });
+        return table::write_table(
+            format.into(),
+            &["flag", "kind", "name", "file", "line"],
+            rows,
+        );
+    }
+    output(&flags, json, |flags| {
+        if flags.is_empty() {
+            match name {
+                Some(name) => println!("No checks of flag '{name}'"),
+                None => println!("No feature flags found."),
+            }
+        }
+        for f in flags {
+            let files: HashSet<&str> = f
+                .checks
+                .iter()
+                .map(|c| c.symbol.file_path.as_str())
+                .collect();
+            println!(
+                "{}  {} checks in {} files",
+                f.flag,
+                f.checks.len(),
+                files.len()
+            );
+            for c in &f.checks {
+                let s = &c.symbol;
+                println!("  {} {}  {}:{}", s.kind, s.name, s.file_path, c.line);
+            }
+        }
+    })
+}
+
+/// Log statements, optionally those a log line or message fragment matches, with
+/// the call chains leading to each match.
+pub fn cmd_logs(
+    grep: Option<&str>,
+    level: Option<LogLevelArg>,
+    depth: u32,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let depth = if grep.is_some() && format.is_none() {
+        depth
+    } else {
+        0
+    };
+    let matches = logs::find(&db, grep, level.map(Into::into), depth)?;
+    if let Some(format) = format {
+        let rows = matches.iter().map(|m| {
+            let (s, l) = (&m.symbol, &m.statement);
+            vec![
+                l.level.as_str().to_string(),
+                l.component.clone().unwrap_or_default(),
+                l.template.clone(),
+                s.kind.to_string(),
+                s.name.clone(),
+                s.file_path.clone(),
+                l.line.to_string(),
+            ]
+        });
+        return table::write_table(
+            format.into(),
+            &[
+                "level",
+                "component",
+                "template",
+                "kind",
+                "name",
+                "file",
+                "line",
+            ],
+            rows,
+        );
+    }
+
+    output(&matches, json, |matches| {
+        if matches.is_empty() {
+            match grep {
+                Some(grep) => println!("No log statement matches '{grep}'"),
+                None => println!("No log statements found."),
+            }
+        }
+        for m in matches {
+            let (s, l) = (&m.symbol, &m.statement);
+            let component = l
+                .component
+                .as_ref()
+                .map(|c| format!(" [{c}]"))
+                .unwrap_or_default();
+            println!(
+                "{:<5}{component} \"{}\"  {} {}  {}:{}",
+                l.level.as_str(),
+                l.template,
+                s.kind,
+                s.name,
+                s.file_path,
+                l.line
+            );
+            print_call_steps(&m.callers, 1);
+        }
+    })
+}
+
+fn print_call_steps(steps: &[CallStep], level: usize) {
+    let indent = "  ".repeat(level);
+    for step in steps {
+        println!(
+            "{indent}<- {}  {}:{}",
+            step.caller.name, step.caller.file_path, step.line
+        );
+        print_call_steps(&step.callers, level + 1);
+    }
+}
+
+/// Marker comments with their enclosing symbol, age and owner, oldest first.
+pub fn cmd_todos(
+    marker: Option<String>,
+    owner: Option<String>,
+    older_than: Option<u32>,
+    blame: bool,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let filter = TodoFilter {
+        marker,
+        owner,
+        older_than,
+    };
+    let now = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_secs() as i64)
+        .unwrap_or(0);
+    let items = todos::inventory(&db, Path::new("."), &filter, blame, now)?;
+    if let Some(format) = format {
+        let rows = items.iter().map(|item| {
+            vec![
+                item.todo.marker.clone(),
+                item.file.clone(),
+                item.todo.line.to_string(),
+                item.age_days.map(|d| d.to_string()).unwrap_or_default(),
+                item.owner.clone().unwrap_or_default(),
+                item.symbol
+                    .as_ref()
+                    .map(|s| s.name.clone())
+                    .unwrap_or_default(),
+                item.todo.text.clone(),
+            ]
+        });
+        return table::write_table(
+            format.into(),
+            &[
+                "marker", "file", "line", "age_days", "owner", "symbol", "text",
+            ],
+            rows,
+        );
+    }
+
+    output(&items, json, |items| {
+        if items.is_empty() {
+            println!("No TODO comments found.");
+        }
+        for item in items {
+            let t = &item.todo;
+            let age = item.age_days.map_or("-".to_string(), |d| format!("{d}d"));
+            let owner = item.owner.as_deref().unwrap_or("-");
+            let symbol = item
+                .symbol
+                .as_ref()
+                .map(|s| format!("  {} {}", s.kind, s.name))
+                .unwrap_or_default();
+            println!(
+                "{:<5} {age:>5}  {owner}  {}:{}{symbol}",
+                t.marker, item.file, t.line
+            );
+            if !t.text.is_empty() {
+                println!("      {}", t.text);
+            }
+        }
+    })
+}
+
+/// Import a Go cover profile and summarize what it covered.
+pub fn cmd_coverage(profile: &str, json: bool) -> Result<()> {
+    let text =
+        std::fs::read_to_string(profile).with_context(|| format!("cannot read {profile}"))?;
+    let db = open_db()?;
+    let summary = coverage::import(&db, &text)?;
+    output(&summary, json, |s| {
+        let percent = Coverage {
+            covered: s.covered,
+            total: s.total,
+        }
+        .percent();
+        println!(
+            "Coverage for {} functions in {} files: {percent:.1}% of {} statements",
+            s.symbols, s.files, s.total
+        );
+        if !s.unmatched.is_empty() {
+            println!("{} profile files not in the index:", s.unmatched.len());
+            for file in &s.unmatched {
+                println!("  {file}");
+            }
+        }
+    })
+}
+
+/// Record a snapshot under `tag`, trace a dependency across snapshots, or list them.
+/// Deprecated symbols with their remaining uses, optionally recording the totals.
+pub fn cmd_deprecations(
+    owner: Option<&str>,
+    record: bool,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let codeowners = CodeOwners::load(Path::new("."))?;
+    let deprecations = deprecations::report(&db, codeowners.as_ref(), owner)?;
+    let history = if record {
+        let now = std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .map(|d| d.as_secs() as i64)
+            .unwrap_or(0);
+        deprecations::record(&db, &deprecations, now)?
+    } else {
+        db.deprecation_history()?
+    };
+    if let Some(format) = format {
+        // One row per use; a deprecated symbol nothing uses still gets one.
+        let mut rows = Vec::new();
+        for d in &deprecations {
+            let row = |usage: [String; 4], owners: &[String]| {
+                let mut row = vec![
+                    d.symbol.name.clone(),
+                    d.symbol.file_path.clone(),
+                    d.symbol.start_line.to_string(),
+                ];
+                row.extend(usage);
+                row.push(owners.join(" "));
+                row
+            };
+            if d.usages.is_empty() {
+                rows.push(row(Default::default(), &d.owners));
+            }
+            for u in &d.usages {
+                let usage = [
+                    u.kind.as_str().to_string(),
+                    u.symbol.name.clone(),
+                    u.file.clone(),
+                    u.line.to_string(),
+                ];
+                rows.push(row(usage, &u.owners));
+            }
+        }
+        return table::write_table(
+            format.into(),
+            &[
+                "symbol", "file", "line", "use_kind", "used_by", "use_file", "use_line", "owners",
+            ],
+            rows,
+        );
+    }
+
+    #[derive(Serialize)]
+    struct Report<'a> {
+        deprecations: &'a [deprecations::Deprecation],
+        history: &'a [deprecations::BurndownPoint],
+    }
+    let report = Report {
+        deprecations: &deprecations,
+        history: &history,
+    };
+    output(&report, json, |r| {
+        if r.deprecations.is_empty() {
+            println!("No deprecated symbols in use");
+        }
+        let owners = |o: &[String]| {
+            if o.is_empty() {
+                String::new()
+            } else {
+                format!("  {}", o.join(" "))
+            }
+        };
+        for d in r.deprecations {
+            println!(
+                "{}  {}:{}{}  {} uses",
+                d.symbol.name,
+                d.symbol.file_path,
+                d.symbol.start_line,
+                owners(&d.owners),
+                d.usages.len()
+            );
+            if !d.note.is_empty() {
+                println!("  Deprecated: {}", d.note);
+            }
+            for u in &d.usages {
+                println!(
+                    "  {}  {}  {}:{}{}",
+                    u.kind.as_str(),
+                    u.symbol.name,
+                    u.file,
+                    u.line,
+                    owners(&u.owners)
+                );
+            }
+        }
+        if !r.history.is_empty() {
+            println!("\nBurndown:");
+            for p in r.history {
+                println!(
+                    "  {}  {} symbols  {} uses",
+                    git::format_epoch_date(p.recorded_at),
+                    p.symbols,
+                    p.usages
+                );
+            }
+        }
+    })
+}
+
+pub fn cmd_snapshot(tag: Option<&str>, depends: Option<&[String]>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    if let Some(tag) = tag {
+        let now = std::time::SystemTime::now()
+            .duration_since(std::time::UNIX_EPOCH)
+            .map(|d| d.as_secs() as i64)
+            .unwrap_or(0);
+        let recorded = snapshot::record(&db, Path::new("."), tag, now)?;
+        return output(&recorded, json, |s| {
+            println!(
+                "Snapshot {}: {} packages, {} dependencies",
+                s.tag, s.packages, s.dependencies
+            );
+        });
+    }
+    if let Some([from, to]) = depends {
+        let history = snapshot::dependency_history(&db, from, to)?;
+        return output(&history, json, |history| {
+            for point in history {
+                let label = point.snapshot.as_ref().map_or_else(
+                    || "(index)".to_string(),
+                    |s| format!("{} ({})", s.tag, git::format_epoch_date(s.created_at)),
+                );
+                println!("{label}  {} edges", point.edges);
+                for d in &point.pairs {
+                    println!("  {} -> {}  {}", d.from, d.to, d.edges);
+                }
+            }
+            if let Some(first) = history
+                .iter()
+                .find(|p| p.edges > 0)
+                .and_then(|p| p.snapshot.as_ref())
+            {
+                println!("First seen in {}", first.tag);
+            }
+        });
+    }
+    let snapshots = db.snapshots()?;
+    output(&snapshots, json, |snapshots| {
+        if snapshots.is_empty() {
+            println!("No snapshots; record one with `cartog snapshot --tag <tag>`");
+            return;
+        }
+        for s in snapshots {
+            println!(
+                "{tag}  {date}  {commit}  {packages} packages, {deps} dependencies",
+                tag = s.tag,
+                date = git::format_epoch_date(s.created_at),
+                commit = s.commit.as_deref().map_or("-", |c| &c[..c.len().min(8)]),
+                packages = s.packages,
+                deps = s.dependencies,
+            );
+        }
+    })
+}
+
+/// Benchmarks and what they call, or the ones to run after changing `name`.
+pub fn cmd_benchmarks(name: Option<&str>, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let Some(name) = name else {
+        let all = benchmarks::inventory(&db)?;
+        return output(&all, json, |all| {
+            if all.is_empty() {
+                println!("No benchmarks found");
+                return;
+            }
+            for b in all {
+                println!(
+                    "{name}  {file}:{line}  -> {calls}",
+                    name = b.symbol.name,
+                    file = b.symbol.file_path,
+                    line = b.symbol.start_line,
+                    calls = b.exercises.join(", "),
+                );
+            }
+        });
+    };
+    let hits = benchmarks::covering(&db, name, depth)?;
+    output(&hits, json, |hits| {
+        if hits.is_empty() {
+            println!("No benchmarks reach '{name}' within {depth} calls");
+            return;
+        }
+        for hit in hits {
+            println!(
+                "{bench}  {file}:{line}  {bench} > {via}",
+                bench = hit.symbol.name,
+                file = hit.symbol.file_path,
+                line = hit.symbol.start_line,
+                via = hit.via.join(" > "),
+            );
+        }
+        println!();
+        let symbols: Vec<&Symbol> = hits.iter().map(|h| &h.symbol).collect();
+        for command in benchmarks::run_commands(&symbols) {
+            println!("{command}");
+        }
+    })
+}
+
+/// Go tests likely to exercise `name`, best first, with a `go test` line running them.
+pub fn cmd_tests_for(name: &str, depth: u32, limit: usize, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let mut found = tests_for::tests_for(&db, name, depth)?;
+    found.tests.truncate(limit);
+    output(&found, json, |found| {
+        if found.definitions.is_empty() {
+            println!("No symbol named '{name}'");
+            return;
+        }
+        if let Some(c) = found.coverage.filter(|c| c.covered == 0) {
+            println!(
+                "note: the loaded coverage shows '{name}' never ran (0/{} statements); scores halved",
+                c.total
+            );
+        }
+        if found.tests.is_empty() {
+            println!("No tests found for '{name}'");
+            return;
+        }
+        for test in &found.tests {
+            println!(
+                "{score:.2}  {test}  {file}:{line}  {reasons}",
+                score = test.score,
+                test = test.symbol.name,
+                file = test.symbol.file_path,
+                line = test.symbol.start_line,
+                reasons = test.reasons.join(", "),
+            );
+        }
+        println!();
+        let symbols: Vec<&Symbol> = found.tests.iter().map(|t| &t.symbol).collect();
+        for command in tests_for::run_commands(&symbols) {
+            println!("{command}");
+        }
+    })
+}
+
+/// Exported functions and methods no test reaches, most central first.
+pub fn cmd_untested(package: Option<&str>, depth: u32, limit: usize, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let mut report = untested::untested(&db, package, depth)?;
+    let total = report.untested.len();
+    report.untested.truncate(limit);
+    if pipe::ids_enabled() {
+        return pipe::write_ids(report.untested.iter().map(|u| u.symbol.id.as_str()));
+    }
+
+    output(&report, json, |report| {
+        let scope = report
+            .package
+            .as_deref()
+            .map(|p| format!(" in {}", if p.is_empty() { "." } else { p }))
+            .unwrap_or_default();
+        println!(
+            "{total} of {} exported functions and methods{scope} reached by no test",
+            report.exported
+        );
+        for u in &report.untested {
+            let s = &u.symbol;
+            let covered = u
+                .coverage
+                .map(|c| format!("  {:.0}% covered", c.percent()))
+                .unwrap_or_default();
+            println!(
+                "{:>8.2}  {} {}  {}:{}{covered}",
+                u.pagerank, s.kind, s.name, s.file_path, s.start_line
+            );
+        }
+    })
+}
+
+/// Config fields with their uses and file keys: a summary, or every site for `name`.
+pub fn cmd_config_keys(name: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let usages = config_keys::usage(&db, Path::new("."), name)?;
+    output(&usages, json, |usages| {
+        if usages.is_empty() {
+            match name {
+                Some(name) => println!("No config field '{name}'"),
+                None => println!("No config structs indexed."),
+            }
+        }
+        for u in usages {
+            let key = u
+                .field
+                .key
+                .as_deref()
+                .map(|k| format!("  (key: {k})"))
+                .unwrap_or_default();
+            println!(
+                "{}.{}  {}:{}{key}",
+                u.owner.name, u.field.name, u.owner.file_path, u.field.line
+            );
+            if name.is_none() {
+                println!("  {} uses, {} file keys", u.uses.len(), u.keys.len());
+                continue;
+            }
+            for k in &u.keys {
+                println!("  key   {}  {}:{}", k.path, k.file, k.line);
+            }
+            for a in &u.uses {
+                let f = &a.function;
+                println!(
+                    "  {:<5} {} {}  {}:{}  ({})",
+                    if a.access.write { "write" } else { "read" },
+                    f.kind,
+                    f.name,
+                    f.file_path,
+                    a.access.line,
+                    a.access.receiver
+                );
+            }
+        }
+    })
+}
+
+#[derive(Serialize)]
+struct RouteEntry {
+    #[serde(flatten)]
+    route: Route,
+    registered_in: Symbol,
+    /// The handler's definition, when the index resolved it.
+    handler_symbol: Option<Symbol>,
+}
+
+/// The HTTP route table, optionally only paths under `prefix`.
+pub fn cmd_routes(prefix: Option<&str>, format: Option<TableFormatArg>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let entries: Vec<_> = db
+        .routes(prefix)?
+        .into_iter()
+        .map(|(route, registered_in, handler_symbol)| RouteEntry {
+            route,
+            registered_in,
+            handler_symbol,
+        })
+        .collect();
+    if let Some(format) = format {
+        let rows = entries.iter().map(|e| {
+            let r = &e.route;
+            // Where the handler is defined, or where an inline one is registered.
+            let (handler, file, line) = match &e.handler_symbol {
+                Some(h) => (h.name.clone(), h.file_path.clone(), h.start_line),
+                None => (
+                    r.handler.clone().unwrap_or_default(),
+                    e.registered_in.file_path.clone(),
+                    r.line,
+                ),
+            };
+            vec![
+                r.method.clone(),
+                r.path.clone(),
+                handler,
+                file,
+                line.to_string(),
+                r.middleware.join(" "),
+            ]
+        });
+        return table::write_table(
+            format.into(),
+            &["method", "path", "handler", "file", "line", "middleware"],
+            rows,
+        );
+    }
+    output(&entries, json, |entries| {
+        if entries.is_empty() {
+            println!("No routes found.");
+        }
+        for e in entries {
+            let r = &e.route;
+            let handler = match (&r.handler, &e.handler_symbol) {
+                (_, Some(h)) => format!("{}  {}:{}", h.name, h.file_path, h.start_line),
+                (Some(handler), None) => handler.clone(),
+                (None, None) => format!(
+                    "inline in {}  {}:{}",
+                    e.registered_in.name, e.registered_in.file_path, r.line
+                ),
+            };
+            println!("{:<7} {}  -> {}", r.method, r.path, handler);
+            if !r.middleware.is_empty() {
+                println!("        via {}", r.middleware.join(" -> "));
+            }
+        }
+    })
+}
+
+#[derive(Serialize)]
+struct ConstantEntry {
+    name: String,
+    file_path: String,
+    #[serde(flatten)]
+    constant: Constant,
+}
+
+/// Name and value of every constant of `type_name`, in declaration order.
+pub fn cmd_const(type_name: &str, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let entries: Vec<_> = db
+        .constants_of_type(type_name)?
+        .into_iter()
+        .map(|(symbol, constant)| ConstantEntry {
+            name: symbol.name,
+            file_path: symbol.file_path,
+            constant,
+        })
+        .collect();
+    output(&entries, json, |entries| {
+        if entries.is_empty() {
+            println!("No constants of type {type_name}.");
+        }
+        let width = entries.iter().map(|e| e.name.len()).max().unwrap_or(0);
+        let mut group = None;
+        for e in entries {
+            let ty = e.constant.type_name.as_deref().unwrap_or_default();
+            if group != Some((ty, e.file_path.as_str())) {
+                group = Some((ty, e.file_path.as_str()));
+                println!("{ty}  {}", e.file_path);
+            }
+            let value = match &e.constant.value {
+                Some(value) => value.clone(),
+                None => format!("{}  (not evaluated)", e.constant.expr),
+            };
+            println!("  {:<width$}  = {value}  L{}", e.name, e.constant.line);
+        }
+    })
+}
+
+/// Construction sites of `type_name`, then its constructors and factories with their callers.
+pub fn cmd_constructs(type_name: &str, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let name = type_name.rsplit('.').next().unwrap_or(type_name);
+    let found = constructs::constructs(&db, name)?;
+    output(&found, json, |found| {
+        if found.sites.is_empty() && found.factories.is_empty() {
+            println!("Nothing creates a {name}.");
+            return;
+        }
+        for site in &found.sites {
+            println!(
+                "{kind:<11} {file}:{line}  in {symbol}",
+                kind = site.construction.kind.as_str(),
+                file = site.symbol.file_path,
+                line = site.construction.line,
+                symbol = site.symbol.name,
+            );
+        }
+        for factory in &found.factories {
+            let role = if factory.constructor {
+                "constructor"
+            } else {
+                "factory"
+            };
+            println!(
+                "{role:<11} {file}:{line}  {symbol}{signature}  ({calls} calls)",
+                file = factory.symbol.file_path,
+                line = factory.symbol.start_line,
+                symbol = factory.symbol.name,
+                signature = factory.symbol.signature.as_deref().unwrap_or_default(),
+                calls = factory.calls.len(),
+            );
+            for (caller, line) in &factory.calls {
+                println!("    {}:{line}  in {}", caller.file_path, caller.name);
+            }
+        }
+    })
+}
+
+/// Packages in initialization order with what runs while each initializes.
+pub fn cmd_inits(package: Option<&str>, all: bool, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let go_mod = cwd_go_mod();
+    let mut packages = inits::init_order(&db, &go_mod, package)?;
+    let total = packages.len();
+    if !all {
+        packages.retain(|p| p.has_effects());
+    }
+
+    output(&packages, json, |packages| {
+        if packages.is_empty() {
+            println!("No Go package does anything at initialization.");
+        }
+        for p in packages {
+            let dir = if p.package.is_empty() {
+                "."
+            } else {
+                &p.package
+            };
+            println!("{:>3}/{total}  {dir}", p.position);
+            for b in &p.blank_imports {
+                println!("        import _ \"{}\"  {}:{}", b.path, b.file, b.line);
+            }
+            let steps = p.var_inits.iter().map(|s| ("var ", s));
+            for (prefix, step) in steps.chain(p.inits.iter().map(|s| ("", s))) {
+                let sym = &step.symbol;
+                println!(
+                    "        {prefix}{}  {}:{}",
+                    sym.name, sym.file_path, sym.start_line
+                );
+                if !step.calls.is_empty() {
+                    println!("          calls {}", step.calls.join(", "));
+                }
+                if !step.writes.is_empty() {
+                    println!("          writes {}", step.writes.join(", "));
+                }
+            }
+        }
+    })
+}
+
+/// Shortest import chains from package `from` to `to`.
+pub fn cmd_why_depends(from: &str, to: &str, limit: usize, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let go_mod = cwd_go_mod();
+    let found = why_depends::why_depends(&db, &go_mod, from, to, limit)?;
+
+    output(&found, json, |found| {
+        let dir = |d: &str| if d.is_empty() { "." } else { d }.to_string();
+        if found.chains.is_empty() {
+            println!(
+                "{} does not depend on {}.",
+                dir(&found.from),
+                dir(&found.to)
+            );
+        }
+        for (i, chain) in found.chains.iter().enumerate() {
+            if i > 0 {
+                println!();
+            }
+            let packages: Vec<String> = std::iter::once(dir(&found.from))
+                .chain(chain.iter().map(|h| dir(&h.to)))
+                .collect();
+            println!("{}", packages.join(" -> "));
+            for hop in chain {
+                println!("  {}:{}  imports \"{}\"", hop.file, hop.line, hop.path);
+            }
+        }
+    })
+}
+
+/// A symbol with its complexity, flattened into one JSON object.
+#[derive(Serialize)]
+struct WithComplexity<'a> {
+    #[serde(flatten)]
+    symbol: &'a Symbol,
+    #[serde(flatten)]
+    complexity: Complexity,
+}
+
+/// Render the architecture overview to stdout or `output`.
+pub fn cmd_doc_architecture(
+    output_path: Option<&str>,
+    depth: usize,
+    key_types: usize,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let arch = doc::architecture(&db, depth, key_types)?;
+    let rendered = if json {
+        serde_json::to_string_pretty(&arch)?
+    } else {
+        doc::render_markdown(&arch)
+    };
+    match output_path {
+        Some(path) => {
+            write_document(path, &rendered)?;
+            eprintln!(
+                "Wrote {path}: {} packages, {} dependencies",
+                arch.packages.len(),
+                arch.dependencies.len()
+            );
+        }
+        None => print!("{rendered}"),
+    }
+    Ok(())
+}
+
+/// Glossary of the most referenced types.
+pub fn cmd_doc_glossary(output_path: Option<&str>, limit: usize, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let terms = doc::glossary(&db, limit)?;
+    let rendered = if json {
+        serde_json::to_string_pretty(&terms)?
+    } else {
+        doc::render_glossary(&terms)
+    };
+    match output_path {
+        Some(path) => {
+            write_document(path, &rendered)?;
+            eprintln!("Wrote {path}: {} terms", terms.len());
+        }
+        None => print!("{rendered}"),
+    }
+    Ok(())
+}
+
+/// Onboarding tour within `budget` estimated tokens.
+pub fn cmd_tour(budget: u32, output_path: Option<&str>, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let tour = tour::plan(&db, budget)?;
+    let rendered = if json {
+        serde_json::to_string_pretty(&tour)?
+    } else {
+        tour::render_markdown(&tour)
+    };
+    match output_path {
+        Some(path) => {
+            write_document(path, &rendered)?;
+            eprintln!(
+                "Wrote {path}: {} stops, ~{} tokens ({} left out)",
+                tour.stops.len(),
+                tour.tokens,
+                tour.skipped
+            );
+        }
+        None => print!("{rendered}"),
+    }
+    Ok(())
+}
+
+/// Write a generated document, creating its directory.
+fn write_document(path: &str, rendered: &str) -> Result<()> {
+    if let Some(dir) = Path::new(path).parent() {
+        std::fs::create_dir_all(dir).with_context(|| format!("cannot create {}", dir.display()))?;
+    }
+    std::fs::write(path, rendered).with_context(|| format!("cannot write {path}"))
+}
+
+/// Rank functions and methods by complexity.
+pub fn cmd_metrics_complexity(
+    top: u32,
+    by: ComplexityMetricArg,
+    file: Option<&str>,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let ranked = db.most_complex(by.into(), file, top)?;
+    if let Some(format) = format {
+        let rows = ranked.iter().map(|(s, c)| {
+            vec![
+                s.kind.to_string(),
+                s.name.clone(),
+                s.file_path.clone(),
+                s.start_line.to_string(),
+                c.cyclomatic.to_string(),
+                c.cognitive.to_string(),
+            ]
+        });
+        return table::write_table(
+            format.into(),
+            &["kind", "name", "file", "line", "cyclomatic", "cognitive"],
+            rows,
+        );
+    }
+    let entries: Vec<WithComplexity<'_>> = ranked
+        .iter()
+        .map(|(symbol, complexity)| WithComplexity {
+            symbol,
+            complexity: *complexity,
+        })
+        .collect();
+
+    output(&entries, json, |entries| {
+        if entries.is_empty() {
+            println!("No complexity data. Re-index with 'cartog index . --force'.");
+            return;
+        }
+        println!("cyclomatic  cognitive  symbol");
+        for e in entries {
+            println!(
+                "{:>10}  {:>9}  {kind} {name}  {file}:{line}",
+                e.complexity.cyclomatic,
+                e.complexity.cognitive,
+                kind = e.symbol.kind,
+                name = e.symbol.name,
+                file = e.symbol.file_path,
+                line = e.symbol.start_line,
+            );
+        }
+    })
+}
+
+/// List clone groups, most duplicated lines first.
+pub fn cmd_dupes(
+    min_lines: u32,
+    similarity: f64,
+    limit: u32,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    anyhow::ensure!(
+        (0.0..=1.0).contains(&similarity),
+        "--similarity must be between 0 and 1"
+    );
+    let db = open_query_db()?;
+    let mut groups = dupes::find_clones(&db, min_lines, similarity)?;
+    groups.truncate(limit as usize);
+    if let Some(format) = format {
+        let rows = groups.iter().flat_map(|g| {
+            let c = &g.canonical;
+            g.duplicates.iter().map(move |d| {
+                let s = &d.symbol;
+                vec![
+                    c.name.clone(),
+                    c.file_path.clone(),
+                    c.start_line.to_string(),
+                    s.kind.to_string(),
+                    s.name.clone(),
+                    s.file_path.clone(),
+                    s.start_line.to_string(),
+                    s.end_line.to_string(),
+                    format!("{:.2}", d.similarity),
+                    d.exact.to_string(),
+                ]
+            })
+        });
+        return table::write_table(
+            format.into(),
+            &[
+                "canonical",
+                "canonical_file",
+                "canonical_line",
+                "kind",
+                "name",
+                "file",
+                "start_line",
+                "end_line",
+                "similarity",
+                "exact",
+            ],
+            rows,
+        );
+    }
+
+    output(&groups, json, |groups| {
+        if groups.is_empty() {
+            println!("No duplicates of {min_lines}+ lines.");
+            return;
+        }
+        for g in groups {
+            let c = &g.canonical;
+            println!(
+                "{} duplicated lines — canonical {} {}  {}:{}-{} ({} refs)",
+                g.duplicated_lines,
+                c.kind,
+                c.name,
+                c.file_path,
+                c.start_line,
+                c.end_line,
+                g.references
+            );
+            for d in &g.duplicates {
+                let s = &d.symbol;
+                let how = if d.exact {
+                    "exact".to_string()
+                } else {
+                    format!("{:.0}%", d.similarity * 100.0)
+                };
+                println!(
+                    "  {how:>5}  {} {}  {}:{}-{}",
+                    s.kind, s.name, s.file_path, s.start_line, s.end_line
+                );
+            }
+        }
+    })
+}
+
+/// How errors from `name` propagate up its callers.
+pub fn cmd_errors_trace(name: &str, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let traces = errors::trace(&db, name, depth)?;
+
+    output(&traces, json, |traces| {
+        if traces.is_empty() {
+            println!("No definition found for '{name}'");
+        }
+        for t in traces {
+            let s = &t.symbol;
+            let origin = if t.fallible {
+                "returns errors"
+            } else {
+                "passes errors on"
+            };
+            println!(
+                "{} {}  {}:{}  ({origin})",
+                s.kind, s.name, s.file_path, s.start_line
+            );
+            if t.callers.is_empty() {
+                println!("  (no callers)");
+            }
+            print_error_steps(&t.callers, 1);
+        }
+    })
+}
+
+fn print_error_steps(steps: &[ErrorStep], level: usize) {
+    let indent = "  ".repeat(level);
+    for step in steps {
+        let handling = step.handling.map_or("?", |h| h.as_str());
+        println!(
+            "{indent}<- {handling:<10} {}  {}:{}",
+            step.caller.name, step.caller.file_path, step.line
+        );
+        print_error_steps(&step.callers, level + 1);
+    }
+}
+
+/// Every stage in the life of an error type or sentinel, from creation to handling.
+pub fn cmd_errors_lifecycle(name: &str, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let life = errors::lifecycle(&db, name, depth)?;
+
+    output(&life, json, |life| {
+        if life.definitions.is_empty() {
+            println!("No definition found for '{name}'");
+            return;
+        }
+        if life.sites.is_empty() {
+            println!("Nothing creates or checks '{name}'");
+        }
+        for site in &life.sites {
+            println!(
+                "{:<9} {}:{}  {}  {}",
+                site.stage.as_str(),
+                site.symbol.file_path,
+                site.line,
+                site.symbol.name,
+                site.detail
+            );
+        }
+    })
+}
+
+/// Call paths from entry points to panics that nothing on the way recovers.
+pub fn cmd_errors_panics(from: &[String], tag: Option<&str>, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let entries = if let Some(tag) = tag {
+        Some(db.tagged_symbols(tag)?)
+    } else if from.is_empty() {
+        None
+    } else {
+        let mut entries = Vec::new();
+        for name in from {
+            let found = db.find_definitions(name)?;
+            anyhow::ensure!(!found.is_empty(), "no definition found for '{name}'");
+            entries.extend(found);
+        }
+        Some(entries)
+    };
+    let paths = panics::unrecovered(&db, entries, depth)?;
+
+    output(&paths, json, |paths| {
+        if paths.is_empty() {
+            println!("No unrecovered panics reachable within {depth} calls.");
+        }
+        for path in paths {
+            // Each call is shown at its site, in the caller's file.
+            let mut file = "";
+            for (i, step) in path.steps.iter().enumerate() {
+                let s = &step.symbol;
+                match step.line {
+                    None => println!("{} {}  {}:{}", s.kind, s.name, s.file_path, s.start_line),
+                    Some(line) => println!("{}-> {}  {file}:{line}", "  ".repeat(i), s.name),
+                }
+                file = s.file_path.as_str();
+            }
+            let indent = "  ".repeat(path.steps.len());
+            for site in &path.sites {
+                println!("{indent}! {}  {file}:{}", site.what, site.line);
+            }
+        }
+    })
+}
+
+/// Symbols reachable from entry points, or the unreachable ones.
+pub fn cmd_reachable(
+    from: &[String],
+    unreachable: bool,
+    format: Option<TableFormatArg>,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let entries = if from.is_empty() {
+        None
+    } else {
+        let mut entries = Vec::new();
+        for name in from {
+            let found = db.find_definitions(name)?;
+            anyhow::ensure!(!found.is_empty(), "no definition found for '{name}'");
+            entries.extend(found);
+        }
+        Some(entries)
+    };
+    let found = reachable::reachable(&db, entries, unreachable)?;
+    if pipe::ids_enabled() {
+        return pipe::write_ids(found.symbols.iter().map(|s| s.id.as_str()));
+    }
+    if let Some(format) = format {
+        let rows = found.symbols.iter().map(|s| {
+            vec![
+                s.kind.to_string(),
+                s.name.clone(),
+                s.file_path.clone(),
+                s.start_line.to_string(),
+            ]
+        });
+        return table::write_table(format.into(), &["kind", "name", "file", "line"], rows);
+    }
+
+    output(&found, json, |found| {
+        println!(
+            "{} of {} symbols reachable from {} entry points",
+            found.reachable,
+            found.total,
+            found.entries.len()
+        );
+        if unreachable {
+            println!("Unreachable:");
+        }
+        for s in &found.symbols {
+            println!("  {} {}  {}:{}", s.kind, s.name, s.file_path, s.start_line);
+        }
+    })
+}
+
+/// Run a query macro from `.cartog.toml`, or list them when `name` is `None`.
+pub fn cmd_macro(name: Option<&str>, args: &[String], json: bool) -> Result<()> {
+    let config = ProjectConfig::load(Path::new("."))?;
+    let defined = config.macros();
+    let Some(name) = name else {
+        return output(defined, json, |defined| {
+            if defined.is_empty() {
+                println!("No macros defined. Add [macros.<name>] to .cartog.toml.");
+            }
+            for (name, def) in defined {
+                let params: String = def.params.iter().map(|p| format!(" <{p}>")).collect();
+                println!("{name}{params}  {}", def.description);
+            }
+        });
+    };
+    let def = defined
+        .get(name)
+        .with_context(|| format!("no macro named '{name}' in .cartog.toml"))?;
+    let db = open_query_db()?;
+    let result = macros::run(&db, name, def, args)?;
+
+    output(&result, json, |r| {
+        for (i, step) in r.steps.iter().enumerate() {
+            println!("── {}. {} ──", i + 1, step.query);
+            for run in &step.runs {
+                if run.hits.is_empty() {
+                    continue;
+                }
+                println!("{}:", run.target);
+                for hit in &run.hits {
+                    println!(
+                        "  {kind:<10} {name}  {file}:{line}",
+                        kind = hit.kind,
+                        name = hit.name,
+                        file = hit.file_path,
+                        line = hit.line,
+                    );
+                }
+            }
+            if step.truncated {
+                println!(
+                    "  (only the first {} names were followed)",
+                    macros::MAX_FAN_OUT
+                );
+            }
+        }
+    })
+}
+
+/// Check every `.cartog.toml` under `path` and print the resolved configuration.
+pub fn cmd_config_validate(path: &str, json: bool) -> Result<()> {
+    let report = validate::validate(Path::new(path))?;
+
+    output(&report, json, |r| {
+        if r.files.is_empty() {
+            println!("No .cartog.toml found; using defaults.");
+        } else {
+            println!("Checked {}", r.files.join(", "));
+        }
+        for d in &r.diagnostics {
+            let severity = match d.severity {
+                Severity::Error => "error",
+                Severity::Warning => "warning",
+            };
+            let key = if d.key.is_empty() {
+                String::new()
+            } else {
+                format!(" {}:", d.key)
+            };
+            println!("{severity:<8} {}{key} {}", d.file, d.message);
+        }
+        for file in &r.effective {
+            let name = if file.dir.is_empty() {
+                "(root)"
+            } else {
+                file.dir.as_str()
+            };
+            println!("\n── {name} ──");
+            match toml::to_string_pretty(&file.config) {
+                Ok(raw) => print!("{raw}"),
+                Err(e) => println!("(cannot render: {e})"),
+            }
+        }
+    })?;
+
+    let errors = report.count(Severity::Error);
+    anyhow::ensure!(errors == 0, "{errors} config error(s)");
+    Ok(())
+}
+
+/// Check every indexed edge against `[[arch.rules]]`; fails when any is broken.
+pub fn cmd_check_arch(json: bool) -> Result<()> {
+    let config = ProjectConfig::load(Path::new("."))?;
+    let db = open_query_db()?;
+    let violations = arch::check(&db, &config)?;
+
+    output(&violations, json, |violations| {
+        if !config.has_arch_rules() {
+            println!("No arch rules. Add [[arch.rules]] to .cartog.toml.");
+            return;
+        }
+        if violations.is_empty() {
+            println!("No violations.");
+        }
+        for v in violations {
+            let breach = match v.breach {
+                Breach::Denied => "denied",
+                Breach::NotAllowed => "not allowed",
+            };
+            let target = match &v.target_file {
+                Some(file) => format!("{} ({file})", v.target),
+                None => v.target.clone(),
+            };
+            println!(
+                "{}:{}  {} {} {target}  [{}: {breach}]",
+                v.file, v.line, v.source, v.kind, v.rule
+            );
+        }
+    })?;
+
+    let count = violations.len();
+    anyhow::ensure!(count == 0, "{count} architecture violation(s)");
+    Ok(())
+}
+
+/// Report functions that lose a context available upstream; fails when any do.
+pub fn cmd_check_ctx(depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let findings = ctx::audit(&db, depth)?;
+
+    output(&findings, json, |findings| {
+        if findings.is_empty() {
+            println!("No dropped contexts.");
+        }
+        for f in findings {
+            let s = &f.function;
+            println!("{} {}  {}:{}", s.kind, s.name, s.file_path, s.start_line);
+            if f.chain.len() > 1 {
+                let names: Vec<_> = f.chain.iter().map(|s| s.symbol.name.as_str()).collect();
+                println!("  context from {}", names.join(" -> "));
+            }
+            for site in &f.sites {
+                println!(
+                    "  {:<6} {}  {}:{}",
+                    site.loss.as_str(),
+                    site.callee,
+                    s.file_path,
+                    site.line
+                );
+            }
+        }
+    })?;
+
+    let count = findings.len();
+    anyhow::ensure!(count == 0, "{count} function(s) drop a context");
+    Ok(())
+}
+
+/// Uses of deprecated symbols, the project's own and those of the Go modules in
+/// `deps`, with counts per package.
+pub fn cmd_check_deprecated(deps: &[String], max: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let codeowners = CodeOwners::load(Path::new("."))?;
+    let mut deprecations = deprecations::report(&db, codeowners.as_ref(), None)?;
+    deprecations.retain(|d| !d.usages.is_empty());
+    for dir in deps {
+        let root = Path::new(dir);
+        let go_mod = std::fs::read_to_string(root.join("go.mod"))
+            .map(|text| deps_usage::parse_go_mod(&text))
+            .with_context(|| format!("{dir} is not a Go module (no go.mod)"))?;
+        let module = go_mod
+            .module
+            .with_context(|| format!("{dir}/go.mod declares no module path"))?;
+        let index = root.join(DB_FILE);
+        let dep = if index.exists() {
+            Database::open_read_only(&index)?
+        } else {
+            let dep = Database::open_memory()?;
+            indexer::index_directory(&dep, root, true)
+                .with_context(|| format!("failed to index {dir}"))?;
+            dep
+        };
+        deprecations.extend(deprecations::dependency_report(
+            &db,
+            &dep,
+            &module,
+            codeowners.as_ref(),
+        )?);
+    }
+    let packages = deprecations::by_package(&deprecations);
+    let total: u32 = packages.iter().map(|p| p.usages).sum();
+
+    #[derive(Serialize)]
+    struct Report<'a> {
+        deprecations: &'a [deprecations::Deprecation],
+        packages: &'a [deprecations::PackageCount],
+        total: u32,
+        max: u32,
+    }
+    let report = Report {
+        deprecations: &deprecations,
+        packages: &packages,
+        total,
+        max,
+    };
+    output(&report, json, |r| {
+        if r.deprecations.is_empty() {
+            println!("No deprecated symbols in use");
+            return;
+        }
+        for d in r.deprecations {
+            let module = d
+                .module
+                .as_ref()
+                .map(|m| format!("  ({m})"))
+                .unwrap_or_default();
+            println!(
+                "{}  {}:{}{module}  {} uses",
+                d.symbol.name,
+                d.symbol.file_path,
+                d.symbol.start_line,
+                d.usages.len()
+            );
+            if !d.note.is_empty() {
+                println!("  Deprecated: {}", d.note);
+            }
+            for u in &d.usages {
+                println!("  {}  {}:{}", u.symbol.name, u.file, u.line);
+            }
+        }
+        println!("\nBy package:");
+        for p in r.packages {
+            println!("  {:>5}  {}", p.usages, p.package);
+        }
+        println!("  {:>5}  total", r.total);
+    })?;
+
+    anyhow::ensure!(
+        total <= max,
+        "{total} use(s) of deprecated symbols, more than {max}"
+    );
+    Ok(())
+}
+
+/// Go uses of `unsafe`, `reflect` and `//go:linkname`, with the calls leading to each.
+pub fn cmd_check_unsafe(depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let findings = unsafe_audit::audit(&db, depth)?;
+
+    output(&findings, json, |findings| {
+        if findings.is_empty() {
+            println!("No unsafe, reflect or linkname uses.");
+        }
+        for f in findings {
+            let s = &f.function;
+            println!("{} {}  {}:{}", s.kind, s.name, s.file_path, s.start_line);
+            for u in &f.uses {
+                println!(
+                    "  {:<8} {}  {}:{}",
+                    u.kind.as_str(),
+                    u.target,
+                    s.file_path,
+                    u.line
+                );
+            }
+            print_call_steps(&f.callers, 1);
+        }
+    })?;
+
+    let count = findings.len();
+    anyhow::ensure!(
+        count == 0,
+        "{count} function(s) use unsafe, reflect or linkname"
+    );
+    Ok(())
+}
+
+/// Print the JSON Schema of `.cartog.toml`.
+pub fn cmd_config_schema() -> Result<()> {
+    print!("{}", validate::SCHEMA);
+    Ok(())
+}
+
+/// Symbol-level diff between two snapshots.
+/// Release-notes draft between two revisions: symbol changes grouped by package.
+pub fn cmd_changelog(from: &str, to: &str, depth: usize, json: bool) -> Result<()> {
+    let diff = diff::diff_refs(Path::new("."), from, to)?;
+    let changelog = changelog::from_diff(&diff, depth);
+
+    output(&changelog, json, |c| {
+        print!("{}", changelog::render_markdown(c));
+    });
+    Ok(())
+}
+
+pub fn cmd_diff(from: &str, to: &str, json: bool) -> Result<()> {
+    let diff = diff::diff_refs(Path::new("."), from, to)?;
+
+    output(&diff, json, |d| {
+        println!(
+            "{from} -> {to}: {} added, {} removed, {} signature changed, {} body changed",
+            d.count(ChangeKind::Added),
+            d.count(ChangeKind::Removed),
+            d.count(ChangeKind::SignatureChanged),
+            d.count(ChangeKind::BodyChanged),
+        );
+        for c in &d.symbols {
+            let detail = match c.change {
+                ChangeKind::SignatureChanged => format!(
+                    "  {} => {}",
+                    c.old_signature.as_deref().unwrap_or(""),
+                    c.new_signature.as_deref().unwrap_or("")
+                ),
+                ChangeKind::BodyChanged => "  (body)".to_string(),
+                _ => String::new(),
+            };
+            println!(
+                "{marker} {kind}  {name}  {file}:{line}{detail}",
+                marker = c.change.marker(),
+                kind = c.kind,
+                name = c.qualified_name,
+                file = c.file_path,
+                line = c.line,
+            );
+        }
+        if !d.edges_added.is_empty() || !d.edges_removed.is_empty() {
+            println!(
+                "\nEdges: {} added, {} removed",
+                d.edges_added.len(),
+                d.edges_removed.len()
+            );
+            let tagged = d
+                .edges_added
+                .iter()
+                .map(|e| ('+', e))
+                .chain(d.edges_removed.iter().map(|e| ('-', e)));
+            for (marker, e) in tagged {
+                println!(
+                    "{marker} {kind}  {source} -> {target}  {file}",
+                    kind = e.kind,
+                    source = e.source,
+                    target = e.target,
+                    file = e.file_path,
+                );
+            }
+        }
+    })
+}
+
+/// Prepare a pull-request review: index head and base, diff, precompute impact.
+pub fn cmd_pr_prepare(base: &str, depth: u32, json: bool) -> Result<()> {
+    let db = open_db()?;
+    let report = pr::prepare(&db, Path::new("."), base, depth)?;
+
+    output(&report, json, |r| {
+        let short = |c: &str| c[..c.len().min(8)].to_string();
+        println!(
+            "base  {base} ({commit}, {source})",
+            commit = short(&r.base_commit),
+            source = r.base_source.as_str(),
+        );
+        println!(
+            "head  {commit} ({} files re-indexed)",
+            r.head_index.files_indexed,
+            commit = r.head_commit.as_deref().map_or("-".to_string(), short),
+        );
+        println!(
+            "{} added, {} removed, {} signature changed, {} body changed",
+            r.diff.count(ChangeKind::Added),
+            r.diff.count(ChangeKind::Removed),
+            r.diff.count(ChangeKind::SignatureChanged),
+            r.diff.count(ChangeKind::BodyChanged),
+        );
+        for c in &r.impact {
+            println!(
+                "{marker} {name}  {file}:{line}  {n} dependents",
+                marker = c.change.marker(),
+                name = c.qualified_name,
+                file = c.file_path,
+                line = c.line,
+                n = c.dependents.len(),
+            );
+        }
+        println!("report: {}", r.report_path.display());
+    })
+}
+
+/// Commits that modified a symbol.
+pub fn cmd_history(name: &str, limit: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let histories = history::symbol_history(&db, Path::new("."), name, limit)?;
+
+    output(&histories, json, |hs| {
+        if hs.is_empty() {
+            println!("No definition found for '{name}'");
+            return;
+        }
+        for h in hs {
+            println!(
+                "{kind} {name}  {file}:{start}-{end}",
+                kind = h.symbol.kind,
+                name = h.symbol.name,
+                file = h.symbol.file_path,
+                start = h.symbol.start_line,
+                end = h.symbol.end_line,
+            );
+            for link in &h.renamed_from {
+                println!(
+                    "  formerly {old} in {file} ({reason})",
+                    old = link.old_name,
+                    file = link.old_file,
+                    reason = link.reason.as_str(),
+                );
+            }
+            if h.commits.is_empty() {
+                println!("  (no commits — file not tracked by git?)");
+            }
+            for c in &h.commits {
+                let short = &c.sha[..c.sha.len().min(8)];
+                let day = c.date.get(..10).unwrap_or(&c.date);
+                println!(
+                    "  {short}  {day}  {author}  {subject}",
+                    author = c.author,
+                    subject = c.subject
+                );
+            }
+        }
+    })
+}
+
+/// Rank code by churn × fan-in.
+pub fn cmd_hotspots(
+    by: HotspotGranularity,
+    since: Option<&str>,
+    limit: u32,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let ranked = hotspots::hotspots(&db, Path::new("."), by.into(), since, limit as usize)?;
+
+    output(&ranked, json, |ranked| {
+        if ranked.is_empty() {
+            println!("No hotspots found (no git history for indexed files?)");
+            return;
+        }
+        for h in ranked {
+            let location = match (&h.file_path, h.line) {
+                (Some(file), Some(line)) => format!("  {file}:{line}"),
+                _ => String::new(),
+            };
+            println!(
+                "{score:>8.1}  {churn:>4} commits  {fan_in:>4} dependents  {kind} {name}{location}",
+                score = h.score,
+                churn = h.churn,
+                fan_in = h.fan_in,
+                kind = h.kind,
+                name = h.name,
+            );
+        }
+    })
+}
+
+/// Symbols changed in the last `commits` commits, most depended upon first.
+pub fn cmd_recent(commits: u32, depth: u32, json: bool) -> Result<()> {
+    let db = open_query_db()?;
+    let recent = recent::recent(&db, Path::new("."), commits, depth)?;
+    if pipe::ids_enabled() {
+        return pipe::write_ids(recent.changes.iter().map(|c| c.symbol.id.as_str()));
+    }
+
+    output(&recent, json, |recent| {
+        if recent.commits.is_empty() {
+            println!("No commits found (not a git repository?)");
+            return;
+        }
+        for c in &recent.commits {
+            let short = &c.sha[..c.sha.len().min(8)];
+            let day = c.date.get(..10).unwrap_or(&c.date);
+            println!(
+                "{short}  {day}  {author}  {subject}",
+                author = c.author,
+                subject = c.subject
+            );
+        }
+        println!();
+        if recent.changes.is_empty() {
+            println!("No indexed symbol changed.");
+            return;
+        }
+        for c in &recent.changes {
+            let s = &c.symbol;
+            println!(
+                "{impact:>4} dependents  {direct:>4} direct  {kind} {name}  {file}:{line}  ({lines} lines changed)",
+                impact = c.impact,
+                direct = c.direct,
+                kind = s.kind,
+                name = s.name,
+                file = s.file_path,
+                line = s.start_line,
+                lines = c.lines,
+            );
+        }
+    })
+}
+
+/// The `top` symbols by PageRank or betweenness, of `kind` when given.
+pub fn cmd_rank(
+    top: u32,
+    kind: Option<SymbolKindFilter>,
+    by: RankMetricArg,
+    json: bool,
+) -> Result<()> {
+    let db = open_query_db()?;
+    let ranked = db.top_ranked(kind.map(SymbolKind::from), by.into(), top)?;
+    if pipe::ids_enabled() {
+        return pipe::write_ids(ranked.iter().map(|(s, _)| s.id.as_str()));
+    }
+
+    #[derive(Serialize)]
+    struct Ranked<'a> {
+        #[serde(flatten)]
+        symbol: &'a Symbol,
+        #[serde(flatten)]
+        centrality: Centrality,
+    }
+    let ranked: Vec<Ranked> = ranked
+        .iter()
+        .map(|(symbol, centrality)| Ranked {
+            symbol,
+            centrality: *centrality,
+        })
+        .collect();
+
+    output(&ranked, json, |ranked| {
+        if ranked.is_empty() {
+            println!("No ranked symbols (re-run cartog index)");
+        }
+        for r in ranked {
+            let s = r.symbol;
+            let score = match by {
+                RankMetricArg::Pagerank => format!("{:>8.2}", r.centrality.pagerank),
+                RankMetricArg::Betweenness => format!("{:>8.4}", r.centrality.betweenness),
+            };
+            println!(
+                "{score}  {} {}  {}:{}",
+                s.kind, s.name, s.file_path, s.start_line
+            );
+        }
+    })
+}
+
+// ── RAG Commands ──
+
 /// Download the embedding model.
 pub fn cmd_rag_setup(json: bool) -> Result<()> {
     // Download bi-encoder (embeddings)
@@ -328,13 +3087,19 @@ pub fn cmd_rag_index(path: &str, force: bool, json: bool) -> Result<()> {
 pub fn cmd_rag_search(
     query: &str,
     kind: Option<SymbolKindFilter>,
+    tag: Option<&str>,
     limit: u32,
     json: bool,
 ) -> Result<()> {
-    let db = open_db()?;
+    let db = open_query_db()?;
     let kind_filter = kind.map(crate::types::SymbolKind::from);
 
-    let search_result = rag::search::hybrid_search(&db, query, limit, kind_filter)?;
+    let config = ProjectConfig::load(Path::new("."))?;
+    let mut search_result =
+        rag::search::hybrid_search_with(&db, query, limit, kind_filter, &config)?;
+    // Applied after ranking, so a tag can return fewer than `limit` results.
+    let tagged = db.tag_filter(tag)?;
+    search_result.results.retain(|r| tagged.keeps(&r.symbol.id));
 
     output(&search_result, json, |sr| {
         if sr.results.is_empty() {
@@ -373,7 +3138,7 @@ pub fn cmd_rag_search(
                 let preview: String = content
                     .lines()
                     .take(3)
-                    .map(|l| format!("    {l}"))
+                    .map(|l| format!("    {}", snippet(&r.symbol.file_path, l)))
                     .collect::<Vec<_>>()
                     .join("\n");
                 println!("{preview}\n");
@@ -391,3 +3156,233 @@ pub fn cmd_watch(path: &str, debounce: u64, rag: bool, rag_delay: u64) -> Result
 
     watch::run_watch(config, DB_FILE)
 }
+
+/// Print an `--explain` report on stderr, keeping stdout clean for command output.
+///
+/// Query plans are computed afterwards on a separate, untraced connection.
+pub fn print_explain(mut report: ExplainReport, json: bool) -> Result<()> {
+    if !report.statements.is_empty() && Path::new(DB_FILE).exists() {
+        let db = open_db()?;
+        for stmt in &mut report.statements {
+            // Plans are best-effort: virtual tables or DDL may not be explainable.
+            stmt.plan = db.query_plan(&stmt.sql).unwrap_or_default();
+        }
+    }
+
+    if json {
+        eprintln!("{}", serde_json::to_string_pretty(&report)?);
+        return Ok(());
+    }
+
+    eprintln!("── explain ──");
+    eprintln!("total: {:.2} ms", report.total_ms);
+    for stage in &report.stages {
+        eprintln!("  {:<10} {:>9.2} ms", stage.stage, stage.ms);
+    }
+    eprintln!(
+        "page cache: {} hits, {} misses",
+        report.cache_hits, report.cache_misses
+    );
+    for stmt in &report.statements {
+        let sql = stmt.sql.split_whitespace().collect::<Vec<_>>().join(" ");
+        eprintln!(
+            "\n{} call(s), {:.2} ms, {} full-scan steps, {} vm steps, {} sorts, {} auto-indexes",
+            stmt.calls,
+            stmt.total_ms,
+            stmt.fullscan_steps,
+            stmt.vm_steps,
+            stmt.sorts,
+            stmt.auto_indexes
+        );
+        eprintln!("  {sql}");
+        for line in &stmt.plan {
+            eprintln!("    plan: {line}");
+        }
+    }
+    Ok(())
+}
+
+/// Run `op` under the profiler and write `trace.json` + `report.json`.
+///
+/// The operation's own output goes to stdout as usual; the profile summary is
+/// printed on stderr.
+pub fn cmd_profile(
+    kind: &str,
+    out: Option<&str>,
+    trace: &SpanTrace,
+    json: bool,
+    op: impl FnOnce() -> Result<()>,
+) -> Result<()> {
+    let out_dir = out.map_or_else(|| profile::default_out_dir(kind), PathBuf::from);
+
+    explain::enable();
+    let cpu_before = profile::cpu_time();
+    profile::start_heap_tracking();
+    let started = std::time::Instant::now();
+
+    let result = op();
+
+    let wall_ms = started.elapsed().as_secs_f64() * 1000.0;
+    let heap = profile::HEAP_TRACKED.then(profile::stop_heap_tracking);
+    let cpu_time = profile::cpu_time()
+        .zip(cpu_before)
+        .map(|(after, before)| CpuTime {
+            user_ms: after.user_ms - before.user_ms,
+            system_ms: after.system_ms - before.system_ms,
+        });
+    let statements = explain::finish("output")
+        .map(|r| r.statements)
+        .unwrap_or_default();
+    let events = trace.drain();
+    let cpu = trace.drain_cpu();
+
+    let mut report = ProfileReport::new(kind, wall_ms, cpu_time, heap, &events, statements);
+    report.write(&out_dir, &events, &cpu)?;
+
+    if json {
+        eprintln!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        eprintln!("── profile: {} ──", report.operation);
+        eprint!("wall: {:.1} ms", report.wall_ms);
+        if let Some(cpu) = report.cpu_time {
+            eprint!(
+                "  cpu time: {:.0} ms user, {:.0} ms system",
+                cpu.user_ms, cpu.system_ms
+            );
+        }
+        eprintln!();
+        match &report.heap {
+            Some(heap) => eprintln!(
+                "heap: peak +{:.1} MiB, {:.1} MiB allocated in {} allocations",
+                heap.peak_bytes as f64 / (1024.0 * 1024.0),
+                heap.allocated_bytes as f64 / (1024.0 * 1024.0),
+                heap.allocations
+            ),
+            None => eprintln!("heap: not tracked (build with --features heap-profile)"),
+        }
+        if !report.spans.is_empty() {
+            eprintln!("spans:");
+            for span in &report.spans {
+                eprintln!(
+                    "  {:<20} {:>6} call(s) {:>10.1} ms",
+                    span.name, span.calls, span.total_ms
+                );
+            }
+        }
+        if !report.statements.is_empty() {
+            eprintln!("slowest SQL:");
+            for stmt in &report.statements {
+                let sql = stmt.sql.split_whitespace().collect::<Vec<_>>().join(" ");
+                let sql: String = sql.chars().take(100).collect();
+                eprintln!("  {:>8.1} ms {:>6}x  {sql}", stmt.total_ms, stmt.calls);
+            }
+        }
+        eprintln!("written to {}", out_dir.display());
+    }
+    result
+}
+
+/// Benchmark the fixture suites and print per-fixture timings and deltas.
+///
+/// With `fail_on_regression`, fails after printing when a metric is worse than
+/// the baseline by more than that many percent.
+pub fn cmd_bench(
+    config: BenchConfig,
+    compare: Option<&str>,
+    fail_on_regression: Option<f64>,
+    json: bool,
+) -> Result<()> {
+    if fail_on_regression.is_some() && config.baseline.is_none() && compare.is_none() {
+        anyhow::bail!("--fail-on-regression needs a baseline (--baseline or --compare)");
+    }
+    let previous = compare
+        .map(|path| -> Result<BenchReport> {
+            let raw =
+                std::fs::read_to_string(path).with_context(|| format!("cannot read {path}"))?;
+            serde_json::from_str(&raw).with_context(|| format!("invalid bench report {path}"))
+        })
+        .transpose()?;
+    let mut report = bench::run(&config)?;
+    if let Some(previous) = previous {
+        report.compare_with(previous);
+    }
+    if let Some(threshold) = fail_on_regression {
+        report.check_regressions(threshold);
+    }
+
+    output(&report, json, |r| {
+        println!(
+            "{:<12} {:<9} {:>10} {:>10} {:>9} {:>9} {:>9} {:>9}",
+            "fixture", "binary", "index", "size", "p50", "p90", "p99", "accuracy"
+        );
+        for run in &r.runs {
+            let accuracy = run
+                .accuracy
+                .map_or_else(|| "-".to_string(), |a| format!("{a:.1}%"));
+            println!(
+                "{:<12} {:<9} {:>8.1}ms {:>6.1} KiB {:>7.2}ms {:>7.2}ms {:>7.2}ms {:>9}",
+                run.fixture,
+                run.binary,
+                run.index_ms,
+                run.index_bytes as f64 / 1024.0,
+                run.p50_ms,
+                run.p90_ms,
+                run.p99_ms,
+                accuracy
+            );
+        }
+        let incremental: Vec<_> = r
+            .runs
+            .iter()
+            .filter_map(|run| Some((run, run.incremental.as_ref()?)))
+            .collect();
+        if !incremental.is_empty() {
+            println!("\nincremental:");
+            for (run, inc) in incremental {
+                let stale = if inc.stale_runs > 0 {
+                    format!("  ({} stale run(s))", inc.stale_runs)
+                } else {
+                    String::new()
+                };
+                println!(
+                    "{:<12} {:<9} {} file(s) changed  index {:.1}ms  fresh result {:.1}ms{stale}",
+                    run.fixture, run.binary, inc.files_changed, inc.index_ms, inc.fresh_ms
+                );
+            }
+        }
+        if !r.deltas.is_empty() {
+            println!("\nvs baseline:");
+            for d in &r.deltas {
+                let accuracy = d
+                    .accuracy_pct
+                    .map(|a| format!("  accuracy {a:+.1}%"))
+                    .unwrap_or_default();
+                let incremental = d
+                    .incremental_ms_pct
+                    .map(|i| format!("  incremental {i:+.1}%"))
+                    .unwrap_or_default();
+                println!(
+                    "{:<12} index {:+.1}%  size {:+.1}%  p50 {:+.1}%  p99 {:+.1}%{incremental}{accuracy}",
+                    d.fixture, d.index_ms_pct, d.index_bytes_pct, d.p50_pct, d.p99_pct
+                );
+            }
+        }
+        if let Some(threshold) = r.fail_on_regression {
+            if r.regressions.is_empty() {
+                println!("\nNo regression over {threshold}%.");
+            } else {
+                println!("\nRegressions over {threshold}%:");
+                for reg in &r.regressions {
+                    println!(
+                        "{:<12} {:<9} {:>10.2} -> {:<10.2} {:+.1}%",
+                        reg.fixture, reg.metric, reg.baseline, reg.current, reg.change_pct
+                    );
+                }
+            }
+        }
+    })?;
+
+    let count = report.regressions.len();
+    anyhow::ensure!(count == 0, "{count} benchmark regression(s)");
+    Ok(())
+}
@@ -0,0 +1,146 @@
+//! Shell completions that know the index.
+//!
+//! `cartog completions <shell>` prints clap's completion script for the
+//! commands and flags, followed by a hook that completes the symbol argument of
+//! [`SYMBOL_COMMANDS`] by running `cartog complete <prefix>`. That command skips
+//! everything a query normally does first (config walk, staleness check, tracing)
+//! and answers with a range scan of the symbol name index, so a tab press costs
+//! a few milliseconds even on large indexes.
+
+use std::fmt::Write as _;
+
+/// Commands whose first argument is a symbol name.
+pub const SYMBOL_COMMANDS: [&str; 8] = [
+    "benchmarks",
+    "callees",
+    "hierarchy",
+    "history",
+    "impact",
+    "refs",
+    "search",
+    "sequence",
+];
+
+/// Names `cartog complete` prints by default.
+pub const DEFAULT_COMPLETIONS: u32 = 100;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Shell {
+    Bash,
+    Zsh,
+    Fish,
+}
+
+/// The completion script for `shell`: clap's for `cmd`, then the symbol hook.
+pub fn script(shell: Shell, cmd: &mut clap::Command) -> String {
+    let generator = match shell {
+        Shell::Bash => clap_complete::Shell::Bash,
+        Shell::Zsh => clap_complete::Shell::Zsh,
+        Shell::Fish => clap_complete::Shell::Fish,
+    };
+    let name = cmd.get_name().to_string();
+    let mut out = Vec::new();
+    clap_complete::generate(generator, cmd, &name, &mut out);
+    let mut script = String::from_utf8_lossy(&out).into_owned();
+    script.push_str(&symbol_hook(shell, &name));
+    script
+}
+
+/// Completes symbol arguments from `<bin> complete`, leaving the rest to clap's
+/// script.
+fn symbol_hook(shell: Shell, bin: &str) -> String {
+    let mut hook = String::new();
+    match shell {
+        Shell::Bash => {
+            let _ = write!(
+                hook,
+                r#"
+_{bin}_symbols() {{
+    local cur="${{COMP_WORDS[COMP_CWORD]}}"
+    if [[ $COMP_CWORD -eq 2 && "$cur" != -* ]]; then
+        case "${{COMP_WORDS[1]}}" in
+            {commands})
+                COMPREPLY=($(compgen -W "$({bin} complete -- "$cur" 2>/dev/null)" -- "$cur"))
+                return 0
+                ;;
+        esac
+    fi
+    _{bin} "$@"
+}}
+complete -F _{bin}_symbols -o bashdefault -o default {bin}
+"#,
+                commands = SYMBOL_COMMANDS.join("|"),
+            );
+        }
+        Shell::Zsh => {
+            let _ = write!(
+                hook,
+                r#"
+_{bin}_symbols() {{
+    if (( CURRENT == 3 )) && [[ $PREFIX != -* ]]; then
+        case $words[2] in
+            ({commands})
+                local -a names
+                names=(${{(f)"$({bin} complete -- "$PREFIX" 2>/dev/null)"}})
+                compadd -a names
+                return
+                ;;
+        esac
+    fi
+    _{bin} "$@"
+}}
+compdef _{bin}_symbols {bin}
+"#,
+                commands = SYMBOL_COMMANDS.join("|"),
+            );
+        }
+        Shell::Fish => {
+            let _ = write!(
+                hook,
+                r#"
+complete -c {bin} -n '__fish_seen_subcommand_from {commands}; and test (count (commandline -opc)) -eq 2' -f -a '({bin} complete -- (commandline -ct) 2>/dev/null)'
+"#,
+                commands = SYMBOL_COMMANDS.join(" "),
+            );
+        }
+    }
+    hook
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::db::Database;
+    use crate::types::{Symbol, SymbolKind};
+
+    #[test]
+    fn test_scripts_complete_symbols_from_index() {
+        let mut cmd = clap::Command::new("cartog")
+            .subcommand(clap::Command::new("refs").arg(clap::Arg::new("name")));
+        let bash = script(Shell::Bash, &mut cmd);
+        assert!(bash.contains("_cartog()"), "clap's script comes first");
+        assert!(bash.contains("callees|hierarchy|history|impact|refs|search|sequence)"));
+        assert!(bash.contains("complete -F _cartog_symbols"));
+        assert!(script(Shell::Zsh, &mut cmd).contains("compdef _cartog_symbols cartog"));
+        assert!(script(Shell::Fish, &mut cmd).contains("(cartog complete -- (commandline -ct)"));
+
+        let db = Database::open_memory().unwrap();
+        for (name, line) in [("validate_token", 1), ("validate", 5), ("Validator", 9)] {
+            db.insert_symbol(&Symbol::new(
+                name,
+                SymbolKind::Function,
+                "auth.py",
+                line,
+                line + 2,
+                0,
+                10,
+            ))
+            .unwrap();
+        }
+        assert_eq!(
+            db.complete_names("valid", 10).unwrap(),
+            ["validate", "validate_token"]
+        );
+        assert_eq!(db.complete_names("", 1).unwrap(), ["Validator"]);
+    }
+}
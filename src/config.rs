@@ -0,0 +1,1260 @@
+//! Project configuration from `.cartog.toml` files.
+//!
+//! A `.cartog.toml` at the project root applies to the whole tree. Any directory
+//! below it may carry its own `.cartog.toml`; its settings apply to that subtree
+//! and are layered on top of its ancestors':
+//!
+//! - `[index] ignore` globs add up. Each layer's globs match paths relative to the
+//!   directory that holds the file.
+//! - `[languages] enable` / `disable` are applied root first, so the deepest layer
+//!   that mentions a language decides.
+//! - `[ranking] boost` multipliers compound across layers.
+//!
+//! Every key can also be set with a `CARTOG_<SECTION>_<KEY>` environment variable
+//! (see [`env_overrides`]). An environment value replaces that key in the root
+//! config; directory-level files still refine their own subtrees.
+//!
+//! ```toml
+//! [index]
+//! ignore = ["generated/**", "**/*_pb2.py"]
+//!
+//! [languages]
+//! disable = ["javascript"]
+//!
+//! [ranking.boost]
+//! "core/**" = 2.0
+//! "tests/**" = 0.5
+//! ```
+
+use std::collections::{BTreeMap, HashMap};
+use std::path::{Path, PathBuf};
+
+use anyhow::{Context, Result};
+use globset::{Glob, GlobBuilder, GlobMatcher, GlobSet, GlobSetBuilder};
+use serde::{Deserialize, Serialize};
+use toml::{Table, Value};
+use walkdir::WalkDir;
+
+use crate::alerts::{AlertsSection, DEFAULT_DEPTH};
+use crate::hooks::HooksSection;
+use crate::indexer::is_ignored_dirname;
+use crate::init::McpClient;
+use crate::macros::MacroDef;
+use crate::plugins::DEFAULT_PLUGIN_DIR;
+use crate::types::{EdgeKind, Symbol, SymbolKind};
+
+/// File name of a project or directory-level config.
+pub const CONFIG_FILE: &str = ".cartog.toml";
+
+/// Prefix of the environment variables that override config keys.
+pub const ENV_PREFIX: &str = "CARTOG_";
+
+/// The contents of one `.cartog.toml`. Every section is optional.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ConfigFile {
+    pub index: IndexSection,
+    pub languages: LanguagesSection,
+    pub ranking: RankingSection,
+    pub extract: ExtractSection,
+    /// Symbol tagging rules by label.
+    pub tags: BTreeMap<String, TagRule>,
+    pub arch: ArchSection,
+    pub plugins: PluginsSection,
+    /// Query macros by name. Only read from the root config.
+    pub macros: BTreeMap<String, MacroDef>,
+    /// Lifecycle hooks. Only read from the root config.
+    pub hooks: HooksSection,
+    /// Symbols the watcher raises alerts for. Only read from the root config.
+    pub alerts: AlertsSection,
+    /// Command aliases: name to the command line it stands for. Only read from
+    /// the root and user configs.
+    pub aliases: BTreeMap<String, String>,
+    /// Personal preferences, meant for the user config.
+    pub output: OutputSection,
+    pub editor: EditorSection,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct IndexSection {
+    /// Globs of files to leave out of the index, on top of the built-in directory list.
+    pub ignore: Vec<String>,
+    /// Index the Go packages in `vendor/` as an external tier, so calls resolve into
+    /// dependencies. Only read from the root config.
+    pub vendor: bool,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct LanguagesSection {
+    /// Languages to index again below this directory after an ancestor disabled them.
+    pub enable: Vec<String>,
+    /// Languages not to index below this directory (`python`, `typescript`, `tsx`,
+    /// `javascript`, `rust`, `go`, `ruby`).
+    pub disable: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct RankingSection {
+    /// Search score multipliers keyed by glob. `2.0` doubles a match's score.
+    pub boost: BTreeMap<String, f64>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ExtractSection {
+    /// Passes to turn off (or back on) for matching files, applied in order.
+    pub rules: Vec<ExtractRule>,
+}
+
+/// `[[extract.rules]]`: which passes run for some paths and languages.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ExtractRule {
+    /// Globs relative to the directory holding the file. Empty matches every file.
+    pub paths: Vec<String>,
+    /// Languages the rule applies to. Empty matches every language.
+    pub languages: Vec<String>,
+    pub skip: Vec<Pass>,
+    /// Passes to run again after an earlier rule skipped them.
+    pub keep: Vec<Pass>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ArchSection {
+    /// Dependency boundaries checked by `cartog check arch`.
+    pub rules: Vec<ArchRule>,
+}
+
+/// `[[arch.rules]]`: what files matching `from` may depend on.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ArchRule {
+    /// Shown with violations; defaults to the rule's position.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub name: Option<String>,
+    /// Globs of the files the rule constrains; empty = every file.
+    pub from: Vec<String>,
+    /// Globs of files they must not depend on.
+    pub deny: Vec<String>,
+    /// Globs of the only files they may depend on, when non-empty.
+    pub allow: Vec<String>,
+    /// Edge kinds checked; empty = all.
+    pub kinds: Vec<EdgeKind>,
+}
+
+/// How an edge breaks an [`ArchRule`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Breach {
+    /// The target matches `deny`.
+    Denied,
+    /// The target is outside `allow`.
+    NotAllowed,
+}
+
+/// `[tags.<label>]`: attach `label` to symbols matching every criterion given.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct TagRule {
+    /// Globs on the file path, relative to the directory holding the config file.
+    pub paths: Vec<String>,
+    /// Globs on the symbol name (`handle_*`, `*Controller`).
+    pub names: Vec<String>,
+    /// Text in the symbol's docstring, or in the comments and attributes directly
+    /// above it (`@deprecated`, `#[deprecated]`, `// perf: hot`).
+    pub annotations: Vec<String>,
+    pub kinds: Vec<SymbolKind>,
+}
+
+impl TagRule {
+    /// A rule without criteria would tag everything; it tags nothing instead.
+    pub fn is_empty(&self) -> bool {
+        self.paths.is_empty()
+            && self.names.is_empty()
+            && self.annotations.is_empty()
+            && self.kinds.is_empty()
+    }
+}
+
+/// An optional part of extraction. Symbols themselves are always extracted.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Pass {
+    Calls,
+    Imports,
+    Inherits,
+    References,
+    Raises,
+    /// Every edge kind above.
+    Edges,
+    /// Symbol source stored for RAG search.
+    Content,
+}
+
+impl Pass {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Calls => "calls",
+            Self::Imports => "imports",
+            Self::Inherits => "inherits",
+            Self::References => "references",
+            Self::Raises => "raises",
+            Self::Edges => "edges",
+            Self::Content => "content",
+        }
+    }
+
+    fn bits(self) -> u8 {
+        match self {
+            Self::Calls => 1,
+            Self::Imports => 1 << 1,
+            Self::Inherits => 1 << 2,
+            Self::References => 1 << 3,
+            Self::Raises => 1 << 4,
+            Self::Edges => 0b1_1111,
+            Self::Content => 1 << 5,
+        }
+    }
+}
+
+/// The passes that run for one file.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Passes {
+    skipped: u8,
+}
+
+impl Passes {
+    pub const ALL: Self = Self { skipped: 0 };
+
+    fn runs(self, pass: Pass) -> bool {
+        self.skipped & pass.bits() == 0
+    }
+
+    /// Whether edges of `kind` are kept.
+    pub fn edge(self, kind: EdgeKind) -> bool {
+        self.runs(match kind {
+            EdgeKind::Calls => Pass::Calls,
+            EdgeKind::Imports => Pass::Imports,
+            EdgeKind::Inherits => Pass::Inherits,
+            EdgeKind::References => Pass::References,
+            EdgeKind::Raises => Pass::Raises,
+        })
+    }
+
+    /// Whether symbol content is stored.
+    pub fn content(self) -> bool {
+        self.runs(Pass::Content)
+    }
+}
+
+impl Default for Passes {
+    fn default() -> Self {
+        Self::ALL
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct OutputSection {
+    /// Output format when neither `--json` nor `CARTOG_OUTPUT_FORMAT` says otherwise.
+    pub format: OutputFormat,
+    /// Page long human output on a terminal.
+    pub pager: bool,
+}
+
+impl Default for OutputSection {
+    fn default() -> Self {
+        Self {
+            format: OutputFormat::default(),
+            pager: true,
+        }
+    }
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum OutputFormat {
+    #[default]
+    Human,
+    Json,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct EditorSection {
+    /// MCP clients whose setup `cartog init` prints without being asked.
+    pub mcp: Vec<McpClient>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct PluginsSection {
+    /// Directory of WASI extractor plugins, relative to the project root. Only
+    /// read from the root config.
+    pub dir: String,
+}
+
+impl Default for PluginsSection {
+    fn default() -> Self {
+        Self {
+            dir: DEFAULT_PLUGIN_DIR.to_string(),
+        }
+    }
+}
+
+impl ConfigFile {
+    pub fn parse(raw: &str) -> Result<Self> {
+        Ok(toml::from_str(raw)?)
+    }
+
+    pub fn load(path: &Path) -> Result<Self> {
+        read_table(path).and_then(|table| Self::from_table(table, path))
+    }
+
+    pub(crate) fn from_table(table: Table, path: &Path) -> Result<Self> {
+        table
+            .try_into()
+            .with_context(|| format!("invalid config in {}", path.display()))
+    }
+}
+
+/// The user's home directory, from `HOME` or `USERPROFILE`.
+pub fn home_dir() -> Option<PathBuf> {
+    std::env::var("HOME")
+        .or_else(|_| std::env::var("USERPROFILE")) // Windows fallback
+        .ok()
+        .map(PathBuf::from)
+}
+
+/// Personal config: `$XDG_CONFIG_HOME/cartog/config.toml`, else
+/// `~/.config/cartog/config.toml`.
+pub fn user_config_path() -> Option<PathBuf> {
+    let base = std::env::var("XDG_CONFIG_HOME")
+        .ok()
+        .filter(|dir| !dir.is_empty())
+        .map(PathBuf::from)
+        .or_else(|| home_dir().map(|home| home.join(".config")))?;
+    Some(base.join("cartog").join("config.toml"))
+}
+
+/// The user config with `CARTOG_*` overrides, for settings that do not depend
+/// on the project (`[output]`, `[editor]`). Cheap: reads a single file.
+pub fn user_config() -> Result<ConfigFile> {
+    let path = user_config_path().unwrap_or_default();
+    let mut table = read_user_table(Some(&path))?;
+    apply_overrides(&mut table, &env_overrides(std::env::vars())?);
+    ConfigFile::from_table(table, &path)
+}
+
+/// The root `.cartog.toml` of `root` over the user config, with `CARTOG_*`
+/// overrides, for settings nested files cannot set (`[aliases]`). Cheap: reads
+/// two files, without walking the project.
+pub fn root_config(root: &Path) -> Result<ConfigFile> {
+    let mut table = read_user_table(user_config_path().as_deref())?;
+    let path = root.join(CONFIG_FILE);
+    if path.is_file() {
+        merge(&mut table, read_table(&path)?);
+    }
+    apply_overrides(&mut table, &env_overrides(std::env::vars())?);
+    ConfigFile::from_table(table, &path)
+}
+
+/// The user config's table, or an empty one when there is none.
+pub(crate) fn read_user_table(path: Option<&Path>) -> Result<Table> {
+    match path {
+        Some(path) if path.is_file() => read_table(path),
+        _ => Ok(Table::new()),
+    }
+}
+
+/// Every config file under `root` as `(directory relative to root, path)`.
+pub fn discover(root: &Path) -> Vec<(String, PathBuf)> {
+    let mut found = Vec::new();
+    let walker = WalkDir::new(root).follow_links(true).into_iter();
+    for entry in walker.filter_entry(|e| {
+        e.depth() == 0
+            || !e.file_type().is_dir()
+            || !is_ignored_dirname(&e.file_name().to_string_lossy())
+    }) {
+        let Ok(entry) = entry else { continue };
+        if !entry.file_type().is_file() || entry.file_name() != CONFIG_FILE {
+            continue;
+        }
+        let dir = entry
+            .path()
+            .parent()
+            .and_then(|p| p.strip_prefix(root).ok())
+            .map(|p| p.to_string_lossy().replace('\\', "/"))
+            .unwrap_or_default();
+        found.push((dir, entry.into_path()));
+    }
+    found
+}
+
+pub(crate) fn read_table(path: &Path) -> Result<Table> {
+    let raw = std::fs::read_to_string(path)
+        .with_context(|| format!("failed to read {}", path.display()))?;
+    raw.parse()
+        .with_context(|| format!("invalid config in {}", path.display()))
+}
+
+/// Every config key as `(section, key, default)`.
+///
+/// Taken from the serialized defaults, so a key added to [`ConfigFile`] gets its
+/// environment variable without further wiring.
+pub fn keys() -> Vec<(String, String, Value)> {
+    let defaults = Table::try_from(ConfigFile::default()).expect("default config serializes");
+    defaults
+        .into_iter()
+        .filter_map(|(section, keys)| match keys {
+            Value::Table(keys) => Some((section, keys)),
+            _ => None,
+        })
+        .flat_map(|(section, keys)| {
+            keys.into_iter()
+                .map(move |(key, default)| (section.clone(), key, default))
+        })
+        .collect()
+}
+
+/// Environment variable for a config key: `[index] ignore` is `CARTOG_INDEX_IGNORE`.
+pub fn env_var(section: &str, key: &str) -> String {
+    format!("{ENV_PREFIX}{section}_{key}").to_uppercase()
+}
+
+/// Config overrides from `CARTOG_<SECTION>_<KEY>` variables, as a partial config table.
+///
+/// Values are TOML (`["gen/**", "*.pb.go"]`, `{ "core/**" = 2.0 }`). Lists and
+/// tables also accept the shell-friendly `gen/**,*.pb.go` and `core/**=2.0`.
+/// `CARTOG_*` variables that name no config key are left alone.
+pub fn env_overrides(vars: impl IntoIterator<Item = (String, String)>) -> Result<Table> {
+    let vars: HashMap<String, String> = vars.into_iter().collect();
+    let mut overrides = Table::new();
+    for (section, key, default) in keys() {
+        let name = env_var(&section, &key);
+        let Some(raw) = vars.get(&name) else {
+            continue;
+        };
+        let value = parse_env_value(raw, &default).with_context(|| format!("invalid {name}"))?;
+        if let Value::Table(keys) = overrides
+            .entry(section)
+            .or_insert_with(|| Value::Table(Table::new()))
+        {
+            keys.insert(key, value);
+        }
+    }
+    Ok(overrides)
+}
+
+fn parse_env_value(raw: &str, default: &Value) -> Result<Value> {
+    if let Some(value) = parse_value(raw).filter(|v| v.same_type(default)) {
+        return Ok(value);
+    }
+    let items = raw.split(',').map(str::trim).filter(|s| !s.is_empty());
+    match default {
+        Value::Array(_) => Ok(Value::Array(
+            items.map(|s| Value::String(s.to_string())).collect(),
+        )),
+        Value::Table(_) => items
+            .map(|item| {
+                let (key, value) = item
+                    .split_once('=')
+                    .with_context(|| format!("expected key=value, got '{item}'"))?;
+                let value = parse_value(value.trim())
+                    .with_context(|| format!("invalid value in '{item}'"))?;
+                Ok((key.trim().to_string(), value))
+            })
+            .collect::<Result<Table>>()
+            .map(Value::Table),
+        Value::String(_) => Ok(Value::String(raw.to_string())),
+        _ => anyhow::bail!("expected a {}, got '{raw}'", default.type_str()),
+    }
+}
+
+fn parse_value(raw: &str) -> Option<Value> {
+    format!("v = {raw}")
+        .parse::<Table>()
+        .ok()
+        .and_then(|mut doc| doc.remove("v"))
+}
+
+/// Set each overridden key in `table`, replacing what the file had.
+pub(crate) fn apply_overrides(table: &mut Table, overrides: &Table) {
+    for (section, keys) in overrides {
+        let Value::Table(keys) = keys else { continue };
+        let target = table
+            .entry(section.clone())
+            .or_insert_with(|| Value::Table(Table::new()));
+        if !target.is_table() {
+            *target = Value::Table(Table::new());
+        }
+        if let Value::Table(target) = target {
+            target.extend(keys.clone());
+        }
+    }
+}
+
+/// Lay `top` over `base`: tables merge key by key, anything else is replaced.
+pub(crate) fn merge(base: &mut Table, top: Table) {
+    for (key, value) in top {
+        match (base.get_mut(&key), value) {
+            (Some(Value::Table(base)), Value::Table(top)) => merge(base, top),
+            (_, value) => {
+                base.insert(key, value);
+            }
+        }
+    }
+}
+
+/// One `.cartog.toml` and the subtree it applies to.
+#[derive(Debug, Clone)]
+struct Layer {
+    /// Directory holding the file, relative to the project root (`""` for the root).
+    dir: String,
+    file: ConfigFile,
+    ignore: GlobSet,
+    boosts: Vec<(GlobMatcher, f64)>,
+    /// `[[extract.rules]]` with their `paths`; `None` matches every path.
+    rules: Vec<(Option<GlobSet>, ExtractRule)>,
+    tags: Vec<TagMatcher>,
+    arch: Vec<ArchMatcher>,
+}
+
+/// A compiled `[[arch.rules]]` entry. `None` globs were not given.
+#[derive(Debug, Clone)]
+struct ArchMatcher {
+    /// The rule's `name`, or `<file>#<n>` (1-based) for unnamed rules.
+    label: String,
+    rule: ArchRule,
+    from: Option<GlobSet>,
+    deny: Option<GlobSet>,
+    allow: Option<GlobSet>,
+}
+
+impl ArchMatcher {
+    fn new(dir: &str, index: usize, rule: &ArchRule) -> Result<Self> {
+        let label = match &rule.name {
+            Some(name) => name.clone(),
+            None if dir.is_empty() => format!("{CONFIG_FILE}#{}", index + 1),
+            None => format!("{dir}/{CONFIG_FILE}#{}", index + 1),
+        };
+        Ok(Self {
+            label,
+            from: globs(&rule.from, glob)?,
+            deny: globs(&rule.deny, glob)?,
+            allow: globs(&rule.allow, glob)?,
+            rule: rule.clone(),
+        })
+    }
+
+    /// `target` is relative to the rule's directory, or `None` outside it.
+    fn check(&self, local: &str, target: Option<&str>, kind: EdgeKind) -> Option<Breach> {
+        if !self.rule.kinds.is_empty() && !self.rule.kinds.contains(&kind) {
+            return None;
+        }
+        if self.from.as_ref().is_some_and(|g| !g.is_match(local)) {
+            return None;
+        }
+        let matches = |set: &Option<GlobSet>| {
+            set.as_ref()
+                .zip(target)
+                .is_some_and(|(g, target)| g.is_match(target))
+        };
+        if matches(&self.deny) {
+            Some(Breach::Denied)
+        } else if self.allow.is_some() && !matches(&self.allow) {
+            Some(Breach::NotAllowed)
+        } else {
+            None
+        }
+    }
+}
+
+/// A compiled `[tags.<label>]`. `None` globs match anything.
+#[derive(Debug, Clone)]
+struct TagMatcher {
+    label: String,
+    paths: Option<GlobSet>,
+    names: Option<GlobSet>,
+    rule: TagRule,
+}
+
+impl TagMatcher {
+    fn new(label: &str, rule: &TagRule) -> Result<Self> {
+        let paths = globs(&rule.paths, glob)?;
+        // Names have no separators; a plain glob lets `*` match anything.
+        let names = globs(&rule.names, |p| {
+            Glob::new(p).with_context(|| format!("invalid glob '{p}'"))
+        })?;
+        Ok(Self {
+            label: label.to_string(),
+            paths,
+            names,
+            rule: rule.clone(),
+        })
+    }
+
+    fn matches(&self, local: &str, sym: &Symbol, preamble: &str) -> bool {
+        if self.rule.is_empty() {
+            return false;
+        }
+        let kind = self.rule.kinds.is_empty() || self.rule.kinds.contains(&sym.kind);
+        let path = self.paths.as_ref().map_or(true, |g| g.is_match(local));
+        let name = self.names.as_ref().map_or(true, |g| g.is_match(&sym.name));
+        let annotation = self.rule.annotations.is_empty()
+            || self.rule.annotations.iter().any(|a| {
+                preamble.contains(a.as_str())
+                    || sym
+                        .docstring
+                        .as_deref()
+                        .is_some_and(|d| d.contains(a.as_str()))
+            });
+        kind && path && name && annotation
+    }
+}
+
+/// A set of `patterns`, or `None` when there are none.
+fn globs(patterns: &[String], build: impl Fn(&str) -> Result<Glob>) -> Result<Option<GlobSet>> {
+    if patterns.is_empty() {
+        return Ok(None);
+    }
+    let mut set = GlobSetBuilder::new();
+    for pattern in patterns {
+        set.add(build(pattern)?);
+    }
+    Ok(Some(set.build()?))
+}
+
+impl Layer {
+    fn new(dir: String, file: ConfigFile) -> Result<Self> {
+        let mut ignore = GlobSetBuilder::new();
+        for pattern in &file.index.ignore {
+            ignore.add(glob(pattern)?);
+        }
+        let boosts = file
+            .ranking
+            .boost
+            .iter()
+            .map(|(pattern, weight)| Ok((glob(pattern)?.compile_matcher(), *weight)))
+            .collect::<Result<_>>()?;
+        let rules = file
+            .extract
+            .rules
+            .iter()
+            .map(|rule| Ok((globs(&rule.paths, glob)?, rule.clone())))
+            .collect::<Result<_>>()?;
+        let tags = file
+            .tags
+            .iter()
+            .map(|(label, rule)| TagMatcher::new(label, rule))
+            .collect::<Result<_>>()?;
+        let arch = file
+            .arch
+            .rules
+            .iter()
+            .enumerate()
+            .map(|(i, rule)| ArchMatcher::new(&dir, i, rule))
+            .collect::<Result<_>>()?;
+        Ok(Self {
+            dir,
+            file,
+            ignore: ignore.build()?,
+            boosts,
+            rules,
+            tags,
+            arch,
+        })
+    }
+
+    /// `rel_path` relative to this layer's directory, if the layer applies to it.
+    fn local<'a>(&self, rel_path: &'a str) -> Option<&'a str> {
+        if self.dir.is_empty() {
+            return Some(rel_path);
+        }
+        rel_path
+            .strip_prefix(self.dir.as_str())
+            .and_then(|rest| rest.strip_prefix('/'))
+    }
+}
+
+/// `*` stays within one path component; `**` crosses directories.
+fn glob(pattern: &str) -> Result<Glob> {
+    GlobBuilder::new(pattern)
+        .literal_separator(true)
+        .build()
+        .with_context(|| format!("invalid glob '{pattern}'"))
+}
+
+/// Every `.cartog.toml` under a project root, resolved per path.
+#[derive(Debug, Clone, Default)]
+pub struct ProjectConfig {
+    /// Ordered root first, so iteration applies ancestors before descendants.
+    layers: Vec<Layer>,
+}
+
+impl ProjectConfig {
+    /// Discover and parse all config files under `root`, then apply `CARTOG_*`
+    /// environment overrides.
+    ///
+    /// Directories the indexer never enters (`.git`, `node_modules`, ...) are not
+    /// searched. A config file that fails to parse is an error: silently dropping
+    /// it would index files the user asked to leave out.
+    pub fn load(root: &Path) -> Result<Self> {
+        Self::load_with(
+            root,
+            user_config_path().as_deref(),
+            &env_overrides(std::env::vars())?,
+        )
+    }
+
+    /// [`ProjectConfig::load`] with an explicit user config and overrides.
+    ///
+    /// The user config sits beneath the root config: keys the root file sets
+    /// replace the user's, and tables (`ranking.boost`, `macros`) merge by key.
+    pub fn load_with(root: &Path, user: Option<&Path>, overrides: &Table) -> Result<Self> {
+        let user = read_user_table(user)?;
+        let mut found = discover(root);
+        if (!overrides.is_empty() || !user.is_empty())
+            && !found.iter().any(|(dir, _)| dir.is_empty())
+        {
+            found.push((String::new(), root.join(CONFIG_FILE)));
+        }
+
+        let mut layers = Vec::new();
+        for (dir, path) in found {
+            let file = if dir.is_empty() {
+                let mut table = user.clone();
+                if path.is_file() {
+                    merge(&mut table, read_table(&path)?);
+                }
+                apply_overrides(&mut table, overrides);
+                ConfigFile::from_table(table, &path)?
+            } else {
+                ConfigFile::load(&path)?
+            };
+            layers.push(Layer::new(dir, file)?);
+        }
+        layers.sort_by_key(|l| (depth(&l.dir), l.dir.clone()));
+        Ok(Self { layers })
+    }
+
+    /// Build from already-parsed files, keyed by directory relative to the root.
+    pub fn from_files(files: Vec<(String, ConfigFile)>) -> Result<Self> {
+        let mut layers = files
+            .into_iter()
+            .map(|(dir, file)| Layer::new(dir, file))
+            .collect::<Result<Vec<_>>>()?;
+        layers.sort_by_key(|l| (depth(&l.dir), l.dir.clone()));
+        Ok(Self { layers })
+    }
+
+    /// Settings of the root config, or the defaults when there is none.
+    pub fn root(&self) -> Option<&ConfigFile> {
+        self.layers
+            .first()
+            .filter(|l| l.dir.is_empty())
+            .map(|l| &l.file)
+    }
+
+    /// Whether `vendor/` is indexed (see [`crate::vendor`]).
+    pub fn vendor(&self) -> bool {
+        self.root().is_some_and(|file| file.index.vendor)
+    }
+
+    /// Plugin directory relative to the project root.
+    pub fn plugin_dir(&self) -> &str {
+        self.root()
+            .map_or(DEFAULT_PLUGIN_DIR, |file| file.plugins.dir.as_str())
+    }
+
+    /// Query macros declared in the root config.
+    pub fn macros(&self) -> &BTreeMap<String, MacroDef> {
+        static NONE: BTreeMap<String, MacroDef> = BTreeMap::new();
+        self.root().map_or(&NONE, |file| &file.macros)
+    }
+
+    /// Lifecycle hooks declared in the root config.
+    pub fn hooks(&self) -> &HooksSection {
+        static NONE: HooksSection = HooksSection {
+            on_index_complete: Vec::new(),
+            on_symbol_changed: Vec::new(),
+            on_watched_impact: Vec::new(),
+        };
+        self.root().map_or(&NONE, |file| &file.hooks)
+    }
+
+    /// Watched symbols declared in the root config.
+    pub fn alerts(&self) -> &AlertsSection {
+        static NONE: AlertsSection = AlertsSection {
+            symbols: Vec::new(),
+            packages: Vec::new(),
+            depth: DEFAULT_DEPTH,
+            notify: false,
+        };
+        self.root().map_or(&NONE, |file| &file.alerts)
+    }
+
+    /// Directories (relative to the root) that carry a config file, root first.
+    pub fn dirs(&self) -> impl Iterator<Item = &str> {
+        self.layers.iter().map(|l| l.dir.as_str())
+    }
+
+    /// Each config file with its directory, root first. The root one includes
+    /// environment overrides.
+    pub fn files(&self) -> impl Iterator<Item = (&str, &ConfigFile)> {
+        self.layers.iter().map(|l| (l.dir.as_str(), &l.file))
+    }
+
+    fn applicable<'a>(&'a self, rel_path: &'a str) -> impl Iterator<Item = (&'a Layer, &'a str)> {
+        self.layers
+            .iter()
+            .filter_map(move |l| l.local(rel_path).map(|local| (l, local)))
+    }
+
+    /// Whether any applicable `ignore` glob matches `rel_path`.
+    pub fn is_ignored(&self, rel_path: &str) -> bool {
+        self.applicable(rel_path)
+            .any(|(layer, local)| layer.ignore.is_match(local))
+    }
+
+    /// The first `ignore` glob matching `rel_path`, with the file declaring it
+    /// (`src/.cartog.toml: gen/**`).
+    pub fn ignore_rule(&self, rel_path: &str) -> Option<String> {
+        self.applicable(rel_path).find_map(|(layer, local)| {
+            let i = *layer.ignore.matches(local).first()?;
+            let file = if layer.dir.is_empty() {
+                CONFIG_FILE.to_string()
+            } else {
+                format!("{}/{CONFIG_FILE}", layer.dir)
+            };
+            Some(format!("{file}: {}", layer.file.index.ignore[i]))
+        })
+    }
+
+    /// Whether `language` should be indexed at `rel_path`.
+    pub fn language_enabled(&self, rel_path: &str, language: &str) -> bool {
+        let mut enabled = true;
+        for (layer, _) in self.applicable(rel_path) {
+            let langs = &layer.file.languages;
+            if langs.disable.iter().any(|l| l == language) {
+                enabled = false;
+            }
+            if langs.enable.iter().any(|l| l == language) {
+                enabled = true;
+            }
+        }
+        enabled
+    }
+
+    /// Extraction passes for a `language` file at `rel_path`. Rules apply root
+    /// first and in file order, so a later `keep` undoes an earlier `skip`.
+    pub fn passes(&self, rel_path: &str, language: &str) -> Passes {
+        let mut skipped = 0;
+        for (layer, local) in self.applicable(rel_path) {
+            for (paths, rule) in &layer.rules {
+                let path_matches = paths.as_ref().map_or(true, |p| p.is_match(local));
+                let lang_matches =
+                    rule.languages.is_empty() || rule.languages.iter().any(|l| l == language);
+                if !path_matches || !lang_matches {
+                    continue;
+                }
+                for pass in &rule.skip {
+                    skipped |= pass.bits();
+                }
+                for pass in &rule.keep {
+                    skipped &= !pass.bits();
+                }
+            }
+        }
+        Passes { skipped }
+    }
+
+    /// Labels of every `[tags.<label>]` rule that matches `sym`. `preamble` is the
+    /// comment and attribute block directly above the symbol.
+    pub fn tags(&self, sym: &Symbol, preamble: &str) -> Vec<String> {
+        let mut labels: Vec<String> = self
+            .applicable(&sym.file_path)
+            .flat_map(|(layer, local)| {
+                layer
+                    .tags
+                    .iter()
+                    .filter(move |t| t.matches(local, sym, preamble))
+                    .map(|t| t.label.clone())
+            })
+            .collect();
+        labels.sort();
+        labels.dedup();
+        labels
+    }
+
+    /// Labels of the `[[arch.rules]]` broken by an edge from `source_file` to
+    /// `target_file`, with how each is broken.
+    ///
+    /// `target_file` is `None` for an import the index could not resolve, such as a
+    /// third-party package; then `module` (its path, `.` and `::` read as `/`) is
+    /// checked against `deny` only, so external code never breaks an `allow` list.
+    pub fn arch_breaches<'a>(
+        &'a self,
+        source_file: &'a str,
+        target_file: Option<&str>,
+        module: Option<&str>,
+        kind: EdgeKind,
+    ) -> Vec<(&'a str, Breach)> {
+        let module = module.map(|m| m.replace("::", "/").replace('.', "/"));
+        let mut breaches = Vec::new();
+        for (layer, local) in self.applicable(source_file) {
+            for m in &layer.arch {
+                let breach = match (target_file, &module) {
+                    (Some(file), _) => m.check(local, layer.local(file), kind),
+                    // Unresolved: only `deny` applies, matched on the module path.
+                    (None, Some(module)) => m
+                        .check(local, Some(module), kind)
+                        .filter(|b| *b == Breach::Denied),
+                    (None, None) => None,
+                };
+                if let Some(breach) = breach {
+                    breaches.push((m.label.as_str(), breach));
+                }
+            }
+        }
+        breaches
+    }
+
+    /// Whether any config file declares `[[arch.rules]]`.
+    pub fn has_arch_rules(&self) -> bool {
+        self.layers.iter().any(|l| !l.arch.is_empty())
+    }
+
+    /// Whether any config file defines tags.
+    pub fn has_tags(&self) -> bool {
+        self.layers.iter().any(|l| !l.tags.is_empty())
+    }
+
+    /// Search score multiplier for `rel_path`; `1.0` when no boost applies.
+    pub fn boost(&self, rel_path: &str) -> f64 {
+        self.applicable(rel_path)
+            .flat_map(|(layer, local)| {
+                layer
+                    .boosts
+                    .iter()
+                    .filter(move |(glob, _)| glob.is_match(local))
+                    .map(|(_, weight)| *weight)
+            })
+            .product()
+    }
+}
+
+fn depth(dir: &str) -> usize {
+    if dir.is_empty() {
+        0
+    } else {
+        dir.matches('/').count() + 1
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn project(files: &[(&str, &str)]) -> ProjectConfig {
+        ProjectConfig::from_files(
+            files
+                .iter()
+                .map(|(dir, raw)| (dir.to_string(), ConfigFile::parse(raw).unwrap()))
+                .collect(),
+        )
+        .unwrap()
+    }
+
+    #[test]
+    fn test_nested_ignores_are_relative_to_their_directory() {
+        let config = project(&[
+            ("", "[index]\nignore = [\"**/*_pb2.py\"]"),
+            ("services/api", "[index]\nignore = [\"generated/**\"]"),
+        ]);
+        assert!(config.is_ignored("lib/user_pb2.py"));
+        assert!(config.is_ignored("services/api/generated/client.py"));
+        assert!(config.is_ignored("services/api/models/user_pb2.py"));
+        assert!(!config.is_ignored("generated/client.py"));
+        assert!(!config.is_ignored("services/apiv2/generated/client.py"));
+    }
+
+    #[test]
+    fn test_deepest_language_toggle_wins() {
+        let config = project(&[
+            ("", "[languages]\ndisable = [\"javascript\"]"),
+            ("web", "[languages]\nenable = [\"javascript\"]"),
+            ("web/legacy", "[languages]\ndisable = [\"javascript\"]"),
+        ]);
+        assert!(!config.language_enabled("tools/build.js", "javascript"));
+        assert!(config.language_enabled("web/app.js", "javascript"));
+        assert!(!config.language_enabled("web/legacy/old.js", "javascript"));
+        assert!(config.language_enabled("tools/build.py", "python"));
+    }
+
+    #[test]
+    fn test_boosts_compound_across_layers() {
+        let config = project(&[
+            ("", "[ranking.boost]\n\"services/**\" = 2.0"),
+            ("services/api", "[ranking.boost]\n\"tests/**\" = 0.25"),
+        ]);
+        assert_eq!(config.boost("README.py"), 1.0);
+        assert_eq!(config.boost("services/api/handlers.py"), 2.0);
+        assert_eq!(config.boost("services/api/tests/test_handlers.py"), 0.5);
+    }
+
+    fn vars(pairs: &[(&str, &str)]) -> Vec<(String, String)> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn test_extract_rules_by_path_and_language() {
+        let config = project(&[
+            (
+                "",
+                r#"
+                [[extract.rules]]
+                paths = ["benchmarks/fixtures/**"]
+                skip = ["calls", "content"]
+
+                [[extract.rules]]
+                languages = ["javascript"]
+                skip = ["edges"]
+                "#,
+            ),
+            (
+                "benchmarks",
+                "[[extract.rules]]\npaths = [\"fixtures/keep/**\"]\nkeep = [\"calls\"]",
+            ),
+        ]);
+        let fixture = config.passes("benchmarks/fixtures/big.py", "python");
+        assert!(!fixture.edge(EdgeKind::Calls));
+        assert!(fixture.edge(EdgeKind::Imports));
+        assert!(!fixture.content());
+
+        let kept = config.passes("benchmarks/fixtures/keep/small.py", "python");
+        assert!(kept.edge(EdgeKind::Calls));
+        assert!(!kept.content());
+
+        let js = config.passes("web/app.js", "javascript");
+        assert!(!js.edge(EdgeKind::Inherits));
+        assert!(js.content());
+        assert_eq!(config.passes("src/app.py", "python"), Passes::ALL);
+    }
+
+    #[test]
+    fn test_tags_need_every_criterion() {
+        let config = project(&[
+            (
+                "",
+                r##"
+                [tags.api-surface]
+                paths = ["api/**"]
+                kinds = ["function"]
+
+                [tags.deprecated]
+                annotations = ["@deprecated", "#[deprecated"]
+
+                [tags.hot-path]
+                names = ["*_hot"]
+                "##,
+            ),
+            ("api/v1", "[tags.v1]\npaths = [\"*.py\"]"),
+        ]);
+        let sym = |name: &str, kind, file: &str| Symbol::new(name, kind, file, 1, 2, 0, 10);
+
+        let handler = sym("get_user", SymbolKind::Function, "api/v1/users.py");
+        assert_eq!(config.tags(&handler, ""), ["api-surface", "v1"]);
+        assert_eq!(
+            config.tags(&handler, "@app.get(\"/users\")\n@deprecated"),
+            ["api-surface", "deprecated", "v1"]
+        );
+        let model = sym("User", SymbolKind::Class, "api/models.py");
+        assert!(config.tags(&model, "").is_empty());
+        let mut loop_hot = sym("render_hot", SymbolKind::Method, "ui/view.rs");
+        loop_hot.docstring = Some("Draws a frame. #[deprecated] soon".into());
+        assert_eq!(config.tags(&loop_hot, ""), ["deprecated", "hot-path"]);
+    }
+
+    #[test]
+    fn test_arch_rules_deny_and_allow() {
+        let config = project(&[
+            (
+                "",
+                r#"
+                [[arch.rules]]
+                name = "models stay pure"
+                from = ["internal/models/**"]
+                deny = ["internal/services/**"]
+
+                [[arch.rules]]
+                from = ["routes/**"]
+                allow = ["services/**"]
+                kinds = ["calls"]
+                "#,
+            ),
+            ("web", "[[arch.rules]]\ndeny = [\"legacy/**\"]"),
+        ]);
+        let breaches =
+            |source, target, module, kind| config.arch_breaches(source, target, module, kind);
+        let models = "internal/models/user.py";
+
+        assert_eq!(
+            breaches(
+                models,
+                Some("internal/services/auth.py"),
+                None,
+                EdgeKind::Imports
+            ),
+            [("models stay pure", Breach::Denied)]
+        );
+        assert_eq!(
+            breaches(
+                models,
+                None,
+                Some("internal.services.auth"),
+                EdgeKind::Imports
+            ),
+            [("models stay pure", Breach::Denied)]
+        );
+        assert!(breaches(
+            models,
+            Some("internal/models/base.py"),
+            None,
+            EdgeKind::Calls
+        )
+        .is_empty());
+
+        let route = "routes/users.ts";
+        assert_eq!(
+            breaches(route, Some("db/query.ts"), None, EdgeKind::Calls),
+            [(".cartog.toml#2", Breach::NotAllowed)]
+        );
+        assert!(breaches(route, Some("services/users.ts"), None, EdgeKind::Calls).is_empty());
+        // Only `calls` is checked, and unresolved code never breaks `allow`.
+        assert!(breaches(route, Some("db/query.ts"), None, EdgeKind::Imports).is_empty());
+        assert!(breaches(route, None, Some("express"), EdgeKind::Calls).is_empty());
+
+        // Nested rules see paths relative to their directory.
+        assert_eq!(
+            breaches(
+                "web/app.js",
+                Some("web/legacy/old.js"),
+                None,
+                EdgeKind::Calls
+            ),
+            [("web/.cartog.toml#1", Breach::Denied)]
+        );
+        assert!(breaches("web/app.js", Some("legacy/old.js"), None, EdgeKind::Calls).is_empty());
+    }
+
+    #[test]
+    fn test_every_key_has_an_env_var() {
+        let names: Vec<String> = keys().iter().map(|(s, k, _)| env_var(s, k)).collect();
+        assert!(names.contains(&"CARTOG_INDEX_IGNORE".to_string()));
+        assert!(names.contains(&"CARTOG_LANGUAGES_DISABLE".to_string()));
+        assert!(names.contains(&"CARTOG_RANKING_BOOST".to_string()));
+        assert!(names.contains(&"CARTOG_PLUGINS_DIR".to_string()));
+        assert!(names.contains(&"CARTOG_HOOKS_ON_INDEX_COMPLETE".to_string()));
+    }
+
+    #[test]
+    fn test_env_overrides_accept_toml_and_shell_forms() {
+        let overrides = env_overrides(vars(&[
+            ("CARTOG_INDEX_IGNORE", "gen/**, *.pb.go"),
+            ("CARTOG_LANGUAGES_DISABLE", r#"["ruby"]"#),
+            ("CARTOG_RANKING_BOOST", "core/**=2.5"),
+            ("CARTOG_SNAPSHOT_CACHE", "/mnt/cache"),
+        ]))
+        .unwrap();
+        let file: ConfigFile = overrides.try_into().unwrap();
+        assert_eq!(file.index.ignore, ["gen/**", "*.pb.go"]);
+        assert_eq!(file.languages.disable, ["ruby"]);
+        assert_eq!(file.ranking.boost.get("core/**"), Some(&2.5));
+
+        let err = env_overrides(vars(&[("CARTOG_RANKING_BOOST", "core/**")])).unwrap_err();
+        assert!(format!("{err:#}").contains("CARTOG_RANKING_BOOST"));
+    }
+
+    #[test]
+    fn test_env_replaces_root_keys() {
+        let root = std::env::temp_dir().join(format!("cartog-config-env-{}", std::process::id()));
+        std::fs::create_dir_all(&root).unwrap();
+        std::fs::write(
+            root.join(CONFIG_FILE),
+            "[index]\nignore = [\"a/**\"]\n[languages]\ndisable = [\"go\"]",
+        )
+        .unwrap();
+
+        let overrides = env_overrides(vars(&[("CARTOG_INDEX_IGNORE", "b/**")])).unwrap();
+        let config = ProjectConfig::load_with(&root, None, &overrides).unwrap();
+        assert!(!config.is_ignored("a/x.py"));
+        assert!(config.is_ignored("b/x.py"));
+        assert!(!config.language_enabled("main.go", "go"));
+
+        std::fs::remove_file(root.join(CONFIG_FILE)).unwrap();
+        let config = ProjectConfig::load_with(&root, None, &overrides).unwrap();
+        assert!(config.is_ignored("b/x.py"));
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+
+    #[test]
+    fn test_user_config_sits_beneath_the_root_config() {
+        let root = std::env::temp_dir().join(format!("cartog-config-user-{}", std::process::id()));
+        std::fs::create_dir_all(&root).unwrap();
+        let user = root.join("user.toml");
+        std::fs::write(
+            &user,
+            "[index]\nignore = [\"scratch/**\"]\n[ranking.boost]\n\"a/**\" = 2.0\n\"b/**\" = 3.0",
+        )
+        .unwrap();
+
+        let config = ProjectConfig::load_with(&root, Some(&user), &Table::new()).unwrap();
+        assert!(
+            config.is_ignored("scratch/x.py"),
+            "applies without a project file"
+        );
+
+        std::fs::write(
+            root.join(CONFIG_FILE),
+            "[index]\nignore = [\"gen/**\"]\n[ranking.boost]\n\"b/**\" = 0.5",
+        )
+        .unwrap();
+        let config = ProjectConfig::load_with(&root, Some(&user), &Table::new()).unwrap();
+        assert!(
+            !config.is_ignored("scratch/x.py"),
+            "project key replaces the user's"
+        );
+        assert!(config.is_ignored("gen/x.py"));
+        assert_eq!(config.boost("a/x.py"), 2.0, "tables merge by key");
+        assert_eq!(config.boost("b/x.py"), 0.5);
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+
+    #[test]
+    fn test_load_discovers_nested_files() {
+        let root = std::env::temp_dir().join(format!("cartog-config-{}", std::process::id()));
+        let nested = root.join("services").join("api");
+        let hidden = root.join("node_modules").join("pkg");
+        std::fs::create_dir_all(&nested).unwrap();
+        std::fs::create_dir_all(&hidden).unwrap();
+        std::fs::write(root.join(CONFIG_FILE), "[index]\nignore = [\"a/**\"]").unwrap();
+        std::fs::write(nested.join(CONFIG_FILE), "[index]\nignore = [\"b/**\"]").unwrap();
+        std::fs::write(hidden.join(CONFIG_FILE), "[index]\nignore = [\"c/**\"]").unwrap();
+
+        let config = ProjectConfig::load_with(&root, None, &Table::new()).unwrap();
+        assert_eq!(config.dirs().collect::<Vec<_>>(), ["", "services/api"]);
+
+        std::fs::write(nested.join(CONFIG_FILE), "[index]\nignore = 3").unwrap();
+        let err = ProjectConfig::load_with(&root, None, &Table::new()).unwrap_err();
+        assert!(format!("{err:#}").contains("services/api"));
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+}
@@ -0,0 +1,355 @@
+//! Config key usage: link each configuration struct field to the code that reads
+//! or sets it and to the keys in the project's YAML, TOML and JSON files it
+//! deserializes from.
+//!
+//! Fields and field accesses are recorded at index time (see
+//! `languages::config_fields`). Accesses carry no type, so they are matched to a
+//! field by name, dropping struct literals of another type. Config files are not
+//! indexed: they are scanned on each query, and their keys matched to a field by
+//! its tag key, or by its name ignoring case when it has no tag (the default of
+//! `yaml.v3`, `encoding/json` and viper).
+
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+use walkdir::WalkDir;
+
+use crate::db::Database;
+use crate::indexer::is_ignored_dirname;
+use crate::types::{ConfigField, FieldUse, Symbol};
+
+const CONFIG_EXTENSIONS: &[&str] = &["yaml", "yml", "toml", "json"];
+
+/// A key in a config file.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct FileKey {
+    pub file: String,
+    pub line: u32,
+    /// Dotted path from the document root (`cache.redis_host`).
+    pub path: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct FieldAccess {
+    pub function: Symbol,
+    #[serde(flatten)]
+    pub access: FieldUse,
+}
+
+/// A config field with the code and file keys that use it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ConfigKeyUsage {
+    pub owner: Symbol,
+    #[serde(flatten)]
+    pub field: ConfigField,
+    pub keys: Vec<FileKey>,
+    pub uses: Vec<FieldAccess>,
+}
+
+/// Usage of every config field, or of those `name` matches (`RedisHost`,
+/// `Config.RedisHost`), with config files found under `root`.
+pub fn usage(db: &Database, root: &Path, name: Option<&str>) -> Result<Vec<ConfigKeyUsage>> {
+    let fields = db.config_fields(name)?;
+    if fields.is_empty() {
+        return Ok(Vec::new());
+    }
+    let file_keys = scan(root);
+    let mut usages = Vec::new();
+    for (owner, field) in fields {
+        let keys = file_keys
+            .iter()
+            .filter(|k| matches_key(&field, k))
+            .cloned()
+            .collect();
+        let uses = db
+            .field_uses(&field.name)?
+            .into_iter()
+            .filter(|(_, access)| is_use_of(&owner, access))
+            .map(|(function, access)| FieldAccess { function, access })
+            .collect();
+        usages.push(ConfigKeyUsage {
+            owner,
+            field,
+            keys,
+            uses,
+        });
+    }
+    Ok(usages)
+}
+
+fn matches_key(field: &ConfigField, key: &FileKey) -> bool {
+    let leaf = key.path.rsplit('.').next().unwrap_or(&key.path);
+    match &field.key {
+        Some(tagged) => leaf == tagged || key.path == *tagged,
+        None => leaf.eq_ignore_ascii_case(&field.name),
+    }
+}
+
+/// Whether `access` can be of a field of `owner`: any operand, but a struct literal
+/// only of `owner`'s own type.
+fn is_use_of(owner: &Symbol, access: &FieldUse) -> bool {
+    let last = access
+        .receiver
+        .rsplit('.')
+        .next()
+        .unwrap_or(&access.receiver);
+    !last.starts_with(|c: char| c.is_ascii_uppercase()) || last == owner.name
+}
+
+/// Keys of every YAML, TOML and JSON file under `root`, except `.cartog.toml`.
+fn scan(root: &Path) -> Vec<FileKey> {
+    let mut keys = Vec::new();
+    let walker = WalkDir::new(root).follow_links(true).into_iter();
+    for entry in walker.filter_entry(|e| {
+        e.depth() == 0
+            || !e.file_type().is_dir()
+            || !is_ignored_dirname(&e.file_name().to_string_lossy())
+    }) {
+        let Ok(entry) = entry else { continue };
+        let path = entry.path();
+        let Some(ext) = path.extension().and_then(|e| e.to_str()) else {
+            continue;
+        };
+        if !CONFIG_EXTENSIONS.contains(&ext) || entry.file_name() == ".cartog.toml" {
+            continue;
+        }
+        let Ok(text) = std::fs::read_to_string(path) else {
+            continue;
+        };
+        let rel = path.strip_prefix(root).unwrap_or(path);
+        let file = rel.to_string_lossy().replace('\\', "/");
+        let found = match ext {
+            "toml" => toml_keys(&text),
+            "json" => json_keys(&text),
+            _ => yaml_keys(&text),
+        };
+        keys.extend(found.into_iter().map(|(line, path)| FileKey {
+            file: file.clone(),
+            line,
+            path,
+        }));
+    }
+    keys
+}
+
+/// `(line, dotted path)` of each mapping key, nesting by indentation.
+fn yaml_keys(text: &str) -> Vec<(u32, String)> {
+    let mut keys = Vec::new();
+    let mut stack: Vec<(usize, String)> = Vec::new();
+    for (i, line) in text.lines().enumerate() {
+        let trimmed = line.trim_start();
+        if trimmed.is_empty() || trimmed.starts_with('#') || trimmed.starts_with("---") {
+            continue;
+        }
+        let mut indent = line.len() - trimmed.len();
+        let mut entry = trimmed;
+        while let Some(rest) = entry.strip_prefix("- ") {
+            indent += 2;
+            entry = rest.trim_start();
+        }
+        let Some((key, _)) = entry
+            .split_once(": ")
+            .or_else(|| entry.strip_suffix(':').map(|k| (k, "")))
+        else {
+            continue;
+        };
+        let key = key.trim().trim_matches(|c| c == '"' || c == '\'');
+        if key.is_empty() || key.contains(' ') {
+            continue;
+        }
+        while stack.last().is_some_and(|(level, _)| *level >= indent) {
+            stack.pop();
+        }
+        stack.push((indent, key.to_string()));
+        let path: Vec<_> = stack.iter().map(|(_, k)| k.as_str()).collect();
+        keys.push((i as u32 + 1, path.join(".")));
+    }
+    keys
+}
+
+/// `(line, dotted path)` of each key, under its `[table]` header.
+fn toml_keys(text: &str) -> Vec<(u32, String)> {
+    let mut keys = Vec::new();
+    let mut table = String::new();
+    for (i, line) in text.lines().enumerate() {
+        let line = line.trim();
+        if let Some(header) = line.strip_prefix('[') {
+            table = header
+                .trim_matches(|c| c == '[' || c == ']')
+                .trim()
+                .to_string();
+            continue;
+        }
+        let Some((key, _)) = line.split_once('=') else {
+            continue;
+        };
+        let key = key.trim().trim_matches('"');
+        if key.is_empty() || key.starts_with('#') {
+            continue;
+        }
+        let path = if table.is_empty() {
+            key.to_string()
+        } else {
+            format!("{table}.{key}")
+        };
+        keys.push((i as u32 + 1, path));
+    }
+    keys
+}
+
+/// `(line, dotted path)` of each object key. Array elements add no segment.
+fn json_keys(text: &str) -> Vec<(u32, String)> {
+    let mut keys = Vec::new();
+    // One entry per open object or array: the key it was opened under.
+    let mut stack: Vec<Option<String>> = Vec::new();
+    let mut last_key: Option<String> = None;
+    let mut line = 1;
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '\n' => line += 1,
+            '"' => {
+                let mut s = String::new();
+                while let Some(c) = chars.next() {
+                    match c {
+                        '\\' => {
+                            chars.next();
+                        }
+                        '"' => break,
+                        '\n' => line += 1,
+                        c => s.push(c),
+                    }
+                }
+                while chars
+                    .peek()
+                    .is_some_and(|c| c.is_whitespace() && *c != '\n')
+                {
+                    chars.next();
+                }
+                if chars.peek() == Some(&':') {
+                    let mut path: Vec<&str> = stack.iter().flatten().map(String::as_str).collect();
+                    path.push(&s);
+                    keys.push((line, path.join(".")));
+                    last_key = Some(s);
+                }
+            }
+            '{' | '[' => stack.push(last_key.take()),
+            '}' | ']' => {
+                stack.pop();
+            }
+            ',' => last_key = None,
+            _ => {}
+        }
+    }
+    keys
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::SymbolKind;
+
+    #[test]
+    fn test_config_file_keys_are_dotted_paths() {
+        let yaml =
+            "app:\n  name: web\n  # port: 1\ncache:\n  redis_host: localhost\nitems:\n  - id: 1\n";
+        assert_eq!(
+            yaml_keys(yaml),
+            [
+                (1, "app".to_string()),
+                (2, "app.name".to_string()),
+                (4, "cache".to_string()),
+                (5, "cache.redis_host".to_string()),
+                (6, "items".to_string()),
+                (7, "items.id".to_string()),
+            ]
+        );
+
+        let toml = "debug = true\n\n[cache]\nredis_host = \"localhost\"\n";
+        assert_eq!(
+            toml_keys(toml),
+            [
+                (1, "debug".to_string()),
+                (4, "cache.redis_host".to_string())
+            ]
+        );
+
+        let json = "{\n  \"cache\": {\"redisHost\": \"a:b\"},\n  \"tags\": [{\"name\": \"x\"}]\n}";
+        assert_eq!(
+            json_keys(json),
+            [
+                (2, "cache".to_string()),
+                (2, "cache.redisHost".to_string()),
+                (3, "tags".to_string()),
+                (3, "tags.name".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_usage_links_fields_to_uses_and_keys() {
+        let db = Database::open_memory().unwrap();
+        let config = Symbol::new("Config", SymbolKind::Class, "config.go", 1, 5, 0, 90);
+        let main = Symbol::new("main", SymbolKind::Function, "main.go", 1, 9, 0, 90);
+        db.insert_symbols(&[config.clone(), main.clone()]).unwrap();
+        let field = |name: &str, line, key: Option<&str>| ConfigField {
+            symbol_id: config.id.clone(),
+            name: name.to_string(),
+            line,
+            key: key.map(str::to_string),
+        };
+        let access = |line, field: &str, receiver: &str, write| FieldUse {
+            symbol_id: main.id.clone(),
+            line,
+            field: field.to_string(),
+            receiver: receiver.to_string(),
+            write,
+        };
+        db.insert_config_fields(
+            "config.go",
+            &[
+                field("RedisHost", 2, Some("redis_host")),
+                field("AppName", 3, None),
+            ],
+            &[],
+        )
+        .unwrap();
+        db.insert_config_fields(
+            "main.go",
+            &[],
+            &[
+                access(3, "RedisHost", "cfg", false),
+                access(4, "AppName", "Options", true),
+            ],
+        )
+        .unwrap();
+
+        let dir = std::env::temp_dir().join(format!("cartog-config-keys-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(
+            dir.join("config.yaml"),
+            "cache:\n  redis_host: x\nappname: web\n",
+        )
+        .unwrap();
+
+        let usages = usage(&db, &dir, None).unwrap();
+        let found: Vec<_> = usages
+            .iter()
+            .map(|u| {
+                let keys: Vec<_> = u.keys.iter().map(|k| (k.line, k.path.as_str())).collect();
+                (u.field.name.as_str(), keys, u.uses.len())
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("RedisHost", vec![(2, "cache.redis_host")], 1),
+                ("AppName", vec![(3, "appname")], 0),
+            ]
+        );
+        assert_eq!(usage(&db, &dir, Some("Config.AppName")).unwrap().len(), 1);
+
+        std::fs::remove_dir_all(&dir).ok();
+    }
+}
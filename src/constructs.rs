@@ -0,0 +1,204 @@
+//! `cartog constructs`: every place a value of a Go type comes from.
+//!
+//! Two sources, one answer:
+//!
+//! - sites: composite literals, `&T{...}` and `new(T)`, recorded per function at
+//!   index time.
+//! - factories: functions and methods whose first result is the type, `T` or
+//!   `*T`, read off their signatures. `New*` functions are reported as
+//!   constructors, the rest as factories; each comes with its resolved call
+//!   sites, since calling one is creating a value too.
+//!
+//! Types are matched by name without package qualifier, so two packages' `Config`
+//! types share their answers.
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{Construction, Symbol, SymbolKind};
+
+/// A composite literal or `new` call creating the type.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Site {
+    /// The function, method or package-level variable creating it.
+    pub symbol: Symbol,
+    #[serde(flatten)]
+    pub construction: Construction,
+}
+
+/// A function returning the type, with where it is called.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Factory {
+    pub symbol: Symbol,
+    /// Named `New*`.
+    pub constructor: bool,
+    /// `(caller, line)` for each resolved call.
+    pub calls: Vec<(Symbol, u32)>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Constructs {
+    pub type_name: String,
+    pub sites: Vec<Site>,
+    /// Constructors first, then other factories, by file and line.
+    pub factories: Vec<Factory>,
+}
+
+/// Where values of the type `name` are created.
+pub fn constructs(db: &Database, name: &str) -> Result<Constructs> {
+    let sites = db
+        .constructions(name)?
+        .into_iter()
+        .map(|(symbol, construction)| Site {
+            symbol,
+            construction,
+        })
+        .collect();
+
+    let mut factories = Vec::new();
+    for symbol in db.all_symbols()? {
+        if !matches!(symbol.kind, SymbolKind::Function | SymbolKind::Method)
+            || !symbol.file_path.ends_with(".go")
+        {
+            continue;
+        }
+        let Some(signature) = symbol.signature.as_deref() else {
+            continue;
+        };
+        if first_result(signature, symbol.kind == SymbolKind::Method) != Some(name) {
+            continue;
+        }
+        let calls = db.callers(&symbol.id)?;
+        factories.push(Factory {
+            constructor: symbol.name.starts_with("New"),
+            symbol,
+            calls,
+        });
+    }
+    factories.sort_by(|a, b| {
+        (!a.constructor, &a.symbol.file_path, a.symbol.start_line).cmp(&(
+            !b.constructor,
+            &b.symbol.file_path,
+            b.symbol.start_line,
+        ))
+    });
+    Ok(Constructs {
+        type_name: name.to_string(),
+        sites,
+        factories,
+    })
+}
+
+/// The named type of the first result in a Go signature, without pointer or
+/// package qualifier: `Store` for `(db *sql.DB) (*store.Store, error)`. Methods
+/// have their receiver in front of the parameters.
+fn first_result(signature: &str, method: bool) -> Option<&str> {
+    let mut rest = signature.trim();
+    for _ in 0..if method { 2 } else { 1 } {
+        rest = skip_group(rest)?.trim_start();
+    }
+    let first = match rest.strip_prefix('(') {
+        // `(s *Store, err error)` or `(*Store, error)`: the type ends the first item.
+        Some(results) => results.split(',').next()?.trim_end_matches(')').trim(),
+        None => rest,
+    };
+    let ty = first.rsplit(' ').next()?.trim_start_matches('*');
+    if ty.starts_with(['[', '(']) || ty.starts_with("map[") || ty.starts_with("func") {
+        return None;
+    }
+    let ty = ty.split('[').next().unwrap_or(ty);
+    let name = ty.rsplit('.').next().unwrap_or(ty);
+    (!name.is_empty() && name.chars().all(|c| c.is_alphanumeric() || c == '_')).then_some(name)
+}
+
+/// `text` after its leading parenthesized group.
+fn skip_group(text: &str) -> Option<&str> {
+    if !text.starts_with('(') {
+        return None;
+    }
+    let mut depth = 0;
+    for (i, c) in text.char_indices() {
+        match c {
+            '(' => depth += 1,
+            ')' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(&text[i + 1..]);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{ConstructionKind, Edge, EdgeKind};
+
+    #[test]
+    fn test_first_result() {
+        assert_eq!(first_result("(db *sql.DB) *Store", false), Some("Store"));
+        assert_eq!(
+            first_result("(db *sql.DB) (*store.Store, error)", false),
+            Some("Store")
+        );
+        assert_eq!(
+            first_result("(opts ...func(*Options)) (s Store, err error)", false),
+            Some("Store")
+        );
+        assert_eq!(first_result("(s *Store) () *Store", true), Some("Store"));
+        assert_eq!(first_result("() Entry[string]", false), Some("Entry"));
+        assert_eq!(first_result("(id int) error", false), Some("error"));
+        assert_eq!(first_result("()", false), None);
+        assert_eq!(first_result("() map[string]Store", false), None);
+    }
+
+    #[test]
+    fn test_sites_and_factories() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, kind, line: u32, signature: &str| {
+            Symbol::new(name, kind, "store/store.go", line, line + 3, 0, 100)
+                .with_signature(Some(signature.to_string()))
+        };
+        let new = func("NewStore", SymbolKind::Function, 5, "(db *sql.DB) *Store");
+        let clone = func("Clone", SymbolKind::Method, 10, "(s *Store) () *Store");
+        let get = func("Get", SymbolKind::Method, 15, "(s *Store) (id int) error");
+        let main = Symbol::new("main", SymbolKind::Function, "main.go", 3, 8, 0, 100)
+            .with_signature(Some("()".to_string()));
+        db.insert_symbols(&[new.clone(), clone, get, main.clone()])
+            .unwrap();
+        db.insert_constructions(
+            "store/store.go",
+            &[Construction {
+                symbol_id: new.id.clone(),
+                line: 6,
+                type_name: "Store".to_string(),
+                kind: ConstructionKind::Pointer,
+            }],
+        )
+        .unwrap();
+        db.insert_edges(&[Edge::new(
+            &main.id,
+            "store.NewStore",
+            EdgeKind::Calls,
+            "main.go",
+            4,
+        )])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let found = constructs(&db, "Store").unwrap();
+        assert_eq!(found.sites.len(), 1);
+        assert_eq!(found.sites[0].symbol.name, "NewStore");
+        let factories: Vec<_> = found
+            .factories
+            .iter()
+            .map(|f| (f.symbol.name.as_str(), f.constructor, f.calls.len()))
+            .collect();
+        assert_eq!(factories, [("NewStore", true, 1), ("Clone", false, 0)]);
+        assert_eq!(found.factories[0].calls[0].0.name, "main");
+    }
+}
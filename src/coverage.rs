@@ -0,0 +1,218 @@
+//! Test coverage overlay: statement coverage from a Go cover profile
+//! (`go test -coverprofile`), attached to the functions and methods it covers.
+//!
+//! A profile lists blocks as `path/file.go:startLine.col,endLine.col statements
+//! count`, with the file named by import path. A profile file is matched to the
+//! indexed file that is its longest `/`-bounded suffix, so the module path needs no
+//! configuration. Each block counts towards the innermost function or method around
+//! it; closures are not symbols, so they count towards the function declaring them.
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{Coverage, Symbol, SymbolKind};
+
+/// One block of a cover profile.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Block {
+    pub file: String,
+    pub start_line: u32,
+    pub end_line: u32,
+    pub statements: u32,
+    /// Whether any test ran the block.
+    pub covered: bool,
+}
+
+/// What an import matched.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct ImportSummary {
+    /// Profile files found in the index.
+    pub files: u32,
+    /// Functions and methods that got coverage.
+    pub symbols: u32,
+    pub covered: u32,
+    pub total: u32,
+    /// Profile files with no indexed counterpart, e.g. generated code.
+    pub unmatched: Vec<String>,
+}
+
+/// Parse a cover profile in any mode (`set`, `count`, `atomic`). Blocks repeated
+/// across test binaries are merged: covered if any run covered them.
+pub fn parse_profile(text: &str) -> Result<Vec<Block>> {
+    let mut merged: BTreeMap<(String, u32, u32, String), (u32, bool)> = BTreeMap::new();
+    for (i, line) in text.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with("mode:") {
+            continue;
+        }
+        let parse = || -> Option<(String, u32, u32, String, u32, u64)> {
+            let (location, rest) = line.rsplit_once(':')?;
+            let mut fields = rest.split_whitespace();
+            let span = fields.next()?;
+            let statements = fields.next()?.parse().ok()?;
+            let count = fields.next()?.parse().ok()?;
+            let (start, end) = span.split_once(',')?;
+            let start_line = start.split_once('.')?.0.parse().ok()?;
+            let end_line = end.split_once('.')?.0.parse().ok()?;
+            Some((
+                location.to_string(),
+                start_line,
+                end_line,
+                span.to_string(),
+                statements,
+                count,
+            ))
+        };
+        let (file, start_line, end_line, span, statements, count) =
+            parse().with_context(|| format!("line {}: not a cover profile block", i + 1))?;
+        let entry = merged
+            .entry((file, start_line, end_line, span))
+            .or_insert((statements, false));
+        entry.1 |= count > 0;
+    }
+    Ok(merged
+        .into_iter()
+        .map(
+            |((file, start_line, end_line, _), (statements, covered))| Block {
+                file,
+                start_line,
+                end_line,
+                statements,
+                covered,
+            },
+        )
+        .collect())
+}
+
+/// The indexed file `profile_file` names: the longest of `files` it ends with at
+/// a `/` boundary (or equals).
+fn resolve<'f>(profile_file: &str, files: &'f HashSet<String>) -> Option<&'f str> {
+    let mut rest = profile_file;
+    loop {
+        if let Some(file) = files.get(rest) {
+            return Some(file.as_str());
+        }
+        rest = rest.split_once('/')?.1;
+    }
+}
+
+/// Coverage of each function and method among `symbols` (all from one file) from
+/// that file's `blocks`.
+fn attribute(blocks: &[&Block], symbols: &[Symbol]) -> HashMap<String, Coverage> {
+    let functions: Vec<&Symbol> = symbols
+        .iter()
+        .filter(|s| matches!(s.kind, SymbolKind::Function | SymbolKind::Method))
+        .collect();
+    let mut coverage: HashMap<String, Coverage> = HashMap::new();
+    for block in blocks {
+        let owner = functions
+            .iter()
+            .filter(|f| f.start_line <= block.start_line && block.end_line <= f.end_line)
+            .min_by_key(|f| f.end_line - f.start_line);
+        if let Some(owner) = owner {
+            let c = coverage.entry(owner.id.clone()).or_default();
+            c.total += block.statements;
+            if block.covered {
+                c.covered += block.statements;
+            }
+        }
+    }
+    coverage
+}
+
+/// Replace the index's coverage with the profile `text`.
+pub fn import(db: &Database, text: &str) -> Result<ImportSummary> {
+    let blocks = parse_profile(text)?;
+    let files: HashSet<String> = db.all_files()?.into_iter().collect();
+    let mut by_file: BTreeMap<&str, Vec<&Block>> = BTreeMap::new();
+    let mut summary = ImportSummary::default();
+    for block in &blocks {
+        match resolve(&block.file, &files) {
+            Some(file) => by_file.entry(file).or_default().push(block),
+            None if !summary.unmatched.contains(&block.file) => {
+                summary.unmatched.push(block.file.clone())
+            }
+            None => {}
+        }
+    }
+    let mut items = Vec::new();
+    for (file, blocks) in by_file {
+        summary.files += 1;
+        for (symbol_id, c) in attribute(&blocks, &db.outline(file)?) {
+            summary.symbols += 1;
+            summary.covered += c.covered;
+            summary.total += c.total;
+            items.push((symbol_id, file.to_string(), c));
+        }
+    }
+    db.replace_coverage(&items)?;
+    Ok(summary)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::db::SearchFilter;
+    use crate::types::FileInfo;
+
+    #[test]
+    fn test_import_attaches_coverage_and_finds_uncovered() {
+        let db = Database::open_memory().unwrap();
+        let file = "internal/services/payment/charge.go";
+        let charge = Symbol::new("Charge", SymbolKind::Function, file, 5, 12, 0, 200);
+        let refund = Symbol::new("Refund", SymbolKind::Function, file, 14, 20, 210, 400);
+        let other = Symbol::new("Refund", SymbolKind::Function, "cmd/refund.go", 3, 6, 0, 90);
+        db.insert_symbols(&[charge.clone(), refund.clone(), other])
+            .unwrap();
+        for path in [file, "cmd/refund.go"] {
+            db.upsert_file(&FileInfo {
+                path: path.to_string(),
+                last_modified: 0.0,
+                hash: String::new(),
+                language: "go".to_string(),
+                num_symbols: 1,
+            })
+            .unwrap();
+        }
+
+        let profile = "mode: set
+github.com/acme/shop/internal/services/payment/charge.go:5.30,7.2 2 1
+github.com/acme/shop/internal/services/payment/charge.go:8.10,10.3 2 0
+github.com/acme/shop/internal/services/payment/charge.go:8.10,10.3 2 1
+github.com/acme/shop/internal/services/payment/charge.go:11.2,11.12 1 0
+github.com/acme/shop/internal/services/payment/charge.go:14.30,19.2 3 0
+github.com/acme/shop/internal/gen/types.go:3.1,4.2 1 1
+";
+        let summary = import(&db, profile).unwrap();
+        assert_eq!((summary.files, summary.symbols), (1, 2));
+        assert_eq!(
+            summary.unmatched,
+            ["github.com/acme/shop/internal/gen/types.go"]
+        );
+
+        let coverage = db
+            .coverage_of(&[charge.id.clone(), refund.id.clone()])
+            .unwrap();
+        assert_eq!(
+            coverage[&charge.id],
+            Coverage {
+                covered: 4,
+                total: 5
+            }
+        );
+        assert_eq!(coverage[&refund.id].percent(), 0.0);
+
+        let filter = SearchFilter {
+            kind: Some(SymbolKind::Function),
+            package: Some("internal/services/payment"),
+            uncovered: true,
+            ..SearchFilter::default()
+        };
+        let uncovered = db.search_filtered("", &filter, 30).unwrap();
+        assert_eq!(uncovered, [refund]);
+        assert!(parse_profile("mode: set\nnot a block\n").is_err());
+    }
+}
@@ -0,0 +1,202 @@
+//! Context propagation audit: find functions that run I/O or start a fresh root
+//! context although a `context.Context` is available upstream.
+//!
+//! Sites are recorded at index time (see `languages::ctx`). A function that takes a
+//! context and loses it is reported on its own; one that takes none is reported
+//! with the chain of callers from the nearest one that had a context to pass. A
+//! function with no context anywhere above it (`main`, a test) is left alone.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::panics::PathStep;
+use crate::types::{ContextSite, EdgeKind, Symbol};
+
+/// A function that drops a context it has, or that a caller could have passed it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ContextFinding {
+    /// From the function holding the context down to `function`, which ends it.
+    /// Only `function` itself when it takes the context and drops it.
+    pub chain: Vec<PathStep>,
+    pub function: Symbol,
+    pub sites: Vec<ContextSite>,
+}
+
+/// Every function that loses a context, looking up to `depth` callers above those
+/// that take none. Ordered by file and line.
+pub fn audit(db: &Database, depth: u32) -> Result<Vec<ContextFinding>> {
+    let mut by_function: Vec<(String, Vec<ContextSite>)> = Vec::new();
+    for site in db.context_sites()? {
+        match by_function.last_mut() {
+            Some((id, sites)) if *id == site.symbol_id => sites.push(site),
+            _ => by_function.push((site.symbol_id.clone(), vec![site])),
+        }
+    }
+    if by_function.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    let takes: HashSet<String> = db.context_symbols()?.into_iter().collect();
+    let mut callers: HashMap<String, Vec<(String, u32)>> = HashMap::new();
+    for edge in db.all_edges()? {
+        if let (EdgeKind::Calls, Some(target)) = (edge.kind, edge.target_id) {
+            callers
+                .entry(target)
+                .or_default()
+                .push((edge.source_id, edge.line));
+        }
+    }
+    let symbols: HashMap<String, Symbol> = db
+        .all_symbols()?
+        .into_iter()
+        .map(|s| (s.id.clone(), s))
+        .collect();
+
+    let mut findings = Vec::new();
+    for (id, sites) in by_function {
+        let Some(function) = symbols.get(&id) else {
+            continue;
+        };
+        let chain = if takes.contains(&id) {
+            vec![PathStep {
+                symbol: function.clone(),
+                line: None,
+            }]
+        } else {
+            match chain_from_context(&id, &takes, &callers, &symbols, depth) {
+                Some(chain) => chain,
+                None => continue,
+            }
+        };
+        findings.push(ContextFinding {
+            chain,
+            function: function.clone(),
+            sites,
+        });
+    }
+    Ok(findings)
+}
+
+/// The shortest caller chain from a function that takes a context down to `id`,
+/// passing only through functions that take none.
+fn chain_from_context(
+    id: &str,
+    takes: &HashSet<String>,
+    callers: &HashMap<String, Vec<(String, u32)>>,
+    symbols: &HashMap<String, Symbol>,
+    depth: u32,
+) -> Option<Vec<PathStep>> {
+    // `callee` link of each caller reached: the function it calls and the call's line.
+    let mut callee: HashMap<&str, (&str, u32)> = HashMap::new();
+    let mut queue = VecDeque::from([(id, 0)]);
+    let mut seen = HashSet::from([id]);
+    while let Some((current, level)) = queue.pop_front() {
+        if level == depth {
+            continue;
+        }
+        for (caller, line) in callers.get(current).into_iter().flatten() {
+            let caller = caller.as_str();
+            if !seen.insert(caller) {
+                continue;
+            }
+            callee.insert(caller, (current, *line));
+            if takes.contains(caller) {
+                let mut chain = vec![PathStep {
+                    symbol: symbols.get(caller)?.clone(),
+                    line: None,
+                }];
+                let mut at = caller;
+                while let Some(&(next, line)) = callee.get(at) {
+                    chain.push(PathStep {
+                        symbol: symbols.get(next)?.clone(),
+                        line: Some(line),
+                    });
+                    at = next;
+                }
+                return Some(chain);
+            }
+            queue.push_back((caller, level + 1));
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{ContextLoss, Edge, SymbolKind};
+
+    #[test]
+    fn test_audit_reports_losses_below_a_context() {
+        let db = Database::open_memory().unwrap();
+        let file = "store/store.go";
+        let sym = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 4, 0, 100)
+        };
+        let handler = sym("Handle", 1);
+        let load = sym("load", 10);
+        let fetch = sym("fetch", 20);
+        let main = sym("main", 30);
+        let ping = sym("Ping", 40);
+        db.insert_symbols(&[
+            handler.clone(),
+            load.clone(),
+            fetch.clone(),
+            main.clone(),
+            ping.clone(),
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(&handler.id, "load", EdgeKind::Calls, file, 2),
+            Edge::new(&load.id, "fetch", EdgeKind::Calls, file, 11),
+            Edge::new(&main.id, "fetch", EdgeKind::Calls, file, 31),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let site = |symbol: &Symbol, line, loss, callee: &str| ContextSite {
+            symbol_id: symbol.id.clone(),
+            line,
+            loss,
+            callee: callee.to_string(),
+        };
+        db.insert_context_sites(
+            file,
+            &[handler.id.clone(), ping.id.clone()],
+            &[
+                site(&fetch, 21, ContextLoss::Io, "db.Query"),
+                site(&main, 32, ContextLoss::Fresh, "context.Background"),
+                site(&ping, 41, ContextLoss::Io, "http.Get"),
+            ],
+        )
+        .unwrap();
+
+        let findings = audit(&db, 5).unwrap();
+        let found: Vec<_> = findings
+            .iter()
+            .map(|f| {
+                let chain: Vec<_> = f
+                    .chain
+                    .iter()
+                    .map(|s| (s.symbol.name.as_str(), s.line))
+                    .collect();
+                (f.function.name.as_str(), chain, f.sites.len())
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (
+                    "fetch",
+                    vec![("Handle", None), ("load", Some(2)), ("fetch", Some(11))],
+                    1
+                ),
+                ("Ping", vec![("Ping", None)], 1),
+            ]
+        );
+
+        assert_eq!(audit(&db, 1).unwrap().len(), 1);
+    }
+}
@@ -1,11 +1,26 @@
+use std::collections::HashSet;
+
 use anyhow::{Context, Result};
-use rusqlite::ffi::sqlite3_auto_extension;
-use rusqlite::{params, Connection, OptionalExtension};
+use rusqlite::ffi::{self, sqlite3_auto_extension};
+use rusqlite::{params, Connection, OpenFlags, OptionalExtension};
 use serde::Serialize;
 use sqlite_vec::sqlite3_vec_init;
 use tracing::warn;
 
-use crate::types::{Edge, EdgeKind, FileInfo, Symbol, SymbolKind, Visibility};
+use crate::bloom::BloomFilter;
+use crate::deprecations::BurndownPoint;
+use crate::doc::{Dependency, Package};
+use crate::dupes::Fingerprint;
+use crate::explain;
+use crate::lineage::{RenameLink, RenameReason};
+use crate::otel;
+use crate::snapshot::Snapshot;
+use crate::types::{
+    Centrality, Complexity, ConfigField, Constant, Construction, ContextSite, Coverage, Edge,
+    EdgeKind, ErrorFlow, ErrorHandling, FieldUse, FileInfo, LogStatement, PanicSite, Route,
+    Serialization, StructField, Symbol, SymbolKind, SyncSite, SyntaxError, TestDouble, Todo,
+    TypeAssertion, VariableAccess, Visibility,
+};
 
 const SQL_INSERT_SYMBOL: &str = "INSERT OR REPLACE INTO symbols
      (id, name, kind, file_path, start_line, end_line, start_byte, end_byte,
@@ -16,6 +31,40 @@ const SQL_INSERT_EDGE: &str =
     "INSERT INTO edges (source_id, target_name, target_id, kind, file_path, line)
      VALUES (?1, ?2, ?3, ?4, ?5, ?6)";
 
+/// Edges pointing at a name, either by unresolved target name or by resolved target
+/// symbol. The OR is written against `edges` columns only so SQLite can answer
+/// each branch from an index (target name, target id) rather than scanning.
+const SQL_REFS: &str =
+    "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
+            s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+            s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+            s.is_async, s.docstring
+     FROM edges e
+     LEFT JOIN symbols s ON e.source_id = s.id
+     WHERE (e.target_name = ?1
+            OR e.target_id IN (SELECT id FROM symbols WHERE name = ?1))
+       AND (?2 IS NULL OR e.kind = ?2)";
+
+/// [`SQL_REFS`] for the one symbol `?1` is the ID of: resolved edges only.
+const SQL_REFS_TO_ID: &str =
+    "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
+            s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+            s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+            s.is_async, s.docstring
+     FROM edges e
+     LEFT JOIN symbols s ON e.source_id = s.id
+     WHERE e.target_id = ?1
+       AND (?2 IS NULL OR e.kind = ?2)";
+
+/// Same match as [`SQL_REFS`], projecting only the source symbol's name.
+const SQL_DEPENDENTS: &str =
+    "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
+            s.name
+     FROM edges e
+     LEFT JOIN symbols s ON e.source_id = s.id
+     WHERE e.target_name = ?1
+        OR e.target_id IN (SELECT id FROM symbols WHERE name = ?1)";
+
 const SCHEMA: &str = r#"
 CREATE TABLE IF NOT EXISTS symbols (
     id TEXT PRIMARY KEY,
@@ -57,16 +106,398 @@ CREATE TABLE IF NOT EXISTS metadata (
     value TEXT
 );
 
-CREATE INDEX IF NOT EXISTS idx_symbols_name ON symbols(name);
+CREATE TABLE IF NOT EXISTS branch_states (
+    branch TEXT PRIMARY KEY,
+    commit_sha TEXT NOT NULL,
+    dirty_files TEXT NOT NULL,
+    indexed_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS branch_files (
+    branch TEXT NOT NULL,
+    path TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    PRIMARY KEY (branch, path)
+);
+
+CREATE INDEX IF NOT EXISTS idx_branch_files_path ON branch_files(path, hash);
+
+CREATE TABLE IF NOT EXISTS parse_cache (
+    path TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    settings TEXT NOT NULL,
+    parsed TEXT NOT NULL,
+    PRIMARY KEY (path, hash, settings)
+);
+
+CREATE TABLE IF NOT EXISTS symbol_renames (
+    old_name TEXT NOT NULL,
+    old_file TEXT NOT NULL,
+    old_start_line INTEGER,
+    old_end_line INTEGER,
+    new_name TEXT NOT NULL,
+    new_file TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    old_commit TEXT,
+    PRIMARY KEY (old_file, old_name, new_file, new_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_renames_new ON symbol_renames(new_file, new_name);
+CREATE INDEX IF NOT EXISTS idx_renames_old_name ON symbol_renames(old_name);
+
+CREATE TABLE IF NOT EXISTS symbol_tags (
+    tag TEXT NOT NULL,
+    symbol_id TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    PRIMARY KEY (tag, symbol_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_tags_file ON symbol_tags(file_path);
+CREATE INDEX IF NOT EXISTS idx_symbol_tags_symbol ON symbol_tags(symbol_id);
+
+CREATE TABLE IF NOT EXISTS symbol_metrics (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    cyclomatic INTEGER NOT NULL,
+    cognitive INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_metrics_file ON symbol_metrics(file_path);
+
+CREATE TABLE IF NOT EXISTS symbol_coverage (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    covered INTEGER NOT NULL,
+    total INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_coverage_file ON symbol_coverage(file_path);
+
+CREATE TABLE IF NOT EXISTS symbol_rank (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    pagerank REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_rank_file ON symbol_rank(file_path);
+CREATE INDEX IF NOT EXISTS idx_symbol_rank_pagerank ON symbol_rank(pagerank);
+
+CREATE TABLE IF NOT EXISTS symbol_betweenness (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    betweenness REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_betweenness_file ON symbol_betweenness(file_path);
+
+CREATE TABLE IF NOT EXISTS symbol_fingerprints (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    hash INTEGER NOT NULL,
+    minhash BLOB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_fingerprints_file ON symbol_fingerprints(file_path);
+
+CREATE TABLE IF NOT EXISTS symbol_hashes (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_symbol_hashes_file ON symbol_hashes(file_path);
+
+CREATE TABLE IF NOT EXISTS fallible_symbols (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_fallible_symbols_file ON fallible_symbols(file_path);
+
+CREATE TABLE IF NOT EXISTS error_flows (
+    source_id TEXT NOT NULL,
+    target_name TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    handling TEXT NOT NULL,
+    PRIMARY KEY (source_id, line, target_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_error_flows_file ON error_flows(file_path);
+
+CREATE TABLE IF NOT EXISTS panic_sites (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    what TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, what)
+);
+
+CREATE INDEX IF NOT EXISTS idx_panic_sites_file ON panic_sites(file_path);
+
+CREATE TABLE IF NOT EXISTS sync_sites (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    op TEXT NOT NULL,
+    object TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, op, object)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_sites_file ON sync_sites(file_path);
+
+CREATE TABLE IF NOT EXISTS context_symbols (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_context_symbols_file ON context_symbols(file_path);
+
+CREATE TABLE IF NOT EXISTS context_sites (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    loss TEXT NOT NULL,
+    callee TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, callee)
+);
+
+CREATE INDEX IF NOT EXISTS idx_context_sites_file ON context_sites(file_path);
+
+CREATE TABLE IF NOT EXISTS global_vars (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    dir TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_global_vars_file ON global_vars(file_path);
+
+CREATE TABLE IF NOT EXISTS variable_accesses (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    dir TEXT NOT NULL,
+    name TEXT NOT NULL,
+    qualifier TEXT,
+    write INTEGER NOT NULL,
+    PRIMARY KEY (symbol_id, line, name, qualifier, write)
+);
+
+CREATE INDEX IF NOT EXISTS idx_variable_accesses_file ON variable_accesses(file_path);
+CREATE INDEX IF NOT EXISTS idx_variable_accesses_name ON variable_accesses(name);
+
+CREATE TABLE IF NOT EXISTS routes (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    handler TEXT,
+    middleware TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, method, path)
+);
+
+CREATE INDEX IF NOT EXISTS idx_routes_file ON routes(file_path);
+
+CREATE TABLE IF NOT EXISTS config_fields (
+    symbol_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    key TEXT,
+    PRIMARY KEY (symbol_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_config_fields_file ON config_fields(file_path);
+
+CREATE TABLE IF NOT EXISTS field_uses (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    field TEXT NOT NULL,
+    receiver TEXT NOT NULL,
+    write INTEGER NOT NULL,
+    PRIMARY KEY (symbol_id, line, field, receiver, write)
+);
+
+CREATE INDEX IF NOT EXISTS idx_field_uses_file ON field_uses(file_path);
+CREATE INDEX IF NOT EXISTS idx_field_uses_field ON field_uses(field);
+
+CREATE TABLE IF NOT EXISTS struct_fields (
+    symbol_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    tags TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_struct_fields_file ON struct_fields(file_path);
+
+CREATE TABLE IF NOT EXISTS serializations (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    type_name TEXT NOT NULL,
+    format TEXT NOT NULL,
+    decode INTEGER NOT NULL,
+    PRIMARY KEY (symbol_id, line, type_name, format)
+);
+
+CREATE INDEX IF NOT EXISTS idx_serializations_file ON serializations(file_path);
+CREATE INDEX IF NOT EXISTS idx_serializations_type ON serializations(type_name);
+
+CREATE TABLE IF NOT EXISTS constants (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    type_name TEXT,
+    value TEXT,
+    expr TEXT NOT NULL,
+    iota INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_constants_file ON constants(file_path);
+CREATE INDEX IF NOT EXISTS idx_constants_type ON constants(type_name);
+
+CREATE TABLE IF NOT EXISTS type_assertions (
+    symbol_id TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    interface TEXT,
+    type_name TEXT NOT NULL,
+    type_switch INTEGER NOT NULL,
+    PRIMARY KEY (symbol_id, line, type_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_type_assertions_file ON type_assertions(file_path);
+CREATE INDEX IF NOT EXISTS idx_type_assertions_type ON type_assertions(type_name);
+CREATE INDEX IF NOT EXISTS idx_type_assertions_interface ON type_assertions(interface);
+
+CREATE TABLE IF NOT EXISTS test_doubles (
+    symbol_id TEXT PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    interface TEXT NOT NULL,
+    generator TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_test_doubles_file ON test_doubles(file_path);
+CREATE INDEX IF NOT EXISTS idx_test_doubles_interface ON test_doubles(interface);
+
+CREATE TABLE IF NOT EXISTS constructions (
+    symbol_id TEXT NOT NULL,
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    type_name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, type_name, kind)
+);
+
+CREATE INDEX IF NOT EXISTS idx_constructions_file ON constructions(file_path);
+CREATE INDEX IF NOT EXISTS idx_constructions_type ON constructions(type_name);
+
+CREATE TABLE IF NOT EXISTS syntax_errors (
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    message TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_syntax_errors_file ON syntax_errors(file_path);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+    tag TEXT PRIMARY KEY,
+    created_at INTEGER NOT NULL,
+    commit_sha TEXT
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_packages (
+    tag TEXT NOT NULL,
+    package TEXT NOT NULL,
+    files INTEGER NOT NULL,
+    symbols INTEGER NOT NULL,
+    PRIMARY KEY (tag, package)
+);
+
+CREATE TABLE IF NOT EXISTS snapshot_deps (
+    tag TEXT NOT NULL,
+    from_package TEXT NOT NULL,
+    to_package TEXT NOT NULL,
+    edges INTEGER NOT NULL,
+    PRIMARY KEY (tag, from_package, to_package)
+);
+
+CREATE TABLE IF NOT EXISTS deprecation_counts (
+    recorded_at INTEGER PRIMARY KEY,
+    symbols INTEGER NOT NULL,
+    usages INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS log_statements (
+    symbol_id TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    file_path TEXT NOT NULL,
+    level TEXT NOT NULL,
+    component TEXT,
+    template TEXT NOT NULL,
+    PRIMARY KEY (symbol_id, line, template)
+);
+
+CREATE INDEX IF NOT EXISTS idx_log_statements_file ON log_statements(file_path);
+
+CREATE TABLE IF NOT EXISTS todos (
+    file_path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    symbol_id TEXT,
+    marker TEXT NOT NULL,
+    assignee TEXT,
+    text TEXT NOT NULL,
+    PRIMARY KEY (file_path, line)
+);
+"#;
+
+/// Secondary indexes on the graph tables.
+///
+/// Kept separate from [`SCHEMA`] so a bulk load can drop them and rebuild them
+/// once at the end, instead of updating every B-tree on each insert.
+///
+/// Composite keys match the hot lookups: edges by (endpoint, kind) so kind-filtered
+/// queries never visit other edge types, symbols by (file, line) so `outline` needs
+/// no sort, and symbols by (name, file, id) so resolution reads only the index.
+const GRAPH_INDEXES: &str = r#"
+CREATE INDEX IF NOT EXISTS idx_symbols_name_file ON symbols(name, file_path, id);
 CREATE INDEX IF NOT EXISTS idx_symbols_kind ON symbols(kind);
-CREATE INDEX IF NOT EXISTS idx_symbols_file ON symbols(file_path);
+CREATE INDEX IF NOT EXISTS idx_symbols_file_line ON symbols(file_path, start_line);
 CREATE INDEX IF NOT EXISTS idx_symbols_parent ON symbols(parent_id);
-CREATE INDEX IF NOT EXISTS idx_edges_source ON edges(source_id);
-CREATE INDEX IF NOT EXISTS idx_edges_target ON edges(target_name);
+CREATE INDEX IF NOT EXISTS idx_edges_source_kind ON edges(source_id, kind);
+CREATE INDEX IF NOT EXISTS idx_edges_target_kind ON edges(target_name, kind);
 CREATE INDEX IF NOT EXISTS idx_edges_target_id ON edges(target_id);
+CREATE INDEX IF NOT EXISTS idx_edges_file_kind ON edges(file_path, kind);
 CREATE INDEX IF NOT EXISTS idx_edges_kind ON edges(kind);
 "#;
 
+/// Indexes superseded by the composite ones in [`GRAPH_INDEXES`], dropped from
+/// databases created by older versions.
+const LEGACY_GRAPH_INDEXES: &str = r#"
+DROP INDEX IF EXISTS idx_symbols_name;
+DROP INDEX IF EXISTS idx_symbols_file;
+DROP INDEX IF EXISTS idx_edges_source;
+DROP INDEX IF EXISTS idx_edges_target;
+"#;
+
+const DROP_GRAPH_INDEXES: &str = r#"
+DROP INDEX IF EXISTS idx_symbols_name_file;
+DROP INDEX IF EXISTS idx_symbols_kind;
+DROP INDEX IF EXISTS idx_symbols_file_line;
+DROP INDEX IF EXISTS idx_symbols_parent;
+DROP INDEX IF EXISTS idx_edges_source_kind;
+DROP INDEX IF EXISTS idx_edges_target_kind;
+DROP INDEX IF EXISTS idx_edges_target_id;
+DROP INDEX IF EXISTS idx_edges_file_kind;
+DROP INDEX IF EXISTS idx_edges_kind;
+"#;
+
 /// Schema for RAG semantic search tables.
 ///
 /// - `symbol_content`: stores raw source code for each symbol (extracted via byte offsets)
@@ -115,10 +546,49 @@ const RAG_VEC_SCHEMA: &str =
 /// Default database filename, stored in the project root.
 pub const DB_FILE: &str = ".cartog.db";
 
+/// Version of the on-disk schema, stored in `PRAGMA user_version`.
+///
+/// Bump whenever `SCHEMA`, `GRAPH_INDEXES` or the RAG schema change: databases
+/// with an older version re-run the (idempotent) DDL once on open, newer ones
+/// skip it entirely.
+const SCHEMA_VERSION: i64 = 26;
+
+fn set_schema_version(conn: &Connection, version: i64) -> Result<()> {
+    conn.execute_batch(&format!("PRAGMA user_version={version};"))
+        .context("Failed to record schema version")
+}
+
 /// Maximum number of results returned by [`Database::search`].
 /// Enforced here and referenced by CLI and MCP layers.
 pub const MAX_SEARCH_LIMIT: u32 = 100;
 
+/// Read a database file (and its WAL) sequentially so its pages are in the OS
+/// page cache before the first query touches them. Returns the bytes read.
+pub fn prewarm_file(path: impl AsRef<std::path::Path>) -> Result<u64> {
+    use std::io::Read;
+
+    let path = path.as_ref();
+    let mut wal = path.as_os_str().to_owned();
+    wal.push("-wal");
+    let mut total = 0u64;
+    let mut buf = vec![0u8; 1024 * 1024];
+    for file in [path.to_path_buf(), std::path::PathBuf::from(wal)] {
+        let Ok(mut f) = std::fs::File::open(&file) else {
+            continue;
+        };
+        loop {
+            let n = f
+                .read(&mut buf)
+                .with_context(|| format!("failed to read {}", file.display()))?;
+            if n == 0 {
+                break;
+            }
+            total += n as u64;
+        }
+    }
+    Ok(total)
+}
+
 /// Split a symbol name into lowercase words for FTS5 indexing.
 ///
 /// Handles camelCase, PascalCase, snake_case, SCREAMING_SNAKE_CASE, and
@@ -175,6 +645,18 @@ pub fn normalize_symbol_name(name: &str) -> String {
     words.join(" ")
 }
 
+/// The one profiling hook a connection can have, feeding both `--explain` and
+/// OpenTelemetry; each ignores statements while it is off.
+unsafe extern "C" fn on_profile(
+    event: std::os::raw::c_uint,
+    ctx: *mut std::os::raw::c_void,
+    stmt: *mut std::os::raw::c_void,
+    nanos: *mut std::os::raw::c_void,
+) -> std::os::raw::c_int {
+    explain::on_profile(event, ctx, stmt, nanos);
+    otel::on_profile(event, ctx, stmt, nanos)
+}
+
 pub struct Database {
     conn: Connection,
 }
@@ -204,20 +686,62 @@ impl Database {
         register_sqlite_vec();
         let conn = Connection::open(path.as_ref()).context("Failed to open database")?;
         conn.execute_batch(
-            "PRAGMA journal_mode=WAL;
-             PRAGMA foreign_keys=ON;
+            "PRAGMA foreign_keys=ON;
              PRAGMA synchronous=NORMAL;
              PRAGMA cache_size=-65536;
              PRAGMA temp_store=MEMORY;
              PRAGMA mmap_size=268435456;",
         )
         .context("Failed to set pragmas")?;
-        conn.execute_batch(SCHEMA)
-            .context("Failed to create schema")?;
-        conn.execute_batch(RAG_SCHEMA)
-            .context("Failed to create RAG schema")?;
-        conn.execute_batch(RAG_VEC_SCHEMA)
-            .context("Failed to create sqlite-vec table")?;
+
+        // Fast path for the common one-shot query: an index already at this schema
+        // version needs no DDL (WAL mode is persistent in the file header).
+        let version: i64 = conn
+            .query_row("PRAGMA user_version", [], |row| row.get(0))
+            .context("Failed to read schema version")?;
+        if version < SCHEMA_VERSION {
+            conn.execute_batch("PRAGMA journal_mode=WAL;")
+                .context("Failed to enable WAL")?;
+            conn.execute_batch(SCHEMA)
+                .context("Failed to create schema")?;
+            conn.execute_batch(LEGACY_GRAPH_INDEXES)
+                .context("Failed to drop legacy indexes")?;
+            conn.execute_batch(GRAPH_INDEXES)
+                .context("Failed to create indexes")?;
+            conn.execute_batch(RAG_SCHEMA)
+                .context("Failed to create RAG schema")?;
+            conn.execute_batch(RAG_VEC_SCHEMA)
+                .context("Failed to create sqlite-vec table")?;
+            set_schema_version(&conn, SCHEMA_VERSION)?;
+        }
+        Ok(Self { conn })
+    }
+
+    /// Open an existing index without write access. It must already be at the
+    /// current schema version, since upgrading it would write.
+    pub fn open_read_only(path: impl AsRef<std::path::Path>) -> Result<Self> {
+        register_sqlite_vec();
+        let conn = Connection::open_with_flags(
+            path.as_ref(),
+            OpenFlags::SQLITE_OPEN_READ_ONLY
+                | OpenFlags::SQLITE_OPEN_URI
+                | OpenFlags::SQLITE_OPEN_NO_MUTEX,
+        )
+        .context("Failed to open database read-only")?;
+        conn.execute_batch(
+            "PRAGMA cache_size=-65536;
+             PRAGMA temp_store=MEMORY;
+             PRAGMA mmap_size=268435456;",
+        )
+        .context("Failed to set pragmas")?;
+        let version: i64 = conn
+            .query_row("PRAGMA user_version", [], |row| row.get(0))
+            .context("Failed to read schema version")?;
+        if version < SCHEMA_VERSION {
+            anyhow::bail!(
+                "index schema is version {version}, not {SCHEMA_VERSION}; run `cartog index .` to upgrade it"
+            );
+        }
         Ok(Self { conn })
     }
 
@@ -228,11 +752,119 @@ impl Database {
         let conn = Connection::open_in_memory()?;
         conn.execute_batch("PRAGMA foreign_keys=ON;")?;
         conn.execute_batch(SCHEMA)?;
+        conn.execute_batch(GRAPH_INDEXES)?;
         conn.execute_batch(RAG_SCHEMA)?;
         conn.execute_batch(RAG_VEC_SCHEMA)?;
         Ok(Self { conn })
     }
 
+    /// Write a consistent copy of the whole database to a new file at `path`.
+    pub fn copy_to(&self, path: &std::path::Path) -> Result<()> {
+        self.conn
+            .execute("VACUUM INTO ?1", params![path.to_string_lossy()])
+            .with_context(|| format!("Failed to copy index to {}", path.display()))?;
+        Ok(())
+    }
+
+    /// Replace everything in this database with the database at `source`, in one
+    /// write transaction: other connections read either the old contents or the
+    /// new ones, never a mix, and reads already under way finish on the old.
+    pub fn replace_with(&mut self, source: &std::path::Path) -> Result<()> {
+        let source = Connection::open_with_flags(source, OpenFlags::SQLITE_OPEN_READ_ONLY)
+            .with_context(|| format!("Failed to open {}", source.display()))?;
+        let backup = rusqlite::backup::Backup::new(&source, &mut self.conn)?;
+        backup
+            .run_to_completion(-1, std::time::Duration::from_millis(50), None)
+            .context("Failed to swap in the new index")?;
+        Ok(())
+    }
+
+    // ── Read path ──
+
+    /// Memory-map up to `bytes` of the database file for reads; 0 disables mmap.
+    ///
+    /// Mapped pages are served from the OS page cache without a copy into SQLite's
+    /// own cache. Returns the size SQLite actually applied: it is capped by the
+    /// compile-time `SQLITE_MAX_MMAP_SIZE`.
+    pub fn set_mmap_size(&self, bytes: u64) -> Result<u64> {
+        let applied: i64 =
+            self.conn
+                .query_row(&format!("PRAGMA mmap_size={bytes}"), [], |row| row.get(0))?;
+        Ok(applied.max(0) as u64)
+    }
+
+    /// Read the symbol and edge tables and their graph indexes once, pulling
+    /// their pages into the page cache (and the mmap) ahead of real queries.
+    pub fn touch_graph_indexes(&self) -> Result<()> {
+        const TOUCH: &[&str] = &[
+            // Unindexed columns force a pass over the table B-trees themselves.
+            "SELECT MAX(end_line) FROM symbols",
+            "SELECT MAX(line) FROM edges",
+            "SELECT COUNT(*) FROM symbols INDEXED BY idx_symbols_name_file",
+            "SELECT COUNT(*) FROM symbols INDEXED BY idx_symbols_file_line",
+            "SELECT COUNT(*) FROM edges INDEXED BY idx_edges_target_kind",
+            "SELECT COUNT(*) FROM edges INDEXED BY idx_edges_source_kind",
+            "SELECT COUNT(*) FROM edges INDEXED BY idx_edges_target_id",
+        ];
+        for sql in TOUCH {
+            self.conn.query_row(sql, [], |_| Ok(()))?;
+        }
+        Ok(())
+    }
+
+    // ── Diagnostics ──
+
+    /// Profile every statement this connection runs while [`explain`] collection is on.
+    pub fn enable_explain(&self) {
+        self.profile_statements();
+    }
+
+    /// Report the statements this connection runs as [`otel`] spans while export is on.
+    pub fn enable_otel(&self) {
+        self.profile_statements();
+    }
+
+    fn profile_statements(&self) {
+        // SAFETY: the handle is valid for the lifetime of `self.conn`, and the callbacks
+        // only read the statement they are handed.
+        unsafe {
+            ffi::sqlite3_trace_v2(
+                self.conn.handle(),
+                ffi::SQLITE_TRACE_PROFILE as std::os::raw::c_uint,
+                Some(on_profile),
+                std::ptr::null_mut(),
+            );
+        }
+    }
+
+    /// Page cache hits and misses on this connection since it was opened.
+    pub fn cache_stats(&self) -> (i64, i64) {
+        let status = |op: std::os::raw::c_int| {
+            let (mut current, mut highwater) = (0, 0);
+            // SAFETY: the handle is valid for the lifetime of `self.conn`; the call
+            // only writes the two counters.
+            unsafe {
+                ffi::sqlite3_db_status(self.conn.handle(), op, &mut current, &mut highwater, 0);
+            }
+            i64::from(current)
+        };
+        (
+            status(ffi::SQLITE_DBSTATUS_CACHE_HIT as std::os::raw::c_int),
+            status(ffi::SQLITE_DBSTATUS_CACHE_MISS as std::os::raw::c_int),
+        )
+    }
+
+    /// `EXPLAIN QUERY PLAN` detail lines for `sql`. Parameters are left unbound.
+    pub fn query_plan(&self, sql: &str) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare(&format!("EXPLAIN QUERY PLAN {sql}"))?;
+        let mut rows = stmt.raw_query();
+        let mut plan = Vec::new();
+        while let Some(row) = rows.next()? {
+            plan.push(row.get(3)?);
+        }
+        Ok(plan)
+    }
+
     // ── Metadata ──
 
     /// Retrieve a metadata value by key.
@@ -256,31 +888,217 @@ impl Database {
         Ok(())
     }
 
-    // ── Files ──
+    // ── Branch states ──
 
-    /// Insert or update file metadata.
-    pub fn upsert_file(&self, file: &FileInfo) -> Result<()> {
-        self.conn.execute(
-            "INSERT OR REPLACE INTO files (path, last_modified, hash, language, num_symbols)
-             VALUES (?1, ?2, ?3, ?4, ?5)",
-            params![
-                file.path,
-                file.last_modified,
-                file.hash,
-                file.language,
-                file.num_symbols,
-            ],
+    /// Record the live index as the state of `branch`: its commit, uncommitted
+    /// files and the hash of every file. Only the `keep` most recently indexed
+    /// branches are kept, and parse results no kept state refers to are dropped
+    /// from the parse cache.
+    pub fn save_branch_state(
+        &self,
+        branch: &str,
+        commit: &str,
+        dirty_files: &[String],
+        indexed_at: u64,
+        keep: usize,
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            self.conn.execute(
+                "INSERT OR REPLACE INTO branch_states (branch, commit_sha, dirty_files, indexed_at)
+                 VALUES (?1, ?2, ?3, ?4)",
+                params![branch, commit, dirty_files.join("\n"), indexed_at as i64],
+            )?;
+            self.conn.execute(
+                "DELETE FROM branch_files WHERE branch = ?1",
+                params![branch],
+            )?;
+            self.conn.execute(
+                "INSERT INTO branch_files (branch, path, hash) SELECT ?1, path, hash FROM files",
+                params![branch],
+            )?;
+            self.conn.execute(
+                "DELETE FROM branch_states WHERE branch NOT IN
+                 (SELECT branch FROM branch_states ORDER BY indexed_at DESC, branch LIMIT ?1)",
+                params![keep as i64],
+            )?;
+            self.conn.execute(
+                "DELETE FROM branch_files WHERE branch NOT IN (SELECT branch FROM branch_states)",
+                [],
+            )?;
+            self.conn.execute(
+                "DELETE FROM parse_cache WHERE NOT EXISTS
+                 (SELECT 1 FROM branch_files b
+                  WHERE b.path = parse_cache.path AND b.hash = parse_cache.hash)",
+                [],
+            )?;
+            Ok(())
+        })
+    }
+
+    /// Saved branch states, most recently indexed first.
+    pub fn branch_states(&self) -> Result<Vec<BranchState>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.branch, s.commit_sha, s.indexed_at,
+                    (SELECT COUNT(DISTINCT c.path) FROM branch_files b
+                     JOIN parse_cache c ON c.path = b.path AND c.hash = b.hash
+                     WHERE b.branch = s.branch)
+             FROM branch_states s
+             ORDER BY s.indexed_at DESC, s.branch",
         )?;
-        Ok(())
+        let rows = stmt
+            .query_map([], |row| {
+                Ok(BranchState {
+                    branch: row.get(0)?,
+                    commit: row.get(1)?,
+                    indexed_at: row.get::<_, i64>(2)? as u64,
+                    cached_files: row.get(3)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
     }
 
-    /// Look up stored metadata for a file.
-    pub fn get_file(&self, path: &str) -> Result<Option<FileInfo>> {
+    /// Keys of the parse cache: `(path, hash, settings)`.
+    pub fn parse_cache_keys(&self) -> Result<std::collections::HashSet<(String, String, String)>> {
+        let mut stmt = self
+            .conn
+            .prepare("SELECT path, hash, settings FROM parse_cache")?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?, row.get(2)?)))?
+            .collect::<std::result::Result<_, _>>()?;
+        Ok(rows)
+    }
+
+    /// The cached parse result of `path` at content `hash`, extracted with `settings`.
+    pub fn cached_parse(&self, path: &str, hash: &str, settings: &str) -> Result<Option<String>> {
         self.conn
             .query_row(
-                "SELECT path, last_modified, hash, language, num_symbols FROM files WHERE path = ?1",
-                params![path],
-                |row| {
+                "SELECT parsed FROM parse_cache WHERE path = ?1 AND hash = ?2 AND settings = ?3",
+                params![path, hash, settings],
+                |row| row.get(0),
+            )
+            .optional()
+            .context("Failed to query parse cache")
+    }
+
+    /// Keep the parse result of `path` at content `hash`, for when a branch
+    /// switch brings that content back.
+    pub fn cache_parse(&self, path: &str, hash: &str, settings: &str, parsed: &str) -> Result<()> {
+        self.conn.execute(
+            "INSERT OR REPLACE INTO parse_cache (path, hash, settings, parsed)
+             VALUES (?1, ?2, ?3, ?4)",
+            params![path, hash, settings, parsed],
+        )?;
+        Ok(())
+    }
+
+    // ── Transactions & Bulk Load ──
+
+    /// Open a write batch: subsequent inserts share one transaction until
+    /// [`commit_batch`](Self::commit_batch). No-op if a batch is already open.
+    pub fn begin_batch(&self) -> Result<()> {
+        if self.conn.is_autocommit() {
+            self.conn.execute_batch("BEGIN")?;
+        }
+        Ok(())
+    }
+
+    /// Commit the open write batch, if any.
+    pub fn commit_batch(&self) -> Result<()> {
+        if !self.conn.is_autocommit() {
+            self.conn.execute_batch("COMMIT")?;
+        }
+        Ok(())
+    }
+
+    /// Discard the open write batch, if any.
+    pub fn rollback_batch(&self) -> Result<()> {
+        if !self.conn.is_autocommit() {
+            self.conn.execute_batch("ROLLBACK")?;
+        }
+        Ok(())
+    }
+
+    /// Drop secondary graph indexes before loading many rows into an empty index.
+    ///
+    /// Lookups by file or name become full scans until [`end_bulk_load`](Self::end_bulk_load)
+    /// rebuilds them, so only use this when nothing needs to be read back meanwhile.
+    pub fn begin_bulk_load(&self) -> Result<()> {
+        // Until the indexes are back, the next open must not take the no-DDL fast path:
+        // an interrupted load would otherwise leave them missing for good.
+        set_schema_version(&self.conn, 0)?;
+        self.conn.execute_batch(DROP_GRAPH_INDEXES)?;
+        Ok(())
+    }
+
+    /// Rebuild the secondary graph indexes dropped by [`begin_bulk_load`](Self::begin_bulk_load).
+    pub fn end_bulk_load(&self) -> Result<()> {
+        self.conn.execute_batch(GRAPH_INDEXES)?;
+        set_schema_version(&self.conn, SCHEMA_VERSION)?;
+        Ok(())
+    }
+
+    /// Schema version recorded in the database header (`PRAGMA user_version`).
+    pub fn schema_version(&self) -> Result<i64> {
+        Ok(self
+            .conn
+            .query_row("PRAGMA user_version", [], |row| row.get(0))?)
+    }
+
+    /// Run `f` in its own transaction, or inside the caller's open batch.
+    fn in_transaction<T>(&self, f: impl FnOnce() -> Result<T>) -> Result<T> {
+        if !self.conn.is_autocommit() {
+            return f();
+        }
+        let tx = self.conn.unchecked_transaction()?;
+        let out = f()?;
+        tx.commit()?;
+        Ok(out)
+    }
+
+    // ── Files ──
+
+    /// Insert or update file metadata.
+    pub fn upsert_file(&self, file: &FileInfo) -> Result<()> {
+        self.conn.execute(
+            "INSERT OR REPLACE INTO files (path, last_modified, hash, language, num_symbols)
+             VALUES (?1, ?2, ?3, ?4, ?5)",
+            params![
+                file.path,
+                file.last_modified,
+                file.hash,
+                file.language,
+                file.num_symbols,
+            ],
+        )?;
+        Ok(())
+    }
+
+    /// Content hash of every indexed file, keyed by path.
+    pub fn file_hashes(&self) -> Result<std::collections::HashMap<String, String>> {
+        let mut stmt = self.conn.prepare("SELECT path, hash FROM files")?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<_, _>>()?;
+        Ok(rows)
+    }
+
+    /// Modification time each indexed file had when it was indexed, keyed by path.
+    pub fn file_mtimes(&self) -> Result<std::collections::HashMap<String, f64>> {
+        let mut stmt = self.conn.prepare("SELECT path, last_modified FROM files")?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<_, _>>()?;
+        Ok(rows)
+    }
+
+    /// Look up stored metadata for a file.
+    pub fn get_file(&self, path: &str) -> Result<Option<FileInfo>> {
+        self.conn
+            .query_row(
+                "SELECT path, last_modified, hash, language, num_symbols FROM files WHERE path = ?1",
+                params![path],
+                |row| {
                     Ok(FileInfo {
                         path: row.get(0)?,
                         last_modified: row.get(1)?,
@@ -294,9 +1112,111 @@ impl Database {
             .context("Failed to query file")
     }
 
-    /// Remove all symbols, edges, and RAG data for a file (before re-indexing it).
+    /// Remove all symbols, edges, tags, metrics, coverage, ranks, betweenness, fingerprints, error flows, panic, sync
+    /// and context sites, globals and variable accesses, routes, config fields, field
+    /// uses, struct tags and serializations, constants, type assertions, test doubles,
+    /// construction sites, syntax errors, log statements and TODOs, and RAG data for a file
+    /// (before re-indexing it).
     pub fn clear_file_data(&self, path: &str) -> Result<()> {
         self.clear_rag_data_for_file(path)?;
+        self.conn.execute(
+            "DELETE FROM symbol_tags WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_metrics WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_coverage WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_rank WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_betweenness WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_fingerprints WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM symbol_hashes WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM fallible_symbols WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM error_flows WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM panic_sites WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn
+            .execute("DELETE FROM sync_sites WHERE file_path = ?1", params![path])?;
+        self.conn.execute(
+            "DELETE FROM context_symbols WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM context_sites WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM global_vars WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM variable_accesses WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn
+            .execute("DELETE FROM routes WHERE file_path = ?1", params![path])?;
+        self.conn.execute(
+            "DELETE FROM config_fields WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn
+            .execute("DELETE FROM field_uses WHERE file_path = ?1", params![path])?;
+        self.conn.execute(
+            "DELETE FROM struct_fields WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM serializations WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn
+            .execute("DELETE FROM constants WHERE file_path = ?1", params![path])?;
+        self.conn.execute(
+            "DELETE FROM type_assertions WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM test_doubles WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM constructions WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM syntax_errors WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn.execute(
+            "DELETE FROM log_statements WHERE file_path = ?1",
+            params![path],
+        )?;
+        self.conn
+            .execute("DELETE FROM todos WHERE file_path = ?1", params![path])?;
         self.conn
             .execute("DELETE FROM edges WHERE file_path = ?1", params![path])?;
         self.conn
@@ -337,72 +1257,1584 @@ impl Database {
         Ok(())
     }
 
-    /// Insert or replace multiple symbols in a single transaction.
-    pub fn insert_symbols(&self, symbols: &[Symbol]) -> Result<()> {
-        let tx = self.conn.unchecked_transaction()?;
-        let mut stmt = self.conn.prepare_cached(SQL_INSERT_SYMBOL)?;
-        for sym in symbols {
-            stmt.execute(params![
-                sym.id,
-                sym.name,
-                sym.kind.as_str(),
-                sym.file_path,
-                sym.start_line,
-                sym.end_line,
-                sym.start_byte,
-                sym.end_byte,
-                sym.parent_id,
-                sym.signature,
-                sym.visibility.as_str(),
-                sym.is_async,
-                sym.docstring,
-            ])?;
-        }
-        tx.commit()?;
-        Ok(())
-    }
+    /// Insert or replace multiple symbols in a single transaction.
+    pub fn insert_symbols(&self, symbols: &[Symbol]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(SQL_INSERT_SYMBOL)?;
+            for sym in symbols {
+                stmt.execute(params![
+                    sym.id,
+                    sym.name,
+                    sym.kind.as_str(),
+                    sym.file_path,
+                    sym.start_line,
+                    sym.end_line,
+                    sym.start_byte,
+                    sym.end_byte,
+                    sym.parent_id,
+                    sym.signature,
+                    sym.visibility.as_str(),
+                    sym.is_async,
+                    sym.docstring,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    // ── Edges ──
+
+    /// Insert a single edge.
+    #[cfg_attr(not(test), allow(dead_code))]
+    pub fn insert_edge(&self, edge: &Edge) -> Result<()> {
+        self.conn.prepare_cached(SQL_INSERT_EDGE)?.execute(params![
+            edge.source_id,
+            edge.target_name,
+            edge.target_id,
+            edge.kind.as_str(),
+            edge.file_path,
+            edge.line,
+        ])?;
+        Ok(())
+    }
+
+    /// Insert multiple edges in a single transaction.
+    pub fn insert_edges(&self, edges: &[Edge]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(SQL_INSERT_EDGE)?;
+            for edge in edges {
+                stmt.execute(params![
+                    edge.source_id,
+                    edge.target_name,
+                    edge.target_id,
+                    edge.kind.as_str(),
+                    edge.file_path,
+                    edge.line,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    // ── Tags ──
+
+    /// Record `(symbol_id, tag)` pairs for the symbols of `file_path`.
+    pub fn insert_symbol_tags(&self, file_path: &str, tags: &[(&str, String)]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR IGNORE INTO symbol_tags (tag, symbol_id, file_path) VALUES (?1, ?2, ?3)",
+            )?;
+            for (symbol_id, tag) in tags {
+                stmt.execute(params![tag, symbol_id, file_path])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every tag in the index with the number of symbols carrying it, by tag.
+    pub fn tag_counts(&self) -> Result<Vec<(String, u32)>> {
+        let mut stmt = self
+            .conn
+            .prepare("SELECT tag, COUNT(*) FROM symbol_tags GROUP BY tag ORDER BY tag")?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Symbols carrying `tag`, ordered by file and line.
+    pub fn tagged_symbols(&self, tag: &str) -> Result<Vec<Symbol>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring
+             FROM symbol_tags t
+             JOIN symbols s ON s.id = t.symbol_id
+             WHERE t.tag = ?1
+             ORDER BY s.file_path, s.start_line",
+        )?;
+        let rows = stmt
+            .query_map(params![tag], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Tags of a symbol, sorted.
+    pub fn symbol_tags(&self, symbol_id: &str) -> Result<Vec<String>> {
+        let mut stmt = self
+            .conn
+            .prepare_cached("SELECT tag FROM symbol_tags WHERE symbol_id = ?1 ORDER BY tag")?;
+        let rows = stmt
+            .query_map(params![symbol_id], |row| row.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// A filter keeping only symbols tagged `tag`; `None` keeps everything.
+    pub fn tag_filter(&self, tag: Option<&str>) -> Result<TagFilter> {
+        let Some(tag) = tag else {
+            return Ok(TagFilter(None));
+        };
+        let mut stmt = self
+            .conn
+            .prepare_cached("SELECT symbol_id FROM symbol_tags WHERE tag = ?1")?;
+        let ids = stmt
+            .query_map(params![tag], |row| row.get(0))?
+            .collect::<std::result::Result<HashSet<String>, _>>()?;
+        Ok(TagFilter(Some(ids)))
+    }
+
+    // ── Metrics ──
+
+    /// Record the complexity of functions and methods in `file_path`.
+    pub fn insert_complexity(&self, file_path: &str, items: &[(String, Complexity)]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_metrics (symbol_id, file_path, cyclomatic, cognitive)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for (symbol_id, c) in items {
+                stmt.execute(params![symbol_id, file_path, c.cyclomatic, c.cognitive])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// The `limit` most complex functions and methods by `metric`, most complex first.
+    /// Ties are broken by the other metric.
+    pub fn most_complex(
+        &self,
+        metric: ComplexityMetric,
+        file_filter: Option<&str>,
+        limit: u32,
+    ) -> Result<Vec<(Symbol, Complexity)>> {
+        let order = match metric {
+            ComplexityMetric::Cyclomatic => "m.cyclomatic DESC, m.cognitive DESC",
+            ComplexityMetric::Cognitive => "m.cognitive DESC, m.cyclomatic DESC",
+        };
+        let mut stmt = self.conn.prepare(&format!(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, m.cyclomatic, m.cognitive
+             FROM symbol_metrics m
+             JOIN symbols s ON s.id = m.symbol_id
+             WHERE (?1 IS NULL OR m.file_path = ?1)
+             ORDER BY {order}, s.file_path, s.start_line
+             LIMIT ?2"
+        ))?;
+        let rows = stmt
+            .query_map(params![file_filter, limit], |row| {
+                Ok((
+                    row_to_symbol(row)?,
+                    Complexity {
+                        cyclomatic: row.get(13)?,
+                        cognitive: row.get(14)?,
+                    },
+                ))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Replace all coverage with `items`: `(symbol_id, file_path, coverage)`. Each
+    /// profile describes one test run, so nothing from an earlier one is kept.
+    pub fn replace_coverage(&self, items: &[(String, String, Coverage)]) -> Result<()> {
+        self.in_transaction(|| {
+            self.conn.execute("DELETE FROM symbol_coverage", [])?;
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_coverage (symbol_id, file_path, covered, total)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for (symbol_id, file_path, c) in items {
+                stmt.execute(params![symbol_id, file_path, c.covered, c.total])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Coverage of whichever of `ids` have some, by symbol id.
+    pub fn coverage_of(
+        &self,
+        ids: &[String],
+    ) -> Result<std::collections::HashMap<String, Coverage>> {
+        let mut stmt = self
+            .conn
+            .prepare_cached("SELECT covered, total FROM symbol_coverage WHERE symbol_id = ?1")?;
+        let mut found = std::collections::HashMap::new();
+        for id in ids {
+            let coverage = stmt
+                .query_row(params![id], |row| {
+                    Ok(Coverage {
+                        covered: row.get(0)?,
+                        total: row.get(1)?,
+                    })
+                })
+                .optional()?;
+            if let Some(coverage) = coverage {
+                found.insert(id.clone(), coverage);
+            }
+        }
+        Ok(found)
+    }
+
+    /// Replace all ranks with `items`: `(symbol_id, file_path, pagerank)`. Ranks are
+    /// computed over the whole graph, so none from an earlier run is kept.
+    pub fn replace_ranks(&self, items: &[(String, String, f64)]) -> Result<()> {
+        self.in_transaction(|| {
+            self.conn.execute("DELETE FROM symbol_rank", [])?;
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_rank (symbol_id, file_path, pagerank)
+                 VALUES (?1, ?2, ?3)",
+            )?;
+            for (symbol_id, file_path, pagerank) in items {
+                stmt.execute(params![symbol_id, file_path, pagerank])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Replace all betweenness scores with `items`: `(symbol_id, file_path, betweenness)`.
+    /// Symbols left out score 0.
+    pub fn replace_betweenness(&self, items: &[(String, String, f64)]) -> Result<()> {
+        self.in_transaction(|| {
+            self.conn.execute("DELETE FROM symbol_betweenness", [])?;
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_betweenness (symbol_id, file_path, betweenness)
+                 VALUES (?1, ?2, ?3)",
+            )?;
+            for (symbol_id, file_path, betweenness) in items {
+                stmt.execute(params![symbol_id, file_path, betweenness])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// PageRank of every ranked symbol, by symbol id.
+    pub fn ranks(&self) -> Result<std::collections::HashMap<String, f64>> {
+        let mut stmt = self
+            .conn
+            .prepare_cached("SELECT symbol_id, pagerank FROM symbol_rank")?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<_, _>>()?;
+        Ok(rows)
+    }
+
+    /// The `limit` ranked symbols highest by `metric`, of `kind` when given, highest first.
+    /// Ties are broken by PageRank.
+    pub fn top_ranked(
+        &self,
+        kind: Option<SymbolKind>,
+        metric: RankMetric,
+        limit: u32,
+    ) -> Result<Vec<(Symbol, Centrality)>> {
+        let order = match metric {
+            RankMetric::PageRank => "r.pagerank DESC",
+            RankMetric::Betweenness => "COALESCE(b.betweenness, 0) DESC, r.pagerank DESC",
+        };
+        let mut stmt = self.conn.prepare(&format!(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, r.pagerank, COALESCE(b.betweenness, 0)
+             FROM symbol_rank r
+             JOIN symbols s ON s.id = r.symbol_id
+             LEFT JOIN symbol_betweenness b ON b.symbol_id = r.symbol_id
+             WHERE (?1 IS NULL OR s.kind = ?1)
+             ORDER BY {order}, s.file_path, s.start_line
+             LIMIT ?2"
+        ))?;
+        let rows = stmt
+            .query_map(params![kind.map(|k| k.as_str()), limit], |row| {
+                Ok((
+                    row_to_symbol(row)?,
+                    Centrality {
+                        pagerank: row.get(13)?,
+                        betweenness: row.get(14)?,
+                    },
+                ))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Record the clone-detection fingerprints of functions and methods in `file_path`.
+    pub fn insert_fingerprints(
+        &self,
+        file_path: &str,
+        items: &[(String, Fingerprint)],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_fingerprints (symbol_id, file_path, hash, minhash)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for (symbol_id, fp) in items {
+                let minhash: Vec<u8> = fp.minhash.iter().flat_map(|s| s.to_le_bytes()).collect();
+                // SQLite integers are signed; the bits round-trip.
+                stmt.execute(params![symbol_id, file_path, fp.hash as i64, minhash])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Store the source hash of each of a file's symbols, replacing earlier ones.
+    pub fn insert_symbol_hashes(&self, file_path: &str, items: &[(String, String)]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_hashes (symbol_id, file_path, hash)
+                 VALUES (?1, ?2, ?3)",
+            )?;
+            for (symbol_id, hash) in items {
+                stmt.execute(params![symbol_id, file_path, hash])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Source hashes of a file's symbols, by symbol ID.
+    pub fn symbol_hashes(
+        &self,
+        file_path: &str,
+    ) -> Result<std::collections::HashMap<String, String>> {
+        let mut stmt = self
+            .conn
+            .prepare_cached("SELECT symbol_id, hash FROM symbol_hashes WHERE file_path = ?1")?;
+        let rows = stmt
+            .query_map(params![file_path], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<std::collections::HashMap<_, _>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Fingerprinted symbols spanning at least `min_lines` lines, ordered by file and line.
+    pub fn fingerprints(&self, min_lines: u32) -> Result<Vec<(Symbol, Fingerprint)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, f.hash, f.minhash
+             FROM symbol_fingerprints f
+             JOIN symbols s ON s.id = f.symbol_id
+             WHERE s.end_line - s.start_line + 1 >= ?1
+             ORDER BY s.file_path, s.start_line",
+        )?;
+        let rows = stmt
+            .query_map(params![min_lines], |row| {
+                let minhash: Vec<u8> = row.get(14)?;
+                Ok((
+                    row_to_symbol(row)?,
+                    Fingerprint {
+                        hash: row.get::<_, i64>(13)? as u64,
+                        minhash: minhash
+                            .chunks_exact(4)
+                            .map(|b| u32::from_le_bytes([b[0], b[1], b[2], b[3]]))
+                            .collect(),
+                    },
+                ))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Resolved edges pointing at `symbol_id`.
+    pub fn reference_count(&self, symbol_id: &str) -> Result<u32> {
+        Ok(self.conn.query_row(
+            "SELECT COUNT(*) FROM edges WHERE target_id = ?1",
+            params![symbol_id],
+            |row| row.get(0),
+        )?)
+    }
+
+    // ── Error Flows and Panics ──
+
+    /// Record which functions in `file_path` produce errors and how each handles
+    /// errors from its calls.
+    pub fn insert_error_flows(
+        &self,
+        file_path: &str,
+        fallible: &[String],
+        flows: &[ErrorFlow],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO fallible_symbols (symbol_id, file_path) VALUES (?1, ?2)",
+            )?;
+            for symbol_id in fallible {
+                stmt.execute(params![symbol_id, file_path])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO error_flows (source_id, target_name, line, file_path, handling)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for flow in flows {
+                stmt.execute(params![
+                    flow.source_id,
+                    flow.target_name,
+                    flow.line,
+                    file_path,
+                    flow.handling.as_str(),
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Whether `symbol_id` returns or raises errors of its own.
+    pub fn is_fallible(&self, symbol_id: &str) -> Result<bool> {
+        Ok(self
+            .conn
+            .prepare_cached("SELECT 1 FROM fallible_symbols WHERE symbol_id = ?1")?
+            .exists(params![symbol_id])?)
+    }
+
+    /// Callers of `symbol_id` with the line of each call and how the caller handles
+    /// errors from it (`None` when the call could not be classified).
+    pub fn error_callers(
+        &self,
+        symbol_id: &str,
+    ) -> Result<Vec<(Symbol, u32, Option<ErrorHandling>)>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, e.line, f.handling
+             FROM edges e
+             JOIN symbols s ON s.id = e.source_id
+             LEFT JOIN error_flows f
+               ON f.source_id = e.source_id AND f.line = e.line AND f.target_name = e.target_name
+             WHERE e.target_id = ?1 AND e.kind = 'calls'
+             ORDER BY s.file_path, e.line",
+        )?;
+        let rows = stmt
+            .query_map(params![symbol_id], |row| {
+                let handling: Option<String> = row.get(14)?;
+                Ok((
+                    row_to_symbol(row)?,
+                    row.get(13)?,
+                    handling.and_then(|h| h.parse().ok()),
+                ))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Record the panic and recover sites in `file_path`.
+    pub fn insert_panic_sites(&self, file_path: &str, sites: &[PanicSite]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO panic_sites (symbol_id, line, file_path, kind, what)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for site in sites {
+                stmt.execute(params![
+                    site.symbol_id,
+                    site.line,
+                    file_path,
+                    site.kind.as_str(),
+                    site.what,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every panic and recover site, ordered by file and line.
+    pub fn panic_sites(&self) -> Result<Vec<PanicSite>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT symbol_id, line, kind, what FROM panic_sites ORDER BY file_path, line",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                let kind: String = row.get(2)?;
+                Ok((row.get(0)?, row.get(1)?, kind, row.get(3)?))
+            })?
+            .collect::<std::result::Result<Vec<(String, u32, String, String)>, _>>()?;
+        Ok(rows
+            .into_iter()
+            .filter_map(|(symbol_id, line, kind, what)| {
+                Some(PanicSite {
+                    symbol_id,
+                    line,
+                    kind: kind.parse().ok()?,
+                    what,
+                })
+            })
+            .collect())
+    }
+
+    // ── Serialization ──
+
+    /// Record the tagged struct fields and the serialization calls in `file_path`.
+    pub fn insert_serializations(
+        &self,
+        file_path: &str,
+        fields: &[StructField],
+        serializations: &[Serialization],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO struct_fields (symbol_id, name, file_path, line, tags)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for field in fields {
+                stmt.execute(params![
+                    field.symbol_id,
+                    field.name,
+                    file_path,
+                    field.line,
+                    serde_json::to_string(&field.tags)?,
+                ])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO serializations
+                 (symbol_id, line, file_path, type_name, format, decode)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6)",
+            )?;
+            for s in serializations {
+                stmt.execute(params![
+                    s.symbol_id,
+                    s.line,
+                    file_path,
+                    s.type_name,
+                    s.format,
+                    s.decode,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// The field `field` of every struct named `type_name`, with the struct.
+    pub fn struct_field(&self, type_name: &str, field: &str) -> Result<Vec<(Symbol, StructField)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, f.line, f.tags
+             FROM struct_fields f
+             JOIN symbols s ON s.id = f.symbol_id
+             WHERE s.name = ?1 AND f.name = ?2
+             ORDER BY s.file_path, f.line",
+        )?;
+        let rows = stmt
+            .query_map(params![type_name, field], |row| {
+                let symbol = row_to_symbol(row)?;
+                let tags: String = row.get(14)?;
+                let field = StructField {
+                    symbol_id: symbol.id.clone(),
+                    name: field.to_string(),
+                    line: row.get(13)?,
+                    tags: serde_json::from_str(&tags).unwrap_or_default(),
+                };
+                Ok((symbol, field))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Calls encoding or decoding a value of the type `type_name`, with the function
+    /// making each, by file and line.
+    pub fn serializations_of(&self, type_name: &str) -> Result<Vec<(Symbol, Serialization)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, z.line, z.format, z.decode
+             FROM serializations z
+             JOIN symbols s ON s.id = z.symbol_id
+             WHERE z.type_name = ?1
+             ORDER BY z.file_path, z.line",
+        )?;
+        let rows = stmt
+            .query_map(params![type_name], |row| {
+                let symbol = row_to_symbol(row)?;
+                let serialization = Serialization {
+                    symbol_id: symbol.id.clone(),
+                    line: row.get(13)?,
+                    type_name: type_name.to_string(),
+                    format: row.get(14)?,
+                    decode: row.get(15)?,
+                };
+                Ok((symbol, serialization))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Constants ──
+
+    /// Record the constants declared in `file_path` with their values.
+    pub fn insert_constants(&self, file_path: &str, constants: &[Constant]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO constants
+                 (symbol_id, file_path, line, type_name, value, expr, iota)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+            )?;
+            for c in constants {
+                stmt.execute(params![
+                    c.symbol_id,
+                    file_path,
+                    c.line,
+                    c.type_name,
+                    c.value,
+                    c.expr,
+                    c.iota,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Constants of the type `type_name`, by file and line. A qualified name
+    /// (`models.PaymentStatus`) matches on its last segment, as well as whole.
+    pub fn constants_of_type(&self, type_name: &str) -> Result<Vec<(Symbol, Constant)>> {
+        let short = type_name.rsplit('.').next().unwrap_or(type_name);
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, c.line, c.type_name, c.value, c.expr, c.iota
+             FROM constants c
+             JOIN symbols s ON s.id = c.symbol_id
+             WHERE c.type_name IN (?1, ?2)
+             ORDER BY c.file_path, c.line, s.start_byte",
+        )?;
+        let rows = stmt
+            .query_map(params![type_name, short], |row| {
+                let symbol = row_to_symbol(row)?;
+                let constant = Constant {
+                    symbol_id: symbol.id.clone(),
+                    line: row.get(13)?,
+                    type_name: row.get(14)?,
+                    value: row.get(15)?,
+                    expr: row.get(16)?,
+                    iota: row.get(17)?,
+                };
+                Ok((symbol, constant))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Type assertions ──
+
+    /// Record the type assertions and type switch cases in `file_path`.
+    pub fn insert_type_assertions(
+        &self,
+        file_path: &str,
+        assertions: &[TypeAssertion],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO type_assertions
+                 (symbol_id, file_path, line, interface, type_name, type_switch)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6)",
+            )?;
+            for a in assertions {
+                stmt.execute(params![
+                    a.symbol_id,
+                    file_path,
+                    a.line,
+                    a.interface,
+                    a.type_name,
+                    a.type_switch,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Assertions to the type `name`, or from the interface `name`, with the
+    /// function making each, by file and line.
+    pub fn type_assertions(&self, name: &str) -> Result<Vec<(Symbol, TypeAssertion)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, a.line, a.interface, a.type_name, a.type_switch
+             FROM type_assertions a
+             JOIN symbols s ON s.id = a.symbol_id
+             WHERE a.type_name = ?1 OR a.interface = ?1
+             ORDER BY a.file_path, a.line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                let symbol = row_to_symbol(row)?;
+                let assertion = TypeAssertion {
+                    symbol_id: symbol.id.clone(),
+                    line: row.get(13)?,
+                    interface: row.get(14)?,
+                    type_name: row.get(15)?,
+                    type_switch: row.get(16)?,
+                };
+                Ok((symbol, assertion))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Test doubles ──
+
+    /// Record the generated mocks and fakes in `file_path`.
+    pub fn insert_test_doubles(&self, file_path: &str, doubles: &[TestDouble]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO test_doubles (symbol_id, file_path, interface, generator)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for d in doubles {
+                stmt.execute(params![d.symbol_id, file_path, d.interface, d.generator])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Test doubles of the interface `name`, or named `name`, with their struct.
+    pub fn test_doubles(&self, name: &str) -> Result<Vec<(Symbol, TestDouble)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, d.interface, d.generator
+             FROM test_doubles d
+             JOIN symbols s ON s.id = d.symbol_id
+             WHERE d.interface = ?1 OR s.name = ?1
+             ORDER BY d.file_path, s.start_line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                let symbol = row_to_symbol(row)?;
+                let double = TestDouble {
+                    symbol_id: symbol.id.clone(),
+                    interface: row.get(13)?,
+                    generator: row.get(14)?,
+                };
+                Ok((symbol, double))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Constructions ──
+
+    /// Record the composite literals and `new` calls in `file_path`.
+    pub fn insert_constructions(
+        &self,
+        file_path: &str,
+        constructions: &[Construction],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO constructions (symbol_id, file_path, line, type_name, kind)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for c in constructions {
+                stmt.execute(params![
+                    c.symbol_id,
+                    file_path,
+                    c.line,
+                    c.type_name,
+                    c.kind.as_str(),
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Where values of the type `name` are created, with the function or variable
+    /// creating each, by file and line.
+    pub fn constructions(&self, name: &str) -> Result<Vec<(Symbol, Construction)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, c.line, c.type_name, c.kind
+             FROM constructions c
+             JOIN symbols s ON s.id = c.symbol_id
+             WHERE c.type_name = ?1
+             ORDER BY c.file_path, c.line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                let kind: String = row.get(15)?;
+                Ok((row_to_symbol(row)?, row.get(13)?, row.get(14)?, kind))
+            })?
+            .collect::<std::result::Result<Vec<(Symbol, u32, String, String)>, _>>()?;
+        Ok(rows
+            .into_iter()
+            .filter_map(|(symbol, line, type_name, kind)| {
+                let construction = Construction {
+                    symbol_id: symbol.id.clone(),
+                    line,
+                    type_name,
+                    kind: kind.parse().ok()?,
+                };
+                Some((symbol, construction))
+            })
+            .collect())
+    }
+
+    // ── Syntax errors ──
+
+    /// Record the syntax errors the parser recovered from in `file_path`.
+    pub fn insert_syntax_errors(&self, file_path: &str, errors: &[SyntaxError]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT INTO syntax_errors (file_path, line, message) VALUES (?1, ?2, ?3)",
+            )?;
+            for e in errors {
+                stmt.execute(params![file_path, e.line, e.message])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Syntax errors in `file_path`, in source order; empty for a clean file.
+    pub fn syntax_errors(&self, file_path: &str) -> Result<Vec<SyntaxError>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT line, message FROM syntax_errors WHERE file_path = ?1 ORDER BY rowid",
+        )?;
+        let rows = stmt
+            .query_map(params![file_path], |row| {
+                Ok(SyntaxError {
+                    line: row.get(0)?,
+                    message: row.get(1)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Files indexed despite syntax errors, by path, with their first error.
+    pub fn degraded_files(&self) -> Result<Vec<DegradedFile>> {
+        // SQLite returns the bare columns of the row that MIN() picked.
+        let mut stmt = self.conn.prepare(
+            "SELECT file_path, COUNT(*), line, message, MIN(rowid) FROM syntax_errors
+             GROUP BY file_path ORDER BY file_path",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                Ok(DegradedFile {
+                    path: row.get(0)?,
+                    errors: row.get(1)?,
+                    first: SyntaxError {
+                        line: row.get(2)?,
+                        message: row.get(3)?,
+                    },
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Snapshots ──
+
+    /// Store the package graph under `tag`, replacing a snapshot of the same tag.
+    /// Snapshots are not tied to files, so re-indexing keeps them.
+    pub fn save_snapshot(
+        &self,
+        tag: &str,
+        created_at: i64,
+        commit: Option<&str>,
+        packages: &[Package],
+        dependencies: &[Dependency],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            self.conn
+                .execute("DELETE FROM snapshot_packages WHERE tag = ?1", params![tag])?;
+            self.conn
+                .execute("DELETE FROM snapshot_deps WHERE tag = ?1", params![tag])?;
+            self.conn.execute(
+                "INSERT OR REPLACE INTO snapshots (tag, created_at, commit_sha)
+                 VALUES (?1, ?2, ?3)",
+                params![tag, created_at, commit],
+            )?;
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT INTO snapshot_packages (tag, package, files, symbols)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for p in packages {
+                stmt.execute(params![tag, p.path, p.files, p.symbols])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT INTO snapshot_deps (tag, from_package, to_package, edges)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for d in dependencies {
+                stmt.execute(params![tag, d.from, d.to, d.edges])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every snapshot, oldest first.
+    pub fn snapshots(&self) -> Result<Vec<Snapshot>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.tag, s.created_at, s.commit_sha,
+                    (SELECT COUNT(*) FROM snapshot_packages p WHERE p.tag = s.tag),
+                    (SELECT COUNT(*) FROM snapshot_deps d WHERE d.tag = s.tag)
+             FROM snapshots s
+             ORDER BY s.created_at, s.tag",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                Ok(Snapshot {
+                    tag: row.get(0)?,
+                    created_at: row.get(1)?,
+                    commit: row.get(2)?,
+                    packages: row.get(3)?,
+                    dependencies: row.get(4)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// The package dependencies stored under `tag`.
+    pub fn snapshot_dependencies(&self, tag: &str) -> Result<Vec<Dependency>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT from_package, to_package, edges FROM snapshot_deps
+             WHERE tag = ?1
+             ORDER BY edges DESC, from_package, to_package",
+        )?;
+        let rows = stmt
+            .query_map(params![tag], |row| {
+                Ok(Dependency {
+                    from: row.get(0)?,
+                    to: row.get(1)?,
+                    edges: row.get(2)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Deprecations ──
+
+    /// Symbols whose doc comment mentions `Deprecated:` (any case; callers check
+    /// the marker), by file and line.
+    pub fn deprecated_symbols(&self) -> Result<Vec<Symbol>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT id, name, kind, file_path, start_line, end_line, start_byte, end_byte,
+                    parent_id, signature, visibility, is_async, docstring
+             FROM symbols
+             WHERE docstring LIKE '%deprecated:%' AND kind != 'import'
+             ORDER BY file_path, start_line",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Resolved edges of any kind pointing at `symbol_id`, with their source
+    /// symbol, by file and line.
+    pub fn references_to(&self, symbol_id: &str) -> Result<Vec<(Edge, Symbol)>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
+                    s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring
+             FROM edges e
+             JOIN symbols s ON s.id = e.source_id
+             WHERE e.target_id = ?1
+             ORDER BY e.file_path, e.line",
+        )?;
+        let rows = stmt
+            .query_map(params![symbol_id], |row| {
+                Ok((row_to_edge(row)?, row_to_symbol_offset(row, 7)?))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Store the deprecation totals at `recorded_at`, replacing a record made at
+    /// the same second. Kept across re-indexing.
+    pub fn record_deprecations(&self, recorded_at: i64, symbols: u32, usages: u32) -> Result<()> {
+        self.conn.execute(
+            "INSERT OR REPLACE INTO deprecation_counts (recorded_at, symbols, usages)
+             VALUES (?1, ?2, ?3)",
+            params![recorded_at, symbols, usages],
+        )?;
+        Ok(())
+    }
+
+    /// Recorded deprecation totals, oldest first.
+    pub fn deprecation_history(&self) -> Result<Vec<BurndownPoint>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT recorded_at, symbols, usages FROM deprecation_counts ORDER BY recorded_at",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                Ok(BurndownPoint {
+                    recorded_at: row.get(0)?,
+                    symbols: row.get(1)?,
+                    usages: row.get(2)?,
+                })
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Logs ──
+
+    /// Record the log statements in `file_path`.
+    pub fn insert_log_statements(
+        &self,
+        file_path: &str,
+        statements: &[LogStatement],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO log_statements
+                 (symbol_id, line, file_path, level, component, template)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6)",
+            )?;
+            for s in statements {
+                stmt.execute(params![
+                    s.symbol_id,
+                    s.line,
+                    file_path,
+                    s.level.as_str(),
+                    s.component,
+                    s.template,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every log statement with the symbol that emits it, ordered by file and line.
+    pub fn log_statements(&self) -> Result<Vec<(Symbol, LogStatement)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, l.line, l.level, l.component, l.template
+             FROM log_statements l
+             JOIN symbols s ON s.id = l.symbol_id
+             ORDER BY l.file_path, l.line",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                let level: String = row.get(14)?;
+                Ok((
+                    row_to_symbol(row)?,
+                    row.get(13)?,
+                    level,
+                    row.get(15)?,
+                    row.get(16)?,
+                ))
+            })?
+            .collect::<std::result::Result<Vec<(Symbol, u32, String, Option<String>, String)>, _>>(
+            )?;
+        Ok(rows
+            .into_iter()
+            .filter_map(|(symbol, line, level, component, template)| {
+                let statement = LogStatement {
+                    symbol_id: symbol.id.clone(),
+                    line,
+                    level: level.parse().ok()?,
+                    component,
+                    template,
+                };
+                Some((symbol, statement))
+            })
+            .collect())
+    }
+
+    /// Callers of `symbol_id` with the line of each call, ordered by file and line.
+    pub fn callers(&self, symbol_id: &str) -> Result<Vec<(Symbol, u32)>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, e.line
+             FROM edges e
+             JOIN symbols s ON s.id = e.source_id
+             WHERE e.target_id = ?1 AND e.kind = 'calls'
+             ORDER BY s.file_path, e.line",
+        )?;
+        let rows = stmt
+            .query_map(params![symbol_id], |row| {
+                Ok((row_to_symbol(row)?, row.get(13)?))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── TODOs ──
+
+    /// Record the TODO, FIXME, HACK and XXX comments in `file_path`.
+    pub fn insert_todos(&self, file_path: &str, todos: &[Todo]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO todos (file_path, line, symbol_id, marker, assignee, text)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6)",
+            )?;
+            for todo in todos {
+                stmt.execute(params![
+                    file_path,
+                    todo.line,
+                    todo.symbol_id,
+                    todo.marker,
+                    todo.assignee,
+                    todo.text,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every marker comment as (file, enclosing symbol, comment), ordered by file
+    /// and line.
+    pub fn todos(&self) -> Result<Vec<(String, Option<Symbol>, Todo)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, t.file_path, t.line, t.symbol_id, t.marker,
+                    t.assignee, t.text
+             FROM todos t
+             LEFT JOIN symbols s ON s.id = t.symbol_id
+             ORDER BY t.file_path, t.line",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                let symbol = match row.get::<_, Option<String>>(0)? {
+                    Some(_) => Some(row_to_symbol(row)?),
+                    None => None,
+                };
+                let todo = Todo {
+                    symbol_id: row.get(15)?,
+                    line: row.get(14)?,
+                    marker: row.get(16)?,
+                    assignee: row.get(17)?,
+                    text: row.get(18)?,
+                };
+                Ok((row.get(13)?, symbol, todo))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Concurrency ──
+
+    /// Record the channel and sync-primitive uses in `file_path`.
+    pub fn insert_sync_sites(&self, file_path: &str, sites: &[SyncSite]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO sync_sites (symbol_id, line, file_path, op, object)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for site in sites {
+                stmt.execute(params![
+                    site.symbol_id,
+                    site.line,
+                    file_path,
+                    site.op.as_str(),
+                    site.object,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every channel and sync primitive used, as written, with how many functions
+    /// use it and how often, most used first.
+    pub fn sync_objects(&self) -> Result<Vec<(String, u32, u32)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT object, COUNT(DISTINCT symbol_id), COUNT(*) FROM sync_sites
+             GROUP BY object
+             ORDER BY COUNT(DISTINCT symbol_id) DESC, object",
+        )?;
+        let rows = stmt
+            .query_map([], |row| Ok((row.get(0)?, row.get(1)?, row.get(2)?)))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Uses of the channel or primitive `name`, with the function making each, ordered
+    /// by object, file and line. `name` matches the object as written or its last
+    /// field: `jobs` finds `jobs` and `p.jobs`.
+    pub fn sync_sites(&self, name: &str) -> Result<Vec<(Symbol, SyncSite)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, u.line, u.op, u.object
+             FROM sync_sites u
+             JOIN symbols s ON s.id = u.symbol_id
+             WHERE u.object = ?1 OR substr(u.object, -length(?1) - 1) = '.' || ?1
+             ORDER BY u.object, u.file_path, u.line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                let op: String = row.get(14)?;
+                Ok((row_to_symbol(row)?, row.get(13)?, op, row.get(15)?))
+            })?
+            .collect::<std::result::Result<Vec<(Symbol, u32, String, String)>, _>>()?;
+        Ok(rows
+            .into_iter()
+            .filter_map(|(symbol, line, op, object)| {
+                let site = SyncSite {
+                    symbol_id: symbol.id.clone(),
+                    line,
+                    op: op.parse().ok()?,
+                    object,
+                };
+                Some((symbol, site))
+            })
+            .collect())
+    }
+
+    /// Record which functions in `file_path` take a context, and the calls that run
+    /// without one.
+    pub fn insert_context_sites(
+        &self,
+        file_path: &str,
+        takes_context: &[String],
+        sites: &[ContextSite],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO context_symbols (symbol_id, file_path) VALUES (?1, ?2)",
+            )?;
+            for symbol_id in takes_context {
+                stmt.execute(params![symbol_id, file_path])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO context_sites (symbol_id, line, file_path, loss, callee)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for site in sites {
+                stmt.execute(params![
+                    site.symbol_id,
+                    site.line,
+                    file_path,
+                    site.loss.as_str(),
+                    site.callee,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Ids of every function that takes a context.
+    pub fn context_symbols(&self) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare("SELECT symbol_id FROM context_symbols")?;
+        let rows = stmt
+            .query_map([], |row| row.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Every call that runs without the caller's context, ordered by file and line.
+    pub fn context_sites(&self) -> Result<Vec<ContextSite>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT symbol_id, line, loss, callee FROM context_sites ORDER BY file_path, line",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                let loss: String = row.get(2)?;
+                Ok((row.get(0)?, row.get(1)?, loss, row.get(3)?))
+            })?
+            .collect::<std::result::Result<Vec<(String, u32, String, String)>, _>>()?;
+        Ok(rows
+            .into_iter()
+            .filter_map(|(symbol_id, line, loss, callee)| {
+                Some(ContextSite {
+                    symbol_id,
+                    line,
+                    loss: loss.parse().ok()?,
+                    callee,
+                })
+            })
+            .collect())
+    }
+
+    // ── Globals ──
+
+    /// Record the package-level variables declared in `file_path` and the variable
+    /// accesses of its functions.
+    pub fn insert_variable_accesses(
+        &self,
+        file_path: &str,
+        globals: &[String],
+        accesses: &[VariableAccess],
+    ) -> Result<()> {
+        let dir = package_dir(file_path);
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO global_vars (symbol_id, file_path, dir) VALUES (?1, ?2, ?3)",
+            )?;
+            for symbol_id in globals {
+                stmt.execute(params![symbol_id, file_path, dir])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO variable_accesses
+                 (symbol_id, line, file_path, dir, name, qualifier, write)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+            )?;
+            for access in accesses {
+                stmt.execute(params![
+                    access.symbol_id,
+                    access.line,
+                    file_path,
+                    dir,
+                    access.name,
+                    access.qualifier,
+                    access.write,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Reads and writes of package-level variables, optionally only those named
+    /// `name`, as (global, accessing function, access). Ordered by global, then file
+    /// and line of the access.
+    ///
+    /// An unqualified access matches a global of that name in the same directory
+    /// (the same Go package); `pkg.Name` one in a directory whose last segment is
+    /// `pkg`.
+    pub fn global_accesses(
+        &self,
+        name: Option<&str>,
+    ) -> Result<Vec<(Symbol, Symbol, VariableAccess)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT g.id, g.name, g.kind, g.file_path, g.start_line, g.end_line,
+                    g.start_byte, g.end_byte, g.parent_id, g.signature, g.visibility,
+                    g.is_async, g.docstring,
+                    s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring,
+                    a.line, a.name, a.qualifier, a.write
+             FROM global_vars gv
+             JOIN symbols g ON g.id = gv.symbol_id
+             JOIN variable_accesses a ON a.name = g.name
+             JOIN symbols s ON s.id = a.symbol_id
+             WHERE (?1 IS NULL OR g.name = ?1)
+               AND CASE WHEN a.qualifier IS NULL THEN a.dir = gv.dir
+                        ELSE gv.dir = a.qualifier
+                             OR substr(gv.dir, -length(a.qualifier) - 1) = '/' || a.qualifier
+                   END
+             ORDER BY g.file_path, g.start_line, a.file_path, a.line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                let accessor = row_to_symbol_offset(row, 13)?;
+                let access = VariableAccess {
+                    symbol_id: accessor.id.clone(),
+                    line: row.get(26)?,
+                    name: row.get(27)?,
+                    qualifier: row.get(28)?,
+                    write: row.get(29)?,
+                };
+                Ok((row_to_symbol(row)?, accessor, access))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Routes ──
+
+    /// Record the HTTP routes registered in `file_path`.
+    pub fn insert_routes(&self, file_path: &str, routes: &[Route]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO routes
+                 (symbol_id, line, file_path, method, path, handler, middleware)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)",
+            )?;
+            for route in routes {
+                stmt.execute(params![
+                    route.symbol_id,
+                    route.line,
+                    file_path,
+                    route.method,
+                    route.path,
+                    route.handler,
+                    serde_json::to_string(&route.middleware)?,
+                ])?;
+            }
+            Ok(())
+        })
+    }
+
+    /// Every route whose path starts with `prefix`, ordered by path and method, as
+    /// (route, registering function, handler). The handler is the symbol the
+    /// registration's reference edge resolved to, if any.
+    pub fn routes(&self, prefix: Option<&str>) -> Result<Vec<(Route, Symbol, Option<Symbol>)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring,
+                    h.id, h.name, h.kind, h.file_path, h.start_line, h.end_line,
+                    h.start_byte, h.end_byte, h.parent_id, h.signature, h.visibility,
+                    h.is_async, h.docstring,
+                    r.line, r.method, r.path, r.handler, r.middleware
+             FROM routes r
+             JOIN symbols s ON s.id = r.symbol_id
+             LEFT JOIN edges e ON e.source_id = r.symbol_id AND e.line = r.line
+                 AND e.kind = 'references' AND e.target_name = r.handler
+             LEFT JOIN symbols h ON h.id = e.target_id
+             WHERE ?1 IS NULL OR substr(r.path, 1, length(?1)) = ?1
+             ORDER BY r.path, r.method, r.file_path, r.line",
+        )?;
+        let rows = stmt
+            .query_map(params![prefix], |row| {
+                let registrar = row_to_symbol(row)?;
+                let handler = match row.get::<_, Option<String>>(13)? {
+                    Some(_) => Some(row_to_symbol_offset(row, 13)?),
+                    None => None,
+                };
+                let middleware: String = row.get(30)?;
+                let route = Route {
+                    symbol_id: registrar.id.clone(),
+                    line: row.get(26)?,
+                    method: row.get(27)?,
+                    path: row.get(28)?,
+                    handler: row.get(29)?,
+                    middleware: serde_json::from_str(&middleware).unwrap_or_default(),
+                };
+                Ok((route, registrar, handler))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Feature Flags ──
+
+    /// Checks of every feature flag, or only `name`, as (flag, checking symbol,
+    /// line). Ordered by flag, file and line.
+    pub fn flag_checks(&self, name: Option<&str>) -> Result<Vec<(String, Symbol, u32)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, f.name, e.line
+             FROM symbols f
+             JOIN edges e ON e.file_path = f.file_path AND e.kind = 'references'
+                 AND e.target_name = f.name
+             JOIN symbols s ON s.id = e.source_id
+             WHERE f.kind = 'flag' AND (?1 IS NULL OR f.name = ?1)
+             ORDER BY f.name, e.file_path, e.line",
+        )?;
+        let rows = stmt
+            .query_map(params![name], |row| {
+                Ok((row.get(13)?, row_to_symbol(row)?, row.get(14)?))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Config Fields ──
 
-    // ── Edges ──
+    /// Record the config struct fields declared in `file_path` and the field accesses
+    /// of its functions.
+    pub fn insert_config_fields(
+        &self,
+        file_path: &str,
+        fields: &[ConfigField],
+        uses: &[FieldUse],
+    ) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO config_fields (symbol_id, name, line, file_path, key)
+                 VALUES (?1, ?2, ?3, ?4, ?5)",
+            )?;
+            for field in fields {
+                stmt.execute(params![
+                    field.symbol_id,
+                    field.name,
+                    field.line,
+                    file_path,
+                    field.key,
+                ])?;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO field_uses (symbol_id, line, file_path, field, receiver, write)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6)",
+            )?;
+            for field_use in uses {
+                stmt.execute(params![
+                    field_use.symbol_id,
+                    field_use.line,
+                    file_path,
+                    field_use.field,
+                    field_use.receiver,
+                    field_use.write,
+                ])?;
+            }
+            Ok(())
+        })
+    }
 
-    /// Insert a single edge.
-    #[cfg_attr(not(test), allow(dead_code))]
-    pub fn insert_edge(&self, edge: &Edge) -> Result<()> {
-        self.conn.prepare_cached(SQL_INSERT_EDGE)?.execute(params![
-            edge.source_id,
-            edge.target_name,
-            edge.target_id,
-            edge.kind.as_str(),
-            edge.file_path,
-            edge.line,
-        ])?;
-        Ok(())
+    /// Config struct fields with their struct, ordered by file and line. `name`
+    /// matches a field (`RedisHost`) or a struct and field (`Config.RedisHost`).
+    pub fn config_fields(&self, name: Option<&str>) -> Result<Vec<(Symbol, ConfigField)>> {
+        let (owner, field) = match name.map(|n| n.rsplit_once('.')) {
+            Some(Some((owner, field))) => (Some(owner), Some(field)),
+            Some(None) => (None, name),
+            None => (None, None),
+        };
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, c.name, c.line, c.key
+             FROM config_fields c
+             JOIN symbols s ON s.id = c.symbol_id
+             WHERE (?1 IS NULL OR s.name = ?1) AND (?2 IS NULL OR c.name = ?2)
+             ORDER BY c.file_path, c.line",
+        )?;
+        let rows = stmt
+            .query_map(params![owner, field], |row| {
+                let owner = row_to_symbol(row)?;
+                let field = ConfigField {
+                    symbol_id: owner.id.clone(),
+                    name: row.get(13)?,
+                    line: row.get(14)?,
+                    key: row.get(15)?,
+                };
+                Ok((owner, field))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
     }
 
-    /// Insert multiple edges in a single transaction.
-    pub fn insert_edges(&self, edges: &[Edge]) -> Result<()> {
-        let tx = self.conn.unchecked_transaction()?;
-        let mut stmt = self.conn.prepare_cached(SQL_INSERT_EDGE)?;
-        for edge in edges {
-            stmt.execute(params![
-                edge.source_id,
-                edge.target_name,
-                edge.target_id,
-                edge.kind.as_str(),
-                edge.file_path,
-                edge.line,
-            ])?;
-        }
-        tx.commit()?;
-        Ok(())
+    /// Accesses of any struct's field `field`, with the function making each,
+    /// ordered by file and line.
+    pub fn field_uses(&self, field: &str) -> Result<Vec<(Symbol, FieldUse)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring, u.line, u.receiver, u.write
+             FROM field_uses u
+             JOIN symbols s ON s.id = u.symbol_id
+             WHERE u.field = ?1
+             ORDER BY u.file_path, u.line",
+        )?;
+        let rows = stmt
+            .query_map(params![field], |row| {
+                let function = row_to_symbol(row)?;
+                let field_use = FieldUse {
+                    symbol_id: function.id.clone(),
+                    line: row.get(13)?,
+                    field: field.to_string(),
+                    receiver: row.get(14)?,
+                    write: row.get(15)?,
+                };
+                Ok((function, field_use))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
     }
 
     // ── Edge Resolution ──
 
     /// Resolve target_name → target_id for all unresolved edges.
     /// Priority: exact match in same file > same directory > unique project-wide match.
+    ///
+    /// Most misses are external or stdlib names, so a Bloom filter over known symbol
+    /// names rejects them before any lookup query runs.
     pub fn resolve_edges(&self) -> Result<u32> {
         let mut resolved = 0u32;
 
+        let names: Vec<String> = self
+            .conn
+            .prepare("SELECT DISTINCT name FROM symbols")?
+            .query_map([], |row| row.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        let known: BloomFilter = names.iter().map(String::as_str).collect();
+
         let mut unresolved_stmt = self.conn.prepare(
             "SELECT e.id, e.target_name, e.file_path
              FROM edges e WHERE e.target_id IS NULL",
@@ -420,15 +2852,20 @@ impl Database {
         let mut same_dir_stmt = self
             .conn
             .prepare("SELECT id FROM symbols WHERE name = ?1 AND file_path LIKE ?2 LIMIT 1")?;
-        let mut anywhere_stmt = self
-            .conn
-            .prepare("SELECT id FROM symbols WHERE name = ?1 LIMIT 2")?;
+        // Vendored dependencies (see `vendor`) and project code only match by
+        // name among themselves; imports link them.
+        let mut anywhere_stmt = self.conn.prepare(
+            "SELECT id FROM symbols WHERE name = ?1 AND (file_path LIKE 'vendor/%') = ?2 LIMIT 2",
+        )?;
         let mut update_stmt = self
             .conn
             .prepare("UPDATE edges SET target_id = ?1 WHERE id = ?2")?;
 
         for (edge_id, target_name, edge_file) in &unresolved {
             let simple_name = target_name.rsplit('.').next().unwrap_or(target_name);
+            if !known.contains(simple_name) {
+                continue;
+            }
 
             // 1) Same file
             let target_id: Option<String> = same_file_stmt
@@ -460,7 +2897,8 @@ impl Database {
             }
 
             // 3) Unique project-wide match — fetch at most 2 rows; resolve only if exactly 1
-            let mut rows = anywhere_stmt.query(params![simple_name])?;
+            let vendored = crate::vendor::is_vendored(edge_file);
+            let mut rows = anywhere_stmt.query(params![simple_name, vendored])?;
             let first = rows.next()?.and_then(|r| r.get::<_, String>(0).ok());
             let has_second = rows.next()?.is_some();
             if let (Some(tid), false) = (first, has_second) {
@@ -473,6 +2911,28 @@ impl Database {
         Ok(resolved)
     }
 
+    /// Resolve unresolved edges to the given symbols. Returns how many changed.
+    pub fn set_edge_targets(&self, targets: &[(Edge, String)]) -> Result<u32> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "UPDATE edges SET target_id = ?1
+                 WHERE source_id = ?2 AND target_name = ?3 AND kind = ?4 AND line = ?5
+                   AND target_id IS NULL",
+            )?;
+            let mut resolved = 0;
+            for (edge, target_id) in targets {
+                resolved += stmt.execute(params![
+                    target_id,
+                    edge.source_id,
+                    edge.target_name,
+                    edge.kind.as_str(),
+                    edge.line,
+                ])? as u32;
+            }
+            Ok(resolved)
+        })
+    }
+
     // ── Queries ──
 
     /// Search for symbols by name — case-insensitive, prefix match ranks before substring.
@@ -487,7 +2947,63 @@ impl Database {
         file_filter: Option<&str>,
         limit: u32,
     ) -> Result<Vec<Symbol>> {
-        anyhow::ensure!(!query.is_empty(), "search query cannot be empty");
+        let filter = SearchFilter {
+            kind: kind_filter,
+            file: file_filter,
+            ..SearchFilter::default()
+        };
+        self.search_filtered(query, &filter, limit)
+    }
+
+    /// Distinct symbol names starting with `prefix` (case-sensitive), in byte
+    /// order. A range scan of the name index, for shell completion.
+    pub fn complete_names(&self, prefix: &str, limit: u32) -> Result<Vec<String>> {
+        let upper = format!("{prefix}{}", char::MAX);
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT DISTINCT name FROM symbols
+             WHERE name >= ?1 AND name < ?2
+             ORDER BY name
+             LIMIT ?3",
+        )?;
+        let names = stmt
+            .query_map(params![prefix, upper, limit], |row| row.get(0))?
+            .collect::<rusqlite::Result<Vec<String>>>()?;
+        Ok(names)
+    }
+
+    /// `(file, line)` of every definition and edge whose name contains `needle`,
+    /// or with `whole` is it (edge names may be qualified: `auth.needle`). A
+    /// superset, for `cartog grep`: case is ignored and `_` matches any character,
+    /// so callers check the lines themselves.
+    pub fn name_lines(&self, needle: &str, whole: bool) -> Result<Vec<(String, u32)>> {
+        let (symbol, edge) = if whole {
+            (needle.to_string(), format!("%{needle}"))
+        } else {
+            (format!("%{needle}%"), format!("%{needle}%"))
+        };
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT file_path, start_line FROM symbols WHERE name LIKE ?1
+             UNION
+             SELECT file_path, line FROM edges WHERE target_name LIKE ?2
+             ORDER BY 1, 2",
+        )?;
+        let rows = stmt
+            .query_map(params![symbol, edge], |row| Ok((row.get(0)?, row.get(1)?)))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// [`Database::search`] with every [`SearchFilter`].
+    pub fn search_filtered(
+        &self,
+        query: &str,
+        filter: &SearchFilter<'_>,
+        limit: u32,
+    ) -> Result<Vec<Symbol>> {
+        anyhow::ensure!(
+            !query.is_empty() || filter.uncovered,
+            "search query cannot be empty"
+        );
         anyhow::ensure!(limit > 0, "search limit must be at least 1");
 
         // Escape LIKE special characters so query is matched literally.
@@ -495,7 +3011,7 @@ impl Database {
             .replace('\\', "\\\\")
             .replace('%', "\\%")
             .replace('_', "\\_");
-        let kind_str = kind_filter.map(|k| k.as_str());
+        let kind_str = filter.kind.map(|k| k.as_str());
         // Ranking: match_tier + kind_penalty.
         //   match_tier: 0 = exact, 1 = prefix, 2 = substring
         //   kind_penalty: definitions (function/method/class) = 0, variable = 3, import = 6
@@ -503,8 +3019,9 @@ impl Database {
         //   exact class=0, prefix function=1, substring method=2,
         //   exact variable=3, prefix variable=4, substring variable=5,
         //   exact import=6, ...
-        // Within the same rank score, secondary sort by kind (fn < method < class)
-        // then by file_path and start_line for determinism.
+        // Within the same rank score, the symbol with the higher PageRank first (see
+        // `rank`), then by kind (fn < method < class) and by file_path and start_line
+        // for determinism.
         let mut stmt = self.conn.prepare(
             "SELECT id, name, kind, file_path, start_line, end_line,
                     start_byte, end_byte, parent_id, signature, visibility,
@@ -526,7 +3043,15 @@ impl Database {
              WHERE LOWER(name) LIKE '%' || LOWER(?2) || '%' ESCAPE '\\'
                AND (?3 IS NULL OR kind = ?3)
                AND (?4 IS NULL OR file_path = ?4)
+               AND (?6 IS NULL OR id IN (SELECT symbol_id FROM symbol_tags WHERE tag = ?6))
+               AND (?7 IS NULL OR id IN (SELECT symbol_id FROM symbol_metrics
+                                         WHERE cyclomatic >= ?7))
+               AND (?8 IS NULL OR (substr(file_path, 1, length(?8) + 1) = ?8 || '/'
+                                   AND instr(substr(file_path, length(?8) + 2), '/') = 0))
+               AND (?9 = 0 OR id IN (SELECT symbol_id FROM symbol_coverage
+                                     WHERE covered = 0 AND total > 0))
              ORDER BY rank,
+                      COALESCE((SELECT pagerank FROM symbol_rank WHERE symbol_id = id), 0) DESC,
                       CASE kind
                         WHEN 'function' THEN 0
                         WHEN 'method'   THEN 1
@@ -537,16 +3062,87 @@ impl Database {
              LIMIT ?5",
         )?;
         // rank is column 13 — row_to_symbol reads columns 0–12 and ignores it
-        // ?1 = raw query (exact equality), ?2 = escaped query (LIKE patterns), ?3 = kind, ?4 = file, ?5 = limit
+        // ?1 = raw query (exact equality), ?2 = escaped query (LIKE patterns), ?3 = kind, ?4 = file,
+        // ?5 = limit, ?6 = tag, ?7 = minimum cyclomatic complexity, ?8 = package
+        // directory, ?9 = uncovered only
         let rows = stmt
             .query_map(
-                params![query, escaped, kind_str, file_filter, limit],
+                params![
+                    query,
+                    escaped,
+                    kind_str,
+                    filter.file,
+                    limit,
+                    filter.tag,
+                    filter.min_complexity,
+                    filter.package.map(|p| p.trim_end_matches('/')),
+                    filter.uncovered,
+                ],
                 row_to_symbol,
             )?
             .collect::<std::result::Result<Vec<_>, _>>()?;
         Ok(rows)
     }
 
+    /// Import edges of every file, ordered by file and insertion.
+    pub fn import_edges(&self) -> Result<Vec<Edge>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT id, source_id, target_name, target_id, kind, file_path, line
+             FROM edges WHERE kind = 'imports'
+             ORDER BY file_path, id",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_edge)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Endpoints of every resolved edge: `(source_id, source_file, target_id, target_file)`.
+    ///
+    /// Used by graph-wide analyses (fan-in, package coupling) that need the
+    /// file of both ends without loading full symbols.
+    pub fn resolved_edge_endpoints(&self) -> Result<Vec<(String, String, String, String)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT e.source_id, s.file_path, e.target_id, t.file_path
+             FROM edges e
+             JOIN symbols s ON e.source_id = s.id
+             JOIN symbols t ON e.target_id = t.id
+             WHERE e.target_id IS NOT NULL",
+        )?;
+        let rows = stmt
+            .query_map([], |row| {
+                Ok((row.get(0)?, row.get(1)?, row.get(2)?, row.get(3)?))
+            })?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Definitions (non-import symbols) with an exact name, ordered by file and line.
+    ///
+    /// A dotted name such as `AuthService.login` matches `login` symbols whose
+    /// parent is named `AuthService` (or, for Go methods, whose receiver type is).
+    pub fn find_definitions(&self, name: &str) -> Result<Vec<Symbol>> {
+        let (parent, simple) = match name.rsplit_once('.') {
+            Some((p, s)) if !p.is_empty() && !s.is_empty() => (Some(p), s),
+            _ => (None, name),
+        };
+        let parent = parent.map(|p| p.rsplit('.').next().unwrap_or(p));
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
+                    s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
+                    s.is_async, s.docstring
+             FROM symbols s
+             LEFT JOIN symbols p ON s.parent_id = p.id
+             WHERE s.name = ?1 AND s.kind != 'import'
+               AND (?2 IS NULL OR p.name = ?2 OR s.parent_id = s.file_path || ':' || ?2)
+             ORDER BY s.file_path, s.start_line",
+        )?;
+        let rows = stmt
+            .query_map(params![simple, parent], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
     /// Outline: all symbols in a file, ordered by line.
     pub fn outline(&self, file_path: &str) -> Result<Vec<Symbol>> {
         let mut stmt = self.conn.prepare(
@@ -575,6 +3171,54 @@ impl Database {
         Ok(rows)
     }
 
+    /// [`Database::callees`] of the one symbol `id`.
+    pub fn callees_of_id(&self, id: &str) -> Result<Vec<Edge>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT id, source_id, target_name, target_id, kind, file_path, line
+             FROM edges
+             WHERE source_id = ?1 AND kind = 'calls'",
+        )?;
+        let rows = stmt
+            .query_map(params![id], row_to_edge)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Go benchmark candidates: functions named `Benchmark...` taking a
+    /// `*testing.B`, in `_test.go` files. Ordered by file and line.
+    pub fn benchmarks(&self) -> Result<Vec<Symbol>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT id, name, kind, file_path, start_line, end_line, start_byte, end_byte,
+                    parent_id, signature, visibility, is_async, docstring
+             FROM symbols
+             WHERE kind = 'function' AND substr(name, 1, 9) = 'Benchmark'
+               AND signature LIKE '%*testing.B%'
+               AND substr(file_path, -8) = '_test.go'
+             ORDER BY file_path, start_line",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Go test functions: `Test*` functions in `_test.go` files taking `*testing.T`.
+    pub fn go_tests(&self) -> Result<Vec<Symbol>> {
+        let mut stmt = self.conn.prepare_cached(
+            "SELECT id, name, kind, file_path, start_line, end_line, start_byte, end_byte,
+                    parent_id, signature, visibility, is_async, docstring
+             FROM symbols
+             WHERE kind = 'function' AND substr(name, 1, 4) = 'Test'
+               AND signature LIKE '%*testing.T%'
+               AND substr(file_path, -8) = '_test.go'
+             ORDER BY file_path, start_line",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
     /// All references to a name, with the source symbol resolved.
     /// Optionally filter by edge kind.
     pub fn refs(
@@ -582,7 +3226,25 @@ impl Database {
         name: &str,
         kind_filter: Option<EdgeKind>,
     ) -> Result<Vec<(Edge, Option<Symbol>)>> {
-        // Use a LEFT JOIN to resolve target_id → symbol name instead of a correlated subquery.
+        self.query_refs(SQL_REFS, name, kind_filter)
+    }
+
+    /// [`Database::refs`] to the one symbol `id`. Only resolved edges point at a
+    /// single symbol, so unresolved references by name are left out.
+    pub fn refs_to_id(
+        &self,
+        id: &str,
+        kind_filter: Option<EdgeKind>,
+    ) -> Result<Vec<(Edge, Option<Symbol>)>> {
+        self.query_refs(SQL_REFS_TO_ID, id, kind_filter)
+    }
+
+    fn query_refs(
+        &self,
+        sql: &str,
+        target: &str,
+        kind_filter: Option<EdgeKind>,
+    ) -> Result<Vec<(Edge, Option<Symbol>)>> {
         let map_row = |row: &rusqlite::Row<'_>| -> rusqlite::Result<(Edge, Option<Symbol>)> {
             let kind_str = row.get::<_, String>(4)?;
             let kind = kind_str.parse().unwrap_or(EdgeKind::References);
@@ -602,50 +3264,37 @@ impl Database {
             Ok((edge, sym))
         };
 
-        let rows = if let Some(kind) = kind_filter {
-            let mut stmt = self.conn.prepare_cached(
-                "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
-                        s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
-                        s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
-                        s.is_async, s.docstring
-                 FROM edges e
-                 LEFT JOIN symbols s ON e.source_id = s.id
-                 LEFT JOIN symbols sym2 ON e.target_id = sym2.id
-                 WHERE (e.target_name = ?1 OR sym2.name = ?1)
-                   AND e.kind = ?2",
-            )?;
-            let rows = stmt
-                .query_map(params![name, kind.as_str()], map_row)?
-                .collect::<std::result::Result<Vec<_>, _>>()?;
-            rows
-        } else {
-            let mut stmt = self.conn.prepare_cached(
-                "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
-                        s.id, s.name, s.kind, s.file_path, s.start_line, s.end_line,
-                        s.start_byte, s.end_byte, s.parent_id, s.signature, s.visibility,
-                        s.is_async, s.docstring
-                 FROM edges e
-                 LEFT JOIN symbols s ON e.source_id = s.id
-                 LEFT JOIN symbols sym2 ON e.target_id = sym2.id
-                 WHERE e.target_name = ?1 OR sym2.name = ?1",
-            )?;
-            let rows = stmt
-                .query_map(params![name], map_row)?
-                .collect::<std::result::Result<Vec<_>, _>>()?;
-            rows
-        };
+        // `?2 IS NULL` keeps one cached statement for both the filtered and unfiltered forms.
+        let mut stmt = self.conn.prepare_cached(sql)?;
+        let rows = stmt
+            .query_map(params![target, kind_filter.map(|k| k.as_str())], map_row)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
         Ok(rows)
     }
 
     /// Inheritance hierarchy rooted at a class.
     pub fn hierarchy(&self, class_name: &str) -> Result<Vec<(String, String)>> {
         // Returns (child, parent) pairs
+        // Both OR branches hit an (endpoint, kind) index, so SQLite unions two
+        // index searches instead of scanning every inherits edge.
+        // Go type assertions add (asserted type, interface) pairs: a downcast
+        // relates the two at run time where no declaration does.
         let mut stmt = self.conn.prepare(
             "SELECT s.name, e.target_name
              FROM edges e
              JOIN symbols s ON e.source_id = s.id
              WHERE e.kind = 'inherits'
-               AND (s.name = ?1 OR e.target_name = ?1)",
+               AND (e.target_name = ?1
+                    OR e.source_id IN (SELECT id FROM symbols WHERE name = ?1))
+             UNION ALL
+             SELECT DISTINCT a.type_name, a.interface
+             FROM type_assertions a
+             WHERE a.interface IS NOT NULL
+               AND (a.type_name = ?1 OR a.interface = ?1)
+               AND NOT EXISTS (
+                   SELECT 1 FROM edges e JOIN symbols s ON e.source_id = s.id
+                   WHERE e.kind = 'inherits' AND s.name = a.type_name
+                     AND e.target_name = a.interface)",
         )?;
         let rows = stmt
             .query_map(params![class_name], |row| Ok((row.get(0)?, row.get(1)?)))?
@@ -678,12 +3327,19 @@ impl Database {
             }
             visited.insert(current.clone());
 
-            let refs = self.refs(&current, None)?;
-            for (edge, sym) in refs {
+            // Only the source name is needed to expand the frontier, so skip the
+            // full symbol row that `refs` loads.
+            let mut stmt = self.conn.prepare_cached(SQL_DEPENDENTS)?;
+            let rows = stmt
+                .query_map(params![current], |row| {
+                    Ok((row_to_edge(row)?, row.get::<_, Option<String>>(7)?))
+                })?
+                .collect::<std::result::Result<Vec<_>, _>>()?;
+            for (edge, source_name) in rows {
                 results.push((edge, depth + 1));
-                if let Some(s) = sym {
-                    if !visited.contains(&s.name) {
-                        frontier.push((s.name, depth + 1));
+                if let Some(source_name) = source_name {
+                    if !visited.contains(&source_name) {
+                        frontier.push((source_name, depth + 1));
                     }
                 }
             }
@@ -692,6 +3348,35 @@ impl Database {
         Ok(results)
     }
 
+    /// [`Database::impact`] of the one symbol `id`, following resolved edges:
+    /// each level goes on from the symbols found, not from their names.
+    pub fn impact_of_id(&self, id: &str, max_depth: u32) -> Result<Vec<(Edge, u32)>> {
+        let mut results = Vec::new();
+        let mut visited = std::collections::HashSet::new();
+        let mut frontier: Vec<(String, u32)> = vec![(id.to_string(), 0)];
+
+        while let Some((current, depth)) = frontier.pop() {
+            if depth >= max_depth || !visited.insert(current.clone()) {
+                continue;
+            }
+            let mut stmt = self.conn.prepare_cached(
+                "SELECT id, source_id, target_name, target_id, kind, file_path, line
+                 FROM edges WHERE target_id = ?1",
+            )?;
+            let rows = stmt
+                .query_map(params![current], row_to_edge)?
+                .collect::<std::result::Result<Vec<_>, _>>()?;
+            for edge in rows {
+                if !visited.contains(&edge.source_id) {
+                    frontier.push((edge.source_id.clone(), depth + 1));
+                }
+                results.push((edge, depth + 1));
+            }
+        }
+
+        Ok(results)
+    }
+
     /// Index statistics.
     pub fn stats(&self) -> Result<IndexStats> {
         let num_files: u32 = self
@@ -730,26 +3415,141 @@ impl Database {
             num_resolved,
             languages,
             symbol_kinds,
+            degraded_files: self.degraded_files()?,
+            branches: self.branch_states()?,
+        })
+    }
+
+    /// Returns `true` if at least one file has been indexed.
+    ///
+    /// Cheaper than [`stats`] for the common "is the index empty?" check —
+    /// SQLite can satisfy `LIMIT 1` with a single index seek rather than a full count.
+    pub fn has_indexed_files(&self) -> Result<bool> {
+        Ok(self
+            .conn
+            .query_row("SELECT 1 FROM files LIMIT 1", [], |_| Ok(()))
+            .optional()?
+            .is_some())
+    }
+
+    /// Get all indexed file paths, sorted alphabetically.
+    pub fn all_files(&self) -> Result<Vec<String>> {
+        let mut stmt = self.conn.prepare("SELECT path FROM files ORDER BY path")?;
+        let rows = stmt
+            .query_map([], |row| row.get(0))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// All symbols in the index, ordered by file and line.
+    pub fn all_symbols(&self) -> Result<Vec<Symbol>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT id, name, kind, file_path, start_line, end_line, start_byte, end_byte,
+                    parent_id, signature, visibility, is_async, docstring
+             FROM symbols
+             ORDER BY file_path, start_line",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_symbol)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// All edges in the index, ordered by file and line.
+    pub fn all_edges(&self) -> Result<Vec<Edge>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT id, source_id, target_name, target_id, kind, file_path, line
+             FROM edges
+             ORDER BY file_path, line",
+        )?;
+        let rows = stmt
+            .query_map([], row_to_edge)?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    /// Every edge with its source symbol's name and, when resolved, the target's file.
+    pub fn edges_with_endpoints(&self) -> Result<Vec<(Edge, String, Option<String>)>> {
+        let mut stmt = self.conn.prepare(
+            "SELECT e.id, e.source_id, e.target_name, e.target_id, e.kind, e.file_path, e.line,
+                    s.name, t.file_path
+             FROM edges e
+             JOIN symbols s ON e.source_id = s.id
+             LEFT JOIN symbols t ON e.target_id = t.id
+             ORDER BY e.file_path, e.line",
+        )?;
+        let rows = stmt
+            .query_map([], |row| Ok((row_to_edge(row)?, row.get(7)?, row.get(8)?)))?
+            .collect::<std::result::Result<Vec<_>, _>>()?;
+        Ok(rows)
+    }
+
+    // ── Symbol Lineage ──
+
+    /// Record rename links detected during indexing (replaces an identical link).
+    pub fn insert_renames(&self, links: &[RenameLink]) -> Result<()> {
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_renames
+                 (old_name, old_file, old_start_line, old_end_line, new_name, new_file,
+                  kind, reason, old_commit)
+                 VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)",
+            )?;
+            for link in links {
+                stmt.execute(params![
+                    link.old_name,
+                    link.old_file,
+                    link.old_start_line,
+                    link.old_end_line,
+                    link.new_name,
+                    link.new_file,
+                    link.kind.as_str(),
+                    link.reason.as_str(),
+                    link.old_commit,
+                ])?;
+            }
+            Ok(())
         })
     }
 
-    /// Returns `true` if at least one file has been indexed.
-    ///
-    /// Cheaper than [`stats`] for the common "is the index empty?" check —
-    /// SQLite can satisfy `LIMIT 1` with a single index seek rather than a full count.
-    pub fn has_indexed_files(&self) -> Result<bool> {
-        Ok(self
-            .conn
-            .query_row("SELECT 1 FROM files LIMIT 1", [], |_| Ok(()))
-            .optional()?
-            .is_some())
+    /// Links whose new identity is `name` in `file` (i.e. what it used to be called).
+    pub fn renames_into(&self, file: &str, name: &str) -> Result<Vec<RenameLink>> {
+        self.query_renames("WHERE new_file = ?1 AND new_name = ?2", params![file, name])
+    }
+
+    /// Links whose old name was `name`, in any file.
+    pub fn renames_from(&self, name: &str) -> Result<Vec<RenameLink>> {
+        self.query_renames("WHERE old_name = ?1", params![name])
     }
 
-    /// Get all indexed file paths, sorted alphabetically.
-    pub fn all_files(&self) -> Result<Vec<String>> {
-        let mut stmt = self.conn.prepare("SELECT path FROM files ORDER BY path")?;
+    fn query_renames(
+        &self,
+        filter: &str,
+        params: impl rusqlite::Params,
+    ) -> Result<Vec<RenameLink>> {
+        let sql = format!(
+            "SELECT old_name, old_file, old_start_line, old_end_line, new_name, new_file,
+                    kind, reason, old_commit
+             FROM symbol_renames {filter}
+             ORDER BY old_file, old_name"
+        );
+        let mut stmt = self.conn.prepare(&sql)?;
         let rows = stmt
-            .query_map([], |row| row.get(0))?
+            .query_map(params, |row| {
+                let kind: String = row.get(6)?;
+                let reason: String = row.get(7)?;
+                Ok(RenameLink {
+                    old_name: row.get(0)?,
+                    old_file: row.get(1)?,
+                    old_start_line: row.get(2)?,
+                    old_end_line: row.get(3)?,
+                    new_name: row.get(4)?,
+                    new_file: row.get(5)?,
+                    kind: kind.parse().unwrap_or(SymbolKind::Function),
+                    reason: reason.parse().unwrap_or(RenameReason::SimilarContent),
+                    old_commit: row.get(8)?,
+                })
+            })?
             .collect::<std::result::Result<Vec<_>, _>>()?;
         Ok(rows)
     }
@@ -780,17 +3580,17 @@ impl Database {
     ///
     /// Tuples: `(symbol_id, symbol_name, content, header)`.
     pub fn insert_symbol_contents(&self, items: &[(String, String, String, String)]) -> Result<()> {
-        let tx = self.conn.unchecked_transaction()?;
-        let mut stmt = self.conn.prepare_cached(
-            "INSERT OR REPLACE INTO symbol_content (symbol_id, content, header, normalized_name)
-             VALUES (?1, ?2, ?3, ?4)",
-        )?;
-        for (symbol_id, name, content, header) in items {
-            let normalized = normalize_symbol_name(name);
-            stmt.execute(params![symbol_id, content, header, normalized])?;
-        }
-        tx.commit()?;
-        Ok(())
+        self.in_transaction(|| {
+            let mut stmt = self.conn.prepare_cached(
+                "INSERT OR REPLACE INTO symbol_content (symbol_id, content, header, normalized_name)
+                 VALUES (?1, ?2, ?3, ?4)",
+            )?;
+            for (symbol_id, name, content, header) in items {
+                let normalized = normalize_symbol_name(name);
+                stmt.execute(params![symbol_id, content, header, normalized])?;
+            }
+            Ok(())
+        })
     }
 
     /// Remove symbol content for all symbols in a file.
@@ -1120,6 +3920,46 @@ impl Database {
     }
 }
 
+/// Optional restrictions on [`Database::search_filtered`].
+#[derive(Debug, Clone, Copy, Default)]
+pub struct SearchFilter<'a> {
+    pub kind: Option<SymbolKind>,
+    pub file: Option<&'a str>,
+    pub tag: Option<&'a str>,
+    /// Functions and methods with at least this cyclomatic complexity.
+    pub min_complexity: Option<u32>,
+    /// Symbols in files directly inside this directory (a Go package).
+    pub package: Option<&'a str>,
+    /// Functions and methods whose statements no test ran, per the imported
+    /// coverage profile. An empty query then matches every name.
+    pub uncovered: bool,
+}
+
+/// Ranking key for [`Database::most_complex`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ComplexityMetric {
+    Cyclomatic,
+    Cognitive,
+}
+
+/// Ranking key for [`Database::top_ranked`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum RankMetric {
+    PageRank,
+    Betweenness,
+}
+
+/// Symbol ids allowed by a `--tag` filter. See [`Database::tag_filter`].
+#[derive(Debug, Clone, Default)]
+pub struct TagFilter(Option<HashSet<String>>);
+
+impl TagFilter {
+    /// Whether the symbol `id` passes; an unset filter passes everything.
+    pub fn keeps(&self, id: &str) -> bool {
+        self.0.as_ref().map_or(true, |ids| ids.contains(id))
+    }
+}
+
 #[derive(Debug, Clone, Serialize)]
 pub struct IndexStats {
     pub num_files: u32,
@@ -1128,6 +3968,36 @@ pub struct IndexStats {
     pub num_resolved: u32,
     pub languages: Vec<(String, u32)>,
     pub symbol_kinds: Vec<(String, u32)>,
+    /// Files indexed despite syntax errors: their symbols may be incomplete.
+    pub degraded_files: Vec<DegradedFile>,
+    /// Branches with a saved index state, most recently indexed first.
+    pub branches: Vec<BranchState>,
+}
+
+/// The index state saved for a branch (see [`Database::save_branch_state`]).
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BranchState {
+    pub branch: String,
+    /// Commit checked out when the branch was last indexed.
+    pub commit: String,
+    pub indexed_at: u64,
+    /// Files whose version on the branch is in the parse cache, ready to be
+    /// restored without parsing.
+    pub cached_files: u32,
+}
+
+/// A file indexed despite syntax errors.
+#[derive(Debug, Clone, Serialize)]
+pub struct DegradedFile {
+    pub path: String,
+    /// Syntax errors recorded, capped per file.
+    pub errors: u32,
+    pub first: SyntaxError,
+}
+
+/// Directory of `file_path`, standing in for its Go package.
+fn package_dir(file_path: &str) -> &str {
+    file_path.rsplit_once('/').map_or("", |(dir, _)| dir)
 }
 
 // ── Row Mapping Helpers ──
@@ -1182,6 +4052,7 @@ fn row_to_edge(row: &rusqlite::Row<'_>) -> rusqlite::Result<Edge> {
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::types::{ConstructionKind, SyncOp};
 
     fn test_symbol(name: &str, kind: SymbolKind, file: &str, line: u32) -> Symbol {
         Symbol::new(name, kind, file, line, line + 5, 0, 100)
@@ -1280,6 +4151,209 @@ mod tests {
         assert_eq!(refs[0].0.source_id, caller.id);
     }
 
+    #[test]
+    fn test_find_definitions_exact_and_qualified() {
+        let db = Database::open_memory().unwrap();
+        let class = test_symbol("AuthService", SymbolKind::Class, "auth.py", 1);
+        let method = test_symbol("login", SymbolKind::Method, "auth.py", 3)
+            .with_parent(Some(class.id.as_str()));
+        let func = test_symbol("login", SymbolKind::Function, "views.py", 1);
+        let import = test_symbol("login", SymbolKind::Import, "app.py", 1);
+        db.insert_symbols(&[class, method, func, import]).unwrap();
+
+        let all = db.find_definitions("login").unwrap();
+        assert_eq!(all.len(), 2, "imports are not definitions");
+
+        let qualified = db.find_definitions("AuthService.login").unwrap();
+        assert_eq!(qualified.len(), 1);
+        assert_eq!(qualified[0].kind, SymbolKind::Method);
+    }
+
+    #[test]
+    fn test_hot_queries_avoid_full_scans() {
+        let db = Database::open_memory().unwrap();
+        for sql in [SQL_REFS, SQL_DEPENDENTS] {
+            let plan = db.query_plan(sql).unwrap();
+            assert!(
+                plan.iter().all(|step| !step.starts_with("SCAN")),
+                "full scan in plan: {plan:?}"
+            );
+        }
+
+        let plan = db
+            .query_plan("SELECT id FROM symbols WHERE file_path = ?1 ORDER BY start_line")
+            .unwrap();
+        assert!(
+            plan.iter().all(|step| !step.contains("TEMP B-TREE")),
+            "outline sorts: {plan:?}"
+        );
+    }
+
+    #[test]
+    fn test_refs_matches_resolved_target_by_name() {
+        let db = Database::open_memory().unwrap();
+        let target = Symbol::new("validate", SymbolKind::Function, "auth.py", 1, 5, 0, 50);
+        let caller = Symbol::new("login", SymbolKind::Function, "views.py", 1, 9, 0, 90);
+        db.insert_symbols(&[target.clone(), caller.clone()])
+            .unwrap();
+        // Qualified target name: only matches "validate" once resolved to the symbol.
+        db.insert_edge(&Edge::new(
+            &caller.id,
+            "auth.validate",
+            EdgeKind::Calls,
+            "views.py",
+            4,
+        ))
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let refs = db.refs("validate", None).unwrap();
+        assert_eq!(refs.len(), 1);
+        assert_eq!(refs[0].1.as_ref().map(|s| s.name.as_str()), Some("login"));
+        assert!(db
+            .refs("validate", Some(EdgeKind::Imports))
+            .unwrap()
+            .is_empty());
+
+        let impact = db.impact("validate", 2).unwrap();
+        assert_eq!(impact.len(), 1);
+        assert_eq!(impact[0].1, 1);
+    }
+
+    #[test]
+    fn test_batched_bulk_load() {
+        let db = Database::open_memory().unwrap();
+        db.begin_bulk_load().unwrap();
+        db.begin_batch().unwrap();
+        db.begin_batch().unwrap(); // idempotent
+                                   // Per-call transactions nest inside the open batch instead of failing.
+        db.insert_symbols(&[test_symbol("a", SymbolKind::Function, "x.py", 1)])
+            .unwrap();
+        db.insert_edges(&[Edge::new("x.py:a:1", "b", EdgeKind::Calls, "x.py", 2)])
+            .unwrap();
+        db.rollback_batch().unwrap();
+        assert!(db.outline("x.py").unwrap().is_empty());
+
+        db.begin_batch().unwrap();
+        db.insert_symbols(&[test_symbol("a", SymbolKind::Function, "x.py", 1)])
+            .unwrap();
+        db.commit_batch().unwrap();
+        db.commit_batch().unwrap(); // no batch open: no-op
+        db.end_bulk_load().unwrap();
+
+        assert_eq!(db.outline("x.py").unwrap().len(), 1);
+        let indexed: u32 = db
+            .conn
+            .query_row(
+                "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_symbols_file_line'",
+                [],
+                |row| row.get(0),
+            )
+            .unwrap();
+        assert_eq!(indexed, 1);
+    }
+
+    #[test]
+    fn test_schema_version_tracks_bulk_load() {
+        let dir = std::env::temp_dir().join(format!("cartog-schema-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let path = dir.join("index.db");
+
+        let db = Database::open(&path).unwrap();
+        assert_eq!(db.schema_version().unwrap(), SCHEMA_VERSION);
+        db.begin_bulk_load().unwrap();
+        assert_eq!(db.schema_version().unwrap(), 0);
+        drop(db);
+
+        // Interrupted bulk load: reopening re-runs the DDL and restores the indexes.
+        let db = Database::open(&path).unwrap();
+        assert_eq!(db.schema_version().unwrap(), SCHEMA_VERSION);
+        let plan = db
+            .query_plan("SELECT id FROM symbols WHERE file_path = ?1 ORDER BY start_line")
+            .unwrap();
+        assert!(plan
+            .iter()
+            .any(|step| step.contains("idx_symbols_file_line")));
+        drop(db);
+
+        // Fast path: a second open with nothing to migrate still works.
+        let db = Database::open(&path).unwrap();
+        db.insert_symbol(&test_symbol("login", SymbolKind::Function, "auth.py", 1))
+            .unwrap();
+        assert_eq!(db.outline("auth.py").unwrap().len(), 1);
+
+        drop(db);
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_open_read_only_refuses_writes() {
+        let dir = std::env::temp_dir().join(format!("cartog-readonly-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let path = dir.join("index.db");
+        assert!(Database::open_read_only(&path).is_err());
+
+        let db = Database::open(&path).unwrap();
+        db.insert_symbol(&test_symbol("login", SymbolKind::Function, "auth.py", 1))
+            .unwrap();
+        drop(db);
+
+        let db = Database::open_read_only(&path).unwrap();
+        assert_eq!(db.outline("auth.py").unwrap().len(), 1);
+        assert!(db
+            .insert_symbol(&test_symbol("logout", SymbolKind::Function, "auth.py", 9))
+            .is_err());
+
+        drop(db);
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_mmap_size_and_prewarm() {
+        let dir = std::env::temp_dir().join(format!("cartog-mmap-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let path = dir.join("index.db");
+        let db = Database::open(&path).unwrap();
+        db.insert_symbol(&test_symbol("login", SymbolKind::Function, "auth.py", 1))
+            .unwrap();
+
+        assert_eq!(db.set_mmap_size(0).unwrap(), 0);
+        assert!(db.set_mmap_size(64 * 1024 * 1024).unwrap() > 0);
+        assert!(prewarm_file(&path).unwrap() > 0);
+        assert_eq!(prewarm_file(dir.join("missing.db")).unwrap(), 0);
+
+        drop(db);
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[test]
+    fn test_renames_roundtrip() {
+        let db = Database::open_memory().unwrap();
+        let link = RenameLink {
+            old_name: "check_token".into(),
+            old_file: "auth.py".into(),
+            old_start_line: 3,
+            old_end_line: 9,
+            new_name: "validate_token".into(),
+            new_file: "auth/tokens.py".into(),
+            kind: SymbolKind::Function,
+            reason: RenameReason::SimilarContent,
+            old_commit: Some("abc123".into()),
+        };
+        db.insert_renames(&[link.clone()]).unwrap();
+        db.insert_renames(&[link.clone()]).unwrap();
+
+        assert_eq!(
+            db.renames_into("auth/tokens.py", "validate_token").unwrap(),
+            vec![link.clone()]
+        );
+        assert_eq!(db.renames_from("check_token").unwrap(), vec![link]);
+        assert!(db
+            .renames_into("auth.py", "check_token")
+            .unwrap()
+            .is_empty());
+    }
+
     #[test]
     fn test_edge_resolution() {
         let db = Database::open_memory().unwrap();
@@ -1510,6 +4584,121 @@ mod tests {
         assert_eq!(pairs[0].1, "Animal");
     }
 
+    #[test]
+    fn test_degraded_files_in_stats() {
+        let db = Database::open_memory().unwrap();
+        let error = |line, message: &str| SyntaxError {
+            line,
+            message: message.to_string(),
+        };
+        db.insert_syntax_errors(
+            "api/broken.go",
+            &[error(7, "missing `)`"), error(9, "unexpected `:=`")],
+        )
+        .unwrap();
+        db.insert_syntax_errors("api/clean.go", &[]).unwrap();
+
+        let degraded = db.stats().unwrap().degraded_files;
+        assert_eq!(degraded.len(), 1);
+        assert_eq!(degraded[0].path, "api/broken.go");
+        assert_eq!(degraded[0].errors, 2);
+        assert_eq!(degraded[0].first, error(7, "missing `)`"));
+
+        db.clear_file_data("api/broken.go").unwrap();
+        assert!(db.stats().unwrap().degraded_files.is_empty());
+        assert!(db.syntax_errors("api/broken.go").unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_constructions_by_type() {
+        let db = Database::open_memory().unwrap();
+        let new = test_symbol("NewStore", SymbolKind::Function, "store/store.go", 5);
+        db.insert_symbols(&[new.clone()]).unwrap();
+        let site = |line, type_name: &str, kind| Construction {
+            symbol_id: new.id.clone(),
+            line,
+            type_name: type_name.to_string(),
+            kind,
+        };
+        db.insert_constructions(
+            "store/store.go",
+            &[
+                site(6, "Store", ConstructionKind::Pointer),
+                site(6, "User", ConstructionKind::Literal),
+            ],
+        )
+        .unwrap();
+
+        let sites = db.constructions("Store").unwrap();
+        assert_eq!(sites.len(), 1);
+        assert_eq!(sites[0].0.name, "NewStore");
+        assert_eq!(sites[0].1.kind, ConstructionKind::Pointer);
+        db.clear_file_data("store/store.go").unwrap();
+        assert!(db.constructions("Store").unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_test_doubles_by_interface_or_name() {
+        let db = Database::open_memory().unwrap();
+        let mock = test_symbol("MockStore", SymbolKind::Class, "mocks/store.go", 8);
+        db.insert_symbols(&[mock.clone()]).unwrap();
+        db.insert_test_doubles(
+            "mocks/store.go",
+            &[TestDouble {
+                symbol_id: mock.id.clone(),
+                interface: "Store".to_string(),
+                generator: "gomock".to_string(),
+            }],
+        )
+        .unwrap();
+
+        for name in ["Store", "MockStore"] {
+            let doubles = db.test_doubles(name).unwrap();
+            assert_eq!(doubles.len(), 1);
+            assert_eq!(doubles[0].0.name, "MockStore");
+            assert_eq!(doubles[0].1.generator, "gomock");
+        }
+        db.clear_file_data("mocks/store.go").unwrap();
+        assert!(db.test_doubles("Store").unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_hierarchy_includes_type_assertions() {
+        let db = Database::open_memory().unwrap();
+
+        let handler = test_symbol("Status", SymbolKind::Function, "api.go", 3);
+        db.insert_symbol(&handler).unwrap();
+        let assertion = |line, interface: Option<&str>, type_switch| TypeAssertion {
+            symbol_id: handler.id.clone(),
+            line,
+            interface: interface.map(str::to_string),
+            type_name: "NotFound".to_string(),
+            type_switch,
+        };
+        db.insert_type_assertions(
+            "api.go",
+            &[
+                assertion(4, Some("error"), false),
+                assertion(8, Some("error"), true),
+                assertion(12, None, false),
+            ],
+        )
+        .unwrap();
+
+        // One pair however many sites; an unknown interface adds none.
+        assert_eq!(
+            db.hierarchy("error").unwrap(),
+            [("NotFound".to_string(), "error".to_string())]
+        );
+        let sites = db.type_assertions("NotFound").unwrap();
+        let lines: Vec<u32> = sites.iter().map(|(_, a)| a.line).collect();
+        assert_eq!(lines, [4, 8, 12]);
+        assert_eq!(sites[0].0.name, "Status");
+
+        db.clear_file_data("api.go").unwrap();
+        assert!(db.hierarchy("error").unwrap().is_empty());
+    }
+
     #[test]
     fn test_file_deps_query() {
         let db = Database::open_memory().unwrap();
@@ -1623,6 +4812,138 @@ mod tests {
         assert_eq!(results[0].name, "parse_config");
     }
 
+    #[test]
+    fn test_tags_filter_search_and_clear_with_file() {
+        let db = Database::open_memory().unwrap();
+        let old = test_symbol("parse_v1", SymbolKind::Function, "a.py", 1);
+        let new = test_symbol("parse_v2", SymbolKind::Function, "a.py", 10);
+        let other = test_symbol("parse_b", SymbolKind::Function, "b.py", 1);
+        db.insert_symbols(&[old.clone(), new.clone(), other.clone()])
+            .unwrap();
+        db.insert_symbol_tags("a.py", &[(old.id.as_str(), "deprecated".into())])
+            .unwrap();
+        db.insert_symbol_tags("b.py", &[(other.id.as_str(), "api-surface".into())])
+            .unwrap();
+
+        let filter = SearchFilter {
+            tag: Some("deprecated"),
+            ..SearchFilter::default()
+        };
+        let tagged = db.search_filtered("parse", &filter, 20).unwrap();
+        assert_eq!(tagged.len(), 1);
+        assert_eq!(tagged[0].name, "parse_v1");
+        assert_eq!(db.search("parse", None, None, 20).unwrap().len(), 3);
+        assert_eq!(
+            db.tag_counts().unwrap(),
+            [
+                ("api-surface".to_string(), 1),
+                ("deprecated".to_string(), 1)
+            ]
+        );
+        let filter = db.tag_filter(Some("api-surface")).unwrap();
+        assert!(filter.keeps(&other.id) && !filter.keeps(&new.id));
+        assert!(db.tag_filter(None).unwrap().keeps(&new.id));
+
+        db.clear_file_data("a.py").unwrap();
+        assert!(db.tagged_symbols("deprecated").unwrap().is_empty());
+        assert_eq!(db.symbol_tags(&other.id).unwrap(), ["api-surface"]);
+    }
+
+    #[test]
+    fn test_complexity_ranking_and_search_filter() {
+        let db = Database::open_memory().unwrap();
+        let simple = test_symbol("parse_flag", SymbolKind::Function, "a.py", 1);
+        let branchy = test_symbol("parse_args", SymbolKind::Function, "a.py", 10);
+        let nested = test_symbol("parse_tree", SymbolKind::Function, "b.py", 1);
+        db.insert_symbols(&[simple.clone(), branchy.clone(), nested.clone()])
+            .unwrap();
+        let c = |cyclomatic, cognitive| Complexity {
+            cyclomatic,
+            cognitive,
+        };
+        db.insert_complexity(
+            "a.py",
+            &[(simple.id.clone(), c(1, 0)), (branchy.id.clone(), c(12, 9))],
+        )
+        .unwrap();
+        db.insert_complexity("b.py", &[(nested.id.clone(), c(6, 15))])
+            .unwrap();
+
+        let by_cyclomatic = db
+            .most_complex(ComplexityMetric::Cyclomatic, None, 2)
+            .unwrap();
+        assert_eq!(by_cyclomatic[0].0.name, "parse_args");
+        assert_eq!(by_cyclomatic[1].1, c(6, 15));
+        let by_cognitive = db
+            .most_complex(ComplexityMetric::Cognitive, None, 10)
+            .unwrap();
+        assert_eq!(by_cognitive[0].0.name, "parse_tree");
+        assert_eq!(by_cognitive.len(), 3);
+
+        let filter = SearchFilter {
+            min_complexity: Some(5),
+            ..SearchFilter::default()
+        };
+        let names: Vec<String> = db
+            .search_filtered("parse", &filter, 20)
+            .unwrap()
+            .into_iter()
+            .map(|s| s.name)
+            .collect();
+        assert_eq!(names, ["parse_args", "parse_tree"]);
+
+        db.clear_file_data("a.py").unwrap();
+        let left = db
+            .most_complex(ComplexityMetric::Cyclomatic, None, 10)
+            .unwrap();
+        assert_eq!(left.len(), 1);
+    }
+
+    #[test]
+    fn test_search_prefers_higher_ranked_symbols() {
+        let db = Database::open_memory().unwrap();
+        let helper = test_symbol("parse_args", SymbolKind::Function, "a.py", 1);
+        let method = test_symbol("parse_config", SymbolKind::Method, "b.py", 1);
+        let unranked = test_symbol("parse_flag", SymbolKind::Function, "c.py", 1);
+        db.insert_symbols(&[helper.clone(), method.clone(), unranked])
+            .unwrap();
+        db.replace_ranks(&[
+            (helper.id.clone(), "a.py".to_string(), 0.4),
+            (method.id.clone(), "b.py".to_string(), 2.5),
+        ])
+        .unwrap();
+
+        let names: Vec<String> = db
+            .search("parse", None, None, 20)
+            .unwrap()
+            .into_iter()
+            .map(|s| s.name)
+            .collect();
+        assert_eq!(names, ["parse_config", "parse_args", "parse_flag"]);
+        let top = db
+            .top_ranked(Some(SymbolKind::Function), RankMetric::PageRank, 10)
+            .unwrap();
+        assert_eq!(
+            (top[0].0.name.as_str(), top[0].1.pagerank),
+            ("parse_args", 0.4)
+        );
+        assert_eq!(top.len(), 1);
+
+        db.replace_betweenness(&[(helper.id.clone(), "a.py".to_string(), 0.25)])
+            .unwrap();
+        let top = db.top_ranked(None, RankMetric::Betweenness, 10).unwrap();
+        let names: Vec<&str> = top.iter().map(|(s, _)| s.name.as_str()).collect();
+        assert_eq!(names, ["parse_args", "parse_config"]);
+        assert_eq!(top[0].1.betweenness, 0.25);
+        assert_eq!(top[1].1.betweenness, 0.0);
+
+        db.clear_file_data("b.py").unwrap();
+        assert_eq!(
+            db.top_ranked(None, RankMetric::PageRank, 10).unwrap().len(),
+            1
+        );
+    }
+
     #[test]
     fn test_search_definitions_outrank_variables() {
         let db = Database::open_memory().unwrap();
@@ -2168,4 +5489,194 @@ mod tests {
         let not_found = db.get_symbol("nonexistent").unwrap();
         assert!(not_found.is_none());
     }
+
+    #[test]
+    fn test_sync_sites_match_object_or_last_field() {
+        let db = Database::open_memory().unwrap();
+        let submit = test_symbol("Submit", SymbolKind::Method, "pool.go", 10);
+        let drain = test_symbol("drain", SymbolKind::Function, "pool.go", 20);
+        db.insert_symbols(&[submit.clone(), drain.clone()]).unwrap();
+        let site = |sym: &Symbol, line, op, object: &str| SyncSite {
+            symbol_id: sym.id.clone(),
+            line,
+            op,
+            object: object.to_string(),
+        };
+        db.insert_sync_sites(
+            "pool.go",
+            &[
+                site(&submit, 11, SyncOp::Send, "p.jobs"),
+                site(&drain, 21, SyncOp::Receive, "jobs"),
+                site(&drain, 22, SyncOp::Receive, "oldjobs"),
+            ],
+        )
+        .unwrap();
+
+        let found: Vec<_> = db
+            .sync_sites("jobs")
+            .unwrap()
+            .into_iter()
+            .map(|(sym, site)| (sym.name, site.op, site.object))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("drain".to_string(), SyncOp::Receive, "jobs".to_string()),
+                ("Submit".to_string(), SyncOp::Send, "p.jobs".to_string()),
+            ]
+        );
+        assert_eq!(db.sync_objects().unwrap()[0], ("jobs".to_string(), 1, 1));
+
+        db.clear_file_data("pool.go").unwrap();
+        assert!(db.sync_objects().unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_flag_checks_stay_in_the_flags_file() {
+        let db = Database::open_memory().unwrap();
+        let pay = test_symbol("Pay", SymbolKind::Function, "pay.go", 3);
+        let flag = test_symbol("new-checkout", SymbolKind::Flag, "pay.go", 4);
+        let other = test_symbol("render", SymbolKind::Function, "view.py", 1);
+        db.insert_symbols(&[pay.clone(), flag, other.clone()])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(&pay.id, "new-checkout", EdgeKind::References, "pay.go", 4),
+            Edge::new(&pay.id, "new-checkout", EdgeKind::References, "pay.go", 7),
+            // Same name, but no flag check in this file.
+            Edge::new(
+                &other.id,
+                "new-checkout",
+                EdgeKind::References,
+                "view.py",
+                2,
+            ),
+        ])
+        .unwrap();
+
+        let checks: Vec<_> = db
+            .flag_checks(None)
+            .unwrap()
+            .into_iter()
+            .map(|(flag, sym, line)| (flag, sym.name, line))
+            .collect();
+        assert_eq!(
+            checks,
+            [
+                ("new-checkout".to_string(), "Pay".to_string(), 4),
+                ("new-checkout".to_string(), "Pay".to_string(), 7),
+            ]
+        );
+        assert!(db.flag_checks(Some("dark-mode")).unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_routes_link_resolved_handlers() {
+        let db = Database::open_memory().unwrap();
+        let register = test_symbol("Register", SymbolKind::Function, "server/routes.go", 1);
+        let get_user = test_symbol("GetUser", SymbolKind::Method, "server/users.go", 10);
+        db.insert_symbols(&[register.clone(), get_user.clone()])
+            .unwrap();
+        db.insert_edge(&Edge::new(
+            &register.id,
+            "h.GetUser",
+            EdgeKind::References,
+            "server/routes.go",
+            3,
+        ))
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let route = |line, path: &str, handler: Option<&str>| Route {
+            symbol_id: register.id.clone(),
+            line,
+            method: "GET".to_string(),
+            path: path.to_string(),
+            handler: handler.map(str::to_string),
+            middleware: vec!["auth".to_string()],
+        };
+        db.insert_routes(
+            "server/routes.go",
+            &[
+                route(3, "/v1/users/:id", Some("h.GetUser")),
+                route(4, "/health", None),
+            ],
+        )
+        .unwrap();
+
+        let routes = db.routes(None).unwrap();
+        let found: Vec<_> = routes
+            .iter()
+            .map(|(route, _, handler)| {
+                (
+                    route.path.as_str(),
+                    handler.as_ref().map(|h| h.name.as_str()),
+                )
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [("/health", None), ("/v1/users/:id", Some("GetUser"))]
+        );
+        assert_eq!(routes[0].0.middleware, ["auth"]);
+        assert_eq!(routes[0].1.name, "Register");
+        assert_eq!(db.routes(Some("/v1")).unwrap().len(), 1);
+    }
+
+    #[test]
+    fn test_global_accesses_resolve_by_package() {
+        let db = Database::open_memory().unwrap();
+        let verbose = test_symbol("verbose", SymbolKind::Variable, "server/state.go", 3);
+        let debug = test_symbol("Debug", SymbolKind::Variable, "app/config/config.go", 5);
+        let handle = test_symbol("Handle", SymbolKind::Function, "server/handler.go", 10);
+        let run = test_symbol("run", SymbolKind::Function, "worker/run.go", 1);
+        db.insert_symbols(&[verbose.clone(), debug.clone(), handle.clone(), run.clone()])
+            .unwrap();
+        db.insert_variable_accesses("server/state.go", &[verbose.id.clone()], &[])
+            .unwrap();
+        db.insert_variable_accesses("app/config/config.go", &[debug.id.clone()], &[])
+            .unwrap();
+        let access =
+            |sym: &Symbol, line, name: &str, qualifier: Option<&str>, write| VariableAccess {
+                symbol_id: sym.id.clone(),
+                line,
+                name: name.to_string(),
+                qualifier: qualifier.map(str::to_string),
+                write,
+            };
+        db.insert_variable_accesses(
+            "server/handler.go",
+            &[],
+            &[
+                access(&handle, 11, "verbose", None, false),
+                access(&handle, 12, "Debug", Some("config"), true),
+            ],
+        )
+        .unwrap();
+        // Another package's `verbose` is a different variable.
+        db.insert_variable_accesses(
+            "worker/run.go",
+            &[],
+            &[access(&run, 2, "verbose", None, true)],
+        )
+        .unwrap();
+
+        let found: Vec<_> = db
+            .global_accesses(None)
+            .unwrap()
+            .into_iter()
+            .map(|(global, accessor, access)| {
+                (global.name, accessor.name, access.line, access.write)
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("Debug".to_string(), "Handle".to_string(), 12, true),
+                ("verbose".to_string(), "Handle".to_string(), 11, false),
+            ]
+        );
+        assert_eq!(db.global_accesses(Some("verbose")).unwrap().len(), 1);
+
+        db.clear_file_data("server/handler.go").unwrap();
+        assert!(db.global_accesses(None).unwrap().is_empty());
+    }
 }
@@ -0,0 +1,433 @@
+//! Deprecation burndown: symbols marked deprecated in their doc comment, every
+//! remaining use of them in the index, and who owns each side.
+//!
+//! A symbol is deprecated when its doc comment carries Go's `Deprecated:` marker;
+//! the text after it is kept as the migration note. Uses are resolved edges of
+//! any kind pointing at the symbol, except from the symbol itself. Owners come
+//! from CODEOWNERS when the project has one. Totals can be recorded in the index
+//! at each run, so the burndown can be followed over time.
+//!
+//! Go dependencies are checked from their own index: a package-level symbol
+//! deprecated there is used wherever the project calls it through the import of
+//! its package (`client.Dial` after `import "example.com/sdk/client"`), and not
+//! through a later major version of the module (`example.com/sdk/v2`). Method
+//! calls on values of a dependency's types cannot be traced back and are not seen.
+
+use std::collections::{BTreeMap, HashMap};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::deps_usage::{go_local_name, in_module};
+use crate::owners::CodeOwners;
+use crate::types::{EdgeKind, Symbol, SymbolKind};
+
+/// A remaining use of a deprecated symbol.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Usage {
+    /// The symbol making the use.
+    pub symbol: Symbol,
+    pub kind: EdgeKind,
+    pub file: String,
+    pub line: u32,
+    pub owners: Vec<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Deprecation {
+    pub symbol: Symbol,
+    /// The Go module it comes from; `None` for the project's own symbols.
+    pub module: Option<String>,
+    /// What the doc comment says after `Deprecated:`.
+    pub note: String,
+    pub owners: Vec<String>,
+    pub usages: Vec<Usage>,
+}
+
+/// Deprecation totals at one point in time.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BurndownPoint {
+    /// Unix seconds.
+    pub recorded_at: i64,
+    pub symbols: u32,
+    pub usages: u32,
+}
+
+/// The text after the `Deprecated:` marker in `docstring`, if it has one.
+pub fn deprecation_note(docstring: &str) -> Option<&str> {
+    let (_, note) = docstring.split_once("Deprecated:")?;
+    Some(note.trim())
+}
+
+/// Every deprecated symbol with its uses, by file and line. With `owner`, only
+/// symbols or uses owned by it are kept (a symbol with any such use stays, with
+/// just those uses).
+pub fn report(
+    db: &Database,
+    codeowners: Option<&CodeOwners>,
+    owner: Option<&str>,
+) -> Result<Vec<Deprecation>> {
+    let owners_of = |file: &str| -> Vec<String> {
+        codeowners.map_or_else(Vec::new, |c| c.owners_of(file).to_vec())
+    };
+    let owned = |owners: &[String]| owner.map_or(true, |o| owners.iter().any(|x| x == o));
+    let mut deprecations = Vec::new();
+    for symbol in db.deprecated_symbols()? {
+        let Some(note) = symbol.docstring.as_deref().and_then(deprecation_note) else {
+            continue;
+        };
+        let note = note.to_string();
+        let owners = owners_of(&symbol.file_path);
+        let mut usages: Vec<Usage> = db
+            .references_to(&symbol.id)?
+            .into_iter()
+            .filter(|(_, source)| source.id != symbol.id)
+            .map(|(edge, source)| Usage {
+                owners: owners_of(&edge.file_path),
+                symbol: source,
+                kind: edge.kind,
+                file: edge.file_path,
+                line: edge.line,
+            })
+            .collect();
+        if !owned(&owners) {
+            usages.retain(|u| owned(&u.owners));
+            if usages.is_empty() {
+                continue;
+            }
+        }
+        deprecations.push(Deprecation {
+            symbol,
+            module: None,
+            note,
+            owners,
+            usages,
+        });
+    }
+    Ok(deprecations)
+}
+
+/// Package-level symbols deprecated in the Go module `module`, indexed in `dep`,
+/// with the project's calls to them, by file and line. Symbols the project does
+/// not call are left out.
+pub fn dependency_report(
+    db: &Database,
+    dep: &Database,
+    module: &str,
+    codeowners: Option<&CodeOwners>,
+) -> Result<Vec<Deprecation>> {
+    let owners_of = |file: &str| -> Vec<String> {
+        codeowners.map_or_else(Vec::new, |c| c.owners_of(file).to_vec())
+    };
+    // (import path, name) -> deprecation.
+    let mut deprecations = Vec::new();
+    let mut by_name: HashMap<(String, String), usize> = HashMap::new();
+    for symbol in dep.deprecated_symbols()? {
+        if symbol.parent_id.is_some()
+            || !symbol.file_path.ends_with(".go")
+            || symbol.file_path.ends_with("_test.go")
+        {
+            continue;
+        }
+        let Some(note) = symbol.docstring.as_deref().and_then(deprecation_note) else {
+            continue;
+        };
+        let path = match symbol.file_path.rsplit_once('/') {
+            Some((dir, _)) => format!("{module}/{dir}"),
+            None => module.to_string(),
+        };
+        by_name.insert((path, symbol.name.clone()), deprecations.len());
+        deprecations.push(Deprecation {
+            note: note.to_string(),
+            owners: Vec::new(),
+            module: Some(module.to_string()),
+            symbol,
+            usages: Vec::new(),
+        });
+    }
+    if deprecations.is_empty() {
+        return Ok(deprecations);
+    }
+
+    // File -> local name -> import path, for the packages of the module.
+    let symbols = db.all_symbols()?;
+    let mut imported: HashMap<&str, HashMap<&str, &str>> = HashMap::new();
+    for import in symbols.iter().filter(|s| {
+        s.kind == SymbolKind::Import && s.file_path.ends_with(".go") && in_module(&s.name, module)
+    }) {
+        let Some(local) = go_local_name(&import.name, import.signature.as_deref()) else {
+            continue;
+        };
+        imported
+            .entry(import.file_path.as_str())
+            .or_default()
+            .insert(local, import.name.as_str());
+    }
+    let by_id: HashMap<&str, &Symbol> = symbols.iter().map(|s| (s.id.as_str(), s)).collect();
+    for edge in db.all_edges()? {
+        if edge.kind != EdgeKind::Calls || edge.target_id.is_some() {
+            continue;
+        }
+        let Some(names) = imported.get(edge.file_path.as_str()) else {
+            continue;
+        };
+        let call = edge.target_name.split('(').next().unwrap_or("");
+        let Some((qualifier, name)) = call.split_once('.') else {
+            continue;
+        };
+        let (Some(path), Some(source)) = (names.get(qualifier), by_id.get(edge.source_id.as_str()))
+        else {
+            continue;
+        };
+        let Some(&i) = by_name.get(&(path.to_string(), name.to_string())) else {
+            continue;
+        };
+        deprecations[i].usages.push(Usage {
+            owners: owners_of(&edge.file_path),
+            symbol: (*source).clone(),
+            kind: edge.kind,
+            file: edge.file_path,
+            line: edge.line,
+        });
+    }
+    deprecations.retain(|d| !d.usages.is_empty());
+    for d in &mut deprecations {
+        d.usages
+            .sort_by(|a, b| (&a.file, a.line).cmp(&(&b.file, b.line)));
+    }
+    Ok(deprecations)
+}
+
+/// Uses of deprecated symbols in one package (directory) of the project.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct PackageCount {
+    pub package: String,
+    pub usages: u32,
+}
+
+/// Uses per package of the project, most first, then by package.
+pub fn by_package(deprecations: &[Deprecation]) -> Vec<PackageCount> {
+    let mut counts: BTreeMap<&str, u32> = BTreeMap::new();
+    for usage in deprecations.iter().flat_map(|d| &d.usages) {
+        let package = usage.file.rsplit_once('/').map_or(".", |(dir, _)| dir);
+        *counts.entry(package).or_default() += 1;
+    }
+    let mut packages: Vec<PackageCount> = counts
+        .into_iter()
+        .map(|(package, usages)| PackageCount {
+            package: package.to_string(),
+            usages,
+        })
+        .collect();
+    // Stable, so packages with as many uses stay in path order.
+    packages.sort_by_key(|p| std::cmp::Reverse(p.usages));
+    packages
+}
+
+/// Store the totals of `deprecations` at `now` and return the whole history.
+pub fn record(db: &Database, deprecations: &[Deprecation], now: i64) -> Result<Vec<BurndownPoint>> {
+    let usages = deprecations.iter().map(|d| d.usages.len() as u32).sum();
+    db.record_deprecations(now, deprecations.len() as u32, usages)?;
+    db.deprecation_history()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, SymbolKind};
+
+    #[test]
+    fn test_report_lists_uses_with_owners_and_records_burndown() {
+        let db = Database::open_memory().unwrap();
+        let old = Symbol::new(
+            "OldClient",
+            SymbolKind::Function,
+            "internal/api/client.go",
+            5,
+            9,
+            0,
+            90,
+        )
+        .with_docstring(Some(
+            "OldClient dials the API. Deprecated: use NewClient, which retries.".to_string(),
+        ));
+        let mentioned = Symbol::new(
+            "NewClient",
+            SymbolKind::Function,
+            "internal/api/client.go",
+            12,
+            20,
+            100,
+            300,
+        )
+        .with_docstring(Some(
+            "NewClient replaces the deprecated OldClient.".to_string(),
+        ));
+        let main = Symbol::new("main", SymbolKind::Function, "cmd/cli/main.go", 3, 9, 0, 90);
+        let sync = Symbol::new(
+            "Sync",
+            SymbolKind::Function,
+            "internal/jobs/sync.go",
+            3,
+            9,
+            0,
+            90,
+        );
+        db.insert_symbols(&[old.clone(), mentioned, main.clone(), sync.clone()])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "OldClient", EdgeKind::Calls, "cmd/cli/main.go", 5),
+            Edge::new(
+                &sync.id,
+                "OldClient",
+                EdgeKind::Calls,
+                "internal/jobs/sync.go",
+                6,
+            ),
+            Edge::new(
+                &old.id,
+                "OldClient",
+                EdgeKind::Calls,
+                "internal/api/client.go",
+                7,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let codeowners = CodeOwners::parse("* @acme/core\n/cmd/ @acme/cli\n").unwrap();
+
+        let all = report(&db, Some(&codeowners), None).unwrap();
+        assert_eq!(all.len(), 1);
+        assert_eq!(all[0].note, "use NewClient, which retries.");
+        assert_eq!(all[0].owners, ["@acme/core"]);
+        let usages: Vec<_> = all[0]
+            .usages
+            .iter()
+            .map(|u| (u.symbol.name.as_str(), u.owners[0].as_str()))
+            .collect();
+        assert_eq!(usages, [("main", "@acme/cli"), ("Sync", "@acme/core")]);
+
+        let cli = report(&db, Some(&codeowners), Some("@acme/cli")).unwrap();
+        assert_eq!(cli[0].usages.len(), 1);
+        assert!(report(&db, Some(&codeowners), Some("@acme/web"))
+            .unwrap()
+            .is_empty());
+
+        record(&db, &all, 100).unwrap();
+        let history = record(&db, &cli, 200).unwrap();
+        let totals: Vec<_> = history.iter().map(|p| (p.recorded_at, p.usages)).collect();
+        assert_eq!(totals, [(100, 2), (200, 1)]);
+    }
+
+    #[test]
+    fn test_dependency_report_matches_calls_through_imports() {
+        let dep = Database::open_memory().unwrap();
+        let doc = |text: &str| Some(text.to_string());
+        let dial = Symbol::new("Dial", SymbolKind::Function, "client/dial.go", 5, 9, 0, 90)
+            .with_docstring(doc("Dial connects. Deprecated: use DialContext."));
+        let close = Symbol::new(
+            "Close",
+            SymbolKind::Function,
+            "client/dial.go",
+            12,
+            14,
+            100,
+            140,
+        )
+        .with_docstring(doc("Close closes. Deprecated: not needed anymore."));
+        let root = Symbol::new("Version", SymbolKind::Function, "sdk.go", 3, 4, 0, 40)
+            .with_docstring(doc("Deprecated: read the module version instead."));
+        dep.insert_symbols(&[dial, close, root]).unwrap();
+
+        let db = Database::open_memory().unwrap();
+        let import = |file: &str, spec: &str, path: &str| {
+            Symbol::new(path, SymbolKind::Import, file, 3, 3, 10, 40)
+                .with_signature(Some(spec.to_string()))
+        };
+        let run = Symbol::new(
+            "Run",
+            SymbolKind::Function,
+            "cmd/app/main.go",
+            6,
+            12,
+            50,
+            200,
+        );
+        let sync = Symbol::new(
+            "Sync",
+            SymbolKind::Function,
+            "internal/jobs/sync.go",
+            6,
+            12,
+            50,
+            200,
+        );
+        db.insert_symbols(&[
+            import(
+                "cmd/app/main.go",
+                "\"example.com/sdk/client\"",
+                "example.com/sdk/client",
+            ),
+            import("cmd/app/main.go", "\"example.com/sdk\"", "example.com/sdk"),
+            import(
+                "internal/jobs/sync.go",
+                "c \"example.com/sdk/client\"",
+                "example.com/sdk/client",
+            ),
+            run.clone(),
+            sync.clone(),
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &run.id,
+                "client.Dial",
+                EdgeKind::Calls,
+                "cmd/app/main.go",
+                7,
+            ),
+            Edge::new(
+                &run.id,
+                "sdk.Version",
+                EdgeKind::Calls,
+                "cmd/app/main.go",
+                8,
+            ),
+            Edge::new(
+                &sync.id,
+                "c.Dial",
+                EdgeKind::Calls,
+                "internal/jobs/sync.go",
+                7,
+            ),
+            // Not imported under that name in this file.
+            Edge::new(
+                &sync.id,
+                "client.Dial",
+                EdgeKind::Calls,
+                "internal/jobs/sync.go",
+                8,
+            ),
+        ])
+        .unwrap();
+
+        let found = dependency_report(&db, &dep, "example.com/sdk", None).unwrap();
+        let names: Vec<_> = found
+            .iter()
+            .map(|d| (d.symbol.name.as_str(), d.usages.len()))
+            .collect();
+        assert_eq!(names, [("Dial", 2), ("Version", 1)]);
+        assert_eq!(found[0].module.as_deref(), Some("example.com/sdk"));
+        assert_eq!(found[0].note, "use DialContext.");
+
+        let packages: Vec<_> = by_package(&found)
+            .into_iter()
+            .map(|p| (p.package, p.usages))
+            .collect();
+        assert_eq!(
+            packages,
+            [("cmd/app".to_string(), 2), ("internal/jobs".to_string(), 1)]
+        );
+    }
+}
@@ -0,0 +1,653 @@
+//! Third-party usage report: which external modules the project imports, from
+//! which files, and how often it calls into them.
+//!
+//! An import is external when it is not relative and resolves to nothing in the
+//! index. Go imports are instead judged against `go.mod`: paths under its module
+//! are internal, and so are those a `replace` points at a directory of the
+//! project. The others are grouped by the longest `require` they fall under, so
+//! every package of one dependency is counted together, with the version
+//! required and the replacement if any. A major version is a module of its own:
+//! `foo/v2/x` never falls under a `require` of `foo`. Go standard library
+//! packages (no dot in the first path segment) are left out unless asked for.
+//! Other languages are grouped by the first segment of the import.
+//!
+//! Calls are counted when they go through a name the import binds in that file:
+//! `cobra.Command{...}` for a Go import of cobra, `get(...)` after Python's
+//! `from requests import get`. Method calls on values of external types cannot
+//! be traced back and are not counted.
+
+use std::collections::{BTreeMap, HashMap};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{EdgeKind, SymbolKind};
+
+/// Most called APIs listed per module.
+const TOP_APIS: usize = 10;
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct FileUsage {
+    pub file: String,
+    pub calls: u32,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ApiUsage {
+    /// The callee as written, e.g. `cobra.Command`.
+    pub name: String,
+    pub calls: u32,
+}
+
+/// One external module and how the project uses it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ModuleUsage {
+    pub module: String,
+    /// The version `go.mod` requires.
+    pub version: Option<String>,
+    /// Where a `replace` sends the module: a directory, or `path@version`.
+    pub replacement: Option<String>,
+    /// Go standard library.
+    pub stdlib: bool,
+    pub calls: u32,
+    /// Importing files, most calls first.
+    pub files: Vec<FileUsage>,
+    /// Most called APIs first.
+    pub apis: Vec<ApiUsage>,
+}
+
+/// What `go.mod` declares.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct GoMod {
+    pub module: Option<String>,
+    pub requires: Vec<Require>,
+    pub replaces: Vec<Replace>,
+}
+
+/// A required module and its version.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Require {
+    pub path: String,
+    pub version: String,
+}
+
+/// A `replace` directive. Without `version` it applies to every version of `path`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Replace {
+    pub path: String,
+    pub version: Option<String>,
+    /// A directory (`../fork`) or another module path.
+    pub new_path: String,
+    /// Absent for a directory.
+    pub new_version: Option<String>,
+}
+
+impl Replace {
+    /// Whether the replacement is a directory rather than a module.
+    pub fn is_local(&self) -> bool {
+        let p = self.new_path.as_str();
+        p == "." || p == ".." || p.starts_with("./") || p.starts_with("../") || p.starts_with('/')
+    }
+
+    /// `../fork` or `example.com/fork@v1.2.0`.
+    fn target(&self) -> String {
+        match &self.new_version {
+            Some(version) => format!("{}@{version}", self.new_path),
+            None => self.new_path.clone(),
+        }
+    }
+}
+
+/// Where the package of a Go import path lives.
+#[derive(Debug, Clone, PartialEq)]
+pub enum GoPackage {
+    /// A directory of the project, relative to `go.mod`.
+    Project(String),
+    /// A dependency: the module path, the version required and the replacement.
+    Module {
+        module: String,
+        version: Option<String>,
+        replacement: Option<String>,
+    },
+    Stdlib,
+}
+
+impl GoMod {
+    /// The package behind `import`: one of the project's directories (its own
+    /// module, or a module a `replace` sends into the project), a dependency, or
+    /// the standard library.
+    pub fn resolve(&self, import: &str) -> GoPackage {
+        if let Some(module) = self.module.as_deref() {
+            if let Some(rest) = import.strip_prefix(module) {
+                if rest.is_empty() || rest.starts_with('/') {
+                    return GoPackage::Project(rest.trim_start_matches('/').to_string());
+                }
+            }
+        }
+        let replaced = self
+            .replaces
+            .iter()
+            .filter(|r| r.is_local() && in_module(import, &r.path))
+            .max_by_key(|r| r.path.len());
+        if let Some(dir) = replaced.and_then(|r| {
+            let rest = &import[r.path.len()..];
+            project_dir(&format!("{}{rest}", r.new_path))
+        }) {
+            return GoPackage::Project(dir);
+        }
+        let required = self
+            .requires
+            .iter()
+            .filter(|r| in_module(import, &r.path))
+            .max_by_key(|r| r.path.len());
+        if let Some(required) = required {
+            let replacement = self
+                .replaces
+                .iter()
+                .filter(|r| r.path == required.path)
+                .find(|r| r.version.as_ref().map_or(true, |v| *v == required.version))
+                .map(Replace::target);
+            return GoPackage::Module {
+                module: required.path.clone(),
+                version: Some(required.version.clone()),
+                replacement,
+            };
+        }
+        if !import.split('/').next().unwrap_or(import).contains('.') {
+            return GoPackage::Stdlib;
+        }
+        let replacement = replaced.map(Replace::target);
+        GoPackage::Module {
+            module: replaced.map_or(import, |r| r.path.as_str()).to_string(),
+            version: None,
+            replacement,
+        }
+    }
+}
+
+/// Read the module path, required modules and replacements from a `go.mod`.
+pub fn parse_go_mod(text: &str) -> GoMod {
+    let mut go_mod = GoMod::default();
+    let mut block = "";
+    for line in text.lines() {
+        let line = line.split("//").next().unwrap_or("").trim();
+        let (directive, spec) = if !block.is_empty() {
+            if line == ")" {
+                block = "";
+                continue;
+            }
+            (block, line)
+        } else if let Some(rest) = line.strip_prefix("module ") {
+            go_mod.module = Some(rest.trim().trim_matches('"').to_string());
+            continue;
+        } else if let Some((directive, rest)) = line.split_once(char::is_whitespace) {
+            if rest.trim() == "(" {
+                block = if directive == "require" || directive == "replace" {
+                    directive
+                } else {
+                    "skip"
+                };
+                continue;
+            }
+            (directive, rest.trim())
+        } else {
+            continue;
+        };
+        let words: Vec<&str> = spec
+            .split_whitespace()
+            .map(|w| w.trim_matches('"'))
+            .collect();
+        match (directive, words.as_slice()) {
+            ("require", [path, version, ..]) => go_mod.requires.push(Require {
+                path: path.to_string(),
+                version: version.to_string(),
+            }),
+            ("replace", [path, rest @ ..]) => {
+                let (version, rest) = match rest {
+                    ["=>", rest @ ..] => (None, rest),
+                    [version, "=>", rest @ ..] => (Some(version.to_string()), rest),
+                    _ => continue,
+                };
+                let Some(new_path) = rest.first() else {
+                    continue;
+                };
+                go_mod.replaces.push(Replace {
+                    path: path.to_string(),
+                    version,
+                    new_path: new_path.to_string(),
+                    new_version: rest.get(1).map(|v| v.to_string()),
+                });
+            }
+            _ => {}
+        }
+    }
+    go_mod
+}
+
+/// Whether the import `path` is in the module `module`: `module` itself or a
+/// package under it, but not a later major version (`module/v2/...`), which is
+/// a module of its own.
+pub(crate) fn in_module(path: &str, module: &str) -> bool {
+    let Some(rest) = path.strip_prefix(module) else {
+        return false;
+    };
+    if rest.is_empty() {
+        return true;
+    }
+    let Some(rest) = rest.strip_prefix('/') else {
+        return false;
+    };
+    let first = rest.split('/').next().unwrap_or(rest);
+    let major = first
+        .strip_prefix('v')
+        .filter(|n| !n.is_empty() && !n.starts_with('0') && n.bytes().all(|b| b.is_ascii_digit()));
+    !major.is_some_and(|n| n != "1")
+}
+
+/// `dir` relative to the project root, normalized; `None` when it leaves the
+/// project or is absolute.
+fn project_dir(dir: &str) -> Option<String> {
+    if dir.starts_with('/') {
+        return None;
+    }
+    let mut parts: Vec<&str> = Vec::new();
+    for part in dir.split('/') {
+        match part {
+            "" | "." => {}
+            ".." => {
+                parts.pop()?;
+            }
+            part => parts.push(part),
+        }
+    }
+    Some(parts.join("/"))
+}
+
+/// The name a Go import binds in its file: the alias of the import spec, or the
+/// package name. `None` for blank and dot imports.
+pub(crate) fn go_local_name<'a>(path: &'a str, spec: Option<&'a str>) -> Option<&'a str> {
+    match spec.and_then(|s| s.split_whitespace().next()) {
+        Some("_" | ".") => None,
+        Some(alias) if !alias.starts_with(['"', '`']) => Some(alias),
+        _ => Some(go_package_name(path)),
+    }
+}
+
+/// The name a Go import path binds without an alias: its last segment, minus a
+/// major version (`.../v2`, `yaml.v3`).
+pub(crate) fn go_package_name(path: &str) -> &str {
+    let mut segments = path.rsplit('/');
+    let last = segments.next().unwrap_or(path);
+    let is_version = |s: &str| {
+        s.strip_prefix('v')
+            .is_some_and(|n| !n.is_empty() && n.bytes().all(|b| b.is_ascii_digit()))
+    };
+    let name = if is_version(last) {
+        segments.next().unwrap_or(last)
+    } else {
+        last
+    };
+    match name.rsplit_once('.') {
+        Some((base, version)) if is_version(version) => base,
+        _ => name,
+    }
+}
+
+/// A module as the report groups imports under it.
+#[derive(Debug, Clone, PartialEq)]
+struct Module {
+    path: String,
+    version: Option<String>,
+    replacement: Option<String>,
+    stdlib: bool,
+}
+
+impl Module {
+    fn named(path: &str) -> Self {
+        Self {
+            path: path.to_string(),
+            version: None,
+            replacement: None,
+            stdlib: false,
+        }
+    }
+}
+
+/// The dependency a Go import path belongs to; `None` for the project's own
+/// packages.
+fn go_module(path: &str, go_mod: &GoMod) -> Option<Module> {
+    match go_mod.resolve(path) {
+        GoPackage::Project(_) => None,
+        GoPackage::Module {
+            module,
+            version,
+            replacement,
+        } => Some(Module {
+            path: module,
+            version,
+            replacement,
+            stdlib: false,
+        }),
+        GoPackage::Stdlib => Some(Module {
+            stdlib: true,
+            ..Module::named(path)
+        }),
+    }
+}
+
+/// Group name for a non-Go import: `@scope/name` or the first segment.
+fn module_root(import: &str) -> &str {
+    if import.starts_with('@') {
+        let end = import
+            .match_indices('/')
+            .nth(1)
+            .map_or(import.len(), |(i, _)| i);
+        return &import[..end];
+    }
+    import
+        .split(|c| c == '/' || c == '.' || c == ':')
+        .next()
+        .unwrap_or(import)
+}
+
+/// External modules by calls, then importing files. Go standard library only
+/// with `include_stdlib`.
+pub fn usage(db: &Database, go_mod: &GoMod, include_stdlib: bool) -> Result<Vec<ModuleUsage>> {
+    let edges = db.all_edges()?;
+    let mut bound: HashMap<&str, Vec<&str>> = HashMap::new();
+    let mut resolved: HashMap<&str, bool> = HashMap::new();
+    for edge in edges.iter().filter(|e| e.kind == EdgeKind::Imports) {
+        bound
+            .entry(edge.source_id.as_str())
+            .or_default()
+            .push(&edge.target_name);
+        *resolved.entry(edge.source_id.as_str()).or_default() |= edge.target_id.is_some();
+    }
+
+    // File -> local name -> module.
+    let mut names: HashMap<String, HashMap<String, usize>> = HashMap::new();
+    let mut modules: Vec<Module> = Vec::new();
+    let mut by_module: BTreeMap<usize, BTreeMap<String, u32>> = BTreeMap::new();
+    for import in db.all_symbols()? {
+        if import.kind != SymbolKind::Import
+            || import.name.starts_with('.')
+            || ["crate", "self", "super"]
+                .iter()
+                .any(|p| import.name.split("::").next() == Some(*p))
+        {
+            continue;
+        }
+        let (module, locals) = if import.file_path.ends_with(".go") {
+            let Some(module) = go_module(&import.name, go_mod) else {
+                continue;
+            };
+            let local = go_local_name(&import.name, import.signature.as_deref());
+            (module, local.map(str::to_string).into_iter().collect())
+        } else {
+            if resolved.get(import.id.as_str()).copied().unwrap_or(false) {
+                continue;
+            }
+            let locals = bound
+                .get(import.id.as_str())
+                .map(|b| b.iter().map(|n| n.to_string()).collect())
+                .unwrap_or_default();
+            (Module::named(module_root(&import.name)), locals)
+        };
+        if module.stdlib && !include_stdlib {
+            continue;
+        }
+        let index = match modules.iter().position(|m| m.path == module.path) {
+            Some(i) => i,
+            None => {
+                modules.push(module);
+                modules.len() - 1
+            }
+        };
+        by_module
+            .entry(index)
+            .or_default()
+            .entry(import.file_path.clone())
+            .or_default();
+        let file_names = names.entry(import.file_path).or_default();
+        for local in locals {
+            file_names.insert(local, index);
+        }
+    }
+
+    let mut apis: HashMap<usize, HashMap<&str, u32>> = HashMap::new();
+    for edge in &edges {
+        if edge.kind != EdgeKind::Calls || edge.target_id.is_some() {
+            continue;
+        }
+        let Some(file_names) = names.get(&edge.file_path) else {
+            continue;
+        };
+        // `os.path.join` may go through `os.path.join`, `os.path` or `os`.
+        let target = edge.target_name.as_str();
+        let module = std::iter::once(target)
+            .chain(target.rmatch_indices('.').map(|(i, _)| &target[..i]))
+            .find_map(|name| file_names.get(name));
+        if let Some(&module) = module {
+            *by_module
+                .entry(module)
+                .or_default()
+                .entry(edge.file_path.clone())
+                .or_default() += 1;
+            *apis.entry(module).or_default().entry(target).or_default() += 1;
+        }
+    }
+
+    let mut report: Vec<ModuleUsage> = by_module
+        .into_iter()
+        .map(|(index, files)| {
+            let mut files: Vec<FileUsage> = files
+                .into_iter()
+                .map(|(file, calls)| FileUsage { file, calls })
+                .collect();
+            // Stable, so files with as many calls stay in path order.
+            files.sort_by_key(|f| std::cmp::Reverse(f.calls));
+            let mut module_apis: Vec<ApiUsage> = apis
+                .remove(&index)
+                .unwrap_or_default()
+                .into_iter()
+                .map(|(name, calls)| ApiUsage {
+                    name: name.to_string(),
+                    calls,
+                })
+                .collect();
+            module_apis.sort_by(|a, b| b.calls.cmp(&a.calls).then_with(|| a.name.cmp(&b.name)));
+            module_apis.truncate(TOP_APIS);
+            let Module {
+                path,
+                version,
+                replacement,
+                stdlib,
+            } = modules[index].clone();
+            ModuleUsage {
+                module: path,
+                version,
+                replacement,
+                stdlib,
+                calls: files.iter().map(|f| f.calls).sum(),
+                files,
+                apis: module_apis,
+            }
+        })
+        .collect();
+    report.sort_by(|a, b| {
+        (b.calls, b.files.len())
+            .cmp(&(a.calls, a.files.len()))
+            .then_with(|| a.module.cmp(&b.module))
+    });
+    Ok(report)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, Symbol};
+
+    #[test]
+    fn test_usage_groups_go_packages_by_required_module() {
+        let go_mod = parse_go_mod(
+            "module github.com/acme/shop
+
+go 1.22
+
+require github.com/spf13/cobra v1.8.0
+
+require (
+\tgithub.com/aws/aws-sdk-go-v2 v1.30.0
+\tgopkg.in/yaml.v3 v3.0.1 // indirect
+)
+",
+        );
+        assert_eq!(go_mod.module.as_deref(), Some("github.com/acme/shop"));
+        assert_eq!(go_mod.requires.len(), 3);
+
+        let db = Database::open_memory().unwrap();
+        let import = |file: &str, path: &str, line: u32, text: &str| {
+            Symbol::new(path, SymbolKind::Import, file, line, line, 0, 10)
+                .with_signature(Some(text.to_string()))
+        };
+        let root = "cmd/root.go";
+        let upload = "internal/files/upload.go";
+        let imports = [
+            import(
+                root,
+                "github.com/spf13/cobra",
+                3,
+                "\"github.com/spf13/cobra\"",
+            ),
+            import(root, "fmt", 4, "\"fmt\""),
+            import(
+                root,
+                "github.com/acme/shop/internal/files",
+                5,
+                "\"github.com/acme/shop/internal/files\"",
+            ),
+            import(
+                upload,
+                "github.com/aws/aws-sdk-go-v2/service/s3",
+                3,
+                "s3svc \"github.com/aws/aws-sdk-go-v2/service/s3\"",
+            ),
+            import(
+                upload,
+                "github.com/aws/aws-sdk-go-v2/aws",
+                4,
+                "\"github.com/aws/aws-sdk-go-v2/aws\"",
+            ),
+            import(upload, "gopkg.in/yaml.v3", 5, "\"gopkg.in/yaml.v3\""),
+        ];
+        let run = Symbol::new("Execute", SymbolKind::Function, root, 8, 20, 0, 300);
+        let put = Symbol::new("Upload", SymbolKind::Function, upload, 8, 20, 0, 300);
+        db.insert_symbols(&imports).unwrap();
+        db.insert_symbols(&[run.clone(), put.clone()]).unwrap();
+        let call = |source: &Symbol, target: &str, line: u32| {
+            Edge::new(&source.id, target, EdgeKind::Calls, &source.file_path, line)
+        };
+        db.insert_edges(&[
+            call(&run, "cobra.Command", 10),
+            call(&run, "cobra.OnInitialize", 11),
+            call(&run, "cobra.OnInitialize", 12),
+            call(&run, "fmt.Println", 13),
+            call(&put, "s3svc.NewFromConfig", 10),
+            call(&put, "aws.String", 11),
+            call(&put, "yaml.Unmarshal", 12),
+            call(&put, "strings.TrimSpace", 13),
+        ])
+        .unwrap();
+
+        let report = usage(&db, &go_mod, false).unwrap();
+        let summary: Vec<_> = report
+            .iter()
+            .map(|m| (m.module.as_str(), m.calls, m.files.len()))
+            .collect();
+        assert_eq!(
+            summary,
+            [
+                ("github.com/spf13/cobra", 3, 1),
+                ("github.com/aws/aws-sdk-go-v2", 2, 1),
+                ("gopkg.in/yaml.v3", 1, 1),
+            ]
+        );
+        assert_eq!(
+            report[0].apis[0],
+            ApiUsage {
+                name: "cobra.OnInitialize".to_string(),
+                calls: 2,
+            }
+        );
+
+        let with_std = usage(&db, &go_mod, true).unwrap();
+        let fmt = with_std.iter().find(|m| m.module == "fmt").unwrap();
+        assert!(fmt.stdlib);
+        assert_eq!(fmt.calls, 1);
+        assert_eq!(go_package_name("github.com/x/y/v2"), "y");
+        assert_eq!(module_root("@aws-sdk/client-s3/dist"), "@aws-sdk/client-s3");
+        assert_eq!(module_root("requests.adapters"), "requests");
+    }
+
+    #[test]
+    fn test_replace_directives_and_major_versions() {
+        let go_mod = parse_go_mod(
+            "module github.com/acme/shop
+
+require (
+	github.com/acme/money v1.4.0
+	github.com/acme/money/v2 v2.1.0
+	github.com/acme/ledger v0.9.0
+	github.com/old/log v1.0.0
+)
+
+replace github.com/acme/ledger => ./third_party/ledger
+
+replace (
+	github.com/acme/money/v2 v2.1.0 => ../money-v2
+	github.com/old/log => github.com/new/log v1.2.0
+)
+",
+        );
+        assert_eq!(go_mod.requires.len(), 4);
+        assert_eq!(go_mod.replaces.len(), 3);
+        assert_eq!(go_mod.replaces[1].version.as_deref(), Some("v2.1.0"));
+
+        let module = |module: &str, version: &str, replacement: Option<&str>| GoPackage::Module {
+            module: module.to_string(),
+            version: Some(version.to_string()),
+            replacement: replacement.map(str::to_string),
+        };
+        // v1 and v2 of the same module stay apart.
+        assert_eq!(
+            go_mod.resolve("github.com/acme/money/currency"),
+            module("github.com/acme/money", "v1.4.0", None)
+        );
+        assert_eq!(
+            go_mod.resolve("github.com/acme/money/v2/currency"),
+            module("github.com/acme/money/v2", "v2.1.0", Some("../money-v2"))
+        );
+        assert_eq!(
+            go_mod.resolve("github.com/old/log"),
+            module(
+                "github.com/old/log",
+                "v1.0.0",
+                Some("github.com/new/log@v1.2.0")
+            )
+        );
+        // A replacement inside the project is the project's own code.
+        assert_eq!(
+            go_mod.resolve("github.com/acme/ledger/entries"),
+            GoPackage::Project("third_party/ledger/entries".to_string())
+        );
+        assert_eq!(
+            go_mod.resolve("github.com/acme/shop/internal/files"),
+            GoPackage::Project("internal/files".to_string())
+        );
+        assert_eq!(go_mod.resolve("net/http"), GoPackage::Stdlib);
+        assert!(in_module("github.com/x/y/v1/z", "github.com/x/y"));
+        assert!(!in_module("github.com/x/y/v3", "github.com/x/y"));
+        assert!(!in_module("github.com/x/yz", "github.com/x/y"));
+    }
+}
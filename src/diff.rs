@@ -0,0 +1,552 @@
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::path::{Path, PathBuf};
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+use sha2::{Digest, Sha256};
+
+use crate::db::{Database, DB_FILE};
+use crate::git::{self, TempWorktree};
+use crate::indexer;
+use crate::types::{EdgeKind, Symbol, SymbolKind};
+
+/// How a symbol changed between two snapshots.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ChangeKind {
+    Added,
+    Removed,
+    SignatureChanged,
+    BodyChanged,
+}
+
+impl ChangeKind {
+    /// Single-character marker used in human-readable output.
+    pub fn marker(&self) -> char {
+        match self {
+            Self::Added => '+',
+            Self::Removed => '-',
+            Self::SignatureChanged | Self::BodyChanged => '~',
+        }
+    }
+}
+
+/// A symbol present in only one snapshot, or present in both with different content.
+#[derive(Debug, Clone, Serialize)]
+pub struct SymbolChange {
+    pub change: ChangeKind,
+    /// Name qualified by its parent chain (e.g. `AuthService.login`).
+    pub qualified_name: String,
+    pub kind: SymbolKind,
+    pub file_path: String,
+    /// Line in the new snapshot (old snapshot for removed symbols).
+    pub line: u32,
+    pub old_signature: Option<String>,
+    pub new_signature: Option<String>,
+}
+
+/// An edge present in only one snapshot. Line numbers are ignored when matching.
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+pub struct EdgeChange {
+    pub file_path: String,
+    /// Qualified name of the source symbol.
+    pub source: String,
+    pub target: String,
+    pub kind: EdgeKind,
+}
+
+/// Symbol- and edge-level difference between two index snapshots.
+#[derive(Debug, Default, Serialize)]
+pub struct IndexDiff {
+    pub from: String,
+    pub to: String,
+    pub symbols: Vec<SymbolChange>,
+    pub edges_added: Vec<EdgeChange>,
+    pub edges_removed: Vec<EdgeChange>,
+}
+
+impl IndexDiff {
+    /// Number of symbol changes of the given kind.
+    pub fn count(&self, change: ChangeKind) -> usize {
+        self.symbols.iter().filter(|c| c.change == change).count()
+    }
+}
+
+/// A symbol as seen in one snapshot, keyed independently of its line number.
+struct SnapshotSymbol {
+    symbol: Symbol,
+    qualified_name: String,
+    /// SHA-256 of the stored source content, when the symbol has any.
+    body_hash: Option<String>,
+}
+
+/// In-memory view of one index, keyed for line-independent comparison.
+struct Snapshot {
+    symbols: BTreeMap<String, SnapshotSymbol>,
+    edges: BTreeSet<EdgeChange>,
+}
+
+impl Snapshot {
+    fn load(db: &Database) -> Result<Self> {
+        let all = db.all_symbols()?;
+        let by_id: HashMap<&str, &Symbol> = all.iter().map(|s| (s.id.as_str(), s)).collect();
+
+        let ids: Vec<String> = all.iter().map(|s| s.id.clone()).collect();
+        let contents = db.get_symbol_contents_batch(&ids)?;
+
+        let mut qualified_by_id: HashMap<String, String> = HashMap::with_capacity(all.len());
+        let mut symbols = BTreeMap::new();
+        for sym in &all {
+            let qualified = qualified_name(sym, &by_id);
+            let base_key = format!("{}\0{}\0{}", sym.file_path, sym.kind, qualified);
+            // Disambiguate same-named siblings (e.g. several `init` funcs in one file)
+            // by occurrence order, which is stable across line shifts.
+            let mut key = base_key.clone();
+            let mut n = 2;
+            while symbols.contains_key(&key) {
+                key = format!("{base_key}#{n}");
+                n += 1;
+            }
+            let body_hash = contents.get(&sym.id).map(|(content, _)| {
+                let mut hasher = Sha256::new();
+                hasher.update(content.as_bytes());
+                format!("{:x}", hasher.finalize())
+            });
+            qualified_by_id.insert(sym.id.clone(), qualified.clone());
+            symbols.insert(
+                key,
+                SnapshotSymbol {
+                    symbol: sym.clone(),
+                    qualified_name: qualified,
+                    body_hash,
+                },
+            );
+        }
+
+        let edges = db
+            .all_edges()?
+            .into_iter()
+            .map(|e| EdgeChange {
+                source: qualified_by_id
+                    .get(&e.source_id)
+                    .cloned()
+                    .unwrap_or(e.source_id),
+                target: e.target_name,
+                kind: e.kind,
+                file_path: e.file_path,
+            })
+            .collect();
+
+        Ok(Self { symbols, edges })
+    }
+}
+
+/// Build `Parent.child` names by walking the parent chain.
+fn qualified_name(sym: &Symbol, by_id: &HashMap<&str, &Symbol>) -> String {
+    let mut parts = vec![sym.name.as_str()];
+    let mut parent = sym.parent_id.as_deref();
+    // Bounded walk guards against malformed parent cycles.
+    while let Some(pid) = parent {
+        if parts.len() > 16 {
+            break;
+        }
+        match by_id.get(pid) {
+            Some(p) => {
+                parts.push(p.name.as_str());
+                parent = p.parent_id.as_deref();
+            }
+            None => break,
+        }
+    }
+    parts.reverse();
+    parts.join(".")
+}
+
+/// Compare two indexes symbol by symbol.
+///
+/// Symbols are matched by `(file, kind, qualified name)`, so moving a function
+/// within its file is not reported as a change. A matched symbol is reported as
+/// `signature_changed` when its signature differs, otherwise `body_changed` when
+/// its stored source content (or line span, if no content is stored) differs.
+pub fn diff_databases(old: &Database, new: &Database) -> Result<IndexDiff> {
+    let old = Snapshot::load(old).context("failed to load old snapshot")?;
+    let new = Snapshot::load(new).context("failed to load new snapshot")?;
+
+    let mut symbols = Vec::new();
+    for (key, o) in &old.symbols {
+        match new.symbols.get(key) {
+            None => symbols.push(change(ChangeKind::Removed, o, Some(o), None)),
+            Some(n) => {
+                if o.symbol.signature != n.symbol.signature {
+                    symbols.push(change(ChangeKind::SignatureChanged, n, Some(o), Some(n)));
+                } else if body_differs(o, n) {
+                    symbols.push(change(ChangeKind::BodyChanged, n, Some(o), Some(n)));
+                }
+            }
+        }
+    }
+    for (key, n) in &new.symbols {
+        if !old.symbols.contains_key(key) {
+            symbols.push(change(ChangeKind::Added, n, None, Some(n)));
+        }
+    }
+    symbols.sort_by(|a, b| {
+        (a.file_path.as_str(), a.line, a.change).cmp(&(b.file_path.as_str(), b.line, b.change))
+    });
+
+    let edges_added = new.edges.difference(&old.edges).cloned().collect();
+    let edges_removed = old.edges.difference(&new.edges).cloned().collect();
+
+    Ok(IndexDiff {
+        from: String::new(),
+        to: String::new(),
+        symbols,
+        edges_added,
+        edges_removed,
+    })
+}
+
+fn body_differs(o: &SnapshotSymbol, n: &SnapshotSymbol) -> bool {
+    match (&o.body_hash, &n.body_hash) {
+        (Some(a), Some(b)) => a != b,
+        (None, None) => {
+            o.symbol.end_line.saturating_sub(o.symbol.start_line)
+                != n.symbol.end_line.saturating_sub(n.symbol.start_line)
+        }
+        _ => true,
+    }
+}
+
+fn change(
+    kind: ChangeKind,
+    at: &SnapshotSymbol,
+    old: Option<&SnapshotSymbol>,
+    new: Option<&SnapshotSymbol>,
+) -> SymbolChange {
+    SymbolChange {
+        change: kind,
+        qualified_name: at.qualified_name.clone(),
+        kind: at.symbol.kind,
+        file_path: at.symbol.file_path.clone(),
+        line: at.symbol.start_line,
+        old_signature: old.and_then(|s| s.symbol.signature.clone()),
+        new_signature: new.and_then(|s| s.symbol.signature.clone()),
+    }
+}
+
+/// A symbol of the newer index, with how it changed since the older one.
+#[derive(Debug, Clone, Serialize)]
+pub struct OutlineEntry {
+    #[serde(flatten)]
+    pub symbol: Symbol,
+    /// `None` when the symbol is unchanged.
+    pub change: Option<ChangeKind>,
+}
+
+/// Outline of some files as they are now, annotated against a revision.
+#[derive(Debug, Default, Serialize)]
+pub struct OutlineDiff {
+    pub rev: String,
+    /// Symbols of the newer index, ordered by file and line.
+    pub symbols: Vec<OutlineEntry>,
+    /// Symbols of the older index that are gone.
+    pub removed: Vec<SymbolChange>,
+}
+
+impl OutlineDiff {
+    /// Number of current symbols matching `pred`.
+    pub fn count(&self, pred: impl Fn(ChangeKind) -> bool) -> usize {
+        self.symbols
+            .iter()
+            .filter(|e| e.change.is_some_and(&pred))
+            .count()
+    }
+}
+
+/// Outline of the files `in_scope` accepts, each symbol of `new` marked with its
+/// change since `old`, plus the symbols removed from those files.
+pub fn outline_against(
+    old: &Database,
+    new: &Database,
+    in_scope: impl Fn(&str) -> bool,
+) -> Result<OutlineDiff> {
+    let diff = diff_databases(old, new)?;
+    let (removed, changed): (Vec<SymbolChange>, Vec<SymbolChange>) = diff
+        .symbols
+        .into_iter()
+        .filter(|c| in_scope(&c.file_path))
+        .partition(|c| c.change == ChangeKind::Removed);
+    // Added and changed symbols are reported at their line in the newer index.
+    let symbols = new
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| in_scope(&s.file_path))
+        .map(|symbol| OutlineEntry {
+            change: changed
+                .iter()
+                .find(|c| {
+                    (c.file_path.as_str(), c.line, c.kind)
+                        == (symbol.file_path.as_str(), symbol.start_line, symbol.kind)
+                })
+                .map(|c| c.change),
+            symbol,
+        })
+        .collect();
+    Ok(OutlineDiff {
+        rev: String::new(),
+        symbols,
+        removed,
+    })
+}
+
+/// Compare two snapshots identified by `from` and `to`.
+///
+/// Each side is either a path to an existing index file (e.g. `.cartog.db`) or a
+/// git revision, which is checked out into a temporary worktree and indexed from scratch.
+pub fn diff_refs(repo: &Path, from: &str, to: &str) -> Result<IndexDiff> {
+    let mut diff = with_snapshot_db(repo, from, |old| {
+        with_snapshot_db(repo, to, |new| diff_databases(old, new))
+    })?;
+    diff.from = from.to_string();
+    diff.to = to.to_string();
+    Ok(diff)
+}
+
+/// Directory (under the repository root) holding per-commit index snapshots.
+pub const SNAPSHOT_DIR: &str = ".cartog/snapshots";
+
+/// Environment variable naming a shared snapshot directory (network mount, CI
+/// artifact cache) consulted before building a snapshot locally.
+pub const REMOTE_CACHE_ENV: &str = "CARTOG_SNAPSHOT_CACHE";
+
+/// Where a commit's index snapshot came from.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SnapshotSource {
+    /// Already present in the local snapshot cache.
+    Cached,
+    /// Copied from the shared cache named by [`REMOTE_CACHE_ENV`].
+    Remote,
+    /// Checked out into a temporary worktree and indexed.
+    Built,
+}
+
+impl SnapshotSource {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Cached => "cached",
+            Self::Remote => "remote cache",
+            Self::Built => "built",
+        }
+    }
+}
+
+/// Path of the cached snapshot for `commit`.
+pub fn snapshot_path(repo: &Path, commit: &str) -> PathBuf {
+    repo.join(SNAPSHOT_DIR).join(format!("{commit}.db"))
+}
+
+/// Make sure an index snapshot exists for `rev`, returning its commit and path.
+///
+/// Snapshots are immutable per commit, so a cached one is always reused.
+pub fn ensure_snapshot(repo: &Path, rev: &str) -> Result<(String, PathBuf, SnapshotSource)> {
+    let commit = git::resolve_commit(repo, rev)?;
+    let path = snapshot_path(repo, &commit);
+    if path.is_file() {
+        return Ok((commit, path, SnapshotSource::Cached));
+    }
+    let dir = path.parent().expect("snapshot path has a parent");
+    std::fs::create_dir_all(dir).with_context(|| format!("failed to create {}", dir.display()))?;
+
+    // Build (or copy) next to the final path, then rename: readers never see a partial file.
+    let tmp = path.with_extension(format!("db.{}.tmp", std::process::id()));
+    let _ = std::fs::remove_file(&tmp);
+
+    let remote = std::env::var_os(REMOTE_CACHE_ENV)
+        .map(|d| PathBuf::from(d).join(format!("{commit}.db")))
+        .filter(|p| p.is_file());
+    let source = match remote {
+        Some(remote) => {
+            std::fs::copy(&remote, &tmp)
+                .with_context(|| format!("failed to copy {}", remote.display()))?;
+            SnapshotSource::Remote
+        }
+        None => {
+            let worktree = TempWorktree::checkout(repo, &commit)?;
+            let db = Database::open(&tmp)?;
+            indexer::index_directory(&db, worktree.path(), true)
+                .with_context(|| format!("failed to index revision '{rev}'"))?;
+            // Closing the last connection checkpoints the WAL into the main file.
+            drop(db);
+            SnapshotSource::Built
+        }
+    };
+    std::fs::rename(&tmp, &path)
+        .with_context(|| format!("failed to store snapshot {}", path.display()))?;
+    Ok((commit, path, source))
+}
+
+/// Open the index for `spec` (index file path or git revision) and run `f` against it.
+///
+/// An index file is the caller's, so it is opened read-only and must be at the
+/// current schema. Revisions with a cached snapshot (see [`ensure_snapshot`])
+/// are opened directly.
+pub fn with_snapshot_db<T>(
+    repo: &Path,
+    spec: &str,
+    f: impl FnOnce(&Database) -> Result<T>,
+) -> Result<T> {
+    let as_path = Path::new(spec);
+    if as_path.is_file() {
+        let db = Database::open_read_only(as_path)
+            .with_context(|| format!("failed to open index '{spec}'"))?;
+        return f(&db);
+    }
+
+    if let Ok(commit) = git::resolve_commit(repo, spec) {
+        let cached = snapshot_path(repo, &commit);
+        if cached.is_file() {
+            let db = Database::open(&cached)
+                .with_context(|| format!("failed to open snapshot for '{spec}'"))?;
+            return f(&db);
+        }
+    }
+
+    let worktree = TempWorktree::checkout(repo, spec)?;
+    let result = {
+        let db = Database::open(worktree.path().join(DB_FILE))?;
+        indexer::index_directory(&db, worktree.path(), true)
+            .with_context(|| format!("failed to index revision '{spec}'"))?;
+        f(&db)
+    };
+    drop(worktree);
+    result
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::Edge;
+
+    fn sym(name: &str, file: &str, line: u32, sig: &str) -> Symbol {
+        Symbol::new(name, SymbolKind::Function, file, line, line + 3, 0, 10)
+            .with_signature(Some(sig.to_string()))
+    }
+
+    #[test]
+    fn test_diff_added_removed_and_signature() {
+        let old = Database::open_memory().unwrap();
+        let new = Database::open_memory().unwrap();
+
+        old.insert_symbols(&[sym("keep", "a.py", 1, "()"), sym("gone", "a.py", 10, "()")])
+            .unwrap();
+        // `keep` moved down a few lines with a new parameter; `fresh` is new.
+        new.insert_symbols(&[
+            sym("keep", "a.py", 4, "(x)"),
+            sym("fresh", "a.py", 20, "()"),
+        ])
+        .unwrap();
+
+        let diff = diff_databases(&old, &new).unwrap();
+        assert_eq!(diff.count(ChangeKind::Added), 1);
+        assert_eq!(diff.count(ChangeKind::Removed), 1);
+        assert_eq!(diff.count(ChangeKind::SignatureChanged), 1);
+
+        let changed = diff
+            .symbols
+            .iter()
+            .find(|c| c.change == ChangeKind::SignatureChanged)
+            .unwrap();
+        assert_eq!(changed.qualified_name, "keep");
+        assert_eq!(changed.old_signature.as_deref(), Some("()"));
+        assert_eq!(changed.new_signature.as_deref(), Some("(x)"));
+    }
+
+    #[test]
+    fn test_diff_line_shift_is_not_a_change() {
+        let old = Database::open_memory().unwrap();
+        let new = Database::open_memory().unwrap();
+        old.insert_symbol(&sym("f", "a.py", 1, "()")).unwrap();
+        new.insert_symbol(&sym("f", "a.py", 7, "()")).unwrap();
+
+        let diff = diff_databases(&old, &new).unwrap();
+        assert!(diff.symbols.is_empty());
+    }
+
+    #[test]
+    fn test_diff_edges() {
+        let old = Database::open_memory().unwrap();
+        let new = Database::open_memory().unwrap();
+        let a_old = sym("run", "a.py", 1, "()");
+        let a_new = sym("run", "a.py", 2, "()");
+        old.insert_symbol(&a_old).unwrap();
+        new.insert_symbol(&a_new).unwrap();
+        old.insert_edge(&Edge::new(&a_old.id, "helper", EdgeKind::Calls, "a.py", 2))
+            .unwrap();
+        new.insert_edge(&Edge::new(&a_new.id, "other", EdgeKind::Calls, "a.py", 3))
+            .unwrap();
+
+        let diff = diff_databases(&old, &new).unwrap();
+        assert_eq!(diff.edges_added.len(), 1);
+        assert_eq!(diff.edges_added[0].source, "run");
+        assert_eq!(diff.edges_added[0].target, "other");
+        assert_eq!(diff.edges_removed.len(), 1);
+        assert_eq!(diff.edges_removed[0].target, "helper");
+    }
+
+    #[test]
+    fn test_qualified_name_uses_parent_chain() {
+        let db_old = Database::open_memory().unwrap();
+        let db_new = Database::open_memory().unwrap();
+        let class = Symbol::new("Service", SymbolKind::Class, "s.py", 1, 20, 0, 100);
+        let method = Symbol::new("login", SymbolKind::Method, "s.py", 3, 8, 10, 50)
+            .with_parent(Some(class.id.as_str()));
+        db_new.insert_symbols(&[class, method]).unwrap();
+
+        let diff = diff_databases(&db_old, &db_new).unwrap();
+        let names: Vec<&str> = diff
+            .symbols
+            .iter()
+            .map(|c| c.qualified_name.as_str())
+            .collect();
+        assert_eq!(names, vec!["Service", "Service.login"]);
+    }
+
+    #[test]
+    fn test_outline_against_marks_changes_in_scope() {
+        let old = Database::open_memory().unwrap();
+        let new = Database::open_memory().unwrap();
+        old.insert_symbols(&[
+            sym("keep", "a.py", 1, "()"),
+            sym("gone", "a.py", 10, "()"),
+            sym("other", "b.py", 1, "()"),
+        ])
+        .unwrap();
+        new.insert_symbols(&[
+            sym("keep", "a.py", 4, "(x)"),
+            sym("fresh", "a.py", 20, "()"),
+            sym("other", "b.py", 1, "(y)"),
+        ])
+        .unwrap();
+
+        let outline = outline_against(&old, &new, |f| f == "a.py").unwrap();
+        let got: Vec<(&str, Option<ChangeKind>)> = outline
+            .symbols
+            .iter()
+            .map(|e| (e.symbol.name.as_str(), e.change))
+            .collect();
+        assert_eq!(
+            got,
+            [
+                ("keep", Some(ChangeKind::SignatureChanged)),
+                ("fresh", Some(ChangeKind::Added)),
+            ]
+        );
+        assert_eq!(outline.removed.len(), 1);
+        assert_eq!(outline.removed[0].qualified_name, "gone");
+        assert_eq!(outline.count(|c| c == ChangeKind::Added), 1);
+
+        let unchanged = outline_against(&new, &new, |_| true).unwrap();
+        assert!(unchanged.symbols.iter().all(|e| e.change.is_none()));
+    }
+}
@@ -0,0 +1,963 @@
+//! Generated documentation: an architecture overview rendered from the index as
+//! Markdown with Mermaid diagrams, meant to be regenerated rather than edited.
+//!
+//! Packages are directories, cut to a number of leading path segments so large
+//! trees stay readable (`internal/api/handlers` is `internal/api` at depth 2).
+//! A package depends on another when a resolved edge crosses from one to the
+//! other; key types are the classes, structs and interfaces with the most
+//! resolved references from other files.
+//!
+//! A package summary narrows this to one directory: its public API, its
+//! non-public types, and the packages on either side of it. The dependencies
+//! section is meant for a README: it lives between marker comments, so it can be
+//! regenerated in place and checked in CI.
+//!
+//! The glossary takes the most referenced types as the domain's vocabulary, with
+//! their doc comments and how they use each other: edges from a type or any of
+//! its members (Go methods included, matched by receiver) to another term.
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::fmt::Write as _;
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{Edge, EdgeKind, Symbol, SymbolKind, Visibility};
+
+/// A directory of indexed files.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Package {
+    pub path: String,
+    pub files: u32,
+    /// Symbols other than imports.
+    pub symbols: u32,
+}
+
+/// Resolved edges from files in one package to symbols in another.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Dependency {
+    pub from: String,
+    pub to: String,
+    pub edges: u32,
+}
+
+/// A type and how many resolved references it gets from other files.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct KeyType {
+    pub symbol: Symbol,
+    pub dependents: u32,
+}
+
+/// Where execution starts: a `main` function or an HTTP route.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct EntryPoint {
+    /// `main`, or the route's method and path (`GET /users/{id}`).
+    pub name: String,
+    /// The function that runs, as written; `None` for an inline handler.
+    pub handler: Option<String>,
+    pub file: String,
+    pub line: u32,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Architecture {
+    pub packages: Vec<Package>,
+    pub dependencies: Vec<Dependency>,
+    pub key_types: Vec<KeyType>,
+    pub entry_points: Vec<EntryPoint>,
+}
+
+/// The package of `file`: its directory, cut to `depth` segments (all of them
+/// for 0). Files at the root are in `.`.
+pub fn package_of(file: &str, depth: usize) -> String {
+    let Some((dir, _)) = file.rsplit_once('/') else {
+        return ".".to_string();
+    };
+    if depth == 0 {
+        return dir.to_string();
+    }
+    dir.split('/').take(depth).collect::<Vec<_>>().join("/")
+}
+
+/// Resolved edges between different packages, most edges first.
+pub fn dependencies(db: &Database, depth: usize) -> Result<Vec<Dependency>> {
+    let mut counts: BTreeMap<(String, String), u32> = BTreeMap::new();
+    for (edge, _, target_file) in db.edges_with_endpoints()? {
+        let Some(target_file) = target_file else {
+            continue;
+        };
+        let from = package_of(&edge.file_path, depth);
+        let to = package_of(&target_file, depth);
+        if from != to {
+            *counts.entry((from, to)).or_default() += 1;
+        }
+    }
+    let mut dependencies: Vec<Dependency> = counts
+        .into_iter()
+        .map(|((from, to), edges)| Dependency { from, to, edges })
+        .collect();
+    // Stable, so equal counts stay ordered by package.
+    dependencies.sort_by_key(|d| std::cmp::Reverse(d.edges));
+    Ok(dependencies)
+}
+
+/// Packages at `depth` with their file and symbol counts, by path.
+pub fn packages(db: &Database, depth: usize) -> Result<Vec<Package>> {
+    let mut packages: BTreeMap<String, Package> = BTreeMap::new();
+    for file in db.all_files()? {
+        let path = package_of(&file, depth);
+        packages
+            .entry(path.clone())
+            .or_insert(Package {
+                path,
+                files: 0,
+                symbols: 0,
+            })
+            .files += 1;
+    }
+    for symbol in db.all_symbols()? {
+        if symbol.kind == SymbolKind::Import {
+            continue;
+        }
+        if let Some(package) = packages.get_mut(&package_of(&symbol.file_path, depth)) {
+            package.symbols += 1;
+        }
+    }
+    Ok(packages.into_values().collect())
+}
+
+/// The `limit` types among `symbols` with the most resolved references from
+/// other files, most referenced first.
+pub(crate) fn rank_types(
+    symbols: &[Symbol],
+    edges: &[(Edge, String, Option<String>)],
+    limit: usize,
+) -> Vec<KeyType> {
+    let types: HashMap<&str, &Symbol> = symbols
+        .iter()
+        .filter(|s| s.kind == SymbolKind::Class)
+        .map(|s| (s.id.as_str(), s))
+        .collect();
+    let mut dependents: HashMap<&str, u32> = HashMap::new();
+    for (edge, _, target_file) in edges {
+        let Some(target) = edge.target_id.as_deref().and_then(|id| types.get(id)) else {
+            continue;
+        };
+        if target_file.as_deref() != Some(edge.file_path.as_str()) {
+            *dependents.entry(target.id.as_str()).or_default() += 1;
+        }
+    }
+    let mut ranked: Vec<KeyType> = dependents
+        .into_iter()
+        .map(|(id, dependents)| KeyType {
+            symbol: types[id].clone(),
+            dependents,
+        })
+        .collect();
+    ranked.sort_by(|a, b| {
+        b.dependents
+            .cmp(&a.dependents)
+            .then_with(|| a.symbol.file_path.cmp(&b.symbol.file_path))
+            .then_with(|| a.symbol.start_line.cmp(&b.symbol.start_line))
+    });
+    ranked.truncate(limit);
+    ranked
+}
+
+/// Read the overview off the index: packages at `depth`, and the `key_types`
+/// most referenced types.
+pub fn architecture(db: &Database, depth: usize, key_types: usize) -> Result<Architecture> {
+    let symbols = db.all_symbols()?;
+    let ranked = rank_types(&symbols, &db.edges_with_endpoints()?, key_types);
+
+    let mut entry_points: Vec<EntryPoint> = symbols
+        .iter()
+        .filter(|s| s.kind == SymbolKind::Function && s.name == "main")
+        .map(|s| EntryPoint {
+            name: "main".to_string(),
+            handler: None,
+            file: s.file_path.clone(),
+            line: s.start_line,
+        })
+        .collect();
+    for (route, registrar, _) in db.routes(None)? {
+        entry_points.push(EntryPoint {
+            name: format!("{} {}", route.method, route.path),
+            handler: route.handler,
+            file: registrar.file_path,
+            line: route.line,
+        });
+    }
+
+    Ok(Architecture {
+        packages: packages(db, depth)?,
+        dependencies: dependencies(db, depth)?,
+        key_types: ranked,
+        entry_points,
+    })
+}
+
+/// A Mermaid `graph LR` of `dependencies`, each edge labelled with its count.
+pub fn mermaid(dependencies: &[Dependency]) -> String {
+    let mut ids: BTreeMap<&str, usize> = BTreeMap::new();
+    for d in dependencies {
+        for package in [d.from.as_str(), d.to.as_str()] {
+            let next = ids.len();
+            ids.entry(package).or_insert(next);
+        }
+    }
+    let mut out = String::from("graph LR\n");
+    for (package, id) in &ids {
+        let _ = writeln!(out, "    p{id}[\"{}\"]", package.replace('"', "'"));
+    }
+    for d in dependencies {
+        let _ = writeln!(
+            out,
+            "    p{} -->|{}| p{}",
+            ids[d.from.as_str()],
+            d.edges,
+            ids[d.to.as_str()]
+        );
+    }
+    out
+}
+
+/// The overview as a Markdown document.
+pub fn render_markdown(arch: &Architecture) -> String {
+    let mut out = String::from("# Architecture\n\n");
+    out.push_str(
+        "<!-- Generated by `cartog doc architecture`; regenerate instead of editing. -->\n\n",
+    );
+
+    out.push_str("## Packages\n\n| Package | Files | Symbols |\n|---|---:|---:|\n");
+    for p in &arch.packages {
+        let _ = writeln!(out, "| `{}` | {} | {} |", p.path, p.files, p.symbols);
+    }
+
+    out.push_str("\n## Dependencies\n\n");
+    if arch.dependencies.is_empty() {
+        out.push_str("No resolved dependencies between packages.\n");
+    } else {
+        let _ = write!(out, "```mermaid\n{}```\n", mermaid(&arch.dependencies));
+    }
+
+    out.push_str("\n## Key types\n\n");
+    if arch.key_types.is_empty() {
+        out.push_str("No types referenced from other files.\n");
+    } else {
+        out.push_str("| Type | Defined in | Dependents |\n|---|---|---:|\n");
+        for t in &arch.key_types {
+            let _ = writeln!(
+                out,
+                "| `{}` | `{}:{}` | {} |",
+                t.symbol.name, t.symbol.file_path, t.symbol.start_line, t.dependents
+            );
+        }
+    }
+
+    out.push_str("\n## Entry points\n\n");
+    if arch.entry_points.is_empty() {
+        out.push_str("No `main` functions or HTTP routes found.\n");
+    } else {
+        out.push_str("| Entry point | Handler | Location |\n|---|---|---|\n");
+        for e in &arch.entry_points {
+            let _ = writeln!(
+                out,
+                "| `{}` | {} | `{}:{}` |",
+                e.name,
+                e.handler
+                    .as_deref()
+                    .map_or("-".to_string(), |h| format!("`{h}`")),
+                e.file,
+                e.line
+            );
+        }
+    }
+    out
+}
+
+/// How a glossary term relates to another.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum RelationKind {
+    Inherits,
+    InheritedBy,
+    /// Calls or references, from the type or one of its members.
+    Uses,
+    UsedBy,
+}
+
+impl RelationKind {
+    pub fn label(self) -> &'static str {
+        match self {
+            Self::Inherits => "inherits",
+            Self::InheritedBy => "inherited by",
+            Self::Uses => "uses",
+            Self::UsedBy => "used by",
+        }
+    }
+
+    fn reverse(self) -> Self {
+        match self {
+            Self::Inherits => Self::InheritedBy,
+            Self::InheritedBy => Self::Inherits,
+            Self::Uses => Self::UsedBy,
+            Self::UsedBy => Self::Uses,
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Relation {
+    pub kind: RelationKind,
+    /// The other term's name.
+    pub term: String,
+    pub edges: u32,
+}
+
+/// A glossary entry: a key type, its doc comment (on `symbol`), and its
+/// relations to the other terms.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Term {
+    pub symbol: Symbol,
+    pub dependents: u32,
+    pub relations: Vec<Relation>,
+}
+
+/// The `limit` most referenced types as glossary terms, most referenced first.
+pub fn glossary(db: &Database, limit: usize) -> Result<Vec<Term>> {
+    let symbols = db.all_symbols()?;
+    let edges = db.edges_with_endpoints()?;
+    let ranked = rank_types(&symbols, &edges, limit);
+
+    let terms: HashMap<&str, usize> = ranked
+        .iter()
+        .enumerate()
+        .map(|(i, t)| (t.symbol.id.as_str(), i))
+        .collect();
+    let parents: HashMap<&str, Option<&str>> = symbols
+        .iter()
+        .map(|s| (s.id.as_str(), s.parent_id.as_deref()))
+        .collect();
+    // The term a symbol belongs to: itself or its nearest enclosing term. Go
+    // methods name their receiver as `file:Type`, which is no symbol id, so
+    // that form is matched by name within the file's package.
+    let owner = |id: &str| -> Option<usize> {
+        let mut current = id;
+        loop {
+            if let Some(&i) = terms.get(current) {
+                return Some(i);
+            }
+            match parents.get(current) {
+                Some(parent) => current = (*parent)?,
+                None => {
+                    let (file, name) = current.rsplit_once(':')?;
+                    return ranked.iter().position(|t| {
+                        t.symbol.name == name
+                            && package_of(&t.symbol.file_path, 0) == package_of(file, 0)
+                    });
+                }
+            }
+        }
+    };
+
+    let mut counts: BTreeMap<(usize, RelationKind, usize), u32> = BTreeMap::new();
+    for (edge, _, _) in &edges {
+        let Some(to) = edge.target_id.as_deref().and_then(|id| terms.get(id)) else {
+            continue;
+        };
+        let Some(from) = owner(&edge.source_id).filter(|from| from != to) else {
+            continue;
+        };
+        let kind = match edge.kind {
+            EdgeKind::Inherits => RelationKind::Inherits,
+            EdgeKind::Calls | EdgeKind::References => RelationKind::Uses,
+            EdgeKind::Imports | EdgeKind::Raises => continue,
+        };
+        *counts.entry((from, kind, *to)).or_default() += 1;
+        *counts.entry((*to, kind.reverse(), from)).or_default() += 1;
+    }
+
+    let names: Vec<String> = ranked.iter().map(|t| t.symbol.name.clone()).collect();
+    let mut glossary: Vec<Term> = ranked
+        .into_iter()
+        .map(|t| Term {
+            symbol: t.symbol,
+            dependents: t.dependents,
+            relations: Vec::new(),
+        })
+        .collect();
+    for ((from, kind, to), edges) in counts {
+        glossary[from].relations.push(Relation {
+            kind,
+            term: names[to].clone(),
+            edges,
+        });
+    }
+    Ok(glossary)
+}
+
+/// The glossary as a Markdown document, one section per term.
+pub fn render_glossary(terms: &[Term]) -> String {
+    let mut out = String::from("# Glossary\n\n");
+    out.push_str("<!-- Generated by `cartog doc glossary`; regenerate instead of editing. -->\n");
+    if terms.is_empty() {
+        out.push_str("\nNo types referenced from other files.\n");
+    }
+    for t in terms {
+        let _ = write!(
+            out,
+            "\n## {}\n\n{} in `{}:{}`, {} references from other files.\n",
+            t.symbol.name, t.symbol.kind, t.symbol.file_path, t.symbol.start_line, t.dependents
+        );
+        if let Some(doc) = t.symbol.docstring.as_deref().map(str::trim) {
+            if !doc.is_empty() {
+                let _ = write!(out, "\n{doc}\n");
+            }
+        }
+        if !t.relations.is_empty() {
+            out.push('\n');
+        }
+        for r in &t.relations {
+            let _ = writeln!(out, "- {} `{}` ({} edges)", r.kind.label(), r.term, r.edges);
+        }
+    }
+    out
+}
+
+/// A module imported from outside the project, and how many files import it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct External {
+    pub module: String,
+    pub files: u32,
+}
+
+/// Markers around the generated part of a README or doc file.
+pub const SECTION_START: &str = "<!-- cartog:dependencies:start -->";
+pub const SECTION_END: &str = "<!-- cartog:dependencies:end -->";
+
+/// The `limit` imports most files use that resolve to nothing in the index,
+/// relative imports aside.
+pub fn externals(db: &Database, limit: usize) -> Result<Vec<External>> {
+    let mut importers: BTreeMap<String, HashSet<String>> = BTreeMap::new();
+    for (edge, _, target_file) in db.edges_with_endpoints()? {
+        if edge.kind != EdgeKind::Imports
+            || target_file.is_some()
+            || edge.target_name.starts_with('.')
+        {
+            continue;
+        }
+        importers
+            .entry(edge.target_name)
+            .or_default()
+            .insert(edge.file_path);
+    }
+    let mut externals: Vec<External> = importers
+        .into_iter()
+        .map(|(module, files)| External {
+            module,
+            files: files.len() as u32,
+        })
+        .collect();
+    // Stable, so equally used modules stay in name order.
+    externals.sort_by_key(|e| std::cmp::Reverse(e.files));
+    externals.truncate(limit);
+    Ok(externals)
+}
+
+/// The body of the dependencies section: a Mermaid graph and a table of package
+/// dependencies, then the notable external modules.
+pub fn render_dependencies(dependencies: &[Dependency], externals: &[External]) -> String {
+    let mut out = String::from("### Internal packages\n\n");
+    if dependencies.is_empty() {
+        out.push_str("No resolved dependencies between packages.\n");
+    } else {
+        let _ = write!(out, "```mermaid\n{}```\n\n", mermaid(dependencies));
+        out.push_str("| Package | Depends on | Edges |\n|---|---|---:|\n");
+        for d in dependencies {
+            let _ = writeln!(out, "| `{}` | `{}` | {} |", d.from, d.to, d.edges);
+        }
+    }
+    out.push_str("\n### External modules\n\n");
+    if externals.is_empty() {
+        out.push_str("None.\n");
+    } else {
+        out.push_str("| Module | Importing files |\n|---|---:|\n");
+        for e in externals {
+            let _ = writeln!(out, "| `{}` | {} |", e.module, e.files);
+        }
+    }
+    out
+}
+
+/// `text` with the part between the section markers replaced by `body`. Without
+/// markers, a `## Dependencies` heading and the marked section are appended.
+pub fn replace_section(text: &str, body: &str) -> Result<String> {
+    let section = format!("{SECTION_START}\n{body}{SECTION_END}");
+    let Some(start) = text.find(SECTION_START) else {
+        let separator = if text.is_empty() || text.ends_with("\n\n") {
+            ""
+        } else if text.ends_with('\n') {
+            "\n"
+        } else {
+            "\n\n"
+        };
+        return Ok(format!("{text}{separator}## Dependencies\n\n{section}\n"));
+    };
+    let end = text[start..]
+        .find(SECTION_END)
+        .map(|at| start + at + SECTION_END.len())
+        .with_context(|| format!("{SECTION_START} without a following {SECTION_END}"))?;
+    Ok(format!("{}{section}{}", &text[..start], &text[end..]))
+}
+
+/// What one package offers and what it is tied to.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct PackageSummary {
+    pub package: String,
+    pub files: Vec<String>,
+    /// Public symbols outside test files, by file and line.
+    pub exported: Vec<Symbol>,
+    /// Non-public types, most referenced first.
+    pub internal_types: Vec<KeyType>,
+    /// Packages this one uses.
+    pub dependencies: Vec<Dependency>,
+    /// Packages using this one.
+    pub dependents: Vec<Dependency>,
+}
+
+/// Summary of the package in directory `package`: files directly inside it, not
+/// in its subdirectories, as `search --package` takes it.
+pub fn package_summary(db: &Database, package: &str) -> Result<PackageSummary> {
+    let package = package.trim_end_matches('/');
+    let files: Vec<String> = db
+        .all_files()?
+        .into_iter()
+        .filter(|f| package_of(f, 0) == package)
+        .collect();
+    let mut exported = Vec::new();
+    let mut internal_types = Vec::new();
+    for file in &files {
+        let test_file = file.ends_with("_test.go");
+        for symbol in db.outline(file)? {
+            match (symbol.kind, symbol.visibility) {
+                (SymbolKind::Import, _) => {}
+                (_, Visibility::Public) if !test_file => exported.push(symbol),
+                (SymbolKind::Class, Visibility::Private | Visibility::Protected) => {
+                    internal_types.push(KeyType {
+                        dependents: db.reference_count(&symbol.id)?,
+                        symbol,
+                    });
+                }
+                _ => {}
+            }
+        }
+    }
+    // Stable, so equally referenced types stay in file and line order.
+    internal_types.sort_by_key(|t| std::cmp::Reverse(t.dependents));
+
+    let (dependencies, dependents) = dependencies(db, 0)?
+        .into_iter()
+        .filter(|d| d.from == package || d.to == package)
+        .partition(|d| d.from == package);
+    Ok(PackageSummary {
+        package: package.to_string(),
+        files,
+        exported,
+        internal_types,
+        dependencies,
+        dependents,
+    })
+}
+
+/// Plain-text rendering of a package summary.
+pub fn render_package_text(summary: &PackageSummary) -> String {
+    let mut lines = vec![format!(
+        "package {}  ({} files)",
+        summary.package,
+        summary.files.len()
+    )];
+    lines.push("exported:".to_string());
+    for s in &summary.exported {
+        lines.push(format!(
+            "  {kind} {name}{sig}  {file}:{line}",
+            kind = s.kind,
+            name = s.name,
+            sig = s.signature.as_deref().unwrap_or(""),
+            file = s.file_path,
+            line = s.start_line,
+        ));
+    }
+    lines.push("internal types:".to_string());
+    for t in &summary.internal_types {
+        lines.push(format!(
+            "  {}  {}:{}  {} refs",
+            t.symbol.name, t.symbol.file_path, t.symbol.start_line, t.dependents
+        ));
+    }
+    lines.push("depends on:".to_string());
+    for d in &summary.dependencies {
+        lines.push(format!("  {}  {} edges", d.to, d.edges));
+    }
+    lines.push("used by:".to_string());
+    for d in &summary.dependents {
+        lines.push(format!("  {}  {} edges", d.from, d.edges));
+    }
+    lines.join("\n") + "\n"
+}
+
+/// The package summary as a Markdown document.
+pub fn render_package_markdown(summary: &PackageSummary) -> String {
+    let mut out = format!("# `{}`\n\n", summary.package);
+    out.push_str(
+        "<!-- Generated by `cartog outline --package`; regenerate instead of editing. -->\n\n",
+    );
+    let files: Vec<String> = summary.files.iter().map(|f| format!("`{f}`")).collect();
+    let _ = writeln!(out, "Files: {}\n", files.join(", "));
+
+    out.push_str("## Exported API\n\n");
+    if summary.exported.is_empty() {
+        out.push_str("Nothing exported.\n");
+    }
+    for s in &summary.exported {
+        let _ = writeln!(
+            out,
+            "- {kind} `{name}{sig}` ({file}:{line})",
+            kind = s.kind,
+            name = s.name,
+            sig = s.signature.as_deref().unwrap_or(""),
+            file = s.file_path,
+            line = s.start_line,
+        );
+        if let Some(doc) = s.docstring.as_deref().and_then(|d| d.lines().next()) {
+            let _ = writeln!(out, "  {}", doc.trim());
+        }
+    }
+
+    out.push_str("\n## Key internal types\n\n");
+    if summary.internal_types.is_empty() {
+        out.push_str("None.\n");
+    }
+    for t in &summary.internal_types {
+        let _ = writeln!(
+            out,
+            "- `{}` ({}:{}), {} references",
+            t.symbol.name, t.symbol.file_path, t.symbol.start_line, t.dependents
+        );
+    }
+
+    for (title, deps, outgoing) in [
+        ("Dependencies", &summary.dependencies, true),
+        ("Dependents", &summary.dependents, false),
+    ] {
+        let _ = write!(out, "\n## {title}\n\n");
+        if deps.is_empty() {
+            out.push_str("None.\n");
+        }
+        for d in deps {
+            let other = if outgoing { &d.to } else { &d.from };
+            let _ = writeln!(out, "- `{other}` ({} edges)", d.edges);
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, FileInfo};
+
+    #[test]
+    fn test_architecture_packages_dependencies_and_key_types() {
+        let db = Database::open_memory().unwrap();
+        let files = [
+            "cmd/server/main.go",
+            "internal/api/users.go",
+            "internal/api/handlers/orders.go",
+            "internal/store/user.go",
+        ];
+        for path in files {
+            db.upsert_file(&FileInfo {
+                path: path.to_string(),
+                last_modified: 0.0,
+                hash: String::new(),
+                language: "go".to_string(),
+                num_symbols: 1,
+            })
+            .unwrap();
+        }
+        let main = Symbol::new("main", SymbolKind::Function, files[0], 5, 9, 0, 50);
+        let get_user = Symbol::new("GetUser", SymbolKind::Function, files[1], 3, 9, 0, 90);
+        let order = Symbol::new("ListOrders", SymbolKind::Function, files[2], 3, 9, 0, 90);
+        let user = Symbol::new("User", SymbolKind::Class, files[3], 3, 8, 0, 80);
+        let row = Symbol::new("userRow", SymbolKind::Class, files[3], 10, 14, 90, 160)
+            .with_visibility(Visibility::Private);
+        db.insert_symbols(&[main.clone(), get_user.clone(), order.clone(), user, row])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "GetUser", EdgeKind::Calls, files[0], 7),
+            Edge::new(&get_user.id, "User", EdgeKind::References, files[1], 5),
+            Edge::new(&order.id, "User", EdgeKind::References, files[2], 6),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let arch = architecture(&db, 2, 10).unwrap();
+        let packages: Vec<_> = arch
+            .packages
+            .iter()
+            .map(|p| (p.path.as_str(), p.files))
+            .collect();
+        assert_eq!(
+            packages,
+            [
+                ("cmd/server", 1),
+                ("internal/api", 2),
+                ("internal/store", 1)
+            ]
+        );
+        assert_eq!(
+            arch.dependencies[0],
+            Dependency {
+                from: "internal/api".to_string(),
+                to: "internal/store".to_string(),
+                edges: 2,
+            }
+        );
+        assert_eq!(arch.key_types[0].symbol.name, "User");
+        assert_eq!(arch.key_types[0].dependents, 2);
+        assert_eq!(arch.entry_points[0].file, "cmd/server/main.go");
+
+        let markdown = render_markdown(&arch);
+        assert!(markdown.contains("p0 -->|2| p1"), "{markdown}");
+        assert!(markdown.contains("| `User` | `internal/store/user.go:3` | 2 |"));
+        assert_eq!(package_of("main.go", 2), ".");
+        assert_eq!(package_of("a/b/c/d.go", 0), "a/b/c");
+
+        let store = package_summary(&db, "internal/store/").unwrap();
+        assert_eq!(store.files, ["internal/store/user.go"]);
+        assert_eq!(store.exported.len(), 1);
+        assert_eq!(store.internal_types[0].symbol.name, "userRow");
+        assert!(store.dependencies.is_empty());
+        let dependents: Vec<_> = store.dependents.iter().map(|d| d.from.as_str()).collect();
+        assert_eq!(dependents, ["internal/api", "internal/api/handlers"]);
+        let markdown = render_package_markdown(&store);
+        assert!(
+            markdown.contains("- class `User` (internal/store/user.go:3)"),
+            "{markdown}"
+        );
+    }
+
+    #[test]
+    fn test_package_summary_renders_as_text_and_markdown() {
+        let db = Database::open_memory().unwrap();
+        let files = ["pkg/auth/token.go", "pkg/api/handler.go"];
+        for path in files {
+            db.upsert_file(&FileInfo {
+                path: path.to_string(),
+                last_modified: 0.0,
+                hash: String::new(),
+                language: "go".to_string(),
+                num_symbols: 1,
+            })
+            .unwrap();
+        }
+        let validate = Symbol::new("Validate", SymbolKind::Function, files[0], 3, 8, 0, 80)
+            .with_signature(Some("(t string) error".to_string()))
+            .with_docstring(Some(
+                "Validate checks a token.\nIt never panics.".to_string(),
+            ));
+        let claims = Symbol::new("claims", SymbolKind::Class, files[0], 10, 14, 90, 160)
+            .with_visibility(Visibility::Private);
+        let serve = Symbol::new("Serve", SymbolKind::Function, files[1], 3, 9, 0, 90);
+        db.insert_symbols(&[validate, claims, serve.clone()])
+            .unwrap();
+        db.insert_edges(&[Edge::new(
+            &serve.id,
+            "Validate",
+            EdgeKind::References,
+            files[1],
+            5,
+        )])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let summary = package_summary(&db, "pkg/auth").unwrap();
+        assert_eq!(
+            render_package_text(&summary),
+            "package pkg/auth  (1 files)\n\
+             exported:\n\
+             \x20 function Validate(t string) error  pkg/auth/token.go:3\n\
+             internal types:\n\
+             \x20 claims  pkg/auth/token.go:10  0 refs\n\
+             depends on:\n\
+             used by:\n\
+             \x20 pkg/api  1 edges\n"
+        );
+
+        let markdown = render_package_markdown(&summary);
+        assert!(markdown.starts_with("# `pkg/auth`\n\n"), "{markdown}");
+        assert!(
+            markdown.contains("Files: `pkg/auth/token.go`\n"),
+            "{markdown}"
+        );
+        assert!(
+            markdown.contains(
+                "## Exported API\n\n\
+                 - function `Validate(t string) error` (pkg/auth/token.go:3)\n\
+                 \x20 Validate checks a token.\n"
+            ),
+            "{markdown}"
+        );
+        assert!(
+            markdown.contains("- `claims` (pkg/auth/token.go:10), 0 references\n"),
+            "{markdown}"
+        );
+        assert!(
+            markdown.contains("## Dependencies\n\nNone.\n"),
+            "{markdown}"
+        );
+        assert!(
+            markdown.ends_with("## Dependents\n\n- `pkg/api` (1 edges)\n"),
+            "{markdown}"
+        );
+    }
+
+    #[test]
+    fn test_glossary_relates_terms_through_go_methods() {
+        let db = Database::open_memory().unwrap();
+        let payment = Symbol::new(
+            "Payment",
+            SymbolKind::Class,
+            "internal/payment/payment.go",
+            5,
+            12,
+            0,
+            200,
+        )
+        .with_docstring(Some(
+            "Payment is money moving from a customer to the shop.".to_string(),
+        ));
+        let order = Symbol::new(
+            "Order",
+            SymbolKind::Class,
+            "internal/orders/order.go",
+            3,
+            9,
+            0,
+            150,
+        );
+        // Declared in another file than Order; linked by receiver only.
+        let pay = Symbol::new(
+            "Pay",
+            SymbolKind::Method,
+            "internal/orders/pay.go",
+            4,
+            10,
+            0,
+            120,
+        )
+        .with_parent(Some("internal/orders/pay.go:Order"));
+        let handler = Symbol::new(
+            "Checkout",
+            SymbolKind::Function,
+            "routes/checkout.go",
+            3,
+            9,
+            0,
+            90,
+        );
+        db.insert_symbols(&[payment, order, pay.clone(), handler.clone()])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &pay.id,
+                "Payment",
+                EdgeKind::References,
+                "internal/orders/pay.go",
+                6,
+            ),
+            Edge::new(
+                &handler.id,
+                "Order",
+                EdgeKind::References,
+                "routes/checkout.go",
+                5,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let terms = glossary(&db, 10).unwrap();
+        let names: Vec<_> = terms.iter().map(|t| t.symbol.name.as_str()).collect();
+        assert_eq!(names, ["Order", "Payment"]);
+        assert_eq!(
+            terms[0].relations,
+            [Relation {
+                kind: RelationKind::Uses,
+                term: "Payment".to_string(),
+                edges: 1,
+            }]
+        );
+        assert_eq!(terms[1].relations[0].kind, RelationKind::UsedBy);
+
+        let markdown = render_glossary(&terms);
+        assert!(markdown.contains(
+            "## Payment\n\nclass in `internal/payment/payment.go:5`, 1 references from other files.\n\nPayment is money moving"
+        ), "{markdown}");
+        assert!(markdown.contains("- used by `Order` (1 edges)\n"));
+        assert_eq!(glossary(&db, 1).unwrap().len(), 1);
+    }
+
+    #[test]
+    fn test_dependencies_section_replaced_between_markers() {
+        let db = Database::open_memory().unwrap();
+        let main = Symbol::new("main", SymbolKind::Function, "cmd/main.go", 3, 9, 0, 90);
+        let store = Symbol::new("Open", SymbolKind::Function, "store/db.go", 3, 9, 0, 90);
+        db.insert_symbols(&[main.clone(), store.clone()]).unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &main.id,
+                "github.com/spf13/cobra",
+                EdgeKind::Imports,
+                "cmd/main.go",
+                1,
+            ),
+            Edge::new(
+                &store.id,
+                "github.com/spf13/cobra",
+                EdgeKind::Imports,
+                "store/db.go",
+                1,
+            ),
+            Edge::new(
+                &store.id,
+                "database/sql",
+                EdgeKind::Imports,
+                "store/db.go",
+                2,
+            ),
+            Edge::new(&main.id, "./local", EdgeKind::Imports, "cmd/main.go", 2),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let externals = externals(&db, 10).unwrap();
+        let found: Vec<_> = externals
+            .iter()
+            .map(|e| (e.module.as_str(), e.files))
+            .collect();
+        assert_eq!(found, [("github.com/spf13/cobra", 2), ("database/sql", 1)]);
+
+        let readme = format!("# App\n\n{SECTION_START}\nstale\n{SECTION_END}\n\n## License\n");
+        let updated = replace_section(&readme, "fresh\n").unwrap();
+        assert_eq!(
+            updated,
+            format!("# App\n\n{SECTION_START}\nfresh\n{SECTION_END}\n\n## License\n")
+        );
+        assert_eq!(replace_section(&updated, "fresh\n").unwrap(), updated);
+        let appended = replace_section("# App\n", "x\n").unwrap();
+        assert!(appended.starts_with("# App\n\n## Dependencies\n\n<!--"));
+        assert!(replace_section(SECTION_START, "x\n").is_err());
+    }
+}
@@ -0,0 +1,398 @@
+//! Clone detection over token fingerprints taken at index time.
+//!
+//! Each function and method body is lexed into a language-agnostic token stream:
+//! comments dropped, literals collapsed, and identifiers renamed by order of first
+//! appearance, so a copy with renamed variables yields the same stream. The stream
+//! is stored as an exact hash plus a MinHash signature of its 5-token shingles.
+//! [`find_clones`] buckets signatures by LSH band, confirms candidate pairs on the
+//! full signature, and groups matches around a canonical instance.
+
+use std::collections::HashMap;
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+
+use crate::db::Database;
+use crate::types::Symbol;
+
+/// MinHash slots per signature. Similarity is measured in steps of `1/SIGNATURE_LEN`.
+pub const SIGNATURE_LEN: usize = 32;
+
+/// Tokens per shingle.
+const SHINGLE: usize = 5;
+
+/// Bodies shorter than this are not fingerprinted: getters and one-liners
+/// look alike without being copies.
+const MIN_TOKENS: usize = 30;
+
+/// Slots per LSH band. Two bodies become candidates when any band matches in full.
+const BAND: usize = 4;
+
+/// Bucket members compared pairwise at most; larger buckets are boilerplate.
+const MAX_BUCKET: usize = 64;
+
+/// Words kept verbatim instead of renamed: control flow and declarations across
+/// the supported languages, so `if` and `while` copies stay distinct.
+const KEYWORDS: &[&str] = &[
+    "and", "as", "async", "await", "begin", "break", "case", "catch", "class", "const", "continue",
+    "def", "defer", "do", "elif", "else", "elsif", "end", "enum", "except", "export", "false",
+    "finally", "fn", "for", "func", "function", "go", "if", "impl", "import", "in", "let", "loop",
+    "match", "mut", "new", "nil", "None", "not", "null", "or", "pub", "raise", "rescue", "return",
+    "select", "self", "static", "struct", "super", "switch", "this", "throw", "true", "True",
+    "False", "try", "type", "unless", "until", "var", "when", "while", "with", "yield",
+];
+
+/// The stored fingerprint of one function or method body.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Fingerprint {
+    /// Hash of the whole normalized token stream: equal for copies that differ only
+    /// in names, literals, comments and layout.
+    pub hash: u64,
+    /// MinHash of the token shingles, `SIGNATURE_LEN` slots.
+    pub minhash: Vec<u32>,
+}
+
+/// One copy in a [`CloneGroup`].
+#[derive(Debug, Clone, Serialize)]
+pub struct Duplicate {
+    #[serde(flatten)]
+    pub symbol: Symbol,
+    /// Estimated share of token shingles in common with the canonical instance.
+    pub similarity: f64,
+    /// Same normalized tokens as the canonical instance.
+    pub exact: bool,
+}
+
+/// Functions that are copies of one another.
+#[derive(Debug, Clone, Serialize)]
+pub struct CloneGroup {
+    /// The instance to keep: the most referenced one, then the first by path.
+    pub canonical: Symbol,
+    /// Resolved edges pointing at the canonical instance.
+    pub references: u32,
+    pub duplicates: Vec<Duplicate>,
+    /// Lines in all copies but the canonical one.
+    pub duplicated_lines: u32,
+}
+
+/// Fingerprint a function body, or `None` when it is too short to matter.
+pub(crate) fn fingerprint(body: &str) -> Option<Fingerprint> {
+    let tokens = tokens(body);
+    if tokens.len() < MIN_TOKENS {
+        return None;
+    }
+    let hash = tokens.iter().fold(FNV_OFFSET, |h, t| fnv(h, *t));
+    let mut minhash = vec![u32::MAX; SIGNATURE_LEN];
+    for shingle in tokens.windows(SHINGLE) {
+        let h = shingle.iter().fold(FNV_OFFSET, |h, t| fnv(h, *t));
+        for (i, slot) in minhash.iter_mut().enumerate() {
+            *slot = (*slot).min(mix(h, i as u64));
+        }
+    }
+    Some(Fingerprint { hash, minhash })
+}
+
+/// Groups of functions and methods of at least `min_lines` lines whose signatures
+/// agree on at least `similarity` (0–1) of their slots, largest duplication first.
+pub fn find_clones(db: &Database, min_lines: u32, similarity: f64) -> Result<Vec<CloneGroup>> {
+    let entries = db.fingerprints(min_lines)?;
+
+    let mut buckets: HashMap<(usize, &[u32]), Vec<usize>> = HashMap::new();
+    for (i, (_, fp)) in entries.iter().enumerate() {
+        for (band, slots) in fp.minhash.chunks(BAND).enumerate() {
+            buckets.entry((band, slots)).or_default().push(i);
+        }
+    }
+
+    let mut groups = UnionFind::new(entries.len());
+    for members in buckets.values().filter(|m| m.len() > 1) {
+        let members = &members[..members.len().min(MAX_BUCKET)];
+        for (n, &a) in members.iter().enumerate() {
+            for &b in &members[..n] {
+                let ((sa, fa), (sb, fb)) = (&entries[a], &entries[b]);
+                if !overlaps(sa, sb) && agreement(fa, fb) >= similarity {
+                    groups.union(a, b);
+                }
+            }
+        }
+    }
+
+    let mut members: HashMap<usize, Vec<usize>> = HashMap::new();
+    for i in 0..entries.len() {
+        members.entry(groups.find(i)).or_default().push(i);
+    }
+
+    let mut clones = Vec::new();
+    for group in members.into_values().filter(|m| m.len() > 1) {
+        let mut ranked = Vec::with_capacity(group.len());
+        for i in group {
+            ranked.push((db.reference_count(&entries[i].0.id)?, i));
+        }
+        // Most referenced first, then by path and line (entries are in that order).
+        ranked.sort_by(|(ra, a), (rb, b)| rb.cmp(ra).then(a.cmp(b)));
+        let (references, first) = ranked[0];
+        let (canonical, canonical_fp) = &entries[first];
+        let duplicates: Vec<Duplicate> = ranked[1..]
+            .iter()
+            .map(|&(_, i)| {
+                let (symbol, fp) = &entries[i];
+                Duplicate {
+                    symbol: symbol.clone(),
+                    similarity: agreement(canonical_fp, fp),
+                    exact: canonical_fp.hash == fp.hash,
+                }
+            })
+            .collect();
+        clones.push(CloneGroup {
+            canonical: canonical.clone(),
+            references,
+            duplicated_lines: duplicates.iter().map(|d| lines(&d.symbol)).sum(),
+            duplicates,
+        });
+    }
+    clones.sort_by(|a, b| {
+        b.duplicated_lines.cmp(&a.duplicated_lines).then_with(|| {
+            (&a.canonical.file_path, a.canonical.start_line)
+                .cmp(&(&b.canonical.file_path, b.canonical.start_line))
+        })
+    });
+    Ok(clones)
+}
+
+fn lines(sym: &Symbol) -> u32 {
+    sym.end_line + 1 - sym.start_line
+}
+
+/// A method nested in a function (or the reverse) is not a copy of it.
+fn overlaps(a: &Symbol, b: &Symbol) -> bool {
+    a.file_path == b.file_path && a.start_line <= b.end_line && b.start_line <= a.end_line
+}
+
+/// Share of MinHash slots two signatures agree on: an estimate of shingle Jaccard.
+fn agreement(a: &Fingerprint, b: &Fingerprint) -> f64 {
+    let same = a
+        .minhash
+        .iter()
+        .zip(&b.minhash)
+        .filter(|(x, y)| x == y)
+        .count();
+    same as f64 / a.minhash.len().max(1) as f64
+}
+
+struct UnionFind(Vec<usize>);
+
+impl UnionFind {
+    fn new(n: usize) -> Self {
+        Self((0..n).collect())
+    }
+
+    fn find(&mut self, i: usize) -> usize {
+        let parent = self.0[i];
+        if parent == i {
+            return i;
+        }
+        let root = self.find(parent);
+        self.0[i] = root;
+        root
+    }
+
+    fn union(&mut self, a: usize, b: usize) {
+        let (a, b) = (self.find(a), self.find(b));
+        self.0[a.max(b)] = a.min(b);
+    }
+}
+
+// Fingerprints are stored in the index, so hashing must not depend on the Rust
+// version the way `DefaultHasher` may.
+const FNV_OFFSET: u64 = 0xcbf2_9ce4_8422_2325;
+
+fn fnv(hash: u64, value: u64) -> u64 {
+    value.to_le_bytes().iter().fold(hash, |h, b| {
+        (h ^ u64::from(*b)).wrapping_mul(0x100_0000_01b3)
+    })
+}
+
+/// splitmix64 of `hash` under seed `i`: one independent hash per MinHash slot.
+fn mix(hash: u64, i: u64) -> u32 {
+    let mut z = hash.wrapping_add(i.wrapping_add(1).wrapping_mul(0x9e37_79b9_7f4a_7c15));
+    z = (z ^ (z >> 30)).wrapping_mul(0xbf58_476d_1ce4_e5b9);
+    z = (z ^ (z >> 27)).wrapping_mul(0x94d0_49bb_1331_11eb);
+    (z ^ (z >> 31)) as u32
+}
+
+fn fnv_str(s: &str) -> u64 {
+    s.bytes().fold(FNV_OFFSET, |h, b| {
+        (h ^ u64::from(b)).wrapping_mul(0x100_0000_01b3)
+    })
+}
+
+/// Normalized tokens of `text`, hashed: comments and whitespace dropped, string and
+/// number literals collapsed to one token each, identifiers other than [`KEYWORDS`]
+/// numbered by first appearance.
+fn tokens(text: &str) -> Vec<u64> {
+    let string = fnv_str("\"");
+    let number = fnv_str("0");
+    let mut names: HashMap<&str, u64> = HashMap::new();
+    let mut out = Vec::new();
+    let bytes = text.as_bytes();
+    let mut i = 0;
+    while i < bytes.len() {
+        let c = bytes[i];
+        let rest = &bytes[i..];
+        if c.is_ascii_whitespace() {
+            i += 1;
+        } else if rest.starts_with(b"//") || (c == b'#' && !rest.starts_with(b"#[")) {
+            i += rest.iter().position(|&b| b == b'\n').unwrap_or(rest.len());
+        } else if rest.starts_with(b"/*") {
+            i += rest[2..]
+                .windows(2)
+                .position(|w| w == b"*/")
+                .map_or(rest.len(), |p| p + 4);
+        } else if matches!(c, b'"' | b'\'' | b'`') {
+            // A literal ends at its closing quote, or at the line end for a lone quote
+            // (a Rust lifetime, a Ruby `?'`), so a stray quote cannot swallow the body.
+            let mut j = 1;
+            while j < rest.len() && rest[j] != c && (c == b'`' || rest[j] != b'\n') {
+                j += if rest[j] == b'\\' { 2 } else { 1 };
+            }
+            i += (j + 1).min(rest.len());
+            out.push(string);
+        } else if c.is_ascii_digit() {
+            i += rest
+                .iter()
+                .position(|b| !(b.is_ascii_alphanumeric() || matches!(b, b'.' | b'_')))
+                .unwrap_or(rest.len());
+            out.push(number);
+        } else if c.is_ascii_alphabetic() || c == b'_' || c == b'$' || c >= 0x80 {
+            let len = rest
+                .iter()
+                .position(|&b| !(b.is_ascii_alphanumeric() || b == b'_' || b == b'$' || b >= 0x80))
+                .unwrap_or(rest.len());
+            // An escape inside a literal may have left `i` mid-character.
+            let word = text.get(i..i + len).unwrap_or_default();
+            i += len;
+            out.push(if KEYWORDS.contains(&word) {
+                fnv_str(word)
+            } else {
+                let next = names.len() as u64;
+                let n = *names.entry(word).or_insert(next);
+                fnv(fnv_str("$"), n)
+            });
+        } else {
+            out.push(u64::from(c));
+            i += 1;
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, SymbolKind};
+
+    const ORIGINAL: &str = r#"
+def load_users(path, limit):
+    # Read users from a CSV export.
+    rows = []
+    with open(path) as handle:
+        for line in handle:
+            if len(rows) >= limit:
+                break
+            rows.append(line.strip().split(","))
+    return rows
+"#;
+
+    const RENAMED: &str = r#"
+def read_orders(file_name, max_rows):
+    result = []
+    with open(file_name) as f:
+        for row in f:
+            if len(result) >= max_rows:
+                break
+            result.append(row.strip().split(";"))
+    return result
+"#;
+
+    const EDITED: &str = r#"
+def read_orders(file_name, max_rows):
+    result = []
+    with open(file_name) as f:
+        for row in f:
+            if len(result) >= max_rows:
+                break
+            result.append(row.strip().split(";"))
+    log(len(result))
+    return result
+"#;
+
+    #[test]
+    fn test_renamed_copy_matches_exactly() {
+        let a = fingerprint(ORIGINAL).unwrap();
+        let b = fingerprint(RENAMED).unwrap();
+        assert_eq!(a.hash, b.hash);
+        assert_eq!(agreement(&a, &b), 1.0);
+        assert!(fingerprint("def f(x):\n    return x\n").is_none());
+    }
+
+    #[test]
+    fn test_find_clones_groups_near_copies_around_most_referenced() {
+        let db = Database::open_memory().unwrap();
+        let sym =
+            |name: &str, file: &str| Symbol::new(name, SymbolKind::Function, file, 1, 10, 0, 0);
+        let original = sym("load_users", "a.py");
+        let copy = sym("read_orders", "b.py");
+        let edited = sym("read_orders_logged", "c.py");
+        let other = sym("unrelated", "d.py");
+        let caller = sym("main", "main.py");
+        db.insert_symbols(&[
+            original.clone(),
+            copy.clone(),
+            edited.clone(),
+            other.clone(),
+            caller.clone(),
+        ])
+        .unwrap();
+        for (sym, body) in [(&original, ORIGINAL), (&copy, RENAMED), (&edited, EDITED)] {
+            db.insert_fingerprints(
+                &sym.file_path,
+                &[(sym.id.clone(), fingerprint(body).unwrap())],
+            )
+            .unwrap();
+        }
+        let unrelated = "def unrelated(a, b):\n    total = 0\n    while a < b:\n        total += a * 2 - b / 3\n        a = a + 1\n    raise ValueError(total)\n";
+        db.insert_fingerprints(
+            "d.py",
+            &[(other.id.clone(), fingerprint(unrelated).unwrap())],
+        )
+        .unwrap();
+        db.insert_edges(&[Edge::new(
+            &caller.id,
+            "read_orders",
+            EdgeKind::Calls,
+            "main.py",
+            3,
+        )])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let groups = find_clones(&db, 5, 0.5).unwrap();
+        assert_eq!(groups.len(), 1);
+        let group = &groups[0];
+        assert_eq!(group.canonical.file_path, "b.py", "the referenced copy");
+        assert_eq!(group.references, 1);
+        let files: Vec<_> = group
+            .duplicates
+            .iter()
+            .map(|d| (d.symbol.file_path.as_str(), d.exact))
+            .collect();
+        assert_eq!(files, [("a.py", true), ("c.py", false)]);
+        assert!(group.duplicates[1].similarity >= 0.5 && group.duplicates[1].similarity < 1.0);
+        assert_eq!(group.duplicated_lines, 20);
+
+        assert!(
+            find_clones(&db, 11, 0.5).unwrap().is_empty(),
+            "shorter than min_lines"
+        );
+    }
+}
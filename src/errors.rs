@@ -0,0 +1,515 @@
+//! Error propagation: follow an error from the function that produces it up through
+//! its callers, stopping where a caller swallows it.
+//!
+//! Handling is recorded per call site at index time (see `languages::errors`): a
+//! caller propagates the error unchanged, wraps it (`%w`, `.context()`, `raise ... from`),
+//! replaces it with a new one, or swallows it.
+//!
+//! [`lifecycle`] starts from a Go error value instead: an error type or a sentinel
+//! variable, from where it is created to where it is checked and handled.
+
+use std::collections::{HashMap, HashSet};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{EdgeKind, ErrorHandling, Symbol, SymbolKind};
+
+/// How errors from one definition travel up its callers.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ErrorTrace {
+    pub symbol: Symbol,
+    /// Whether the symbol returns or raises errors of its own (as opposed to only
+    /// passing on those of its callees).
+    pub fallible: bool,
+    pub callers: Vec<ErrorStep>,
+}
+
+/// A call site on the way up, with the callers the error reaches from there.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ErrorStep {
+    pub caller: Symbol,
+    pub line: u32,
+    /// `None` when the call could not be classified; the trace stops there.
+    pub handling: Option<ErrorHandling>,
+    pub callers: Vec<ErrorStep>,
+}
+
+/// Trace errors from every definition of `name` (dotted names such as
+/// `Pool.GetConnection` narrow by parent) up to `depth` callers away.
+///
+/// A caller seen earlier on the same trace is listed but not expanded again, so
+/// recursion terminates.
+pub fn trace(db: &Database, name: &str, depth: u32) -> Result<Vec<ErrorTrace>> {
+    db.find_definitions(name)?
+        .into_iter()
+        .map(|symbol| {
+            let mut seen = HashSet::from([symbol.id.clone()]);
+            Ok(ErrorTrace {
+                fallible: db.is_fallible(&symbol.id)?,
+                callers: callers(db, &symbol.id, depth, &mut seen)?,
+                symbol,
+            })
+        })
+        .collect()
+}
+
+fn callers(
+    db: &Database,
+    symbol_id: &str,
+    depth: u32,
+    seen: &mut HashSet<String>,
+) -> Result<Vec<ErrorStep>> {
+    if depth == 0 {
+        return Ok(Vec::new());
+    }
+    let mut steps = Vec::new();
+    for (caller, line, handling) in db.error_callers(symbol_id)? {
+        let expand = handling.is_some_and(ErrorHandling::escapes) && seen.insert(caller.id.clone());
+        let callers = if expand {
+            callers(db, &caller.id, depth - 1, seen)?
+        } else {
+            Vec::new()
+        };
+        steps.push(ErrorStep {
+            caller,
+            line,
+            handling,
+            callers,
+        });
+    }
+    Ok(steps)
+}
+
+/// A step in the life of an error value, in the order it happens.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ErrorStage {
+    /// Declared (a sentinel) or built (`&ExpiredTokenError{...}`).
+    Created,
+    /// A sentinel returned or assigned as is.
+    Returned,
+    /// Kept inside another error: `%w`, or a caller wrapping what a creator returns.
+    Wrapped,
+    /// Compared: `errors.Is`, `errors.As`, `==`, a type assertion or switch case.
+    Checked,
+    /// Swallowed or replaced by a caller on the way up: it goes no further.
+    Handled,
+}
+
+impl ErrorStage {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Created => "created",
+            Self::Returned => "returned",
+            Self::Wrapped => "wrapped",
+            Self::Checked => "checked",
+            Self::Handled => "handled",
+        }
+    }
+}
+
+/// Where an error value is at one stage.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct LifecycleSite {
+    pub stage: ErrorStage,
+    /// The function, method or variable at that stage.
+    pub symbol: Symbol,
+    pub line: u32,
+    /// The source line, or what happens there when it is not in the index.
+    pub detail: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ErrorLifecycle {
+    pub definitions: Vec<Symbol>,
+    /// By stage, then file and line.
+    pub sites: Vec<LifecycleSite>,
+}
+
+/// The life of the Go error type or sentinel `name`: where it is created or
+/// returned, wrapped, checked, and handled by callers up to `depth` calls above
+/// the functions creating it.
+///
+/// A sentinel's uses come from the reads of the package-level variable, told
+/// apart by their source line: `errors.Is`, `==`, `!=` and `case` check it, `%w`
+/// wraps it, anything else returns it. An error type is created by its composite
+/// literals and `new` calls and checked by type assertions, type switch cases and
+/// the `errors.As` calls in functions mentioning it.
+pub fn lifecycle(db: &Database, name: &str, depth: u32) -> Result<ErrorLifecycle> {
+    let definitions = db.find_definitions(name)?;
+    let mut sources = Sources {
+        db,
+        contents: HashMap::new(),
+    };
+    let mut sites = Vec::new();
+    let mut origins: Vec<Symbol> = Vec::new();
+
+    // Sentinels: the variable, then every read of it.
+    for sentinel in definitions
+        .iter()
+        .filter(|d| d.kind == SymbolKind::Variable)
+    {
+        sites.push(LifecycleSite {
+            stage: ErrorStage::Created,
+            symbol: sentinel.clone(),
+            line: sentinel.start_line,
+            detail: "package-level variable".to_string(),
+        });
+    }
+    for (_, accessor, access) in db.global_accesses(Some(name))? {
+        if access.write {
+            continue;
+        }
+        let text = sources.line(&accessor, access.line)?.unwrap_or_default();
+        let stage = sentinel_stage(&text);
+        if stage != ErrorStage::Checked {
+            origins.push(accessor.clone());
+        }
+        sites.push(LifecycleSite {
+            stage,
+            symbol: accessor,
+            line: access.line,
+            detail: text,
+        });
+    }
+
+    // Error types: literals and `new`, assertions and `errors.As`.
+    if definitions.iter().any(|d| d.kind == SymbolKind::Class) {
+        for (symbol, construction) in db.constructions(name)? {
+            let detail = sources
+                .line(&symbol, construction.line)?
+                .unwrap_or_else(|| format!("{} {name}", construction.kind));
+            origins.push(symbol.clone());
+            sites.push(LifecycleSite {
+                stage: ErrorStage::Created,
+                symbol,
+                line: construction.line,
+                detail,
+            });
+        }
+        for (symbol, assertion) in db.type_assertions(name)? {
+            if assertion.type_name != name {
+                continue;
+            }
+            let detail = sources.line(&symbol, assertion.line)?.unwrap_or_else(|| {
+                if assertion.type_switch {
+                    "type switch case".to_string()
+                } else {
+                    "type assertion".to_string()
+                }
+            });
+            sites.push(LifecycleSite {
+                stage: ErrorStage::Checked,
+                symbol,
+                line: assertion.line,
+                detail,
+            });
+        }
+        for (edge, symbol) in db.refs("errors.As", Some(EdgeKind::Calls))? {
+            let Some(symbol) = symbol else {
+                continue;
+            };
+            if sources.content(&symbol)?.is_some_and(|c| c.contains(name)) {
+                let detail = sources.line(&symbol, edge.line)?.unwrap_or_default();
+                sites.push(LifecycleSite {
+                    stage: ErrorStage::Checked,
+                    symbol,
+                    line: edge.line,
+                    detail,
+                });
+            }
+        }
+    }
+
+    // Callers of the creators, up to where the error stops.
+    let mut seen: HashSet<String> = origins.iter().map(|o| o.id.clone()).collect();
+    let mut visited = HashSet::new();
+    for origin in &origins {
+        if visited.insert(origin.id.clone()) {
+            let steps = callers(db, &origin.id, depth, &mut seen)?;
+            handling_sites(&steps, &origin.name, &mut sites);
+        }
+    }
+
+    let mut unique = HashSet::new();
+    sites.retain(|s| unique.insert((s.stage, s.symbol.id.clone(), s.line)));
+    sites.sort_by(|a, b| {
+        (a.stage, &a.symbol.file_path, a.line).cmp(&(b.stage, &b.symbol.file_path, b.line))
+    });
+    Ok(ErrorLifecycle { definitions, sites })
+}
+
+/// Indexed source of symbols, fetched once each.
+struct Sources<'a> {
+    db: &'a Database,
+    contents: HashMap<String, Option<String>>,
+}
+
+impl Sources<'_> {
+    fn content(&mut self, symbol: &Symbol) -> Result<Option<&str>> {
+        if !self.contents.contains_key(&symbol.id) {
+            let content = self.db.get_symbol_content(&symbol.id)?.map(|(c, _)| c);
+            self.contents.insert(symbol.id.clone(), content);
+        }
+        Ok(self.contents[&symbol.id].as_deref())
+    }
+
+    /// Line `line` of the file, trimmed, when it falls inside `symbol`.
+    fn line(&mut self, symbol: &Symbol, line: u32) -> Result<Option<String>> {
+        let start = symbol.start_line;
+        let Some(content) = self.content(symbol)? else {
+            return Ok(None);
+        };
+        Ok(line
+            .checked_sub(start)
+            .and_then(|i| content.lines().nth(i as usize))
+            .map(|l| l.trim().to_string()))
+    }
+}
+
+/// What a line reading a sentinel does with it.
+fn sentinel_stage(text: &str) -> ErrorStage {
+    let checks = text.contains("errors.Is(")
+        || text.starts_with("case ")
+        || text.contains("==")
+        || text.contains("!=");
+    if checks {
+        ErrorStage::Checked
+    } else if text.contains("%w") {
+        ErrorStage::Wrapped
+    } else {
+        ErrorStage::Returned
+    }
+}
+
+/// Wrapping, swallowing and replacing callers in a trace from `callee`.
+fn handling_sites(steps: &[ErrorStep], callee: &str, sites: &mut Vec<LifecycleSite>) {
+    for step in steps {
+        let stage = match step.handling {
+            Some(ErrorHandling::Wraps) => Some(ErrorStage::Wrapped),
+            Some(ErrorHandling::Swallows | ErrorHandling::Replaces) => Some(ErrorStage::Handled),
+            Some(ErrorHandling::Propagates) | None => None,
+        };
+        if let (Some(stage), Some(handling)) = (stage, step.handling) {
+            sites.push(LifecycleSite {
+                stage,
+                symbol: step.caller.clone(),
+                line: step.line,
+                detail: format!("{} the error from {callee}", handling.as_str()),
+            });
+        }
+        handling_sites(&step.callers, &step.caller.name, sites);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, ErrorFlow, VariableAccess};
+
+    #[test]
+    fn test_trace_stops_where_errors_are_swallowed() {
+        let db = Database::open_memory().unwrap();
+        let file = "app/db.go";
+        let sym = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 8, 0, 100)
+        };
+        let get = sym("GetConnection", 1);
+        let load = sym("LoadUser", 10);
+        let handler = sym("HandleUser", 20);
+        let cron = sym("Refresh", 30);
+        let main = sym("main", 40);
+        db.insert_symbols(&[
+            get.clone(),
+            load.clone(),
+            handler.clone(),
+            cron.clone(),
+            main.clone(),
+        ])
+        .unwrap();
+        let calls = [
+            (&load, "GetConnection", 12, ErrorHandling::Wraps),
+            (&handler, "LoadUser", 22, ErrorHandling::Propagates),
+            (&cron, "LoadUser", 32, ErrorHandling::Swallows),
+            (&main, "Refresh", 42, ErrorHandling::Propagates),
+        ];
+        let edges: Vec<_> = calls
+            .iter()
+            .map(|(s, t, l, _)| Edge::new(&s.id, *t, EdgeKind::Calls, file, *l))
+            .collect();
+        db.insert_edges(&edges).unwrap();
+        db.resolve_edges().unwrap();
+        let flows: Vec<_> = calls
+            .iter()
+            .map(|(s, t, l, h)| ErrorFlow {
+                source_id: s.id.clone(),
+                target_name: t.to_string(),
+                line: *l,
+                handling: *h,
+            })
+            .collect();
+        db.insert_error_flows(file, &[get.id.clone()], &flows)
+            .unwrap();
+
+        let traces = trace(&db, "GetConnection", 5).unwrap();
+        assert_eq!(traces.len(), 1);
+        assert!(traces[0].fallible);
+        let [load_step] = traces[0].callers.as_slice() else {
+            panic!("expected one caller: {:?}", traces[0].callers);
+        };
+        assert_eq!(load_step.caller.name, "LoadUser");
+        assert_eq!(load_step.handling, Some(ErrorHandling::Wraps));
+        let up: Vec<_> = load_step
+            .callers
+            .iter()
+            .map(|s| (s.caller.name.as_str(), s.handling, s.callers.len()))
+            .collect();
+        assert_eq!(
+            up,
+            [
+                ("HandleUser", Some(ErrorHandling::Propagates), 0),
+                ("Refresh", Some(ErrorHandling::Swallows), 0),
+            ]
+        );
+
+        assert!(!trace(&db, "LoadUser", 5).unwrap()[0].fallible);
+        assert!(trace(&db, "GetConnection", 1).unwrap()[0].callers[0]
+            .callers
+            .is_empty());
+    }
+
+    #[test]
+    fn test_lifecycle_of_a_sentinel_and_an_error_type() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, file: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 5, 0, 100)
+        };
+        let sentinel = Symbol::new(
+            "ErrExpired",
+            SymbolKind::Variable,
+            "auth/token.go",
+            5,
+            5,
+            0,
+            10,
+        );
+        let validate = func("Validate", "auth/token.go", 10);
+        let middleware = func("Middleware", "auth/mw.go", 30);
+        let cron = func("Refresh", "jobs/cron.go", 40);
+        let login = func("Login", "api/login.go", 20);
+        db.insert_symbols(&[
+            sentinel.clone(),
+            validate.clone(),
+            middleware.clone(),
+            cron.clone(),
+            login.clone(),
+        ])
+        .unwrap();
+        let content = |sym: &Symbol, text: &str| {
+            (
+                sym.id.clone(),
+                sym.name.clone(),
+                text.to_string(),
+                String::new(),
+            )
+        };
+        db.insert_symbol_contents(&[
+            content(
+                &validate,
+                "func Validate(t string) error {\n\tif expired(t) {\n\t\treturn ErrExpired\n\t}\n\treturn nil\n}",
+            ),
+            content(
+                &login,
+                "func Login() {\n\terr := auth.Validate(tok)\n\tif errors.Is(err, auth.ErrExpired) {\n\t\tredirect()\n\t}\n}",
+            ),
+        ])
+        .unwrap();
+        let read = |sym: &Symbol, line, qualifier: Option<&str>| VariableAccess {
+            symbol_id: sym.id.clone(),
+            line,
+            name: "ErrExpired".to_string(),
+            qualifier: qualifier.map(str::to_string),
+            write: false,
+        };
+        db.insert_variable_accesses(
+            "auth/token.go",
+            &[sentinel.id.clone()],
+            &[read(&validate, 12, None)],
+        )
+        .unwrap();
+        db.insert_variable_accesses("api/login.go", &[], &[read(&login, 22, Some("auth"))])
+            .unwrap();
+        let calls = [
+            (&middleware, "Validate", 32, ErrorHandling::Wraps),
+            (&cron, "Middleware", 42, ErrorHandling::Swallows),
+        ];
+        for (s, t, l, h) in calls {
+            db.insert_edges(&[Edge::new(&s.id, t, EdgeKind::Calls, &s.file_path, l)])
+                .unwrap();
+            let flow = ErrorFlow {
+                source_id: s.id.clone(),
+                target_name: t.to_string(),
+                line: l,
+                handling: h,
+            };
+            db.insert_error_flows(&s.file_path, &[], &[flow]).unwrap();
+        }
+        db.resolve_edges().unwrap();
+
+        let life = lifecycle(&db, "ErrExpired", 5).unwrap();
+        let sites: Vec<_> = life
+            .sites
+            .iter()
+            .map(|s| (s.stage, s.symbol.name.as_str(), s.line, s.detail.as_str()))
+            .collect();
+        assert_eq!(
+            sites,
+            [
+                (
+                    ErrorStage::Created,
+                    "ErrExpired",
+                    5,
+                    "package-level variable"
+                ),
+                (ErrorStage::Returned, "Validate", 12, "return ErrExpired"),
+                (
+                    ErrorStage::Wrapped,
+                    "Middleware",
+                    32,
+                    "wraps the error from Validate"
+                ),
+                (
+                    ErrorStage::Checked,
+                    "Login",
+                    22,
+                    "if errors.Is(err, auth.ErrExpired) {"
+                ),
+                (
+                    ErrorStage::Handled,
+                    "Refresh",
+                    42,
+                    "swallows the error from Middleware"
+                ),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_sentinel_stage() {
+        assert_eq!(sentinel_stage("return ErrExpired"), ErrorStage::Returned);
+        assert_eq!(
+            sentinel_stage("return fmt.Errorf(\"refresh %s: %w\", id, ErrExpired)"),
+            ErrorStage::Wrapped
+        );
+        assert_eq!(
+            sentinel_stage("if err == ErrExpired {"),
+            ErrorStage::Checked
+        );
+        assert_eq!(
+            sentinel_stage("case ErrExpired, ErrRevoked:"),
+            ErrorStage::Checked
+        );
+    }
+}
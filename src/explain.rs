@@ -0,0 +1,248 @@
+//! Query diagnostics for `--explain`: per-stage wall time and per-statement SQLite stats.
+//!
+//! Collection is opt-in and per-thread (the CLI answers a query on its main thread).
+//! [`enable`] turns it on, [`Database::enable_explain`] installs a profiling hook on a
+//! connection, [`mark`] closes a timing stage, and [`finish`] drains everything into
+//! an [`ExplainReport`].
+//!
+//! [`Database::enable_explain`]: crate::db::Database::enable_explain
+
+use std::cell::{Cell, RefCell};
+use std::ffi::CStr;
+use std::os::raw::{c_int, c_uint, c_void};
+use std::time::{Duration, Instant};
+
+use rusqlite::ffi;
+use serde::Serialize;
+
+thread_local! {
+    static ENABLED: Cell<bool> = const { Cell::new(false) };
+    static TRACE: RefCell<Trace> = RefCell::new(Trace::new());
+}
+
+struct Trace {
+    started: Instant,
+    last_mark: Instant,
+    stages: Vec<StageTiming>,
+    statements: Vec<StatementStats>,
+    cache_hits: i64,
+    cache_misses: i64,
+}
+
+impl Trace {
+    fn new() -> Self {
+        let now = Instant::now();
+        Self {
+            started: now,
+            last_mark: now,
+            stages: Vec::new(),
+            statements: Vec::new(),
+            cache_hits: 0,
+            cache_misses: 0,
+        }
+    }
+}
+
+/// Wall time spent in one stage of a command.
+#[derive(Debug, Clone, Serialize)]
+pub struct StageTiming {
+    pub stage: &'static str,
+    pub ms: f64,
+}
+
+/// Aggregated execution stats for one distinct SQL statement.
+#[derive(Debug, Clone, Serialize)]
+pub struct StatementStats {
+    pub sql: String,
+    pub calls: u32,
+    pub total_ms: f64,
+    /// Rows stepped through by full table scans (no usable index).
+    pub fullscan_steps: i64,
+    /// Virtual machine operations; a rough proxy for total rows touched.
+    pub vm_steps: i64,
+    pub sorts: i64,
+    pub auto_indexes: i64,
+    /// `EXPLAIN QUERY PLAN` detail lines, filled in by the caller.
+    pub plan: Vec<String>,
+}
+
+/// Everything collected while `--explain` was active.
+#[derive(Debug, Clone, Serialize)]
+pub struct ExplainReport {
+    pub total_ms: f64,
+    pub stages: Vec<StageTiming>,
+    pub statements: Vec<StatementStats>,
+    /// Page cache hits and misses on the traced connection.
+    pub cache_hits: i64,
+    pub cache_misses: i64,
+}
+
+/// Turn collection on and start the clock for the first stage.
+pub fn enable() {
+    ENABLED.with(|e| e.set(true));
+    TRACE.with(|t| *t.borrow_mut() = Trace::new());
+}
+
+pub fn is_enabled() -> bool {
+    ENABLED.with(Cell::get)
+}
+
+/// Close the current stage: the time since the previous mark is attributed to `stage`.
+pub fn mark(stage: &'static str) {
+    if !is_enabled() {
+        return;
+    }
+    TRACE.with(|t| {
+        let mut t = t.borrow_mut();
+        let now = Instant::now();
+        let elapsed = now - t.last_mark;
+        t.last_mark = now;
+        t.stages.push(StageTiming {
+            stage,
+            ms: millis(elapsed),
+        });
+    });
+}
+
+/// Close the final `stage`, stop collecting, and return the report.
+///
+/// Returns `None` if collection was never enabled.
+pub fn finish(stage: &'static str) -> Option<ExplainReport> {
+    if !is_enabled() {
+        return None;
+    }
+    mark(stage);
+    ENABLED.with(|e| e.set(false));
+    let trace = TRACE.with(|t| std::mem::replace(&mut *t.borrow_mut(), Trace::new()));
+    Some(ExplainReport {
+        total_ms: millis(trace.started.elapsed()),
+        stages: trace.stages,
+        statements: trace.statements,
+        cache_hits: trace.cache_hits,
+        cache_misses: trace.cache_misses,
+    })
+}
+
+fn millis(d: Duration) -> f64 {
+    d.as_secs_f64() * 1000.0
+}
+
+fn record(sql: String, elapsed: Duration, counters: [i64; 4], cache: (i64, i64)) {
+    TRACE.with(|t| {
+        let mut t = t.borrow_mut();
+        let [fullscan_steps, vm_steps, sorts, auto_indexes] = counters;
+        match t.statements.iter_mut().find(|s| s.sql == sql) {
+            Some(s) => {
+                s.calls += 1;
+                s.total_ms += millis(elapsed);
+                s.fullscan_steps += fullscan_steps;
+                s.vm_steps += vm_steps;
+                s.sorts += sorts;
+                s.auto_indexes += auto_indexes;
+            }
+            None => t.statements.push(StatementStats {
+                sql,
+                calls: 1,
+                total_ms: millis(elapsed),
+                fullscan_steps,
+                vm_steps,
+                sorts,
+                auto_indexes,
+                plan: Vec::new(),
+            }),
+        }
+        // Connection-wide counters are cumulative; the latest reading wins.
+        t.cache_hits = cache.0;
+        t.cache_misses = cache.1;
+    });
+}
+
+/// `sqlite3_trace_v2` callback for `SQLITE_TRACE_PROFILE` events.
+///
+/// Fires once per statement execution. Statement counters are read with the reset
+/// flag set so cached statements report per-execution numbers.
+pub(crate) unsafe extern "C" fn on_profile(
+    event: c_uint,
+    _ctx: *mut c_void,
+    stmt: *mut c_void,
+    nanos: *mut c_void,
+) -> c_int {
+    if event != ffi::SQLITE_TRACE_PROFILE as c_uint || !is_enabled() {
+        return 0;
+    }
+    let stmt = stmt as *mut ffi::sqlite3_stmt;
+    let sql_ptr = ffi::sqlite3_sql(stmt);
+    if sql_ptr.is_null() {
+        return 0;
+    }
+    let sql = CStr::from_ptr(sql_ptr).to_string_lossy().trim().to_string();
+    let elapsed = Duration::from_nanos((*(nanos as *const i64)).max(0) as u64);
+
+    let status = |op: c_int| i64::from(ffi::sqlite3_stmt_status(stmt, op, 1));
+    let counters = [
+        status(ffi::SQLITE_STMTSTATUS_FULLSCAN_STEP as c_int),
+        status(ffi::SQLITE_STMTSTATUS_VM_STEP as c_int),
+        status(ffi::SQLITE_STMTSTATUS_SORT as c_int),
+        status(ffi::SQLITE_STMTSTATUS_AUTOINDEX as c_int),
+    ];
+
+    let db = ffi::sqlite3_db_handle(stmt);
+    let db_status = |op: c_int| {
+        let (mut current, mut highwater) = (0, 0);
+        ffi::sqlite3_db_status(db, op, &mut current, &mut highwater, 0);
+        i64::from(current)
+    };
+    let cache = (
+        db_status(ffi::SQLITE_DBSTATUS_CACHE_HIT as c_int),
+        db_status(ffi::SQLITE_DBSTATUS_CACHE_MISS as c_int),
+    );
+
+    record(sql, elapsed, counters, cache);
+    0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::db::Database;
+    use crate::types::{Symbol, SymbolKind};
+
+    #[test]
+    fn test_explain_collects_statements_and_stages() {
+        enable();
+        let db = Database::open_memory().unwrap();
+        db.enable_explain();
+        mark("open");
+        db.insert_symbol(&Symbol::new(
+            "login",
+            SymbolKind::Function,
+            "auth.py",
+            1,
+            5,
+            0,
+            50,
+        ))
+        .unwrap();
+        db.outline("auth.py").unwrap();
+        db.outline("auth.py").unwrap();
+
+        let report = finish("query").unwrap();
+        assert!(!is_enabled());
+        let stages: Vec<_> = report.stages.iter().map(|s| s.stage).collect();
+        assert_eq!(stages, ["open", "query"]);
+        let outline = report
+            .statements
+            .iter()
+            .find(|s| s.sql.contains("WHERE file_path"))
+            .expect("outline query traced");
+        assert_eq!(outline.calls, 2);
+
+        let plan = db.query_plan(&outline.sql).unwrap();
+        assert!(!plan.is_empty());
+    }
+
+    #[test]
+    fn test_finish_without_enable_is_none() {
+        assert!(finish("query").is_none());
+    }
+}
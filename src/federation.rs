@@ -0,0 +1,228 @@
+//! Several repositories behind one `cartog serve`.
+//!
+//! `--mount NAME=PATH` mounts the index of the repository at PATH under the
+//! namespace NAME, next to the server's own index, which is always there as `.`.
+//! Query tools take a `repo` argument naming the index to ask; without one they
+//! ask the server's own. Search also takes `repo: "*"` to ask every index at
+//! once and merge the results, each tagged with the repository it came from.
+//! Mounted indexes are opened read-only: they are rebuilt where they live.
+
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::sync::{Arc, Mutex};
+
+use anyhow::{bail, Context, Result};
+use serde::Serialize;
+
+use crate::db::{Database, DB_FILE};
+use crate::types::Symbol;
+
+/// Namespace of the server's own index.
+pub const LOCAL: &str = ".";
+
+/// `repo` value that searches every index.
+pub const ALL: &str = "*";
+
+/// A repository to mount, from `NAME=PATH`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Mount {
+    pub name: String,
+    pub root: PathBuf,
+}
+
+impl FromStr for Mount {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        let Some((name, root)) = s.split_once('=') else {
+            bail!("expected NAME=PATH, got '{s}'");
+        };
+        let valid = |c: char| c.is_ascii_alphanumeric() || matches!(c, '-' | '_' | '.');
+        if name.is_empty() || name == LOCAL || !name.chars().all(valid) {
+            bail!("invalid repository name '{name}': use letters, digits, '-', '_' and '.'");
+        }
+        if root.is_empty() {
+            bail!("no path for repository '{name}'");
+        }
+        Ok(Self {
+            name: name.to_string(),
+            root: PathBuf::from(root),
+        })
+    }
+}
+
+/// One index the server can query.
+#[derive(Debug, Clone)]
+pub struct Repo {
+    pub name: String,
+    /// Canonical root of the repository; file arguments are resolved within it.
+    pub root: Arc<Path>,
+    pub db: Arc<Mutex<Database>>,
+}
+
+/// The server's own index, then the mounted ones in the order given.
+#[derive(Debug, Clone)]
+pub struct Repos {
+    repos: Vec<Repo>,
+}
+
+impl Repos {
+    pub fn new(root: Arc<Path>, db: Arc<Mutex<Database>>) -> Self {
+        Self {
+            repos: vec![Repo {
+                name: LOCAL.to_string(),
+                root,
+                db,
+            }],
+        }
+    }
+
+    /// Open the index of `mount` read-only and add it.
+    pub fn mount(&mut self, mount: &Mount) -> Result<()> {
+        if self.repos.iter().any(|r| r.name == mount.name) {
+            bail!("repository '{}' is mounted twice", mount.name);
+        }
+        let root = mount
+            .root
+            .canonicalize()
+            .with_context(|| format!("cannot resolve {}", mount.root.display()))?;
+        let path = root.join(DB_FILE);
+        if !path.exists() {
+            bail!(
+                "no index at {}; run `cartog index .` there first",
+                path.display()
+            );
+        }
+        let db = Database::open_read_only(&path)
+            .with_context(|| format!("cannot open {}", path.display()))?;
+        self.repos.push(Repo {
+            name: mount.name.clone(),
+            root: Arc::from(root),
+            db: Arc::new(Mutex::new(db)),
+        });
+        Ok(())
+    }
+
+    pub fn names(&self) -> impl Iterator<Item = &str> {
+        self.repos.iter().map(|r| r.name.as_str())
+    }
+
+    /// The index `repo` names; the server's own when unset.
+    pub fn get(&self, repo: Option<&str>) -> Result<&Repo, String> {
+        let name = repo.unwrap_or(LOCAL);
+        if name == ALL {
+            return Err("repo \"*\" is only supported by cartog_search".to_string());
+        }
+        self.repos.iter().find(|r| r.name == name).ok_or_else(|| {
+            let known: Vec<&str> = self.names().collect();
+            format!("unknown repo '{name}'; mounted: {}", known.join(", "))
+        })
+    }
+
+    /// Every index for [`ALL`], otherwise the one `repo` names.
+    pub fn select(&self, repo: Option<&str>) -> Result<Vec<&Repo>, String> {
+        match repo {
+            Some(ALL) => Ok(self.repos.iter().collect()),
+            _ => self.get(repo).map(|r| vec![r]),
+        }
+    }
+}
+
+/// `id` as a session remembers it: symbol ids of mounted repositories carry
+/// their namespace, so the same path in two repositories isn't taken as one.
+pub fn qualified_id(repo: &str, id: &str) -> String {
+    if repo == LOCAL {
+        id.to_string()
+    } else {
+        format!("{repo}:{id}")
+    }
+}
+
+/// A search result from one of several indexes.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RepoSymbol {
+    pub repo: String,
+    #[serde(flatten)]
+    pub symbol: Symbol,
+}
+
+/// Merge each index's search results, ranked as one index ranks them (exact
+/// name, then prefix, then substring), ties in mount order, keeping `limit`.
+pub fn merge_ranked(
+    query: &str,
+    results: Vec<(String, Vec<Symbol>)>,
+    limit: usize,
+) -> Vec<RepoSymbol> {
+    let query = query.to_lowercase();
+    let rank = |name: &str| {
+        let name = name.to_lowercase();
+        if name == query {
+            0
+        } else if name.starts_with(&query) {
+            1
+        } else {
+            2
+        }
+    };
+    let mut merged: Vec<RepoSymbol> = results
+        .into_iter()
+        .flat_map(|(repo, symbols)| {
+            symbols.into_iter().map(move |symbol| RepoSymbol {
+                repo: repo.clone(),
+                symbol,
+            })
+        })
+        .collect();
+    merged.sort_by_key(|r| rank(&r.symbol.name));
+    merged.truncate(limit);
+    merged
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::SymbolKind;
+
+    #[test]
+    fn test_mounts_and_merged_search() {
+        let mount: Mount = "billing=../billing".parse().unwrap();
+        assert_eq!(mount.name, "billing");
+        assert_eq!(mount.root, PathBuf::from("../billing"));
+        assert!("billing".parse::<Mount>().is_err());
+        assert!(".=../billing".parse::<Mount>().is_err());
+        assert!("a/b=../billing".parse::<Mount>().is_err());
+        assert!("billing=".parse::<Mount>().is_err());
+
+        let local = Arc::new(Mutex::new(Database::open_memory().unwrap()));
+        let repos = Repos::new(Arc::from(Path::new("/srv/api")), local);
+        assert_eq!(repos.get(None).unwrap().name, LOCAL);
+        assert!(repos
+            .get(Some("billing"))
+            .unwrap_err()
+            .contains("mounted: ."));
+        assert!(repos.get(Some(ALL)).is_err());
+        assert_eq!(repos.select(Some(ALL)).unwrap().len(), 1);
+
+        let sym =
+            |name: &str, file: &str| Symbol::new(name, SymbolKind::Function, file, 1, 2, 0, 10);
+        let merged = merge_ranked(
+            "charge",
+            vec![
+                (
+                    ".".to_string(),
+                    vec![sym("ChargeCard", "api.go"), sym("recharge", "api.go")],
+                ),
+                ("billing".to_string(), vec![sym("Charge", "billing.go")]),
+            ],
+            2,
+        );
+        let got: Vec<(&str, &str)> = merged
+            .iter()
+            .map(|r| (r.repo.as_str(), r.symbol.name.as_str()))
+            .collect();
+        assert_eq!(got, [("billing", "Charge"), (".", "ChargeCard")]);
+        let json = serde_json::to_value(&merged[0]).unwrap();
+        assert_eq!(json["repo"], "billing");
+        assert_eq!(json["file_path"], "billing.go");
+    }
+}
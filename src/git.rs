@@ -0,0 +1,660 @@
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+use tracing::warn;
+
+/// Run a git command with stdin suppressed to prevent interactive prompts.
+///
+/// Returns `None` if git could not be spawned (not installed, bad CWD, or
+/// spawning forbidden by the server's capabilities).
+pub fn git_cmd(root: &Path, args: &[&str]) -> Option<std::process::Output> {
+    if !crate::capabilities::shell_allowed() {
+        return None;
+    }
+    std::process::Command::new("git")
+        .args(args)
+        .current_dir(root)
+        .stdin(std::process::Stdio::null())
+        .output()
+        .ok()
+}
+
+/// Run a git command and return its stdout, failing with git's stderr on a non-zero exit.
+pub fn git_stdout(root: &Path, args: &[&str]) -> Result<String> {
+    let output = git_cmd(root, args).context("failed to run git")?;
+    if !output.status.success() {
+        anyhow::bail!(
+            "git {} failed: {}",
+            args.join(" "),
+            String::from_utf8_lossy(&output.stderr).trim()
+        );
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+/// Parse lines from git command output, filtering empty lines.
+pub fn parse_git_lines(stdout: &[u8]) -> impl Iterator<Item = String> + '_ {
+    String::from_utf8_lossy(stdout)
+        .lines()
+        .filter(|l| !l.is_empty())
+        .map(|l| l.to_string())
+        .collect::<Vec<_>>()
+        .into_iter()
+}
+
+/// Get the current HEAD commit hash.
+pub fn head_commit(root: &Path) -> Option<String> {
+    let output = git_cmd(root, &["rev-parse", "HEAD"])?;
+    if output.status.success() {
+        Some(String::from_utf8(output.stdout).ok()?.trim().to_string())
+    } else {
+        None
+    }
+}
+
+/// Current branch name, or `None` on a detached HEAD or outside a repository.
+pub fn current_branch(root: &Path) -> Option<String> {
+    let output = git_cmd(root, &["symbolic-ref", "--quiet", "--short", "HEAD"])?;
+    if output.status.success() {
+        Some(String::from_utf8(output.stdout).ok()?.trim().to_string())
+    } else {
+        None
+    }
+}
+
+/// Checked-out branch and commit, as read from the `.git` directory.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HeadState {
+    /// `None` on a detached HEAD.
+    pub branch: Option<String>,
+    /// `None` on an unborn branch (no commits yet).
+    pub commit: Option<String>,
+}
+
+/// Read HEAD straight from the repository files, without spawning git.
+///
+/// Spawning `git` costs several milliseconds, which dominates a one-shot query.
+/// This handles the common layouts (plain `.git` directory, linked worktrees via
+/// a `.git` file, loose and packed refs). It returns `None` for anything it does
+/// not recognise, so callers can fall back to [`head_commit`] / [`current_branch`].
+pub fn read_head(root: &Path) -> Option<HeadState> {
+    let (git_dir, common_dir) = find_git_dir(root)?;
+    let head = std::fs::read_to_string(git_dir.join("HEAD")).ok()?;
+    let head = head.trim();
+
+    let Some(reference) = head.strip_prefix("ref: ") else {
+        return is_object_id(head).then(|| HeadState {
+            branch: None,
+            commit: Some(head.to_string()),
+        });
+    };
+    let branch = reference
+        .strip_prefix("refs/heads/")
+        .unwrap_or(reference)
+        .to_string();
+    let commit = match std::fs::read_to_string(common_dir.join(reference)) {
+        Ok(loose) => Some(loose.trim().to_string()).filter(|c| is_object_id(c)),
+        Err(_) => std::fs::read_to_string(common_dir.join("packed-refs"))
+            .ok()
+            .and_then(|packed| packed_ref(&packed, reference)),
+    };
+    Some(HeadState {
+        branch: Some(branch),
+        commit,
+    })
+}
+
+/// Locate the git dir (per-worktree) and common dir (shared refs) above `root`.
+fn find_git_dir(root: &Path) -> Option<(PathBuf, PathBuf)> {
+    let root = root.canonicalize().ok()?;
+    let dot_git = root
+        .ancestors()
+        .map(|d| d.join(".git"))
+        .find(|p| p.exists())?;
+    let git_dir = if dot_git.is_file() {
+        let pointer = std::fs::read_to_string(&dot_git).ok()?;
+        let target = PathBuf::from(pointer.trim().strip_prefix("gitdir: ")?);
+        if target.is_absolute() {
+            target
+        } else {
+            dot_git.parent()?.join(target)
+        }
+    } else {
+        dot_git
+    };
+    let common_dir = match std::fs::read_to_string(git_dir.join("commondir")) {
+        Ok(rel) => git_dir.join(rel.trim()),
+        Err(_) => git_dir.clone(),
+    };
+    Some((git_dir, common_dir))
+}
+
+/// Look up `reference` in the contents of a `packed-refs` file.
+fn packed_ref(packed: &str, reference: &str) -> Option<String> {
+    packed
+        .lines()
+        .filter(|l| !l.starts_with('#') && !l.starts_with('^'))
+        .filter_map(|l| l.split_once(' '))
+        .find(|(_, name)| *name == reference)
+        .map(|(sha, _)| sha.to_string())
+        .filter(|sha| is_object_id(sha))
+}
+
+/// SHA-1 (40) or SHA-256 (64) hex object name.
+fn is_object_id(s: &str) -> bool {
+    matches!(s.len(), 40 | 64) && s.bytes().all(|b| b.is_ascii_hexdigit())
+}
+
+/// Resolve a ref (branch, tag, `HEAD~2`, short SHA) to a full commit hash.
+pub fn resolve_commit(root: &Path, rev: &str) -> Result<String> {
+    let spec = format!("{rev}^{{commit}}");
+    let out = git_stdout(root, &["rev-parse", "--verify", "--quiet", &spec])
+        .with_context(|| format!("unknown git revision '{rev}'"))?;
+    Ok(out.trim().to_string())
+}
+
+/// Top-level directory of the git repository containing `path`.
+pub fn toplevel(path: &Path) -> Option<PathBuf> {
+    let out = git_stdout(path, &["rev-parse", "--show-toplevel"]).ok()?;
+    Some(PathBuf::from(out.trim()))
+}
+
+/// A commit summary as reported by `git log`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct CommitInfo {
+    pub sha: String,
+    pub author: String,
+    pub email: String,
+    /// Author date, ISO 8601.
+    pub date: String,
+    pub subject: String,
+}
+
+/// `git log` format producing one `\x1e`-prefixed header per commit with `\x1f`-separated fields.
+const LOG_FORMAT: &str = "--format=%x1e%H%x1f%an%x1f%ae%x1f%aI%x1f%s";
+
+/// Parse `git log` output produced with [`LOG_FORMAT`].
+///
+/// Anything after the header line of each record (patches emitted by `-L`) is ignored.
+fn parse_log_records(stdout: &str) -> Vec<CommitInfo> {
+    stdout
+        .split('\x1e')
+        .filter_map(|record| {
+            let header = record.lines().next()?;
+            let mut fields = header.split('\x1f');
+            Some(CommitInfo {
+                sha: fields.next()?.to_string(),
+                author: fields.next()?.to_string(),
+                email: fields.next()?.to_string(),
+                date: fields.next()?.to_string(),
+                subject: fields.next().unwrap_or("").to_string(),
+            })
+        })
+        .collect()
+}
+
+/// Commits that modified lines `start..=end` of `file`, newest first.
+///
+/// Uses `git log -L`, which follows the line range back through history, so the
+/// result reflects the evolution of the code currently at that location.
+/// `since` optionally restricts the walk to recent commits (`git log --since`).
+pub fn line_history(
+    root: &Path,
+    file: &str,
+    start: u32,
+    end: u32,
+    limit: u32,
+    since: Option<&str>,
+) -> Result<Vec<CommitInfo>> {
+    line_history_at(root, None, file, start, end, limit, since)
+}
+
+/// Like [`line_history`], but walking back from `rev` instead of `HEAD`.
+///
+/// Used for code that only exists at an older commit (before a rename or move).
+pub fn line_history_at(
+    root: &Path,
+    rev: Option<&str>,
+    file: &str,
+    start: u32,
+    end: u32,
+    limit: u32,
+    since: Option<&str>,
+) -> Result<Vec<CommitInfo>> {
+    let range = format!("-L{start},{end}:{file}");
+    let max = format!("--max-count={limit}");
+    let mut args = vec!["log", LOG_FORMAT, max.as_str()];
+    let since_arg;
+    if let Some(since) = since {
+        since_arg = format!("--since={since}");
+        args.push(&since_arg);
+    }
+    args.push(&range);
+    if let Some(rev) = rev {
+        args.push(rev);
+    }
+    let out = git_stdout(root, &args)?;
+    Ok(parse_log_records(&out))
+}
+
+/// The last `count` commits on the first-parent chain of `HEAD`, newest first.
+pub fn recent_commits(root: &Path, count: u32) -> Result<Vec<CommitInfo>> {
+    let max = format!("--max-count={count}");
+    let out = git_stdout(root, &["log", "--first-parent", LOG_FORMAT, &max])?;
+    Ok(parse_log_records(&out))
+}
+
+/// The empty tree, to diff a root commit against.
+pub fn empty_tree(root: &Path) -> Result<String> {
+    let out = git_stdout(root, &["hash-object", "-t", "tree", "--stdin"])?;
+    Ok(out.trim().to_string())
+}
+
+/// Lines changed between `from` and `HEAD`, by file, as inclusive ranges of
+/// line numbers in `HEAD`. A deletion is the line before it (1 at the top).
+///
+/// Paths are relative to `root` (`--relative`); deleted files are left out.
+pub fn changed_lines(root: &Path, from: &str) -> Result<BTreeMap<String, Vec<(u32, u32)>>> {
+    let out = git_stdout(
+        root,
+        &[
+            "diff",
+            "--relative",
+            "--no-color",
+            "--no-ext-diff",
+            "-U0",
+            from,
+            "HEAD",
+        ],
+    )?;
+    Ok(parse_changed_lines(&out))
+}
+
+fn parse_changed_lines(diff: &str) -> BTreeMap<String, Vec<(u32, u32)>> {
+    let mut changed: BTreeMap<String, Vec<(u32, u32)>> = BTreeMap::new();
+    let mut file: Option<String> = None;
+    for line in diff.lines() {
+        if let Some(path) = line.strip_prefix("+++ ") {
+            file = path.strip_prefix("b/").map(str::to_string);
+            continue;
+        }
+        let (Some(file), Some(hunk)) = (&file, line.strip_prefix("@@ ")) else {
+            continue;
+        };
+        // `@@ -a,b +c,d @@`: `d` lines from `c`; `,d` is omitted when it is 1.
+        let Some(new) = hunk.split(' ').find_map(|part| part.strip_prefix('+')) else {
+            continue;
+        };
+        let (start, count) = match new.split_once(',') {
+            Some((start, count)) => (start.parse().unwrap_or(0), count.parse().unwrap_or(0)),
+            None => (new.parse().unwrap_or(0), 1),
+        };
+        let range = if count == 0 {
+            (start.max(1), start.max(1))
+        } else {
+            (start, start + count - 1)
+        };
+        changed.entry(file.clone()).or_default().push(range);
+    }
+    changed
+}
+
+/// Files renamed between two commits, as `(old_path, new_path)`.
+///
+/// Uses git's similarity-based rename detection (`-M`); paths are relative to `root`.
+pub fn renamed_files(root: &Path, from: &str, to: &str) -> Result<Vec<(String, String)>> {
+    let out = git_stdout(
+        root,
+        &[
+            "diff",
+            "--relative",
+            "--name-status",
+            "-M",
+            "--diff-filter=R",
+            from,
+            to,
+        ],
+    )?;
+    Ok(parse_renames(&out))
+}
+
+fn parse_renames(out: &str) -> Vec<(String, String)> {
+    out.lines()
+        .filter_map(|line| {
+            let mut parts = line.split('\t');
+            let status = parts.next()?;
+            if !status.starts_with('R') {
+                return None;
+            }
+            Some((parts.next()?.to_string(), parts.next()?.to_string()))
+        })
+        .collect()
+}
+
+/// Files touched by each non-merge commit, newest first, as `(sha, paths)`.
+///
+/// Paths are relative to `root` (`--relative`), so they line up with index paths
+/// when `root` is the indexed directory. `since` accepts anything `git log --since`
+/// does (`2026-01-01`, `6 months ago`).
+pub fn commit_files(root: &Path, since: Option<&str>) -> Result<Vec<(String, Vec<String>)>> {
+    let mut args = vec![
+        "log",
+        "--no-merges",
+        "--relative",
+        "--name-only",
+        "--format=%x1e%H",
+    ];
+    let since_arg;
+    if let Some(since) = since {
+        since_arg = format!("--since={since}");
+        args.push(&since_arg);
+    }
+    let out = git_stdout(root, &args)?;
+    Ok(out
+        .split('\x1e')
+        .filter_map(|record| {
+            let mut lines = record.lines().filter(|l| !l.is_empty());
+            let sha = lines.next()?.to_string();
+            Some((sha, lines.map(str::to_string).collect()))
+        })
+        .collect())
+}
+
+/// Last-change attribution for a line or line range, from `git blame`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BlameInfo {
+    pub sha: String,
+    pub author: String,
+    pub email: String,
+    /// Author date, `YYYY-MM-DD` (UTC).
+    pub date: String,
+    pub summary: String,
+    #[serde(skip)]
+    author_time: i64,
+}
+
+impl BlameInfo {
+    /// Whole days from the author date to `now` (Unix seconds).
+    pub fn age_days(&self, now: i64) -> u32 {
+        (now - self.author_time).max(0).div_euclid(86_400) as u32
+    }
+}
+
+/// Per-line blame of one file, parsed from `git blame --porcelain`.
+#[derive(Debug, Default)]
+pub struct FileBlame {
+    commits: Vec<BlameInfo>,
+    /// Index into `commits` for each 1-based line (slot 0 unused).
+    lines: Vec<Option<usize>>,
+}
+
+impl FileBlame {
+    /// The most recent commit touching any line in `start..=end`.
+    pub fn last_change(&self, start: u32, end: u32) -> Option<&BlameInfo> {
+        let start = start.max(1) as usize;
+        let end = (end as usize).min(self.lines.len().saturating_sub(1));
+        (start..=end)
+            .filter_map(|l| self.lines.get(l).copied().flatten())
+            .map(|i| &self.commits[i])
+            .max_by_key(|c| c.author_time)
+    }
+}
+
+/// Blame every line of `file` at the working tree state.
+pub fn blame_file(root: &Path, file: &str) -> Result<FileBlame> {
+    let out = git_stdout(root, &["blame", "--porcelain", "--", file])?;
+    Ok(parse_blame_porcelain(&out))
+}
+
+fn parse_blame_porcelain(out: &str) -> FileBlame {
+    let mut blame = FileBlame::default();
+    let mut index_by_sha: std::collections::HashMap<String, usize> =
+        std::collections::HashMap::new();
+    let mut current: Option<usize> = None;
+
+    for line in out.lines() {
+        if line.starts_with('\t') {
+            continue; // line content
+        }
+        let mut parts = line.split(' ');
+        let first = parts.next().unwrap_or("");
+        if first.len() == 40 && first.bytes().all(|b| b.is_ascii_hexdigit()) {
+            let final_line: usize = match parts.nth(1).and_then(|n| n.parse().ok()) {
+                Some(n) => n,
+                None => continue,
+            };
+            let idx = *index_by_sha.entry(first.to_string()).or_insert_with(|| {
+                blame.commits.push(BlameInfo {
+                    sha: first.to_string(),
+                    author: String::new(),
+                    email: String::new(),
+                    date: String::new(),
+                    summary: String::new(),
+                    author_time: 0,
+                });
+                blame.commits.len() - 1
+            });
+            if blame.lines.len() <= final_line {
+                blame.lines.resize(final_line + 1, None);
+            }
+            blame.lines[final_line] = Some(idx);
+            current = Some(idx);
+            continue;
+        }
+        let Some(idx) = current else { continue };
+        let commit = &mut blame.commits[idx];
+        if let Some(v) = line.strip_prefix("author-mail ") {
+            commit.email = v.trim_matches(|c| c == '<' || c == '>').to_string();
+        } else if let Some(v) = line.strip_prefix("author-time ") {
+            commit.author_time = v.trim().parse().unwrap_or(0);
+            commit.date = format_epoch_date(commit.author_time);
+        } else if let Some(v) = line.strip_prefix("author ") {
+            commit.author = v.to_string();
+        } else if let Some(v) = line.strip_prefix("summary ") {
+            commit.summary = v.to_string();
+        }
+    }
+    blame
+}
+
+/// Format a Unix timestamp as a `YYYY-MM-DD` UTC date.
+///
+/// Civil-from-days conversion (Howard Hinnant's algorithm), avoiding a date crate.
+pub fn format_epoch_date(secs: i64) -> String {
+    let days = secs.div_euclid(86_400);
+    let z = days + 719_468;
+    let era = z.div_euclid(146_097);
+    let doe = z - era * 146_097;
+    let yoe = (doe - doe / 1460 + doe / 36_524 - doe / 146_096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + i64::from(month <= 2);
+    format!("{year:04}-{month:02}-{day:02}")
+}
+
+static WORKTREE_SEQ: AtomicUsize = AtomicUsize::new(0);
+
+/// A detached git worktree checked out at a given revision in a temporary directory.
+///
+/// The worktree is removed (and pruned from git's bookkeeping) on drop.
+pub struct TempWorktree {
+    repo: PathBuf,
+    path: PathBuf,
+}
+
+impl TempWorktree {
+    /// Check out `rev` from the repository at `repo` into a fresh temporary directory.
+    pub fn checkout(repo: &Path, rev: &str) -> Result<Self> {
+        let commit = resolve_commit(repo, rev)?;
+        let short = &commit[..commit.len().min(12)];
+        // Two worktrees of the same commit may coexist (e.g. `diff X X`), so make names unique.
+        let seq = WORKTREE_SEQ.fetch_add(1, Ordering::Relaxed);
+        let path = std::env::temp_dir().join(format!(
+            "cartog-worktree-{short}-{}-{seq}",
+            std::process::id()
+        ));
+        if path.exists() {
+            std::fs::remove_dir_all(&path).ok();
+        }
+        let path_str = path.to_string_lossy().into_owned();
+        git_stdout(
+            repo,
+            &["worktree", "add", "--detach", "--quiet", &path_str, &commit],
+        )
+        .with_context(|| format!("failed to check out '{rev}' into a temporary worktree"))?;
+        Ok(Self {
+            repo: repo.to_path_buf(),
+            path,
+        })
+    }
+
+    /// Root directory of the checked-out tree.
+    pub fn path(&self) -> &Path {
+        &self.path
+    }
+}
+
+impl Drop for TempWorktree {
+    fn drop(&mut self) {
+        let path_str = self.path.to_string_lossy().into_owned();
+        let removed = git_cmd(&self.repo, &["worktree", "remove", "--force", &path_str])
+            .map(|o| o.status.success())
+            .unwrap_or(false);
+        if !removed {
+            warn!(path = %self.path.display(), "failed to remove temporary worktree");
+            let _ = std::fs::remove_dir_all(&self.path);
+            let _ = git_cmd(&self.repo, &["worktree", "prune"]);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_packed_ref_lookup() {
+        let packed = "# pack-refs with: peeled fully-peeled sorted\n\
+            1111111111111111111111111111111111111111 refs/heads/dev\n\
+            2222222222222222222222222222222222222222 refs/tags/v1\n\
+            ^3333333333333333333333333333333333333333\n";
+        assert_eq!(
+            packed_ref(packed, "refs/heads/dev").as_deref(),
+            Some("1111111111111111111111111111111111111111")
+        );
+        assert!(packed_ref(packed, "refs/heads/main").is_none());
+    }
+
+    #[test]
+    fn test_read_head_matches_git() {
+        let root = Path::new(env!("CARGO_MANIFEST_DIR"));
+        let Some(expected) = head_commit(root) else {
+            return; // not a git checkout (e.g. a source tarball)
+        };
+        let head = read_head(root).expect("readable .git");
+        assert_eq!(head.commit.as_deref(), Some(expected.as_str()));
+        assert_eq!(head.branch, current_branch(root));
+    }
+
+    #[test]
+    fn test_parse_git_lines_skips_empty() {
+        let lines: Vec<String> = parse_git_lines(b"a.rs\n\nb.rs\n").collect();
+        assert_eq!(lines, vec!["a.rs", "b.rs"]);
+    }
+
+    #[test]
+    fn test_parse_log_records_ignores_patch_lines() {
+        let out =
+            "\x1eabc123\x1fJane\x1fjane@example.com\x1f2026-01-02T10:00:00+00:00\x1fFix expiry\n\
+                   diff --git a/x.py b/x.py\n+    return None\n\
+                   \x1edef456\x1fBob\x1fbob@example.com\x1f2025-12-01T09:00:00+00:00\x1fAdd x\n";
+        let commits = parse_log_records(out);
+        assert_eq!(commits.len(), 2);
+        assert_eq!(commits[0].sha, "abc123");
+        assert_eq!(commits[0].author, "Jane");
+        assert_eq!(commits[0].subject, "Fix expiry");
+        assert_eq!(commits[1].date, "2025-12-01T09:00:00+00:00");
+    }
+
+    #[test]
+    fn test_parse_renames() {
+        let out = "R100\tsrc/old.rs\tsrc/new.rs\nR087\ta.py\tpkg/a.py\nM\tother.rs\n";
+        assert_eq!(
+            parse_renames(out),
+            vec![
+                ("src/old.rs".to_string(), "src/new.rs".to_string()),
+                ("a.py".to_string(), "pkg/a.py".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_changed_lines() {
+        let diff = "diff --git a/auth/login.go b/auth/login.go\n\
+                    --- a/auth/login.go\n\
+                    +++ b/auth/login.go\n\
+                    @@ -10,2 +10,3 @@ func Login() {\n\
+                    +\tif expired(t) {\n\
+                    @@ -40 +41 @@ func Logout() {\n\
+                    @@ -50,3 +51,0 @@ func refresh() {\n\
+                    diff --git a/old.go b/old.go\n\
+                    --- a/old.go\n\
+                    +++ /dev/null\n\
+                    @@ -1,5 +0,0 @@\n";
+        let changed = parse_changed_lines(diff);
+        assert_eq!(
+            changed.into_iter().collect::<Vec<_>>(),
+            [(
+                "auth/login.go".to_string(),
+                vec![(10, 12), (41, 41), (51, 51)]
+            )]
+        );
+    }
+
+    #[test]
+    fn test_format_epoch_date() {
+        assert_eq!(format_epoch_date(0), "1970-01-01");
+        assert_eq!(format_epoch_date(951_782_400), "2000-02-29");
+        assert_eq!(format_epoch_date(1_767_225_600), "2026-01-01");
+    }
+
+    #[test]
+    fn test_parse_blame_porcelain_picks_latest_commit() {
+        let old = "a".repeat(40);
+        let new = "b".repeat(40);
+        let out = format!(
+            "{old} 1 1 2\nauthor Old Author\nauthor-mail <old@example.com>\nauthor-time 1000000000\nsummary Initial\n\tline one\n\
+             {old} 2 2\n\tline two\n\
+             {new} 3 3 1\nauthor New Author\nauthor-mail <new@example.com>\nauthor-time 1700000000\nsummary Tweak\n\tline three\n"
+        );
+        let blame = parse_blame_porcelain(&out);
+
+        let first_two = blame.last_change(1, 2).unwrap();
+        assert_eq!(first_two.author, "Old Author");
+        assert_eq!(first_two.email, "old@example.com");
+
+        let all = blame.last_change(1, 3).unwrap();
+        assert_eq!(all.author, "New Author");
+        assert_eq!(all.summary, "Tweak");
+        assert_eq!(all.date, "2023-11-14");
+
+        assert!(blame.last_change(10, 12).is_none());
+    }
+
+    #[test]
+    fn test_resolve_commit_unknown_rev() {
+        assert!(resolve_commit(Path::new("."), "definitely-not-a-ref-xyz").is_err());
+    }
+
+    #[test]
+    fn test_resolve_commit_head_matches_head_commit() {
+        if let Some(head) = head_commit(Path::new(".")) {
+            assert_eq!(resolve_commit(Path::new("."), "HEAD").unwrap(), head);
+        }
+    }
+}
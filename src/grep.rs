@@ -0,0 +1,333 @@
+//! `cartog grep`: grep answered from the index where it can be.
+//!
+//! Agents reach for `grep -rn name .` to find where an identifier is used. When
+//! the pattern is an identifier, the index already knows: every definition and
+//! every edge records its file and line, so only those lines are read back. The
+//! index vouches only for files it covers whose modification time still matches
+//! what it recorded; everything else is scanned from disk like grep would:
+//! config, docs, languages cartog doesn't parse, files edited since the last
+//! index, and the whole tree when the pattern isn't an identifier, `--scan` is
+//! given, or there is no index. Each match says which of the two found it.
+//!
+//! Patterns are literal strings, as with `grep -F`. An identifier that appears
+//! in an indexed file only inside a comment or string literal is not an edge,
+//! so the index misses it; `--scan` reads every file instead.
+
+use std::collections::{BTreeMap, HashSet};
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+use walkdir::WalkDir;
+
+use crate::db::Database;
+use crate::indexer::{file_modified, is_ignored_dirname};
+
+/// Bytes checked for NUL before a file is taken as binary and skipped.
+const BINARY_PROBE: usize = 8192;
+
+#[derive(Debug, Clone, Copy, Default)]
+pub struct GrepOptions {
+    /// Ignore case (`-i`).
+    pub ignore_case: bool,
+    /// Match whole words only (`-w`).
+    pub word: bool,
+    /// Read every file, never the index.
+    pub scan: bool,
+}
+
+/// Where a match was found.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Source {
+    Index,
+    Scan,
+}
+
+/// Which sources served a whole result.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum GrepMode {
+    /// Every file in scope was answered by the index.
+    Index,
+    /// The index was not used.
+    Scan,
+    /// The index answered for the files it covers, the rest were scanned.
+    Mixed,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct GrepMatch {
+    pub path: String,
+    pub line: u32,
+    pub text: String,
+    pub source: Source,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct GrepResult {
+    pub mode: GrepMode,
+    /// Why the index was not used, when it wasn't.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub fallback: Option<String>,
+    pub matches: Vec<GrepMatch>,
+    /// Files the index answered for without reading them.
+    pub indexed_files: usize,
+    /// Files read from disk.
+    pub scanned_files: usize,
+}
+
+/// A literal pattern, as grep's `-F` with `-i` and `-w`.
+struct Matcher {
+    needle: String,
+    ignore_case: bool,
+    word: bool,
+}
+
+impl Matcher {
+    fn is_match(&self, line: &str) -> bool {
+        let lowered;
+        let haystack = if self.ignore_case {
+            lowered = line.to_lowercase();
+            lowered.as_str()
+        } else {
+            line
+        };
+        if !self.word {
+            return haystack.contains(&self.needle);
+        }
+        let is_word = |c: char| c.is_alphanumeric() || c == '_';
+        haystack.match_indices(&self.needle).any(|(at, m)| {
+            let before = haystack[..at].chars().next_back();
+            let after = haystack[at + m.len()..].chars().next();
+            !before.is_some_and(is_word) && !after.is_some_and(is_word)
+        })
+    }
+}
+
+fn is_identifier(pattern: &str) -> bool {
+    !pattern.is_empty() && pattern.chars().all(|c| c.is_alphanumeric() || c == '_')
+}
+
+/// Search `paths`, relative to `root` where the index lives, for `pattern`.
+pub fn grep(
+    db: Option<&Database>,
+    root: &Path,
+    pattern: &str,
+    paths: &[String],
+    opts: GrepOptions,
+) -> Result<GrepResult> {
+    anyhow::ensure!(!pattern.is_empty(), "empty pattern");
+    let matcher = Matcher {
+        needle: if opts.ignore_case {
+            pattern.to_lowercase()
+        } else {
+            pattern.to_string()
+        },
+        ignore_case: opts.ignore_case,
+        word: opts.word,
+    };
+    let scopes: Vec<String> = paths
+        .iter()
+        .map(|p| {
+            let p = p.trim_start_matches("./").trim_end_matches('/');
+            if p == "." {
+                String::new()
+            } else {
+                p.to_string()
+            }
+        })
+        .collect();
+    let in_scope = |path: &str| {
+        scopes.is_empty()
+            || scopes.iter().any(|s| {
+                s.is_empty()
+                    || path == s
+                    || path
+                        .strip_prefix(s.as_str())
+                        .is_some_and(|r| r.starts_with('/'))
+            })
+    };
+
+    let fallback = match db {
+        _ if opts.scan => Some("--scan".to_string()),
+        None => Some("no index".to_string()),
+        Some(_) if !is_identifier(pattern) => Some("pattern is not an identifier".to_string()),
+        Some(_) => None,
+    };
+
+    let mut matches = Vec::new();
+    // Files the index vouches for: indexed, in scope, unchanged since.
+    let mut fresh: HashSet<String> = HashSet::new();
+    if let (Some(db), None) = (db, &fallback) {
+        for (path, mtime) in db.file_mtimes()? {
+            if in_scope(&path) && file_modified(&root.join(&path)) == mtime {
+                fresh.insert(path);
+            }
+        }
+        let mut lines: BTreeMap<String, Vec<u32>> = BTreeMap::new();
+        for (path, line) in db.name_lines(pattern, opts.word)? {
+            if fresh.contains(&path) {
+                lines.entry(path).or_default().push(line);
+            }
+        }
+        for (path, wanted) in lines {
+            let Ok(content) = std::fs::read_to_string(root.join(&path)) else {
+                continue;
+            };
+            let text: Vec<&str> = content.lines().collect();
+            for line in wanted {
+                let Some(t) = text.get(line.saturating_sub(1) as usize) else {
+                    continue;
+                };
+                if matcher.is_match(t) {
+                    matches.push(GrepMatch {
+                        path: path.clone(),
+                        line,
+                        text: t.to_string(),
+                        source: Source::Index,
+                    });
+                }
+            }
+        }
+    }
+
+    let mut scanned_files = 0;
+    let walk_roots: Vec<&str> = if scopes.iter().any(|s| s.is_empty()) || scopes.is_empty() {
+        vec![""]
+    } else {
+        scopes.iter().map(String::as_str).collect()
+    };
+    let mut seen: HashSet<String> = HashSet::new();
+    for start in walk_roots {
+        let walker = WalkDir::new(root.join(start))
+            .into_iter()
+            .filter_entry(|e| {
+                e.depth() == 0
+                    || !e.file_type().is_dir()
+                    || !is_ignored_dirname(&e.file_name().to_string_lossy())
+            });
+        for entry in walker.filter_map(|e| e.ok()) {
+            if !entry.file_type().is_file() {
+                continue;
+            }
+            let Ok(rel) = entry.path().strip_prefix(root) else {
+                continue;
+            };
+            let rel = rel.to_string_lossy().to_string();
+            if fresh.contains(&rel) || !seen.insert(rel.clone()) {
+                continue;
+            }
+            let Ok(bytes) = std::fs::read(entry.path()) else {
+                continue;
+            };
+            if bytes[..bytes.len().min(BINARY_PROBE)].contains(&0) {
+                continue;
+            }
+            scanned_files += 1;
+            let content = String::from_utf8_lossy(&bytes);
+            for (i, t) in content.lines().enumerate() {
+                if matcher.is_match(t) {
+                    matches.push(GrepMatch {
+                        path: rel.clone(),
+                        line: i as u32 + 1,
+                        text: t.to_string(),
+                        source: Source::Scan,
+                    });
+                }
+            }
+        }
+    }
+    matches.sort_by(|a, b| (&a.path, a.line).cmp(&(&b.path, b.line)));
+
+    let mode = match (&fallback, scanned_files) {
+        (Some(_), _) => GrepMode::Scan,
+        (None, 0) => GrepMode::Index,
+        (None, _) => GrepMode::Mixed,
+    };
+    Ok(GrepResult {
+        mode,
+        fallback,
+        matches,
+        indexed_files: fresh.len(),
+        scanned_files,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::indexer::index_directory;
+
+    #[test]
+    fn test_grep_uses_index_then_scans_the_rest() {
+        let dir = std::env::temp_dir().join(format!("cartog-grep-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(dir.join("src")).unwrap();
+        std::fs::write(
+            dir.join("src/auth.py"),
+            "def validate_token(t):\n    return t\n\ndef login(t):\n    return validate_token(t)\n",
+        )
+        .unwrap();
+        std::fs::write(dir.join("README.md"), "Call validate_token first.\n").unwrap();
+
+        let db = Database::open_memory().unwrap();
+        index_directory(&db, &dir, false).unwrap();
+        let all = [".".to_string()];
+
+        let result = grep(
+            Some(&db),
+            &dir,
+            "validate_token",
+            &all,
+            GrepOptions::default(),
+        )
+        .unwrap();
+        assert_eq!(result.mode, GrepMode::Mixed);
+        assert_eq!(result.indexed_files, 1);
+        assert_eq!(result.scanned_files, 1);
+        let got: Vec<(&str, u32, Source)> = result
+            .matches
+            .iter()
+            .map(|m| (m.path.as_str(), m.line, m.source))
+            .collect();
+        assert_eq!(
+            got,
+            [
+                ("README.md", 1, Source::Scan),
+                ("src/auth.py", 1, Source::Index),
+                ("src/auth.py", 5, Source::Index),
+            ]
+        );
+
+        let src = grep(
+            Some(&db),
+            &dir,
+            "VALIDATE_TOKEN",
+            &["src".to_string()],
+            GrepOptions {
+                ignore_case: true,
+                word: true,
+                ..GrepOptions::default()
+            },
+        )
+        .unwrap();
+        assert_eq!(src.mode, GrepMode::Index);
+        assert_eq!(src.matches.len(), 2);
+
+        let phrase = grep(Some(&db), &dir, "return t", &all, GrepOptions::default()).unwrap();
+        assert_eq!(phrase.mode, GrepMode::Scan);
+        assert_eq!(
+            phrase.fallback.as_deref(),
+            Some("pattern is not an identifier")
+        );
+        assert_eq!(phrase.matches.len(), 1);
+
+        assert!(grep(None, &dir, "validate", &all, GrepOptions::default())
+            .unwrap()
+            .matches
+            .iter()
+            .all(|m| m.source == Source::Scan));
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
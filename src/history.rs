@@ -0,0 +1,241 @@
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+use tracing::warn;
+
+use crate::db::Database;
+use crate::git::{self, BlameInfo, CommitInfo, FileBlame};
+use crate::lineage::RenameLink;
+use crate::types::Symbol;
+
+/// Maximum rename hops followed back from a symbol's current identity.
+const MAX_RENAME_DEPTH: usize = 8;
+
+/// Commits that touched one symbol definition.
+#[derive(Debug, Serialize)]
+pub struct SymbolHistory {
+    pub symbol: Symbol,
+    pub commits: Vec<CommitInfo>,
+    /// Earlier names/locations of this symbol, most recent first.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub renamed_from: Vec<RenameLink>,
+}
+
+/// Git history for every definition matching `name` (exact or `Parent.name`).
+///
+/// Each definition is traced with `git log -L` over its current line range,
+/// newest commit first, capped at `limit` commits per definition. When the
+/// index recorded the symbol under an earlier name or file, history from
+/// those older locations is appended. A `name` that no longer exists but was
+/// renamed resolves to its current definition.
+pub fn symbol_history(
+    db: &Database,
+    root: &Path,
+    name: &str,
+    limit: u32,
+) -> Result<Vec<SymbolHistory>> {
+    let mut defs = db.find_definitions(name)?;
+    if defs.is_empty() {
+        for link in db.renames_from(name)? {
+            let mut current = current_identity(db, &link)?;
+            defs.append(&mut current);
+        }
+        defs.sort_by(|a, b| a.id.cmp(&b.id));
+        defs.dedup_by(|a, b| a.id == b.id);
+    }
+
+    let mut out = Vec::with_capacity(defs.len());
+    for symbol in defs {
+        // Untracked or uncommitted files make `git log -L` fail; report them with no history.
+        let commits = git::line_history(
+            root,
+            &symbol.file_path,
+            symbol.start_line,
+            symbol.end_line.max(symbol.start_line),
+            limit,
+            None,
+        )
+        .unwrap_or_else(|e| {
+            warn!(file = %symbol.file_path, error = %e, "git history unavailable");
+            Vec::new()
+        });
+        let renamed_from = rename_chain(db, &symbol.file_path, &symbol.name)?;
+        let commits = extend_with_renames(root, commits, &renamed_from, limit);
+        out.push(SymbolHistory {
+            symbol,
+            commits,
+            renamed_from,
+        });
+    }
+    Ok(out)
+}
+
+/// Follow rename links back from `(file, name)`, guarding against cycles.
+fn rename_chain(db: &Database, file: &str, name: &str) -> Result<Vec<RenameLink>> {
+    let mut chain = Vec::new();
+    let mut seen: HashSet<(String, String)> = HashSet::new();
+    let mut frontier = vec![(file.to_string(), name.to_string())];
+    while let Some((file, name)) = frontier.pop() {
+        if chain.len() >= MAX_RENAME_DEPTH || !seen.insert((file.clone(), name.clone())) {
+            continue;
+        }
+        for link in db.renames_into(&file, &name)? {
+            frontier.push((link.old_file.clone(), link.old_name.clone()));
+            chain.push(link);
+        }
+    }
+    Ok(chain)
+}
+
+/// Current definitions a rename link points to (the target may itself be renamed later).
+fn current_identity(db: &Database, link: &RenameLink) -> Result<Vec<Symbol>> {
+    let mut file = link.new_file.clone();
+    let mut name = link.new_name.clone();
+    for _ in 0..MAX_RENAME_DEPTH {
+        let defs: Vec<Symbol> = db
+            .outline(&file)?
+            .into_iter()
+            .filter(|s| s.name == name && s.kind == link.kind)
+            .collect();
+        if !defs.is_empty() {
+            return Ok(defs);
+        }
+        match db
+            .renames_from(&name)?
+            .into_iter()
+            .find(|l| l.old_file == file)
+        {
+            Some(next) => (file, name) = (next.new_file, next.new_name),
+            None => break,
+        }
+    }
+    Ok(Vec::new())
+}
+
+/// Append commits from the symbol's previous locations, skipping ones already listed.
+fn extend_with_renames(
+    root: &Path,
+    mut commits: Vec<CommitInfo>,
+    renamed_from: &[RenameLink],
+    limit: u32,
+) -> Vec<CommitInfo> {
+    let mut seen: HashSet<String> = commits.iter().map(|c| c.sha.clone()).collect();
+    for link in renamed_from {
+        if commits.len() >= limit as usize {
+            break;
+        }
+        let Some(rev) = link.old_commit.as_deref() else {
+            continue;
+        };
+        let older = git::line_history_at(
+            root,
+            Some(rev),
+            &link.old_file,
+            link.old_start_line,
+            link.old_end_line,
+            limit,
+            None,
+        )
+        .unwrap_or_else(|e| {
+            warn!(file = %link.old_file, error = %e, "git history unavailable before rename");
+            Vec::new()
+        });
+        for c in older {
+            if seen.insert(c.sha.clone()) {
+                commits.push(c);
+            }
+        }
+    }
+    commits.truncate(limit as usize);
+    commits
+}
+
+/// Lazily blames files on first use, one `git blame` per file.
+///
+/// Files git cannot blame (untracked, outside a repository) are remembered as
+/// such and yield no attribution.
+pub struct BlameCache<'a> {
+    root: &'a Path,
+    files: HashMap<String, Option<FileBlame>>,
+}
+
+impl<'a> BlameCache<'a> {
+    pub fn new(root: &'a Path) -> Self {
+        Self {
+            root,
+            files: HashMap::new(),
+        }
+    }
+
+    /// Last author and commit for `file` lines `start..=end`.
+    pub fn last_change(&mut self, file: &str, start: u32, end: u32) -> Option<BlameInfo> {
+        let root = self.root;
+        self.files
+            .entry(file.to_string())
+            .or_insert_with(|| {
+                git::blame_file(root, file)
+                    .map_err(|e| warn!(file, error = %e, "git blame unavailable"))
+                    .ok()
+            })
+            .as_ref()?
+            .last_change(start, end.max(start))
+            .cloned()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_symbol_history_unknown_symbol_is_empty() {
+        let db = Database::open_memory().unwrap();
+        let result = symbol_history(&db, Path::new("."), "nonexistent", 5).unwrap();
+        assert!(result.is_empty());
+    }
+
+    #[test]
+    fn test_old_name_resolves_to_renamed_symbol() {
+        use crate::lineage::RenameReason;
+        use crate::types::SymbolKind;
+
+        let db = Database::open_memory().unwrap();
+        let current = Symbol::new(
+            "validate_token",
+            SymbolKind::Function,
+            "auth.py",
+            4,
+            9,
+            0,
+            80,
+        );
+        db.insert_symbol(&current).unwrap();
+        db.insert_renames(&[RenameLink {
+            old_name: "check_token".into(),
+            old_file: "auth.py".into(),
+            old_start_line: 4,
+            old_end_line: 9,
+            new_name: "validate_token".into(),
+            new_file: "auth.py".into(),
+            kind: SymbolKind::Function,
+            reason: RenameReason::SimilarContent,
+            old_commit: None,
+        }])
+        .unwrap();
+
+        let result = symbol_history(&db, &std::env::temp_dir(), "check_token", 5).unwrap();
+        assert_eq!(result.len(), 1);
+        assert_eq!(result[0].symbol.name, "validate_token");
+        assert_eq!(result[0].renamed_from.len(), 1);
+        assert_eq!(result[0].renamed_from[0].old_name, "check_token");
+    }
+
+    #[test]
+    fn test_blame_cache_untracked_file_has_no_attribution() {
+        let dir = std::env::temp_dir();
+        let mut cache = BlameCache::new(&dir);
+        assert!(cache.last_change("missing.rs", 1, 3).is_none());
+    }
+}
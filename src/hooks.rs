@@ -0,0 +1,361 @@
+//! Lifecycle hooks fired after indexing.
+//!
+//! Configured in the root `.cartog.toml`:
+//!
+//! ```toml
+//! [hooks]
+//! on_index_complete = ["make docs", { webhook = "https://ci.example.com/cartog" }]
+//! on_symbol_changed = [{ command = "./scripts/notify.sh", timeout_secs = 10 }]
+//! on_watched_impact = ["./scripts/page-owner.sh"]
+//! ```
+//!
+//! A hook is a shell command or a webhook. Commands run from the project root
+//! with the JSON payload on stdin and `CARTOG_EVENT` set. Webhooks receive the
+//! payload as a JSON `POST`. Hooks run one after another once the index is
+//! written. A hook that fails or times out is logged and never fails the index.
+//! `on_watched_impact` is fired by the watcher instead, see [`crate::alerts`].
+//!
+//! The config comes with the repository, so indexing a clone would run its
+//! commands. Hooks only run once `--allow-hooks` calls [`allow`]; otherwise they
+//! are skipped with a warning.
+
+use std::io::Write;
+use std::path::Path;
+use std::process::{Command, Stdio};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use tracing::{debug, warn};
+
+use crate::indexer::IndexResult;
+use crate::types::SymbolKind;
+
+/// Time a hook may take when `timeout_secs` is not set.
+pub const DEFAULT_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// `[hooks]`: hooks per event.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct HooksSection {
+    /// After every index run, with the run's counts.
+    pub on_index_complete: Vec<Hook>,
+    /// After an incremental index that added, removed or modified symbols.
+    pub on_symbol_changed: Vec<Hook>,
+    /// After a re-index by the watcher whose changes reach a watched symbol.
+    pub on_watched_impact: Vec<Hook>,
+}
+
+impl HooksSection {
+    pub fn is_empty(&self) -> bool {
+        self.on_index_complete.is_empty()
+            && self.on_symbol_changed.is_empty()
+            && self.on_watched_impact.is_empty()
+    }
+}
+
+/// A shell command or webhook. A bare string is a command.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(from = "HookSpec")]
+pub struct Hook {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub command: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub webhook: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub timeout_secs: Option<u64>,
+}
+
+#[derive(Deserialize)]
+#[serde(untagged)]
+enum HookSpec {
+    Command(String),
+    Full {
+        command: Option<String>,
+        webhook: Option<String>,
+        timeout_secs: Option<u64>,
+    },
+}
+
+impl From<HookSpec> for Hook {
+    fn from(spec: HookSpec) -> Self {
+        match spec {
+            HookSpec::Command(command) => Self {
+                command: Some(command),
+                webhook: None,
+                timeout_secs: None,
+            },
+            HookSpec::Full {
+                command,
+                webhook,
+                timeout_secs,
+            } => Self {
+                command,
+                webhook,
+                timeout_secs,
+            },
+        }
+    }
+}
+
+impl Hook {
+    fn timeout(&self) -> Duration {
+        self.timeout_secs
+            .map_or(DEFAULT_TIMEOUT, Duration::from_secs)
+    }
+
+    fn describe(&self) -> &str {
+        self.command
+            .as_deref()
+            .or(self.webhook.as_deref())
+            .unwrap_or("<empty hook>")
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ChangeKind {
+    Added,
+    Removed,
+    Modified,
+}
+
+/// A symbol whose definition changed in an index run.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct SymbolChange {
+    pub change: ChangeKind,
+    pub name: String,
+    pub kind: SymbolKind,
+    pub file_path: String,
+    pub line: u32,
+}
+
+/// JSON sent to every hook.
+#[derive(Debug, Serialize)]
+pub struct HookPayload<'a> {
+    pub event: &'static str,
+    pub root: String,
+    pub result: &'a IndexResult,
+    #[serde(skip_serializing_if = "<[_]>::is_empty")]
+    pub changes: &'a [SymbolChange],
+}
+
+/// Fire the hooks for a finished index run.
+pub fn fire(hooks: &HooksSection, root: &Path, result: &IndexResult, changes: &[SymbolChange]) {
+    let root_str = root.to_string_lossy().into_owned();
+    let mut events: Vec<(&'static str, &[Hook], &[SymbolChange])> = vec![(
+        "index_complete",
+        hooks.on_index_complete.as_slice(),
+        &[][..],
+    )];
+    if !changes.is_empty() {
+        events.push((
+            "symbol_changed",
+            hooks.on_symbol_changed.as_slice(),
+            changes,
+        ));
+    }
+    for (event, list, changes) in events {
+        if list.is_empty() {
+            continue;
+        }
+        let payload = HookPayload {
+            event,
+            root: root_str.clone(),
+            result,
+            changes,
+        };
+        match serde_json::to_string(&payload) {
+            Ok(body) => run_all(list, event, &body, root),
+            Err(e) => warn!(event, error = %e, "failed to serialize hook payload"),
+        }
+    }
+}
+
+static ALLOWED: AtomicBool = AtomicBool::new(false);
+static SKIP_WARNED: AtomicBool = AtomicBool::new(false);
+
+/// Run configured hooks for the rest of this process's life (`--allow-hooks`).
+pub fn allow() {
+    ALLOWED.store(true, Ordering::Relaxed);
+}
+
+pub fn allowed() -> bool {
+    ALLOWED.load(Ordering::Relaxed)
+}
+
+/// Run `list` one after another with `body` as payload, logging failures.
+pub(crate) fn run_all(list: &[Hook], event: &str, body: &str, root: &Path) {
+    if !allowed() {
+        // Once per process: the watcher fires on every re-index.
+        if !SKIP_WARNED.swap(true, Ordering::Relaxed) {
+            warn!(
+                event,
+                hooks = list.len(),
+                "skipping the hooks in .cartog.toml; pass --allow-hooks to run them"
+            );
+        }
+        return;
+    }
+    for hook in list {
+        debug!(event, hook = hook.describe(), "running hook");
+        if let Err(e) = run(hook, event, body, root) {
+            warn!(event, hook = hook.describe(), error = %format!("{e:#}"), "hook failed");
+        }
+    }
+}
+
+fn run(hook: &Hook, event: &str, body: &str, root: &Path) -> Result<()> {
+    if let Some(command) = &hook.command {
+        run_command(command, event, body, root, hook.timeout())?;
+    }
+    if let Some(url) = &hook.webhook {
+        ureq::post(url)
+            .set("Content-Type", "application/json")
+            .set("X-Cartog-Event", event)
+            .timeout(hook.timeout())
+            .send_string(body)
+            .with_context(|| format!("POST {url}"))?;
+    }
+    Ok(())
+}
+
+fn run_command(
+    command: &str,
+    event: &str,
+    body: &str,
+    root: &Path,
+    timeout: Duration,
+) -> Result<()> {
+    if !crate::capabilities::shell_allowed() {
+        anyhow::bail!("running `{command}` is forbidden by the server's capabilities");
+    }
+    let (shell, flag) = if cfg!(windows) {
+        ("cmd", "/C")
+    } else {
+        ("sh", "-c")
+    };
+    let mut child = Command::new(shell)
+        .arg(flag)
+        .arg(command)
+        .current_dir(root)
+        .env("CARTOG_EVENT", event)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .spawn()
+        .with_context(|| format!("failed to start `{command}`"))?;
+    // Written from another thread: a hook that never reads a payload larger
+    // than the pipe buffer would otherwise block us before the deadline applies.
+    // Once the child is killed the write fails and the thread ends.
+    if let Some(mut stdin) = child.stdin.take() {
+        let body = body.to_string();
+        std::thread::spawn(move || {
+            // A hook that ignores its input closes the pipe early; that is not an error.
+            let _ = stdin.write_all(body.as_bytes());
+        });
+    }
+
+    let deadline = Instant::now() + timeout;
+    loop {
+        if let Some(status) = child.try_wait()? {
+            anyhow::ensure!(status.success(), "`{command}` exited with {status}");
+            return Ok(());
+        }
+        if Instant::now() >= deadline {
+            let _ = child.kill();
+            let _ = child.wait();
+            anyhow::bail!("`{command}` timed out after {}s", timeout.as_secs());
+        }
+        std::thread::sleep(Duration::from_millis(20));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::ConfigFile;
+
+    #[test]
+    fn test_hooks_accept_strings_and_tables() {
+        let file = ConfigFile::parse(
+            r#"
+            [hooks]
+            on_index_complete = ["make docs", { webhook = "http://localhost:9/x", timeout_secs = 2 }]
+            "#,
+        )
+        .unwrap();
+        let hooks = &file.hooks.on_index_complete;
+        assert_eq!(hooks[0].command.as_deref(), Some("make docs"));
+        assert_eq!(hooks[1].webhook.as_deref(), Some("http://localhost:9/x"));
+        assert_eq!(hooks[1].timeout(), Duration::from_secs(2));
+        assert!(file.hooks.on_symbol_changed.is_empty());
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_hook_ignoring_a_large_payload_times_out() {
+        let body = "x".repeat(1 << 20);
+        let started = Instant::now();
+        let err = run_command(
+            "sleep 5",
+            "on_symbol_changed",
+            &body,
+            &std::env::temp_dir(),
+            Duration::from_millis(200),
+        )
+        .unwrap_err();
+        assert!(err.to_string().contains("timed out"));
+        assert!(started.elapsed() < Duration::from_secs(4));
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_command_hook_gets_payload_on_stdin() {
+        let dir = std::env::temp_dir().join(format!("cartog-hooks-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        let hooks = HooksSection {
+            on_symbol_changed: vec![Hook::from(HookSpec::Command(
+                "cat > payload.json; echo $CARTOG_EVENT > event".into(),
+            ))],
+            ..HooksSection::default()
+        };
+        let changes = [SymbolChange {
+            change: ChangeKind::Modified,
+            name: "login".into(),
+            kind: SymbolKind::Function,
+            file_path: "auth.py".into(),
+            line: 3,
+        }];
+        fire(&hooks, &dir, &IndexResult::default(), &changes);
+        assert!(!dir.join("event").exists());
+
+        allow();
+        fire(&hooks, &dir, &IndexResult::default(), &changes);
+
+        let payload: serde_json::Value =
+            serde_json::from_str(&std::fs::read_to_string(dir.join("payload.json")).unwrap())
+                .unwrap();
+        assert_eq!(payload["event"], "symbol_changed");
+        assert_eq!(payload["changes"][0]["change"], "modified");
+        assert_eq!(
+            std::fs::read_to_string(dir.join("event")).unwrap().trim(),
+            "symbol_changed"
+        );
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_command_hook_times_out() {
+        let err = run_command(
+            "sleep 5",
+            "index_complete",
+            "{}",
+            &std::env::temp_dir(),
+            Duration::from_millis(100),
+        )
+        .unwrap_err();
+        assert!(err.to_string().contains("timed out"));
+    }
+}
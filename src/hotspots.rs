@@ -0,0 +1,281 @@
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::git;
+use crate::types::SymbolKind;
+
+/// How many candidates (per requested result) get an exact per-symbol churn count.
+///
+/// Ranking starts from file-level churn (one `git log` for the whole repo); only the
+/// most promising functions are then re-counted with `git log -L`, which costs one
+/// git invocation each.
+const REFINE_FACTOR: usize = 3;
+
+/// Granularity of a hotspot ranking.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Granularity {
+    Function,
+    Package,
+}
+
+/// A frequently-changed, heavily-depended-upon symbol or package.
+#[derive(Debug, Clone, Serialize)]
+pub struct Hotspot {
+    /// Function/method name, or directory path for packages.
+    pub name: String,
+    pub kind: String,
+    pub file_path: Option<String>,
+    pub line: Option<u32>,
+    /// Number of commits that touched it.
+    pub churn: u32,
+    /// Distinct symbols (or packages) that depend on it.
+    pub fan_in: u32,
+    pub score: f64,
+}
+
+/// Combine churn and fan-in into one score.
+///
+/// Churn dominates; fan-in scales it logarithmically so a rarely-edited utility with
+/// hundreds of callers does not outrank code that changes every week.
+/// A symbol with no dependents scores exactly its churn.
+pub fn hotspot_score(churn: u32, fan_in: u32) -> f64 {
+    f64::from(churn) * (2.0 + f64::from(fan_in)).log2()
+}
+
+/// Rank code by churn × centrality.
+///
+/// `since` limits the git history considered (`git log --since` syntax).
+pub fn hotspots(
+    db: &Database,
+    root: &Path,
+    granularity: Granularity,
+    since: Option<&str>,
+    limit: usize,
+) -> Result<Vec<Hotspot>> {
+    let commits = git::commit_files(root, since)?;
+    let endpoints = db.resolved_edge_endpoints()?;
+    match granularity {
+        Granularity::Function => function_hotspots(db, root, &commits, &endpoints, since, limit),
+        Granularity::Package => {
+            let files: HashSet<String> = db.all_files()?.into_iter().collect();
+            Ok(rank_packages(&commits, &files, &endpoints, limit))
+        }
+    }
+}
+
+fn function_hotspots(
+    db: &Database,
+    root: &Path,
+    commits: &[(String, Vec<String>)],
+    endpoints: &[(String, String, String, String)],
+    since: Option<&str>,
+    limit: usize,
+) -> Result<Vec<Hotspot>> {
+    let churn = file_churn(commits);
+
+    let mut callers: HashMap<&str, HashSet<&str>> = HashMap::new();
+    for (source_id, _, target_id, _) in endpoints {
+        if source_id != target_id {
+            callers
+                .entry(target_id.as_str())
+                .or_default()
+                .insert(source_id.as_str());
+        }
+    }
+
+    // (hotspot, end_line) — the end line is only needed for the refinement pass.
+    let mut candidates: Vec<(Hotspot, u32)> = db
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| matches!(s.kind, SymbolKind::Function | SymbolKind::Method))
+        .filter_map(|s| {
+            let file_churn = *churn.get(&s.file_path)?;
+            let fan_in = callers.get(s.id.as_str()).map_or(0, |c| c.len() as u32);
+            let end_line = s.end_line.max(s.start_line);
+            Some((
+                Hotspot {
+                    score: hotspot_score(file_churn, fan_in),
+                    name: s.name,
+                    kind: s.kind.to_string(),
+                    file_path: Some(s.file_path),
+                    line: Some(s.start_line),
+                    churn: file_churn,
+                    fan_in,
+                },
+                end_line,
+            ))
+        })
+        .collect();
+    candidates.sort_by(|a, b| compare_score(&a.0, &b.0));
+    candidates.truncate(limit.saturating_mul(REFINE_FACTOR));
+
+    // Refine: exact commit count over each candidate's own line range.
+    let mut candidates: Vec<Hotspot> = candidates
+        .into_iter()
+        .map(|(mut h, end)| {
+            if let (Some(file), Some(start)) = (h.file_path.as_deref(), h.line) {
+                if let Ok(log) = git::line_history(root, file, start, end, u32::MAX, since) {
+                    h.churn = log.len() as u32;
+                    h.score = hotspot_score(h.churn, h.fan_in);
+                }
+            }
+            h
+        })
+        .collect();
+    candidates.retain(|h| h.churn > 0);
+    sort_by_score(&mut candidates);
+    candidates.truncate(limit);
+    Ok(candidates)
+}
+
+/// Commits per file path.
+fn file_churn(commits: &[(String, Vec<String>)]) -> HashMap<String, u32> {
+    let mut churn = HashMap::new();
+    for (_, files) in commits {
+        for f in files {
+            *churn.entry(f.clone()).or_insert(0) += 1;
+        }
+    }
+    churn
+}
+
+/// Directory of a file path (`"."` for top-level files).
+pub fn package_of(file_path: &str) -> &str {
+    match file_path.rsplit_once('/') {
+        Some((dir, _)) => dir,
+        None => ".",
+    }
+}
+
+/// Rank directories by commits touching any of their indexed files, weighted by
+/// the number of other directories that depend on them.
+fn rank_packages(
+    commits: &[(String, Vec<String>)],
+    indexed_files: &HashSet<String>,
+    endpoints: &[(String, String, String, String)],
+    limit: usize,
+) -> Vec<Hotspot> {
+    let mut churn: HashMap<&str, u32> = HashMap::new();
+    for (_, files) in commits {
+        let pkgs: HashSet<&str> = files
+            .iter()
+            .filter(|f| indexed_files.contains(*f))
+            .map(|f| package_of(f))
+            .collect();
+        for pkg in pkgs {
+            *churn.entry(pkg).or_insert(0) += 1;
+        }
+    }
+
+    let mut dependents: HashMap<&str, HashSet<&str>> = HashMap::new();
+    for (_, source_file, _, target_file) in endpoints {
+        let (from, to) = (package_of(source_file), package_of(target_file));
+        if from != to {
+            dependents.entry(to).or_default().insert(from);
+        }
+    }
+
+    let mut ranked: Vec<Hotspot> = churn
+        .into_iter()
+        .map(|(pkg, churn)| {
+            let fan_in = dependents.get(pkg).map_or(0, |d| d.len() as u32);
+            Hotspot {
+                name: pkg.to_string(),
+                kind: "package".to_string(),
+                file_path: None,
+                line: None,
+                churn,
+                fan_in,
+                score: hotspot_score(churn, fan_in),
+            }
+        })
+        .collect();
+    sort_by_score(&mut ranked);
+    ranked.truncate(limit);
+    ranked
+}
+
+/// Highest score first; ties broken by name for deterministic output.
+fn sort_by_score(items: &mut [Hotspot]) {
+    items.sort_by(compare_score);
+}
+
+fn compare_score(a: &Hotspot, b: &Hotspot) -> std::cmp::Ordering {
+    b.score
+        .partial_cmp(&a.score)
+        .unwrap_or(std::cmp::Ordering::Equal)
+        .then_with(|| a.name.cmp(&b.name))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn commit(sha: &str, files: &[&str]) -> (String, Vec<String>) {
+        (
+            sha.to_string(),
+            files.iter().map(|f| f.to_string()).collect(),
+        )
+    }
+
+    fn endpoint(src_file: &str, tgt_file: &str) -> (String, String, String, String) {
+        (
+            format!("{src_file}:a:1"),
+            src_file.to_string(),
+            format!("{tgt_file}:b:1"),
+            tgt_file.to_string(),
+        )
+    }
+
+    #[test]
+    fn test_score_without_dependents_is_churn() {
+        assert_eq!(hotspot_score(5, 0), 5.0);
+        assert!(hotspot_score(5, 10) > hotspot_score(5, 1));
+        assert!(hotspot_score(10, 0) > hotspot_score(2, 3));
+    }
+
+    #[test]
+    fn test_package_of() {
+        assert_eq!(package_of("internal/auth/token.go"), "internal/auth");
+        assert_eq!(package_of("main.go"), ".");
+    }
+
+    #[test]
+    fn test_file_churn_counts_commits() {
+        let commits = vec![commit("a", &["x.go", "y.go"]), commit("b", &["x.go"])];
+        let churn = file_churn(&commits);
+        assert_eq!(churn["x.go"], 2);
+        assert_eq!(churn["y.go"], 1);
+    }
+
+    #[test]
+    fn test_rank_packages_counts_commits_once_per_package() {
+        let commits = vec![
+            commit("a", &["db/pool.go", "db/conn.go"]),
+            commit("b", &["db/pool.go"]),
+            commit("c", &["api/routes.go", "README.md"]),
+        ];
+        let files: HashSet<String> = ["db/pool.go", "db/conn.go", "api/routes.go"]
+            .iter()
+            .map(|s| s.to_string())
+            .collect();
+        let endpoints = vec![
+            endpoint("api/routes.go", "db/pool.go"),
+            endpoint("db/conn.go", "db/pool.go"),
+        ];
+
+        let ranked = rank_packages(&commits, &files, &endpoints, 10);
+        assert_eq!(ranked[0].name, "db");
+        assert_eq!(ranked[0].churn, 2);
+        assert_eq!(ranked[0].fan_in, 1, "same-package edges do not count");
+        assert_eq!(ranked[1].name, "api");
+        assert!(
+            ranked.iter().all(|h| h.name != "."),
+            "unindexed files are ignored"
+        );
+    }
+}
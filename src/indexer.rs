@@ -1,14 +1,25 @@
+use std::collections::{HashMap, HashSet};
 use std::path::Path;
+use std::sync::mpsc::SyncSender;
+use std::sync::{Mutex, MutexGuard};
 use std::time::SystemTime;
 
 use anyhow::{Context, Result};
 use sha2::{Digest, Sha256};
-use tracing::warn;
+use tracing::{info_span, warn};
 use walkdir::WalkDir;
 
+use crate::config::ProjectConfig;
 use crate::db::Database;
-use crate::languages::{detect_language, get_extractor, Extractor};
-use crate::types::FileInfo;
+use crate::git::{self, current_branch, git_cmd, head_commit, parse_git_lines};
+use crate::hooks::{self, ChangeKind, SymbolChange};
+use crate::languages::detect_language;
+use crate::lineage;
+use crate::pipeline::{self, ParseJob, ParseOutcome, ParsedFile, PipelineConfig};
+use crate::plugins::PluginRegistry;
+use crate::rank;
+use crate::types::{FileInfo, Symbol, SymbolKind};
+use crate::vendor;
 
 /// Summary of an indexing operation.
 #[derive(Debug, Default, serde::Serialize)]
@@ -16,175 +27,753 @@ pub struct IndexResult {
     pub files_indexed: u32,
     pub files_skipped: u32,
     pub files_removed: u32,
+    /// Of the files indexed, those restored from the parse cache instead of parsed.
+    pub files_restored: u32,
     pub symbols_added: u32,
     pub edges_added: u32,
     pub edges_resolved: u32,
 }
 
+/// Files written per transaction. Large enough to amortize commit cost (one fsync
+/// per batch in WAL mode), small enough that a crash loses little work.
+const WRITE_BATCH_FILES: u32 = 256;
+
+/// Metadata keys recording the checkout an index was built from.
+const META_LAST_COMMIT: &str = "last_commit";
+const META_LAST_BRANCH: &str = "last_branch";
+/// Newline-separated files that had uncommitted changes at the last index.
+const META_DIRTY_FILES: &str = "dirty_files";
+/// Unix seconds at which the last index run finished.
+const META_INDEXED_AT: &str = "indexed_at";
+/// Unix nanoseconds of the same moment, telling apart runs within one second.
+pub(crate) const META_INDEX_STAMP: &str = "index_stamp";
+/// Branches whose index state is kept; the least recently indexed go first.
+const MAX_BRANCH_STATES: usize = 8;
+
 /// Index a directory, updating the database incrementally.
 ///
 /// Change detection strategy (in order):
 /// 1. `force = true` → re-index everything, no checks
 /// 2. Git-based → diff `last_commit..HEAD` to find changed files, skip the rest without reading
 /// 3. SHA-256 fallback → read file, hash it, compare to stored hash
+///
+/// Each run on a branch saves that branch's state: its commit, dirty files and the
+/// hash of every file. The graph tables hold one checkout, and are shared by all
+/// branches for the files they have in common. Files written while on a branch
+/// (not on a first or forced index) also go to a parse cache keyed by path and
+/// content hash, kept as long as some saved state refers to them. Switching
+/// branches diffs `last_commit..HEAD` as usual, and each differing file whose
+/// content a saved state has is restored from the cache rather than parsed. So
+/// after one round trip, moving between branches costs a hash per differing file.
+/// Files that were dirty at the previous index are always re-checked, since their
+/// indexed content may match neither commit.
 pub fn index_directory(db: &Database, root: &Path, force: bool) -> Result<IndexResult> {
-    let mut result = IndexResult::default();
+    index_directory_with(db, root, force, &PipelineConfig::default())
+}
 
-    let root = root.canonicalize().context("Failed to resolve root path")?;
+/// [`index_directory`] with explicit parse-pipeline settings (worker count, memory cap).
+///
+/// Files are read and parsed in parallel (see [`crate::pipeline`]); all database
+/// writes stay on the calling thread.
+pub fn index_directory_with(
+    db: &Database,
+    root: &Path,
+    force: bool,
+    config: &PipelineConfig,
+) -> Result<IndexResult> {
+    index_directory_changes(db, root, force, config).map(|(result, _)| result)
+}
 
-    // Cache one extractor (with its Parser) per language to avoid recreating parsers per file.
-    let mut extractors: std::collections::HashMap<&'static str, Box<dyn Extractor>> =
-        std::collections::HashMap::new();
+/// [`index_directory_with`], also returning the symbols the run added, removed
+/// or modified. None are reported on a first index.
+pub fn index_directory_changes(
+    db: &Database,
+    root: &Path,
+    force: bool,
+    config: &PipelineConfig,
+) -> Result<(IndexResult, Vec<SymbolChange>)> {
+    let mut result = IndexResult::default();
 
-    // Collect files that should be indexed
-    let mut current_files = std::collections::HashSet::new();
+    let root = root.canonicalize().context("Failed to resolve root path")?;
+    let project = ProjectConfig::load(&root)?;
+    let plugins = PluginRegistry::discover(&root.join(project.plugin_dir()))?;
 
     // Git-based change detection: get set of files changed since last indexed commit
     let last_commit = if force {
         None
     } else {
-        db.get_metadata("last_commit")?
+        db.get_metadata(META_LAST_COMMIT)?
     };
+    // Symbols that vanished / appeared in this run, for rename tracking (see `lineage`).
+    // Skipped on the first index: nothing can have been renamed yet.
+    let track_renames = db.has_indexed_files()?;
+    let mut vanished: Vec<(Symbol, String)> = Vec::new();
+    let mut appeared: Vec<(Symbol, String)> = Vec::new();
+    // Same name and kind as before, different body; reported to `on_symbol_changed` hooks.
+    let mut modified: Vec<Symbol> = Vec::new();
+    let previous_commit = db.get_metadata(META_LAST_COMMIT)?;
+
     let changed_files = if force {
         None
     } else {
-        git_changed_files(&root, last_commit.as_deref())
-    };
-
-    for entry in WalkDir::new(&root)
-        .follow_links(true)
-        .into_iter()
-        .filter_entry(|e| !is_ignored(e))
-    {
-        let entry = match entry {
-            Ok(e) => e,
-            Err(e) => {
-                warn!(error = %e, "directory walk error");
-                continue;
+        git_changed_files(&root, last_commit.as_deref()).map(|mut changed| {
+            if let Ok(Some(dirty)) = db.get_metadata(META_DIRTY_FILES) {
+                changed.extend(dirty.lines().map(str::to_string));
             }
-        };
-
-        if !entry.file_type().is_file() {
-            continue;
-        }
-
-        let path = entry.path();
-        let rel_path = match path.strip_prefix(&root) {
-            Ok(p) => p.to_string_lossy().to_string(),
-            Err(_) => continue,
-        };
+            changed
+        })
+    };
 
-        let lang = match detect_language(Path::new(&rel_path)) {
-            Some(l) => l,
-            None => continue,
-        };
+    // Stored hashes, loaded once so workers can skip unchanged files without the db.
+    let known_hashes = db.file_hashes()?;
+    let cached = if force {
+        HashSet::new()
+    } else {
+        db.parse_cache_keys()?
+    };
+    let branch = current_branch(&root);
 
-        current_files.insert(rel_path.clone());
+    let tagging = project.has_tags();
+    let with_vendor = project.vendor();
+    let walk = |jobs: &SyncSender<ParseJob>| {
+        // Collect files that should be indexed
+        let mut current_files = HashSet::new();
+        let mut git_skipped = 0u32;
 
-        // ── Change detection (deferred file read) ──
-        if !force {
-            if let Some(ref changed) = changed_files {
-                // Git-based: skip files not in the changed set that already exist in db
-                if !changed.contains(&rel_path) && db.get_file(&rel_path)?.is_some() {
-                    result.files_skipped += 1;
+        for entry in WalkDir::new(&root)
+            .follow_links(true)
+            .into_iter()
+            .filter_entry(|e| !is_ignored(e) || (with_vendor && is_vendor_dir(e)))
+        {
+            let entry = match entry {
+                Ok(e) => e,
+                Err(e) => {
+                    warn!(error = %e, "directory walk error");
                     continue;
                 }
+            };
+
+            if !entry.file_type().is_file() {
+                continue;
             }
-        }
 
-        let source = match std::fs::read_to_string(path) {
-            Ok(s) => s,
-            Err(e) if e.kind() == std::io::ErrorKind::InvalidData => continue, // binary file
-            Err(e) => {
-                warn!(file = %rel_path, error = %e, "cannot read file");
+            let path = entry.path();
+            let rel_path = match path.strip_prefix(&root) {
+                Ok(p) => p.to_string_lossy().to_string(),
+                Err(_) => continue,
+            };
+
+            let lang = match detect_language(Path::new(&rel_path))
+                .or_else(|| plugins.detect(Path::new(&rel_path)))
+            {
+                Some(l) => l,
+                None => continue,
+            };
+            if project.is_ignored(&rel_path) || !project.language_enabled(&rel_path, lang) {
+                continue;
+            }
+            // Only Go vendors its dependencies as source.
+            if lang != "go" && vendor::is_vendored(&rel_path) {
                 continue;
             }
-        };
 
-        let hash = file_hash(&source);
+            current_files.insert(rel_path.clone());
 
-        // Hash-based check: even for git-detected changes, skip if content is identical
-        // (handles touched-but-not-modified files)
-        if !force {
-            if let Ok(Some(existing)) = db.get_file(&rel_path) {
-                if existing.hash == hash {
-                    result.files_skipped += 1;
-                    continue;
+            // ── Change detection (deferred file read) ──
+            if !force {
+                if let Some(ref changed) = changed_files {
+                    // Git-based: skip files not in the changed set that already exist in db
+                    if !changed.contains(&rel_path) && known_hashes.contains_key(&rel_path) {
+                        git_skipped += 1;
+                        continue;
+                    }
                 }
             }
-        }
 
-        let modified = file_modified(path);
+            let job = ParseJob {
+                passes: project.passes(&rel_path, lang),
+                preambles: tagging,
+                rel_path,
+                path: path.to_path_buf(),
+                lang: lang.to_string(),
+            };
+            if jobs.send(job).is_err() {
+                break; // writer failed; the error surfaces from `pipeline::run`
+            }
+        }
+        (current_files, git_skipped)
+    };
 
-        // Extract symbols and edges — reuse the cached extractor for this language
-        // so the tree-sitter Parser inside is allocated only once per language.
-        let extractor = extractors
-            .entry(lang)
-            .or_insert_with(|| get_extractor(lang).expect("lang was validated by detect_language"))
-            .as_mut();
+    // First index into an empty database: build secondary indexes once at the end
+    // instead of maintaining them on every insert.
+    let bulk_load = known_hashes.is_empty();
+    if bulk_load {
+        db.begin_bulk_load()?;
+    }
+    // A first or forced index would copy every file into the cache; later runs
+    // only write what changed, which is what a branch switch may bring back.
+    let caching = branch.is_some() && !bulk_load && !force;
+    db.begin_batch()?;
+    let mut batched = 0u32;
+
+    let parse_span = info_span!("parse_and_store").entered();
+    let walked = pipeline::run(
+        config,
+        &plugins,
+        &known_hashes,
+        &cached,
+        force,
+        walk,
+        |outcome| {
+            let (parsed, restored) = match outcome {
+                ParseOutcome::Unchanged => {
+                    result.files_skipped += 1;
+                    return Ok(());
+                }
+                ParseOutcome::Parsed(parsed) => (parsed, false),
+                ParseOutcome::Cached(file) => {
+                    let raw = db
+                        .cached_parse(&file.rel_path, &file.hash, &file.settings)?
+                        .with_context(|| format!("{} left the parse cache", file.rel_path))?;
+                    let mut parsed: ParsedFile = serde_json::from_str(&raw).with_context(|| {
+                        format!("corrupt parse cache entry for {}", file.rel_path)
+                    })?;
+                    parsed.modified = file.modified;
+                    result.files_restored += 1;
+                    (parsed, true)
+                }
+            };
+            let rel_path = parsed.rel_path.as_str();
+
+            // Files new to the index have nothing to snapshot or clear.
+            let known = known_hashes.contains_key(rel_path);
+            let previous = if track_renames && known {
+                snapshot_symbols(db, rel_path)?
+            } else {
+                Vec::new()
+            };
+
+            // Clear old data and insert new
+            if known {
+                db.clear_file_data(rel_path)?;
+            }
 
-        let extraction = match extractor.extract(&source, &rel_path) {
-            Ok(e) => e,
-            Err(err) => {
-                warn!(file = %rel_path, error = %err, "extraction failed");
-                continue;
+            let num_symbols = parsed.symbols.len() as u32;
+            let num_edges = parsed.edges.len() as u32;
+
+            db.insert_symbols(&parsed.symbols)?;
+            db.insert_edges(&parsed.edges)?;
+            db.insert_complexity(rel_path, &parsed.complexity)?;
+            db.insert_fingerprints(rel_path, &parsed.fingerprints)?;
+            db.insert_error_flows(rel_path, &parsed.fallible, &parsed.error_flows)?;
+            db.insert_panic_sites(rel_path, &parsed.panic_sites)?;
+            db.insert_sync_sites(rel_path, &parsed.sync_sites)?;
+            db.insert_context_sites(rel_path, &parsed.takes_context, &parsed.context_sites)?;
+            db.insert_variable_accesses(rel_path, &parsed.globals, &parsed.variable_accesses)?;
+            db.insert_routes(rel_path, &parsed.routes)?;
+            db.insert_config_fields(rel_path, &parsed.config_fields, &parsed.field_uses)?;
+            db.insert_serializations(rel_path, &parsed.struct_fields, &parsed.serializations)?;
+            db.insert_constants(rel_path, &parsed.constants)?;
+            db.insert_type_assertions(rel_path, &parsed.type_assertions)?;
+            db.insert_test_doubles(rel_path, &parsed.test_doubles)?;
+            db.insert_constructions(rel_path, &parsed.constructions)?;
+            db.insert_syntax_errors(rel_path, &parsed.syntax_errors)?;
+            db.insert_log_statements(rel_path, &parsed.log_statements)?;
+            db.insert_todos(rel_path, &parsed.todos)?;
+            if tagging {
+                let preambles: HashMap<&str, &str> = parsed
+                    .preambles
+                    .iter()
+                    .map(|(id, text)| (id.as_str(), text.as_str()))
+                    .collect();
+                let tags: Vec<(&str, String)> = parsed
+                    .symbols
+                    .iter()
+                    .flat_map(|sym| {
+                        let preamble = preambles.get(sym.id.as_str()).copied().unwrap_or("");
+                        project
+                            .tags(sym, preamble)
+                            .into_iter()
+                            .map(move |tag| (sym.id.as_str(), tag))
+                    })
+                    .collect();
+                db.insert_symbol_tags(rel_path, &tags)?;
             }
-        };
 
-        // Clear old data and insert new
-        db.clear_file_data(&rel_path)?;
+            if track_renames {
+                let old_ids = symbol_keys(previous.iter().map(|(s, _, _)| s));
+                let new_ids = symbol_keys(parsed.symbols.iter());
+                let old_hashes: HashMap<&SymbolKey, &str> = previous
+                    .iter()
+                    .map(|(s, _, hash)| (&old_ids[s.id.as_str()], hash.as_str()))
+                    .collect();
+                let new_keys: HashSet<&SymbolKey> = new_ids.values().collect();
+                let by_id: HashMap<&str, &Symbol> =
+                    parsed.symbols.iter().map(|s| (s.id.as_str(), s)).collect();
+                // Empty without the content pass: renames are then only matched
+                // across file renames, not by body similarity.
+                let contents: HashMap<&str, &str> = parsed
+                    .contents
+                    .iter()
+                    .map(|(id, _, content, _)| (id.as_str(), content.as_str()))
+                    .collect();
+                for (id, hash) in &parsed.body_hashes {
+                    if let Some(sym) = by_id.get(id.as_str()) {
+                        match old_hashes.get(&new_ids[id.as_str()]) {
+                            None => {
+                                let content = contents.get(id.as_str()).copied().unwrap_or("");
+                                appeared.push(((*sym).clone(), content.to_string()));
+                            }
+                            Some(old) if *old != hash.as_str() => modified.push((*sym).clone()),
+                            Some(_) => {}
+                        }
+                    }
+                }
+                vanished.extend(
+                    previous
+                        .iter()
+                        .filter(|(s, _, _)| !new_keys.contains(&old_ids[s.id.as_str()]))
+                        .map(|(s, content, _)| (s.clone(), content.clone())),
+                );
+            }
 
-        let num_symbols = extraction.symbols.len() as u32;
-        let num_edges = extraction.edges.len() as u32;
+            db.insert_symbol_hashes(rel_path, &parsed.body_hashes)?;
+            // Store symbol content for RAG/semantic search
+            if !parsed.contents.is_empty() {
+                db.insert_symbol_contents(&parsed.contents)?;
+            }
 
-        db.insert_symbols(&extraction.symbols)?;
-        db.insert_edges(&extraction.edges)?;
+            if caching && !restored {
+                if let Some(settings) = &parsed.settings {
+                    db.cache_parse(
+                        rel_path,
+                        &parsed.hash,
+                        settings,
+                        &serde_json::to_string(&parsed)?,
+                    )?;
+                }
+            }
 
-        // Store symbol content for RAG/semantic search
-        let contents: Vec<(String, String, String, String)> = extraction
-            .symbols
-            .iter()
-            .filter(|sym| sym.kind != crate::types::SymbolKind::Import)
-            .filter_map(|sym| {
-                extract_symbol_content(&source, sym)
-                    .map(|(content, header)| (sym.id.clone(), sym.name.clone(), content, header))
-            })
-            .collect();
-        if !contents.is_empty() {
-            db.insert_symbol_contents(&contents)?;
-        }
+            db.upsert_file(&FileInfo {
+                path: parsed.rel_path.clone(),
+                last_modified: parsed.modified,
+                hash: parsed.hash,
+                language: parsed.lang,
+                num_symbols,
+            })?;
+
+            result.files_indexed += 1;
+            result.symbols_added += num_symbols;
+            result.edges_added += num_edges;
+
+            batched += 1;
+            if batched >= WRITE_BATCH_FILES {
+                db.commit_batch()?;
+                db.begin_batch()?;
+                batched = 0;
+            }
+            Ok(())
+        },
+    );
 
-        db.upsert_file(&FileInfo {
-            path: rel_path,
-            last_modified: modified,
-            hash,
-            language: lang.to_string(),
-            num_symbols,
-        })?;
+    drop(parse_span);
 
-        result.files_indexed += 1;
-        result.symbols_added += num_symbols;
-        result.edges_added += num_edges;
+    let finished = match walked {
+        Ok(walked) => db.commit_batch().map(|()| walked),
+        Err(e) => {
+            db.rollback_batch()?;
+            Err(e)
+        }
+    };
+    if bulk_load {
+        info_span!("rebuild_indexes").in_scope(|| db.end_bulk_load())?;
     }
+    let (current_files, git_skipped) = finished?;
+    result.files_skipped += git_skipped;
 
     // Remove files that no longer exist
     let all_indexed = db.all_files()?;
     for indexed_path in all_indexed {
         if !current_files.contains(&indexed_path) {
+            if track_renames {
+                vanished.extend(
+                    snapshot_symbols(db, &indexed_path)?
+                        .into_iter()
+                        .map(|(s, content, _)| (s, content)),
+                );
+            }
             db.remove_file(&indexed_path)?;
             result.files_removed += 1;
         }
     }
 
     // Resolve edges
-    result.edges_resolved = db.resolve_edges()?;
+    result.edges_resolved = info_span!("resolve_edges").in_scope(|| db.resolve_edges())?;
+    if with_vendor {
+        result.edges_resolved += info_span!("resolve_vendor").in_scope(|| vendor::resolve(db))?;
+    }
+    info_span!("rank").in_scope(|| rank::compute(db))?;
+
+    if !vanished.is_empty() && !appeared.is_empty() {
+        let _span = info_span!("detect_renames").entered();
+        let file_renames: HashMap<String, String> = previous_commit
+            .as_deref()
+            .and_then(|from| git::renamed_files(&root, from, "HEAD").ok())
+            .unwrap_or_default()
+            .into_iter()
+            .collect();
+        let links = lineage::detect_renames(
+            &vanished,
+            &appeared,
+            &file_renames,
+            previous_commit.as_deref(),
+        );
+        db.insert_renames(&links)?;
+    }
+
+    let elapsed = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .unwrap_or_default();
+    let now = elapsed.as_secs();
+    db.set_metadata(META_INDEXED_AT, &now.to_string())?;
+    db.set_metadata(META_INDEX_STAMP, &elapsed.as_nanos().to_string())?;
+
+    // Store the current git checkout as last indexed
+    if let Some(commit) = head_commit(&root) {
+        db.set_metadata(META_LAST_COMMIT, &commit)?;
+        db.set_metadata(META_LAST_BRANCH, branch.as_deref().unwrap_or(""))?;
+        let mut dirty: Vec<String> = git_dirty_files(&root).into_iter().collect();
+        dirty.sort();
+        db.set_metadata(META_DIRTY_FILES, &dirty.join("\n"))?;
+        if let Some(branch) = &branch {
+            db.save_branch_state(branch, &commit, &dirty, now, MAX_BRANCH_STATES)?;
+        }
+    }
+
+    let change = |change, s: &Symbol| SymbolChange {
+        change,
+        name: s.name.clone(),
+        kind: s.kind,
+        file_path: s.file_path.clone(),
+        line: s.start_line,
+    };
+    let changes: Vec<SymbolChange> = appeared
+        .iter()
+        .map(|(s, _)| change(ChangeKind::Added, s))
+        .chain(vanished.iter().map(|(s, _)| change(ChangeKind::Removed, s)))
+        .chain(modified.iter().map(|s| change(ChangeKind::Modified, s)))
+        .collect();
+
+    let hooks = project.hooks();
+    if !hooks.is_empty() {
+        let _span = info_span!("hooks").entered();
+        hooks::fire(hooks, &root, &result, &changes);
+    }
+
+    Ok((result, changes))
+}
+
+/// Identifies a symbol across two versions of its file: parent name, name, kind,
+/// and its rank among the symbols sharing those, by line. Same-named methods of
+/// different classes stay apart, and so do overloads within one.
+type SymbolKey = (Option<String>, String, SymbolKind, usize);
+
+/// [`SymbolKey`] of each symbol of one file, by symbol ID.
+fn symbol_keys<'a>(symbols: impl Iterator<Item = &'a Symbol>) -> HashMap<&'a str, SymbolKey> {
+    let mut symbols: Vec<&Symbol> = symbols.collect();
+    symbols.sort_by_key(|s| (s.start_line, s.start_byte));
+    let names: HashMap<&str, &str> = symbols
+        .iter()
+        .map(|&s| (s.id.as_str(), s.name.as_str()))
+        .collect();
+    let mut seen: HashMap<(Option<String>, String, SymbolKind), usize> = HashMap::new();
+    symbols
+        .into_iter()
+        .map(|s| {
+            // A parent outside the file (a Go receiver type) keeps its own ID.
+            let parent = s
+                .parent_id
+                .as_deref()
+                .map(|id| names.get(id).copied().unwrap_or(id).to_string());
+            let rank = seen
+                .entry((parent.clone(), s.name.clone(), s.kind))
+                .or_default();
+            let key = (parent, s.name.clone(), s.kind, *rank);
+            *rank += 1;
+            (s.id.as_str(), key)
+        })
+        .collect()
+}
+
+/// Rough index bytes per byte of source: graph rows, symbol text kept for RAG,
+/// and its full-text index.
+const ESTIMATED_INDEX_RATIO: f64 = 3.0;
+/// Rough bytes of source one parser thread gets through per second, writes included.
+const ESTIMATED_BYTES_PER_SEC: f64 = 4.0 * 1024.0 * 1024.0;
+
+/// Why a path would be left out of the index.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, serde::Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SkipReason {
+    /// A directory never indexed (`.git`, `node_modules`, `target`, ...); not walked.
+    BuiltinDir,
+    /// Matched an `ignore` glob in a `.cartog.toml`.
+    Ignored,
+    /// Its language is turned off by `[languages] disable`.
+    LanguageDisabled,
+    /// No parser or plugin handles the file.
+    Unsupported,
+}
+
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct PlannedFile {
+    pub path: String,
+    pub language: String,
+    pub bytes: u64,
+}
+
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct SkippedPath {
+    /// Directories end in `/`.
+    pub path: String,
+    pub reason: SkipReason,
+    /// The glob, directory name or language responsible.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub rule: Option<String>,
+}
+
+/// What `cartog index --dry-run` reports: the files a full index would parse,
+/// those it would leave out and why, and how big and long the build would be.
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct IndexPlan {
+    pub included: Vec<PlannedFile>,
+    pub skipped: Vec<SkippedPath>,
+    pub included_bytes: u64,
+    /// Rough estimates for a full build.
+    pub estimated_index_bytes: u64,
+    pub estimated_seconds: f64,
+    pub jobs: usize,
+}
+
+/// Walk `root` as [`index_directory`] does and report what it would index,
+/// without reading or parsing any file.
+pub fn plan_index(root: &Path, jobs: usize) -> Result<IndexPlan> {
+    let root = root.canonicalize().context("Failed to resolve root path")?;
+    let project = ProjectConfig::load(&root)?;
+    let plugins = PluginRegistry::discover(&root.join(project.plugin_dir()))?;
+    let rel = |path: &Path| {
+        path.strip_prefix(&root)
+            .map(|p| p.to_string_lossy().to_string())
+            .ok()
+    };
+
+    let with_vendor = project.vendor();
+    let mut included = Vec::new();
+    let mut skipped = Vec::new();
+    let mut walker = WalkDir::new(&root).follow_links(true).into_iter();
+    while let Some(entry) = walker.next() {
+        let entry = match entry {
+            Ok(e) => e,
+            Err(e) => {
+                warn!(error = %e, "directory walk error");
+                continue;
+            }
+        };
+        if is_ignored(&entry) && !(with_vendor && is_vendor_dir(&entry)) {
+            if let Some(path) = rel(entry.path()) {
+                skipped.push(SkippedPath {
+                    path: format!("{path}/"),
+                    reason: SkipReason::BuiltinDir,
+                    rule: Some(entry.file_name().to_string_lossy().to_string()),
+                });
+            }
+            walker.skip_current_dir();
+            continue;
+        }
+        if !entry.file_type().is_file() {
+            continue;
+        }
+        let Some(rel_path) = rel(entry.path()) else {
+            continue;
+        };
+        let skip = |reason, rule| SkippedPath {
+            path: rel_path.clone(),
+            reason,
+            rule,
+        };
+        let Some(lang) =
+            detect_language(Path::new(&rel_path)).or_else(|| plugins.detect(Path::new(&rel_path)))
+        else {
+            skipped.push(skip(SkipReason::Unsupported, None));
+            continue;
+        };
+        if let Some(rule) = project.ignore_rule(&rel_path) {
+            skipped.push(skip(SkipReason::Ignored, Some(rule)));
+            continue;
+        }
+        if !project.language_enabled(&rel_path, lang) {
+            skipped.push(skip(SkipReason::LanguageDisabled, Some(lang.to_string())));
+            continue;
+        }
+        if lang != "go" && vendor::is_vendored(&rel_path) {
+            skipped.push(skip(
+                SkipReason::BuiltinDir,
+                Some(vendor::VENDOR_DIR.to_string()),
+            ));
+            continue;
+        }
+        included.push(PlannedFile {
+            bytes: entry.metadata().map_or(0, |m| m.len()),
+            path: rel_path,
+            language: lang.to_string(),
+        });
+    }
+    included.sort_by(|a, b| a.path.cmp(&b.path));
+    skipped.sort_by(|a, b| (a.reason, &a.path).cmp(&(b.reason, &b.path)));
+
+    let included_bytes: u64 = included.iter().map(|f| f.bytes).sum();
+    let jobs = jobs.max(1);
+    Ok(IndexPlan {
+        included,
+        skipped,
+        included_bytes,
+        estimated_index_bytes: (included_bytes as f64 * ESTIMATED_INDEX_RATIO) as u64,
+        estimated_seconds: included_bytes as f64 / (ESTIMATED_BYTES_PER_SEC * jobs as f64),
+        jobs,
+    })
+}
 
-    // Store the current git commit as last indexed
-    if let Some(commit) = git_head_commit(&root) {
-        db.set_metadata("last_commit", &commit)?;
+/// The index was built from a different checkout than the one on disk.
+#[derive(Debug, Clone, PartialEq, serde::Serialize)]
+pub struct Staleness {
+    pub indexed_branch: Option<String>,
+    pub indexed_commit: String,
+    pub current_branch: Option<String>,
+    pub current_commit: String,
+}
+
+impl std::fmt::Display for Staleness {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        fn describe(branch: &Option<String>, commit: &str) -> String {
+            let short = &commit[..commit.len().min(8)];
+            match branch {
+                Some(b) => format!("'{b}' ({short})"),
+                None => format!("detached {short}"),
+            }
+        }
+        write!(
+            f,
+            "index was built on {} but the checkout is now {}; run `cartog index .` to update",
+            describe(&self.indexed_branch, &self.indexed_commit),
+            describe(&self.current_branch, &self.current_commit),
+        )
     }
+}
+
+/// Suffix of the file a new index generation is built in, next to the live one.
+const NEXT_GENERATION: &str = ".next";
+
+/// Held by whatever writes the index in this process; see [`write_lock`].
+static WRITER: Mutex<()> = Mutex::new(());
+
+/// Wait until nothing else in this process is writing the index, and keep it
+/// that way until the guard is dropped.
+///
+/// [`index_generation`] holds it for the whole rebuild: a write to the live
+/// index after the copy would be overwritten by the swap, so writers that run
+/// alongside a rebuild (the watcher, an incremental `cartog_index`) wait for it
+/// and then see the new generation.
+pub fn write_lock() -> MutexGuard<'static, ()> {
+    WRITER.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Build a new generation of the index at `db_path` without disturbing readers
+/// of the live one, then swap it in.
+///
+/// The live index is copied next to itself (keeping snapshots, burndown history
+/// and embeddings), `build` runs against the copy, and the result replaces the
+/// live contents in one write transaction. Queries running on other connections
+/// finish on the old generation; the ones after the swap see the new one.
+/// Writers in this process wait for the swap (see [`write_lock`]); `build` must
+/// not take the lock itself.
+pub fn index_generation(
+    db_path: &Path,
+    build: impl FnOnce(&Database) -> Result<IndexResult>,
+) -> Result<IndexResult> {
+    let mut next = db_path.as_os_str().to_owned();
+    next.push(NEXT_GENERATION);
+    let next = std::path::PathBuf::from(next);
+    let remove_next = || {
+        for suffix in ["", "-wal", "-shm"] {
+            let mut file = next.as_os_str().to_owned();
+            file.push(suffix);
+            let _ = std::fs::remove_file(file);
+        }
+    };
+    let _writer = write_lock();
+    remove_next();
+
+    let built = (|| {
+        let mut live = Database::open(db_path)?;
+        live.copy_to(&next)?;
+        // Closed before the swap so the copy is complete on disk.
+        let result = build(&Database::open(&next)?)?;
+        live.replace_with(&next)?;
+        Ok(result)
+    })();
+    remove_next();
+    built
+}
+
+/// Unix seconds at which the index was last built; `None` for indexes built
+/// before this was recorded.
+pub fn indexed_at(db: &Database) -> Result<Option<u64>> {
+    Ok(db
+        .get_metadata(META_INDEXED_AT)?
+        .and_then(|secs| secs.parse().ok()))
+}
 
-    Ok(result)
+/// Identifies the last index run: it changes every time the index is built.
+/// `None` for indexes built before this was recorded.
+pub fn index_stamp(db: &Database) -> Result<Option<String>> {
+    db.get_metadata(META_INDEX_STAMP)
+}
+
+/// Compare the indexed checkout with the current HEAD.
+///
+/// Returns `None` when they match, when `root` is not a git repository, or when
+/// the index predates commit tracking.
+pub fn staleness(db: &Database, root: &Path) -> Result<Option<Staleness>> {
+    let Some(indexed_commit) = db.get_metadata(META_LAST_COMMIT)? else {
+        return Ok(None);
+    };
+    // Runs before every query: read HEAD from disk and only spawn git as a fallback.
+    let head = git::read_head(root);
+    let current_commit = match &head {
+        Some(head) => head.commit.clone(),
+        None => head_commit(root),
+    };
+    let Some(current_commit) = current_commit else {
+        return Ok(None);
+    };
+    if indexed_commit == current_commit {
+        return Ok(None);
+    }
+    let indexed_branch = db.get_metadata(META_LAST_BRANCH)?.filter(|b| !b.is_empty());
+    Ok(Some(Staleness {
+        indexed_branch,
+        indexed_commit,
+        current_branch: match head {
+            Some(head) => head.branch,
+            None => current_branch(root),
+        },
+        current_commit,
+    }))
+}
+
+/// The project's own `vendor/`, walked when `[index] vendor` is set.
+fn is_vendor_dir(entry: &walkdir::DirEntry) -> bool {
+    entry.depth() == 1 && entry.file_type().is_dir() && entry.file_name() == vendor::VENDOR_DIR
 }
 
 fn is_ignored(entry: &walkdir::DirEntry) -> bool {
@@ -225,13 +814,13 @@ pub fn is_ignored_dirname(name: &str) -> bool {
     ) || name.starts_with('.')
 }
 
-fn file_hash(content: &str) -> String {
+pub(crate) fn file_hash(content: &str) -> String {
     let mut hasher = Sha256::new();
     hasher.update(content.as_bytes());
     format!("{:x}", hasher.finalize())
 }
 
-fn file_modified(path: &Path) -> f64 {
+pub(crate) fn file_modified(path: &Path) -> f64 {
     path.metadata()
         .and_then(|m| m.modified())
         .ok()
@@ -267,7 +856,36 @@ fn git_changed_files(
     let mut changed: std::collections::HashSet<String> =
         parse_git_lines(&diff_output.stdout).collect();
 
-    // Also include untracked files (new files not yet committed)
+    changed.extend(git_dirty_files(root));
+
+    Some(changed)
+}
+
+/// Current symbols of an indexed file with their stored source text (empty
+/// when the content pass was skipped) and source hash. Symbols with neither,
+/// imports among them, are left out.
+fn snapshot_symbols(db: &Database, path: &str) -> Result<Vec<(Symbol, String, String)>> {
+    let symbols = db.outline(path)?;
+    let ids: Vec<String> = symbols.iter().map(|s| s.id.clone()).collect();
+    let mut contents = db.get_symbol_contents_batch(&ids)?;
+    let mut hashes = db.symbol_hashes(path)?;
+    Ok(symbols
+        .into_iter()
+        .filter_map(|s| {
+            let content = contents.remove(&s.id).map(|(content, _)| content);
+            let hash = hashes
+                .remove(&s.id)
+                .or_else(|| content.as_deref().map(file_hash))?;
+            Some((s, content.unwrap_or_default(), hash))
+        })
+        .collect())
+}
+
+/// Untracked, modified and staged files in the working tree.
+fn git_dirty_files(root: &Path) -> std::collections::HashSet<String> {
+    let mut changed = std::collections::HashSet::new();
+
+    // Untracked files (new files not yet committed)
     if let Some(out) = git_cmd(root, &["ls-files", "--others", "--exclude-standard"]) {
         if out.status.success() {
             changed.extend(parse_git_lines(&out.stdout));
@@ -287,37 +905,7 @@ fn git_changed_files(
         }
     }
 
-    Some(changed)
-}
-
-/// Get the current HEAD commit hash.
-fn git_head_commit(root: &Path) -> Option<String> {
-    let output = git_cmd(root, &["rev-parse", "HEAD"])?;
-    if output.status.success() {
-        Some(String::from_utf8(output.stdout).ok()?.trim().to_string())
-    } else {
-        None
-    }
-}
-
-/// Run a git command with stdin suppressed to prevent interactive prompts.
-fn git_cmd(root: &Path, args: &[&str]) -> Option<std::process::Output> {
-    std::process::Command::new("git")
-        .args(args)
-        .current_dir(root)
-        .stdin(std::process::Stdio::null())
-        .output()
-        .ok()
-}
-
-/// Parse lines from git command output, filtering empty lines.
-fn parse_git_lines(stdout: &[u8]) -> impl Iterator<Item = String> + '_ {
-    String::from_utf8_lossy(stdout)
-        .lines()
-        .filter(|l| !l.is_empty())
-        .map(|l| l.to_string())
-        .collect::<Vec<_>>()
-        .into_iter()
+    changed
 }
 
 /// Find the largest byte index <= `index` that is a valid UTF-8 char boundary in `s`.
@@ -352,10 +940,16 @@ const MIN_CONTENT_BYTES: usize = 50;
 ///
 /// Returns `(content, header)` where `header` is a brief preamble for embedding context.
 /// Returns `None` if: byte offsets are invalid, content is empty/too short,
-/// or the symbol is an import (not useful for semantic search).
-fn extract_symbol_content(source: &str, sym: &crate::types::Symbol) -> Option<(String, String)> {
-    // Skip imports — they don't contain searchable logic.
-    if sym.kind == crate::types::SymbolKind::Import {
+/// or the symbol is an import or flag (not useful for semantic search).
+pub(crate) fn extract_symbol_content(
+    source: &str,
+    sym: &crate::types::Symbol,
+) -> Option<(String, String)> {
+    // Skip imports and flags — they don't contain searchable logic.
+    if matches!(
+        sym.kind,
+        crate::types::SymbolKind::Import | crate::types::SymbolKind::Flag
+    ) {
         return None;
     }
 
@@ -480,7 +1074,7 @@ mod tests {
     fn test_git_changed_files_valid_head() {
         // If we diff HEAD against HEAD, the changed set should be empty
         // (only working tree / untracked files would appear)
-        let head = git_head_commit(Path::new("."));
+        let head = head_commit(Path::new("."));
         if let Some(commit) = head {
             let result = git_changed_files(Path::new("."), Some(&commit));
             // Should return Some (valid commit), though the set may contain untracked/modified files
@@ -488,6 +1082,151 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_staleness_without_indexed_commit() {
+        let db = Database::open_memory().unwrap();
+        assert!(staleness(&db, Path::new(".")).unwrap().is_none());
+    }
+
+    #[test]
+    fn test_staleness_detects_moved_head() {
+        let Some(head) = head_commit(Path::new(".")) else {
+            return; // not running inside a git checkout
+        };
+        let db = Database::open_memory().unwrap();
+
+        db.set_metadata(META_LAST_COMMIT, &head).unwrap();
+        assert!(staleness(&db, Path::new(".")).unwrap().is_none());
+
+        let old = "0123456789abcdef0123456789abcdef01234567";
+        db.set_metadata(META_LAST_COMMIT, old).unwrap();
+        db.set_metadata(META_LAST_BRANCH, "old-feature").unwrap();
+        let stale = staleness(&db, Path::new(".")).unwrap().unwrap();
+        assert_eq!(stale.indexed_branch.as_deref(), Some("old-feature"));
+        assert_eq!(stale.current_commit, head);
+        assert!(stale.to_string().contains("'old-feature' (01234567)"));
+    }
+
+    #[test]
+    fn test_branch_switch_restores_from_parse_cache() {
+        let tmp = std::env::temp_dir().join(format!("cartog-branches-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&tmp);
+        std::fs::create_dir_all(&tmp).unwrap();
+        let git = |args: &[&str]| {
+            std::process::Command::new("git")
+                .args(["-c", "user.name=t", "-c", "user.email=t@example.com"])
+                .args(args)
+                .current_dir(&tmp)
+                .output()
+                .is_ok_and(|out| out.status.success())
+        };
+        if !git(&["init", "-q", "-b", "main"]) {
+            return; // no git
+        }
+        std::fs::write(tmp.join("a.py"), "def login():\n    pass\n").unwrap();
+        std::fs::write(tmp.join("b.py"), "def logout():\n    pass\n").unwrap();
+        assert!(git(&["add", "."]) && git(&["commit", "-qm", "main"]));
+        assert!(git(&["checkout", "-qb", "feature"]));
+        std::fs::write(tmp.join("a.py"), "def login(user):\n    check(user)\n").unwrap();
+        assert!(git(&["commit", "-qam", "feature"]));
+
+        let db = Database::open_memory().unwrap();
+        let switch = |branch: &str| {
+            assert!(git(&["checkout", "-q", branch]));
+            index_directory(&db, &tmp, false).unwrap()
+        };
+        assert_eq!(switch("main").files_indexed, 2);
+        // Each version of `a.py` is parsed once, then restored.
+        for (branch, restored) in [("feature", 0), ("main", 0), ("feature", 1), ("main", 1)] {
+            let result = switch(branch);
+            assert_eq!((result.files_indexed, result.files_restored), (1, restored));
+        }
+        let login = db.find_definitions("login").unwrap();
+        assert_eq!(login[0].signature.as_deref(), Some("()"));
+
+        let mut states: Vec<(String, u32)> = db
+            .branch_states()
+            .unwrap()
+            .into_iter()
+            .map(|s| (s.branch, s.cached_files))
+            .collect();
+        states.sort();
+        // `b.py` is the same on both branches: only the live copy exists.
+        assert_eq!(
+            states,
+            [("feature".to_string(), 1), ("main".to_string(), 1)]
+        );
+        let _ = std::fs::remove_dir_all(&tmp);
+    }
+
+    #[test]
+    fn test_symbol_keys_tell_same_named_methods_apart() {
+        let class = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Class, "models.py", line, line + 5, 0, 0)
+        };
+        let init = |parent: &Symbol, line: u32| {
+            Symbol::new(
+                "__init__",
+                SymbolKind::Method,
+                "models.py",
+                line,
+                line + 1,
+                0,
+                0,
+            )
+            .with_parent(Some(&parent.id))
+        };
+        let (user, team) = (class("User", 1), class("Team", 10));
+        let old = [init(&user, 2), init(&team, 11), user.clone(), team.clone()];
+        // `User` grew by two lines: its `__init__` moved but is the same method.
+        let (user2, team2) = (class("User", 1), class("Team", 12));
+        let new = [
+            user2.clone(),
+            init(&user2, 4),
+            team2.clone(),
+            init(&team2, 13),
+        ];
+
+        let old_keys = symbol_keys(old.iter());
+        let new_keys = symbol_keys(new.iter());
+        assert_eq!(old_keys[old[0].id.as_str()], new_keys[new[1].id.as_str()]);
+        assert_eq!(old_keys[old[1].id.as_str()], new_keys[new[3].id.as_str()]);
+        assert_ne!(new_keys[new[1].id.as_str()], new_keys[new[3].id.as_str()]);
+    }
+
+    #[test]
+    fn test_changes_are_reported_without_the_content_pass() {
+        let tmp = std::env::temp_dir().join(format!("cartog-changes-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&tmp);
+        std::fs::create_dir_all(&tmp).unwrap();
+        std::fs::write(
+            tmp.join(".cartog.toml"),
+            "[[extract.rules]]\nskip = [\"content\"]\n",
+        )
+        .unwrap();
+        let models = |team_body: &str| {
+            format!(
+                "class User:\n    def __init__(self):\n        self.load()\n\n\
+                 class Team:\n    def __init__(self):\n        {team_body}\n"
+            )
+        };
+        std::fs::write(tmp.join("models.py"), models("self.reset()")).unwrap();
+
+        let db = Database::open_memory().unwrap();
+        let config = PipelineConfig::default();
+        index_directory_changes(&db, &tmp, false, &config).unwrap();
+        assert_eq!(db.symbol_content_count().unwrap(), 0);
+
+        std::fs::write(tmp.join("models.py"), models("self.clear()")).unwrap();
+        let (_, changes) = index_directory_changes(&db, &tmp, false, &config).unwrap();
+        let changed: Vec<(ChangeKind, &str, u32)> = changes
+            .iter()
+            .map(|c| (c.change, c.name.as_str(), c.line))
+            .collect();
+        assert_eq!(changed, [(ChangeKind::Modified, "__init__", 6)]);
+        let _ = std::fs::remove_dir_all(&tmp);
+    }
+
     #[test]
     fn test_index_directory_force() {
         use crate::db::Database;
@@ -512,6 +1251,57 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_plan_index_reports_skips() {
+        let tmp = std::env::temp_dir().join(format!("cartog-plan-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&tmp);
+        for (path, text) in [
+            (
+                ".cartog.toml",
+                "[index]\nignore = [\"gen/**\"]\n\n[languages]\ndisable = [\"ruby\"]\n",
+            ),
+            ("app.py", "def main(): pass\n"),
+            ("gen/api.py", "x = 1\n"),
+            ("tools/x.rb", "def x; end\n"),
+            ("README.md", "# app\n"),
+            ("node_modules/dep/index.js", "module.exports = 1\n"),
+        ] {
+            let file = tmp.join(path);
+            std::fs::create_dir_all(file.parent().unwrap()).unwrap();
+            std::fs::write(file, text).unwrap();
+        }
+
+        let plan = plan_index(&tmp, 2).unwrap();
+        let included: Vec<&str> = plan.included.iter().map(|f| f.path.as_str()).collect();
+        assert_eq!(included, ["app.py"]);
+        assert_eq!(plan.included_bytes, 17);
+        let skipped: Vec<(&str, SkipReason, Option<&str>)> = plan
+            .skipped
+            .iter()
+            .map(|s| (s.path.as_str(), s.reason, s.rule.as_deref()))
+            .collect();
+        assert_eq!(
+            skipped,
+            [
+                (
+                    "node_modules/",
+                    SkipReason::BuiltinDir,
+                    Some("node_modules")
+                ),
+                (
+                    "gen/api.py",
+                    SkipReason::Ignored,
+                    Some(".cartog.toml: gen/**")
+                ),
+                ("tools/x.rb", SkipReason::LanguageDisabled, Some("ruby")),
+                (".cartog.toml", SkipReason::Unsupported, None),
+                ("README.md", SkipReason::Unsupported, None),
+            ]
+        );
+        assert!(plan.estimated_index_bytes > plan.included_bytes);
+        std::fs::remove_dir_all(&tmp).unwrap();
+    }
+
     #[test]
     fn test_floor_char_boundary_ascii() {
         let s = "hello world";
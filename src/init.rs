@@ -0,0 +1,361 @@
+//! `cartog init`: writes a starter `.cartog.toml` for a project.
+//!
+//! Scans the tree once, then proposes a config from what it found: generated
+//! code to ignore, lower ranking for test code, and no call graph or RAG
+//! content for fixtures. Interactive runs ask about each proposal; `--yes`
+//! takes them all. The written file is checked with the same validation as
+//! `cartog config validate`.
+
+use std::collections::BTreeMap;
+use std::io::{BufRead, Write};
+use std::path::Path;
+
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use walkdir::WalkDir;
+
+use crate::config::CONFIG_FILE;
+use crate::indexer::is_ignored_dirname;
+use crate::languages::detect_language;
+
+/// Directories of generated or third-party code, proposed as ignores.
+const GENERATED_DIRS: &[&str] = &["generated", "gen", "third_party", "coverage"];
+/// Generated-file patterns, proposed as ignores when a file matches.
+const GENERATED_FILES: &[(&str, &str)] = &[
+    ("_pb2.py", "**/*_pb2.py"),
+    ("_pb2_grpc.py", "**/*_pb2_grpc.py"),
+    (".pb.go", "**/*.pb.go"),
+    (".min.js", "**/*.min.js"),
+    (".d.ts", "**/*.d.ts"),
+];
+/// Test directories, ranked down in search.
+const TEST_DIRS: &[&str] = &["tests", "test", "spec", "__tests__"];
+/// Test data directories, indexed for symbols only.
+const FIXTURE_DIRS: &[&str] = &["fixtures", "testdata"];
+
+/// Search boost proposed for test directories.
+const TEST_BOOST: f64 = 0.5;
+
+/// An editor or agent whose MCP config `init` can print.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum McpClient {
+    ClaudeCode,
+    Cursor,
+}
+
+/// A proposed setting and the files that motivated it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Proposal {
+    pub pattern: String,
+    pub files: usize,
+}
+
+/// What `init` found and proposes.
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct Plan {
+    /// Indexable files per language.
+    pub languages: BTreeMap<String, usize>,
+    pub ignore: Vec<Proposal>,
+    /// Globs for `[ranking.boost]` at [`TEST_BOOST`].
+    pub tests: Vec<Proposal>,
+    /// Globs for an `[[extract.rules]]` that skips calls and content.
+    pub fixtures: Vec<Proposal>,
+}
+
+impl Plan {
+    /// Scan `root` and propose settings.
+    pub fn detect(root: &Path) -> Self {
+        let mut plan = Self::default();
+        let mut dirs: BTreeMap<String, usize> = BTreeMap::new();
+        let mut suffixes: BTreeMap<&str, usize> = BTreeMap::new();
+
+        let walker = WalkDir::new(root).follow_links(true).into_iter();
+        for entry in walker.filter_entry(|e| {
+            e.depth() == 0
+                || !e.file_type().is_dir()
+                || !is_ignored_dirname(&e.file_name().to_string_lossy())
+        }) {
+            let Ok(entry) = entry else { continue };
+            if !entry.file_type().is_file() {
+                continue;
+            }
+            let Ok(rel) = entry.path().strip_prefix(root) else {
+                continue;
+            };
+            let Some(lang) = detect_language(rel) else {
+                continue;
+            };
+            *plan.languages.entry(lang.to_string()).or_default() += 1;
+
+            let rel_path = rel.to_string_lossy().replace('\\', "/");
+            // Outermost directory with a known role, so `tests/fixtures` counts once.
+            let components: Vec<&str> = rel_path.split('/').collect();
+            if let Some(i) = components[..components.len() - 1].iter().position(|c| {
+                GENERATED_DIRS.contains(c) || TEST_DIRS.contains(c) || FIXTURE_DIRS.contains(c)
+            }) {
+                *dirs.entry(components[..=i].join("/")).or_default() += 1;
+            }
+            if let Some((suffix, _)) = GENERATED_FILES
+                .iter()
+                .find(|(suffix, _)| rel_path.ends_with(suffix))
+            {
+                *suffixes.entry(*suffix).or_default() += 1;
+            }
+        }
+
+        for (dir, files) in dirs {
+            let name = dir.rsplit('/').next().unwrap_or(&dir);
+            let proposal = Proposal {
+                pattern: format!("{dir}/**"),
+                files,
+            };
+            if GENERATED_DIRS.contains(&name) {
+                plan.ignore.push(proposal);
+            } else if TEST_DIRS.contains(&name) {
+                plan.tests.push(proposal);
+            } else {
+                plan.fixtures.push(proposal);
+            }
+        }
+        for (suffix, pattern) in GENERATED_FILES {
+            if let Some(&files) = suffixes.get(suffix) {
+                plan.ignore.push(Proposal {
+                    pattern: pattern.to_string(),
+                    files,
+                });
+            }
+        }
+        plan
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.ignore.is_empty() && self.tests.is_empty() && self.fixtures.is_empty()
+    }
+
+    /// The `.cartog.toml` for this plan, with comments.
+    pub fn render(&self) -> String {
+        let mut out = String::from(
+            "#:schema https://raw.githubusercontent.com/jrollin/cartog/main/docs/cartog.schema.json\n",
+        );
+        let summary: Vec<String> = self
+            .languages
+            .iter()
+            .map(|(lang, files)| format!("{lang} ({files})"))
+            .collect();
+        if !summary.is_empty() {
+            out.push_str(&format!("# Detected: {}\n", summary.join(", ")));
+        }
+
+        out.push_str("\n[index]\n# Generated and third-party code, left out of the index.\n");
+        out.push_str(&format!("ignore = {}\n", array(&self.ignore)));
+
+        out.push_str(
+            "\n[languages]\n# Languages to skip, e.g. [\"javascript\"] for bundled assets.\ndisable = []\n",
+        );
+
+        if !self.tests.is_empty() {
+            out.push_str("\n[ranking.boost]\n# Rank test code below the code it tests.\n");
+            for p in &self.tests {
+                out.push_str(&format!("\"{}\" = {TEST_BOOST:?}\n", p.pattern));
+            }
+        }
+
+        if !self.fixtures.is_empty() {
+            out.push_str(
+                "\n# Fixtures keep their symbols but stay out of the call graph and RAG search.\n",
+            );
+            out.push_str("[[extract.rules]]\n");
+            out.push_str(&format!("paths = {}\n", array(&self.fixtures)));
+            out.push_str("skip = [\"calls\", \"content\"]\n");
+        }
+        out
+    }
+}
+
+fn array(proposals: &[Proposal]) -> String {
+    let items: Vec<String> = proposals
+        .iter()
+        .map(|p| format!("\"{}\"", p.pattern))
+        .collect();
+    format!("[{}]", items.join(", "))
+}
+
+/// MCP server config for `client`, ready to paste or run.
+pub fn mcp_snippet(client: McpClient) -> String {
+    let server = r#"{
+  "mcpServers": {
+    "cartog": {
+      "command": "cartog",
+      "args": ["serve", "--watch"]
+    }
+  }
+}"#;
+    match client {
+        McpClient::ClaudeCode => format!(
+            "# Claude Code: run\nclaude mcp add cartog -- cartog serve --watch\n\
+             # or add to .claude/settings.local.json:\n{server}\n"
+        ),
+        McpClient::Cursor => format!("# Cursor: add to .cursor/mcp.json:\n{server}\n"),
+    }
+}
+
+/// Ask the user to accept or drop each proposal and pick MCP clients.
+///
+/// An empty answer accepts the default shown in brackets.
+pub fn interview(
+    plan: &mut Plan,
+    input: &mut impl BufRead,
+    out: &mut impl Write,
+) -> Result<Vec<McpClient>> {
+    let languages: Vec<String> = plan
+        .languages
+        .iter()
+        .map(|(lang, files)| format!("{lang} ({files} files)"))
+        .collect();
+    if languages.is_empty() {
+        writeln!(out, "No supported source files found.")?;
+    } else {
+        writeln!(out, "Languages: {}", languages.join(", "))?;
+    }
+
+    let prompts = [
+        ("Ignore", &mut plan.ignore),
+        ("Rank down tests in", &mut plan.tests),
+        ("Index symbols only in", &mut plan.fixtures),
+    ];
+    for (label, proposals) in prompts {
+        let mut kept = Vec::new();
+        for p in proposals.drain(..) {
+            let question = format!("{label} {} ({} files)?", p.pattern, p.files);
+            if ask(input, out, &question, true)? {
+                kept.push(p);
+            }
+        }
+        *proposals = kept;
+    }
+
+    let mut clients = Vec::new();
+    for (client, name) in [
+        (McpClient::ClaudeCode, "Claude Code"),
+        (McpClient::Cursor, "Cursor"),
+    ] {
+        if ask(input, out, &format!("Show MCP setup for {name}?"), false)? {
+            clients.push(client);
+        }
+    }
+    Ok(clients)
+}
+
+fn ask(
+    input: &mut impl BufRead,
+    out: &mut impl Write,
+    question: &str,
+    default: bool,
+) -> Result<bool> {
+    let hint = if default { "[Y/n]" } else { "[y/N]" };
+    loop {
+        write!(out, "{question} {hint} ")?;
+        out.flush()?;
+        let mut answer = String::new();
+        if input.read_line(&mut answer)? == 0 {
+            return Ok(default);
+        }
+        match answer.trim().to_ascii_lowercase().as_str() {
+            "" => return Ok(default),
+            "y" | "yes" => return Ok(true),
+            "n" | "no" => return Ok(false),
+            _ => writeln!(out, "Please answer y or n.")?,
+        }
+    }
+}
+
+/// Write `config` as `root/.cartog.toml`, refusing to replace one unless `force`.
+pub fn write(root: &Path, config: &str, force: bool) -> Result<std::path::PathBuf> {
+    let path = root.join(CONFIG_FILE);
+    anyhow::ensure!(
+        force || !path.exists(),
+        "{} already exists (use --force to replace it)",
+        path.display()
+    );
+    std::fs::write(&path, config)?;
+    Ok(path)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::ConfigFile;
+
+    fn fixture_project(name: &str) -> std::path::PathBuf {
+        let root = std::env::temp_dir().join(format!("cartog-init-{name}-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        for path in [
+            "app/models.py",
+            "app/api_pb2.py",
+            "tests/test_models.py",
+            "tests/fixtures/sample.py",
+            "generated/client.ts",
+            "web/main.ts",
+            "README.md",
+        ] {
+            let path = root.join(path);
+            std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+            std::fs::write(path, "").unwrap();
+        }
+        root
+    }
+
+    #[test]
+    fn test_detect_proposes_from_tree() {
+        let root = fixture_project("detect");
+        let plan = Plan::detect(&root);
+        assert_eq!(plan.languages["python"], 4);
+        assert_eq!(plan.languages["typescript"], 2);
+        let patterns = |ps: &[Proposal]| ps.iter().map(|p| p.pattern.clone()).collect::<Vec<_>>();
+        assert_eq!(patterns(&plan.ignore), ["generated/**", "**/*_pb2.py"]);
+        // `tests/fixtures` sits under `tests`, which claims it first.
+        assert_eq!(patterns(&plan.tests), ["tests/**"]);
+        assert!(plan.fixtures.is_empty());
+
+        let file = ConfigFile::parse(&plan.render()).unwrap();
+        assert_eq!(file.index.ignore, ["generated/**", "**/*_pb2.py"]);
+        assert_eq!(file.ranking.boost["tests/**"], TEST_BOOST);
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+
+    #[test]
+    fn test_interview_drops_rejected_proposals() {
+        let mut plan = Plan {
+            ignore: vec![
+                Proposal {
+                    pattern: "generated/**".into(),
+                    files: 3,
+                },
+                Proposal {
+                    pattern: "**/*.d.ts".into(),
+                    files: 9,
+                },
+            ],
+            fixtures: vec![Proposal {
+                pattern: "testdata/**".into(),
+                files: 2,
+            }],
+            ..Plan::default()
+        };
+        let mut input = "\nn\nmaybe\ny\ny\n".as_bytes();
+        let mut out = Vec::new();
+        let clients = interview(&mut plan, &mut input, &mut out).unwrap();
+
+        assert_eq!(plan.ignore.len(), 1);
+        assert_eq!(plan.ignore[0].pattern, "generated/**");
+        assert_eq!(plan.fixtures.len(), 1);
+        assert_eq!(clients, [McpClient::ClaudeCode]);
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .contains("Please answer y or n."));
+
+        let file = ConfigFile::parse(&plan.render()).unwrap();
+        assert_eq!(file.extract.rules[0].paths, ["testdata/**"]);
+    }
+}
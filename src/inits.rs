@@ -0,0 +1,327 @@
+//! Go package initialization: the order packages initialize in, and what runs
+//! while they do.
+//!
+//! A package initializes after every package it imports. Among the packages
+//! whose imports are all done, Go takes the first by import path, which is the
+//! order reproduced here over the project's own packages (directories of
+//! non-test `.go` files); packages outside the project initialize before any of
+//! them. Within a package, package-level variables are initialized first, then
+//! the `init` functions in file name order and source order.
+//!
+//! For each package the report lists its blank imports (`import _ "pkg"`),
+//! which exist only for the importee's init side effects, the package-level
+//! variables whose initializer calls something, and each `init` with the calls
+//! it makes and the package-level variables it writes.
+
+use std::collections::{BTreeMap, BTreeSet, HashMap, HashSet};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::deps_usage::{GoMod, GoPackage};
+use crate::types::{EdgeKind, Symbol, SymbolKind};
+
+/// An `import _ "path"`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct BlankImport {
+    pub path: String,
+    pub file: String,
+    pub line: u32,
+    /// The project directory the import names, when it is one of ours.
+    pub local: Option<String>,
+}
+
+/// Code that runs at initialization: an `init` function or a variable initializer.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct InitStep {
+    pub symbol: Symbol,
+    /// Callees as written, in call order, without repeats.
+    pub calls: Vec<String>,
+    /// Package-level variables it assigns, as written (`pkg.Name` when qualified).
+    pub writes: Vec<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct PackageInit {
+    /// Directory of the package.
+    pub package: String,
+    /// 1-based place in the initialization order.
+    pub position: usize,
+    /// The project packages it imports, which initialize before it.
+    pub after: Vec<String>,
+    pub blank_imports: Vec<BlankImport>,
+    pub var_inits: Vec<InitStep>,
+    pub inits: Vec<InitStep>,
+}
+
+impl PackageInit {
+    /// Whether anything runs or is pulled in for side effects at initialization.
+    pub fn has_effects(&self) -> bool {
+        !self.blank_imports.is_empty() || !self.var_inits.is_empty() || !self.inits.is_empty()
+    }
+}
+
+pub(crate) fn is_go_source(path: &str) -> bool {
+    path.ends_with(".go") && !path.ends_with("_test.go")
+}
+
+pub(crate) fn dir_of(path: &str) -> &str {
+    path.rsplit_once('/').map_or("", |(dir, _)| dir)
+}
+
+/// The import path of a project directory.
+fn import_path(dir: &str, go_mod: &GoMod) -> String {
+    match (go_mod.module.as_deref(), dir) {
+        (Some(module), "") => module.to_string(),
+        (Some(module), dir) => format!("{module}/{dir}"),
+        (None, dir) => dir.to_string(),
+    }
+}
+
+/// The project directory an import path names, through the module path and
+/// the `replace` directives of `go.mod`. Without a module path, the longest
+/// directory the import path ends with.
+pub(crate) fn local_dir<'a>(
+    path: &str,
+    go_mod: &GoMod,
+    dirs: &'a BTreeSet<String>,
+) -> Option<&'a str> {
+    if let GoPackage::Project(dir) = go_mod.resolve(path) {
+        return dirs.get(&dir).map(String::as_str);
+    }
+    if go_mod.module.is_some() {
+        return None;
+    }
+    dirs.iter()
+        .filter(|d| !d.is_empty() && (path == d.as_str() || path.ends_with(&format!("/{d}"))))
+        .max_by_key(|d| d.len())
+        .map(String::as_str)
+}
+
+/// Packages in initialization order, limited to `package` and what it imports
+/// when given.
+pub fn init_order(
+    db: &Database,
+    go_mod: &GoMod,
+    package: Option<&str>,
+) -> Result<Vec<PackageInit>> {
+    let symbols: Vec<Symbol> = db
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| is_go_source(&s.file_path))
+        .collect();
+    let dirs: BTreeSet<String> = symbols
+        .iter()
+        .map(|s| dir_of(&s.file_path).to_string())
+        .collect();
+
+    // Imports between project packages, and the blank ones of every package.
+    let mut imports: BTreeMap<&str, BTreeSet<&str>> = BTreeMap::new();
+    let mut blank: BTreeMap<&str, Vec<BlankImport>> = BTreeMap::new();
+    for dir in &dirs {
+        imports.entry(dir.as_str()).or_default();
+    }
+    for sym in symbols.iter().filter(|s| s.kind == SymbolKind::Import) {
+        let dir = dir_of(&sym.file_path);
+        let local = local_dir(&sym.name, go_mod, &dirs).filter(|d| *d != dir);
+        if let Some(local) = local {
+            imports.entry(dir).or_default().insert(local);
+        }
+        if sym
+            .signature
+            .as_deref()
+            .is_some_and(|s| s.trim_start().starts_with("_ ") || s.trim_start().starts_with("_\t"))
+        {
+            blank.entry(dir).or_default().push(BlankImport {
+                path: sym.name.clone(),
+                file: sym.file_path.clone(),
+                line: sym.start_line,
+                local: local.map(str::to_string),
+            });
+        }
+    }
+
+    let mut scope: HashSet<&str> = imports.keys().copied().collect();
+    if let Some(package) = package {
+        let package = package.trim_end_matches('/');
+        anyhow::ensure!(
+            imports.contains_key(package),
+            "no Go package in directory {package}"
+        );
+        scope.clear();
+        let mut stack = vec![package];
+        while let Some(dir) = stack.pop() {
+            if scope.insert(dir) {
+                stack.extend(imports[dir].iter().copied());
+            }
+        }
+    }
+
+    // Go's rule: repeatedly take the first package, by import path, whose
+    // imports are all initialized. A cycle (which does not compile) is
+    // appended as is.
+    let mut pending: Vec<&str> = scope.into_iter().collect();
+    pending.sort_by_key(|dir| import_path(dir, go_mod));
+    let mut order: Vec<&str> = Vec::new();
+    let mut done: HashSet<&str> = HashSet::new();
+    while !pending.is_empty() {
+        let next = pending
+            .iter()
+            .position(|dir| imports[dir].iter().all(|d| done.contains(d)))
+            .unwrap_or(0);
+        let dir = pending.remove(next);
+        done.insert(dir);
+        order.push(dir);
+    }
+
+    let steps = init_steps(db, &symbols)?;
+    let mut packages = Vec::new();
+    for (i, dir) in order.into_iter().enumerate() {
+        let in_dir = |step: &&InitStep| dir_of(&step.symbol.file_path) == dir;
+        let mut inits: Vec<InitStep> = steps
+            .iter()
+            .filter(|s| s.symbol.kind == SymbolKind::Function)
+            .filter(in_dir)
+            .cloned()
+            .collect();
+        inits.sort_by(|a, b| {
+            (&a.symbol.file_path, a.symbol.start_line)
+                .cmp(&(&b.symbol.file_path, b.symbol.start_line))
+        });
+        let var_inits = steps
+            .iter()
+            .filter(|s| s.symbol.kind == SymbolKind::Variable)
+            .filter(in_dir)
+            .cloned()
+            .collect();
+        packages.push(PackageInit {
+            package: dir.to_string(),
+            position: i + 1,
+            after: imports[dir].iter().map(|d| d.to_string()).collect(),
+            blank_imports: blank.remove(dir).unwrap_or_default(),
+            var_inits,
+            inits,
+        });
+    }
+    Ok(packages)
+}
+
+/// Every `init` function, and every package-level variable whose initializer
+/// calls something, with its calls and writes.
+fn init_steps(db: &Database, symbols: &[Symbol]) -> Result<Vec<InitStep>> {
+    let is_init =
+        |s: &Symbol| s.kind == SymbolKind::Function && s.name == "init" && s.parent_id.is_none();
+    let mut calls: HashMap<&str, Vec<(u32, String)>> = HashMap::new();
+    let edges = db.all_edges()?;
+    for edge in edges.iter().filter(|e| e.kind == EdgeKind::Calls) {
+        calls
+            .entry(edge.source_id.as_str())
+            .or_default()
+            .push((edge.line, edge.target_name.clone()));
+    }
+    let mut writes: HashMap<String, Vec<(u32, String)>> = HashMap::new();
+    for (_, accessor, access) in db.global_accesses(None)? {
+        if access.write && accessor.name == "init" {
+            let name = match &access.qualifier {
+                Some(q) => format!("{q}.{}", access.name),
+                None => access.name.clone(),
+            };
+            writes
+                .entry(accessor.id)
+                .or_default()
+                .push((access.line, name));
+        }
+    }
+
+    let mut steps = Vec::new();
+    for sym in symbols {
+        let is_var = sym.kind == SymbolKind::Variable
+            && sym.parent_id.is_none()
+            && calls.contains_key(sym.id.as_str());
+        if !is_init(sym) && !is_var {
+            continue;
+        }
+        let ordered = |mut list: Vec<(u32, String)>| {
+            list.sort();
+            let mut seen = HashSet::new();
+            list.into_iter()
+                .map(|(_, name)| name)
+                .filter(|name| seen.insert(name.clone()))
+                .collect::<Vec<_>>()
+        };
+        steps.push(InitStep {
+            symbol: sym.clone(),
+            calls: ordered(calls.get(sym.id.as_str()).cloned().unwrap_or_default()),
+            writes: ordered(writes.remove(&sym.id).unwrap_or_default()),
+        });
+    }
+    Ok(steps)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::deps_usage::parse_go_mod;
+    use crate::test_support::index_fixture;
+
+    const FILES: &[(&str, &str)] = &[
+        (
+            "cmd/app/main.go",
+            "package main\n\nimport (\n\t_ \"example.com/app/plugins\"\n\t\"example.com/app/store\"\n)\n\nfunc main() {\n\tstore.Open()\n}\n",
+        ),
+        (
+            "store/store.go",
+            "package store\n\nimport (\n\t\"database/sql\"\n\n\t_ \"github.com/lib/pq\"\n)\n\nvar db = mustOpen()\n\nvar driver string\n\nfunc init() {\n\tdriver = \"postgres\"\n\tsql.Drivers()\n}\n\nfunc mustOpen() *sql.DB { return nil }\n\nfunc Open() {}\n",
+        ),
+        (
+            "plugins/b.go",
+            "package plugins\n\nimport \"example.com/app/store\"\n\nfunc init() {\n\tregister(\"b\")\n\tstore.Open()\n}\n",
+        ),
+        (
+            "plugins/a.go",
+            "package plugins\n\nfunc init() {\n\tregister(\"a\")\n}\n\nfunc register(name string) {}\n",
+        ),
+    ];
+
+    #[test]
+    fn test_packages_initialize_after_their_imports() {
+        let db = index_fixture("inits-order", FILES);
+        let go_mod = parse_go_mod("module example.com/app\n");
+        let order = init_order(&db, &go_mod, None).unwrap();
+        let dirs: Vec<&str> = order.iter().map(|p| p.package.as_str()).collect();
+        assert_eq!(dirs, ["store", "plugins", "cmd/app"]);
+
+        let store = &order[0];
+        assert_eq!(store.blank_imports[0].path, "github.com/lib/pq");
+        assert_eq!(store.blank_imports[0].local, None);
+        assert_eq!(store.var_inits[0].symbol.name, "db");
+        assert_eq!(store.var_inits[0].calls, ["mustOpen"]);
+        assert_eq!(store.inits[0].writes, ["driver"]);
+        assert_eq!(store.inits[0].calls, ["sql.Drivers"]);
+
+        // init functions run in file name order.
+        let plugins = &order[1];
+        let files: Vec<&str> = plugins
+            .inits
+            .iter()
+            .map(|s| s.symbol.file_path.as_str())
+            .collect();
+        assert_eq!(files, ["plugins/a.go", "plugins/b.go"]);
+        assert_eq!(plugins.after, ["store"]);
+
+        let main = &order[2];
+        assert_eq!(main.blank_imports[0].local.as_deref(), Some("plugins"));
+        assert!(main.has_effects() && main.inits.is_empty());
+    }
+
+    #[test]
+    fn test_order_limited_to_a_package_and_its_imports() {
+        let db = index_fixture("inits-scope", FILES);
+        let go_mod = GoMod::default();
+        let order = init_order(&db, &go_mod, Some("plugins/")).unwrap();
+        let dirs: Vec<&str> = order.iter().map(|p| p.package.as_str()).collect();
+        assert_eq!(dirs, ["store", "plugins"]);
+        assert!(init_order(&db, &go_mod, Some("nowhere")).is_err());
+    }
+}
@@ -0,0 +1,168 @@
+//! Go type assertions (`x.(T)`) and type switch cases, read off the syntax tree
+//! during extraction.
+//!
+//! Each one is a downcast: the function expects the value behind an interface
+//! to be of a concrete type at run time, which method sets alone do not show.
+//! The concrete type is the asserted one; the interface is the declared type of
+//! the operand when it is a parameter, the receiver or a variable declared with
+//! a visible type (`func handle(err error) { e, ok := err.(*NotFound) }` links
+//! `NotFound` to `error`), and unknown otherwise. The asserted type can also be
+//! another interface (`w.(http.Flusher)`).
+//!
+//! Pointers, slices and package qualifiers are dropped, as for serializations:
+//! `*store.NotFound` is `NotFound`. Predeclared types (`case string:`), `nil` and
+//! unnamed types (`map[string]any`) are left out, and so are the empty
+//! interfaces `any` and `interface{}` as the asserted-from side.
+
+use tree_sitter::Node;
+
+use crate::types::{Symbol, SymbolKind, TypeAssertion};
+
+use super::complexity::{self, find_function};
+use super::consts::BUILTIN_TYPES;
+use super::node_text;
+use super::serialize::{base_type, local_types, value_type};
+
+/// Every type assertion and type switch case in the functions and methods among
+/// `symbols`.
+pub(crate) fn go_assertions(root: Node, source: &str, symbols: &[Symbol]) -> Vec<TypeAssertion> {
+    let mut assertions = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        let types = local_types(function, source);
+        let mut record = |operand: Node, ty: Node, type_switch: bool| {
+            let Some(type_name) = concrete_type(node_text(ty, source)) else {
+                return;
+            };
+            let interface =
+                value_type(operand, source, &types).filter(|i| i != "any" && *i != type_name);
+            assertions.push(TypeAssertion {
+                symbol_id: sym.id.clone(),
+                line: ty.start_position().row as u32 + 1,
+                interface,
+                type_name,
+                type_switch,
+            });
+        };
+        visit(function, &mut |node| match node.kind() {
+            "type_assertion_expression" => {
+                if let (Some(operand), Some(ty)) = (
+                    node.child_by_field_name("operand"),
+                    node.child_by_field_name("type"),
+                ) {
+                    record(operand, ty, false);
+                }
+            }
+            "type_switch_statement" => {
+                let Some(value) = node.child_by_field_name("value") else {
+                    return;
+                };
+                for case in node.named_children(&mut node.walk()) {
+                    if case.kind() != "type_case" {
+                        continue;
+                    }
+                    let mut cursor = case.walk();
+                    for ty in case.children_by_field_name("type", &mut cursor) {
+                        record(value, ty, true);
+                    }
+                }
+            }
+            _ => {}
+        });
+    }
+    assertions
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// The named type a case or an assertion downcasts to; `None` for predeclared
+/// types, `nil` and unnamed types.
+fn concrete_type(text: &str) -> Option<String> {
+    if text.starts_with("map[") || text.starts_with("chan ") || text.starts_with("func") {
+        return None;
+    }
+    base_type(text).filter(|name| {
+        name != "nil" && name != "any" && name != "error" && !BUILTIN_TYPES.contains(&name.as_str())
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+
+    #[test]
+    fn test_go_assertions_and_type_switches() {
+        let source = r#"package api
+
+func Status(err error) int {
+	if nf, ok := err.(*store.NotFound); ok {
+		return nf.Code
+	}
+	switch e := err.(type) {
+	case *ValidationError, Conflict:
+		return 400
+	case nil, string:
+		return 0
+	}
+	return 500
+}
+
+func Decode(m map[string]any) {
+	claims, _ := m["user"].(*TokenClaims)
+	_ = claims
+	n, _ := m["n"].(int)
+	_ = n
+}
+
+func (s *Server) Handle(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "api/status.go")
+            .unwrap();
+        let found: Vec<_> = result
+            .type_assertions
+            .iter()
+            .map(|a| {
+                (
+                    a.line,
+                    a.type_name.as_str(),
+                    a.interface.as_deref(),
+                    a.type_switch,
+                )
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (4, "NotFound", Some("error"), false),
+                (8, "ValidationError", Some("error"), true),
+                (8, "Conflict", Some("error"), true),
+                (17, "TokenClaims", None, false),
+                (24, "Flusher", Some("ResponseWriter"), false),
+            ]
+        );
+        // The function references the type it downcasts to.
+        assert!(result
+            .edges
+            .iter()
+            .any(|e| e.target_name == "TokenClaims" && e.line == 17));
+    }
+}
@@ -0,0 +1,364 @@
+//! Per-function complexity, measured on the syntax tree during extraction.
+//!
+//! - **Cyclomatic** (McCabe): 1 + one per branch — `if`/`elif`, loops, `catch`,
+//!   ternaries, `case` arms and each `&&`/`||`.
+//! - **Cognitive** (SonarSource): one per break in linear flow, plus the current
+//!   nesting depth for structures that nest. `else`/`elif` add one without the
+//!   nesting penalty, and a run of the same boolean operator counts once.
+//!
+//! Each language lists the node kinds playing each role in a [`Rules`] table.
+//! Nested functions and closures are counted into their enclosing function,
+//! one nesting level deeper.
+
+use tree_sitter::Node;
+
+use crate::types::{Complexity, Symbol, SymbolKind};
+
+use super::node_text;
+
+/// Node kinds playing each role in a grammar.
+pub(crate) struct Rules {
+    /// Functions, methods and closures: nest their body one level deeper.
+    pub functions: &'static [&'static str],
+    pub ifs: &'static [&'static str],
+    /// `elif`/`elsif` clauses, for grammars that have them.
+    pub else_ifs: &'static [&'static str],
+    pub elses: &'static [&'static str],
+    /// Loops, `catch`/`rescue` and ternaries.
+    pub nesting: &'static [&'static str],
+    /// `switch`/`match`: one cognitive point; their arms add the cyclomatic ones.
+    pub switches: &'static [&'static str],
+    pub cases: &'static [&'static str],
+    /// Binary expressions whose `operator` field may be one of `logical_ops`.
+    pub logical: &'static [&'static str],
+    pub logical_ops: &'static [&'static str],
+}
+
+pub(crate) const PYTHON: Rules = Rules {
+    functions: &["function_definition", "lambda"],
+    ifs: &["if_statement"],
+    else_ifs: &["elif_clause"],
+    elses: &["else_clause"],
+    nesting: &[
+        "for_statement",
+        "while_statement",
+        "except_clause",
+        "conditional_expression",
+    ],
+    switches: &["match_statement"],
+    cases: &["case_clause"],
+    logical: &["boolean_operator"],
+    logical_ops: &["and", "or"],
+};
+
+/// JavaScript, TypeScript and TSX.
+pub(crate) const JAVASCRIPT: Rules = Rules {
+    functions: &[
+        "function_declaration",
+        "function_expression",
+        "function",
+        "generator_function_declaration",
+        "generator_function",
+        "arrow_function",
+        "method_definition",
+    ],
+    ifs: &["if_statement"],
+    else_ifs: &[],
+    elses: &["else_clause"],
+    nesting: &[
+        "for_statement",
+        "for_in_statement",
+        "while_statement",
+        "do_statement",
+        "catch_clause",
+        "ternary_expression",
+    ],
+    switches: &["switch_statement"],
+    cases: &["switch_case"],
+    logical: &["binary_expression"],
+    logical_ops: &["&&", "||", "??"],
+};
+
+pub(crate) const RUST: Rules = Rules {
+    functions: &["function_item", "closure_expression"],
+    ifs: &["if_expression", "if_let_expression"],
+    else_ifs: &[],
+    elses: &["else_clause"],
+    nesting: &[
+        "for_expression",
+        "while_expression",
+        "while_let_expression",
+        "loop_expression",
+    ],
+    switches: &["match_expression"],
+    cases: &["match_arm"],
+    logical: &["binary_expression"],
+    logical_ops: &["&&", "||"],
+};
+
+/// Go has no else node: an `else` is the `alternative` block of an `if_statement`.
+pub(crate) const GO: Rules = Rules {
+    functions: &["function_declaration", "method_declaration", "func_literal"],
+    ifs: &["if_statement"],
+    else_ifs: &[],
+    elses: &[],
+    nesting: &["for_statement"],
+    switches: &[
+        "expression_switch_statement",
+        "type_switch_statement",
+        "select_statement",
+    ],
+    cases: &["expression_case", "type_case", "communication_case"],
+    logical: &["binary_expression"],
+    logical_ops: &["&&", "||"],
+};
+
+pub(crate) const RUBY: Rules = Rules {
+    functions: &["method", "singleton_method", "lambda", "block", "do_block"],
+    ifs: &["if", "unless", "if_modifier", "unless_modifier"],
+    else_ifs: &["elsif"],
+    elses: &["else"],
+    nesting: &[
+        "while",
+        "until",
+        "for",
+        "while_modifier",
+        "until_modifier",
+        "rescue",
+        "rescue_modifier",
+        "conditional",
+    ],
+    switches: &["case", "case_match"],
+    cases: &["when", "in_clause"],
+    logical: &["binary"],
+    logical_ops: &["&&", "||", "and", "or"],
+};
+
+/// Complexity of every function and method in `symbols`, keyed by symbol id.
+pub(crate) fn measure(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+    rules: &Rules,
+) -> Vec<(String, Complexity)> {
+    symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+        .filter_map(|sym| {
+            let node =
+                root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)?;
+            // The symbol may span a wrapper (decorators, `const f = () => ...`).
+            let function = find_function(node, rules.functions).unwrap_or(node);
+            let mut counter = Counter {
+                rules,
+                source,
+                cyclomatic: 1,
+                cognitive: 0,
+            };
+            for child in function.named_children(&mut function.walk()) {
+                counter.visit(child, 0);
+            }
+            Some((
+                sym.id.clone(),
+                Complexity {
+                    cyclomatic: counter.cyclomatic,
+                    cognitive: counter.cognitive,
+                },
+            ))
+        })
+        .collect()
+}
+
+/// `node` or its closest descendant that is one of `functions`, searching breadth-first.
+pub(super) fn find_function<'t>(node: Node<'t>, functions: &[&str]) -> Option<Node<'t>> {
+    let mut queue = std::collections::VecDeque::from([node]);
+    while let Some(next) = queue.pop_front() {
+        if functions.contains(&next.kind()) {
+            return Some(next);
+        }
+        queue.extend(next.named_children(&mut next.walk()));
+    }
+    None
+}
+
+struct Counter<'a> {
+    rules: &'a Rules,
+    source: &'a str,
+    cyclomatic: u32,
+    cognitive: u32,
+}
+
+impl<'a> Counter<'a> {
+    fn visit(&mut self, node: Node, nesting: u32) {
+        let rules = self.rules;
+        let kind = node.kind();
+        let mut inner = nesting;
+        if rules.ifs.contains(&kind) {
+            self.cyclomatic += 1;
+            if self.is_else_if(node) {
+                // Already nested by the `if` it continues.
+                self.cognitive += 1;
+            } else {
+                self.cognitive += 1 + nesting;
+                inner = nesting + 1;
+            }
+            // Go: a plain `else` is a bare block.
+            if let Some(alt) = node.child_by_field_name("alternative") {
+                let alt = alt.kind();
+                if ![rules.ifs, rules.else_ifs, rules.elses]
+                    .iter()
+                    .any(|kinds| kinds.contains(&alt))
+                {
+                    self.cognitive += 1;
+                }
+            }
+        } else if rules.else_ifs.contains(&kind) {
+            self.cyclomatic += 1;
+            self.cognitive += 1;
+        } else if rules.elses.contains(&kind) {
+            // `else if` is counted on the `if`.
+            if !self.wraps_if(node) {
+                self.cognitive += 1;
+            }
+        } else if rules.nesting.contains(&kind) {
+            self.cyclomatic += 1;
+            self.cognitive += 1 + nesting;
+            inner = nesting + 1;
+        } else if rules.switches.contains(&kind) {
+            self.cognitive += 1 + nesting;
+            inner = nesting + 1;
+        } else if rules.cases.contains(&kind) {
+            self.cyclomatic += 1;
+        } else if rules.functions.contains(&kind) {
+            inner = nesting + 1;
+        } else if let Some(op) = self.logical_op(node) {
+            self.cyclomatic += 1;
+            if node.parent().and_then(|p| self.logical_op(p)) != Some(op) {
+                self.cognitive += 1;
+            }
+        }
+
+        for child in node.named_children(&mut node.walk()) {
+            self.visit(child, inner);
+        }
+    }
+
+    /// An `if` continuing another: inside an `else`, or an `if`'s `alternative`.
+    fn is_else_if(&self, node: Node) -> bool {
+        let Some(parent) = node.parent() else {
+            return false;
+        };
+        if self.rules.elses.contains(&parent.kind()) {
+            return self.wraps_if(parent);
+        }
+        self.rules.ifs.contains(&parent.kind())
+            && parent.child_by_field_name("alternative") == Some(node)
+    }
+
+    /// Whether an `else` holds nothing but an `if`.
+    fn wraps_if(&self, node: Node) -> bool {
+        node.named_child_count() == 1
+            && node
+                .named_child(0)
+                .is_some_and(|child| self.rules.ifs.contains(&child.kind()))
+    }
+
+    /// The operator of a logical `&&`/`||`/`and`/`or` expression.
+    fn logical_op(&self, node: Node) -> Option<&'a str> {
+        if !self.rules.logical.contains(&node.kind()) {
+            return None;
+        }
+        let op = node_text(node.child_by_field_name("operator")?, self.source);
+        self.rules.logical_ops.contains(&op).then_some(op)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    fn complexity(lang: &str, file: &str, source: &str, name: &str) -> Complexity {
+        let result = get_extractor(lang).unwrap().extract(source, file).unwrap();
+        let sym = result
+            .symbols
+            .iter()
+            .find(|s| s.name == name)
+            .unwrap_or_else(|| panic!("no symbol {name}"));
+        result
+            .complexity
+            .iter()
+            .find(|(id, _)| *id == sym.id)
+            .map(|(_, c)| *c)
+            .unwrap_or_else(|| panic!("no complexity for {name}"))
+    }
+
+    #[test]
+    fn test_straight_line_code_is_one() {
+        let c = complexity("python", "a.py", "def f(x):\n    return x + 1\n", "f");
+        assert_eq!((c.cyclomatic, c.cognitive), (1, 0));
+    }
+
+    #[test]
+    fn test_python_nesting_and_boolean_runs() {
+        let source = r#"
+def check(items, strict):
+    for item in items:              # +1, cognitive +1
+        if item and strict:         # +1 +1(and), cognitive +2 (nesting 1) +1
+            return True
+        elif item or not strict:    # +1 +1(or), cognitive +1 +1
+            continue
+        else:                       # cognitive +1
+            pass
+    return False
+"#;
+        let c = complexity("python", "a.py", source, "check");
+        assert_eq!((c.cyclomatic, c.cognitive), (6, 7));
+    }
+
+    #[test]
+    fn test_else_if_chains_stay_flat() {
+        let js = r#"
+function grade(n) {
+    if (n > 90) { return "a"; }
+    else if (n > 80) { return "b"; }
+    else { return "c"; }
+}
+"#;
+        let go = r#"
+package main
+
+func grade(n int) string {
+    if n > 90 {
+        return "a"
+    } else if n > 80 {
+        return "b"
+    } else {
+        return "c"
+    }
+}
+"#;
+        for (lang, file, source) in [("javascript", "a.js", js), ("go", "a.go", go)] {
+            let c = complexity(lang, file, source, "grade");
+            assert_eq!((c.cyclomatic, c.cognitive), (3, 3), "{lang}");
+        }
+    }
+
+    #[test]
+    fn test_rust_match_and_closure_nesting() {
+        let source = r#"
+fn classify(values: &[i32]) -> usize {
+    values
+        .iter()
+        .filter(|v| match v {       // closure: nesting 1; match cognitive +2
+            0 => false,             // arms: cyclomatic +1 each
+            n if *n < 0 => true,
+            _ => false,
+        })
+        .count()
+}
+"#;
+        let c = complexity("rust", "a.rs", source, "classify");
+        assert_eq!((c.cyclomatic, c.cognitive), (4, 2));
+    }
+}
@@ -0,0 +1,250 @@
+//! Channel and sync-primitive usage in Go, read off the syntax tree during extraction.
+//!
+//! Records, per function, where channels are made (`make(chan T)`), sent on,
+//! received from and closed, and where mutexes are locked and unlocked and wait
+//! groups are added to, marked done and waited on. Objects are kept as written
+//! (`jobs`, `s.mu`); a made channel is named after what it is assigned to.
+//!
+//! `Lock`/`Unlock`/`RLock`/`RUnlock` count on any receiver: the names are specific
+//! enough. `Add`/`Done`/`Wait` only count on names the file declares as a
+//! `sync.WaitGroup`, since `ctx.Done()` and friends share them.
+
+use std::collections::HashSet;
+
+use tree_sitter::Node;
+
+use crate::types::{Symbol, SymbolKind, SyncOp, SyncSite};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+const LOCKS: &[(&str, SyncOp)] = &[
+    ("Lock", SyncOp::Lock),
+    ("Unlock", SyncOp::Unlock),
+    ("RLock", SyncOp::RLock),
+    ("RUnlock", SyncOp::RUnlock),
+];
+
+const WAIT_GROUP: &[(&str, SyncOp)] = &[
+    ("Add", SyncOp::Add),
+    ("Done", SyncOp::Done),
+    ("Wait", SyncOp::Wait),
+];
+
+/// Sync sites inside the Go functions and methods among `symbols`.
+pub(crate) fn go_sites(root: Node, source: &str, symbols: &[Symbol]) -> Vec<SyncSite> {
+    let wait_groups = wait_groups(root, source);
+    let mut sites = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        visit(function, &mut |node| {
+            let Some((op, object)) = site(node, source, &wait_groups) else {
+                return;
+            };
+            sites.push(SyncSite {
+                symbol_id: sym.id.clone(),
+                line: node.start_position().row as u32 + 1,
+                op,
+                object: object.to_string(),
+            });
+        });
+    }
+    sites
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+fn site<'s>(node: Node, source: &'s str, wait_groups: &HashSet<&str>) -> Option<(SyncOp, &'s str)> {
+    let text = |field: &str| {
+        node.child_by_field_name(field)
+            .map(|n| node_text(n, source))
+    };
+    match node.kind() {
+        "send_statement" => Some((SyncOp::Send, text("channel")?)),
+        "unary_expression" if text("operator")? == "<-" => {
+            Some((SyncOp::Receive, text("operand")?))
+        }
+        "call_expression" => {
+            let function = node.child_by_field_name("function")?;
+            let first_arg = node
+                .child_by_field_name("arguments")
+                .and_then(|args| args.named_child(0));
+            match function.kind() {
+                "identifier" => match node_text(function, source) {
+                    "make" if first_arg?.kind() == "channel_type" => {
+                        Some((SyncOp::Make, assigned_name(node, source)?))
+                    }
+                    "close" => Some((SyncOp::Close, node_text(first_arg?, source))),
+                    _ => None,
+                },
+                "selector_expression" => {
+                    let method = node_text(function.child_by_field_name("field")?, source);
+                    let receiver = node_text(function.child_by_field_name("operand")?, source);
+                    if let Some(&(_, op)) = LOCKS.iter().find(|(name, _)| *name == method) {
+                        return Some((op, receiver));
+                    }
+                    let &(_, op) = WAIT_GROUP.iter().find(|(name, _)| *name == method)?;
+                    let last = receiver.rsplit('.').next().unwrap_or(receiver);
+                    wait_groups.contains(last).then_some((op, receiver))
+                }
+                _ => None,
+            }
+        }
+        _ => None,
+    }
+}
+
+/// The variable or field a value is assigned to: `jobs := make(...)`,
+/// `s.jobs = make(...)`, `var jobs = make(...)` or `Pool{jobs: make(...)}`.
+fn assigned_name<'s>(value: Node, source: &'s str) -> Option<&'s str> {
+    let mut node = value;
+    let mut parent = value.parent()?;
+    while matches!(parent.kind(), "expression_list" | "literal_element") {
+        if parent.kind() == "expression_list" {
+            node = parent;
+        }
+        parent = parent.parent()?;
+    }
+    match parent.kind() {
+        "short_var_declaration" | "assignment_statement" => {
+            let right = parent.child_by_field_name("right")?;
+            let left = parent.child_by_field_name("left")?;
+            let index = if right == node {
+                (0..right.named_child_count()).find(|&i| right.named_child(i) == Some(value))?
+            } else {
+                0
+            };
+            let target = if left.kind() == "expression_list" {
+                left.named_child(index)?
+            } else {
+                left
+            };
+            Some(node_text(target, source))
+        }
+        "var_spec" => Some(node_text(parent.child_by_field_name("name")?, source)),
+        "keyed_element" => Some(node_text(parent.named_child(0)?, source)),
+        _ => None,
+    }
+}
+
+/// Names the file declares as a `sync.WaitGroup`: variables, fields and parameters.
+fn wait_groups<'s>(root: Node, source: &'s str) -> HashSet<&'s str> {
+    let mut names = HashSet::new();
+    let is_wait_group = |text: &str| {
+        let text = text.trim_start_matches('&').trim_start_matches('*');
+        text == "sync.WaitGroup" || text.starts_with("sync.WaitGroup{")
+    };
+    visit(root, &mut |node| match node.kind() {
+        "var_spec" | "field_declaration" | "parameter_declaration" => {
+            let typed = node
+                .child_by_field_name("type")
+                .or_else(|| node.child_by_field_name("value"))
+                .is_some_and(|t| is_wait_group(node_text(t, source)));
+            if typed {
+                let mut cursor = node.walk();
+                for name in node.children_by_field_name("name", &mut cursor) {
+                    names.insert(node_text(name, source));
+                }
+            }
+        }
+        "short_var_declaration" => {
+            let (Some(left), Some(right)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+            ) else {
+                return;
+            };
+            if is_wait_group(node_text(right, source)) {
+                names.insert(node_text(left, source));
+            }
+        }
+        _ => {}
+    });
+    names
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_channels_mutexes_and_wait_groups() {
+        let source = r#"package pool
+
+type Pool struct {
+	mu   sync.Mutex
+	jobs chan Job
+	wg   sync.WaitGroup
+}
+
+func New() *Pool {
+	return &Pool{jobs: make(chan Job, 8)}
+}
+
+func (p *Pool) Submit(j Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wg.Add(1)
+	p.jobs <- j
+}
+
+func (p *Pool) worker(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		select {
+		case j := <-p.jobs:
+			j.Run()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pool) Close() {
+	close(p.jobs)
+	p.wg.Wait()
+	results := make(chan Result)
+	_ = results
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "pool.go")
+            .unwrap();
+        let found: Vec<_> = result
+            .sync_sites
+            .iter()
+            .map(|s| (s.line, s.op, s.object.as_str()))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (10, SyncOp::Make, "jobs"),
+                (14, SyncOp::Lock, "p.mu"),
+                (15, SyncOp::Unlock, "p.mu"),
+                (16, SyncOp::Add, "p.wg"),
+                (17, SyncOp::Send, "p.jobs"),
+                (21, SyncOp::Done, "p.wg"),
+                (24, SyncOp::Receive, "p.jobs"),
+                (26, SyncOp::Receive, "ctx.Done()"),
+                (33, SyncOp::Close, "p.jobs"),
+                (34, SyncOp::Wait, "p.wg"),
+                (35, SyncOp::Make, "results"),
+            ]
+        );
+    }
+}
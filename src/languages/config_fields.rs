@@ -0,0 +1,229 @@
+//! Configuration struct fields in Go and the field accesses that may consume them,
+//! read off the syntax tree during extraction.
+//!
+//! A struct counts as configuration when its name ends in `Config` or `Settings`, or
+//! when a field carries a `yaml`, `toml`, `mapstructure` or `env` tag. Each field
+//! keeps the key it deserializes from when a tag names one.
+//!
+//! Field accesses are recorded for every struct without knowing the operand's type:
+//! `x.Field` outside of calls and package qualifiers, and `T{Field: ...}` keys, which
+//! do name their type. Matching them to config fields happens at query time.
+
+use tree_sitter::Node;
+
+use crate::types::{ConfigField, FieldUse, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::globals::{imports, is_callee, is_write};
+use super::node_text;
+
+const CONFIG_SUFFIXES: &[&str] = &["Config", "Settings"];
+
+/// Tags that mark a config struct, in the order their key is preferred.
+const CONFIG_TAGS: &[&str] = &["yaml", "toml", "mapstructure", "json", "env"];
+
+/// Fields of the config structs among `symbols`, and every function's field accesses.
+pub(crate) fn go_config_fields(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+) -> (Vec<ConfigField>, Vec<FieldUse>) {
+    let mut fields = Vec::new();
+    for sym in symbols.iter().filter(|sym| sym.kind == SymbolKind::Class) {
+        let Some(struct_type) = root
+            .descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+            .and_then(|spec| spec.child_by_field_name("type"))
+            .filter(|t| t.kind() == "struct_type")
+        else {
+            continue;
+        };
+        let declared = struct_fields(sym, struct_type, source);
+        let by_name = CONFIG_SUFFIXES.iter().any(|s| sym.name.ends_with(s));
+        let by_tag = declared.iter().any(|(_, tagged)| *tagged);
+        if by_name || by_tag {
+            fields.extend(declared.into_iter().map(|(field, _)| field));
+        }
+    }
+
+    let imports = imports(root, source);
+    let mut uses = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        visit(function, &mut |node| {
+            let (field, receiver, write) = match node.kind() {
+                "selector_expression" if !is_callee(node) => {
+                    let (Some(operand), Some(field)) = (
+                        node.child_by_field_name("operand"),
+                        node.child_by_field_name("field"),
+                    ) else {
+                        return;
+                    };
+                    let receiver = node_text(operand, source);
+                    if operand.kind() == "identifier" && imports.contains(receiver) {
+                        return;
+                    }
+                    (node_text(field, source), receiver, is_write(node))
+                }
+                "keyed_element" => {
+                    let Some(literal_type) = node
+                        .parent()
+                        .and_then(|body| body.parent())
+                        .filter(|lit| lit.kind() == "composite_literal")
+                        .and_then(|lit| lit.child_by_field_name("type"))
+                    else {
+                        return;
+                    };
+                    let Some(key) = node.named_child(0) else {
+                        return;
+                    };
+                    let key = node_text(key, source);
+                    (key, node_text(literal_type, source), true)
+                }
+                _ => return,
+            };
+            if !field.starts_with(|c: char| c.is_ascii_uppercase()) {
+                return;
+            }
+            uses.push(FieldUse {
+                symbol_id: sym.id.clone(),
+                line: node.start_position().row as u32 + 1,
+                field: field.to_string(),
+                receiver: receiver.trim_start_matches('&').to_string(),
+                write,
+            });
+        });
+    }
+    (fields, uses)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// Named fields of `struct_type`, each with whether it carries a config tag.
+fn struct_fields(owner: &Symbol, struct_type: Node, source: &str) -> Vec<(ConfigField, bool)> {
+    let mut fields = Vec::new();
+    let Some(list) = struct_type.named_child(0) else {
+        return fields;
+    };
+    for declaration in list.named_children(&mut list.walk()) {
+        if declaration.kind() != "field_declaration" {
+            continue;
+        }
+        let tag = declaration
+            .child_by_field_name("tag")
+            .map(|t| node_text(t, source).trim_matches(|c| c == '`' || c == '"'));
+        let key = tag.and_then(tag_key);
+        if key == Some("-") {
+            continue;
+        }
+        let tagged = tag.is_some_and(|t| {
+            CONFIG_TAGS
+                .iter()
+                .filter(|name| **name != "json")
+                .any(|name| t.contains(&format!("{name}:\"")))
+        });
+        let mut cursor = declaration.walk();
+        for name in declaration.children_by_field_name("name", &mut cursor) {
+            let field = ConfigField {
+                symbol_id: owner.id.clone(),
+                name: node_text(name, source).to_string(),
+                line: name.start_position().row as u32 + 1,
+                key: key.map(str::to_string),
+            };
+            fields.push((field, tagged));
+        }
+    }
+    fields
+}
+
+/// The key named by the first config tag present: `redis_host` in
+/// `yaml:"redis_host,omitempty"`.
+fn tag_key(tag: &str) -> Option<&str> {
+    CONFIG_TAGS.iter().find_map(|name| {
+        let (_, rest) = tag.split_once(&format!("{name}:\""))?;
+        let value = rest.split('"').next()?;
+        let key = value.split(',').next()?;
+        (!key.is_empty()).then_some(key)
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_config_fields_and_uses() {
+        let source = r#"package config
+
+import "os"
+
+type Config struct {
+	AppName   string
+	RedisHost string `yaml:"redis_host"`
+	Secret    string `yaml:"-"`
+}
+
+type Limits struct {
+	Burst int `mapstructure:"burst,omitempty"`
+}
+
+type User struct {
+	Name string `json:"name"`
+}
+
+func Load() *Config {
+	cfg := &Config{AppName: os.Getenv("APP")}
+	cfg.RedisHost = "localhost"
+	return cfg
+}
+
+func (c *Config) Addr() string {
+	return c.RedisHost + c.port + os.PathSeparator
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "config.go")
+            .unwrap();
+        let fields: Vec<_> = result
+            .config_fields
+            .iter()
+            .map(|f| (f.name.as_str(), f.line, f.key.as_deref()))
+            .collect();
+        assert_eq!(
+            fields,
+            [
+                ("AppName", 6, None),
+                ("RedisHost", 7, Some("redis_host")),
+                ("Burst", 12, Some("burst")),
+            ]
+        );
+
+        let uses: Vec<_> = result
+            .field_uses
+            .iter()
+            .map(|u| (u.line, u.field.as_str(), u.receiver.as_str(), u.write))
+            .collect();
+        assert_eq!(
+            uses,
+            [
+                (20, "AppName", "Config", true),
+                (21, "RedisHost", "cfg", true),
+                (26, "RedisHost", "c", false),
+            ]
+        );
+    }
+}
@@ -0,0 +1,224 @@
+//! Where Go code creates values of its named types, read off the syntax tree
+//! during extraction: composite literals (`User{...}`), their address
+//! (`&User{...}`) and `new(User)`, in functions, methods and package-level
+//! variable initializers.
+//!
+//! Types are kept without package qualifier or type arguments, as for
+//! serializations: `&store.Entry[string]{}` creates an `Entry`. Slice, array and
+//! map literals create containers rather than values of their element type and
+//! are left out, but the elements inside them count on their own, including
+//! those whose type is elided (`[]User{{Name: "root"}}`).
+//!
+//! Functions returning the type (`New*` constructors and other factories) are
+//! found from signatures at query time, not here.
+
+use tree_sitter::Node;
+
+use crate::types::{Construction, ConstructionKind, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::consts::BUILTIN_TYPES;
+use super::node_text;
+use super::serialize::base_type;
+
+/// Every construction site in the functions, methods and package-level
+/// variables among `symbols`.
+pub(crate) fn go_constructions(root: Node, source: &str, symbols: &[Symbol]) -> Vec<Construction> {
+    let mut constructions = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        collect(function, source, &sym.id, &mut constructions);
+    }
+    // A package-level `var` symbol spans its name only: walk the whole spec.
+    for declaration in root.named_children(&mut root.walk()) {
+        if declaration.kind() != "var_declaration" {
+            continue;
+        }
+        visit(declaration, &mut |spec| {
+            if spec.kind() != "var_spec" {
+                return;
+            }
+            let Some(name) = spec.child_by_field_name("name") else {
+                return;
+            };
+            let owner = symbols.iter().find(|s| {
+                s.kind == SymbolKind::Variable && s.start_byte as usize == name.start_byte()
+            });
+            if let Some(owner) = owner {
+                collect(spec, source, &owner.id, &mut constructions);
+            }
+        });
+    }
+    constructions
+}
+
+fn collect(node: Node, source: &str, symbol_id: &str, constructions: &mut Vec<Construction>) {
+    visit(node, &mut |node| {
+        let (ty, kind) = match node.kind() {
+            "composite_literal" => {
+                let addressed = node.parent().is_some_and(|p| {
+                    p.kind() == "unary_expression"
+                        && p.child_by_field_name("operator")
+                            .is_some_and(|op| node_text(op, source) == "&")
+                });
+                let kind = if addressed {
+                    ConstructionKind::Pointer
+                } else {
+                    ConstructionKind::Literal
+                };
+                elided_elements(node, source, symbol_id, constructions);
+                (node.child_by_field_name("type"), kind)
+            }
+            "call_expression" => {
+                let is_new = node
+                    .child_by_field_name("function")
+                    .is_some_and(|f| f.kind() == "identifier" && node_text(f, source) == "new");
+                if !is_new {
+                    return;
+                }
+                let ty = node
+                    .child_by_field_name("arguments")
+                    .and_then(|args| args.named_child(0));
+                (ty, ConstructionKind::New)
+            }
+            _ => return,
+        };
+        let Some(type_name) = ty.and_then(|ty| created_type(node_text(ty, source))) else {
+            return;
+        };
+        constructions.push(Construction {
+            symbol_id: symbol_id.to_string(),
+            line: node.start_position().row as u32 + 1,
+            type_name,
+            kind,
+        });
+    });
+}
+
+/// The elements of a slice, array or map literal written without their type:
+/// `{Name: "root"}` in `[]User{{Name: "root"}}` creates a `User`.
+fn elided_elements(
+    literal: Node,
+    source: &str,
+    symbol_id: &str,
+    constructions: &mut Vec<Construction>,
+) {
+    let Some(container) = literal.child_by_field_name("type") else {
+        return;
+    };
+    let element = match container.kind() {
+        "slice_type" | "array_type" | "implicit_length_array_type" => {
+            container.child_by_field_name("element")
+        }
+        "map_type" => container.child_by_field_name("value"),
+        _ => None,
+    };
+    let Some(element) = element.map(|e| node_text(e, source)) else {
+        return;
+    };
+    let kind = if element.starts_with('*') {
+        ConstructionKind::Pointer
+    } else {
+        ConstructionKind::Literal
+    };
+    let Some(type_name) = created_type(element.trim_start_matches('*')) else {
+        return;
+    };
+    let Some(body) = literal.child_by_field_name("body") else {
+        return;
+    };
+    for item in body.named_children(&mut body.walk()) {
+        // The value of a keyed element comes last.
+        let value = match item.kind() {
+            "keyed_element" => item.named_child(item.named_child_count().saturating_sub(1)),
+            _ => Some(item),
+        };
+        let value = value.map(|v| match v.kind() {
+            "literal_element" => v.named_child(0).unwrap_or(v),
+            _ => v,
+        });
+        if let Some(value) = value.filter(|v| v.kind() == "literal_value") {
+            constructions.push(Construction {
+                symbol_id: symbol_id.to_string(),
+                line: value.start_position().row as u32 + 1,
+                type_name: type_name.clone(),
+                kind,
+            });
+        }
+    }
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// The named type a literal or `new` creates; `None` for containers, anonymous
+/// structs and predeclared types.
+fn created_type(text: &str) -> Option<String> {
+    if text.starts_with(['[', '*']) || text.starts_with("map[") || text.starts_with("struct") {
+        return None;
+    }
+    let without_args = text.split('[').next().unwrap_or(text);
+    base_type(without_args).filter(|name| !BUILTIN_TYPES.contains(&name.as_str()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_constructions() {
+        let source = r#"package store
+
+var defaultCache = &Cache{size: 16}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, cache: defaultCache, users: []User{{Name: "root"}}}
+}
+
+func (s *Store) Get(id int) (*Entry[string], error) {
+	e := new(Entry[string])
+	_ = map[string]int{}
+	_ = struct{ ok bool }{true}
+	opts := sql.TxOptions{ReadOnly: true}
+	_ = opts
+	_ = new(int)
+	return e, nil
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "store/store.go")
+            .unwrap();
+        let found: Vec<_> = result
+            .constructions
+            .iter()
+            .map(|c| {
+                let owner = result.symbols.iter().find(|s| s.id == c.symbol_id).unwrap();
+                (owner.name.as_str(), c.line, c.kind, c.type_name.as_str())
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("NewStore", 6, ConstructionKind::Pointer, "Store"),
+                ("NewStore", 6, ConstructionKind::Literal, "User"),
+                ("Get", 10, ConstructionKind::New, "Entry"),
+                ("Get", 13, ConstructionKind::Literal, "TxOptions"),
+                ("defaultCache", 3, ConstructionKind::Pointer, "Cache"),
+            ]
+        );
+    }
+}
@@ -0,0 +1,474 @@
+//! Values of Go constants, worked out from their declarations during extraction.
+//!
+//! Each `const` block is walked in order with `iota` counting its specs. A spec
+//! without `= ...` repeats the type and expressions of the one before it, as Go
+//! does, so `A T = iota` followed by bare `B` and `C` gives 0, 1, 2 of type `T`.
+//! Expressions are evaluated over integers, floats, strings and booleans, with
+//! the arithmetic, shift, bit and comparison operators, conversions `T(x)` and
+//! references to constants declared earlier in the same file. Anything else
+//! (another package's constant, `len`, `unsafe.Sizeof`) leaves the value unknown;
+//! the initializer text is kept either way.
+
+use std::collections::HashMap;
+
+use tree_sitter::Node;
+
+use crate::types::{Constant, Symbol, SymbolKind};
+
+use super::node_text;
+
+/// Predeclared types, which a conversion does not name as the constant's type.
+pub(super) const BUILTIN_TYPES: &[&str] = &[
+    "bool",
+    "byte",
+    "complex64",
+    "complex128",
+    "float32",
+    "float64",
+    "int",
+    "int8",
+    "int16",
+    "int32",
+    "int64",
+    "rune",
+    "string",
+    "uint",
+    "uint8",
+    "uint16",
+    "uint32",
+    "uint64",
+    "uintptr",
+];
+
+/// Calls that are allowed in a constant but are not conversions.
+fn is_builtin_call(function: &str) -> bool {
+    matches!(
+        function,
+        "len" | "cap" | "real" | "imag" | "complex" | "min" | "max"
+    ) || function.starts_with("unsafe.")
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Value {
+    Int(i128),
+    Float(f64),
+    Str(String),
+    Bool(bool),
+}
+
+impl std::fmt::Display for Value {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Value::Int(n) => write!(f, "{n}"),
+            Value::Float(x) => write!(f, "{x:?}"),
+            Value::Str(s) => write!(f, "{s:?}"),
+            Value::Bool(b) => write!(f, "{b}"),
+        }
+    }
+}
+
+/// Every constant among `symbols` with its type and value, in source order.
+pub(crate) fn go_constants(root: Node, source: &str, symbols: &[Symbol]) -> Vec<Constant> {
+    let by_start: HashMap<u32, &Symbol> = symbols
+        .iter()
+        .filter(|s| s.kind == SymbolKind::Variable)
+        .map(|s| (s.start_byte, s))
+        .collect();
+    let mut known: HashMap<String, (Value, Option<String>)> = HashMap::new();
+    let mut constants = Vec::new();
+    visit(root, &mut |decl| {
+        let mut specs = Vec::new();
+        for child in decl.named_children(&mut decl.walk()) {
+            match child.kind() {
+                "const_spec" => specs.push(child),
+                "const_spec_list" => specs.extend(
+                    child
+                        .named_children(&mut child.walk())
+                        .filter(|s| s.kind() == "const_spec"),
+                ),
+                _ => {}
+            }
+        }
+
+        // Type and expressions of the last spec that had them, for implicit repetition.
+        let mut previous: (Option<String>, Vec<Node>) = (None, Vec::new());
+        for (iota, spec) in specs.into_iter().enumerate() {
+            let explicit = spec
+                .child_by_field_name("value")
+                .map(|list| list.named_children(&mut list.walk()).collect::<Vec<_>>());
+            if let Some(exprs) = explicit {
+                let ty = spec
+                    .child_by_field_name("type")
+                    .map(|t| node_text(t, source).to_string());
+                previous = (ty, exprs);
+            }
+            let (declared, exprs) = &previous;
+
+            let names = spec
+                .children_by_field_name("name", &mut spec.walk())
+                .collect::<Vec<_>>();
+            for (i, name) in names.into_iter().enumerate() {
+                let Some(expr) = exprs.get(i).copied() else {
+                    continue;
+                };
+                let value = eval(expr, source, iota as i128, &known);
+                let type_name = declared
+                    .clone()
+                    .or_else(|| implied_type(expr, source, &known));
+                let name_text = node_text(name, source);
+                if let Some(v) = &value {
+                    known.insert(name_text.to_string(), (v.clone(), type_name.clone()));
+                }
+                let Some(sym) = by_start.get(&(name.start_byte() as u32)) else {
+                    continue;
+                };
+                if name_text == "_" {
+                    continue;
+                }
+                constants.push(Constant {
+                    symbol_id: sym.id.clone(),
+                    line: sym.start_line,
+                    type_name,
+                    value: value.map(|v| v.to_string()),
+                    expr: node_text(expr, source).to_string(),
+                    iota: iota as u32,
+                });
+            }
+        }
+    });
+    constants
+}
+
+fn visit<'a>(node: Node<'a>, f: &mut impl FnMut(Node<'a>)) {
+    if node.kind() == "const_declaration" {
+        f(node);
+        return;
+    }
+    for child in node.named_children(&mut node.walk()) {
+        visit(child, f);
+    }
+}
+
+/// The type an untyped declaration takes from its expression: `T(x)`, or a typed
+/// constant it names.
+fn implied_type(
+    expr: Node,
+    source: &str,
+    known: &HashMap<String, (Value, Option<String>)>,
+) -> Option<String> {
+    match expr.kind() {
+        "call_expression" => {
+            let function = expr.child_by_field_name("function")?;
+            let name = node_text(function, source);
+            (matches!(
+                function.kind(),
+                "identifier" | "qualified_type" | "selector_expression"
+            ) && !BUILTIN_TYPES.contains(&name)
+                && !is_builtin_call(name))
+            .then(|| name.to_string())
+        }
+        "identifier" => known.get(node_text(expr, source))?.1.clone(),
+        "parenthesized_expression" => implied_type(expr.named_child(0)?, source, known),
+        _ => None,
+    }
+}
+
+fn eval(
+    node: Node,
+    source: &str,
+    iota: i128,
+    known: &HashMap<String, (Value, Option<String>)>,
+) -> Option<Value> {
+    let text = node_text(node, source);
+    match node.kind() {
+        "iota" => Some(Value::Int(iota)),
+        "true" => Some(Value::Bool(true)),
+        "false" => Some(Value::Bool(false)),
+        "int_literal" => parse_int(text).map(Value::Int),
+        "float_literal" => text.replace('_', "").parse().ok().map(Value::Float),
+        "rune_literal" => parse_rune(text).map(|c| Value::Int(c as i128)),
+        "interpreted_string_literal" => Some(Value::Str(unquote(text))),
+        "raw_string_literal" => Some(Value::Str(text.trim_matches('`').to_string())),
+        "identifier" => known.get(text).map(|(v, _)| v.clone()),
+        "parenthesized_expression" => eval(node.named_child(0)?, source, iota, known),
+        "call_expression" => {
+            // Conversions keep the value: `Status(1)`, `float64(x)`, `string(r)`.
+            let function = node.child_by_field_name("function")?;
+            let args = node.child_by_field_name("arguments")?;
+            if args.named_child_count() != 1 || is_builtin_call(node_text(function, source)) {
+                return None;
+            }
+            let value = eval(args.named_child(0)?, source, iota, known)?;
+            match (node_text(function, source), value) {
+                ("string", Value::Int(c)) => char::from_u32(c as u32).map(|c| Value::Str(c.into())),
+                ("float32" | "float64", Value::Int(n)) => Some(Value::Float(n as f64)),
+                (_, value) => Some(value),
+            }
+        }
+        "unary_expression" => {
+            let operand = eval(node.child_by_field_name("operand")?, source, iota, known)?;
+            let operator = node.child_by_field_name("operator")?;
+            match (node_text(operator, source), operand) {
+                ("-", Value::Int(n)) => Some(Value::Int(-n)),
+                ("-", Value::Float(x)) => Some(Value::Float(-x)),
+                ("+", v) => Some(v),
+                ("^", Value::Int(n)) => Some(Value::Int(!n)),
+                ("!", Value::Bool(b)) => Some(Value::Bool(!b)),
+                _ => None,
+            }
+        }
+        "binary_expression" => {
+            let left = eval(node.child_by_field_name("left")?, source, iota, known)?;
+            let right = eval(node.child_by_field_name("right")?, source, iota, known)?;
+            let operator = node.child_by_field_name("operator")?;
+            binary(node_text(operator, source), left, right)
+        }
+        _ => None,
+    }
+}
+
+fn binary(op: &str, left: Value, right: Value) -> Option<Value> {
+    use Value::*;
+    match (left, right) {
+        (Int(a), Int(b)) => match op {
+            "+" => a.checked_add(b).map(Int),
+            "-" => a.checked_sub(b).map(Int),
+            "*" => a.checked_mul(b).map(Int),
+            "/" => a.checked_div(b).map(Int),
+            "%" => a.checked_rem(b).map(Int),
+            "<<" => u32::try_from(b)
+                .ok()
+                .filter(|&s| s < 127)
+                .and_then(|s| a.checked_shl(s))
+                .map(Int),
+            ">>" => u32::try_from(b)
+                .ok()
+                .filter(|&s| s < 128)
+                .map(|s| Int(a >> s)),
+            "&" => Some(Int(a & b)),
+            "|" => Some(Int(a | b)),
+            "^" => Some(Int(a ^ b)),
+            "&^" => Some(Int(a & !b)),
+            _ => compare(op, a.cmp(&b)),
+        },
+        (Float(a), Float(b)) => float(op, a, b),
+        (Int(a), Float(b)) => float(op, a as f64, b),
+        (Float(a), Int(b)) => float(op, a, b as f64),
+        (Str(a), Str(b)) => match op {
+            "+" => Some(Str(a + &b)),
+            _ => compare(op, a.cmp(&b)),
+        },
+        (Bool(a), Bool(b)) => match op {
+            "&&" => Some(Bool(a && b)),
+            "||" => Some(Bool(a || b)),
+            "==" => Some(Bool(a == b)),
+            "!=" => Some(Bool(a != b)),
+            _ => None,
+        },
+        _ => None,
+    }
+}
+
+fn float(op: &str, a: f64, b: f64) -> Option<Value> {
+    match op {
+        "+" => Some(Value::Float(a + b)),
+        "-" => Some(Value::Float(a - b)),
+        "*" => Some(Value::Float(a * b)),
+        "/" if b != 0.0 => Some(Value::Float(a / b)),
+        _ => compare(op, a.partial_cmp(&b)?),
+    }
+}
+
+fn compare(op: &str, ordering: std::cmp::Ordering) -> Option<Value> {
+    use std::cmp::Ordering::*;
+    let result = match op {
+        "==" => ordering == Equal,
+        "!=" => ordering != Equal,
+        "<" => ordering == Less,
+        "<=" => ordering != Greater,
+        ">" => ordering == Greater,
+        ">=" => ordering != Less,
+        _ => return None,
+    };
+    Some(Value::Bool(result))
+}
+
+fn parse_int(text: &str) -> Option<i128> {
+    let digits = text.replace('_', "");
+    let lower = digits.to_ascii_lowercase();
+    if let Some(hex) = lower.strip_prefix("0x") {
+        i128::from_str_radix(hex, 16).ok()
+    } else if let Some(bin) = lower.strip_prefix("0b") {
+        i128::from_str_radix(bin, 2).ok()
+    } else if let Some(oct) = lower.strip_prefix("0o") {
+        i128::from_str_radix(oct, 8).ok()
+    } else if lower.len() > 1 && lower.starts_with('0') {
+        i128::from_str_radix(&lower[1..], 8).ok()
+    } else {
+        lower.parse().ok()
+    }
+}
+
+fn parse_rune(text: &str) -> Option<char> {
+    let inner = text.strip_prefix('\'')?.strip_suffix('\'')?;
+    let mut chars = unquote_chars(inner);
+    let c = chars.next()?;
+    chars.next().is_none().then_some(c)
+}
+
+fn unquote(text: &str) -> String {
+    unquote_chars(text.trim_matches('"')).collect()
+}
+
+/// The common escapes; an unknown one is kept as written.
+fn unquote_chars(text: &str) -> impl Iterator<Item = char> + '_ {
+    let mut chars = text.chars();
+    std::iter::from_fn(move || {
+        let c = chars.next()?;
+        if c != '\\' {
+            return Some(c);
+        }
+        Some(match chars.next()? {
+            'n' => '\n',
+            't' => '\t',
+            'r' => '\r',
+            '0' => '\0',
+            other => other,
+        })
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use crate::languages::go::GoExtractor;
+    use crate::languages::Extractor;
+    use crate::types::Constant;
+
+    fn constants(source: &str) -> Vec<(String, Constant)> {
+        let result = GoExtractor::new().extract(source, "consts.go").unwrap();
+        result
+            .constants
+            .into_iter()
+            .map(|c| {
+                let name = result
+                    .symbols
+                    .iter()
+                    .find(|s| s.id == c.symbol_id)
+                    .unwrap()
+                    .name
+                    .clone();
+                (name, c)
+            })
+            .collect()
+    }
+
+    fn values(source: &str) -> Vec<(String, Option<String>, Option<String>)> {
+        constants(source)
+            .into_iter()
+            .map(|(name, c)| (name, c.type_name, c.value))
+            .collect()
+    }
+
+    fn t(
+        name: &str,
+        ty: Option<&str>,
+        value: Option<&str>,
+    ) -> (String, Option<String>, Option<String>) {
+        (
+            name.to_string(),
+            ty.map(String::from),
+            value.map(String::from),
+        )
+    }
+
+    #[test]
+    fn test_iota_repeats_type_and_expression() {
+        let got = values(
+            r#"package models
+
+type PaymentStatus int
+
+const (
+    PaymentPending PaymentStatus = iota
+    PaymentProcessing
+    _
+    PaymentFailed
+)
+
+const (
+    KB = 1 << (10 * (iota + 1))
+    MB
+)
+"#,
+        );
+        assert_eq!(
+            got,
+            [
+                t("PaymentPending", Some("PaymentStatus"), Some("0")),
+                t("PaymentProcessing", Some("PaymentStatus"), Some("1")),
+                t("PaymentFailed", Some("PaymentStatus"), Some("3")),
+                t("KB", None, Some("1024")),
+                t("MB", None, Some("1048576")),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_literals_conversions_and_references() {
+        let got = values(
+            r#"package main
+
+const Name = "cart" + "og"
+const Tab = '\t'
+const Mask = 0x_FF &^ 0b1111
+const Ratio = 3 / 2.0
+const Max = Level(Mask)
+const Same = Max
+const Debug = Mask > 10 && !false
+const Remote = http.StatusOK
+const Size = unsafe.Sizeof(Mask)
+const A, B = 1, "two"
+"#,
+        );
+        assert_eq!(
+            got,
+            [
+                t("Name", None, Some("\"cartog\"")),
+                t("Tab", None, Some("9")),
+                t("Mask", None, Some("240")),
+                t("Ratio", None, Some("1.5")),
+                t("Max", Some("Level"), Some("240")),
+                t("Same", Some("Level"), Some("240")),
+                t("Debug", None, Some("true")),
+                t("Remote", None, None),
+                t("Size", None, None),
+                t("A", None, Some("1")),
+                t("B", None, Some("\"two\"")),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_signature_shows_type_and_value() {
+        let result = GoExtractor::new()
+            .extract(
+                "package main\n\ntype Level int\n\nconst (\n    Low Level = iota + 1\n    High\n    Far = pkg.Value\n)\n",
+                "consts.go",
+            )
+            .unwrap();
+        let signature = |name: &str| {
+            result
+                .symbols
+                .iter()
+                .find(|s| s.name == name)
+                .and_then(|s| s.signature.clone())
+        };
+        assert_eq!(signature("Low").as_deref(), Some(" Level = 1"));
+        assert_eq!(signature("High").as_deref(), Some(" Level = 2"));
+        assert_eq!(signature("Far").as_deref(), Some(" = pkg.Value"));
+        let (_, high) = constants("package main\n\nconst (\n    Low = iota\n    High\n)\n")
+            .pop()
+            .unwrap();
+        assert_eq!((high.iota, high.expr.as_str()), (1, "iota"));
+    }
+}
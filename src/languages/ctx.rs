@@ -0,0 +1,160 @@
+//! `context.Context` use in Go, read off the syntax tree during extraction.
+//!
+//! Records which functions take a `context.Context` parameter, and the calls that
+//! run without the caller's context: a fresh `context.Background()`/`context.TODO()`,
+//! or I/O through an API that has a context-taking variant (`http.Get` vs
+//! `http.NewRequestWithContext`, `db.Query` vs `db.QueryContext`).
+
+use tree_sitter::Node;
+
+use crate::types::{ContextLoss, ContextSite, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+const FRESH: &[&str] = &["context.Background", "context.TODO"];
+
+/// Package functions that do I/O and have a context-taking variant.
+const IO_FUNCS: &[&str] = &[
+    "http.Get",
+    "http.Head",
+    "http.Post",
+    "http.PostForm",
+    "http.NewRequest",
+    "net.Dial",
+    "net.DialTimeout",
+    "exec.Command",
+];
+
+/// `database/sql` methods with a `...Context` twin, matched on any receiver.
+const IO_METHODS: &[&str] = &["Query", "QueryRow", "Exec", "Prepare", "Begin", "Ping"];
+
+/// Go functions and methods among `symbols` that take a context, and the calls
+/// that do without one.
+pub(crate) fn go_context(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+) -> (Vec<String>, Vec<ContextSite>) {
+    let mut takes_context = Vec::new();
+    let mut sites = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        if takes_context_param(function, source) {
+            takes_context.push(sym.id.clone());
+        }
+        visit(function, &mut |call| {
+            if call.kind() != "call_expression" {
+                return;
+            }
+            let Some(callee) = call.child_by_field_name("function") else {
+                return;
+            };
+            let text = node_text(callee, source);
+            let loss = if FRESH.contains(&text) {
+                ContextLoss::Fresh
+            } else if IO_FUNCS.contains(&text) || is_io_method(callee, source) {
+                ContextLoss::Io
+            } else {
+                return;
+            };
+            sites.push(ContextSite {
+                symbol_id: sym.id.clone(),
+                line: call.start_position().row as u32 + 1,
+                loss,
+                callee: text.to_string(),
+            });
+        });
+    }
+    (takes_context, sites)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+fn takes_context_param(function: Node, source: &str) -> bool {
+    let Some(params) = function.child_by_field_name("parameters") else {
+        return false;
+    };
+    let mut cursor = params.walk();
+    let found = params.named_children(&mut cursor).any(|param| {
+        param
+            .child_by_field_name("type")
+            .is_some_and(|t| node_text(t, source) == "context.Context")
+    });
+    found
+}
+
+fn is_io_method(callee: Node, source: &str) -> bool {
+    callee.kind() == "selector_expression"
+        && callee
+            .child_by_field_name("field")
+            .is_some_and(|f| IO_METHODS.contains(&node_text(f, source)))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_context_params_and_losses() {
+        let source = r#"package store
+
+func (s *Store) Load(ctx context.Context, id int) (*User, error) {
+	return s.fetch(id)
+}
+
+func (s *Store) fetch(id int) (*User, error) {
+	rows, err := s.db.Query("SELECT * FROM users WHERE id = ?", id)
+	_ = s.db.QueryRowContext(context.TODO(), "SELECT 1")
+	return scan(rows), err
+}
+
+func Ping(ctx context.Context) error {
+	resp, err := http.Get("https://example.com")
+	_ = resp
+	return err
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "store.go")
+            .unwrap();
+        let name = |id: &str| {
+            result
+                .symbols
+                .iter()
+                .find(|s| s.id == id)
+                .map(|s| s.name.as_str())
+                .unwrap()
+        };
+        let takes: Vec<_> = result.takes_context.iter().map(|id| name(id)).collect();
+        assert_eq!(takes, ["Load", "Ping"]);
+        let sites: Vec<_> = result
+            .context_sites
+            .iter()
+            .map(|s| (name(&s.symbol_id), s.line, s.loss, s.callee.as_str()))
+            .collect();
+        assert_eq!(
+            sites,
+            [
+                ("fetch", 8, ContextLoss::Io, "s.db.Query"),
+                ("fetch", 9, ContextLoss::Fresh, "context.TODO"),
+                ("Ping", 14, ContextLoss::Io, "http.Get"),
+            ]
+        );
+    }
+}
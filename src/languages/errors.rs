@@ -0,0 +1,738 @@
+//! Error handling at call sites, read off the syntax tree during extraction.
+//!
+//! For each call a function makes, [`analyze`] records what happens to an error
+//! coming out of it ([`ErrorHandling`]), keyed like the call's edge:
+//!
+//! - **Go**: the `err` assigned from the call is followed to its `if err != nil`
+//!   check. Returning it is propagating, `%w` or `errors.Wrap` is wrapping,
+//!   `fmt.Errorf` without `%w` or another error is replacing, anything else
+//!   (including `_` and a discarded result) is swallowing.
+//! - **Rust**: `?` and a tail or `return` propagate, `.map_err`/`.context` before
+//!   them wrap, `.ok()`/`.unwrap_or*`/`let _ =`/a discarded result swallow.
+//!   `unwrap`/`expect` panic and are left unclassified.
+//! - **Python, JavaScript, Ruby**: a call outside any `try` propagates. Inside one,
+//!   the handlers decide: no raise swallows, re-raising the caught error
+//!   propagates, raising a new error that mentions it (`from e`, `cause: e`) wraps,
+//!   and raising an unrelated one replaces.
+//!
+//! It also lists the functions that produce errors of their own: Go functions
+//! returning `error`, Rust functions returning a `Result`, and functions with a
+//! `raise`/`throw` of their own.
+
+use std::collections::{HashMap, HashSet};
+
+use tree_sitter::Node;
+
+use crate::types::{Edge, EdgeKind, ErrorFlow, ErrorHandling, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+/// Node kinds and conventions of one grammar.
+pub(crate) struct Rules {
+    /// Call node kinds, with the field holding the callee name the extractor
+    /// records as the edge target (or its last segment).
+    pub calls: &'static [(&'static str, &'static str)],
+    pub functions: &'static [&'static str],
+    pub model: Model,
+}
+
+/// How a language reports errors.
+pub(crate) enum Model {
+    /// `error` values checked with `if err != nil`.
+    Go,
+    /// `Result` values and `?`.
+    Rust,
+    Exceptions(Exceptions),
+}
+
+/// Node kinds of an exception-based grammar.
+pub(crate) struct Exceptions {
+    /// Nodes whose body is protected by handler children (`try`, `begin`).
+    pub tries: &'static [&'static str],
+    pub handlers: &'static [&'static str],
+    /// `finally`/`else`/`ensure` children: not protected by the handlers.
+    pub clauses: &'static [&'static str],
+    pub raises: &'static [&'static str],
+    /// Calls that raise (`raise`, `fail`) in grammars without a raise statement.
+    pub raise_calls: &'static [&'static str],
+}
+
+pub(crate) const GO: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    functions: complexity::GO.functions,
+    model: Model::Go,
+};
+
+pub(crate) const RUST: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    functions: complexity::RUST.functions,
+    model: Model::Rust,
+};
+
+pub(crate) const PYTHON: Rules = Rules {
+    calls: &[("call", "function")],
+    functions: complexity::PYTHON.functions,
+    model: Model::Exceptions(Exceptions {
+        tries: &["try_statement"],
+        handlers: &["except_clause", "except_group_clause"],
+        clauses: &["else_clause", "finally_clause"],
+        raises: &["raise_statement"],
+        raise_calls: &[],
+    }),
+};
+
+/// JavaScript, TypeScript and TSX.
+pub(crate) const JAVASCRIPT: Rules = Rules {
+    calls: &[
+        ("call_expression", "function"),
+        ("new_expression", "constructor"),
+    ],
+    functions: complexity::JAVASCRIPT.functions,
+    model: Model::Exceptions(Exceptions {
+        tries: &["try_statement"],
+        handlers: &["catch_clause"],
+        clauses: &["finally_clause"],
+        raises: &["throw_statement"],
+        raise_calls: &[],
+    }),
+};
+
+pub(crate) const RUBY: Rules = Rules {
+    calls: &[("call", "method")],
+    // Blocks run in place, so a `rescue` around an `each` still catches their errors.
+    functions: &["method", "singleton_method", "lambda"],
+    model: Model::Exceptions(Exceptions {
+        tries: &["begin", "body_statement"],
+        handlers: &["rescue"],
+        clauses: &["else", "ensure"],
+        raises: &[],
+        raise_calls: &["raise", "fail"],
+    }),
+};
+
+/// Go wrappers that keep the original error reachable by `errors.Is`/`errors.As`.
+const GO_WRAPPERS: &[&str] = &[
+    "errors.Wrap",
+    "errors.Wrapf",
+    "errors.WithMessage",
+    "errors.WithMessagef",
+    "errors.WithStack",
+    "errors.Join",
+];
+
+/// Rust adapters that wrap an error on its way to `?`.
+const RUST_WRAPPERS: &[&str] = &[
+    "map_err",
+    "context",
+    "with_context",
+    "wrap_err",
+    "wrap_err_with",
+];
+
+/// Rust adapters that turn an error into a value.
+const RUST_SWALLOWERS: &[&str] = &[
+    "ok",
+    "unwrap_or",
+    "unwrap_or_default",
+    "unwrap_or_else",
+    "is_ok",
+    "is_err",
+];
+
+/// Fallible functions among `symbols`, and how each function handles errors from
+/// the calls behind its `Calls` edges.
+pub(crate) fn analyze(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+    edges: &[Edge],
+    rules: &Rules,
+) -> (Vec<String>, Vec<ErrorFlow>) {
+    let mut calls: HashMap<(&str, u32), Vec<&str>> = HashMap::new();
+    for edge in edges.iter().filter(|e| e.kind == EdgeKind::Calls) {
+        calls
+            .entry((edge.source_id.as_str(), edge.line))
+            .or_default()
+            .push(edge.target_name.as_str());
+    }
+
+    let mut fallible = Vec::new();
+    let mut flows = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, rules.functions).unwrap_or(node);
+        if is_fallible(function, source, rules) {
+            fallible.push(sym.id.clone());
+        }
+
+        let mut seen = HashSet::new();
+        visit(function, &mut |call| {
+            let Some(&(_, field)) = rules.calls.iter().find(|(kind, _)| *kind == call.kind())
+            else {
+                return;
+            };
+            let line = call.start_position().row as u32 + 1;
+            let callee = call
+                .child_by_field_name(field)
+                .map_or("", |f| node_text(f, source));
+            // Ruby records `receiver.method` for a call whose field is only `method`.
+            let names_callee = |target: &&&str| {
+                !callee.is_empty()
+                    && target.strip_suffix(callee).is_some_and(|rest| {
+                        rest.is_empty() || rest.ends_with('.') || rest.ends_with(':')
+                    })
+            };
+            let Some(target) = calls
+                .get(&(sym.id.as_str(), line))
+                .and_then(|targets| targets.iter().find(names_callee))
+            else {
+                return;
+            };
+            if !seen.insert((line, *target)) {
+                return;
+            }
+            if let Some(handling) = handling(call, source, rules) {
+                flows.push(ErrorFlow {
+                    source_id: sym.id.clone(),
+                    target_name: target.to_string(),
+                    line,
+                    handling,
+                });
+            }
+        });
+    }
+    (fallible, flows)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    f(node);
+    for child in node.named_children(&mut node.walk()) {
+        visit(child, f);
+    }
+}
+
+/// Whether `function` produces errors of its own.
+fn is_fallible(function: Node, source: &str, rules: &Rules) -> bool {
+    match &rules.model {
+        Model::Go => function
+            .child_by_field_name("result")
+            .is_some_and(|r| mentions(node_text(r, source), "error")),
+        Model::Rust => function
+            .child_by_field_name("return_type")
+            .is_some_and(|r| node_text(r, source).contains("Result")),
+        Model::Exceptions(ex) => {
+            let mut raises = false;
+            visit_body(function, rules, &mut |node| {
+                raises |= is_raise(node, source, ex);
+            });
+            raises
+        }
+    }
+}
+
+/// Visit the descendants of `function`, skipping nested functions.
+fn visit_body<'t>(function: Node<'t>, rules: &Rules, f: &mut impl FnMut(Node<'t>)) {
+    for child in function.named_children(&mut function.walk()) {
+        if !rules.functions.contains(&child.kind()) {
+            f(child);
+            visit_body(child, rules, f);
+        }
+    }
+}
+
+fn handling(call: Node, source: &str, rules: &Rules) -> Option<ErrorHandling> {
+    match &rules.model {
+        Model::Go => go_handling(call, source),
+        Model::Rust => rust_handling(call, source),
+        Model::Exceptions(ex) => exception_handling(call, source, rules, ex),
+    }
+}
+
+// ── Go ──
+
+fn go_handling(call: Node, source: &str) -> Option<ErrorHandling> {
+    let mut node = call;
+    let mut parent = call.parent()?;
+    while matches!(
+        parent.kind(),
+        "parenthesized_expression" | "expression_list"
+    ) {
+        node = parent;
+        parent = parent.parent()?;
+    }
+    match parent.kind() {
+        "return_statement" => Some(ErrorHandling::Propagates),
+        "expression_statement" | "defer_statement" | "go_statement" => {
+            Some(ErrorHandling::Swallows)
+        }
+        "short_var_declaration" | "assignment_statement"
+            if parent.child_by_field_name("right") == Some(node) =>
+        {
+            let left = parent.child_by_field_name("left")?;
+            let var = match left.named_child_count() {
+                0 => left,
+                n => left.named_child(n - 1)?,
+            };
+            let var = node_text(var, source);
+            if var == "_" {
+                return Some(ErrorHandling::Swallows);
+            }
+            // `if v, err := f(); err != nil { ... }`
+            if let Some(check) = parent
+                .parent()
+                .filter(|p| p.kind() == "if_statement")
+                .filter(|p| p.child_by_field_name("initializer") == Some(parent))
+            {
+                return Some(go_check(check, source, var));
+            }
+            let mut next = parent.next_named_sibling();
+            while let Some(stmt) = next {
+                match stmt.kind() {
+                    "if_statement"
+                        if stmt
+                            .child_by_field_name("condition")
+                            .is_some_and(|c| mentions(node_text(c, source), var)) =>
+                    {
+                        return Some(go_check(stmt, source, var));
+                    }
+                    "return_statement" if mentions(node_text(stmt, source), var) => {
+                        return Some(go_return(stmt, source, var));
+                    }
+                    // `err` is overwritten before anyone looked at it.
+                    "short_var_declaration" | "assignment_statement"
+                        if stmt
+                            .child_by_field_name("left")
+                            .is_some_and(|l| mentions(node_text(l, source), var)) =>
+                    {
+                        break;
+                    }
+                    _ => {}
+                }
+                next = stmt.next_named_sibling();
+            }
+            Some(ErrorHandling::Swallows)
+        }
+        _ => None,
+    }
+}
+
+/// What the `if err != nil` branch does with `var`.
+fn go_check(check: Node, source: &str, var: &str) -> ErrorHandling {
+    let Some(branch) = check.child_by_field_name("consequence") else {
+        return ErrorHandling::Swallows;
+    };
+    let mut returns = Vec::new();
+    visit(branch, &mut |node| {
+        if node.kind() == "return_statement" {
+            returns.push(node);
+        }
+    });
+    if let Some(ret) = returns
+        .iter()
+        .find(|r| mentions(node_text(**r, source), var))
+    {
+        return go_return(*ret, source, var);
+    }
+    // `return nil, ErrNotFound` replaces; a bare `return` or `return x, nil` swallows.
+    let fails = returns.iter().any(|r| {
+        let values = r.named_child(0);
+        values
+            .and_then(|v| v.named_child(v.named_child_count().saturating_sub(1)))
+            .is_some_and(|last| node_text(last, source) != "nil")
+    });
+    if fails {
+        ErrorHandling::Replaces
+    } else {
+        ErrorHandling::Swallows
+    }
+}
+
+fn go_return(ret: Node, source: &str, var: &str) -> ErrorHandling {
+    let text = node_text(ret, source);
+    let bare = ret.named_child(0).is_some_and(|values| {
+        values
+            .named_children(&mut values.walk())
+            .any(|v| node_text(v, source) == var)
+    });
+    if bare {
+        ErrorHandling::Propagates
+    } else if text.contains("%w") || GO_WRAPPERS.iter().any(|w| text.contains(w)) {
+        ErrorHandling::Wraps
+    } else if text.contains("fmt.Errorf") {
+        ErrorHandling::Replaces
+    } else {
+        // Held by a custom error value (`&QueryError{Err: err}`).
+        ErrorHandling::Wraps
+    }
+}
+
+// ── Rust ──
+
+fn rust_handling(call: Node, source: &str) -> Option<ErrorHandling> {
+    let mut node = call;
+    let mut wrapped = false;
+    let escapes = |wrapped| {
+        Some(if wrapped {
+            ErrorHandling::Wraps
+        } else {
+            ErrorHandling::Propagates
+        })
+    };
+    loop {
+        let parent = node.parent()?;
+        match parent.kind() {
+            "try_expression" | "return_expression" => return escapes(wrapped),
+            "await_expression" | "parenthesized_expression" => node = parent,
+            "field_expression" => {
+                let method_call = parent.parent().filter(|p| p.kind() == "call_expression")?;
+                let method = node_text(parent.child_by_field_name("field")?, source);
+                if RUST_WRAPPERS.contains(&method) {
+                    wrapped = true;
+                } else if RUST_SWALLOWERS.contains(&method) {
+                    return Some(ErrorHandling::Swallows);
+                } else {
+                    return None;
+                }
+                node = method_call;
+            }
+            "let_declaration" => {
+                let pattern = parent.child_by_field_name("pattern")?;
+                return (node_text(pattern, source) == "_").then_some(ErrorHandling::Swallows);
+            }
+            "expression_statement" => return Some(ErrorHandling::Swallows),
+            // The tail expression of a function body is its return value.
+            "block" => {
+                let is_tail = parent
+                    .named_child(parent.named_child_count().saturating_sub(1))
+                    .is_some_and(|last| last == node);
+                let is_body = parent.parent().is_some_and(|p| p.kind() == "function_item");
+                return if is_tail && is_body {
+                    escapes(wrapped)
+                } else {
+                    None
+                };
+            }
+            _ => return None,
+        }
+    }
+}
+
+// ── Exceptions ──
+
+fn exception_handling(
+    call: Node,
+    source: &str,
+    rules: &Rules,
+    ex: &Exceptions,
+) -> Option<ErrorHandling> {
+    let mut child = call;
+    while let Some(parent) = child.parent() {
+        if rules.functions.contains(&parent.kind()) {
+            break;
+        }
+        if ex.tries.contains(&parent.kind()) {
+            let handlers: Vec<Node> = parent
+                .named_children(&mut parent.walk())
+                .filter(|c| ex.handlers.contains(&c.kind()))
+                .collect();
+            let protected = !handlers.is_empty()
+                && !handlers.contains(&child)
+                && !ex.clauses.contains(&child.kind());
+            if protected {
+                let outcomes: Vec<ErrorHandling> = handlers
+                    .iter()
+                    .map(|h| handler_outcome(*h, source, rules, ex))
+                    .collect();
+                // Errors some handler swallows never reach the callers.
+                return Some(if outcomes.contains(&ErrorHandling::Swallows) {
+                    ErrorHandling::Swallows
+                } else {
+                    outcomes[0]
+                });
+            }
+        }
+        child = parent;
+    }
+    Some(ErrorHandling::Propagates)
+}
+
+fn handler_outcome(handler: Node, source: &str, rules: &Rules, ex: &Exceptions) -> ErrorHandling {
+    let mut raise = None;
+    visit_body(handler, rules, &mut |node| {
+        if raise.is_none() && is_raise(node, source, ex) {
+            raise = Some(node);
+        }
+    });
+    let Some(raise) = raise else {
+        return ErrorHandling::Swallows;
+    };
+    let caught = caught_name(handler, source);
+    let value = raised_value(raise, source);
+    match (value, caught) {
+        (None, _) => ErrorHandling::Propagates,
+        (Some(value), Some(caught)) if value == caught => ErrorHandling::Propagates,
+        // Python `raise X from None` drops the context on purpose.
+        _ if raise
+            .child_by_field_name("cause")
+            .is_some_and(|c| node_text(c, source) == "None") =>
+        {
+            ErrorHandling::Replaces
+        }
+        _ if raise.child_by_field_name("cause").is_some() => ErrorHandling::Wraps,
+        (Some(value), Some(caught)) if mentions(value, caught) => ErrorHandling::Wraps,
+        _ => ErrorHandling::Replaces,
+    }
+}
+
+fn is_raise(node: Node, source: &str, ex: &Exceptions) -> bool {
+    if ex.raises.contains(&node.kind()) {
+        return true;
+    }
+    if ex.raise_calls.is_empty() {
+        return false;
+    }
+    // Ruby: `raise Foo, "msg"` is a call; a bare `raise` is an identifier statement.
+    let name = match node.kind() {
+        "call" if node.child_by_field_name("receiver").is_none() => node
+            .child_by_field_name("method")
+            .map(|m| node_text(m, source)),
+        "identifier" => Some(node_text(node, source)),
+        _ => None,
+    };
+    name.is_some_and(|n| ex.raise_calls.contains(&n))
+}
+
+/// Text of what a raise raises; `None` for a bare re-raise.
+fn raised_value<'a>(raise: Node, source: &'a str) -> Option<&'a str> {
+    let value = match raise.kind() {
+        "call" => raise.child_by_field_name("arguments"),
+        "identifier" => None,
+        _ => raise.named_child(0),
+    }?;
+    Some(node_text(value, source))
+}
+
+/// The variable a handler binds the error to (`as e`, `catch (e)`, `=> e`).
+fn caught_name<'a>(handler: Node, source: &'a str) -> Option<&'a str> {
+    if let Some(param) = handler.child_by_field_name("parameter") {
+        return Some(node_text(param, source));
+    }
+    if let Some(var) = handler.child_by_field_name("variable") {
+        return Some(node_text(var, source).trim_start_matches("=>").trim());
+    }
+    let header = node_text(handler, source).split(':').next()?;
+    let (_, name) = header.rsplit_once(" as ")?;
+    Some(name.trim())
+}
+
+/// Whether `text` contains `word` as a whole identifier.
+fn mentions(text: &str, word: &str) -> bool {
+    let is_ident = |c: char| c.is_alphanumeric() || c == '_';
+    text.match_indices(word).any(|(i, _)| {
+        !text[..i].chars().next_back().is_some_and(is_ident)
+            && !text[i + word.len()..].chars().next().is_some_and(is_ident)
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    /// `(caller, target, handling)` for every classified call, and the fallible names.
+    fn analyze_source(
+        lang: &str,
+        file: &str,
+        source: &str,
+    ) -> (Vec<String>, Vec<(String, String, ErrorHandling)>) {
+        let result = get_extractor(lang).unwrap().extract(source, file).unwrap();
+        let name = |id: &str| {
+            result
+                .symbols
+                .iter()
+                .find(|s| s.id == id)
+                .map(|s| s.name.clone())
+                .unwrap()
+        };
+        let fallible = result.fallible.iter().map(|id| name(id)).collect();
+        let flows = result
+            .error_flows
+            .iter()
+            .map(|f| (name(&f.source_id), f.target_name.clone(), f.handling))
+            .collect();
+        (fallible, flows)
+    }
+
+    fn flow(
+        caller: &str,
+        target: &str,
+        handling: ErrorHandling,
+    ) -> (String, String, ErrorHandling) {
+        (caller.to_string(), target.to_string(), handling)
+    }
+
+    #[test]
+    fn test_go_propagate_wrap_replace_swallow() {
+        let source = r#"
+package repo
+
+func (p *Pool) GetConnection() (*Conn, error) {
+    return nil, ErrClosed
+}
+
+func Find(p *Pool) (*User, error) {
+    conn, err := p.GetConnection()
+    if err != nil {
+        return nil, err
+    }
+    return load(conn), nil
+}
+
+func Get(p *Pool) (*User, error) {
+    u, err := Find(p)
+    if err != nil {
+        return nil, fmt.Errorf("get user: %w", err)
+    }
+    return u, nil
+}
+
+func Legacy(p *Pool) error {
+    if _, err := Find(p); err != nil {
+        return fmt.Errorf("legacy: %v", err)
+    }
+    return nil
+}
+
+func Show(p *Pool) {
+    u, err := Get(p)
+    if err != nil {
+        log.Println(err)
+        return
+    }
+    render(u)
+}
+"#;
+        let (fallible, flows) = analyze_source("go", "repo.go", source);
+        assert_eq!(fallible, ["GetConnection", "Find", "Get", "Legacy"]);
+        for expected in [
+            flow("Find", "p.GetConnection", ErrorHandling::Propagates),
+            flow("Get", "Find", ErrorHandling::Wraps),
+            flow("Legacy", "Find", ErrorHandling::Replaces),
+            flow("Show", "Get", ErrorHandling::Swallows),
+        ] {
+            assert!(flows.contains(&expected), "{expected:?} not in {flows:#?}");
+        }
+    }
+
+    #[test]
+    fn test_rust_question_mark_and_adapters() {
+        let source = r#"
+fn open() -> Result<Conn, Error> {
+    connect()
+}
+
+fn load() -> anyhow::Result<User> {
+    let conn = open().context("opening")?;
+    read(&conn)
+}
+
+fn warm() {
+    let _ = load();
+    open().ok();
+}
+"#;
+        let (fallible, flows) = analyze_source("rust", "lib.rs", source);
+        assert_eq!(fallible, ["open", "load"]);
+        for expected in [
+            flow("open", "connect", ErrorHandling::Propagates),
+            flow("load", "open", ErrorHandling::Wraps),
+            flow("load", "read", ErrorHandling::Propagates),
+            flow("warm", "load", ErrorHandling::Swallows),
+            flow("warm", "open", ErrorHandling::Swallows),
+        ] {
+            assert!(flows.contains(&expected), "{expected:?} not in {flows:#?}");
+        }
+    }
+
+    #[test]
+    fn test_python_handlers_decide() {
+        let source = r#"
+def fetch(url):
+    raise TimeoutError(url)
+
+def direct():
+    return fetch("a")
+
+def reraise():
+    try:
+        fetch("b")
+    except TimeoutError:
+        raise
+
+def chained():
+    try:
+        fetch("c")
+    except TimeoutError as e:
+        raise ServiceError("down") from e
+
+def quiet():
+    try:
+        fetch("d")
+    except TimeoutError:
+        pass
+    finally:
+        fetch("e")
+"#;
+        let (fallible, flows) = analyze_source("python", "svc.py", source);
+        assert_eq!(fallible, ["fetch", "reraise", "chained"]);
+        for expected in [
+            flow("direct", "fetch", ErrorHandling::Propagates),
+            flow("reraise", "fetch", ErrorHandling::Propagates),
+            flow("chained", "fetch", ErrorHandling::Wraps),
+            flow("quiet", "fetch", ErrorHandling::Swallows),
+        ] {
+            assert!(flows.contains(&expected), "{expected:?} not in {flows:#?}");
+        }
+        // The `finally` call is not protected by the handler.
+        assert!(flows.contains(&flow("quiet", "fetch", ErrorHandling::Propagates)));
+    }
+
+    #[test]
+    fn test_javascript_catch_replaces_or_wraps() {
+        let source = r#"
+function save(user) {
+    try {
+        db.insert(user);
+    } catch (err) {
+        throw new SaveError("save failed", { cause: err });
+    }
+}
+
+function remove(id) {
+    try {
+        db.delete(id);
+    } catch (err) {
+        throw new Error("remove failed");
+    }
+}
+"#;
+        let (_, flows) = analyze_source("javascript", "repo.js", source);
+        assert!(flows.contains(&flow("save", "db.insert", ErrorHandling::Wraps)));
+        assert!(flows.contains(&flow("remove", "db.delete", ErrorHandling::Replaces)));
+    }
+
+    #[test]
+    fn test_mentions_whole_identifiers() {
+        assert!(mentions("err != nil", "err"));
+        assert!(!mentions("errs != nil", "err"));
+        assert!(!mentions("myerr", "err"));
+    }
+}
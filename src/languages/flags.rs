@@ -0,0 +1,274 @@
+//! Feature flag checks, read off the syntax tree during extraction.
+//!
+//! A check is a call to a flag SDK method (LaunchDarkly `BoolVariation`, Unleash
+//! `isEnabled`, OpenFeature `getBooleanValue`, Flipper `enabled?`...) whose first
+//! string argument names the flag. Each flag becomes a `flag` symbol in the file,
+//! at its first check there, and each check a reference edge from the enclosing
+//! symbol to the flag's name, so `refs` and `impact` work on flags too.
+
+use std::collections::HashSet;
+
+use tree_sitter::Node;
+
+use crate::types::{Edge, EdgeKind, Symbol, SymbolKind};
+
+use super::node_text;
+
+/// Flag SDK methods, matched on the callee's last segment.
+const FLAG_METHODS: &[&str] = &[
+    // LaunchDarkly
+    "variation",
+    "variation_detail",
+    "boolVariation",
+    "BoolVariation",
+    "StringVariation",
+    "IntVariation",
+    "Float64Variation",
+    "JSONVariation",
+    "bool_variation",
+    // Unleash, Flipper
+    "isEnabled",
+    "IsEnabled",
+    "is_enabled",
+    "is_enabled?",
+    "enabled?",
+    // OpenFeature
+    "getBooleanValue",
+    "get_boolean_value",
+    "BooleanValue",
+    "get_bool_value",
+    // Split
+    "getTreatment",
+    "get_treatment",
+    "GetTreatment",
+    // GrowthBook, Statsig, PostHog, Optimizely, Flagsmith, django-waffle
+    "isOn",
+    "IsOn",
+    "is_on",
+    "checkGate",
+    "check_gate",
+    "CheckGate",
+    "isFeatureEnabled",
+    "is_feature_enabled",
+    "IsFeatureEnabled",
+    "feature_enabled",
+    "has_feature",
+    "flag_is_active",
+    "useFlag",
+];
+
+/// Node kinds of one grammar.
+pub(crate) struct Rules {
+    /// Call node kinds, with the field holding the callee.
+    pub calls: &'static [(&'static str, &'static str)],
+    /// String literal kinds a flag name can be written as.
+    pub strings: &'static [&'static str],
+}
+
+pub(crate) const GO: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    strings: &["interpreted_string_literal", "raw_string_literal"],
+};
+
+pub(crate) const JAVASCRIPT: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    strings: &["string"],
+};
+
+pub(crate) const PYTHON: Rules = Rules {
+    calls: &[("call", "function")],
+    strings: &["string"],
+};
+
+pub(crate) const RUBY: Rules = Rules {
+    calls: &[("call", "method")],
+    strings: &["string", "simple_symbol"],
+};
+
+pub(crate) const RUST: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    strings: &["string_literal", "raw_string_literal"],
+};
+
+/// Flag symbols and check edges for the flag checks in the file, attributed to the
+/// innermost symbol among `symbols` around each. Checks outside any symbol are
+/// skipped.
+pub(crate) fn checks(
+    root: Node,
+    source: &str,
+    file_path: &str,
+    symbols: &[Symbol],
+    rules: &Rules,
+) -> (Vec<Symbol>, Vec<Edge>) {
+    let mut flags: HashSet<String> = HashSet::new();
+    let mut flag_symbols = Vec::new();
+    let mut edges = Vec::new();
+    visit(root, &mut |call| {
+        let Some(&(_, field)) = rules.calls.iter().find(|(kind, _)| *kind == call.kind()) else {
+            return;
+        };
+        let Some(callee) = call.child_by_field_name(field) else {
+            return;
+        };
+        let callee = node_text(callee, source);
+        let method = callee.rsplit(['.', ':']).next().unwrap_or(callee);
+        if !FLAG_METHODS.contains(&method) {
+            return;
+        }
+        let Some((literal, name)) = call.child_by_field_name("arguments").and_then(|args| {
+            let mut cursor = args.walk();
+            let found = args
+                .named_children(&mut cursor)
+                .filter(|a| rules.strings.contains(&a.kind()))
+                .find_map(|a| flag_name(node_text(a, source)).map(|name| (a, name)));
+            found
+        }) else {
+            return;
+        };
+        let Some(owner) = enclosing(symbols, call) else {
+            return;
+        };
+        let line = call.start_position().row as u32 + 1;
+        if flags.insert(name.to_string()) {
+            flag_symbols.push(Symbol::new(
+                name,
+                SymbolKind::Flag,
+                file_path,
+                literal.start_position().row as u32 + 1,
+                literal.end_position().row as u32 + 1,
+                literal.start_byte() as u32,
+                literal.end_byte() as u32,
+            ));
+        }
+        edges.push(Edge::new(
+            &owner.id,
+            name,
+            EdgeKind::References,
+            file_path,
+            line,
+        ));
+    });
+    (flag_symbols, edges)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// The flag a literal names: its contents, unless it is empty, interpolated or
+/// looks like prose.
+fn flag_name(literal: &str) -> Option<&str> {
+    let text = literal.strip_prefix(':').unwrap_or(literal);
+    let text = match text.find(['"', '\'', '`']) {
+        // Python `f"..."` / Rust `r"..."` prefixes.
+        Some(quote)
+            if text[..quote]
+                .chars()
+                .all(|c| c.is_ascii_alphabetic() || c == '#') =>
+        {
+            &text[quote..]
+        }
+        Some(_) => return None,
+        None => text,
+    };
+    let name = text.trim_matches(|c| matches!(c, '"' | '\'' | '`' | '#'));
+    let valid =
+        !name.is_empty() && !name.contains(char::is_whitespace) && !name.contains(['{', '$', '%']);
+    valid.then_some(name)
+}
+
+/// The innermost symbol, other than an import, whose range holds `node`.
+pub(crate) fn enclosing<'a>(symbols: &'a [Symbol], node: Node) -> Option<&'a Symbol> {
+    let (start, end) = (node.start_byte() as u32, node.end_byte() as u32);
+    symbols
+        .iter()
+        .filter(|s| s.kind != SymbolKind::Import && s.start_byte <= start && end <= s.end_byte)
+        .min_by_key(|s| s.end_byte - s.start_byte)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    fn flags(lang: &str, source: &str, file: &str) -> (Vec<String>, Vec<(String, u32)>) {
+        let result = get_extractor(lang).unwrap().extract(source, file).unwrap();
+        let flags = result
+            .symbols
+            .iter()
+            .filter(|s| s.kind == SymbolKind::Flag)
+            .map(|s| s.name.clone())
+            .collect();
+        let checks = result
+            .edges
+            .iter()
+            .filter(|e| {
+                result
+                    .symbols
+                    .iter()
+                    .any(|s| s.kind == SymbolKind::Flag && s.name == e.target_name)
+            })
+            .map(|e| (e.target_name.clone(), e.line))
+            .collect();
+        (flags, checks)
+    }
+
+    #[test]
+    fn test_flag_checks_across_sdks() {
+        let go = r#"package checkout
+
+func Pay(ctx context.Context, user ld.Context) {
+	if client.BoolVariation("new-checkout", user, false) {
+		payV2()
+	}
+	if unleash.IsEnabled("new-checkout") && unleash.IsEnabled(name) {
+		log.Println("on")
+	}
+}
+"#;
+        assert_eq!(
+            flags("go", go, "pay.go"),
+            (
+                vec!["new-checkout".to_string()],
+                vec![
+                    ("new-checkout".to_string(), 4),
+                    ("new-checkout".to_string(), 7)
+                ]
+            )
+        );
+
+        let python = r#"def render(request):
+    if flag_is_active(request, "dark_mode"):
+        return dark()
+    return posthog.feature_enabled(f"beta-{name}", user_id)
+"#;
+        assert_eq!(
+            flags("python", python, "views.py"),
+            (
+                vec!["dark_mode".to_string()],
+                vec![("dark_mode".to_string(), 2)]
+            )
+        );
+
+        let ruby = "class Cart\n  def total\n    return 0 if Flipper.enabled?(:free_shipping, user)\n  end\nend\n";
+        assert_eq!(
+            flags("ruby", ruby, "cart.rb"),
+            (
+                vec!["free_shipping".to_string()],
+                vec![("free_shipping".to_string(), 3)]
+            )
+        );
+
+        let ts = "export function Banner() {\n  return client.isEnabled('promo.banner') ? show() : null;\n}\n";
+        assert_eq!(
+            flags("typescript", ts, "banner.ts"),
+            (
+                vec!["promo.banner".to_string()],
+                vec![("promo.banner".to_string(), 2)]
+            )
+        );
+    }
+}
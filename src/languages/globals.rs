@@ -0,0 +1,315 @@
+//! Package-level variables in Go and the reads and writes of outside variables in
+//! each function, read off the syntax tree during extraction.
+//!
+//! A function's accesses are its identifiers minus what it declares itself
+//! (parameters, receivers, `:=`, `var`, range and type-switch variables), builtins,
+//! calls, struct literal keys and package qualifiers. `pkg.Name` is kept with its
+//! qualifier. Declarations are not scoped: a name declared anywhere in the function
+//! hides a global of that name throughout it.
+
+use std::collections::HashSet;
+
+use tree_sitter::Node;
+
+use crate::types::{Symbol, SymbolKind, VariableAccess};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+const BUILTINS: &[&str] = &[
+    "_", "nil", "true", "false", "iota", "append", "cap", "clear", "close", "complex", "copy",
+    "delete", "imag", "len", "make", "max", "min", "new", "panic", "print", "println", "real",
+    "recover",
+];
+
+/// Ids of the package-level `var`s among `symbols`, and every function's accesses to
+/// variables it does not declare.
+pub(crate) fn go_globals(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+) -> (Vec<String>, Vec<VariableAccess>) {
+    let globals = symbols
+        .iter()
+        .filter(|sym| sym.kind == SymbolKind::Variable && sym.parent_id.is_none())
+        .filter(|sym| {
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+                .is_some_and(is_package_var)
+        })
+        .map(|sym| sym.id.clone())
+        .collect();
+
+    let imports = imports(root, source);
+    let mut accesses = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        let locals = locals(function, source);
+        let mut push = |node: Node, name: &str, qualifier: Option<&str>| {
+            accesses.push(VariableAccess {
+                symbol_id: sym.id.clone(),
+                line: node.start_position().row as u32 + 1,
+                name: name.to_string(),
+                qualifier: qualifier.map(str::to_string),
+                write: is_write(node),
+            });
+        };
+        visit(function, &mut |node| {
+            let parent = node.parent();
+            match node.kind() {
+                "selector_expression" if !is_callee(node) => {
+                    let (Some(operand), Some(field)) = (
+                        node.child_by_field_name("operand"),
+                        node.child_by_field_name("field"),
+                    ) else {
+                        return;
+                    };
+                    let package = node_text(operand, source);
+                    if operand.kind() == "identifier"
+                        && imports.contains(package)
+                        && !locals.contains(package)
+                    {
+                        push(node, node_text(field, source), Some(package));
+                    }
+                }
+                "identifier" => {
+                    let name = node_text(node, source);
+                    if BUILTINS.contains(&name)
+                        || locals.contains(name)
+                        || imports.contains(name)
+                        || is_callee(node)
+                        || parent.is_some_and(|p| is_literal_key(node, p))
+                    {
+                        return;
+                    }
+                    push(node, name, None);
+                }
+                _ => {}
+            }
+        });
+    }
+    (globals, accesses)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// Whether the identifier `node` is declared by a top-level `var`.
+fn is_package_var(node: Node) -> bool {
+    let mut current = node.parent();
+    let mut in_var_spec = false;
+    while let Some(parent) = current {
+        match parent.kind() {
+            "var_spec" => in_var_spec = true,
+            "var_declaration" => {
+                return in_var_spec && parent.parent().is_some_and(|p| p.kind() == "source_file")
+            }
+            "var_spec_list" => {}
+            _ => return false,
+        }
+        current = parent.parent();
+    }
+    false
+}
+
+/// Names the file imports packages as: the alias, or the last path segment.
+pub(crate) fn imports<'s>(root: Node, source: &'s str) -> HashSet<&'s str> {
+    let mut names = HashSet::new();
+    visit(root, &mut |node| {
+        if node.kind() != "import_spec" {
+            return;
+        }
+        let alias = node
+            .child_by_field_name("name")
+            .map(|n| node_text(n, source));
+        let path = node.child_by_field_name("path").map(|p| {
+            let path = node_text(p, source).trim_matches(|c| c == '"' || c == '`');
+            path.rsplit('/').next().unwrap_or(path)
+        });
+        if let Some(name) = alias.or(path).filter(|n| !matches!(*n, "_" | ".")) {
+            names.insert(name);
+        }
+    });
+    names
+}
+
+/// Names `function` declares: parameters, receiver, named results, `:=`, `var`,
+/// `const`, range and type-switch variables, including those of closures.
+fn locals<'s>(function: Node, source: &'s str) -> HashSet<&'s str> {
+    let mut names = HashSet::new();
+    let mut identifiers = |node: Node| {
+        if node.kind() == "identifier" {
+            names.insert(node_text(node, source));
+        }
+        for child in node.named_children(&mut node.walk()) {
+            if child.kind() == "identifier" {
+                names.insert(node_text(child, source));
+            }
+        }
+    };
+    visit(function, &mut |node| match node.kind() {
+        "parameter_declaration" | "variadic_parameter_declaration" | "var_spec" | "const_spec" => {
+            let mut cursor = node.walk();
+            for name in node.children_by_field_name("name", &mut cursor) {
+                identifiers(name);
+            }
+        }
+        "short_var_declaration" => {
+            if let Some(left) = node.child_by_field_name("left") {
+                identifiers(left);
+            }
+        }
+        "range_clause" | "receive_statement" | "type_switch_statement" => {
+            let field = if node.kind() == "type_switch_statement" {
+                "alias"
+            } else {
+                "left"
+            };
+            // `for k, v = range m` assigns existing variables.
+            let declares = node.kind() == "type_switch_statement"
+                || node_text(node, source)
+                    .split_once(":=")
+                    .is_some_and(|(before, _)| !before.contains("range") && !before.contains("<-"));
+            if let Some(left) = node.child_by_field_name(field).filter(|_| declares) {
+                identifiers(left);
+            }
+        }
+        _ => {}
+    });
+    names
+}
+
+/// Whether `node` is the function of a call.
+pub(crate) fn is_callee(node: Node) -> bool {
+    node.parent().is_some_and(|p| {
+        p.kind() == "call_expression" && p.child_by_field_name("function") == Some(node)
+    })
+}
+
+/// Whether `node` is the key of a keyed element (`Config{Debug: true}`).
+fn is_literal_key(node: Node, parent: Node) -> bool {
+    let (element, key) = if parent.kind() == "literal_element" {
+        match parent.parent() {
+            Some(p) => (p, parent),
+            None => return false,
+        }
+    } else {
+        (parent, node)
+    };
+    element.kind() == "keyed_element" && element.named_child(0) == Some(key)
+}
+
+/// Whether the expression `node` is assigned to, incremented or has its address
+/// taken, directly or through a field or index.
+pub(crate) fn is_write(node: Node) -> bool {
+    let mut current = node;
+    while let Some(parent) = current.parent() {
+        match parent.kind() {
+            "selector_expression" | "index_expression"
+                if parent.child_by_field_name("operand") == Some(current) =>
+            {
+                current = parent;
+            }
+            "parenthesized_expression" => current = parent,
+            "expression_list" => {
+                return parent.parent().is_some_and(|p| {
+                    p.kind() == "assignment_statement"
+                        && p.child_by_field_name("left") == Some(parent)
+                });
+            }
+            "assignment_statement" => return parent.child_by_field_name("left") == Some(current),
+            "inc_statement" | "dec_statement" => return true,
+            "unary_expression" => {
+                return parent
+                    .child_by_field_name("operator")
+                    .is_some_and(|op| op.kind() == "&");
+            }
+            _ => return false,
+        }
+    }
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_package_vars_and_accesses() {
+        let source = r#"package server
+
+import (
+	"sync/atomic"
+	cfg "example.com/app/config"
+)
+
+var requests int64
+
+var (
+	cache   = map[string]string{}
+	verbose bool
+)
+
+const limit = 10
+
+func Handle(key string) string {
+	atomic.AddInt64(&requests, 1)
+	requests++
+	if cfg.Debug || verbose {
+		cfg.Debug = false
+	}
+	for k, v := range cache {
+		_ = k + v
+	}
+	cache[key] = "x"
+	n := limit
+	return format(Options{Key: key}, n)
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "server.go")
+            .unwrap();
+        let name = |id: &str| {
+            result
+                .symbols
+                .iter()
+                .find(|s| s.id == id)
+                .map(|s| s.name.as_str())
+                .unwrap()
+        };
+        let globals: Vec<_> = result.globals.iter().map(|id| name(id)).collect();
+        assert_eq!(globals, ["requests", "cache", "verbose"]);
+
+        let accesses: Vec<_> = result
+            .variable_accesses
+            .iter()
+            .map(|a| (a.line, a.name.as_str(), a.qualifier.as_deref(), a.write))
+            .collect();
+        assert_eq!(
+            accesses,
+            [
+                (18, "requests", None, true),
+                (19, "requests", None, true),
+                (20, "Debug", Some("cfg"), false),
+                (20, "verbose", None, false),
+                (21, "Debug", Some("cfg"), true),
+                (23, "cache", None, false),
+                (26, "cache", None, true),
+                (27, "limit", None, false),
+            ]
+        );
+    }
+}
@@ -3,7 +3,10 @@ use tree_sitter::{Language, Node, Parser};
 
 use crate::types::{symbol_id, Edge, EdgeKind, Symbol, SymbolKind, Visibility};
 
-use super::{node_text, ExtractionResult, Extractor};
+use super::{
+    assertions, complexity, concurrency, config_fields, constructs, consts, ctx, doubles, errors,
+    flags, globals, logs, node_text, panics, routes, serialize, todos, ExtractionResult, Extractor,
+};
 
 pub struct GoExtractor {
     parser: Parser,
@@ -44,7 +47,109 @@ impl Extractor for GoExtractor {
             &mut edges,
         );
 
-        Ok(ExtractionResult { symbols, edges })
+        let complexity = complexity::measure(tree.root_node(), source, &symbols, &complexity::GO);
+        let (fallible, error_flows) =
+            errors::analyze(tree.root_node(), source, &symbols, &edges, &errors::GO);
+        let panic_sites = panics::sites(tree.root_node(), source, &symbols, &panics::GO);
+        let sync_sites = concurrency::go_sites(tree.root_node(), source, &symbols);
+        let (takes_context, context_sites) = ctx::go_context(tree.root_node(), source, &symbols);
+        let (globals, variable_accesses) = globals::go_globals(tree.root_node(), source, &symbols);
+        let routes = routes::go_routes(tree.root_node(), source, &symbols);
+        let (config_fields, field_uses) =
+            config_fields::go_config_fields(tree.root_node(), source, &symbols);
+        // Registering a handler references it, like passing it anywhere else would.
+        for route in &routes {
+            if let Some(handler) = &route.handler {
+                edges.push(Edge::new(
+                    &route.symbol_id,
+                    handler,
+                    EdgeKind::References,
+                    file_path,
+                    route.line,
+                ));
+            }
+        }
+        // Serializing a value references its type, so `impact` on the type reaches it.
+        let (struct_fields, serializations) =
+            serialize::go_serialization(tree.root_node(), source, &symbols);
+        for s in &serializations {
+            edges.push(Edge::new(
+                &s.symbol_id,
+                &s.type_name,
+                EdgeKind::References,
+                file_path,
+                s.line,
+            ));
+        }
+        // Constants show their value wherever their signature does (outline, search).
+        let constants = consts::go_constants(tree.root_node(), source, &symbols);
+        for c in &constants {
+            if let Some(sym) = symbols.iter_mut().find(|s| s.id == c.symbol_id) {
+                let ty = c
+                    .type_name
+                    .as_deref()
+                    .map(|t| format!(" {t}"))
+                    .unwrap_or_default();
+                let value = c.value.as_deref().unwrap_or(&c.expr);
+                sym.signature = Some(format!("{ty} = {value}"));
+            }
+        }
+        // A downcast references the type it expects, like a composite literal does.
+        let type_assertions = assertions::go_assertions(tree.root_node(), source, &symbols);
+        for a in &type_assertions {
+            edges.push(Edge::new(
+                &a.symbol_id,
+                &a.type_name,
+                EdgeKind::References,
+                file_path,
+                a.line,
+            ));
+        }
+        // A generated mock implements the interface it fakes.
+        let test_doubles = doubles::go_doubles(source, &symbols);
+        for d in &test_doubles {
+            if let Some(sym) = symbols.iter().find(|s| s.id == d.symbol_id) {
+                edges.push(Edge::new(
+                    &d.symbol_id,
+                    &d.interface,
+                    EdgeKind::Inherits,
+                    file_path,
+                    sym.start_line,
+                ));
+            }
+        }
+        let constructions = constructs::go_constructions(tree.root_node(), source, &symbols);
+        let log_statements = logs::statements(tree.root_node(), source, &symbols, &logs::GO);
+        let todos = todos::comments(tree.root_node(), source, &symbols);
+        let (flag_symbols, flag_edges) =
+            flags::checks(tree.root_node(), source, file_path, &symbols, &flags::GO);
+        symbols.extend(flag_symbols);
+        edges.extend(flag_edges);
+        Ok(ExtractionResult {
+            symbols,
+            edges,
+            complexity,
+            fallible,
+            error_flows,
+            panic_sites,
+            sync_sites,
+            takes_context,
+            context_sites,
+            globals,
+            variable_accesses,
+            routes,
+            config_fields,
+            field_uses,
+            struct_fields,
+            serializations,
+            constants,
+            type_assertions,
+            test_doubles,
+            constructions,
+            syntax_errors: super::syntax_errors(tree.root_node(), source),
+            log_statements,
+            todos,
+        })
     }
 }
 
@@ -300,8 +405,11 @@ fn extract_interface_embeds(
                 }
             }
             // Recurse into method_spec_list or other container nodes
-            // but skip method_spec (those are method declarations, not embeds)
-            "method_spec" => {}
+            // but skip methods (method_spec, method_elem in newer grammars):
+            // their parameter and result types are not embeds. Unions and
+            // ~T terms are constraint type sets, not embeds either.
+            "method_spec" | "method_elem" | "negated_type" => {}
+            "type_elem" if child.named_child_count() > 1 => {}
             _ => {
                 extract_interface_embeds(child, source, file_path, parent_sym_id, line, edges);
             }
@@ -937,6 +1045,37 @@ type ReadWriter interface {
         assert!(targets.contains(&"Writer"));
     }
 
+    #[test]
+    fn test_interface_methods_and_constraints_are_not_embeds() {
+        let result = extract(
+            r#"package main
+
+type Reader interface {
+    Read(p []byte) (n int, err error)
+}
+
+type Number interface {
+    ~int | ~int64 | float64
+}
+
+type ReadCloser interface {
+    Reader
+    Close() error
+}
+"#,
+        );
+
+        let inherits: Vec<(&str, &str)> = result
+            .edges
+            .iter()
+            .filter(|e| e.kind == EdgeKind::Inherits)
+            .map(|e| (e.source_id.as_str(), e.target_name.as_str()))
+            .collect();
+        assert_eq!(inherits.len(), 1, "{inherits:?}");
+        assert!(inherits[0].0.contains("ReadCloser"));
+        assert_eq!(inherits[0].1, "Reader");
+    }
+
     #[test]
     fn test_imports() {
         let result = extract(
@@ -9,7 +9,7 @@ use tree_sitter::{Node, Parser};
 
 use crate::types::{symbol_id, Edge, EdgeKind, Symbol, SymbolKind, Visibility};
 
-use super::{node_text, ExtractionResult};
+use super::{complexity, errors, flags, logs, node_text, todos, ExtractionResult};
 
 /// Parse source and extract symbols + edges. Works for JS, TS, and TSX.
 pub fn extract(parser: &mut Parser, source: &str, file_path: &str) -> Result<ExtractionResult> {
@@ -29,7 +29,51 @@ pub fn extract(parser: &mut Parser, source: &str, file_path: &str) -> Result<Ext
         &mut edges,
     );
 
-    Ok(ExtractionResult { symbols, edges })
+    let complexity =
+        complexity::measure(tree.root_node(), source, &symbols, &complexity::JAVASCRIPT);
+    let (fallible, error_flows) = errors::analyze(
+        tree.root_node(),
+        source,
+        &symbols,
+        &edges,
+        &errors::JAVASCRIPT,
+    );
+    let log_statements = logs::statements(tree.root_node(), source, &symbols, &logs::JAVASCRIPT);
+    let todos = todos::comments(tree.root_node(), source, &symbols);
+    let (flag_symbols, flag_edges) = flags::checks(
+        tree.root_node(),
+        source,
+        file_path,
+        &symbols,
+        &flags::JAVASCRIPT,
+    );
+    symbols.extend(flag_symbols);
+    edges.extend(flag_edges);
+    Ok(ExtractionResult {
+        symbols,
+        edges,
+        complexity,
+        fallible,
+        error_flows,
+        panic_sites: Vec::new(),
+        sync_sites: Vec::new(),
+        takes_context: Vec::new(),
+        context_sites: Vec::new(),
+        globals: Vec::new(),
+        variable_accesses: Vec::new(),
+        routes: Vec::new(),
+        config_fields: Vec::new(),
+        field_uses: Vec::new(),
+        struct_fields: Vec::new(),
+        serializations: Vec::new(),
+        constants: Vec::new(),
+        type_assertions: Vec::new(),
+        test_doubles: Vec::new(),
+        constructions: Vec::new(),
+        syntax_errors: super::syntax_errors(tree.root_node(), source),
+        log_statements,
+        todos,
+    })
 }
 
 fn extract_node(
@@ -0,0 +1,378 @@
+//! Log statements, read off the syntax tree during extraction.
+//!
+//! A log statement is a call of a level method (`Info`, `warning`, `Errorf`,
+//! `Infow`, `Println`...) on something that looks like a logger: a receiver
+//! mentioning `log` (`log`, `s.logger`, `logging`, `Rails.logger`, `slog`), or
+//! `console`, `zap`, `tracing`. Rust's `info!`-style macros count bare too, and
+//! zerolog chains (`log.Error().Err(err).Msg("...")`) take their level from the
+//! chain. The message template is the first string literal argument; calls
+//! without one (`log.Println(err)`) are skipped.
+
+use tree_sitter::Node;
+
+use crate::types::{LogLevel, LogStatement, Symbol};
+
+use super::flags::enclosing;
+use super::node_text;
+
+/// Receiver segments that are loggers without mentioning `log`.
+const LOGGERS: &[&str] = &["console", "zap", "tracing", "sugar", "l", "lg"];
+
+/// Keys whose string value names the logger's component.
+const COMPONENT_KEYS: &[&str] = &[
+    "component",
+    "module",
+    "service",
+    "subsystem",
+    "logger",
+    "target",
+];
+
+/// Calls that name a logger after their first argument.
+const NAMING_CALLS: &[&str] = &["Named", "getLogger", "GetLogger"];
+
+/// Node kinds of one grammar.
+pub(crate) struct Rules {
+    /// Call node kinds, with the field holding the callee.
+    pub calls: &'static [(&'static str, &'static str)],
+    /// Field holding the receiver, for grammars that keep it out of the callee.
+    pub receiver: Option<&'static str>,
+    /// Callees that log without a receiver.
+    pub bare: &'static [&'static str],
+    /// String literal kinds a message can be written as.
+    pub strings: &'static [&'static str],
+}
+
+pub(crate) const GO: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    receiver: None,
+    bare: &[],
+    strings: &["interpreted_string_literal", "raw_string_literal"],
+};
+
+pub(crate) const JAVASCRIPT: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    receiver: None,
+    bare: &[],
+    strings: &["string", "template_string"],
+};
+
+pub(crate) const PYTHON: Rules = Rules {
+    calls: &[("call", "function")],
+    receiver: None,
+    bare: &[],
+    strings: &["string"],
+};
+
+pub(crate) const RUBY: Rules = Rules {
+    calls: &[("call", "method")],
+    receiver: Some("receiver"),
+    bare: &[],
+    strings: &["string"],
+};
+
+pub(crate) const RUST: Rules = Rules {
+    calls: &[("macro_invocation", "macro")],
+    receiver: None,
+    bare: &["trace", "debug", "info", "warn", "error"],
+    strings: &["string_literal", "raw_string_literal"],
+};
+
+/// Log statements in the file, attributed to the innermost symbol among `symbols`
+/// around each. Statements outside any symbol are skipped.
+pub(crate) fn statements(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+    rules: &Rules,
+) -> Vec<LogStatement> {
+    let mut statements = Vec::new();
+    visit(root, &mut |call| {
+        let Some(&(_, field)) = rules.calls.iter().find(|(kind, _)| *kind == call.kind()) else {
+            return;
+        };
+        let Some(callee) = call.child_by_field_name(field) else {
+            return;
+        };
+        let (receiver, method) = match rules.receiver.and_then(|f| call.child_by_field_name(f)) {
+            Some(receiver) => (node_text(receiver, source), node_text(callee, source)),
+            None => split_callee(node_text(callee, source)),
+        };
+        let level = if matches!(method, "Msg" | "Msgf") {
+            // zerolog: the level is a call earlier in the chain, after the logger.
+            receiver
+                .split('.')
+                .skip(1)
+                .find_map(|segment| level(call_name(segment)))
+        } else {
+            level(method)
+        };
+        let Some(level) = level else {
+            return;
+        };
+        let is_logger = if receiver.is_empty() {
+            rules.bare.contains(&method)
+        } else {
+            is_logger(receiver)
+        };
+        if !is_logger {
+            return;
+        }
+        let Some(args) = call.child_by_field_name("arguments").or_else(|| {
+            let mut cursor = call.walk();
+            let args = call
+                .named_children(&mut cursor)
+                .find(|c| c.kind() == "token_tree");
+            args
+        }) else {
+            return;
+        };
+        let Some(template) = template(args, source, rules) else {
+            return;
+        };
+        let Some(owner) = enclosing(symbols, call) else {
+            return;
+        };
+        let component = component(callee, source, rules)
+            .or_else(|| {
+                call.child_by_field_name("receiver")
+                    .and_then(|r| component(r, source, rules))
+            })
+            .or_else(|| component(args, source, rules));
+        statements.push(LogStatement {
+            symbol_id: owner.id.clone(),
+            line: call.start_position().row as u32 + 1,
+            level,
+            component,
+            template: template.to_string(),
+        });
+    });
+    statements
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// `("s.logger", "Info")` for `s.logger.Info`, `("tracing", "warn")` for
+/// `tracing::warn`; an empty receiver for a bare name.
+fn split_callee(callee: &str) -> (&str, &str) {
+    match callee.rfind(['.', ':']) {
+        Some(cut) => (
+            callee[..cut].trim_end_matches([':', '?']),
+            &callee[cut + 1..],
+        ),
+        None => ("", callee),
+    }
+}
+
+/// `Error` for the chain segment `Error()`.
+fn call_name(segment: &str) -> &str {
+    segment.split('(').next().unwrap_or(segment)
+}
+
+/// The level a logging method logs at, ignoring `f`/`w`/`ln` format suffixes and
+/// slog's `Context` variants.
+fn level(method: &str) -> Option<LogLevel> {
+    let method = method.strip_suffix("Context").unwrap_or(method);
+    let lower = method.to_ascii_lowercase();
+    let exact = |name: &str| match name {
+        "trace" => Some(LogLevel::Trace),
+        "debug" => Some(LogLevel::Debug),
+        "info" | "print" | "log" | "notice" => Some(LogLevel::Info),
+        "warn" | "warning" => Some(LogLevel::Warn),
+        "error" | "exception" => Some(LogLevel::Error),
+        "fatal" | "critical" => Some(LogLevel::Fatal),
+        "panic" | "dpanic" => Some(LogLevel::Panic),
+        _ => None,
+    };
+    exact(lower.as_str()).or_else(|| {
+        ["f", "w", "ln"]
+            .iter()
+            .find_map(|suffix| lower.strip_suffix(suffix).and_then(exact))
+    })
+}
+
+/// Whether `receiver` looks like a logger: it mentions `log`, or one of its
+/// segments is a well-known logger.
+fn is_logger(receiver: &str) -> bool {
+    receiver.to_ascii_lowercase().contains("log")
+        || receiver
+            .split(['.', ':'])
+            .any(|segment| LOGGERS.contains(&call_name(segment)))
+}
+
+/// The first string literal among `args`, unquoted, other than the value of a
+/// component key (Rust's `target: "..."`).
+fn template<'s>(args: Node, source: &'s str, rules: &Rules) -> Option<&'s str> {
+    let mut previous: Option<Node> = None;
+    for arg in args.named_children(&mut args.walk()) {
+        let keyed =
+            previous.is_some_and(|p| COMPONENT_KEYS.contains(&unquote(node_text(p, source))));
+        if rules.strings.contains(&arg.kind()) && !keyed {
+            let text = unquote(node_text(arg, source));
+            return (!text.is_empty()).then_some(text);
+        }
+        previous = Some(arg);
+    }
+    None
+}
+
+/// The component named under `node`: the argument of a naming call (`Named("x")`),
+/// or a string following a component key, as call arguments (`"component", "x"`),
+/// a map or object entry (`{"component": "x"}`) or Rust's `target: "x"`.
+fn component(node: Node, source: &str, rules: &Rules) -> Option<String> {
+    let mut found = None;
+    let mut check = |node: Node| {
+        if found.is_some() {
+            return;
+        }
+        let children: Vec<Node> = node.named_children(&mut node.walk()).collect();
+        let naming = rules.calls.iter().any(|(kind, field)| {
+            *kind == node.kind()
+                && node.child_by_field_name(field).is_some_and(|callee| {
+                    NAMING_CALLS.contains(&split_callee(node_text(callee, source)).1)
+                })
+        });
+        if naming {
+            let args = node.child_by_field_name("arguments");
+            found = args.and_then(|args| {
+                let mut cursor = args.walk();
+                let first = args.named_children(&mut cursor).next();
+                first
+                    .filter(|a| rules.strings.contains(&a.kind()))
+                    .map(|a| unquote(node_text(a, source)).to_string())
+            });
+            return;
+        }
+        found = children.windows(2).find_map(|pair| {
+            let key = unquote(node_text(pair[0], source));
+            (COMPONENT_KEYS.contains(&key) && rules.strings.contains(&pair[1].kind()))
+                .then(|| unquote(node_text(pair[1], source)).to_string())
+        });
+    };
+    check(node);
+    visit(node, &mut check);
+    found.filter(|c| !c.is_empty())
+}
+
+/// The contents of a string literal, without quotes or prefixes (`f"..."`,
+/// `r#"..."#`, `:sym`).
+fn unquote(literal: &str) -> &str {
+    let text = literal.trim_start_matches(':');
+    match text.find(['"', '\'', '`']) {
+        Some(quote)
+            if text[..quote]
+                .chars()
+                .all(|c| c.is_ascii_alphabetic() || c == '#') =>
+        {
+            let q = text.as_bytes()[quote] as char;
+            let body = &text[quote..];
+            let body = if text[..quote].contains('#') {
+                body.trim_end_matches('#')
+            } else {
+                body
+            };
+            body.trim_matches(q)
+        }
+        _ => text,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    fn logs(lang: &str, source: &str, file: &str) -> Vec<(u32, LogLevel, Option<String>, String)> {
+        get_extractor(lang)
+            .unwrap()
+            .extract(source, file)
+            .unwrap()
+            .log_statements
+            .into_iter()
+            .map(|s| (s.line, s.level, s.component, s.template))
+            .collect()
+    }
+
+    #[test]
+    fn test_go_log_statements() {
+        let source = r#"package billing
+
+func Charge(ctx context.Context, id string) error {
+	log.Printf("charging %s", id)
+	logger := zap.L().Named("billing")
+	s.logger.Warnw("rate limit hit", "component", "payments", "id", id)
+	slog.ErrorContext(ctx, "charge failed", "err", err)
+	log.Error().Err(err).Msg("card declined")
+	fmt.Printf("not a log %s", id)
+	log.Println(err)
+	zap.L().Named("billing").Info("done")
+	return fmt.Errorf("wrap: %w", err)
+}
+"#;
+        let some = |s: &str| Some(s.to_string());
+        assert_eq!(
+            logs("go", source, "billing/charge.go"),
+            [
+                (4, LogLevel::Info, None, "charging %s".to_string()),
+                (
+                    6,
+                    LogLevel::Warn,
+                    some("payments"),
+                    "rate limit hit".to_string()
+                ),
+                (7, LogLevel::Error, None, "charge failed".to_string()),
+                (8, LogLevel::Error, None, "card declined".to_string()),
+                (11, LogLevel::Info, some("billing"), "done".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_log_statements_across_languages() {
+        let python = "def pay(order):\n    logger.warning(\"retry %d for %s\", n, order.id)\n    print(\"x\")\n";
+        assert_eq!(
+            logs("python", python, "pay.py"),
+            [(2, LogLevel::Warn, None, "retry %d for %s".to_string())]
+        );
+
+        let ruby =
+            "class Cart\n  def total\n    Rails.logger.info \"total for #{id}\"\n  end\nend\n";
+        assert_eq!(
+            logs("ruby", ruby, "cart.rb"),
+            [(3, LogLevel::Info, None, "total for #{id}".to_string())]
+        );
+
+        let ts = "export function send() {\n  console.error(`send failed: ${err}`);\n  this.log.debug({ component: 'mailer' }, 'sent');\n}\n";
+        assert_eq!(
+            logs("typescript", ts, "send.ts"),
+            [
+                (2, LogLevel::Error, None, "send failed: ${err}".to_string()),
+                (
+                    3,
+                    LogLevel::Debug,
+                    Some("mailer".to_string()),
+                    "sent".to_string()
+                ),
+            ]
+        );
+
+        let rust = "fn sync() {\n    tracing::info!(target: \"sync\", \"synced {} rows\", n);\n    warn!(\"slow\");\n    panic!(\"no\");\n}\n";
+        assert_eq!(
+            logs("rust", rust, "sync.rs"),
+            [
+                (
+                    2,
+                    LogLevel::Info,
+                    Some("sync".to_string()),
+                    "synced {} rows".to_string()
+                ),
+                (3, LogLevel::Warn, None, "slow".to_string()),
+            ]
+        );
+    }
+}
@@ -1,12 +1,32 @@
+pub(crate) mod assertions;
+pub(crate) mod complexity;
+pub(crate) mod concurrency;
+pub(crate) mod config_fields;
+pub(crate) mod constructs;
+pub(crate) mod consts;
+pub(crate) mod ctx;
+pub(crate) mod doubles;
+pub(crate) mod errors;
+pub(crate) mod flags;
+pub(crate) mod globals;
 pub mod go;
 pub mod javascript;
 mod js_shared;
+pub(crate) mod logs;
+pub(crate) mod panics;
 pub mod python;
+pub(crate) mod routes;
 pub mod ruby;
 pub mod rust_lang;
+pub(crate) mod serialize;
+pub(crate) mod todos;
 pub mod typescript;
 
-use crate::types::{Edge, Symbol};
+use crate::types::{
+    Complexity, ConfigField, Constant, Construction, ContextSite, Edge, ErrorFlow, FieldUse,
+    LogStatement, PanicSite, Route, Serialization, StructField, Symbol, SyncSite, SyntaxError,
+    TestDouble, Todo, TypeAssertion, VariableAccess,
+};
 use anyhow::Result;
 use tree_sitter::Node;
 
@@ -15,6 +35,48 @@ use tree_sitter::Node;
 pub struct ExtractionResult {
     pub symbols: Vec<Symbol>,
     pub edges: Vec<Edge>,
+    /// `(symbol_id, complexity)` for functions and methods.
+    pub complexity: Vec<(String, Complexity)>,
+    /// Ids of functions and methods that return or raise errors of their own.
+    pub fallible: Vec<String>,
+    /// How each function handles errors from the calls it makes.
+    pub error_flows: Vec<ErrorFlow>,
+    /// Panic and recover sites in functions and methods (Go and Rust).
+    pub panic_sites: Vec<PanicSite>,
+    /// Channel, mutex and wait group uses in functions and methods (Go).
+    pub sync_sites: Vec<SyncSite>,
+    /// Ids of functions and methods that take a `context.Context` (Go).
+    pub takes_context: Vec<String>,
+    /// Calls that run without the caller's context (Go).
+    pub context_sites: Vec<ContextSite>,
+    /// Ids of package-level variables (Go).
+    pub globals: Vec<String>,
+    /// Reads and writes of variables a function does not declare (Go).
+    pub variable_accesses: Vec<VariableAccess>,
+    /// HTTP route registrations (Go).
+    pub routes: Vec<Route>,
+    /// Fields of configuration structs (Go).
+    pub config_fields: Vec<ConfigField>,
+    /// Struct field accesses (Go).
+    pub field_uses: Vec<FieldUse>,
+    /// Struct fields with their tags (Go).
+    pub struct_fields: Vec<StructField>,
+    /// Calls that encode or decode a struct: JSON, YAML, database rows... (Go).
+    pub serializations: Vec<Serialization>,
+    /// Constants with their type and evaluated value (Go).
+    pub constants: Vec<Constant>,
+    /// Type assertions and type switch cases (Go).
+    pub type_assertions: Vec<TypeAssertion>,
+    /// Generated mocks and fakes with the interface each one fakes (Go).
+    pub test_doubles: Vec<TestDouble>,
+    /// Composite literals and `new` calls with the type they create (Go).
+    pub constructions: Vec<Construction>,
+    /// Syntax errors the parser recovered from; empty for a clean file.
+    pub syntax_errors: Vec<SyntaxError>,
+    /// Logger calls with their level and message template.
+    pub log_statements: Vec<LogStatement>,
+    /// TODO, FIXME, HACK and XXX comments.
+    pub todos: Vec<Todo>,
 }
 
 /// Trait implemented by each language extractor.
@@ -31,6 +93,53 @@ pub(crate) fn node_text<'a>(node: Node, source: &'a str) -> &'a str {
     source.get(node.start_byte()..node.end_byte()).unwrap_or("")
 }
 
+/// Most syntax errors kept per file: past a few, the file is broken either way.
+const MAX_SYNTAX_ERRORS: usize = 20;
+
+/// The syntax errors tree-sitter recovered from while parsing, in source order:
+/// text it skipped (`ERROR` nodes) and tokens it assumed (`MISSING` nodes).
+pub(crate) fn syntax_errors(root: Node, source: &str) -> Vec<SyntaxError> {
+    fn visit(node: Node, source: &str, errors: &mut Vec<SyntaxError>) {
+        for child in node.children(&mut node.walk()) {
+            if errors.len() == MAX_SYNTAX_ERRORS {
+                return;
+            }
+            let line = child.start_position().row as u32 + 1;
+            if child.is_error() {
+                let text = node_text(child, source).lines().next().unwrap_or("").trim();
+                let text: String = text.chars().take(40).collect();
+                errors.push(SyntaxError {
+                    line,
+                    message: format!("unexpected `{text}`"),
+                });
+            } else if child.is_missing() {
+                errors.push(SyntaxError {
+                    line,
+                    message: format!("missing `{}`", child.kind()),
+                });
+            } else if child.has_error() {
+                visit(child, source, errors);
+            }
+        }
+    }
+    let mut errors = Vec::new();
+    if root.has_error() {
+        visit(root, source, &mut errors);
+    }
+    errors
+}
+
+/// Languages with a built-in extractor.
+pub const LANGUAGES: &[&str] = &[
+    "python",
+    "typescript",
+    "tsx",
+    "javascript",
+    "rust",
+    "go",
+    "ruby",
+];
+
 /// Map file extension to language name.
 pub fn detect_language(path: &std::path::Path) -> Option<&'static str> {
     let ext = path.extension()?.to_str()?;
@@ -92,5 +201,26 @@ mod tests {
         assert!(get_extractor("ruby").is_some());
         assert!(get_extractor("java").is_none());
         assert!(get_extractor("unknown").is_none());
+        assert!(LANGUAGES.iter().all(|lang| get_extractor(lang).is_some()));
+    }
+
+    #[test]
+    fn test_syntax_errors_keep_what_parses() {
+        let broken =
+            "package api\n\nfunc Before() int {\n\treturn 1\n}\n\nfunc Broken( {\n\tx :=\n}\n";
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(broken, "api/broken.go")
+            .unwrap();
+        assert!(result.symbols.iter().any(|s| s.name == "Before"));
+        assert!(!result.syntax_errors.is_empty());
+        assert!(result.syntax_errors.iter().all(|e| e.line >= 7));
+
+        let clean = "package api\n\nfunc Before() int {\n\treturn 1\n}\n";
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(clean, "api/clean.go")
+            .unwrap();
+        assert!(result.syntax_errors.is_empty());
     }
 }
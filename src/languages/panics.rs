@@ -0,0 +1,228 @@
+//! Panic and recover sites, read off the syntax tree during extraction.
+//!
+//! - **Go**: `panic(...)` and `log.Panic*` panic; a `recover()` inside a deferred
+//!   function recovers.
+//! - **Rust**: `panic!`, `unreachable!`, `todo!`, `unimplemented!`, `.unwrap()` and
+//!   `.expect()` panic; `catch_unwind` recovers.
+//!
+//! Sites in closures count toward the enclosing function. Exception-based languages
+//! have no separate panic channel: their raises are covered by `errors`.
+
+use tree_sitter::Node;
+
+use crate::types::{PanicKind, PanicSite, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+/// Node kinds and names of one grammar.
+pub(crate) struct Rules {
+    /// Call node kinds, with the field holding the callee.
+    pub calls: &'static [(&'static str, &'static str)],
+    /// The call kind that invokes a macro, reported with a trailing `!`.
+    pub macros: Option<&'static str>,
+    /// Callees that panic, matched on whole `.`/`::` segments from the end.
+    pub panics: &'static [&'static str],
+    pub recovers: &'static [&'static str],
+    /// A recover only counts under this ancestor (Go's `defer`).
+    pub recover_within: Option<&'static str>,
+    pub functions: &'static [&'static str],
+    /// Nested declarations that are symbols of their own.
+    pub items: &'static [&'static str],
+}
+
+pub(crate) const GO: Rules = Rules {
+    calls: &[("call_expression", "function")],
+    macros: None,
+    panics: &["panic", "log.Panic", "log.Panicf", "log.Panicln"],
+    recovers: &["recover"],
+    recover_within: Some("defer_statement"),
+    functions: complexity::GO.functions,
+    items: &[],
+};
+
+pub(crate) const RUST: Rules = Rules {
+    calls: &[
+        ("call_expression", "function"),
+        ("macro_invocation", "macro"),
+    ],
+    macros: Some("macro_invocation"),
+    panics: &[
+        "panic",
+        "unreachable",
+        "todo",
+        "unimplemented",
+        "unwrap",
+        "expect",
+    ],
+    recovers: &["catch_unwind"],
+    recover_within: None,
+    functions: complexity::RUST.functions,
+    items: &["function_item"],
+};
+
+/// Panic and recover sites inside the functions and methods among `symbols`.
+pub(crate) fn sites(root: Node, source: &str, symbols: &[Symbol], rules: &Rules) -> Vec<PanicSite> {
+    let mut sites = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, rules.functions).unwrap_or(node);
+        visit_body(function, rules, &mut |call| {
+            let Some(&(_, field)) = rules.calls.iter().find(|(kind, _)| *kind == call.kind())
+            else {
+                return;
+            };
+            let callee = call
+                .child_by_field_name(field)
+                .map_or("", |f| node_text(f, source));
+            let (kind, name) = if let Some(name) = matching(callee, rules.panics) {
+                (PanicKind::Panic, name)
+            } else if let Some(name) = matching(callee, rules.recovers) {
+                if rules
+                    .recover_within
+                    .is_some_and(|within| !has_ancestor(call, function, within))
+                {
+                    return;
+                }
+                (PanicKind::Recover, name)
+            } else {
+                return;
+            };
+            let what = if rules.macros == Some(call.kind()) {
+                format!("{name}!")
+            } else {
+                name.to_string()
+            };
+            sites.push(PanicSite {
+                symbol_id: sym.id.clone(),
+                line: call.start_position().row as u32 + 1,
+                kind,
+                what,
+            });
+        });
+    }
+    sites
+}
+
+/// Visit the descendants of `function`, skipping nested items.
+fn visit_body<'t>(function: Node<'t>, rules: &Rules, f: &mut impl FnMut(Node<'t>)) {
+    for child in function.named_children(&mut function.walk()) {
+        if !rules.items.contains(&child.kind()) {
+            f(child);
+            visit_body(child, rules, f);
+        }
+    }
+}
+
+/// The entry of `names` that `callee` ends with, on a segment boundary.
+fn matching(callee: &str, names: &[&'static str]) -> Option<&'static str> {
+    names.iter().copied().find(|name| {
+        callee
+            .strip_suffix(name)
+            .is_some_and(|rest| rest.is_empty() || rest.ends_with('.') || rest.ends_with("::"))
+    })
+}
+
+/// Whether a node of `kind` lies between `node` and `function`.
+fn has_ancestor(node: Node, function: Node, kind: &str) -> bool {
+    let mut current = node.parent();
+    while let Some(parent) = current {
+        if parent == function {
+            return false;
+        }
+        if parent.kind() == kind {
+            return true;
+        }
+        current = parent.parent();
+    }
+    false
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    /// `(line, kind, what)` for every site found.
+    fn found(lang: &str, file: &str, source: &str) -> Vec<(u32, PanicKind, String)> {
+        let result = get_extractor(lang).unwrap().extract(source, file).unwrap();
+        result
+            .panic_sites
+            .into_iter()
+            .map(|s| (s.line, s.kind, s.what))
+            .collect()
+    }
+
+    #[test]
+    fn test_go_panic_and_deferred_recover() {
+        let source = r#"package main
+
+func mustOpen(path string) *File {
+	f, err := open(path)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func Serve() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("recovered: %v", r)
+		}
+	}()
+	mustOpen("x")
+}
+
+func notDeferred() {
+	recover()
+	log.Panicf("bad %d", 1)
+}
+"#;
+        assert_eq!(
+            found("go", "main.go", source),
+            [
+                (6, PanicKind::Panic, "panic".to_string()),
+                (13, PanicKind::Recover, "recover".to_string()),
+                (22, PanicKind::Panic, "log.Panicf".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_rust_panics_and_catch_unwind() {
+        let source = r#"
+fn load(path: &str) -> Config {
+    let text = std::fs::read_to_string(path).unwrap();
+    let fallback = text.parse().unwrap_or_default();
+    if text.is_empty() {
+        unreachable!("empty");
+    }
+    todo!()
+}
+
+fn guarded() {
+    let _ = std::panic::catch_unwind(|| load("x"));
+    let v: Option<u32> = None;
+    v.expect("set");
+}
+"#;
+        assert_eq!(
+            found("rust", "lib.rs", source),
+            [
+                (3, PanicKind::Panic, "unwrap".to_string()),
+                (6, PanicKind::Panic, "unreachable!".to_string()),
+                (8, PanicKind::Panic, "todo!".to_string()),
+                (12, PanicKind::Recover, "catch_unwind".to_string()),
+                (14, PanicKind::Panic, "expect".to_string()),
+            ]
+        );
+    }
+}
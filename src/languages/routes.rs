@@ -0,0 +1,448 @@
+//! HTTP route registrations in Go, read off the syntax tree during extraction.
+//!
+//! Recognizes `net/http` (`HandleFunc`, `Handle`, Go 1.22 `"GET /path"` patterns),
+//! gin and echo (`GET`, `POST`, ..., `Any`, `Group`), chi (`Get`, `Post`, ...,
+//! `Method`, `Route`, `Group`, `With`) and gorilla/mux (`HandleFunc(...).Methods(...)`,
+//! `PathPrefix(...).Subrouter()`). Prefixes and middleware are followed through
+//! router variables within a function: `v1 := r.Group("/v1")`, `r.Use(mw)`. A path
+//! must be a string literal starting with `/`, which keeps `cache.Get(key)` out.
+//!
+//! Handlers wrapped in calls (`logging(auth(h))`) count the wrappers as middleware;
+//! adapters (`http.HandlerFunc`, `gin.WrapF`) are looked through.
+
+use std::collections::HashMap;
+
+use tree_sitter::Node;
+
+use crate::types::{Route, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+/// gin and echo verb methods; `Any` registers every method.
+const UPPER_VERBS: &[&str] = &[
+    "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS", "CONNECT", "TRACE", "Any",
+];
+
+/// chi verb methods.
+const CHI_VERBS: &[&str] = &[
+    "Get", "Post", "Put", "Patch", "Delete", "Head", "Options", "Connect", "Trace",
+];
+
+/// Calls that turn a function into a handler without adding behaviour.
+const ADAPTERS: &[&str] = &["HandlerFunc", "WrapF", "WrapH", "WrapHandler"];
+
+/// Prefix and middleware a router variable carries.
+#[derive(Debug, Clone, Default)]
+struct Scope {
+    prefix: String,
+    middleware: Vec<String>,
+}
+
+/// Routes registered inside the Go functions and methods among `symbols`.
+pub(crate) fn go_routes(root: Node, source: &str, symbols: &[Symbol]) -> Vec<Route> {
+    // echo takes the handler before the route's middleware, gin after.
+    let echo = source.contains("\"github.com/labstack/echo");
+    let mut routes = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        let mut walker = Walker {
+            source,
+            echo,
+            symbol_id: &sym.id,
+            routes: &mut routes,
+        };
+        walker.walk(function, &mut HashMap::new());
+    }
+    routes
+}
+
+struct Walker<'a, 's> {
+    source: &'s str,
+    echo: bool,
+    symbol_id: &'a str,
+    routes: &'a mut Vec<Route>,
+}
+
+impl<'s> Walker<'_, 's> {
+    fn walk(&mut self, node: Node, scopes: &mut HashMap<&'s str, Scope>) {
+        for child in node.named_children(&mut node.walk()) {
+            match child.kind() {
+                "short_var_declaration" | "assignment_statement" => {
+                    let (Some(left), Some(right)) = (
+                        child.child_by_field_name("left"),
+                        child.child_by_field_name("right"),
+                    ) else {
+                        continue;
+                    };
+                    let left = single(left);
+                    if let Some(scope) = self.derived_scope(single(right), scopes) {
+                        scopes.insert(node_text(left, self.source), scope);
+                    }
+                }
+                "call_expression" if self.call(child, scopes) => continue,
+                _ => {}
+            }
+            self.walk(child, scopes);
+        }
+    }
+
+    /// Handle a call: a route, a `Use`, or a `Route`/`Group` closure. True when the
+    /// closure has been walked already.
+    fn call(&mut self, call: Node, scopes: &mut HashMap<&'s str, Scope>) -> bool {
+        let Some((receiver, method, args)) = method_call(call, self.source) else {
+            return false;
+        };
+        match method {
+            "Use" => {
+                let middleware = args.iter().map(|a| node_text(*a, self.source).to_string());
+                scopes
+                    .entry(node_text(receiver, self.source))
+                    .or_default()
+                    .middleware
+                    .extend(middleware);
+            }
+            "Route" | "Group" if args.last().is_some_and(|a| a.kind() == "func_literal") => {
+                let closure = args[args.len() - 1];
+                let mut scope = self.scope_of(receiver, scopes);
+                if let Some(prefix) = args.first().and_then(|a| string(*a, self.source)) {
+                    scope.prefix = join(&scope.prefix, prefix);
+                }
+                let mut inner = scopes.clone();
+                let param = closure
+                    .child_by_field_name("parameters")
+                    .and_then(|p| p.named_child(0))
+                    .and_then(|p| p.child_by_field_name("name"));
+                if let Some(param) = param {
+                    inner.insert(node_text(param, self.source), scope);
+                }
+                self.walk(closure, &mut inner);
+                return true;
+            }
+            _ => {
+                if let Some(route) = self.route(call, receiver, method, &args, scopes) {
+                    self.routes.push(route);
+                }
+            }
+        }
+        false
+    }
+
+    fn route(
+        &self,
+        call: Node,
+        receiver: Node,
+        method: &str,
+        args: &[Node],
+        scopes: &HashMap<&'s str, Scope>,
+    ) -> Option<Route> {
+        let text = |i: usize| args.get(i).and_then(|a| string(*a, self.source));
+        let (http_method, path, handler, route_middleware) = match method {
+            // gin's `Handle("GET", "/path", handlers...)`
+            "Handle" if args.len() >= 3 && text(1).is_some() => {
+                let last = args.len() - 1;
+                (text(0)?.to_string(), text(1)?, args[last], &args[2..last])
+            }
+            "Handle" | "HandleFunc" if args.len() == 2 => {
+                let pattern = text(0)?;
+                let (verb, path) = match pattern.split_once(' ') {
+                    Some((verb, path)) if is_verb(verb) => (Some(verb), path.trim_start()),
+                    _ => (None, pattern),
+                };
+                let verb = verb
+                    .map(str::to_string)
+                    .or_else(|| gorilla_methods(call, self.source))
+                    .unwrap_or_else(|| "ANY".to_string());
+                (verb, path, args[1], &args[2..])
+            }
+            "Method" | "MethodFunc" if args.len() == 3 => {
+                (text(0)?.to_uppercase(), text(1)?, args[2], &args[3..])
+            }
+            _ if UPPER_VERBS.contains(&method) && args.len() >= 2 => {
+                let verb = method.to_uppercase();
+                if self.echo {
+                    (verb, text(0)?, args[1], &args[2..])
+                } else {
+                    let last = args.len() - 1;
+                    (verb, text(0)?, args[last], &args[1..last])
+                }
+            }
+            _ if CHI_VERBS.contains(&method) && args.len() == 2 => {
+                (method.to_uppercase(), text(0)?, args[1], &args[2..])
+            }
+            _ => return None,
+        };
+        if !path.starts_with('/') {
+            return None;
+        }
+        let scope = self.scope_of(receiver, scopes);
+        let mut middleware = scope.middleware;
+        middleware.extend(
+            route_middleware
+                .iter()
+                .map(|a| node_text(*a, self.source).to_string()),
+        );
+        let handler = unwrap_handler(handler, self.source, &mut middleware);
+        Some(Route {
+            symbol_id: self.symbol_id.to_string(),
+            line: call.start_position().row as u32 + 1,
+            method: http_method,
+            path: join(&scope.prefix, path),
+            handler,
+            middleware,
+        })
+    }
+
+    /// Scope of a router expression: a derived router, or a variable seen earlier.
+    fn scope_of(&self, expr: Node, scopes: &HashMap<&'s str, Scope>) -> Scope {
+        self.derived_scope(expr, scopes)
+            .or_else(|| scopes.get(node_text(expr, self.source)).cloned())
+            .unwrap_or_default()
+    }
+
+    /// Scope of a call that derives a router: `Group`, `With`, `PathPrefix` and
+    /// `Subrouter`.
+    fn derived_scope(&self, expr: Node, scopes: &HashMap<&'s str, Scope>) -> Option<Scope> {
+        let (receiver, method, args) = method_call(expr, self.source)?;
+        let mut scope = match method {
+            "Group" | "With" | "PathPrefix" | "Subrouter" => self.scope_of(receiver, scopes),
+            _ => return None,
+        };
+        let mut middleware = args.as_slice();
+        if matches!(method, "Group" | "PathPrefix") {
+            scope.prefix = join(&scope.prefix, string(*args.first()?, self.source)?);
+            middleware = &args[1..];
+        }
+        scope.middleware.extend(
+            middleware
+                .iter()
+                .map(|a| node_text(*a, self.source).to_string()),
+        );
+        Some(scope)
+    }
+}
+
+/// `receiver.method(args...)`, with comments left out of the arguments.
+fn method_call<'t, 's>(
+    node: Node<'t>,
+    source: &'s str,
+) -> Option<(Node<'t>, &'s str, Vec<Node<'t>>)> {
+    if node.kind() != "call_expression" {
+        return None;
+    }
+    let function = node.child_by_field_name("function")?;
+    if function.kind() != "selector_expression" {
+        return None;
+    }
+    let receiver = function.child_by_field_name("operand")?;
+    let method = node_text(function.child_by_field_name("field")?, source);
+    let arguments = node.child_by_field_name("arguments")?;
+    let args = arguments
+        .named_children(&mut arguments.walk())
+        .filter(|a| a.kind() != "comment")
+        .collect();
+    Some((receiver, method, args))
+}
+
+/// The only element of an expression list, or the expression itself.
+fn single(node: Node) -> Node {
+    if node.kind() == "expression_list" && node.named_child_count() == 1 {
+        node.named_child(0).unwrap_or(node)
+    } else {
+        node
+    }
+}
+
+/// Contents of a string literal.
+fn string<'s>(node: Node, source: &'s str) -> Option<&'s str> {
+    matches!(
+        node.kind(),
+        "interpreted_string_literal" | "raw_string_literal"
+    )
+    .then(|| node_text(node, source).trim_matches(|c| c == '"' || c == '`'))
+}
+
+fn is_verb(word: &str) -> bool {
+    !word.is_empty() && word.chars().all(|c| c.is_ascii_uppercase())
+}
+
+/// Methods named by a gorilla `.Methods("GET", "HEAD")` chained on `call`.
+fn gorilla_methods(call: Node, source: &str) -> Option<String> {
+    let selector = call
+        .parent()
+        .filter(|p| p.kind() == "selector_expression")?;
+    if node_text(selector.child_by_field_name("field")?, source) != "Methods" {
+        return None;
+    }
+    let (_, _, args) = method_call(selector.parent()?, source)?;
+    let methods: Vec<_> = args.iter().filter_map(|a| string(*a, source)).collect();
+    (!methods.is_empty()).then(|| methods.join(","))
+}
+
+/// The handler as written, taking wrapping calls off into `middleware`. `None` for
+/// an inline function.
+fn unwrap_handler(mut node: Node, source: &str, middleware: &mut Vec<String>) -> Option<String> {
+    let mut wrappers = Vec::new();
+    loop {
+        match node.kind() {
+            "func_literal" => {
+                middleware.extend(wrappers);
+                return None;
+            }
+            "call_expression" => {
+                let (Some(function), Some(arg)) = (
+                    node.child_by_field_name("function"),
+                    node.child_by_field_name("arguments")
+                        .filter(|a| a.named_child_count() == 1)
+                        .and_then(|a| a.named_child(0)),
+                ) else {
+                    break;
+                };
+                let name = node_text(function, source);
+                let last = name.rsplit('.').next().unwrap_or(name);
+                if !ADAPTERS.contains(&last) {
+                    wrappers.push(name.to_string());
+                }
+                node = arg;
+            }
+            _ => break,
+        }
+    }
+    middleware.extend(wrappers);
+    Some(node_text(node, source).to_string())
+}
+
+/// `prefix` followed by `path`, without doubling the `/` between them.
+fn join(prefix: &str, path: &str) -> String {
+    match (prefix.strip_suffix('/'), path.starts_with('/')) {
+        (Some(trimmed), true) => format!("{trimmed}{path}"),
+        _ => format!("{prefix}{path}"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    /// Method, path, handler and middleware.
+    type Row = (String, String, Option<String>, Vec<String>);
+
+    fn routes(source: &str) -> Vec<Row> {
+        get_extractor("go")
+            .unwrap()
+            .extract(source, "routes.go")
+            .unwrap()
+            .routes
+            .into_iter()
+            .map(|r| (r.method, r.path, r.handler, r.middleware))
+            .collect()
+    }
+
+    fn route(method: &str, path: &str, handler: Option<&str>, mw: &[&str]) -> Row {
+        (
+            method.to_string(),
+            path.to_string(),
+            handler.map(str::to_string),
+            mw.iter().map(|m| m.to_string()).collect(),
+        )
+    }
+
+    #[test]
+    fn test_go_net_http_and_gin_routes() {
+        let source = r#"package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Register(mux *http.ServeMux, h *Handlers) {
+	mux.HandleFunc("GET /health", h.Health)
+	http.Handle("/admin", logging(http.HandlerFunc(h.Admin)))
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func Router(h *Handlers) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	v1 := r.Group("/v1", auth)
+	v1.GET("/users/:id", limit, h.GetUser)
+	r.Handle("DELETE", "/cache", h.Flush)
+	cache.Get("key")
+	return r
+}
+"#;
+        assert_eq!(
+            routes(source),
+            [
+                route("GET", "/health", Some("h.Health"), &[]),
+                route("ANY", "/admin", Some("h.Admin"), &["logging"]),
+                route("ANY", "/ping", None, &[]),
+                route(
+                    "GET",
+                    "/v1/users/:id",
+                    Some("h.GetUser"),
+                    &["gin.Recovery()", "auth", "limit"]
+                ),
+                route("DELETE", "/cache", Some("h.Flush"), &["gin.Recovery()"]),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_go_echo_chi_and_gorilla_routes() {
+        let source = r#"package server
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/mux"
+	"github.com/labstack/echo/v4"
+)
+
+func Echo(e *echo.Echo) {
+	g := e.Group("/api")
+	g.POST("/login", login, rateLimit)
+}
+
+func Chi(r chi.Router) {
+	r.Route("/orders", func(r chi.Router) {
+		r.Use(auth)
+		r.Get("/", listOrders)
+		r.With(paginate).Get("/{id}", getOrder)
+	})
+	r.Method("put", "/items", updateItem)
+}
+
+func Gorilla() {
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api/").Subrouter()
+	api.HandleFunc("/users", createUser).Methods("POST", "PUT")
+}
+"#;
+        assert_eq!(
+            routes(source),
+            [
+                route("POST", "/api/login", Some("login"), &["rateLimit"]),
+                route("GET", "/orders/", Some("listOrders"), &["auth"]),
+                route(
+                    "GET",
+                    "/orders/{id}",
+                    Some("getOrder"),
+                    &["auth", "paginate"]
+                ),
+                route("PUT", "/items", Some("updateItem"), &[]),
+                route("POST,PUT", "/api/users", Some("createUser"), &[]),
+            ]
+        );
+    }
+}
@@ -3,7 +3,7 @@ use tree_sitter::{Language, Node, Parser};
 
 use crate::types::{symbol_id, Edge, EdgeKind, Symbol, SymbolKind, Visibility};
 
-use super::{node_text, ExtractionResult, Extractor};
+use super::{complexity, errors, flags, logs, node_text, todos, ExtractionResult, Extractor};
 
 /// Extracts symbols and edges from Ruby source files.
 pub struct RubyExtractor {
@@ -45,7 +45,40 @@ impl Extractor for RubyExtractor {
             &mut edges,
         );
 
-        Ok(ExtractionResult { symbols, edges })
+        let complexity = complexity::measure(tree.root_node(), source, &symbols, &complexity::RUBY);
+        let (fallible, error_flows) =
+            errors::analyze(tree.root_node(), source, &symbols, &edges, &errors::RUBY);
+        let log_statements = logs::statements(tree.root_node(), source, &symbols, &logs::RUBY);
+        let todos = todos::comments(tree.root_node(), source, &symbols);
+        let (flag_symbols, flag_edges) =
+            flags::checks(tree.root_node(), source, file_path, &symbols, &flags::RUBY);
+        symbols.extend(flag_symbols);
+        edges.extend(flag_edges);
+        Ok(ExtractionResult {
+            symbols,
+            edges,
+            complexity,
+            fallible,
+            error_flows,
+            panic_sites: Vec::new(),
+            sync_sites: Vec::new(),
+            takes_context: Vec::new(),
+            context_sites: Vec::new(),
+            globals: Vec::new(),
+            variable_accesses: Vec::new(),
+            routes: Vec::new(),
+            config_fields: Vec::new(),
+            field_uses: Vec::new(),
+            struct_fields: Vec::new(),
+            serializations: Vec::new(),
+            constants: Vec::new(),
+            type_assertions: Vec::new(),
+            test_doubles: Vec::new(),
+            constructions: Vec::new(),
+            syntax_errors: super::syntax_errors(tree.root_node(), source),
+            log_statements,
+            todos,
+        })
     }
 }
 
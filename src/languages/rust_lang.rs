@@ -3,7 +3,9 @@ use tree_sitter::{Language, Node, Parser};
 
 use crate::types::{symbol_id, Edge, EdgeKind, Symbol, SymbolKind, Visibility};
 
-use super::{node_text, ExtractionResult, Extractor};
+use super::{
+    complexity, errors, flags, logs, node_text, panics, todos, ExtractionResult, Extractor,
+};
 
 pub struct RustExtractor {
     parser: Parser,
@@ -44,7 +46,41 @@ impl Extractor for RustExtractor {
             &mut edges,
         );
 
-        Ok(ExtractionResult { symbols, edges })
+        let complexity = complexity::measure(tree.root_node(), source, &symbols, &complexity::RUST);
+        let (fallible, error_flows) =
+            errors::analyze(tree.root_node(), source, &symbols, &edges, &errors::RUST);
+        let panic_sites = panics::sites(tree.root_node(), source, &symbols, &panics::RUST);
+        let log_statements = logs::statements(tree.root_node(), source, &symbols, &logs::RUST);
+        let todos = todos::comments(tree.root_node(), source, &symbols);
+        let (flag_symbols, flag_edges) =
+            flags::checks(tree.root_node(), source, file_path, &symbols, &flags::RUST);
+        symbols.extend(flag_symbols);
+        edges.extend(flag_edges);
+        Ok(ExtractionResult {
+            symbols,
+            edges,
+            complexity,
+            fallible,
+            error_flows,
+            panic_sites,
+            sync_sites: Vec::new(),
+            takes_context: Vec::new(),
+            context_sites: Vec::new(),
+            globals: Vec::new(),
+            variable_accesses: Vec::new(),
+            routes: Vec::new(),
+            config_fields: Vec::new(),
+            field_uses: Vec::new(),
+            struct_fields: Vec::new(),
+            serializations: Vec::new(),
+            constants: Vec::new(),
+            type_assertions: Vec::new(),
+            test_doubles: Vec::new(),
+            constructions: Vec::new(),
+            syntax_errors: super::syntax_errors(tree.root_node(), source),
+            log_statements,
+            todos,
+        })
     }
 }
 
@@ -0,0 +1,379 @@
+//! Go struct tags and the calls that serialize structs, read off the syntax tree
+//! during extraction.
+//!
+//! Every struct field is kept with its tags (`json:"email,omitempty"` becomes
+//! `("json", "email,omitempty")`). A serialization is a call that encodes or
+//! decodes a value of a named type:
+//!
+//! - **json, yaml, xml, toml**: `json.Marshal(v)`, `json.Unmarshal(b, &v)`,
+//!   `json.NewEncoder(w).Encode(v)`, `NewDecoder(r).Decode(&v)` (the format is the
+//!   package the chain starts from), and gin/echo `c.JSON(code, v)`,
+//!   `c.ShouldBindJSON(&v)`, `render.JSON(w, r, v)`.
+//! - **db**: sqlx `Get`, `Select`, `StructScan`, `NamedExec`, `NamedQuery` and gorm
+//!   `Create`, `Save`, `First`, `Find`, `Scan`, on a receiver that mentions `db`,
+//!   `tx` or `rows`.
+//!
+//! The value's type is read off the expression where it is a literal (`User{}`,
+//! `&User{}`) or looked up among the function's parameters and declarations
+//! (`var u User`, `u := &User{...}`, `u := new(User)`). Pointers, slices and
+//! package qualifiers are dropped: `[]*models.User` is `User`.
+
+use std::collections::HashMap;
+
+use tree_sitter::Node;
+
+use crate::types::{Serialization, StructField, Symbol, SymbolKind};
+
+use super::complexity::{self, find_function};
+use super::node_text;
+
+/// Methods that encode their last argument, whatever the receiver.
+const ENCODERS: &[(&str, &str)] = &[
+    ("JSON", "json"),
+    ("IndentedJSON", "json"),
+    ("PureJSON", "json"),
+    ("XML", "xml"),
+    ("YAML", "yaml"),
+    ("TOML", "toml"),
+];
+
+/// Methods that decode into their last argument, whatever the receiver.
+const BINDERS: &[(&str, &str)] = &[
+    ("BindJSON", "json"),
+    ("ShouldBindJSON", "json"),
+    ("BindXML", "xml"),
+    ("ShouldBindXML", "xml"),
+    ("BindYAML", "yaml"),
+    ("ShouldBindYAML", "yaml"),
+    ("Bind", "json"),
+    ("ShouldBind", "json"),
+];
+
+/// Packages whose `Marshal`/`Unmarshal`/`Encode`/`Decode` name the format.
+const FORMATS: &[&str] = &["json", "yaml", "xml", "toml"];
+
+/// Database methods, with whether they decode (read rows into the value). The
+/// value is the first argument, except for the `Named*` ones.
+const DB_METHODS: &[(&str, bool)] = &[
+    ("Get", true),
+    ("Select", true),
+    ("StructScan", true),
+    ("First", true),
+    ("Find", true),
+    ("Scan", true),
+    ("Take", true),
+    ("Last", true),
+    ("NamedExec", false),
+    ("NamedQuery", false),
+    ("Create", false),
+    ("Save", false),
+    ("Updates", false),
+];
+
+/// Which argument of a serializing call holds the value.
+#[derive(Clone, Copy)]
+enum Arg {
+    First,
+    Last,
+}
+
+/// Fields of the structs among `symbols` with their tags, and every function's
+/// serializations.
+pub(crate) fn go_serialization(
+    root: Node,
+    source: &str,
+    symbols: &[Symbol],
+) -> (Vec<StructField>, Vec<Serialization>) {
+    let mut fields = Vec::new();
+    for sym in symbols.iter().filter(|sym| sym.kind == SymbolKind::Class) {
+        let Some(list) = root
+            .descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+            .and_then(|spec| spec.child_by_field_name("type"))
+            .filter(|t| t.kind() == "struct_type")
+            .and_then(|t| t.named_child(0))
+        else {
+            continue;
+        };
+        for declaration in list.named_children(&mut list.walk()) {
+            if declaration.kind() != "field_declaration" {
+                continue;
+            }
+            let tags = declaration
+                .child_by_field_name("tag")
+                .map(|t| parse_tags(node_text(t, source)))
+                .unwrap_or_default();
+            let mut cursor = declaration.walk();
+            for name in declaration.children_by_field_name("name", &mut cursor) {
+                fields.push(StructField {
+                    symbol_id: sym.id.clone(),
+                    name: node_text(name, source).to_string(),
+                    line: name.start_position().row as u32 + 1,
+                    tags: tags.clone(),
+                });
+            }
+        }
+    }
+
+    let mut serializations = Vec::new();
+    for sym in symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+    {
+        let Some(node) =
+            root.descendant_for_byte_range(sym.start_byte as usize, sym.end_byte as usize)
+        else {
+            continue;
+        };
+        let function = find_function(node, complexity::GO.functions).unwrap_or(node);
+        let types = local_types(function, source);
+        visit(function, &mut |call| {
+            if call.kind() != "call_expression" {
+                return;
+            }
+            let (Some(callee), Some(args)) = (
+                call.child_by_field_name("function"),
+                call.child_by_field_name("arguments"),
+            ) else {
+                return;
+            };
+            let Some((format, decode, arg)) = classify(node_text(callee, source)) else {
+                return;
+            };
+            let value = match arg {
+                Arg::First => args.named_child(0),
+                Arg::Last => args.named_child(args.named_child_count().saturating_sub(1)),
+            };
+            let Some(value) = value else {
+                return;
+            };
+            let Some(type_name) = value_type(value, source, &types) else {
+                return;
+            };
+            serializations.push(Serialization {
+                symbol_id: sym.id.clone(),
+                line: call.start_position().row as u32 + 1,
+                type_name,
+                format: format.to_string(),
+                decode,
+            });
+        });
+    }
+    (fields, serializations)
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// `[("json", "email,omitempty"), ("db", "email")]` for
+/// `` `json:"email,omitempty" db:"email"` ``.
+fn parse_tags(tag: &str) -> Vec<(String, String)> {
+    let mut tags = Vec::new();
+    let mut rest = tag.trim_matches('`');
+    while let Some((name, after)) = rest.split_once(":\"") {
+        let Some((value, after)) = after.split_once('"') else {
+            break;
+        };
+        tags.push((name.trim().to_string(), value.to_string()));
+        rest = after;
+    }
+    tags
+}
+
+/// The format a callee serializes in, whether it decodes into the value rather
+/// than encoding it, and which argument the value is.
+fn classify(callee: &str) -> Option<(&'static str, bool, Arg)> {
+    let (receiver, method) = callee.rsplit_once('.')?;
+    if let Some(&(_, format)) = ENCODERS.iter().find(|(m, _)| *m == method) {
+        return Some((format, false, Arg::Last));
+    }
+    if let Some(&(_, format)) = BINDERS.iter().find(|(m, _)| *m == method) {
+        return Some((format, true, Arg::Last));
+    }
+    let codec = match method {
+        "Marshal" | "MarshalIndent" | "Encode" => Some((false, Arg::First)),
+        "Decode" => Some((true, Arg::First)),
+        "Unmarshal" => Some((true, Arg::Last)),
+        _ => None,
+    };
+    if let Some((decode, arg)) = codec {
+        let package = receiver.split(['.', '(']).next().unwrap_or(receiver);
+        return FORMATS
+            .iter()
+            .find(|f| **f == package)
+            .map(|f| (*f, decode, arg));
+    }
+    let lower = receiver.to_ascii_lowercase();
+    if lower.contains("db") || lower.contains("tx") || lower.ends_with("rows") {
+        return DB_METHODS
+            .iter()
+            .find(|(m, _)| *m == method)
+            .map(|&(m, decode)| {
+                let arg = if m.starts_with("Named") {
+                    Arg::Last
+                } else {
+                    Arg::First
+                };
+                ("db", decode, arg)
+            });
+    }
+    None
+}
+
+/// Type names of the variables `function` declares with a visible type.
+pub(super) fn local_types<'s>(function: Node, source: &'s str) -> HashMap<&'s str, String> {
+    let mut types = HashMap::new();
+    visit(function, &mut |node| match node.kind() {
+        "parameter_declaration" | "var_spec" => {
+            let declared = node
+                .child_by_field_name("type")
+                .and_then(|t| base_type(node_text(t, source)))
+                .or_else(|| {
+                    let values = node.child_by_field_name("value")?;
+                    let value = values.named_child(0)?;
+                    expression_type(value, source)
+                });
+            let Some(declared) = declared else {
+                return;
+            };
+            let mut cursor = node.walk();
+            for name in node.children_by_field_name("name", &mut cursor) {
+                types.insert(node_text(name, source), declared.clone());
+            }
+        }
+        "short_var_declaration" => {
+            let (Some(left), Some(right)) = (
+                node.child_by_field_name("left"),
+                node.child_by_field_name("right"),
+            ) else {
+                return;
+            };
+            let names = left.named_children(&mut left.walk()).collect::<Vec<_>>();
+            let values = right.named_children(&mut right.walk()).collect::<Vec<_>>();
+            for (name, value) in names.iter().zip(values) {
+                if let Some(declared) = expression_type(value, source) {
+                    types.insert(node_text(*name, source), declared);
+                }
+            }
+        }
+        _ => {}
+    });
+    types
+}
+
+/// The type an expression evidently has: a composite literal's, possibly behind
+/// `&`, or `new(T)`'s.
+fn expression_type(value: Node, source: &str) -> Option<String> {
+    match value.kind() {
+        "composite_literal" => base_type(node_text(value.child_by_field_name("type")?, source)),
+        "unary_expression" => expression_type(value.child_by_field_name("operand")?, source),
+        "call_expression" => {
+            let function = value.child_by_field_name("function")?;
+            if node_text(function, source) != "new" {
+                return None;
+            }
+            let args = value.child_by_field_name("arguments")?;
+            base_type(node_text(args.named_child(0)?, source))
+        }
+        _ => None,
+    }
+}
+
+/// The type of a serialized value: its literal type, or the declared type of the
+/// variable, through `&`, `*` and parentheses.
+pub(super) fn value_type(
+    value: Node,
+    source: &str,
+    types: &HashMap<&str, String>,
+) -> Option<String> {
+    match value.kind() {
+        "identifier" => types.get(node_text(value, source)).cloned(),
+        "unary_expression" | "parenthesized_expression" => {
+            let inner = value
+                .child_by_field_name("operand")
+                .or_else(|| value.named_child(0))?;
+            value_type(inner, source, types)
+        }
+        _ => expression_type(value, source),
+    }
+}
+
+/// `User` for `*models.User`, `[]User` or `[]*User`; `None` for maps, funcs and
+/// other unnamed types.
+pub(super) fn base_type(text: &str) -> Option<String> {
+    let text = text.trim_start_matches(['*', '&', '[', ']']);
+    let name = text.rsplit('.').next().unwrap_or(text);
+    let named = !name.is_empty()
+        && name.starts_with(|c: char| c.is_alphabetic())
+        && name.chars().all(|c| c.is_alphanumeric() || c == '_');
+    named.then(|| name.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_go_struct_tags_and_serializations() {
+        let source = r#"package api
+
+type User struct {
+	ID    int64  `json:"id" db:"id"`
+	Email string `json:"email,omitempty" db:"email_address"`
+	note  string
+}
+
+func GetUser(c *gin.Context, db *sqlx.DB) {
+	var u User
+	if err := db.Get(&u, "SELECT * FROM users WHERE id = $1", c.Param("id")); err != nil {
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	in := &models.User{}
+	json.NewDecoder(r.Body).Decode(in)
+	out, _ := json.Marshal(map[string]string{"ok": "yes"})
+	w.Write(out)
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "api/users.go")
+            .unwrap();
+        let fields: Vec<_> = result
+            .struct_fields
+            .iter()
+            .map(|f| (f.name.as_str(), f.tags.len()))
+            .collect();
+        assert_eq!(fields, [("ID", 2), ("Email", 2), ("note", 0)]);
+        assert_eq!(
+            result.struct_fields[1].tags[0],
+            ("json".to_string(), "email,omitempty".to_string())
+        );
+
+        let sites: Vec<_> = result
+            .serializations
+            .iter()
+            .map(|s| (s.line, s.type_name.as_str(), s.format.as_str(), s.decode))
+            .collect();
+        assert_eq!(
+            sites,
+            [
+                (11, "User", "db", true),
+                (14, "User", "json", false),
+                (19, "User", "json", true),
+            ]
+        );
+        // Serializing a type references it, so `refs` and `impact` see it.
+        assert!(result
+            .edges
+            .iter()
+            .any(|e| e.target_name == "User" && e.line == 14));
+    }
+}
@@ -0,0 +1,129 @@
+//! TODO, FIXME, HACK and XXX comments, read off the syntax tree during extraction.
+//!
+//! Every grammar names its comment nodes `comment`, `line_comment` or
+//! `block_comment`, so one walk serves all languages. Markers only count in
+//! upper case and as whole words; `TODO(alice):` names an assignee.
+
+use tree_sitter::Node;
+
+use crate::types::{Symbol, Todo};
+
+use super::flags::enclosing;
+use super::node_text;
+
+pub(crate) const MARKERS: &[&str] = &["TODO", "FIXME", "HACK", "XXX"];
+
+/// Marker comments in the file, each with the innermost symbol among `symbols`
+/// around it, if any.
+pub(crate) fn comments(root: Node, source: &str, symbols: &[Symbol]) -> Vec<Todo> {
+    let mut todos = Vec::new();
+    visit(root, &mut |node| {
+        // Rust doc comments nest a `doc_comment` in their `line_comment`.
+        let nested = node.parent().is_some_and(|p| p.kind().ends_with("comment"));
+        if !node.kind().ends_with("comment") || nested {
+            return;
+        }
+        let symbol_id = enclosing(symbols, node).map(|s| s.id.clone());
+        let first_line = node.start_position().row as u32 + 1;
+        for (i, line) in node_text(node, source).lines().enumerate() {
+            let Some((marker, rest)) = find_marker(line) else {
+                continue;
+            };
+            let (assignee, rest) = match rest.strip_prefix('(').and_then(|r| r.split_once(')')) {
+                Some((who, rest)) => (Some(who.trim().to_string()), rest),
+                None => (None, rest),
+            };
+            let text = rest
+                .trim_start_matches(|c: char| matches!(c, ':' | '-' | '!') || c.is_whitespace())
+                .trim_end()
+                .trim_end_matches("*/")
+                .trim_end();
+            todos.push(Todo {
+                symbol_id: symbol_id.clone(),
+                line: first_line + i as u32,
+                marker: marker.to_string(),
+                assignee: assignee.filter(|a| !a.is_empty()),
+                text: text.to_string(),
+            });
+        }
+    });
+    todos
+}
+
+fn visit<'t>(node: Node<'t>, f: &mut impl FnMut(Node<'t>)) {
+    for child in node.named_children(&mut node.walk()) {
+        f(child);
+        visit(child, f);
+    }
+}
+
+/// The first marker on `line` standing as a whole word, and the text after it.
+fn find_marker(line: &str) -> Option<(&'static str, &str)> {
+    MARKERS
+        .iter()
+        .filter_map(|&marker| {
+            line.match_indices(marker)
+                .find(|&(at, _)| {
+                    let before = line[..at].chars().next_back();
+                    let after = line[at + marker.len()..].chars().next();
+                    !before.is_some_and(|c| c.is_alphanumeric() || c == '_')
+                        && !after.is_some_and(|c| c.is_alphanumeric() || c == '_')
+                })
+                .map(|(at, _)| (at, marker))
+        })
+        .min_by_key(|&(at, _)| at)
+        .map(|(at, marker)| (marker, &line[at + marker.len()..]))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::super::get_extractor;
+    use super::*;
+
+    #[test]
+    fn test_marker_comments_with_enclosing_symbols() {
+        let source = r#"package billing
+
+// TODO(alice): split into charge and capture
+func Charge() {
+	// FIXME: retries are not idempotent
+	x := todo() // not a TODOS marker, nor a todo
+	/* HACK - until the gateway
+	   supports it XXX */
+}
+"#;
+        let result = get_extractor("go")
+            .unwrap()
+            .extract(source, "billing/charge.go")
+            .unwrap();
+        let charge = result.symbols.iter().find(|s| s.name == "Charge").unwrap();
+        let todos: Vec<_> = result
+            .todos
+            .iter()
+            .map(|t| {
+                (
+                    t.line,
+                    t.marker.as_str(),
+                    t.assignee.as_deref(),
+                    t.text.as_str(),
+                    t.symbol_id.as_deref() == Some(charge.id.as_str()),
+                )
+            })
+            .collect();
+        assert_eq!(
+            todos,
+            [
+                (
+                    3,
+                    "TODO",
+                    Some("alice"),
+                    "split into charge and capture",
+                    false
+                ),
+                (5, "FIXME", None, "retries are not idempotent", true),
+                (7, "HACK", None, "until the gateway", true),
+                (8, "XXX", None, "", true),
+            ]
+        );
+    }
+}
@@ -1,6 +1,72 @@
+pub mod alerts;
+pub mod aliases;
+pub mod arch;
+pub mod audit;
+pub mod auth;
+pub mod batch;
+pub mod bench;
+pub mod benchmarks;
+pub mod bloom;
+pub mod capabilities;
+pub mod changelog;
+pub mod completions;
+pub mod config;
+pub mod config_keys;
+pub mod constructs;
+pub mod coverage;
+pub mod ctx;
 pub mod db;
+pub mod deprecations;
+pub mod deps_usage;
+pub mod diff;
+pub mod doc;
+pub mod dupes;
+pub mod errors;
+pub mod explain;
+pub mod federation;
+pub mod git;
+pub mod grep;
+pub mod history;
+pub mod hooks;
+pub mod hotspots;
 pub mod indexer;
+pub mod init;
+pub mod inits;
 pub mod languages;
+pub mod lineage;
+pub mod logs;
+pub mod macros;
+pub mod metrics;
+pub mod otel;
+pub mod owners;
+pub mod pager;
+pub mod panics;
+pub mod pipe;
+pub mod pipeline;
+pub mod plugins;
+pub mod pr;
+pub mod profile;
 pub mod rag;
+pub mod rank;
+pub mod ratelimit;
+pub mod reachable;
+pub mod recent;
+pub mod sequence;
+pub mod session;
+pub mod snapshot;
+pub mod stdlib;
+pub mod table;
+#[cfg(test)]
+mod test_support;
+pub mod tests_for;
+pub mod todos;
+pub mod tour;
 pub mod types;
+pub mod unsafe_audit;
+pub mod untested;
+pub mod validate;
+pub mod vendor;
+pub mod warm;
 pub mod watch;
+pub mod why_depends;
+pub mod wire;
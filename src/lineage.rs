@@ -0,0 +1,229 @@
+use std::collections::{HashMap, HashSet};
+
+use serde::Serialize;
+
+use crate::types::{Symbol, SymbolKind};
+
+/// Minimum line-set similarity for two symbol bodies to count as the same code.
+const SIMILARITY_THRESHOLD: f64 = 0.8;
+
+/// Bodies shorter than this (in non-blank lines) are too generic to match by content:
+/// one-line getters or empty constructors would pair up arbitrarily.
+const MIN_BODY_LINES: usize = 3;
+
+/// How an old symbol was linked to its new identity.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "kebab-case")]
+pub enum RenameReason {
+    /// The containing file was renamed (git rename detection); the symbol name is unchanged.
+    FileRename,
+    /// The symbol vanished and a new one appeared with a near-identical body.
+    SimilarContent,
+}
+
+impl RenameReason {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::FileRename => "file-rename",
+            Self::SimilarContent => "similar-content",
+        }
+    }
+}
+
+impl std::str::FromStr for RenameReason {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "file-rename" => Ok(Self::FileRename),
+            "similar-content" => Ok(Self::SimilarContent),
+            _ => Err(anyhow::anyhow!("unknown rename reason: '{s}'")),
+        }
+    }
+}
+
+/// Link from a symbol's previous name/location to its current one.
+///
+/// Symbol IDs embed the start line, so links are keyed by `(file, name)` instead:
+/// they stay valid while the symbol moves within its file.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RenameLink {
+    pub old_name: String,
+    pub old_file: String,
+    pub old_start_line: u32,
+    pub old_end_line: u32,
+    pub new_name: String,
+    pub new_file: String,
+    pub kind: SymbolKind,
+    pub reason: RenameReason,
+    /// Last indexed commit at which the old symbol existed, if known.
+    pub old_commit: Option<String>,
+}
+
+/// Pair symbols that disappeared during an index run with those that appeared.
+///
+/// `removed` and `added` hold each symbol with its source text. `file_renames` maps
+/// old paths to new ones. A file rename with an unchanged name wins; otherwise the
+/// most similar body above [`SIMILARITY_THRESHOLD`] is taken. Each added symbol is
+/// claimed at most once.
+pub fn detect_renames(
+    removed: &[(Symbol, String)],
+    added: &[(Symbol, String)],
+    file_renames: &HashMap<String, String>,
+    old_commit: Option<&str>,
+) -> Vec<RenameLink> {
+    let mut claimed: HashSet<usize> = HashSet::new();
+    let mut links = Vec::new();
+
+    let added_lines: Vec<HashSet<String>> = added
+        .iter()
+        .map(|(sym, content)| body_lines(content, &sym.name))
+        .collect();
+
+    for (old, old_content) in removed {
+        if !is_trackable(old.kind) {
+            continue;
+        }
+
+        let renamed_file = file_renames.get(&old.file_path);
+        let by_file = added.iter().enumerate().find(|(i, (new, _))| {
+            !claimed.contains(i)
+                && new.kind == old.kind
+                && new.name == old.name
+                && Some(&new.file_path) == renamed_file
+        });
+
+        let matched = match by_file {
+            Some((i, _)) => Some((i, RenameReason::FileRename)),
+            None => {
+                let old_lines = body_lines(old_content, &old.name);
+                if old_lines.len() < MIN_BODY_LINES {
+                    continue;
+                }
+                added
+                    .iter()
+                    .enumerate()
+                    .filter(|(i, (new, _))| !claimed.contains(i) && new.kind == old.kind)
+                    .map(|(i, _)| (i, jaccard(&old_lines, &added_lines[i])))
+                    .filter(|(_, score)| *score >= SIMILARITY_THRESHOLD)
+                    .max_by(|a, b| a.1.partial_cmp(&b.1).unwrap_or(std::cmp::Ordering::Equal))
+                    .map(|(i, _)| (i, RenameReason::SimilarContent))
+            }
+        };
+
+        if let Some((i, reason)) = matched {
+            claimed.insert(i);
+            let new = &added[i].0;
+            links.push(RenameLink {
+                old_name: old.name.clone(),
+                old_file: old.file_path.clone(),
+                old_start_line: old.start_line,
+                old_end_line: old.end_line.max(old.start_line),
+                new_name: new.name.clone(),
+                new_file: new.file_path.clone(),
+                kind: new.kind,
+                reason,
+                old_commit: old_commit.map(str::to_string),
+            });
+        }
+    }
+    links
+}
+
+fn is_trackable(kind: SymbolKind) -> bool {
+    matches!(
+        kind,
+        SymbolKind::Function | SymbolKind::Method | SymbolKind::Class
+    )
+}
+
+/// Trimmed, non-blank lines of a body, with the symbol's own name masked so a
+/// pure rename still compares equal.
+fn body_lines(content: &str, name: &str) -> HashSet<String> {
+    content
+        .lines()
+        .map(str::trim)
+        .filter(|l| !l.is_empty())
+        .map(|l| {
+            if name.is_empty() {
+                l.to_string()
+            } else {
+                l.replace(name, "\u{0}")
+            }
+        })
+        .collect()
+}
+
+fn jaccard(a: &HashSet<String>, b: &HashSet<String>) -> f64 {
+    if a.is_empty() && b.is_empty() {
+        return 0.0;
+    }
+    let shared = a.intersection(b).count();
+    shared as f64 / (a.len() + b.len() - shared) as f64
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn func(name: &str, file: &str) -> Symbol {
+        Symbol::new(name, SymbolKind::Function, file, 10, 20, 0, 100)
+    }
+
+    const BODY: &str = "def NAME(token):\n    claims = decode(token)\n    if claims.expired:\n        raise ExpiredTokenError()\n    return claims\n";
+
+    fn body(name: &str) -> String {
+        BODY.replace("NAME", name)
+    }
+
+    #[test]
+    fn test_renamed_function_matched_by_content() {
+        let removed = vec![(func("check_token", "auth.py"), body("check_token"))];
+        let added = vec![
+            (
+                func("unrelated", "auth.py"),
+                "def unrelated():\n    pass\n".to_string(),
+            ),
+            (func("validate_token", "auth.py"), body("validate_token")),
+        ];
+        let links = detect_renames(&removed, &added, &HashMap::new(), Some("abc"));
+        assert_eq!(links.len(), 1);
+        assert_eq!(links[0].old_name, "check_token");
+        assert_eq!(links[0].new_name, "validate_token");
+        assert_eq!(links[0].reason, RenameReason::SimilarContent);
+        assert_eq!(links[0].old_commit.as_deref(), Some("abc"));
+    }
+
+    #[test]
+    fn test_file_rename_keeps_name_even_if_body_changed() {
+        let removed = vec![(func("login", "old/views.py"), body("login"))];
+        let added = vec![(
+            func("login", "new/views.py"),
+            "def login():\n    return redirect()\n".to_string(),
+        )];
+        let renames = HashMap::from([("old/views.py".to_string(), "new/views.py".to_string())]);
+        let links = detect_renames(&removed, &added, &renames, None);
+        assert_eq!(links.len(), 1);
+        assert_eq!(links[0].reason, RenameReason::FileRename);
+        assert_eq!(links[0].new_file, "new/views.py");
+    }
+
+    #[test]
+    fn test_short_or_dissimilar_bodies_are_not_linked() {
+        let removed = vec![
+            (func("tiny", "a.py"), "def tiny():\n    pass\n".to_string()),
+            (func("check_token", "a.py"), body("check_token")),
+        ];
+        let added = vec![
+            (
+                func("other", "a.py"),
+                "def other():\n    pass\n".to_string(),
+            ),
+            (
+                func("render", "a.py"),
+                "def render(page):\n    html = template(page)\n    return html\n".to_string(),
+            ),
+        ];
+        assert!(detect_renames(&removed, &added, &HashMap::new(), None).is_empty());
+    }
+}
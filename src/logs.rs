@@ -0,0 +1,227 @@
+//! Log lookup: from a line seen in production logs back to the statement that
+//! emitted it, the symbol it sits in and the calls that lead there.
+//!
+//! Statements are recorded at index time (see `languages::logs`). A query
+//! matches a statement when it is part of the template, or when the template's
+//! literal text, split at its placeholders (`%s`, `{}`, `${x}`, `#{x}`...), appears
+//! in order in the query: so a full rendered line finds the template it came from.
+
+use std::collections::HashSet;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{LogLevel, LogStatement, Symbol};
+
+/// Literal text a template needs before a rendered line can match it, so
+/// templates that are all placeholders (`"%v"`) do not match everything.
+const MIN_LITERAL_LEN: usize = 4;
+
+/// A log statement with the symbol that emits it and its callers.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct LogMatch {
+    pub symbol: Symbol,
+    #[serde(flatten)]
+    pub statement: LogStatement,
+    pub callers: Vec<CallStep>,
+}
+
+/// A call site on the way up, with its own callers.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct CallStep {
+    pub caller: Symbol,
+    pub line: u32,
+    pub callers: Vec<CallStep>,
+}
+
+/// Log statements at `min_level` or above whose template matches `grep` (all of
+/// them without one), each with its callers up to `depth` calls away.
+pub fn find(
+    db: &Database,
+    grep: Option<&str>,
+    min_level: Option<LogLevel>,
+    depth: u32,
+) -> Result<Vec<LogMatch>> {
+    let mut matches = Vec::new();
+    for (symbol, statement) in db.log_statements()? {
+        if min_level.is_some_and(|min| statement.level < min)
+            || grep.is_some_and(|q| !template_matches(&statement.template, q))
+        {
+            continue;
+        }
+        let mut seen = HashSet::from([symbol.id.clone()]);
+        matches.push(LogMatch {
+            callers: callers(db, &symbol.id, depth, &mut seen)?,
+            symbol,
+            statement,
+        });
+    }
+    Ok(matches)
+}
+
+/// Callers of `symbol_id`, `depth` levels up. A caller seen earlier is listed but
+/// not expanded again, so recursion terminates.
+pub(crate) fn callers(
+    db: &Database,
+    symbol_id: &str,
+    depth: u32,
+    seen: &mut HashSet<String>,
+) -> Result<Vec<CallStep>> {
+    if depth == 0 {
+        return Ok(Vec::new());
+    }
+    let mut steps = Vec::new();
+    for (caller, line) in db.callers(symbol_id)? {
+        let callers = if seen.insert(caller.id.clone()) {
+            callers(db, &caller.id, depth - 1, seen)?
+        } else {
+            Vec::new()
+        };
+        steps.push(CallStep {
+            caller,
+            line,
+            callers,
+        });
+    }
+    Ok(steps)
+}
+
+/// Whether `query` is part of `template`, or a line `template` could have
+/// rendered, ignoring case.
+fn template_matches(template: &str, query: &str) -> bool {
+    let template = template.to_lowercase();
+    let query = query.to_lowercase();
+    if template.contains(&query) {
+        return true;
+    }
+    let pieces = literal_pieces(&template);
+    if pieces.iter().map(|p| p.len()).sum::<usize>() < MIN_LITERAL_LEN {
+        return false;
+    }
+    let mut rest = query.as_str();
+    pieces.iter().all(|piece| match rest.find(piece.as_str()) {
+        Some(at) => {
+            rest = &rest[at + piece.len()..];
+            true
+        }
+        None => false,
+    })
+}
+
+/// The literal text of a template between its placeholders: printf verbs (`%s`,
+/// `%-5.2f`, `%(name)s`), braces (`{}`, `{id}`, `{:?}`) and interpolations (`${x}`,
+/// `#{x}`).
+fn literal_pieces(template: &str) -> Vec<String> {
+    let mut pieces = vec![String::new()];
+    let mut chars = template.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '%' if chars.peek() == Some(&'%') => {
+                chars.next();
+                pieces.last_mut().unwrap().push('%');
+            }
+            '%' => {
+                if chars.peek() == Some(&'(') {
+                    chars.find(|&c| c == ')');
+                }
+                while chars
+                    .peek()
+                    .is_some_and(|c| matches!(c, '-' | '+' | '#' | ' ' | '0'..='9' | '.'))
+                {
+                    chars.next();
+                }
+                if chars.peek().is_some_and(|c| c.is_ascii_alphabetic()) {
+                    chars.next();
+                }
+                pieces.push(String::new());
+            }
+            '$' | '#' if chars.peek() == Some(&'{') => {
+                chars.find(|&c| c == '}');
+                pieces.push(String::new());
+            }
+            '{' => {
+                chars.find(|&c| c == '}');
+                pieces.push(String::new());
+            }
+            c => pieces.last_mut().unwrap().push(c),
+        }
+    }
+    pieces.retain(|p| !p.trim().is_empty());
+    pieces
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, SymbolKind};
+
+    #[test]
+    fn test_rendered_lines_match_their_template() {
+        let template = "rate limit hit for %s after %d retries";
+        assert!(template_matches(template, "Rate limit"));
+        assert!(template_matches(
+            template,
+            "2024-05-01T10:00:00Z WARN rate limit hit for user-42 after 3 retries"
+        ));
+        assert!(!template_matches(
+            template,
+            "rate limit hit after 3 retries"
+        ));
+        assert!(template_matches(
+            "synced {} rows in {:?}",
+            "synced 12 rows in 3ms"
+        ));
+        assert!(template_matches(
+            "total for #{id}: ${amount}",
+            "total for 7: 10"
+        ));
+        assert!(!template_matches("%v", "anything at all"));
+        assert_eq!(literal_pieces("100%% done: %(name)s"), ["100% done: "]);
+    }
+
+    #[test]
+    fn test_find_lists_callers_of_the_emitting_symbol() {
+        let db = Database::open_memory().unwrap();
+        let file = "billing/charge.go";
+        let sym = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 8, 0, 100)
+        };
+        let charge = sym("Charge", 1);
+        let checkout = sym("Checkout", 10);
+        let handler = sym("HandleCheckout", 20);
+        db.insert_symbols(&[charge.clone(), checkout.clone(), handler.clone()])
+            .unwrap();
+        let statement = |line, level, template: &str| LogStatement {
+            symbol_id: charge.id.clone(),
+            line,
+            level,
+            component: None,
+            template: template.to_string(),
+        };
+        db.insert_log_statements(
+            file,
+            &[
+                statement(3, LogLevel::Debug, "charging %s"),
+                statement(5, LogLevel::Warn, "rate limit hit for %s"),
+            ],
+        )
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(&checkout.id, "Charge", EdgeKind::Calls, file, 12),
+            Edge::new(&handler.id, "Checkout", EdgeKind::Calls, file, 22),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let found = find(&db, Some("rate limit hit for user-42"), None, 5).unwrap();
+        assert_eq!(found.len(), 1);
+        assert_eq!(found[0].statement.line, 5);
+        assert_eq!(found[0].callers[0].caller.name, "Checkout");
+        assert_eq!(found[0].callers[0].callers[0].caller.name, "HandleCheckout");
+
+        let warnings = find(&db, None, Some(LogLevel::Warn), 0).unwrap();
+        assert_eq!(warnings.len(), 1);
+        assert!(warnings[0].callers.is_empty());
+    }
+}
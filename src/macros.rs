@@ -0,0 +1,396 @@
+//! User-defined query macros.
+//!
+//! A macro is a named, parameterized pipeline of built-in queries, declared in
+//! the root `.cartog.toml`:
+//!
+//! ```toml
+//! [macros.handler-chain]
+//! description = "A route handler, what it calls, and the tests that reach it"
+//! params = ["route"]
+//! steps = [
+//!     { run = "search", query = "{route}", kind = "function", limit = 3 },
+//!     { run = "callees", name = "{prev}", depth = 3 },
+//!     { run = "refs", name = "{prev}", files = "tests/**" },
+//! ]
+//! ```
+//!
+//! `{param}` placeholders are replaced with the invocation's arguments. A step
+//! that mentions `{prev}` runs once for each distinct name the previous step
+//! produced (up to [`MAX_FAN_OUT`]). `files` keeps only hits in matching files.
+
+use std::collections::{BTreeMap, HashSet, VecDeque};
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::db::Database;
+use crate::types::{EdgeKind, SymbolKind};
+
+/// Cap on how many names a `{prev}` step expands to.
+pub const MAX_FAN_OUT: usize = 50;
+
+/// Placeholder for each name produced by the previous step.
+const PREV: &str = "{prev}";
+
+/// One macro from `[macros.<name>]`.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+#[serde(default)]
+pub struct MacroDef {
+    pub description: String,
+    /// Positional parameter names, referenced as `{name}` in step arguments.
+    pub params: Vec<String>,
+    pub steps: Vec<MacroStep>,
+}
+
+/// A built-in query with templated arguments.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct MacroStep {
+    #[serde(flatten)]
+    pub query: StepQuery,
+    /// Glob on the hit's file path; hits elsewhere are dropped.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub files: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "run", rename_all = "snake_case")]
+pub enum StepQuery {
+    Search {
+        query: String,
+        kind: Option<SymbolKind>,
+        limit: Option<u32>,
+    },
+    Outline {
+        file: String,
+    },
+    Callees {
+        name: String,
+        /// Follow calls this many hops (default 1).
+        depth: Option<u32>,
+    },
+    Refs {
+        name: String,
+        kind: Option<EdgeKind>,
+    },
+    Impact {
+        name: String,
+        depth: Option<u32>,
+    },
+    Hierarchy {
+        name: String,
+    },
+    Deps {
+        file: String,
+    },
+}
+
+impl MacroStep {
+    /// Matcher of the step's `files` glob, if it has one.
+    pub(crate) fn files_matcher(&self) -> Result<Option<globset::GlobMatcher>> {
+        self.files
+            .as_deref()
+            .map(|pattern| {
+                globset::GlobBuilder::new(pattern)
+                    .literal_separator(true)
+                    .build()
+                    .map(|g| g.compile_matcher())
+                    .with_context(|| format!("invalid files glob '{pattern}'"))
+            })
+            .transpose()
+    }
+}
+
+impl StepQuery {
+    pub(crate) fn label(&self) -> &'static str {
+        match self {
+            Self::Search { .. } => "search",
+            Self::Outline { .. } => "outline",
+            Self::Callees { .. } => "callees",
+            Self::Refs { .. } => "refs",
+            Self::Impact { .. } => "impact",
+            Self::Hierarchy { .. } => "hierarchy",
+            Self::Deps { .. } => "deps",
+        }
+    }
+
+    /// The templated argument: search text, symbol name or file path.
+    pub(crate) fn target(&self) -> &str {
+        match self {
+            Self::Search { query, .. } => query,
+            Self::Outline { file } | Self::Deps { file } => file,
+            Self::Callees { name, .. }
+            | Self::Refs { name, .. }
+            | Self::Impact { name, .. }
+            | Self::Hierarchy { name } => name,
+        }
+    }
+}
+
+/// A row produced by a step: a symbol, or the far end of an edge.
+#[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize)]
+pub struct MacroHit {
+    pub name: String,
+    /// Symbol kind for symbol queries, edge kind for edge queries.
+    pub kind: String,
+    pub file_path: String,
+    pub line: u32,
+}
+
+/// One execution of a step with its placeholders filled in.
+#[derive(Debug, Clone, Serialize)]
+pub struct StepRun {
+    pub target: String,
+    pub hits: Vec<MacroHit>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct StepResult {
+    pub query: &'static str,
+    pub runs: Vec<StepRun>,
+    /// `{prev}` had more names than [`MAX_FAN_OUT`]; the rest were not run.
+    pub truncated: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct MacroResult {
+    pub name: String,
+    pub args: BTreeMap<String, String>,
+    pub steps: Vec<StepResult>,
+}
+
+/// Run macro `name` with positional `args`.
+pub fn run(db: &Database, name: &str, def: &MacroDef, args: &[String]) -> Result<MacroResult> {
+    anyhow::ensure!(
+        args.len() == def.params.len(),
+        "macro '{name}' takes {} argument(s) ({}), got {}",
+        def.params.len(),
+        def.params.join(", "),
+        args.len()
+    );
+    let bindings: BTreeMap<String, String> = def
+        .params
+        .iter()
+        .cloned()
+        .zip(args.iter().cloned())
+        .collect();
+
+    let mut steps = Vec::with_capacity(def.steps.len());
+    let mut prev: Vec<String> = Vec::new();
+    for (i, step) in def.steps.iter().enumerate() {
+        let files = step.files_matcher()?;
+        let template = substitute(step.query.target(), &bindings);
+        let (targets, truncated) = if template.contains(PREV) {
+            let truncated = prev.len() > MAX_FAN_OUT;
+            let targets = prev
+                .iter()
+                .take(MAX_FAN_OUT)
+                .map(|name| template.replace(PREV, name))
+                .collect();
+            (targets, truncated)
+        } else {
+            (vec![template], false)
+        };
+
+        let mut runs = Vec::with_capacity(targets.len());
+        let mut names = Vec::new();
+        let mut seen = HashSet::new();
+        for target in targets {
+            let mut hits = execute(db, &step.query, &target).with_context(|| {
+                format!("macro '{name}' step {} ({})", i + 1, step.query.label())
+            })?;
+            if let Some(files) = &files {
+                hits.retain(|h| files.is_match(&h.file_path));
+            }
+            for hit in &hits {
+                if seen.insert(hit.name.clone()) {
+                    names.push(hit.name.clone());
+                }
+            }
+            runs.push(StepRun { target, hits });
+        }
+        prev = names;
+        steps.push(StepResult {
+            query: step.query.label(),
+            runs,
+            truncated,
+        });
+    }
+
+    Ok(MacroResult {
+        name: name.to_string(),
+        args: bindings,
+        steps,
+    })
+}
+
+/// Replace `{param}` placeholders; `{prev}` is left for the fan-out.
+fn substitute(template: &str, bindings: &BTreeMap<String, String>) -> String {
+    bindings
+        .iter()
+        .fold(template.to_string(), |acc, (param, value)| {
+            acc.replace(&format!("{{{param}}}"), value)
+        })
+}
+
+pub(crate) fn execute(db: &Database, query: &StepQuery, target: &str) -> Result<Vec<MacroHit>> {
+    let symbol_hit = |s: crate::types::Symbol| MacroHit {
+        name: s.name,
+        kind: s.kind.to_string(),
+        file_path: s.file_path,
+        line: s.start_line,
+    };
+    let edge_hit = |e: crate::types::Edge| MacroHit {
+        name: e.target_name,
+        kind: e.kind.to_string(),
+        file_path: e.file_path,
+        line: e.line,
+    };
+    Ok(match query {
+        StepQuery::Search { kind, limit, .. } => db
+            .search(target, *kind, None, limit.unwrap_or(20))?
+            .into_iter()
+            .map(symbol_hit)
+            .collect(),
+        StepQuery::Outline { .. } => db.outline(target)?.into_iter().map(symbol_hit).collect(),
+        StepQuery::Callees { depth, .. } => {
+            let mut hits = Vec::new();
+            let mut visited = HashSet::new();
+            let mut frontier = VecDeque::from([(target.to_string(), 0)]);
+            while let Some((name, hop)) = frontier.pop_front() {
+                if hop >= depth.unwrap_or(1).max(1) || !visited.insert(name.clone()) {
+                    continue;
+                }
+                for edge in db.callees(&name)? {
+                    frontier.push_back((edge.target_name.clone(), hop + 1));
+                    hits.push(edge_hit(edge));
+                }
+            }
+            hits
+        }
+        StepQuery::Refs { kind, .. } => db
+            .refs(target, *kind)?
+            .into_iter()
+            .map(|(edge, source)| MacroHit {
+                name: source.map_or(edge.source_id, |s| s.name),
+                kind: edge.kind.to_string(),
+                file_path: edge.file_path,
+                line: edge.line,
+            })
+            .collect(),
+        StepQuery::Impact { depth, .. } => {
+            let edges = db.impact(target, depth.unwrap_or(3))?;
+            let ids: Vec<String> = edges.iter().map(|(e, _)| e.source_id.clone()).collect();
+            let names: BTreeMap<String, String> = db
+                .get_symbols_by_ids(&ids)?
+                .into_iter()
+                .map(|s| (s.id, s.name))
+                .collect();
+            edges
+                .into_iter()
+                .map(|(edge, _)| MacroHit {
+                    name: names
+                        .get(&edge.source_id)
+                        .cloned()
+                        .unwrap_or_else(|| edge.source_id.clone()),
+                    kind: edge.kind.to_string(),
+                    file_path: edge.file_path,
+                    line: edge.line,
+                })
+                .collect()
+        }
+        StepQuery::Hierarchy { .. } => db
+            .hierarchy(target)?
+            .into_iter()
+            .flat_map(|(child, parent)| [child, parent])
+            .filter(|name| name != target)
+            .map(|name| MacroHit {
+                name,
+                kind: EdgeKind::Inherits.to_string(),
+                file_path: String::new(),
+                line: 0,
+            })
+            .collect(),
+        StepQuery::Deps { .. } => db.file_deps(target)?.into_iter().map(edge_hit).collect(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::ConfigFile;
+    use crate::types::{Edge, Symbol};
+
+    fn handler_chain() -> MacroDef {
+        let file = ConfigFile::parse(
+            r#"
+            [macros.handler-chain]
+            params = ["route"]
+            steps = [
+                { run = "search", query = "{route}", kind = "function" },
+                { run = "callees", name = "{prev}", depth = 2 },
+                { run = "refs", name = "{prev}", files = "tests/**" },
+            ]
+            "#,
+        )
+        .unwrap();
+        file.macros["handler-chain"].clone()
+    }
+
+    fn seed(db: &Database) {
+        let sym = |name: &str, file: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 2, 0, 10)
+        };
+        let symbols = [
+            sym("get_user", "api/routes.py", 1),
+            sym("load_user", "api/service.py", 1),
+            sym("query_db", "api/store.py", 1),
+            sym("test_load_user", "tests/test_service.py", 1),
+            sym("warm_cache", "jobs/cache.py", 1),
+        ];
+        for s in &symbols {
+            db.insert_symbol(s).unwrap();
+        }
+        let call = |from: &Symbol, to: &str| {
+            Edge::new(from.id.clone(), to, EdgeKind::Calls, &from.file_path, 2)
+        };
+        db.insert_edges(&[
+            call(&symbols[0], "load_user"),
+            call(&symbols[1], "query_db"),
+            call(&symbols[3], "load_user"),
+            call(&symbols[4], "query_db"),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+    }
+
+    #[test]
+    fn test_macro_pipes_names_between_steps() {
+        let db = Database::open_memory().unwrap();
+        seed(&db);
+        let result = run(&db, "handler-chain", &handler_chain(), &["get_user".into()]).unwrap();
+
+        assert_eq!(result.steps[0].runs[0].target, "get_user");
+        let callees: Vec<&str> = result.steps[1]
+            .runs
+            .iter()
+            .flat_map(|r| r.hits.iter().map(|h| h.name.as_str()))
+            .collect();
+        assert_eq!(callees, ["load_user", "query_db"]);
+
+        let tests: Vec<&str> = result.steps[2]
+            .runs
+            .iter()
+            .flat_map(|r| r.hits.iter().map(|h| h.name.as_str()))
+            .collect();
+        assert_eq!(tests, ["test_load_user"], "jobs/ hits filtered out");
+    }
+
+    #[test]
+    fn test_macro_checks_arity() {
+        let db = Database::open_memory().unwrap();
+        let err = run(&db, "handler-chain", &handler_chain(), &[]).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("takes 1 argument(s) (route), got 0"));
+    }
+}
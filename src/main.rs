@@ -3,20 +3,98 @@ mod commands;
 mod mcp;
 
 // Re-export lib modules as crate-level so commands/cli/mcp can use crate::db, etc.
+pub use cartog::alerts;
+pub use cartog::aliases;
+pub use cartog::arch;
+pub use cartog::audit;
+pub use cartog::auth;
+pub use cartog::batch;
+pub use cartog::bench;
+pub use cartog::benchmarks;
+pub use cartog::capabilities;
+pub use cartog::changelog;
+pub use cartog::completions;
+pub use cartog::config;
+pub use cartog::config_keys;
+pub use cartog::constructs;
+pub use cartog::coverage;
+pub use cartog::ctx;
 pub use cartog::db;
+pub use cartog::deprecations;
+pub use cartog::deps_usage;
+pub use cartog::diff;
+pub use cartog::doc;
+pub use cartog::dupes;
+pub use cartog::errors;
+pub use cartog::explain;
+pub use cartog::federation;
+pub use cartog::git;
+pub use cartog::grep;
+pub use cartog::history;
+pub use cartog::hooks;
+pub use cartog::hotspots;
 pub use cartog::indexer;
+pub use cartog::init;
+pub use cartog::inits;
 pub use cartog::languages;
+pub use cartog::logs;
+pub use cartog::macros;
+pub use cartog::metrics;
+pub use cartog::otel;
+pub use cartog::owners;
+pub use cartog::pager;
+pub use cartog::panics;
+pub use cartog::pipe;
+pub use cartog::pipeline;
+pub use cartog::plugins;
+pub use cartog::pr;
+pub use cartog::profile;
 pub use cartog::rag;
+pub use cartog::rank;
+pub use cartog::ratelimit;
+pub use cartog::reachable;
+pub use cartog::recent;
+pub use cartog::sequence;
+pub use cartog::session;
+pub use cartog::snapshot;
+pub use cartog::stdlib;
+pub use cartog::table;
+pub use cartog::tests_for;
+pub use cartog::todos;
+pub use cartog::tour;
 pub use cartog::types;
+pub use cartog::unsafe_audit;
+pub use cartog::untested;
+pub use cartog::validate;
+pub use cartog::vendor;
+pub use cartog::warm;
 pub use cartog::watch;
+pub use cartog::why_depends;
+pub use cartog::wire;
 
-use anyhow::Result;
+use std::ffi::OsString;
+use std::path::Path;
+
+use anyhow::{bail, Context, Result};
 use clap::Parser;
+use tracing_subscriber::prelude::*;
+
+use cli::{
+    CheckCommand, Cli, Command, ConfigCommand, DepsCommand, DocCommand, ErrorsCommand,
+    MetricsCommand, PrCommand, ProfileCommand, RagCommand,
+};
+use db::SearchFilter;
+use grep::GrepOptions;
+use profile::SpanTrace;
 
-use cli::{Cli, Command, RagCommand};
+/// Counts heap usage for `cartog profile`; a pass-through otherwise. Only in
+/// `heap-profile` builds, since even the pass-through costs an atomic load.
+#[cfg(feature = "heap-profile")]
+#[global_allocator]
+static ALLOC: profile::CountingAlloc = profile::CountingAlloc;
 
 fn main() -> Result<()> {
-    let cli = Cli::parse();
+    let cli = Cli::parse_from(expand_alias(std::env::args_os().collect())?);
 
     let is_serve = matches!(cli.command, Command::Serve { .. });
     let is_watch = matches!(cli.command, Command::Watch { .. });
@@ -34,45 +112,478 @@ fn main() -> Result<()> {
     // - CLI mode: only warnings (e.g., unparseable files) show by default
     // - Serve / RAG index / Watch mode: info-level for progress
     // Stdout stays clean for CLI output and MCP protocol.
-    tracing_subscriber::fmt()
-        .with_writer(std::io::stderr)
-        .with_env_filter(
-            tracing_subscriber::EnvFilter::try_from_default_env()
-                .unwrap_or_else(|_| tracing_subscriber::EnvFilter::new(default_level)),
+    // `profile` additionally records every span, whatever the log level.
+    let span_trace = matches!(cli.command, Command::Profile(_)).then(SpanTrace::new);
+    // Spans also go to an OpenTelemetry collector when OTEL_EXPORTER_OTLP_* says so.
+    let otel_layer = otel::init();
+    let env_filter = tracing_subscriber::EnvFilter::try_from_default_env()
+        .unwrap_or_else(|_| tracing_subscriber::EnvFilter::new(default_level));
+    tracing_subscriber::registry()
+        .with(
+            tracing_subscriber::fmt::layer()
+                .with_writer(std::io::stderr)
+                .with_filter(env_filter),
         )
+        .with(span_trace.clone())
+        .with(otel_layer)
         .init();
 
-    match cli.command {
-        Command::Index { path, force } => commands::cmd_index(&path, force, cli.json),
-        Command::Outline { file } => commands::cmd_outline(&file, cli.json),
-        Command::Callees { name } => commands::cmd_callees(&name, cli.json),
-        Command::Impact { name, depth } => commands::cmd_impact(&name, depth, cli.json),
-        Command::Refs { name, kind } => commands::cmd_refs(&name, kind, cli.json),
-        Command::Hierarchy { name } => commands::cmd_hierarchy(&name, cli.json),
-        Command::Deps { file } => commands::cmd_deps(&file, cli.json),
-        Command::Stats => commands::cmd_stats(cli.json),
+    if cli.explain {
+        explain::enable();
+    }
+    if cli.allow_hooks {
+        hooks::allow();
+    }
+
+    let prefs = output_prefs();
+    if cli.ids {
+        anyhow::ensure!(
+            cli.command.writes_ids(),
+            "--ids applies to search, refs, callees, impact, rank, reachable, recent and untested"
+        );
+        pipe::enable_ids();
+    }
+    let json = !cli.ids && (cli.json || prefs.format == config::OutputFormat::Json);
+    let paged =
+        !json && !cli.ids && !cli.no_pager && prefs.pager && cli.command.pages() && pager::wanted();
+    let command = cli.command;
+    let run_command = move || {
+        // One trace per command; a server traces each tool call on its own instead.
+        let command_span = (!is_serve && !is_watch).then(|| {
+            let name = std::env::args().skip(1).find(|a| !a.starts_with('-'));
+            tracing::info_span!("command", name = name.as_deref().unwrap_or("")).entered()
+        });
+        let result = run(command, json, span_trace);
+        drop(command_span);
+        otel::shutdown();
+
+        if let Some(report) = explain::finish("output") {
+            commands::print_explain(report, json)?;
+        }
+        result
+    };
+    if paged {
+        pager::run_paged(run_command)
+    } else {
+        run_command()
+    }
+}
+
+/// The command line with an `[aliases]` name in command position expanded.
+/// Config is only read when that word is not a built-in command.
+fn expand_alias(args: Vec<OsString>) -> Result<Vec<OsString>> {
+    let Some(at) = aliases::command_position(&args) else {
+        return Ok(args);
+    };
+    let word = args[at].to_string_lossy().into_owned();
+    let cli = <Cli as clap::CommandFactory>::command();
+    if word == "help" || cli.find_subcommand(&word).is_some() {
+        return Ok(args);
+    }
+    let aliases = config::root_config(Path::new("."))?.aliases;
+    match aliases.get(&word) {
+        Some(definition) => aliases::expand(&args, at, definition)
+            .with_context(|| format!("invalid alias '{word}'")),
+        None => Ok(args),
+    }
+}
+
+/// `[output]` from the user config and `CARTOG_OUTPUT_*`, e.g. `format = "json"`.
+fn output_prefs() -> config::OutputSection {
+    match config::user_config() {
+        Ok(user) => user.output,
+        Err(e) => {
+            tracing::warn!(error = %format!("{e:#}"), "ignoring user config");
+            config::OutputSection::default()
+        }
+    }
+}
+
+fn run(command: Command, json: bool, span_trace: Option<SpanTrace>) -> Result<()> {
+    match command {
+        Command::Init {
+            path,
+            yes,
+            force,
+            mcp,
+        } => commands::cmd_init(
+            &path,
+            yes,
+            force,
+            mcp.into_iter().map(Into::into).collect(),
+            json,
+        ),
+        Command::Index {
+            path,
+            force,
+            swap,
+            dry_run,
+            jobs,
+            max_memory,
+        } => {
+            if dry_run {
+                commands::cmd_index_plan(&path, jobs, json)
+            } else {
+                commands::cmd_index(&path, force, swap, jobs, max_memory, json)
+            }
+        }
+        Command::Outline {
+            file,
+            package,
+            diff: Some(rev),
+            ..
+        } => commands::cmd_outline_diff(&rev, file.as_deref(), package.as_deref(), json),
+        Command::Outline {
+            file: Some(file),
+            with_blame,
+            tag,
+            ..
+        } => commands::cmd_outline(&file, with_blame, tag.as_deref(), json),
+        Command::Outline {
+            package,
+            format,
+            export,
+            ..
+        } => commands::cmd_outline_package(
+            package.as_deref().unwrap_or_default(),
+            format,
+            export.as_deref(),
+            json,
+        ),
+        Command::Callees {
+            name,
+            stdin_ids,
+            tag,
+        } => {
+            let targets = commands::query_targets(name, stdin_ids)?;
+            commands::cmd_callees(&targets, tag.as_deref(), json)
+        }
+        Command::Sequence { name, to, depth } => {
+            commands::cmd_sequence(&name, to.as_deref(), depth, json)
+        }
+        Command::Impact {
+            name,
+            stdin_ids,
+            depth,
+            tag,
+        } => {
+            let targets = commands::query_targets(name, stdin_ids)?;
+            commands::cmd_impact(&targets, depth, tag.as_deref(), json)
+        }
+        Command::Refs {
+            name,
+            globals_only: true,
+            tag,
+            ..
+        } => commands::cmd_refs_globals(name.as_deref(), tag.as_deref(), json),
+        Command::Refs {
+            name,
+            stdin_ids,
+            kind,
+            with_blame,
+            tag,
+            ..
+        } => {
+            let targets = commands::query_targets(name, stdin_ids)?;
+            commands::cmd_refs(&targets, kind, with_blame, tag.as_deref(), json)
+        }
+        Command::Hierarchy { name, doubles } => commands::cmd_hierarchy(&name, doubles, json),
+        Command::Deps { command, file } => match command {
+            Some(DepsCommand::Usage { module, std, limit }) => {
+                commands::cmd_deps_usage(module.as_deref(), std, limit, json)
+            }
+            None => commands::cmd_deps(file.as_deref().unwrap_or_default(), json),
+        },
+        Command::Stats => commands::cmd_stats(json),
+        Command::Tags { tag } => commands::cmd_tags(tag.as_deref(), json),
+        Command::Concurrency { name } => commands::cmd_concurrency(name.as_deref(), json),
+        Command::Flags { name, format } => commands::cmd_flags(name.as_deref(), format, json),
+        Command::Logs {
+            grep,
+            level,
+            depth,
+            format,
+        } => commands::cmd_logs(grep.as_deref(), level, depth, format, json),
+        Command::Todos {
+            marker,
+            owner,
+            older_than,
+            no_blame,
+            format,
+        } => commands::cmd_todos(marker, owner, older_than, !no_blame, format, json),
+        Command::Coverage { profile } => commands::cmd_coverage(&profile, json),
+        Command::Snapshot { tag, depends } => {
+            commands::cmd_snapshot(tag.as_deref(), depends.as_deref(), json)
+        }
+        Command::Deprecations {
+            owner,
+            record,
+            format,
+        } => commands::cmd_deprecations(owner.as_deref(), record, format, json),
+        Command::Benchmarks { name, depth } => {
+            commands::cmd_benchmarks(name.as_deref(), depth, json)
+        }
+        Command::TestsFor { name, depth, limit } => {
+            commands::cmd_tests_for(&name, depth, limit, json)
+        }
+        Command::Untested {
+            package,
+            depth,
+            limit,
+        } => commands::cmd_untested(package.as_deref(), depth, limit, json),
+        Command::Tour { budget, output } => commands::cmd_tour(budget, output.as_deref(), json),
+        Command::Routes { prefix, format } => commands::cmd_routes(prefix.as_deref(), format, json),
+        Command::Const { type_name } => commands::cmd_const(&type_name, json),
+        Command::Constructs { type_name } => commands::cmd_constructs(&type_name, json),
+        Command::Inits { package, all } => commands::cmd_inits(package.as_deref(), all, json),
+        Command::WhyDepends { from, to, limit } => {
+            commands::cmd_why_depends(&from, &to, limit, json)
+        }
+        Command::Reachable {
+            from,
+            unreachable,
+            format,
+        } => commands::cmd_reachable(&from, unreachable, format, json),
+        Command::ConfigKeys { name } => commands::cmd_config_keys(name.as_deref(), json),
+        Command::Dupes {
+            min_lines,
+            similarity,
+            limit,
+            format,
+        } => commands::cmd_dupes(min_lines, similarity, limit, format, json),
+        Command::Metrics(metrics_cmd) => match metrics_cmd {
+            MetricsCommand::Complexity {
+                top,
+                by,
+                file,
+                format,
+            } => commands::cmd_metrics_complexity(top, by, file.as_deref(), format, json),
+        },
+        Command::Doc(doc_cmd) => match doc_cmd {
+            DocCommand::Architecture {
+                output,
+                depth,
+                key_types,
+            } => commands::cmd_doc_architecture(output.as_deref(), depth, key_types, json),
+            DocCommand::Glossary { output, limit } => {
+                commands::cmd_doc_glossary(output.as_deref(), limit, json)
+            }
+            DocCommand::Dependencies {
+                update,
+                check,
+                depth,
+                externals,
+            } => commands::cmd_doc_dependencies(update.as_deref(), check, depth, externals, json),
+        },
+        Command::Macro { name, args } => commands::cmd_macro(name.as_deref(), &args, json),
+        Command::Config(config_cmd) => match config_cmd {
+            ConfigCommand::Validate { path } => commands::cmd_config_validate(&path, json),
+            ConfigCommand::Schema => commands::cmd_config_schema(),
+        },
+        Command::Check(check_cmd) => match check_cmd {
+            CheckCommand::Arch => commands::cmd_check_arch(json),
+            CheckCommand::Ctx { depth } => commands::cmd_check_ctx(depth, json),
+            CheckCommand::Deprecated { deps, max } => {
+                commands::cmd_check_deprecated(&deps, max, json)
+            }
+            CheckCommand::Unsafe { depth } => commands::cmd_check_unsafe(depth, json),
+        },
+        Command::Errors(errors_cmd) => match errors_cmd {
+            ErrorsCommand::Trace { name, depth } => commands::cmd_errors_trace(&name, depth, json),
+            ErrorsCommand::Lifecycle { name, depth } => {
+                commands::cmd_errors_lifecycle(&name, depth, json)
+            }
+            ErrorsCommand::Panics { from, tag, depth } => {
+                commands::cmd_errors_panics(&from, tag.as_deref(), depth, json)
+            }
+        },
+        Command::Diff { from, to } => commands::cmd_diff(&from, &to, json),
+        Command::Changelog { from, to, depth } => commands::cmd_changelog(&from, &to, depth, json),
+        Command::History { name, limit } => commands::cmd_history(&name, limit, json),
+        Command::Hotspots { by, since, limit } => {
+            commands::cmd_hotspots(by, since.as_deref(), limit, json)
+        }
+        Command::Recent { commits, depth } => commands::cmd_recent(commits, depth, json),
+        Command::Rank { top, kind, by } => commands::cmd_rank(top, kind, by, json),
+        Command::Pr(pr_cmd) => match pr_cmd {
+            PrCommand::Prepare { base, depth } => commands::cmd_pr_prepare(&base, depth, json),
+        },
         Command::Search {
             query,
             kind,
             file,
+            tag,
+            min_complexity,
+            package,
+            uncovered,
             limit,
-        } => commands::cmd_search(&query, kind, file.as_deref(), limit, cli.json),
+        } => commands::cmd_search(
+            query.as_deref().unwrap_or(""),
+            kind,
+            SearchFilter {
+                file: file.as_deref(),
+                tag: tag.as_deref(),
+                min_complexity,
+                package: package.as_deref(),
+                uncovered,
+                ..SearchFilter::default()
+            },
+            limit,
+            json,
+        ),
+        Command::Grep {
+            pattern,
+            paths,
+            ignore_case,
+            word_regexp,
+            files_with_matches,
+            scan,
+            recursive: _,
+            line_number: _,
+        } => commands::cmd_grep(
+            &pattern,
+            &paths,
+            GrepOptions {
+                ignore_case,
+                word: word_regexp,
+                scan,
+            },
+            files_with_matches,
+            json,
+        ),
         Command::Watch {
             path,
             debounce,
             rag,
             rag_delay,
         } => commands::cmd_watch(&path, debounce, rag, rag_delay),
-        Command::Serve { watch, rag } => {
+        Command::Serve {
+            watch,
+            rag,
+            mmap,
+            prewarm,
+            listen,
+            tokens,
+            tls_cert,
+            tls_key,
+            tls_client_ca,
+            capabilities,
+            qps,
+            max_concurrent,
+            metrics,
+            audit,
+            audit_max_mib,
+            audit_keep,
+            mounts,
+        } => {
+            let tokens = tokens
+                .map(|path| auth::Tokens::load(std::path::Path::new(&path)))
+                .transpose()?;
+            let tls = tls_cert.zip(tls_key).map(|(cert, key)| mcp::TlsConfig {
+                cert: cert.into(),
+                key: key.into(),
+                client_ca: tls_client_ca.map(Into::into),
+            });
+            let serve = mcp::ServeConfig {
+                watch,
+                rag,
+                read: mcp::ReadConfig {
+                    mmap_bytes: mmap.map(|mib| mib.saturating_mul(1024 * 1024)),
+                    prewarm,
+                },
+                listen,
+                tokens,
+                tls,
+                capabilities,
+                limits: ratelimit::Limits {
+                    qps: qps.filter(|q| *q > 0.0),
+                    concurrent: max_concurrent.filter(|c| *c > 0),
+                },
+                metrics,
+                audit: audit.map(|path| mcp::AuditConfig {
+                    path: path.into(),
+                    max_bytes: audit_max_mib.saturating_mul(1024 * 1024),
+                    keep: audit_keep,
+                }),
+                mounts,
+            };
             let runtime = tokio::runtime::Runtime::new()?;
-            runtime.block_on(mcp::run_server(watch, rag))
+            runtime.block_on(mcp::run_server(serve))
         }
         Command::Rag(rag_cmd) => match rag_cmd {
-            RagCommand::Setup => commands::cmd_rag_setup(cli.json),
-            RagCommand::Index { path, force } => commands::cmd_rag_index(&path, force, cli.json),
-            RagCommand::Search { query, kind, limit } => {
-                commands::cmd_rag_search(&query, kind, limit, cli.json)
-            }
+            RagCommand::Setup => commands::cmd_rag_setup(json),
+            RagCommand::Index { path, force } => commands::cmd_rag_index(&path, force, json),
+            RagCommand::Search {
+                query,
+                kind,
+                tag,
+                limit,
+            } => commands::cmd_rag_search(&query, kind, tag.as_deref(), limit, json),
         },
+        Command::Completions { shell } => commands::cmd_completions(shell.into()),
+        Command::Complete { prefix, limit } => commands::cmd_complete(&prefix, limit),
+        Command::Bench {
+            fixtures,
+            repo,
+            fixtures_dir,
+            baseline,
+            compare,
+            fail_on_regression,
+            iterations,
+            index_runs,
+            mutate,
+        } => {
+            // A saved report given as --baseline is compared like --compare.
+            let (baseline, compare) = match baseline {
+                Some(path) if path.ends_with(".json") => (None, Some(path)),
+                baseline => (baseline, compare),
+            };
+            commands::cmd_bench(
+                bench::BenchConfig {
+                    fixtures_dir: fixtures_dir.into(),
+                    fixtures,
+                    current: std::env::current_exe()?,
+                    baseline: baseline.map(Into::into),
+                    iterations,
+                    index_runs,
+                    mutate,
+                    repo,
+                },
+                compare.as_deref(),
+                fail_on_regression,
+                json,
+            )
+        }
+        Command::Profile(profile_cmd) => {
+            let trace = span_trace.unwrap_or_default();
+            match profile_cmd {
+                ProfileCommand::Index { path, force, out } => {
+                    let max_memory = pipeline::DEFAULT_MEMORY_CAP / (1024 * 1024);
+                    commands::cmd_profile("index", out.as_deref(), &trace, json, || {
+                        commands::cmd_index(&path, force, false, None, max_memory, json)
+                    })
+                }
+                ProfileCommand::Query { out, args } => {
+                    let inner =
+                        Cli::try_parse_from(std::iter::once("cartog".to_string()).chain(args))
+                            .unwrap_or_else(|e| e.exit());
+                    if matches!(
+                        inner.command,
+                        Command::Profile(_)
+                            | Command::Bench { .. }
+                            | Command::Serve { .. }
+                            | Command::Watch { .. }
+                    ) {
+                        bail!(
+                            "profile query expects a one-shot command such as `refs` or `search`"
+                        );
+                    }
+                    let json = json || inner.json;
+                    commands::cmd_profile("query", out.as_deref(), &trace, json, || {
+                        run(inner.command, json, None)
+                    })
+                }
+            }
+        }
     }
 }
@@ -1,6 +1,10 @@
+use std::collections::BTreeMap;
 use std::future::Future;
+use std::net::SocketAddr;
 use std::path::{Path, PathBuf};
-use std::sync::{Arc, Mutex};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, Mutex, MutexGuard};
+use std::time::{Duration, Instant};
 
 use rmcp::schemars;
 use rmcp::{
@@ -12,19 +16,51 @@ use rmcp::{
 };
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
-use tracing::{debug, info};
-
-use crate::db::{Database, DB_FILE, MAX_SEARCH_LIMIT};
+use tokio::io::{AsyncBufReadExt, AsyncRead, AsyncReadExt, AsyncWrite, AsyncWriteExt, BufReader};
+use tokio_rustls::rustls;
+use tokio_rustls::TlsAcceptor;
+use tracing::{debug, info, info_span};
+
+use crate::audit::{self, AuditEntry, AuditLog};
+use crate::auth::{self, Access, Tokens};
+use crate::batch;
+use crate::capabilities::{self, Capabilities, Capability};
+
+use crate::config::ProjectConfig;
+use crate::db::{self, Database, SearchFilter, TagFilter, DB_FILE, MAX_SEARCH_LIMIT};
+use crate::federation::{self, Mount, Repo, Repos};
+use crate::history;
 use crate::indexer;
+use crate::macros::MacroStep;
+use crate::metrics::{IndexGauges, Metrics};
+use crate::otel;
 use crate::rag;
+use crate::ratelimit::{Limits, Permit, RateLimiter};
+use crate::session::{self, Session, Sessions};
 use crate::types::EdgeKind;
+use crate::warm::{
+    self, HotSet, IndexSnapshot, WarmSnapshot, HOT_SET_CAPACITY, SNAPSHOT_FILE, WARM_FILE,
+};
 use crate::watch::{self, WatchConfig, WatchHandle};
 
 const MAX_IMPACT_DEPTH: u32 = 10;
 
+/// JSON-RPC error code of a query refused by rate limits, after HTTP's 429.
+const RATE_LIMITED: ErrorCode = ErrorCode(-32029);
+
+/// Tools that need more than reading the index, and what they need.
+const TOOL_CAPABILITIES: [(&str, Capability); 5] = [
+    ("cartog_index", Capability::Index),
+    ("cartog_rag_index", Capability::Index),
+    // Loading the embedding model downloads it on first use.
+    ("cartog_rag_index", Capability::Files),
+    ("cartog_rag_search", Capability::Files),
+    ("cartog_history", Capability::Shell),
+];
+
 // ── Parameter types ──
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct IndexParams {
     /// Directory to index relative to project root (defaults to ".")
     #[serde(default = "default_dot")]
@@ -32,65 +68,127 @@ pub struct IndexParams {
     /// Force full re-index, bypassing change detection
     #[serde(default)]
     pub force: bool,
+    /// Rebuild from scratch in the background and swap the new index in when
+    /// done; queries keep being answered from the current one meanwhile
+    #[serde(default)]
+    pub swap: bool,
 }
 
 fn default_dot() -> String {
     ".".to_string()
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct OutlineParams {
     /// File path relative to project root
     pub file: String,
+    /// Only symbols carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct RefsParams {
     /// Symbol name to find references for
     pub name: String,
     /// Filter by edge kind: calls, imports, inherits, references, raises
     pub kind: Option<String>,
+    /// Only references from symbols carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct CalleesParams {
     /// Symbol name to find callees of
     pub name: String,
+    /// Only callees carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
+pub struct MacroParams {
+    /// Macro name from .cartog.toml; omit to list the available macros
+    pub name: Option<String>,
+    /// Macro arguments, in the order of its params
+    #[serde(default)]
+    pub args: Vec<String>,
+}
+
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
+pub struct BatchParams {
+    /// Queries to run in order, written like macro steps: {"run": "search", "query": "user", "kind": "function", "limit": 5},
+    /// {"run": "refs", "name": "validate_token", "kind": "calls"}, {"run": "callees", "name": "X", "depth": 2},
+    /// {"run": "impact", "name": "X", "depth": 3}, {"run": "outline", "file": "a.py"}, {"run": "deps", "file": "a.py"},
+    /// {"run": "hierarchy", "name": "X"}; "files" keeps only hits in files matching a glob
+    pub queries: Vec<serde_json::Value>,
+    /// Estimated tokens all results together may take; hits past it are dropped
+    pub budget: Option<u32>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
+}
+
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct ImpactParams {
     /// Symbol name to analyze impact for
     pub name: String,
     /// Maximum traversal depth (default 3, max 10)
     pub depth: Option<u32>,
+    /// Only dependents carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct HierarchyParams {
     /// Class name to show hierarchy for
     pub name: String,
+    /// Generated mocks and fakes (gomock, mockery, counterfeiter): "include" (default), "exclude" or "only"
+    pub doubles: Option<String>,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct DepsParams {
     /// File path to show import dependencies for
     pub file: String,
+    /// Mounted repository to query (see `cartog serve --mount`); the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct SearchParams {
     /// Case-insensitive query string (prefix + substring match against symbol names)
     pub query: String,
-    /// Filter by symbol kind: function, class, method, variable, import
+    /// Filter by symbol kind: function, class, method, variable, import, flag
     pub kind: Option<String>,
     /// Filter to a specific file path relative to project root
     pub file: Option<String>,
+    /// Only symbols carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
+    /// Only functions and methods with at least this cyclomatic complexity
+    pub min_complexity: Option<u32>,
     /// Maximum results to return (default 30, max 100)
     pub limit: Option<u32>,
+    /// Mounted repository to query (see `cartog serve --mount`), or "*" for all of them; the server's own index when omitted
+    pub repo: Option<String>,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
+pub struct HistoryParams {
+    /// Symbol name (or `Parent.name` to disambiguate methods)
+    pub name: String,
+    /// Maximum commits per definition (default 20)
+    pub limit: Option<u32>,
+}
+
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct RagIndexParams {
     /// Directory to index relative to project root (defaults to ".")
     #[serde(default = "default_dot")]
@@ -100,16 +198,33 @@ pub struct RagIndexParams {
     pub force: bool,
 }
 
-#[derive(Debug, Deserialize, JsonSchema)]
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
 pub struct RagSearchParams {
     /// Natural language query for semantic code search
     pub query: String,
     /// Filter by symbol kind: function, class, method, variable
     pub kind: Option<String>,
+    /// Only symbols carrying this tag, from [tags] in .cartog.toml
+    pub tag: Option<String>,
     /// Maximum results to return (default 10)
     pub limit: Option<u32>,
 }
 
+#[derive(Debug, Serialize, Deserialize, JsonSchema)]
+pub struct SessionParams {
+    /// Path prefix that refs, impact and search results are kept within; "" clears it
+    pub scope: Option<String>,
+    /// Tag applied to tool calls that pass none; "" clears it
+    pub tag: Option<String>,
+    /// Estimated response tokens this session may receive; 0 removes the budget
+    pub budget: Option<u32>,
+    /// Leave symbols already returned out of later search results
+    pub dedup: Option<bool>,
+    /// Forget the tokens spent and the symbols seen so far
+    #[serde(default)]
+    pub reset: bool,
+}
+
 // ── Response wrappers for JSON serialization ──
 
 #[derive(Debug, Serialize)]
@@ -128,6 +243,9 @@ struct ImpactEntry {
 struct HierarchyEntry {
     child: String,
     parent: String,
+    /// Generator of a mock or fake child.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    double: Option<String>,
 }
 
 // ── Path validation ──
@@ -193,8 +311,34 @@ fn mcp_err(msg: impl std::fmt::Display) -> McpError {
     McpError::internal_error(msg.to_string(), None)
 }
 
-/// Build a JSON text response, appending a hint if the DB has no indexed files.
-fn json_response(db: &Database, json: String) -> Result<CallToolResult, McpError> {
+/// The symbols a `tag` parameter keeps; everything when it is unset.
+fn tag_filter(db: &Database, tag: Option<&str>) -> Result<TagFilter, McpError> {
+    let _plan = info_span!("plan", tag).entered();
+    db.tag_filter(tag)
+        .map_err(|e| mcp_err(format!("tag lookup failed: {e}")))
+}
+
+/// Serialize a tool's result, traced as the `format` stage.
+fn to_json<T: Serialize + ?Sized>(value: &T) -> Result<String, McpError> {
+    let _format = info_span!("format").entered();
+    serde_json::to_string_pretty(value).map_err(|e| mcp_err(format!("serialization failed: {e}")))
+}
+
+fn lock_session(session: &Mutex<Session>) -> MutexGuard<'_, Session> {
+    session.lock().unwrap_or_else(|e| e.into_inner())
+}
+
+/// Build a JSON text response charged to the session's budget, appending a hint
+/// if the DB has no indexed files.
+fn json_response(
+    db: &Database,
+    root: &Path,
+    session: &Mutex<Session>,
+    json: String,
+) -> Result<CallToolResult, McpError> {
+    lock_session(session)
+        .charge(&json)
+        .map_err(|e| McpError::invalid_request(e, None))?;
     // Single lightweight check instead of full stats() (which runs 4 COUNT queries).
     let is_empty = !db
         .has_indexed_files()
@@ -204,6 +348,12 @@ fn json_response(db: &Database, json: String) -> Result<CallToolResult, McpError
         Ok(CallToolResult::success(vec![Content::text(format!(
             "{json}{hint}"
         ))]))
+    } else if let Ok(Some(stale)) = indexer::staleness(db, root) {
+        // Branch switch or new commits since the last index: results may be outdated.
+        let hint = format!("\n\n(Stale index: {stale}. Run cartog_index to refresh.)");
+        Ok(CallToolResult::success(vec![Content::text(format!(
+            "{json}{hint}"
+        ))]))
     } else {
         Ok(CallToolResult::success(vec![Content::text(json)]))
     }
@@ -216,53 +366,220 @@ pub struct CartogServer {
     tool_router: ToolRouter<Self>,
     /// Shared database connection, opened once at server start.
     db: Arc<Mutex<Database>>,
+    /// Where that database lives, for the connections opened beside it.
+    db_path: Arc<Path>,
     /// Canonicalized CWD captured at server start to avoid repeated syscalls.
     /// Wrapped in `Arc` so clones (required by `#[derive(Clone)]`) are cheap.
     cwd: Arc<Path>,
+    /// Files and names asked about, persisted to [`WARM_FILE`] on shutdown.
+    hot: Arc<Mutex<HotSet>>,
+    /// The local index's symbol table and import graph, once loaded from
+    /// [`SNAPSHOT_FILE`]; dropped when the index is rebuilt.
+    snapshot: Arc<Mutex<Option<Arc<IndexSnapshot>>>>,
+    /// `.cartog.toml` settings, loaded once at server start.
+    config: Arc<ProjectConfig>,
+    /// All clients' sessions, shared by every connection.
+    sessions: Arc<Sessions>,
+    /// This client's session.
+    session: Arc<Mutex<Session>>,
+    /// What the server may do besides reading the index.
+    capabilities: Capabilities,
+    /// Query limits shared by all clients.
+    limiter: Arc<RateLimiter>,
+    /// Tool call counts and latencies, for `--metrics`.
+    metrics: Arc<Metrics>,
+    /// Where answered tool calls are logged, with `--audit`.
+    audit: Option<Arc<AuditLog>>,
+    /// Set while a swapped rebuild is running.
+    rebuilding: Arc<AtomicBool>,
+    /// Index generations swapped in since the server started.
+    generation: Arc<AtomicU64>,
+    /// The server's own index and those mounted with `--mount`.
+    repos: Arc<Repos>,
+}
+
+const MIB: u64 = 1024 * 1024;
+
+/// Floor for the automatic mmap size, matching the CLI default.
+const MIN_AUTO_MMAP: u64 = 256 * MIB;
+
+/// Read-path tuning for the server's long-lived connection.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct ReadConfig {
+    /// Bytes to memory-map; `None` sizes the map from the index file.
+    pub mmap_bytes: Option<u64>,
+    /// Read the index file once at startup so its pages are already cached.
+    pub prewarm: bool,
+}
+
+/// Map the whole index with room to double (a watched index keeps growing),
+/// never less than the CLI default.
+fn auto_mmap_bytes(db_bytes: u64) -> u64 {
+    let doubled = db_bytes.saturating_mul(2);
+    ((doubled + MIB - 1) / MIB * MIB).max(MIN_AUTO_MMAP)
 }
 
 #[tool_router]
 impl CartogServer {
     pub fn new() -> anyhow::Result<Self> {
-        let db =
-            Database::open(DB_FILE).map_err(|e| anyhow::anyhow!("failed to open database: {e}"))?;
+        Self::with_config(
+            ReadConfig::default(),
+            Capabilities::default(),
+            Limits::default(),
+        )
+    }
+
+    /// A server reading through `read` and limited to `capabilities`: tools
+    /// needing anything else are not listed, and without `index` the database is
+    /// opened read-only. Clients are held to `limits` unless their token sets
+    /// its own.
+    pub fn with_config(
+        read: ReadConfig,
+        capabilities: Capabilities,
+        limits: Limits,
+    ) -> anyhow::Result<Self> {
+        let db = if capabilities.allows(Capability::Index) {
+            Database::open(DB_FILE)
+        } else {
+            Database::open_read_only(DB_FILE)
+        }
+        .map_err(|e| anyhow::anyhow!("failed to open database: {e}"))?;
+        if otel::is_enabled() {
+            db.enable_otel();
+        }
+        let db_bytes = std::fs::metadata(DB_FILE).map(|m| m.len()).unwrap_or(0);
+        let requested = read.mmap_bytes.unwrap_or_else(|| auto_mmap_bytes(db_bytes));
+        let applied = db.set_mmap_size(requested)?;
+        info!(db_bytes, requested, applied, "configured mmap read path");
+        if read.prewarm {
+            let warmed = db::prewarm_file(DB_FILE)?;
+            info!(bytes = warmed, "prewarmed index pages");
+        }
         let cwd = std::env::current_dir()
             .and_then(|p| p.canonicalize())
             .map_err(|e| anyhow::anyhow!("cannot determine CWD: {e}"))?;
+        let hot =
+            HotSet::from_snapshot(&WarmSnapshot::load(Path::new(WARM_FILE)), HOT_SET_CAPACITY);
+        let config = ProjectConfig::load(&cwd)?;
+        let sessions = Arc::new(Sessions::default());
+        let session = Arc::new(Mutex::new(sessions.open()));
+        let mut tool_router = Self::tool_router();
+        for (tool, capability) in TOOL_CAPABILITIES {
+            if !capabilities.allows(capability) {
+                tool_router.remove_route(tool);
+            }
+        }
+        let db = Arc::new(Mutex::new(db));
+        let db_path: Arc<Path> = Arc::from(cwd.join(DB_FILE));
+        let cwd: Arc<Path> = Arc::from(cwd);
+        let repos = Arc::new(Repos::new(Arc::clone(&cwd), Arc::clone(&db)));
+        Ok(Self {
+            tool_router,
+            db,
+            db_path,
+            cwd,
+            hot: Arc::new(Mutex::new(hot)),
+            snapshot: Arc::new(Mutex::new(None)),
+            config: Arc::new(config),
+            sessions,
+            session,
+            capabilities,
+            limiter: Arc::new(RateLimiter::new(limits)),
+            metrics: Arc::new(Metrics::default()),
+            audit: None,
+            rebuilding: Arc::new(AtomicBool::new(false)),
+            generation: Arc::new(AtomicU64::new(0)),
+            repos,
+        })
+    }
+
+    /// Log every answered tool call to `audit`.
+    pub fn with_audit(self, audit: AuditLog) -> Self {
+        Self {
+            audit: Some(Arc::new(audit)),
+            ..self
+        }
+    }
+
+    /// Also answer queries from the indexes of `mounts`.
+    pub fn with_mounts(self, mounts: &[Mount]) -> anyhow::Result<Self> {
+        let mut repos = Repos::clone(&self.repos);
+        for mount in mounts {
+            repos.mount(mount)?;
+            info!(repo = %mount.name, root = %mount.root.display(), "mounted repository");
+        }
         Ok(Self {
-            tool_router: Self::tool_router(),
-            db: Arc::new(Mutex::new(db)),
-            cwd: Arc::from(cwd),
+            repos: Arc::new(repos),
+            ..self
         })
     }
 
+    /// The index a tool call's `repo` names.
+    fn repo(&self, repo: Option<&str>) -> Result<Repo, McpError> {
+        self.repos.get(repo).cloned().map_err(mcp_err)
+    }
+
+    /// A server for another client: same index and settings, fresh session.
+    pub fn for_client(&self) -> Self {
+        let mut session = self.sessions.open();
+        session.limits = self.limiter.defaults();
+        Self {
+            session: Arc::new(Mutex::new(session)),
+            ..self.clone()
+        }
+    }
+
     /// Build or rebuild the code graph index for a directory.
     #[tool(
-        description = "Build or rebuild the code graph index. Indexes source files with tree-sitter, extracts symbols and edges, stores in SQLite. Incremental by default (only re-indexes changed files)."
+        description = "Build or rebuild the code graph index. Indexes source files with tree-sitter, extracts symbols and edges, stores in SQLite. Incremental by default (only re-indexes changed files). With swap, rebuilds in the background and returns at once; queries are answered from the current index until the new one is swapped in."
     )]
     async fn cartog_index(
         &self,
         Parameters(params): Parameters<IndexParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        self.permit("cartog_index")?;
+        self.require(Access::Admin, "cartog_index")?;
+        let _admitted = self.admit()?;
         let path = params.path;
         let force = params.force;
+        let swap = params.swap;
         let db = Arc::clone(&self.db);
         let cwd = Arc::clone(&self.cwd);
-
-        tokio::task::spawn_blocking(move || {
-            let validated = validate_path_within_cwd_canonical(&path, &cwd).map_err(mcp_err)?;
+        let rebuilding = Arc::clone(&self.rebuilding);
+        let rebuild = self.rebuild();
+
+        self.blocking("cartog_index", args, move || {
+            let validated = info_span!("parse")
+                .in_scope(|| validate_path_within_cwd_canonical(&path, &cwd))
+                .map_err(mcp_err)?;
+            if swap {
+                let Some(claim) = Rebuilding::claim(&rebuilding) else {
+                    return Err(mcp_err("a rebuild is already running"));
+                };
+                let next = rebuild.generation.load(Ordering::Acquire) + 1;
+                info!(path = %validated.display(), generation = next, "rebuilding index");
+                rebuild.spawn(validated, claim);
+                let json = to_json(&serde_json::json!({
+                    "generation": next,
+                    "status": "building",
+                }))?;
+                return Ok(CallToolResult::success(vec![Content::text(json)]));
+            }
+            if rebuilding.load(Ordering::Acquire) {
+                return Err(mcp_err("a rebuild is running; retry once it is swapped in"));
+            }
             debug!(path = %validated.display(), force, "indexing directory");
 
+            let _writer = indexer::write_lock();
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
             let result = indexer::index_directory(&db, &validated, force)
                 .map_err(|e| mcp_err(format!("indexing failed: {e}")))?;
 
-            let json = serde_json::to_string_pretty(&result)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
+            let json = to_json(&result)?;
             Ok(CallToolResult::success(vec![Content::text(json)]))
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Show symbols and structure of a file without reading its content.
@@ -273,22 +590,33 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<OutlineParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let file = params.file;
-        let db = Arc::clone(&self.db);
-
-        tokio::task::spawn_blocking(move || {
-            debug!(file = %file, "outline");
+        let tag = self.session_tag(params.tag);
+        self.touch_file(&file);
+        let repo = self.repo(params.repo.as_deref())?;
+        let snapshot = self.snapshot_of(&repo);
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_outline", args, move || {
+            debug!(file = %file, tag = ?tag, "outline");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
-            let symbols = db
-                .outline(&file)
-                .map_err(|e| mcp_err(format!("outline query failed: {e}")))?;
+            let mut symbols = match snapshot.and_then(|s| current_snapshot(&s, &db)) {
+                Some(snapshot) => snapshot.outline(&file),
+                None => db
+                    .outline(&file)
+                    .map_err(|e| mcp_err(format!("outline query failed: {e}")))?,
+            };
+            let tagged = tag_filter(&db, tag.as_deref())?;
+            symbols.retain(|sym| tagged.keeps(&sym.id));
 
-            let json = serde_json::to_string_pretty(&symbols)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&symbols)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Find all references to a symbol (calls, imports, inherits, type references, raises).
@@ -299,11 +627,19 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<RefsParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let name = params.name;
+        self.touch_name(&name);
         let kind_str = params.kind;
-        let db = Arc::clone(&self.db);
-
-        tokio::task::spawn_blocking(move || {
+        let tag = self.session_tag(params.tag);
+        let repo = self.repo(params.repo.as_deref())?;
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_refs", args, move || {
+            let parse = info_span!("parse").entered();
             let kind_filter = kind_str
                 .as_deref()
                 .map(|s| {
@@ -315,24 +651,28 @@ impl CartogServer {
                     })
                 })
                 .transpose()?;
+            drop(parse);
 
             debug!(name = %name, kind = ?kind_filter, "refs");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
             let results = db
                 .refs(&name, kind_filter)
                 .map_err(|e| mcp_err(format!("refs query failed: {e}")))?;
+            let tagged = tag_filter(&db, tag.as_deref())?;
+            let scoped = lock_session(&session);
 
             let entries: Vec<RefEntry> = results
                 .into_iter()
+                .filter(|(edge, _)| tagged.keeps(&edge.source_id))
+                .filter(|(edge, _)| scoped.in_scope(&edge.file_path))
                 .map(|(edge, sym)| RefEntry { edge, source: sym })
                 .collect();
+            drop(scoped);
 
-            let json = serde_json::to_string_pretty(&entries)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&entries)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Find what a symbol calls.
@@ -343,22 +683,108 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<CalleesParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let name = params.name;
-        let db = Arc::clone(&self.db);
-
-        tokio::task::spawn_blocking(move || {
-            debug!(name = %name, "callees");
+        let tag = self.session_tag(params.tag);
+        self.touch_name(&name);
+        let repo = self.repo(params.repo.as_deref())?;
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_callees", args, move || {
+            debug!(name = %name, tag = ?tag, "callees");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
-            let edges = db
+            let mut edges = db
                 .callees(&name)
                 .map_err(|e| mcp_err(format!("callees query failed: {e}")))?;
+            if tag.is_some() {
+                let tagged = tag_filter(&db, tag.as_deref())?;
+                edges.retain(|e| e.target_id.as_deref().is_some_and(|id| tagged.keeps(id)));
+            }
 
-            let json = serde_json::to_string_pretty(&edges)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&edges)?;
+            json_response(&db, &root, &session, json)
+        })
+        .await
+    }
+
+    /// Run a user-defined query macro.
+    #[tool(
+        description = "Run a query macro defined in the project's .cartog.toml. Macros chain built-in queries (search, callees, refs, impact, ...) under one name. Omit name to list macros with their params and descriptions."
+    )]
+    async fn cartog_macro(
+        &self,
+        Parameters(params): Parameters<MacroParams>,
+    ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
+        let db = Arc::clone(&self.db);
+        let config = Arc::clone(&self.config);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_macro", args, move || {
+            let macros = config.macros();
+            let json = match params.name {
+                None => to_json(macros)?,
+                Some(name) => {
+                    debug!(name = %name, args = ?params.args, "macro");
+                    let def = macros
+                        .get(&name)
+                        .ok_or_else(|| mcp_err(format!("no macro named '{name}'")))?;
+                    let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
+                    let result = crate::macros::run(&db, &name, def, &params.args)
+                        .map_err(|e| mcp_err(format!("{e:#}")))?;
+                    let json = to_json(&result)?;
+                    return json_response(&db, Path::new("."), &session, json);
+                }
+            };
+            Ok(CallToolResult::success(vec![Content::text(json)]))
+        })
+        .await
+    }
+
+    /// Several queries in one round trip.
+    #[tool(
+        description = "Run up to 16 queries (search, refs, callees, impact, outline, deps, hierarchy) in one call. Hits an earlier query returned are left out of later ones, and an optional token budget caps all results together. Use when a step needs several related lookups."
+    )]
+    async fn cartog_batch(
+        &self,
+        Parameters(params): Parameters<BatchParams>,
+    ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
+        let repo = self.repo(params.repo.as_deref())?;
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_batch", args, move || {
+            let steps = info_span!("parse").in_scope(|| {
+                params
+                    .queries
+                    .into_iter()
+                    .enumerate()
+                    .map(|(i, query)| {
+                        serde_json::from_value::<MacroStep>(query)
+                            .map_err(|e| mcp_err(format!("query {}: {e}", i + 1)))
+                    })
+                    .collect::<Result<Vec<_>, _>>()
+            })?;
+            debug!(queries = steps.len(), budget = ?params.budget, "batch");
+            let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
+            let scoped = lock_session(&session);
+            let result = batch::run(&db, &steps, params.budget, |hit| {
+                hit.file_path.is_empty() || scoped.in_scope(&hit.file_path)
+            })
+            .map_err(|e| mcp_err(format!("{e:#}")))?;
+            drop(scoped);
+
+            let json = to_json(&result)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Transitive impact analysis — what breaks if this symbol changes?
@@ -369,59 +795,95 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<ImpactParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let name = params.name;
+        self.touch_name(&name);
         let depth = params.depth.unwrap_or(3).min(MAX_IMPACT_DEPTH);
-        let db = Arc::clone(&self.db);
+        let tag = self.session_tag(params.tag);
+        let repo = self.repo(params.repo.as_deref())?;
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
 
-        tokio::task::spawn_blocking(move || {
+        self.blocking("cartog_impact", args, move || {
             debug!(name = %name, depth, "impact");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
             let results = db
                 .impact(&name, depth)
                 .map_err(|e| mcp_err(format!("impact query failed: {e}")))?;
+            let tagged = tag_filter(&db, tag.as_deref())?;
+            let scoped = lock_session(&session);
 
             let entries: Vec<ImpactEntry> = results
                 .into_iter()
+                .filter(|(edge, _)| tagged.keeps(&edge.source_id))
+                .filter(|(edge, _)| scoped.in_scope(&edge.file_path))
                 .map(|(edge, d)| ImpactEntry { edge, depth: d })
                 .collect();
+            drop(scoped);
 
-            let json = serde_json::to_string_pretty(&entries)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&entries)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Show inheritance hierarchy for a class.
     #[tool(
-        description = "Show inheritance hierarchy for a class. Returns parent-child relationships for the given class name."
+        description = "Show inheritance hierarchy for a class. Returns parent-child relationships for the given class name. Generated Go mocks and fakes are marked with their generator (`double`); `doubles` keeps or drops them."
     )]
     async fn cartog_hierarchy(
         &self,
         Parameters(params): Parameters<HierarchyParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let name = params.name;
-        let db = Arc::clone(&self.db);
+        self.touch_name(&name);
+        let doubles = params.doubles.unwrap_or_else(|| "include".to_string());
+        if !matches!(doubles.as_str(), "include" | "exclude" | "only") {
+            return Err(mcp_err("invalid doubles. Valid: include, exclude, only"));
+        }
+        let repo = self.repo(params.repo.as_deref())?;
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
 
-        tokio::task::spawn_blocking(move || {
+        self.blocking("cartog_hierarchy", args, move || {
             debug!(name = %name, "hierarchy");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
             let pairs = db
                 .hierarchy(&name)
                 .map_err(|e| mcp_err(format!("hierarchy query failed: {e}")))?;
+            let fakes = db
+                .test_doubles(&name)
+                .map_err(|e| mcp_err(format!("hierarchy query failed: {e}")))?;
 
             let entries: Vec<HierarchyEntry> = pairs
                 .into_iter()
-                .map(|(child, parent)| HierarchyEntry { child, parent })
+                .map(|(child, parent)| {
+                    let double = fakes
+                        .iter()
+                        .find(|(sym, d)| sym.name == child && d.interface == parent)
+                        .map(|(_, d)| d.generator.clone());
+                    HierarchyEntry {
+                        child,
+                        parent,
+                        double,
+                    }
+                })
+                .filter(|e| match doubles.as_str() {
+                    "exclude" => e.double.is_none(),
+                    "only" => e.double.is_some(),
+                    _ => true,
+                })
                 .collect();
 
-            let json = serde_json::to_string_pretty(&entries)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&entries)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// File-level import dependencies.
@@ -432,43 +894,64 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<DepsParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let file = params.file;
-        let db = Arc::clone(&self.db);
-
-        tokio::task::spawn_blocking(move || {
+        self.touch_file(&file);
+        let repo = self.repo(params.repo.as_deref())?;
+        let snapshot = self.snapshot_of(&repo);
+        let db = Arc::clone(&repo.db);
+        let root = Arc::clone(&repo.root);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_deps", args, move || {
             debug!(file = %file, "deps");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
-            let edges = db
-                .file_deps(&file)
-                .map_err(|e| mcp_err(format!("deps query failed: {e}")))?;
+            let edges = match snapshot.and_then(|s| current_snapshot(&s, &db)) {
+                Some(snapshot) => snapshot.file_deps(&file),
+                None => db
+                    .file_deps(&file)
+                    .map_err(|e| mcp_err(format!("deps query failed: {e}")))?,
+            };
 
-            let json = serde_json::to_string_pretty(&edges)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let json = to_json(&edges)?;
+            json_response(&db, &root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Search for symbols by name — use this to discover exact names before calling refs/callees/impact.
     #[tool(
         description = "Search symbols by name (case-insensitive prefix + substring match). \
                        Use to discover symbol names before calling refs/callees/impact. \
-                       Optionally filter by kind (function|class|method|variable|import) or file path. \
-                       Returns up to 100 results ranked: exact match → prefix → substring."
+                       Optionally filter by kind (function|class|method|variable|import|flag) or file path. \
+                       Returns up to 100 results ranked: exact match → prefix → substring. \
+                       With repo \"*\", searches every mounted repository and tags each result with its repo."
     )]
     async fn cartog_search(
         &self,
         Parameters(params): Parameters<SearchParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        let _admitted = self.admit()?;
         let query = params.query;
         let kind_str = params.kind;
         let file = params.file;
+        let tag = self.session_tag(params.tag);
+        let min_complexity = params.min_complexity;
         let limit = params.limit.unwrap_or(30).min(MAX_SEARCH_LIMIT);
-        let db = Arc::clone(&self.db);
-        let cwd = Arc::clone(&self.cwd);
-
-        tokio::task::spawn_blocking(move || {
+        let federated = params.repo.as_deref() == Some(federation::ALL);
+        let repos: Vec<Repo> = self
+            .repos
+            .select(params.repo.as_deref())
+            .map_err(mcp_err)?
+            .into_iter()
+            .cloned()
+            .collect();
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_search", args, move || {
+            let parse = info_span!("parse").entered();
             if query.is_empty() {
                 return Err(mcp_err("query cannot be empty"));
             }
@@ -478,33 +961,71 @@ impl CartogServer {
                 .map(|s| {
                     s.parse::<crate::types::SymbolKind>().map_err(|_| {
                         mcp_err(
-                            "invalid symbol kind. Valid: function, class, method, variable, import",
+                            "invalid symbol kind. Valid: function, class, method, variable, import, flag",
                         )
                     })
                 })
                 .transpose()?;
 
+            if federated && file.is_some() {
+                return Err(mcp_err("file filter needs a single repo, not \"*\""));
+            }
             // Validate file path is within CWD — consistent with cartog_outline / cartog_deps.
             let validated_file: Option<String> = file
                 .map(|f| {
-                    validate_path_within_cwd_canonical(&f, &cwd)
+                    validate_path_within_cwd_canonical(&f, &repos[0].root)
                         .map_err(mcp_err)
                         .map(|p| p.to_string_lossy().into_owned())
                 })
                 .transpose()?;
-            let file_filter = validated_file.as_deref();
-            debug!(query = %query, kind = ?kind_filter, limit, "search");
-            let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
-            let symbols = db
-                .search(&query, kind_filter, file_filter, limit)
+            let filter = SearchFilter {
+                kind: kind_filter,
+                file: validated_file.as_deref(),
+                tag: tag.as_deref(),
+                min_complexity,
+                ..SearchFilter::default()
+            };
+            drop(parse);
+            debug!(query = %query, ?filter, limit, repos = repos.len(), "search");
+            if federated {
+                let mut results = Vec::with_capacity(repos.len());
+                for repo in &repos {
+                    let db = repo.db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
+                    let symbols = db.search_filtered(&query, &filter, limit).map_err(|e| {
+                        mcp_err(format!("search of repo '{}' failed: {e}", repo.name))
+                    })?;
+                    results.push((repo.name.clone(), symbols));
+                }
+                let mut found = federation::merge_ranked(&query, results, limit as usize);
+                let mut seen = lock_session(&session);
+                found.retain(|r| {
+                    seen.in_scope(&r.symbol.file_path)
+                        && seen.first_sight(&federation::qualified_id(&r.repo, &r.symbol.id))
+                });
+                drop(seen);
+
+                let json = to_json(&found)?;
+                lock_session(&session)
+                    .charge(&json)
+                    .map_err(|e| McpError::invalid_request(e, None))?;
+                return Ok(CallToolResult::success(vec![Content::text(json)]));
+            }
+            let repo = &repos[0];
+            let db = repo.db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
+            let mut symbols = db
+                .search_filtered(&query, &filter, limit)
                 .map_err(|e| mcp_err(format!("search failed: {e}")))?;
-
-            let json = serde_json::to_string_pretty(&symbols)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            let mut seen = lock_session(&session);
+            symbols.retain(|sym| {
+                seen.in_scope(&sym.file_path)
+                    && seen.first_sight(&federation::qualified_id(&repo.name, &sym.id))
+            });
+            drop(seen);
+
+            let json = to_json(&symbols)?;
+            json_response(&db, &repo.root, &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Index statistics summary.
@@ -512,21 +1033,49 @@ impl CartogServer {
         description = "Show index statistics: file count, symbol count, edge count, resolution rate, breakdown by language and symbol kind."
     )]
     async fn cartog_stats(&self) -> Result<CallToolResult, McpError> {
+        let _admitted = self.admit()?;
         let db = Arc::clone(&self.db);
 
-        tokio::task::spawn_blocking(move || {
+        self.blocking("cartog_stats", serde_json::Value::Null, move || {
             debug!("stats");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
             let stats = db
                 .stats()
                 .map_err(|e| mcp_err(format!("stats query failed: {e}")))?;
 
-            let json = serde_json::to_string_pretty(&stats)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
+            let json = to_json(&stats)?;
             Ok(CallToolResult::success(vec![Content::text(json)]))
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
+    }
+
+    /// Commits that modified a symbol.
+    #[tool(
+        description = "List the git commits that modified a symbol (author, date, message), newest first. Traces the symbol's current line range with git log -L. Use to answer when and why a function changed."
+    )]
+    async fn cartog_history(
+        &self,
+        Parameters(params): Parameters<HistoryParams>,
+    ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        self.permit("cartog_history")?;
+        let _admitted = self.admit()?;
+        let name = params.name;
+        let limit = params.limit.unwrap_or(20).min(MAX_SEARCH_LIMIT);
+        let db = Arc::clone(&self.db);
+        let cwd = Arc::clone(&self.cwd);
+        let session = Arc::clone(&self.session);
+
+        self.blocking("cartog_history", args, move || {
+            debug!(name = %name, limit, "history");
+            let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
+            let histories = history::symbol_history(&db, &cwd, &name, limit)
+                .map_err(|e| mcp_err(format!("history query failed: {e}")))?;
+
+            let json = to_json(&histories)?;
+            json_response(&db, Path::new("."), &session, json)
+        })
+        .await
     }
 
     /// Build embedding index for semantic code search.
@@ -537,13 +1086,19 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<RagIndexParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        self.permit("cartog_rag_index")?;
+        self.require(Access::Admin, "cartog_rag_index")?;
+        let _admitted = self.admit()?;
         let path = params.path;
         let force = params.force;
         let db = Arc::clone(&self.db);
         let cwd = Arc::clone(&self.cwd);
 
-        tokio::task::spawn_blocking(move || {
-            let validated = validate_path_within_cwd_canonical(&path, &cwd).map_err(mcp_err)?;
+        self.blocking("cartog_rag_index", args, move || {
+            let validated = info_span!("parse")
+                .in_scope(|| validate_path_within_cwd_canonical(&path, &cwd))
+                .map_err(mcp_err)?;
             debug!(path = %validated.display(), force, "rag index");
 
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
@@ -555,12 +1110,10 @@ impl CartogServer {
             let result = rag::indexer::index_embeddings(&db, force)
                 .map_err(|e| mcp_err(format!("embedding indexing failed: {e}")))?;
 
-            let json = serde_json::to_string_pretty(&result)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
+            let json = to_json(&result)?;
             Ok(CallToolResult::success(vec![Content::text(json)]))
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
     }
 
     /// Semantic search over code symbols using hybrid FTS5 + vector search.
@@ -571,12 +1124,18 @@ impl CartogServer {
         &self,
         Parameters(params): Parameters<RagSearchParams>,
     ) -> Result<CallToolResult, McpError> {
+        let args = self.audit_args(&params);
+        self.permit("cartog_rag_search")?;
+        let _admitted = self.admit()?;
         let query = params.query;
         let kind_str = params.kind;
+        let tag = self.session_tag(params.tag);
         let limit = params.limit.unwrap_or(10).min(MAX_SEARCH_LIMIT);
         let db = Arc::clone(&self.db);
+        let config = Arc::clone(&self.config);
+        let session = Arc::clone(&self.session);
 
-        tokio::task::spawn_blocking(move || {
+        self.blocking("cartog_rag_search", args, move || {
             if query.is_empty() {
                 return Err(mcp_err("query cannot be empty"));
             }
@@ -584,42 +1143,73 @@ impl CartogServer {
             debug!(query = %query, kind = ?kind_str, limit, "rag search");
             let db = db.lock().map_err(|_| mcp_err("database lock poisoned"))?;
 
+            let parse = info_span!("parse").entered();
             let kind_filter = match kind_str {
                 Some(kind_s) => {
                     let kind = kind_s.parse::<crate::types::SymbolKind>().map_err(|_| {
                         mcp_err(
-                            "invalid symbol kind. Valid: function, class, method, variable, import",
+                            "invalid symbol kind. Valid: function, class, method, variable, import, flag",
                         )
                     })?;
                     Some(kind)
                 }
                 None => None,
             };
-
-            let result = rag::search::hybrid_search(&db, &query, limit, kind_filter)
-                .map_err(|e| mcp_err(format!("semantic search failed: {e}")))?;
-
-            let json = serde_json::to_string_pretty(&result)
-                .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
-            json_response(&db, json)
+            drop(parse);
+
+            let mut result =
+                rag::search::hybrid_search_with(&db, &query, limit, kind_filter, &config)
+                    .map_err(|e| mcp_err(format!("semantic search failed: {e}")))?;
+            let tagged = tag_filter(&db, tag.as_deref())?;
+            result.results.retain(|r| tagged.keeps(&r.symbol.id));
+            let mut seen = lock_session(&session);
+            result.results.retain(|r| {
+                seen.in_scope(&r.symbol.file_path) && seen.first_sight(&r.symbol.id)
+            });
+            drop(seen);
+
+            let json = to_json(&result)?;
+            json_response(&db, Path::new("."), &session, json)
         })
         .await
-        .map_err(|e| mcp_err(format!("task join failed: {e}")))?
+    }
+
+    /// Show or change this client's session settings.
+    #[tool(
+        description = "Show or change this client's session: a default path scope and tag for later calls, a token budget for responses, and dedup, which leaves symbols already returned out of later searches. Other clients of the same server are not affected."
+    )]
+    async fn cartog_session(
+        &self,
+        Parameters(params): Parameters<SessionParams>,
+    ) -> Result<CallToolResult, McpError> {
+        let status = {
+            let mut session = lock_session(&self.session);
+            if let Some(scope) = params.scope {
+                session.scope = Some(scope).filter(|s| !s.is_empty());
+            }
+            if let Some(tag) = params.tag {
+                session.tag = Some(tag).filter(|t| !t.is_empty());
+            }
+            if let Some(budget) = params.budget {
+                session.budget = Some(budget).filter(|b| *b > 0);
+            }
+            if let Some(dedup) = params.dedup {
+                session.dedup = dedup;
+            }
+            if params.reset {
+                session.reset();
+            }
+            debug!(id = session.id, "session");
+            session.status(self.sessions.count())
+        };
+        let json = serde_json::to_string_pretty(&status)
+            .map_err(|e| mcp_err(format!("serialization failed: {e}")))?;
+        Ok(CallToolResult::success(vec![Content::text(json)]))
     }
 }
 
-#[tool_handler]
-impl ServerHandler for CartogServer {
-    fn get_info(&self) -> ServerInfo {
-        ServerInfo {
-            protocol_version: ProtocolVersion::LATEST,
-            capabilities: ServerCapabilities::builder().enable_tools().build(),
-            server_info: Implementation {
-                name: "cartog".into(),
-                version: env!("CARGO_PKG_VERSION").into(),
-            },
-            instructions: Some(
-                "cartog is a code graph indexer with semantic search. It pre-computes a graph of symbols \
+/// What clients are told about the tools at initialization.
+const INSTRUCTIONS: &str = "cartog is a code graph indexer with semantic search. It pre-computes a graph of symbols \
                  (functions, classes, methods, imports) and edges (calls, imports, inherits, \
                  type references, raises) using tree-sitter, stored in SQLite.\n\n\
                   Workflow:\n\
@@ -629,33 +1219,487 @@ impl ServerHandler for CartogServer {
                   4. Use cartog_refs to find all usages of a symbol (filter with kind param).\n\
                   5. Use cartog_impact before refactoring to assess blast radius.\n\
                   6. Re-run cartog_index after making code changes to keep the graph current.\n\
-                  7. Only fall back to reading files when you need actual implementation logic.\n\n\
+                  7. Only fall back to reading files when you need actual implementation logic.\n\
+                  8. Use cartog_batch to run several related lookups in one call.\n\n\
+                  Sessions:\n\
+                  - Use cartog_session to set a default scope or tag, a token budget, or dedup for this client.\n\n\
+                  History (git repositories):\n\
+                  - Use cartog_history to see when and why a symbol changed.\n\n\
                   Semantic search (if embedding model is installed):\n\
                   - Run cartog_rag_index to build the embedding index (after cartog_index).\n\
                   - Use cartog_rag_search for natural language queries about code functionality.\n\
                   - Combines keyword (BM25) and vector similarity search for best results.\n\n\
-                 Supports: Python, TypeScript/JavaScript, Rust, Go, Ruby."
-                    .into(),
+                 Supports: Python, TypeScript/JavaScript, Rust, Go, Ruby.";
+
+#[tool_handler]
+impl ServerHandler for CartogServer {
+    fn get_info(&self) -> ServerInfo {
+        // Advertise what this server may do, for clients that plan around it.
+        let allowed = self.capabilities.allowed();
+        let mut cartog = JsonObject::new();
+        cartog.insert("capabilities".into(), serde_json::json!(allowed));
+        let mut capabilities = ServerCapabilities::builder().enable_tools().build();
+        capabilities.experimental = Some(BTreeMap::from([("cartog".to_string(), cartog)]));
+        let mut instructions = String::from(INSTRUCTIONS);
+        if self.capabilities != Capabilities::default() {
+            let mut disabled: Vec<&str> = TOOL_CAPABILITIES
+                .iter()
+                .filter(|(_, c)| !self.capabilities.allows(*c))
+                .map(|(tool, _)| *tool)
+                .collect();
+            disabled.dedup();
+            instructions.push_str(&format!(
+                "\n\nThis server runs with capabilities: {}. Unavailable: {}.",
+                self.capabilities,
+                disabled.join(", ")
+            ));
+        }
+        ServerInfo {
+            protocol_version: ProtocolVersion::LATEST,
+            capabilities,
+            server_info: Implementation {
+                name: "cartog".into(),
+                version: env!("CARGO_PKG_VERSION").into(),
+            },
+            instructions: Some(instructions),
+        }
+    }
+}
+
+impl CartogServer {
+    /// Run a tool's work on the blocking pool, traced as a `tool` span of its
+    /// own: each call is one trace, with the `parse`, `plan`, `sql` and `format`
+    /// stages under it. The call is counted in the metrics and, with `args`,
+    /// written to the audit log.
+    fn blocking<F>(
+        &self,
+        tool: &'static str,
+        args: serde_json::Value,
+        work: F,
+    ) -> impl Future<Output = Result<CallToolResult, McpError>>
+    where
+        F: FnOnce() -> Result<CallToolResult, McpError> + Send + 'static,
+    {
+        let (id, client) = {
+            let session = lock_session(&self.session);
+            (session.id, session.client.clone())
+        };
+        let span = info_span!(
+            parent: None,
+            "tool",
+            tool,
+            session = id,
+            client = client.as_deref().unwrap_or("")
+        );
+        let metrics = Arc::clone(&self.metrics);
+        let audit = self.audit.clone();
+        let started = Instant::now();
+        async move {
+            let run = move || {
+                let result = span.in_scope(work);
+                if let Some(audit) = audit {
+                    let text = match &result {
+                        Ok(answer) => serde_json::to_string(answer).unwrap_or_default(),
+                        Err(_) => String::new(),
+                    };
+                    let entry = AuditEntry {
+                        at: audit::now_rfc3339(),
+                        session: id,
+                        client,
+                        tool: tool.to_string(),
+                        args,
+                        ok: result.is_ok(),
+                        error: result.as_ref().err().map(|e| e.message.to_string()),
+                        result_bytes: text.len(),
+                        tokens: session::estimate_tokens(&text),
+                        duration_ms: started.elapsed().as_secs_f64() * 1000.0,
+                    };
+                    if let Err(e) = audit.record(&entry) {
+                        tracing::warn!(error = %format!("{e:#}"), "failed to write audit log");
+                    }
+                }
+                result
+            };
+            let result = match tokio::task::spawn_blocking(run).await {
+                Ok(result) => result,
+                Err(e) => Err(mcp_err(format!("task join failed: {e}"))),
+            };
+            metrics.record(tool, result.is_ok(), started.elapsed());
+            result
+        }
+    }
+
+    /// A tool's arguments for the audit log; nothing when it is off.
+    fn audit_args<P: Serialize>(&self, params: &P) -> serde_json::Value {
+        match &self.audit {
+            Some(_) => serde_json::to_value(params).unwrap_or_default(),
+            None => serde_json::Value::Null,
+        }
+    }
+
+    /// The metrics page, with the index gauges read now.
+    async fn render_metrics(&self) -> anyhow::Result<String> {
+        let server = self.clone();
+        tokio::task::spawn_blocking(move || {
+            let db = server
+                .db
+                .lock()
+                .map_err(|_| anyhow::anyhow!("database lock poisoned"))?;
+            let stats = db.stats()?;
+            let (cache_hits, cache_misses) = db.cache_stats();
+            let indexed_at = indexer::indexed_at(&db)?;
+            drop(db);
+            let now = std::time::SystemTime::now()
+                .duration_since(std::time::UNIX_EPOCH)
+                .map_or(0, |d| d.as_secs());
+            let gauges = IndexGauges {
+                files: stats.num_files,
+                symbols: stats.num_symbols,
+                edges: stats.num_edges,
+                resolved_edges: stats.num_resolved,
+                bytes: std::fs::metadata(DB_FILE).map(|m| m.len()).unwrap_or(0),
+                age_seconds: indexed_at.map(|at| now.saturating_sub(at) as f64),
+                cache_hits,
+                cache_misses,
+                sessions: server.sessions.count(),
+            };
+            Ok(server.metrics.render(&gauges))
+        })
+        .await?
+    }
+
+    /// Admit one query from this client under its rate limits. Refusals carry
+    /// an HTTP-like status and how long to wait, for clients that back off.
+    fn admit(&self) -> Result<Permit, McpError> {
+        let (key, limits) = {
+            let session = lock_session(&self.session);
+            (session.rate_key(), session.limits)
+        };
+        self.limiter
+            .acquire(&key, limits, Instant::now())
+            .map_err(|refusal| {
+                debug!(client = %key, %refusal, "query refused");
+                self.metrics.refused();
+                McpError::new(
+                    RATE_LIMITED,
+                    format!("rate limited: {refusal}"),
+                    Some(serde_json::json!({
+                        "status": 429,
+                        "retry_after_ms": refusal.retry_after().as_millis() as u64,
+                    })),
+                )
+            })
+    }
+
+    /// Refuse `tool` when the server runs without a capability it needs. Such
+    /// tools are not listed either; this guards against clients calling them
+    /// anyway.
+    fn permit(&self, tool: &str) -> Result<(), McpError> {
+        match TOOL_CAPABILITIES
+            .iter()
+            .find(|(name, c)| *name == tool && !self.capabilities.allows(*c))
+        {
+            Some((_, missing)) => Err(McpError::invalid_request(
+                format!("{tool} is disabled: the server runs without the `{missing}` capability"),
+                None,
+            )),
+            None => Ok(()),
+        }
+    }
+
+    /// Refuse `tool` unless the client's token grants `needed`.
+    fn require(&self, needed: Access, tool: &str) -> Result<(), McpError> {
+        if lock_session(&self.session).access.allows(needed) {
+            Ok(())
+        } else {
+            Err(McpError::invalid_request(
+                format!("{tool} needs an admin token"),
+                None,
+            ))
+        }
+    }
+
+    /// `tag`, or the session's default tag.
+    fn session_tag(&self, tag: Option<String>) -> Option<String> {
+        lock_session(&self.session).tag_or_default(tag)
+    }
+
+    fn touch_file(&self, file: &str) {
+        self.hot
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .touch_file(file);
+    }
+
+    fn touch_name(&self, name: &str) {
+        self.hot
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .touch_name(name);
+    }
+
+    fn hot_snapshot(&self) -> WarmSnapshot {
+        self.hot
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .snapshot()
+    }
+
+    /// What a swapped rebuild of the local index needs from this server.
+    fn rebuild(&self) -> Rebuild {
+        Rebuild {
+            db_path: Arc::clone(&self.db_path),
+            generation: Arc::clone(&self.generation),
+            hot: Arc::clone(&self.hot),
+            snapshot: Arc::clone(&self.snapshot),
+        }
+    }
+
+    /// The snapshot slot, for the local index only: mounted ones have none.
+    fn snapshot_of(&self, repo: &Repo) -> Option<Arc<Mutex<Option<Arc<IndexSnapshot>>>>> {
+        (repo.name == federation::LOCAL).then(|| Arc::clone(&self.snapshot))
+    }
+
+    /// Load [`SNAPSHOT_FILE`] on a separate thread and start answering from it
+    /// once it is in memory, if the index was not rebuilt since it was saved.
+    /// Never blocks startup.
+    fn spawn_snapshot_load(&self) {
+        let slot = Arc::clone(&self.snapshot);
+        let db_path = Arc::clone(&self.db_path);
+        std::thread::spawn(move || {
+            let started = Instant::now();
+            let Some(snapshot) = IndexSnapshot::load(Path::new(SNAPSHOT_FILE)) else {
+                debug!("no index snapshot to load");
+                return;
+            };
+            match Database::open_read_only(&*db_path).and_then(|db| snapshot.is_current(&db)) {
+                Ok(true) => {
+                    info!(
+                        symbols = snapshot.symbol_count(),
+                        elapsed_ms = started.elapsed().as_secs_f64() * 1000.0,
+                        "loaded index snapshot"
+                    );
+                    *slot.lock().unwrap_or_else(|e| e.into_inner()) = Some(Arc::new(snapshot));
+                }
+                Ok(false) => debug!("index rebuilt since the snapshot was saved; ignoring it"),
+                Err(e) => debug!(error = %e, "index snapshot skipped"),
+            }
+        });
+    }
+
+    /// Save the symbol table and import graph to [`SNAPSHOT_FILE`]: the loaded
+    /// snapshot if it still applies, otherwise a fresh one.
+    fn save_snapshot(&self) -> anyhow::Result<()> {
+        let db = self
+            .db
+            .lock()
+            .map_err(|_| anyhow::anyhow!("database lock poisoned"))?;
+        let snapshot = match current_snapshot(&self.snapshot, &db) {
+            Some(snapshot) => snapshot,
+            None => match IndexSnapshot::build(&db)? {
+                Some(snapshot) => Arc::new(snapshot),
+                None => return Ok(()),
+            },
+        };
+        snapshot.save(Path::new(SNAPSHOT_FILE))
+    }
+}
+
+/// The snapshot in `slot` if `db` is still the build it was taken from. A stale
+/// one is dropped, and queries go to the index from then on.
+fn current_snapshot(
+    slot: &Mutex<Option<Arc<IndexSnapshot>>>,
+    db: &Database,
+) -> Option<Arc<IndexSnapshot>> {
+    let mut slot = slot.lock().unwrap_or_else(|e| e.into_inner());
+    let snapshot = slot.as_ref()?;
+    if snapshot.is_current(db).unwrap_or(false) {
+        return Some(Arc::clone(snapshot));
+    }
+    debug!("index rebuilt; dropping the index snapshot");
+    *slot = None;
+    None
+}
+
+/// Replay the previous session's working set on a separate connection so the
+/// first tool calls find their pages resident. Never blocks startup.
+fn spawn_warmup(db_path: Arc<Path>, snapshot: WarmSnapshot) {
+    std::thread::spawn(move || {
+        match Database::open_read_only(&*db_path).and_then(|db| warm::warm(&db, &snapshot)) {
+            Ok(stats) => info!(
+                files = stats.files,
+                names = stats.names,
+                elapsed_ms = stats.elapsed_ms,
+                "restored warm snapshot"
             ),
+            Err(e) => debug!(error = %e, "warm-up skipped"),
+        }
+    });
+}
+
+/// Holds [`CartogServer::rebuilding`] while a swapped rebuild runs, and clears
+/// it when dropped, whether the rebuild finished, failed or panicked.
+struct Rebuilding(Arc<AtomicBool>);
+
+impl Rebuilding {
+    /// Set the flag; `None` when a rebuild already holds it.
+    fn claim(flag: &Arc<AtomicBool>) -> Option<Self> {
+        (!flag.swap(true, Ordering::AcqRel)).then(|| Self(Arc::clone(flag)))
+    }
+}
+
+impl Drop for Rebuilding {
+    fn drop(&mut self) {
+        self.0.store(false, Ordering::Release);
+    }
+}
+
+/// A swapped rebuild of the local index, run by `cartog_index` on its own thread.
+struct Rebuild {
+    db_path: Arc<Path>,
+    generation: Arc<AtomicU64>,
+    hot: Arc<Mutex<HotSet>>,
+    snapshot: Arc<Mutex<Option<Arc<IndexSnapshot>>>>,
+}
+
+impl Rebuild {
+    /// Rebuild `root` into a new generation and swap it in, holding `claim`
+    /// until the thread ends.
+    fn spawn(self, root: PathBuf, claim: Rebuilding) -> std::thread::JoinHandle<()> {
+        std::thread::spawn(move || {
+            let _claim = claim;
+            let had_snapshot = self
+                .snapshot
+                .lock()
+                .unwrap_or_else(|e| e.into_inner())
+                .is_some();
+            let built = indexer::index_generation(&self.db_path, |db| {
+                indexer::index_directory(db, &root, true)
+            });
+            match built {
+                Ok(result) => {
+                    let generation = self.generation.fetch_add(1, Ordering::AcqRel) + 1;
+                    info!(
+                        generation,
+                        files = result.files_indexed,
+                        symbols = result.symbols_added,
+                        "swapped in new index"
+                    );
+                    self.warm(had_snapshot);
+                }
+                Err(e) => tracing::warn!(error = %e, "rebuild failed; keeping the current index"),
+            }
+        })
+    }
+
+    /// Warm the generation just swapped in: replace the snapshot of the old one
+    /// with a snapshot of the new, if there was one, and fault in the working
+    /// set's pages.
+    fn warm(&self, had_snapshot: bool) {
+        let hot = self
+            .hot
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .snapshot();
+        let warmed = Database::open_read_only(&*self.db_path).and_then(|db| {
+            if had_snapshot {
+                let fresh = IndexSnapshot::build(&db)?.map(Arc::new);
+                *self.snapshot.lock().unwrap_or_else(|e| e.into_inner()) = fresh;
+            }
+            warm::warm(&db, &hot)
+        });
+        match warmed {
+            Ok(stats) => info!(
+                files = stats.files,
+                names = stats.names,
+                elapsed_ms = stats.elapsed_ms,
+                "warmed new index"
+            ),
+            Err(e) => debug!(error = %e, "warm-up skipped"),
         }
     }
 }
 
-/// Start the MCP server over stdio.
+/// Longest request head a metrics scrape may send.
+const MAX_SCRAPE_REQUEST: u64 = 8192;
+
+/// How long a scraper has to send its request.
+const SCRAPE_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Longest `Authorization` line a client may send before the MCP stream.
+const MAX_PREAMBLE: u64 = 4096;
+
+/// How long a client has to send its token.
+const PREAMBLE_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// How long a client has to complete the TLS handshake.
+const TLS_HANDSHAKE_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// Certificate and key the server presents, and the CA client certificates
+/// must chain to when clients are authenticated by TLS too.
+#[derive(Debug, Clone)]
+pub struct TlsConfig {
+    pub cert: PathBuf,
+    pub key: PathBuf,
+    pub client_ca: Option<PathBuf>,
+}
+
+/// How `cartog serve` runs.
+#[derive(Debug, Clone, Default)]
+pub struct ServeConfig {
+    /// Keep the index fresh with a background file watcher.
+    pub watch: bool,
+    /// Also keep embeddings fresh; requires `watch`.
+    pub rag: bool,
+    /// Tuning of the shared connection's memory-mapped read path.
+    pub read: ReadConfig,
+    /// Accept MCP clients on this TCP address instead of serving stdio.
+    pub listen: Option<SocketAddr>,
+    /// Bearer tokens clients of `listen` must present; anyone may connect when unset.
+    pub tokens: Option<Tokens>,
+    /// Serve `listen` over TLS.
+    pub tls: Option<TlsConfig>,
+    /// What the server may do besides reading the index.
+    pub capabilities: Capabilities,
+    /// Query limits for each client of `listen`, unless its token sets its own.
+    pub limits: Limits,
+    /// Serve Prometheus metrics on this TCP address.
+    pub metrics: Option<SocketAddr>,
+    /// Log answered tool calls here.
+    pub audit: Option<AuditConfig>,
+    /// Other repositories whose indexes are queried through this server.
+    pub mounts: Vec<Mount>,
+}
+
+/// Where the audit log goes and how it is rotated.
+#[derive(Debug, Clone)]
+pub struct AuditConfig {
+    pub path: PathBuf,
+    pub max_bytes: u64,
+    /// Rotated files kept.
+    pub keep: u32,
+}
+
+/// Start the MCP server, over stdio or for many clients on a TCP address.
 ///
-/// When `watch` is true, a background file watcher keeps the index fresh.
-/// When `rag` is true (requires `watch`), embeddings are also auto-updated.
-pub async fn run_server(watch: bool, rag: bool) -> anyhow::Result<()> {
+/// Clients share the index connection, the watcher and the warm set; each has
+/// its own session (see [`crate::session`]).
+pub async fn run_server(serve: ServeConfig) -> anyhow::Result<()> {
     info!("starting cartog MCP server v{}", env!("CARGO_PKG_VERSION"));
 
+    if serve.watch && !serve.capabilities.allows(Capability::Index) {
+        anyhow::bail!("--watch rebuilds the index, which --capabilities does not allow");
+    }
+    check_listen(&serve)?;
+
     // Optionally spawn a background file watcher
-    let _watch_handle: Option<WatchHandle> = if watch {
+    let _watch_handle: Option<WatchHandle> = if serve.watch {
         let cwd = std::env::current_dir()?;
         let mut config = WatchConfig::new(cwd);
-        config.rag = rag;
+        config.rag = serve.rag;
         match watch::spawn_watch(config, DB_FILE) {
             Ok(handle) => {
-                info!(rag, "background file watcher started");
+                info!(rag = serve.rag, "background file watcher started");
                 Some(handle)
             }
             Err(e) => {
@@ -667,21 +1711,343 @@ pub async fn run_server(watch: bool, rag: bool) -> anyhow::Result<()> {
         None
     };
 
-    let server = CartogServer::new()?;
-    let service = server.serve(stdio()).await?;
-    service.waiting().await?;
+    if !serve.capabilities.allows(Capability::Shell) {
+        capabilities::forbid_shell();
+    }
+    let mut server = CartogServer::with_config(serve.read, serve.capabilities, serve.limits)?;
+    if let Some(audit) = &serve.audit {
+        let log = AuditLog::open(&audit.path, audit.max_bytes, audit.keep)?;
+        info!(path = %audit.path.display(), "auditing tool calls");
+        server = server.with_audit(log);
+    }
+    if !serve.mounts.is_empty() {
+        server = server.with_mounts(&serve.mounts)?;
+    }
+    server.spawn_snapshot_load();
+    spawn_warmup(Arc::clone(&server.db_path), server.hot_snapshot());
+    if let Some(addr) = serve.metrics {
+        let listener = tokio::net::TcpListener::bind(addr)
+            .await
+            .map_err(|e| anyhow::anyhow!("cannot serve metrics on {addr}: {e}"))?;
+        info!(addr = %listener.local_addr()?, "serving metrics at /metrics");
+        tokio::spawn(serve_metrics(server.clone(), listener));
+    }
+    match serve.listen {
+        Some(addr) => {
+            let tls = serve.tls.as_ref().map(tls_acceptor).transpose()?;
+            serve_clients(&server, addr, serve.tokens.map(Arc::new), tls).await?
+        }
+        None => {
+            let service = server.clone().serve(stdio()).await?;
+            service.waiting().await?;
+        }
+    }
+
+    if serve.capabilities.allows(Capability::Files) {
+        if let Err(e) = server.hot_snapshot().save(Path::new(WARM_FILE)) {
+            tracing::warn!(error = %e, "failed to save warm snapshot");
+        }
+        if let Err(e) = server.save_snapshot() {
+            tracing::warn!(error = %e, "failed to save index snapshot");
+        }
+    }
 
     // WatchHandle is dropped here, signaling the watcher thread to stop.
     info!("cartog MCP server stopped");
     Ok(())
 }
 
+/// Answer Prometheus scrapes at `GET /metrics`, one request per connection.
+async fn serve_metrics(server: CartogServer, listener: tokio::net::TcpListener) {
+    loop {
+        let stream = match listener.accept().await {
+            Ok((stream, _)) => stream,
+            Err(e) => {
+                tracing::warn!(error = %e, "failed to accept metrics scrape");
+                continue;
+            }
+        };
+        let server = server.clone();
+        tokio::spawn(async move {
+            if let Err(e) = answer_scrape(&server, stream).await {
+                debug!(error = %e, "metrics scrape failed");
+            }
+        });
+    }
+}
+
+async fn answer_scrape(
+    server: &CartogServer,
+    mut stream: tokio::net::TcpStream,
+) -> anyhow::Result<()> {
+    let (read, mut write) = stream.split();
+    let mut reader = BufReader::new(read.take(MAX_SCRAPE_REQUEST));
+    let mut request = String::new();
+    tokio::time::timeout(SCRAPE_TIMEOUT, async {
+        reader.read_line(&mut request).await?;
+        // Headers are read and ignored.
+        let mut header = String::new();
+        while reader.read_line(&mut header).await? > 0 && !header.trim().is_empty() {
+            header.clear();
+        }
+        Ok::<_, std::io::Error>(())
+    })
+    .await
+    .map_err(|_| anyhow::anyhow!("no request within {}s", SCRAPE_TIMEOUT.as_secs()))??;
+
+    let mut fields = request.split_whitespace();
+    let method = fields.next().unwrap_or("");
+    let path = fields.next().unwrap_or("").split('?').next().unwrap_or("");
+    let (status, body) = match (method, path) {
+        ("GET", "/metrics") => ("200 OK", server.render_metrics().await?),
+        ("GET", _) => (
+            "404 Not Found",
+            "not found; metrics are at /metrics\n".to_string(),
+        ),
+        _ => ("405 Method Not Allowed", "only GET is served\n".to_string()),
+    };
+    let response = format!(
+        "HTTP/1.1 {status}\r\n\
+         Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n\
+         Content-Length: {}\r\n\
+         Connection: close\r\n\r\n{body}",
+        body.len()
+    );
+    write.write_all(response.as_bytes()).await?;
+    write.shutdown().await?;
+    Ok(())
+}
+
+/// A TLS acceptor for `tls`, verifying client certificates when it has a CA.
+fn tls_acceptor(tls: &TlsConfig) -> anyhow::Result<TlsAcceptor> {
+    use rustls::pki_types::{pem::PemObject, CertificateDer, PrivateKeyDer};
+
+    let pem_err = |path: &Path, e: rustls::pki_types::pem::Error| {
+        anyhow::anyhow!("cannot read {}: {e}", path.display())
+    };
+    let certs = CertificateDer::pem_file_iter(&tls.cert)
+        .and_then(|certs| certs.collect::<Result<Vec<_>, _>>())
+        .map_err(|e| pem_err(&tls.cert, e))?;
+    let key = PrivateKeyDer::from_pem_file(&tls.key).map_err(|e| pem_err(&tls.key, e))?;
+
+    let provider = Arc::new(rustls::crypto::ring::default_provider());
+    let builder = rustls::ServerConfig::builder_with_provider(Arc::clone(&provider))
+        .with_safe_default_protocol_versions()?;
+    let builder = match &tls.client_ca {
+        Some(ca) => {
+            let mut roots = rustls::RootCertStore::empty();
+            for cert in CertificateDer::pem_file_iter(ca).map_err(|e| pem_err(ca, e))? {
+                roots.add(cert.map_err(|e| pem_err(ca, e))?)?;
+            }
+            let verifier = rustls::server::WebPkiClientVerifier::builder_with_provider(
+                Arc::new(roots),
+                provider,
+            )
+            .build()?;
+            builder.with_client_cert_verifier(verifier)
+        }
+        None => builder.with_no_client_auth(),
+    };
+    let config = builder.with_single_cert(certs, key)?;
+    Ok(TlsAcceptor::from(Arc::new(config)))
+}
+
+/// Accept MCP clients on `addr` until interrupted, each served on its own task
+/// with a fresh session.
+async fn serve_clients(
+    server: &CartogServer,
+    addr: SocketAddr,
+    tokens: Option<Arc<Tokens>>,
+    tls: Option<TlsAcceptor>,
+) -> anyhow::Result<()> {
+    let listener = tokio::net::TcpListener::bind(addr)
+        .await
+        .map_err(|e| anyhow::anyhow!("cannot listen on {addr}: {e}"))?;
+    info!(
+        addr = %listener.local_addr()?,
+        tls = tls.is_some(),
+        auth = tokens.is_some(),
+        "listening for MCP clients"
+    );
+    // The template server's own session is never used here.
+    server.sessions.close();
+    loop {
+        let (stream, peer) = tokio::select! {
+            accepted = listener.accept() => match accepted {
+                Ok(accepted) => accepted,
+                Err(e) => {
+                    tracing::warn!(error = %e, "failed to accept client");
+                    continue;
+                }
+            },
+            _ = tokio::signal::ctrl_c() => return Ok(()),
+        };
+        let client = server.for_client();
+        let tokens = tokens.clone();
+        let tls = tls.clone();
+        tokio::spawn(async move {
+            let sessions = Arc::clone(&client.sessions);
+            let id = lock_session(&client.session).id;
+            let result = match tls {
+                Some(tls) => {
+                    match tokio::time::timeout(TLS_HANDSHAKE_TIMEOUT, tls.accept(stream)).await {
+                        Ok(Ok(stream)) => serve_client(client, stream, tokens.as_deref()).await,
+                        Ok(Err(e)) => Err(anyhow::anyhow!("TLS handshake failed: {e}")),
+                        Err(_) => Err(anyhow::anyhow!("TLS handshake timed out")),
+                    }
+                }
+                None => serve_client(client, stream, tokens.as_deref()).await,
+            };
+            sessions.close();
+            match result {
+                Ok(()) => info!(%peer, session = id, "client disconnected"),
+                Err(e) => tracing::warn!(%peer, session = id, error = %e, "client dropped"),
+            }
+        });
+    }
+}
+
+/// Refuse to serve unauthenticated clients beyond this machine: without tokens
+/// or client certificates, anyone reaching the socket could rebuild the index.
+fn check_listen(serve: &ServeConfig) -> anyhow::Result<()> {
+    let Some(addr) = serve.listen else {
+        return Ok(());
+    };
+    let client_certs = serve
+        .tls
+        .as_ref()
+        .is_some_and(|tls| tls.client_ca.is_some());
+    anyhow::ensure!(
+        addr.ip().is_loopback() || serve.tokens.is_some() || client_certs,
+        "--listen {addr} is reachable from other machines; authenticate clients \
+         with --tokens or --tls-client-ca, or listen on a loopback address"
+    );
+    Ok(())
+}
+
+/// Authenticate one client, when tokens are required, then serve it MCP.
+async fn serve_client<S>(
+    client: CartogServer,
+    stream: S,
+    tokens: Option<&Tokens>,
+) -> anyhow::Result<()>
+where
+    S: AsyncRead + AsyncWrite + Send + 'static,
+{
+    let (read, mut write) = tokio::io::split(stream);
+    let mut read = BufReader::new(read);
+    if let Some(tokens) = tokens {
+        let mut line = String::new();
+        let preamble = (&mut read).take(MAX_PREAMBLE).read_line(&mut line);
+        tokio::time::timeout(PREAMBLE_TIMEOUT, preamble)
+            .await
+            .map_err(|_| anyhow::anyhow!("no token sent"))??;
+        let Some(grant) = auth::bearer_token(&line).and_then(|t| tokens.authenticate(t)) else {
+            let _ = write.write_all(b"unauthorized\n").await;
+            anyhow::bail!("invalid or missing bearer token");
+        };
+        info!(client = %grant.name, access = ?grant.access, "client authenticated");
+        lock_session(&client.session).authenticate(grant);
+    }
+    let sessions = Arc::clone(&client.sessions);
+    let id = lock_session(&client.session).id;
+    info!(session = id, clients = sessions.count(), "client connected");
+    client.serve((read, write)).await?.waiting().await?;
+    Ok(())
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    // ── Read path tests ──
+
+    #[test]
+    fn auto_mmap_covers_index_growth() {
+        assert_eq!(auto_mmap_bytes(0), MIN_AUTO_MMAP);
+        assert_eq!(auto_mmap_bytes(10 * MIB), MIN_AUTO_MMAP);
+        assert_eq!(auto_mmap_bytes(300 * MIB), 600 * MIB);
+        assert_eq!(auto_mmap_bytes(300 * MIB + 1), 601 * MIB);
+    }
+
+    // ── Rebuild tests ──
+
+    #[test]
+    fn swapped_rebuild_reaches_readers_once_their_read_ends() {
+        let tmp = std::env::temp_dir().join(format!("cartog-mcp-swap-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&tmp);
+        std::fs::create_dir_all(&tmp).unwrap();
+        std::fs::write(tmp.join("a.py"), "def a():\n    pass\n").unwrap();
+        let db_path: Arc<Path> = Arc::from(tmp.join("index.db"));
+        indexer::index_directory(&Database::open(&*db_path).unwrap(), &tmp, false).unwrap();
+        std::fs::write(tmp.join("b.py"), "def b():\n    pass\n").unwrap();
+
+        let symbols = |conn: &rusqlite::Connection| -> i64 {
+            conn.query_row("SELECT COUNT(*) FROM symbols", [], |row| row.get(0))
+                .unwrap()
+        };
+        let reader = rusqlite::Connection::open(&*db_path).unwrap();
+        reader.execute_batch("BEGIN").unwrap();
+        let old = symbols(&reader);
+
+        let rebuilding = Arc::new(AtomicBool::new(false));
+        let generation = Arc::new(AtomicU64::new(0));
+        let rebuild = Rebuild {
+            db_path,
+            generation: Arc::clone(&generation),
+            hot: Arc::new(Mutex::new(HotSet::new(HOT_SET_CAPACITY))),
+            snapshot: Arc::new(Mutex::new(None)),
+        };
+        let claim = Rebuilding::claim(&rebuilding).unwrap();
+        assert!(Rebuilding::claim(&rebuilding).is_none());
+        rebuild.spawn(tmp.clone(), claim).join().unwrap();
+
+        assert_eq!(generation.load(Ordering::Acquire), 1);
+        assert!(!rebuilding.load(Ordering::Acquire), "claim released");
+        assert_eq!(
+            symbols(&reader),
+            old,
+            "a read under way stays on the old generation"
+        );
+        reader.execute_batch("COMMIT").unwrap();
+        assert!(
+            symbols(&reader) > old,
+            "the next read sees the new generation"
+        );
+
+        std::fs::remove_dir_all(&tmp).unwrap();
+    }
+
     // ── Path validation tests ──
 
+    #[test]
+    fn listen_beyond_loopback_needs_auth() {
+        let listen = |addr: &str| ServeConfig {
+            listen: Some(addr.parse().unwrap()),
+            ..ServeConfig::default()
+        };
+        assert!(check_listen(&ServeConfig::default()).is_ok());
+        assert!(check_listen(&listen("127.0.0.1:7777")).is_ok());
+        assert!(check_listen(&listen("[::1]:7777")).is_ok());
+        assert!(check_listen(&listen("0.0.0.0:7777")).is_err());
+
+        let tokens = ServeConfig {
+            tokens: Some(Tokens::parse("9f2c0e7d4b1a read ci").unwrap()),
+            ..listen("0.0.0.0:7777")
+        };
+        assert!(check_listen(&tokens).is_ok());
+        let tls = |client_ca: Option<&str>| ServeConfig {
+            tls: Some(TlsConfig {
+                cert: "cert.pem".into(),
+                key: "key.pem".into(),
+                client_ca: client_ca.map(PathBuf::from),
+            }),
+            ..listen("0.0.0.0:7777")
+        };
+        assert!(check_listen(&tls(None)).is_err());
+        assert!(check_listen(&tls(Some("ca.pem"))).is_ok());
+    }
+
     #[test]
     fn validate_path_dot_is_allowed() {
         let result = validate_path_within_cwd(".");
@@ -890,6 +2256,7 @@ mod tests {
         let entry = HierarchyEntry {
             child: "Dog".to_string(),
             parent: "Animal".to_string(),
+            double: None,
         };
         let json = serde_json::to_string(&entry).expect("serialize");
         assert!(json.contains("\"Dog\""));
@@ -0,0 +1,258 @@
+//! Prometheus metrics for `cartog serve --metrics`.
+//!
+//! The server counts tool calls by outcome and times them into a latency
+//! histogram per tool. At scrape time these are rendered in the Prometheus text
+//! format together with [`IndexGauges`], read from the index: its size, how long
+//! ago it was built, and the SQLite page cache's hits and misses.
+
+use std::collections::BTreeMap;
+use std::fmt::Write as _;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::Mutex;
+use std::time::Duration;
+
+/// Upper bounds, in seconds, of the latency histogram buckets.
+pub const LATENCY_BUCKETS: [f64; 11] = [
+    0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5,
+];
+
+#[derive(Debug, Default)]
+struct ToolStats {
+    ok: u64,
+    errors: u64,
+    /// Calls per bucket, not cumulative; the last counts calls above every bound.
+    buckets: [u64; LATENCY_BUCKETS.len() + 1],
+    seconds: f64,
+}
+
+/// Counters kept for the life of the server.
+#[derive(Debug, Default)]
+pub struct Metrics {
+    tools: Mutex<BTreeMap<String, ToolStats>>,
+    rate_limited: AtomicU64,
+}
+
+/// What the index looks like at scrape time.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct IndexGauges {
+    pub files: u32,
+    pub symbols: u32,
+    pub edges: u32,
+    pub resolved_edges: u32,
+    /// Size of the index file.
+    pub bytes: u64,
+    /// Seconds since the index was last built; unknown for indexes built before
+    /// the time was recorded.
+    pub age_seconds: Option<f64>,
+    /// SQLite page cache lookups on the server's connection.
+    pub cache_hits: i64,
+    pub cache_misses: i64,
+    /// Client sessions open.
+    pub sessions: usize,
+}
+
+impl Metrics {
+    /// Count one call of `tool` that took `elapsed`.
+    pub fn record(&self, tool: &str, ok: bool, elapsed: Duration) {
+        let mut tools = self.tools.lock().unwrap_or_else(|e| e.into_inner());
+        let stats = tools.entry(tool.to_string()).or_default();
+        if ok {
+            stats.ok += 1;
+        } else {
+            stats.errors += 1;
+        }
+        let seconds = elapsed.as_secs_f64();
+        let bucket = LATENCY_BUCKETS
+            .iter()
+            .position(|bound| seconds <= *bound)
+            .unwrap_or(LATENCY_BUCKETS.len());
+        stats.buckets[bucket] += 1;
+        stats.seconds += seconds;
+    }
+
+    /// Count one call refused by rate limits.
+    pub fn refused(&self) {
+        self.rate_limited.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Everything, in the Prometheus text exposition format.
+    pub fn render(&self, index: &IndexGauges) -> String {
+        let mut out = String::new();
+        let tools = self.tools.lock().unwrap_or_else(|e| e.into_inner());
+
+        header(
+            &mut out,
+            "cartog_queries_total",
+            "counter",
+            "Tool calls answered, by tool and outcome.",
+        );
+        for (tool, stats) in tools.iter() {
+            for (outcome, count) in [("ok", stats.ok), ("error", stats.errors)] {
+                let _ = writeln!(
+                    out,
+                    "cartog_queries_total{{tool=\"{tool}\",outcome=\"{outcome}\"}} {count}"
+                );
+            }
+        }
+
+        header(
+            &mut out,
+            "cartog_query_duration_seconds",
+            "histogram",
+            "Time to answer a tool call.",
+        );
+        for (tool, stats) in tools.iter() {
+            let mut cumulative = 0;
+            for (bound, count) in LATENCY_BUCKETS.iter().zip(stats.buckets) {
+                cumulative += count;
+                let _ = writeln!(
+                    out,
+                    "cartog_query_duration_seconds_bucket{{tool=\"{tool}\",le=\"{bound}\"}} {cumulative}"
+                );
+            }
+            let count = stats.ok + stats.errors;
+            let _ = writeln!(
+                out,
+                "cartog_query_duration_seconds_bucket{{tool=\"{tool}\",le=\"+Inf\"}} {count}"
+            );
+            let _ = writeln!(
+                out,
+                "cartog_query_duration_seconds_sum{{tool=\"{tool}\"}} {}",
+                stats.seconds
+            );
+            let _ = writeln!(
+                out,
+                "cartog_query_duration_seconds_count{{tool=\"{tool}\"}} {count}"
+            );
+        }
+        drop(tools);
+
+        let single = |out: &mut String, name: &str, kind: &str, help: &str, value: String| {
+            header(out, name, kind, help);
+            let _ = writeln!(out, "{name} {value}");
+        };
+        single(
+            &mut out,
+            "cartog_rate_limited_total",
+            "counter",
+            "Tool calls refused by rate limits.",
+            self.rate_limited.load(Ordering::Relaxed).to_string(),
+        );
+        single(
+            &mut out,
+            "cartog_cache_hits_total",
+            "counter",
+            "SQLite page cache hits.",
+            index.cache_hits.to_string(),
+        );
+        single(
+            &mut out,
+            "cartog_cache_misses_total",
+            "counter",
+            "SQLite page cache misses.",
+            index.cache_misses.to_string(),
+        );
+        let lookups = index.cache_hits + index.cache_misses;
+        if lookups > 0 {
+            single(
+                &mut out,
+                "cartog_cache_hit_ratio",
+                "gauge",
+                "Share of SQLite page lookups served from the cache.",
+                (index.cache_hits as f64 / lookups as f64).to_string(),
+            );
+        }
+        if let Some(age) = index.age_seconds {
+            single(
+                &mut out,
+                "cartog_index_age_seconds",
+                "gauge",
+                "Seconds since the index was last built.",
+                age.to_string(),
+            );
+        }
+        for (name, help, value) in [
+            (
+                "cartog_index_files",
+                "Files in the index.",
+                index.files.to_string(),
+            ),
+            (
+                "cartog_index_symbols",
+                "Symbols in the index.",
+                index.symbols.to_string(),
+            ),
+            (
+                "cartog_index_edges",
+                "Edges in the index.",
+                index.edges.to_string(),
+            ),
+            (
+                "cartog_index_resolved_edges",
+                "Edges resolved to a symbol.",
+                index.resolved_edges.to_string(),
+            ),
+            (
+                "cartog_index_bytes",
+                "Size of the index file.",
+                index.bytes.to_string(),
+            ),
+            (
+                "cartog_sessions",
+                "Client sessions open.",
+                index.sessions.to_string(),
+            ),
+        ] {
+            single(&mut out, name, "gauge", help, value);
+        }
+        out
+    }
+}
+
+fn header(out: &mut String, name: &str, kind: &str, help: &str) {
+    let _ = writeln!(out, "# HELP {name} {help}");
+    let _ = writeln!(out, "# TYPE {name} {kind}");
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render_counts_and_histograms() {
+        let metrics = Metrics::default();
+        metrics.record("cartog_refs", true, Duration::from_micros(800));
+        metrics.record("cartog_refs", true, Duration::from_millis(30));
+        metrics.record("cartog_refs", false, Duration::from_secs(4));
+        metrics.refused();
+        let text = metrics.render(&IndexGauges {
+            files: 12,
+            bytes: 4096,
+            age_seconds: Some(90.0),
+            cache_hits: 3,
+            cache_misses: 1,
+            sessions: 2,
+            ..IndexGauges::default()
+        });
+        let lines: Vec<&str> = text.lines().collect();
+        for expected in [
+            "# TYPE cartog_queries_total counter",
+            "cartog_queries_total{tool=\"cartog_refs\",outcome=\"ok\"} 2",
+            "cartog_queries_total{tool=\"cartog_refs\",outcome=\"error\"} 1",
+            "cartog_query_duration_seconds_bucket{tool=\"cartog_refs\",le=\"0.001\"} 1",
+            "cartog_query_duration_seconds_bucket{tool=\"cartog_refs\",le=\"0.025\"} 1",
+            "cartog_query_duration_seconds_bucket{tool=\"cartog_refs\",le=\"0.05\"} 2",
+            "cartog_query_duration_seconds_bucket{tool=\"cartog_refs\",le=\"2.5\"} 2",
+            "cartog_query_duration_seconds_bucket{tool=\"cartog_refs\",le=\"+Inf\"} 3",
+            "cartog_query_duration_seconds_count{tool=\"cartog_refs\"} 3",
+            "cartog_rate_limited_total 1",
+            "cartog_cache_hit_ratio 0.75",
+            "cartog_index_age_seconds 90",
+            "cartog_index_files 12",
+            "cartog_index_bytes 4096",
+            "cartog_sessions 2",
+        ] {
+            assert!(lines.contains(&expected), "missing {expected} in\n{text}");
+        }
+    }
+}
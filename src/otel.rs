@@ -0,0 +1,558 @@
+//! OpenTelemetry traces, exported over OTLP/HTTP with JSON bodies.
+//!
+//! Export is on when `OTEL_EXPORTER_OTLP_TRACES_ENDPOINT` or
+//! `OTEL_EXPORTER_OTLP_ENDPOINT` is set, as with any OpenTelemetry SDK, and off
+//! under `OTEL_SDK_DISABLED=true` or `OTEL_TRACES_EXPORTER=none`. The service is
+//! named by `OTEL_SERVICE_NAME` (`cartog` by default) and extra request headers
+//! come from `OTEL_EXPORTER_OTLP_HEADERS` as `key=value,key=value`.
+//!
+//! [`OtlpLayer`] turns cartog's own tracing spans at info level and above into
+//! OTLP spans: a CLI command, an MCP tool call, and the `parse`, `plan` and
+//! `format` stages inside them. SQL statements become `sql` spans under the
+//! span that ran them, one per distinct statement with its call count, fed by
+//! the connection's profiling hook (see [`Database::enable_otel`]). Spans are
+//! batched and posted from a background thread; [`shutdown`] sends what is left.
+//!
+//! [`Database::enable_otel`]: crate::db::Database::enable_otel
+
+use std::cell::RefCell;
+use std::collections::hash_map::RandomState;
+use std::collections::HashMap;
+use std::ffi::CStr;
+use std::hash::{BuildHasher, Hasher};
+use std::os::raw::{c_int, c_uint, c_void};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::mpsc::{self, Receiver, RecvTimeoutError, SyncSender};
+use std::sync::{Mutex, OnceLock};
+use std::thread::JoinHandle;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use rusqlite::ffi;
+use serde_json::{json, Value};
+use tracing::{span, Level, Metadata, Subscriber};
+use tracing_subscriber::layer::Context as LayerContext;
+use tracing_subscriber::registry::LookupSpan;
+use tracing_subscriber::Layer;
+
+use crate::profile::FieldVisitor;
+
+/// Spans posted in one request.
+const MAX_BATCH: usize = 512;
+
+/// Spans waiting to be posted; more are dropped while the collector lags.
+const MAX_QUEUE: usize = 4096;
+
+/// How long a span may wait for its batch to fill.
+const FLUSH_EVERY: Duration = Duration::from_secs(2);
+
+const EXPORT_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Distinct statements kept per span; the rest are not reported.
+const MAX_STATEMENTS: usize = 64;
+
+// OTLP span kinds.
+const KIND_INTERNAL: u8 = 1;
+const KIND_SERVER: u8 = 2;
+const KIND_CLIENT: u8 = 3;
+
+/// Where and how spans are exported.
+#[derive(Debug, Clone, PartialEq)]
+pub struct OtlpConfig {
+    /// Full URL spans are posted to.
+    pub endpoint: String,
+    pub headers: Vec<(String, String)>,
+    pub service: String,
+}
+
+impl OtlpConfig {
+    /// The exporter the environment asks for, if any.
+    pub fn from_env() -> Option<Self> {
+        Self::from_vars(|name| std::env::var(name).ok())
+    }
+
+    fn from_vars(var: impl Fn(&str) -> Option<String>) -> Option<Self> {
+        let set = |name: &str| {
+            var(name)
+                .map(|v| v.trim().to_string())
+                .filter(|v| !v.is_empty())
+        };
+        if set("OTEL_SDK_DISABLED").is_some_and(|v| v.eq_ignore_ascii_case("true"))
+            || set("OTEL_TRACES_EXPORTER").is_some_and(|v| v == "none")
+        {
+            return None;
+        }
+        let endpoint = match set("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") {
+            Some(url) => url,
+            None => format!(
+                "{}/v1/traces",
+                set("OTEL_EXPORTER_OTLP_ENDPOINT")?.trim_end_matches('/')
+            ),
+        };
+        let headers = set("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+            .or_else(|| set("OTEL_EXPORTER_OTLP_HEADERS"))
+            .map(|list| {
+                list.split(',')
+                    .filter_map(|pair| pair.split_once('='))
+                    .map(|(k, v)| (k.trim().to_string(), v.trim().to_string()))
+                    .filter(|(k, _)| !k.is_empty())
+                    .collect()
+            })
+            .unwrap_or_default();
+        Some(Self {
+            endpoint,
+            headers,
+            service: set("OTEL_SERVICE_NAME").unwrap_or_else(|| "cartog".to_string()),
+        })
+    }
+}
+
+// ── Spans ──
+
+/// A finished span, ready to export.
+#[derive(Debug, Clone)]
+struct SpanData {
+    trace_id: u128,
+    span_id: u64,
+    parent_id: Option<u64>,
+    name: String,
+    kind: u8,
+    start: u64,
+    end: u64,
+    attributes: Vec<(String, String)>,
+}
+
+impl SpanData {
+    fn to_json(&self) -> Value {
+        let attributes: Vec<Value> = self
+            .attributes
+            .iter()
+            .map(|(key, value)| attribute(key, value))
+            .collect();
+        let mut span = json!({
+            "traceId": format!("{:032x}", self.trace_id),
+            "spanId": format!("{:016x}", self.span_id),
+            "name": self.name,
+            "kind": self.kind,
+            "startTimeUnixNano": self.start.to_string(),
+            "endTimeUnixNano": self.end.to_string(),
+            "attributes": attributes,
+        });
+        if let Some(parent) = self.parent_id {
+            span["parentSpanId"] = json!(format!("{parent:016x}"));
+        }
+        span
+    }
+}
+
+fn attribute(key: &str, value: &str) -> Value {
+    json!({ "key": key, "value": { "stringValue": value } })
+}
+
+/// The OTLP/JSON request body for `spans`.
+fn payload(service: &str, spans: &[SpanData]) -> Value {
+    let spans: Vec<Value> = spans.iter().map(SpanData::to_json).collect();
+    json!({
+        "resourceSpans": [{
+            "resource": { "attributes": [attribute("service.name", service)] },
+            "scopeSpans": [{
+                "scope": { "name": "cartog", "version": env!("CARGO_PKG_VERSION") },
+                "spans": spans,
+            }],
+        }],
+    })
+}
+
+/// A statement run under one span, with its calls added up.
+#[derive(Debug)]
+struct Statement {
+    sql: String,
+    calls: u64,
+    start: u64,
+    nanos: u64,
+}
+
+struct Exporter {
+    queue: Mutex<Option<SyncSender<SpanData>>>,
+    worker: Mutex<Option<JoinHandle<()>>>,
+    /// Statements by the span they ran under, until it closes.
+    statements: Mutex<HashMap<u64, Vec<Statement>>>,
+}
+
+static EXPORTER: OnceLock<Exporter> = OnceLock::new();
+
+thread_local! {
+    /// Spans entered on this thread, innermost last, as (trace id, span id).
+    static ACTIVE: RefCell<Vec<(u128, u64)>> = const { RefCell::new(Vec::new()) };
+}
+
+/// Start exporting if the environment asks for it, returning the layer that
+/// feeds the exporter.
+pub fn init() -> Option<OtlpLayer> {
+    let config = OtlpConfig::from_env()?;
+    let (queue, spans) = mpsc::sync_channel(MAX_QUEUE);
+    let worker = std::thread::Builder::new()
+        .name("cartog-otlp".to_string())
+        .spawn(move || export_loop(config, spans))
+        .ok()?;
+    EXPORTER
+        .set(Exporter {
+            queue: Mutex::new(Some(queue)),
+            worker: Mutex::new(Some(worker)),
+            statements: Mutex::default(),
+        })
+        .ok()?;
+    Some(OtlpLayer { _private: () })
+}
+
+pub fn is_enabled() -> bool {
+    EXPORTER.get().is_some()
+}
+
+/// Stop exporting, posting every span closed so far.
+pub fn shutdown() {
+    let Some(exporter) = EXPORTER.get() else {
+        return;
+    };
+    drop(
+        exporter
+            .queue
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .take(),
+    );
+    if let Some(worker) = exporter
+        .worker
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .take()
+    {
+        let _ = worker.join();
+    }
+}
+
+fn export(span: SpanData) {
+    if let Some(exporter) = EXPORTER.get() {
+        if let Some(queue) = &*exporter.queue.lock().unwrap_or_else(|e| e.into_inner()) {
+            let _ = queue.try_send(span);
+        }
+    }
+}
+
+fn export_loop(config: OtlpConfig, spans: Receiver<SpanData>) {
+    let mut batch = Vec::new();
+    loop {
+        match spans.recv_timeout(FLUSH_EVERY) {
+            Ok(span) => {
+                batch.push(span);
+                if batch.len() < MAX_BATCH {
+                    continue;
+                }
+            }
+            Err(RecvTimeoutError::Timeout) => {}
+            Err(RecvTimeoutError::Disconnected) => {
+                post(&config, &mut batch);
+                return;
+            }
+        }
+        post(&config, &mut batch);
+    }
+}
+
+fn post(config: &OtlpConfig, batch: &mut Vec<SpanData>) {
+    static WARNED: AtomicBool = AtomicBool::new(false);
+    if batch.is_empty() {
+        return;
+    }
+    let body = payload(&config.service, batch).to_string();
+    batch.clear();
+    let mut request = ureq::post(&config.endpoint)
+        .set("Content-Type", "application/json")
+        .timeout(EXPORT_TIMEOUT);
+    for (key, value) in &config.headers {
+        request = request.set(key, value);
+    }
+    if let Err(e) = request.send_string(&body) {
+        // Once is enough: a missing collector shouldn't flood stderr.
+        if !WARNED.swap(true, Ordering::Relaxed) {
+            tracing::warn!(endpoint = %config.endpoint, error = %e, "cannot export traces");
+        }
+    }
+}
+
+fn random_id() -> u64 {
+    static COUNTER: AtomicU64 = AtomicU64::new(0);
+    let mut hasher = RandomState::new().build_hasher();
+    hasher.write_u64(COUNTER.fetch_add(1, Ordering::Relaxed));
+    hasher.finish().max(1)
+}
+
+fn unix_nanos() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |d| d.as_nanos() as u64)
+}
+
+/// Whether spans like `metadata` are exported: cartog's own, at info and above.
+fn exported(metadata: &Metadata<'_>) -> bool {
+    metadata.target().starts_with("cartog") && *metadata.level() <= Level::INFO
+}
+
+// ── SQL statements ──
+
+/// SQLite trace callback adding each finished statement to the active span.
+///
+/// # Safety
+///
+/// Must only be installed through `sqlite3_trace_v2` with `SQLITE_TRACE_PROFILE`,
+/// which passes a statement handle and a pointer to its run time in nanoseconds.
+pub(crate) unsafe extern "C" fn on_profile(
+    event: c_uint,
+    _ctx: *mut c_void,
+    stmt: *mut c_void,
+    nanos: *mut c_void,
+) -> c_int {
+    if event != ffi::SQLITE_TRACE_PROFILE as c_uint {
+        return 0;
+    }
+    let Some(exporter) = EXPORTER.get() else {
+        return 0;
+    };
+    let Some((_, parent)) = ACTIVE.with(|active| active.borrow().last().copied()) else {
+        return 0;
+    };
+    let sql_ptr = ffi::sqlite3_sql(stmt as *mut ffi::sqlite3_stmt);
+    if sql_ptr.is_null() {
+        return 0;
+    }
+    let sql = CStr::from_ptr(sql_ptr).to_string_lossy();
+    let sql = sql.trim();
+    let elapsed = (*(nanos as *const i64)).max(0) as u64;
+
+    let mut statements = exporter
+        .statements
+        .lock()
+        .unwrap_or_else(|e| e.into_inner());
+    let under = statements.entry(parent).or_default();
+    if let Some(statement) = under.iter_mut().find(|s| s.sql == sql) {
+        statement.calls += 1;
+        statement.nanos += elapsed;
+    } else if under.len() < MAX_STATEMENTS {
+        under.push(Statement {
+            sql: sql.to_string(),
+            calls: 1,
+            start: unix_nanos().saturating_sub(elapsed),
+            nanos: elapsed,
+        });
+    }
+    0
+}
+
+/// The `sql` spans of statements run under `parent`.
+fn statement_spans(trace_id: u128, parent: u64) -> Vec<SpanData> {
+    let Some(exporter) = EXPORTER.get() else {
+        return Vec::new();
+    };
+    let statements = exporter
+        .statements
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .remove(&parent)
+        .unwrap_or_default();
+    statements
+        .into_iter()
+        .map(|s| SpanData {
+            trace_id,
+            span_id: random_id(),
+            parent_id: Some(parent),
+            name: "sql".to_string(),
+            kind: KIND_CLIENT,
+            start: s.start,
+            end: s.start + s.nanos,
+            attributes: vec![
+                ("db.system.name".to_string(), "sqlite".to_string()),
+                ("db.query.text".to_string(), s.sql),
+                ("cartog.sql.calls".to_string(), s.calls.to_string()),
+            ],
+        })
+        .collect()
+}
+
+// ── Layer ──
+
+/// Tracing layer exporting cartog's spans; see the module docs.
+pub struct OtlpLayer {
+    _private: (),
+}
+
+struct OtelSpan {
+    trace_id: u128,
+    span_id: u64,
+    parent_id: Option<u64>,
+    start: u64,
+    attributes: HashMap<String, String>,
+}
+
+impl<S> Layer<S> for OtlpLayer
+where
+    S: Subscriber + for<'a> LookupSpan<'a>,
+{
+    fn on_new_span(&self, attrs: &span::Attributes<'_>, id: &span::Id, ctx: LayerContext<'_, S>) {
+        let Some(span) = ctx.span(id) else {
+            return;
+        };
+        if !exported(span.metadata()) {
+            return;
+        }
+        let parent = span.scope().skip(1).find_map(|ancestor| {
+            ancestor
+                .extensions()
+                .get::<OtelSpan>()
+                .map(|o| (o.trace_id, o.span_id))
+        });
+        let mut visitor = FieldVisitor::default();
+        attrs.record(&mut visitor);
+        span.extensions_mut().insert(OtelSpan {
+            trace_id: parent.map_or_else(
+                || (u128::from(random_id()) << 64) | u128::from(random_id()),
+                |(trace_id, _)| trace_id,
+            ),
+            span_id: random_id(),
+            parent_id: parent.map(|(_, span_id)| span_id),
+            start: unix_nanos(),
+            attributes: visitor.0,
+        });
+    }
+
+    fn on_record(&self, id: &span::Id, values: &span::Record<'_>, ctx: LayerContext<'_, S>) {
+        let Some(span) = ctx.span(id) else {
+            return;
+        };
+        let mut extensions = span.extensions_mut();
+        if let Some(otel) = extensions.get_mut::<OtelSpan>() {
+            let mut visitor = FieldVisitor::default();
+            values.record(&mut visitor);
+            otel.attributes.extend(visitor.0);
+        }
+    }
+
+    fn on_enter(&self, id: &span::Id, ctx: LayerContext<'_, S>) {
+        if let Some(span) = ctx.span(id) {
+            if let Some(otel) = span.extensions().get::<OtelSpan>() {
+                ACTIVE.with(|active| active.borrow_mut().push((otel.trace_id, otel.span_id)));
+            }
+        }
+    }
+
+    fn on_exit(&self, id: &span::Id, ctx: LayerContext<'_, S>) {
+        if let Some(span) = ctx.span(id) {
+            if let Some(otel) = span.extensions().get::<OtelSpan>() {
+                ACTIVE.with(|active| {
+                    let mut active = active.borrow_mut();
+                    if let Some(at) = active.iter().rposition(|(_, s)| *s == otel.span_id) {
+                        active.remove(at);
+                    }
+                });
+            }
+        }
+    }
+
+    fn on_close(&self, id: span::Id, ctx: LayerContext<'_, S>) {
+        let Some(span) = ctx.span(&id) else {
+            return;
+        };
+        let Some(otel) = span.extensions_mut().remove::<OtelSpan>() else {
+            return;
+        };
+        let mut attributes: Vec<(String, String)> = otel.attributes.into_iter().collect();
+        attributes.sort();
+        let kind = if otel.parent_id.is_none() && span.name() == "tool" {
+            KIND_SERVER
+        } else {
+            KIND_INTERNAL
+        };
+        for statement in statement_spans(otel.trace_id, otel.span_id) {
+            export(statement);
+        }
+        export(SpanData {
+            trace_id: otel.trace_id,
+            span_id: otel.span_id,
+            parent_id: otel.parent_id,
+            name: span.name().to_string(),
+            kind,
+            start: otel.start,
+            end: unix_nanos(),
+            attributes,
+        });
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_config_and_payload() {
+        let env = |vars: &'static [(&'static str, &'static str)]| {
+            move |name: &str| {
+                vars.iter()
+                    .find(|(k, _)| *k == name)
+                    .map(|(_, v)| v.to_string())
+            }
+        };
+        assert_eq!(OtlpConfig::from_vars(env(&[])), None);
+        let config = OtlpConfig::from_vars(env(&[
+            ("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/"),
+            ("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key=abc, tenant = ops"),
+        ]))
+        .unwrap();
+        assert_eq!(config.endpoint, "http://collector:4318/v1/traces");
+        assert_eq!(
+            config.headers,
+            [
+                ("x-api-key".to_string(), "abc".to_string()),
+                ("tenant".to_string(), "ops".to_string())
+            ]
+        );
+        assert_eq!(config.service, "cartog");
+        let traces = OtlpConfig::from_vars(env(&[
+            ("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318"),
+            ("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "http://tempo/otlp"),
+            ("OTEL_SERVICE_NAME", "cartog-ci"),
+        ]))
+        .unwrap();
+        assert_eq!(
+            (traces.endpoint.as_str(), traces.service.as_str()),
+            ("http://tempo/otlp", "cartog-ci")
+        );
+        assert_eq!(
+            OtlpConfig::from_vars(env(&[
+                ("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318"),
+                ("OTEL_SDK_DISABLED", "true"),
+            ])),
+            None
+        );
+
+        let span = SpanData {
+            trace_id: 0xab,
+            span_id: 0x12,
+            parent_id: Some(0x34),
+            name: "sql".to_string(),
+            kind: KIND_CLIENT,
+            start: 1_000,
+            end: 2_500,
+            attributes: vec![("db.system.name".to_string(), "sqlite".to_string())],
+        };
+        let body = payload("cartog", &[span]);
+        let resource = &body["resourceSpans"][0];
+        assert_eq!(
+            resource["resource"]["attributes"][0]["value"]["stringValue"],
+            "cartog"
+        );
+        let span = &resource["scopeSpans"][0]["spans"][0];
+        assert_eq!(span["traceId"], "000000000000000000000000000000ab");
+        assert_eq!(span["spanId"], "0000000000000012");
+        assert_eq!(span["parentSpanId"], "0000000000000034");
+        assert_eq!(span["endTimeUnixNano"], "2500");
+        assert_eq!(span["attributes"][0]["key"], "db.system.name");
+    }
+}
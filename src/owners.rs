@@ -0,0 +1,110 @@
+//! Code ownership from a CODEOWNERS file, as GitHub and GitLab read it.
+//!
+//! Each line is a gitignore-style pattern followed by owners; the last matching
+//! line wins, and a line with no owners un-assigns what it matches. Patterns
+//! without a slash match at any depth, a leading slash anchors to the root, and a
+//! pattern naming a directory covers everything below it.
+
+use std::path::Path;
+
+use anyhow::{Context, Result};
+use globset::{GlobBuilder, GlobSet, GlobSetBuilder};
+
+/// Where CODEOWNERS is looked for, in GitHub's order.
+pub const CODEOWNERS_PATHS: [&str; 3] = [".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"];
+
+#[derive(Debug, Clone)]
+pub struct CodeOwners {
+    rules: Vec<(GlobSet, Vec<String>)>,
+}
+
+impl CodeOwners {
+    /// The CODEOWNERS file under `root`, if there is one.
+    pub fn load(root: &Path) -> Result<Option<Self>> {
+        for path in CODEOWNERS_PATHS {
+            let path = root.join(path);
+            if path.is_file() {
+                let text = std::fs::read_to_string(&path)
+                    .with_context(|| format!("cannot read {}", path.display()))?;
+                return Self::parse(&text)
+                    .map(Some)
+                    .with_context(|| format!("invalid {}", path.display()));
+            }
+        }
+        Ok(None)
+    }
+
+    pub fn parse(text: &str) -> Result<Self> {
+        let mut rules = Vec::new();
+        for (i, line) in text.lines().enumerate() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let mut fields = line.split_whitespace();
+            let Some(pattern) = fields.next() else {
+                continue;
+            };
+            let owners: Vec<String> = fields
+                .take_while(|f| !f.starts_with('#'))
+                .map(str::to_string)
+                .collect();
+            let globs = Self::globs(pattern).with_context(|| format!("line {}", i + 1))?;
+            rules.push((globs, owners));
+        }
+        Ok(Self { rules })
+    }
+
+    /// The glob forms of one pattern: the path itself, and everything below it.
+    fn globs(pattern: &str) -> Result<GlobSet> {
+        let anchored = pattern.starts_with('/') || pattern.trim_end_matches('/').contains('/');
+        let mut base = pattern
+            .trim_start_matches('/')
+            .trim_end_matches('/')
+            .to_string();
+        if base.is_empty() || base == "*" {
+            base = "**".to_string();
+        } else if !anchored {
+            base = format!("**/{base}");
+        }
+        let mut set = GlobSetBuilder::new();
+        for glob in [base.clone(), format!("{base}/**")] {
+            set.add(GlobBuilder::new(&glob).literal_separator(true).build()?);
+        }
+        Ok(set.build()?)
+    }
+
+    /// Owners of `path` (relative to the root); empty when none are assigned.
+    pub fn owners_of(&self, path: &str) -> &[String] {
+        self.rules
+            .iter()
+            .rev()
+            .find(|(globs, _)| globs.is_match(path))
+            .map_or(&[], |(_, owners)| owners.as_slice())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_last_matching_rule_wins() {
+        let owners = CodeOwners::parse(
+            "# default
+*                 @acme/core
+*.go              @acme/go
+/internal/api/    @acme/api  # REST team
+docs              @acme/docs
+internal/api/gen/
+",
+        )
+        .unwrap();
+        assert_eq!(owners.owners_of("README.md"), ["@acme/core"]);
+        assert_eq!(owners.owners_of("cmd/main.go"), ["@acme/go"]);
+        assert_eq!(owners.owners_of("internal/api/users.go"), ["@acme/api"]);
+        assert_eq!(owners.owners_of("site/docs/intro.md"), ["@acme/docs"]);
+        assert!(owners.owners_of("internal/api/gen/types.go").is_empty());
+        assert_eq!(owners.owners_of("pkg/internal/api/x.py"), ["@acme/core"]);
+    }
+}
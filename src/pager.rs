@@ -0,0 +1,581 @@
+//! Paging long human output on a terminal.
+//!
+//! When stdout is a terminal, a query command runs with its stdout redirected
+//! to a spool file. Output that fits on the screen is then printed as is.
+//! Longer output is shown in a full-screen view: space and `b` move by page,
+//! `j` and `k` by line, `n` and `N` jump between headings (lines that start at
+//! the left margin), `g` and `G` go to either end, and `q` quits. While its
+//! output is captured, the command colors code snippets with [`highlight`].
+//!
+//! Redirecting stdout and the view's raw mode (through `stty`) are Unix-only,
+//! so paging is only done there. `--no-pager`, `[output] pager = false` or a
+//! non-terminal stdout print directly.
+
+use std::fs::File;
+use std::io::{IsTerminal, Read, Seek, Write};
+use std::process::{Command, Stdio};
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use anyhow::{Context, Result};
+
+const RESET: &str = "\x1b[0m";
+const KEYWORD: &str = "\x1b[1;34m";
+const STRING: &str = "\x1b[32m";
+const COMMENT: &str = "\x1b[90m";
+const NUMBER: &str = "\x1b[35m";
+
+const PYTHON_KEYWORDS: &[&str] = &[
+    "and", "as", "async", "await", "break", "class", "continue", "def", "elif", "else", "except",
+    "False", "finally", "for", "from", "if", "import", "in", "is", "lambda", "None", "not", "or",
+    "pass", "raise", "return", "self", "True", "try", "while", "with", "yield",
+];
+
+const RUST_KEYWORDS: &[&str] = &[
+    "as", "async", "await", "break", "const", "continue", "crate", "dyn", "else", "enum", "false",
+    "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub", "ref",
+    "return", "self", "Self", "static", "struct", "super", "trait", "true", "type", "unsafe",
+    "use", "where", "while",
+];
+
+const GO_KEYWORDS: &[&str] = &[
+    "break",
+    "case",
+    "chan",
+    "const",
+    "continue",
+    "default",
+    "defer",
+    "else",
+    "false",
+    "for",
+    "func",
+    "go",
+    "if",
+    "import",
+    "interface",
+    "map",
+    "nil",
+    "package",
+    "range",
+    "return",
+    "select",
+    "struct",
+    "switch",
+    "true",
+    "type",
+    "var",
+];
+
+const JS_KEYWORDS: &[&str] = &[
+    "async",
+    "await",
+    "break",
+    "case",
+    "catch",
+    "class",
+    "const",
+    "continue",
+    "default",
+    "delete",
+    "do",
+    "else",
+    "export",
+    "extends",
+    "false",
+    "finally",
+    "for",
+    "from",
+    "function",
+    "if",
+    "import",
+    "in",
+    "instanceof",
+    "interface",
+    "let",
+    "new",
+    "null",
+    "return",
+    "super",
+    "switch",
+    "this",
+    "throw",
+    "true",
+    "try",
+    "type",
+    "typeof",
+    "undefined",
+    "var",
+    "while",
+    "yield",
+];
+
+const RUBY_KEYWORDS: &[&str] = &[
+    "begin", "break", "case", "class", "def", "do", "else", "elsif", "end", "ensure", "false",
+    "if", "in", "module", "next", "nil", "not", "raise", "rescue", "return", "self", "super",
+    "then", "true", "unless", "until", "when", "while", "yield",
+];
+
+/// Keywords of `language`, as [`crate::languages::detect_language`] names it;
+/// none for a language without a list here.
+fn keywords(language: Option<&str>) -> &'static [&'static str] {
+    match language {
+        Some("python") => PYTHON_KEYWORDS,
+        Some("rust") => RUST_KEYWORDS,
+        Some("go") => GO_KEYWORDS,
+        Some("javascript" | "typescript" | "tsx") => JS_KEYWORDS,
+        Some("ruby") => RUBY_KEYWORDS,
+        _ => &[],
+    }
+}
+
+/// Set while a command's output is captured for the pager.
+static CAPTURING: AtomicBool = AtomicBool::new(false);
+
+/// Whether this process should hand its output to the pager.
+pub fn wanted() -> bool {
+    cfg!(unix) && std::io::stdout().is_terminal()
+}
+
+/// Whether output should carry color: only while it is captured for the pager.
+pub fn color() -> bool {
+    CAPTURING.load(Ordering::Relaxed) && std::env::var_os("NO_COLOR").is_none()
+}
+
+/// Run `command` with its stdout captured, then page what it printed. Returns
+/// the command's result once the pager is closed. When stdout cannot be
+/// redirected, the command prints directly.
+pub fn run_paged<T>(command: impl FnOnce() -> Result<T>) -> Result<T> {
+    let mut spool = match spool_file() {
+        Ok(spool) => spool,
+        Err(e) => {
+            tracing::debug!(error = %format!("{e:#}"), "not paging");
+            return command();
+        }
+    };
+    let redirect = match Redirect::to(&spool) {
+        Ok(redirect) => redirect,
+        Err(e) => {
+            tracing::debug!(error = %e, "not paging");
+            return command();
+        }
+    };
+    CAPTURING.store(true, Ordering::Relaxed);
+    let result = command();
+    CAPTURING.store(false, Ordering::Relaxed);
+    drop(redirect);
+
+    let mut captured = Vec::new();
+    spool.rewind()?;
+    spool.read_to_end(&mut captured)?;
+    let text = String::from_utf8_lossy(&captured);
+    let lines: Vec<&str> = text.lines().collect();
+    match terminal_size() {
+        Some((rows, cols)) if lines.len() >= rows => {
+            if let Err(e) = page(&lines, rows, cols) {
+                restore_terminal();
+                tracing::warn!(error = %format!("{e:#}"), "pager failed");
+                print!("{text}");
+            }
+        }
+        _ => print!("{text}"),
+    }
+    std::io::stdout().flush()?;
+    result
+}
+
+/// An anonymous file for captured output: unlinked once open, where the
+/// platform allows it, so nothing is left behind.
+fn spool_file() -> Result<File> {
+    let path = std::env::temp_dir().join(format!("cartog-page-{}", std::process::id()));
+    let file = File::options()
+        .read(true)
+        .write(true)
+        .create(true)
+        .truncate(true)
+        .open(&path)
+        .with_context(|| format!("cannot create {}", path.display()))?;
+    let _ = std::fs::remove_file(&path);
+    Ok(file)
+}
+
+/// Stdout pointed at a file until dropped, on unwind too.
+#[cfg(unix)]
+struct Redirect {
+    saved: std::os::fd::OwnedFd,
+}
+
+#[cfg(unix)]
+impl Redirect {
+    fn to(file: &File) -> std::io::Result<Self> {
+        use std::os::fd::{AsFd, AsRawFd};
+        let saved = std::io::stdout().as_fd().try_clone_to_owned()?;
+        std::io::stdout().flush()?;
+        point_stdout_at(file.as_raw_fd())?;
+        Ok(Self { saved })
+    }
+}
+
+#[cfg(unix)]
+impl Drop for Redirect {
+    fn drop(&mut self) {
+        use std::os::fd::AsRawFd;
+        let _ = std::io::stdout().flush();
+        let _ = point_stdout_at(self.saved.as_raw_fd());
+    }
+}
+
+/// Make descriptor 1 a duplicate of `fd`.
+#[cfg(unix)]
+fn point_stdout_at(fd: std::os::fd::RawFd) -> std::io::Result<()> {
+    extern "C" {
+        fn dup2(oldfd: std::os::raw::c_int, newfd: std::os::raw::c_int) -> std::os::raw::c_int;
+    }
+    // SAFETY: dup2 takes two descriptors and touches no memory; `fd` is open
+    // for the duration of the call, and replacing descriptor 1 is the point.
+    if unsafe { dup2(fd, 1) } < 0 {
+        return Err(std::io::Error::last_os_error());
+    }
+    Ok(())
+}
+
+#[cfg(not(unix))]
+struct Redirect;
+
+#[cfg(not(unix))]
+impl Redirect {
+    fn to(_: &File) -> std::io::Result<Self> {
+        Err(std::io::ErrorKind::Unsupported.into())
+    }
+}
+
+/// `(rows, columns)` of the controlling terminal.
+fn terminal_size() -> Option<(usize, usize)> {
+    let out = stty(&["size"]).ok()?;
+    let mut parts = out.split_whitespace().map(|n| n.parse().ok());
+    match (parts.next()??, parts.next()??) {
+        (0, _) | (_, 0) => None,
+        size => Some(size),
+    }
+}
+
+fn stty(args: &[&str]) -> Result<String> {
+    let tty = File::open("/dev/tty").context("no controlling terminal")?;
+    let out = Command::new("stty")
+        .args(args)
+        .stdin(tty)
+        .stderr(Stdio::null())
+        .output()
+        .context("failed to run stty")?;
+    anyhow::ensure!(out.status.success(), "stty {} failed", args.join(" "));
+    Ok(String::from_utf8_lossy(&out.stdout).trim().to_string())
+}
+
+fn restore_terminal() {
+    print!("\x1b[?25h\x1b[?1049l");
+    let _ = std::io::stdout().flush();
+    let _ = stty(&["sane"]);
+}
+
+/// Keys the view understands.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Key {
+    LineDown,
+    LineUp,
+    PageDown,
+    PageUp,
+    NextHeading,
+    PrevHeading,
+    Top,
+    Bottom,
+    Quit,
+    Other,
+}
+
+/// Where the view is in the output.
+#[derive(Debug)]
+struct View {
+    len: usize,
+    /// Lines shown at once; the last row is the status line.
+    height: usize,
+    headings: Vec<usize>,
+    top: usize,
+}
+
+impl View {
+    fn new(lines: &[&str], rows: usize) -> Self {
+        let headings = lines
+            .iter()
+            .enumerate()
+            .filter(|(_, l)| is_heading(l))
+            .map(|(i, _)| i)
+            .collect();
+        Self {
+            len: lines.len(),
+            height: rows.saturating_sub(1).max(1),
+            headings,
+            top: 0,
+        }
+    }
+
+    fn last_top(&self) -> usize {
+        self.len.saturating_sub(self.height)
+    }
+
+    /// Move for `key`; `false` once the view should close.
+    fn apply(&mut self, key: Key) -> bool {
+        let top = match key {
+            Key::LineDown => self.top + 1,
+            Key::LineUp => self.top.saturating_sub(1),
+            Key::PageDown => self.top + self.height,
+            Key::PageUp => self.top.saturating_sub(self.height),
+            Key::NextHeading => self
+                .headings
+                .iter()
+                .copied()
+                .find(|&h| h > self.top)
+                .unwrap_or(self.top),
+            Key::PrevHeading => self
+                .headings
+                .iter()
+                .copied()
+                .rev()
+                .find(|&h| h < self.top)
+                .unwrap_or(0),
+            Key::Top => 0,
+            Key::Bottom => self.last_top(),
+            Key::Quit => return false,
+            Key::Other => self.top,
+        };
+        self.top = top.min(self.last_top());
+        true
+    }
+}
+
+/// A line that starts at the left margin: a section or result heading.
+fn is_heading(line: &str) -> bool {
+    let plain = strip_ansi(line);
+    plain.chars().next().is_some_and(|c| !c.is_whitespace())
+}
+
+fn page(lines: &[&str], rows: usize, cols: usize) -> Result<()> {
+    let saved = stty(&["-g"])?;
+    stty(&["-icanon", "-echo", "-isig", "min", "1"])?;
+    let mut tty = File::open("/dev/tty")?;
+    let mut out = std::io::stdout().lock();
+    write!(out, "\x1b[?1049h\x1b[?25l")?;
+
+    let mut view = View::new(lines, rows);
+    loop {
+        write!(out, "\x1b[H\x1b[2J")?;
+        for line in lines.iter().skip(view.top).take(view.height) {
+            write!(out, "{}{RESET}\r\n", truncate_visible(line, cols))?;
+        }
+        let shown = (view.top + view.height).min(view.len);
+        let status = format!(
+            "lines {}-{shown} of {}  space/b page  j/k line  n/N heading  g/G ends  q quit",
+            view.top + 1,
+            view.len
+        );
+        write!(
+            out,
+            "\x1b[{rows};1H\x1b[7m{}{RESET}",
+            truncate_visible(&status, cols)
+        )?;
+        out.flush()?;
+        if !view.apply(read_key(&mut tty)?) {
+            break;
+        }
+    }
+
+    write!(out, "\x1b[?25h\x1b[?1049l")?;
+    out.flush()?;
+    stty(&[saved.as_str()])?;
+    Ok(())
+}
+
+fn read_key(tty: &mut File) -> Result<Key> {
+    let mut byte = [0u8; 1];
+    tty.read_exact(&mut byte)?;
+    Ok(match byte[0] {
+        b'j' | b'\r' | b'\n' => Key::LineDown,
+        b'k' => Key::LineUp,
+        b' ' | b'f' => Key::PageDown,
+        b'b' => Key::PageUp,
+        b'n' => Key::NextHeading,
+        b'N' => Key::PrevHeading,
+        b'g' | b'<' => Key::Top,
+        b'G' | b'>' => Key::Bottom,
+        // q, Ctrl-C, Ctrl-D
+        b'q' | b'Q' | 3 | 4 => Key::Quit,
+        0x1b => {
+            // Arrows and Page Up/Down: ESC [ A, ESC [ B, ESC [ 5 ~, ESC [ 6 ~.
+            let mut seq = [0u8; 2];
+            tty.read_exact(&mut seq)?;
+            match seq {
+                [b'[', b'A'] => Key::LineUp,
+                [b'[', b'B'] => Key::LineDown,
+                [b'[', b'H'] => Key::Top,
+                [b'[', b'F'] => Key::Bottom,
+                [b'[', n @ (b'5' | b'6')] => {
+                    tty.read_exact(&mut byte)?;
+                    if n == b'5' {
+                        Key::PageUp
+                    } else {
+                        Key::PageDown
+                    }
+                }
+                _ => Key::Other,
+            }
+        }
+        _ => Key::Other,
+    })
+}
+
+fn strip_ansi(s: &str) -> String {
+    let mut out = String::with_capacity(s.len());
+    let mut chars = s.chars();
+    while let Some(c) = chars.next() {
+        if c == '\x1b' {
+            // CSI: ESC [ parameters, ended by a letter.
+            for c in chars.by_ref() {
+                if c.is_ascii_alphabetic() {
+                    break;
+                }
+            }
+        } else {
+            out.push(c);
+        }
+    }
+    out
+}
+
+/// `s` cut to `cols` visible characters, keeping escape sequences whole.
+fn truncate_visible(s: &str, cols: usize) -> String {
+    let mut out = String::with_capacity(s.len());
+    let mut visible = 0;
+    let mut chars = s.chars();
+    while let Some(c) = chars.next() {
+        if c == '\x1b' {
+            out.push(c);
+            for c in chars.by_ref() {
+                out.push(c);
+                if c.is_ascii_alphabetic() {
+                    break;
+                }
+            }
+            continue;
+        }
+        if visible == cols {
+            break;
+        }
+        let c = if c == '\t' { ' ' } else { c };
+        out.push(c);
+        visible += 1;
+    }
+    out
+}
+
+/// One line of `language` code, colored for the terminal: the language's
+/// keywords, string literals, numbers and a trailing comment. A lexer for
+/// previews, not a parser: strings and comments are only recognized within the
+/// line.
+pub fn highlight(line: &str, language: Option<&str>) -> String {
+    let comment = match language {
+        Some("python" | "ruby") => "#",
+        _ => "//",
+    };
+    let keywords = keywords(language);
+    let mut out = String::with_capacity(line.len() * 2);
+    let mut rest = line;
+    while let Some(c) = rest.chars().next() {
+        if rest.starts_with(comment) {
+            out.push_str(&format!("{COMMENT}{rest}{RESET}"));
+            return out;
+        }
+        if c == '"' || (c == '\'' && language != Some("rust")) {
+            let end = rest[1..]
+                .char_indices()
+                .scan(false, |escaped, (i, ch)| {
+                    let closes = ch == c && !*escaped;
+                    *escaped = ch == '\\' && !*escaped;
+                    Some((i, closes))
+                })
+                .find(|(_, closes)| *closes)
+                .map_or(rest.len(), |(i, _)| i + 2);
+            out.push_str(&format!("{STRING}{}{RESET}", &rest[..end]));
+            rest = &rest[end..];
+            continue;
+        }
+        if c.is_alphanumeric() || c == '_' {
+            let end = rest
+                .find(|ch: char| !(ch.is_alphanumeric() || ch == '_'))
+                .unwrap_or(rest.len());
+            let word = &rest[..end];
+            if keywords.contains(&word) {
+                out.push_str(&format!("{KEYWORD}{word}{RESET}"));
+            } else if c.is_ascii_digit() {
+                out.push_str(&format!("{NUMBER}{word}{RESET}"));
+            } else {
+                out.push_str(word);
+            }
+            rest = &rest[end..];
+            continue;
+        }
+        out.push(c);
+        rest = &rest[c.len_utf8()..];
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_view_navigates_pages_and_headings() {
+        let text = "impact of login\n  a\n  b\n  c\nrefs of login\n  d\n  e\n  f\n  g\n  h";
+        let lines: Vec<&str> = text.lines().collect();
+        let mut view = View::new(&lines, 4);
+        assert_eq!(view.headings, [0, 4]);
+        assert!(view.apply(Key::NextHeading));
+        assert_eq!(view.top, 4);
+        view.apply(Key::PageDown);
+        assert_eq!(view.top, 7, "stops at the last full page");
+        view.apply(Key::PrevHeading);
+        assert_eq!(view.top, 4);
+        view.apply(Key::PrevHeading);
+        assert_eq!(view.top, 0);
+        view.apply(Key::Bottom);
+        view.apply(Key::LineUp);
+        assert_eq!(view.top, 6);
+        assert!(!view.apply(Key::Quit));
+
+        assert_eq!(truncate_visible("\x1b[32mabcdef\x1b[0m", 3), "\x1b[32mabc");
+        assert!(is_heading("\x1b[1mrefs\x1b[0m"));
+        assert!(!is_heading("    return t"));
+    }
+
+    #[test]
+    fn test_highlight_code_line() {
+        let line = highlight(r#"def f(x): return "a\"b" + 42  # done"#, Some("python"));
+        assert!(line.starts_with(&format!("{KEYWORD}def{RESET} f(x): ")));
+        assert!(line.contains(&format!("{STRING}\"a\\\"b\"{RESET}")));
+        assert!(line.contains(&format!("{NUMBER}42{RESET}")));
+        assert!(line.ends_with(&format!("{COMMENT}# done{RESET}")));
+        assert_eq!(strip_ansi(&line), r#"def f(x): return "a\"b" + 42  # done"#);
+
+        let rust = highlight("fn id<'a>(s: &'a str) -> &'a str { s } // ok", Some("rust"));
+        assert!(rust.contains("<'a>"), "lifetimes are not strings");
+        assert!(rust.ends_with(&format!("{COMMENT}// ok{RESET}")));
+
+        // Keywords are the line's language's own.
+        let go = highlight("func end(def int) {}", Some("go"));
+        assert!(go.starts_with(&format!("{KEYWORD}func{RESET} end(def int)")));
+        let ruby = highlight("def func; end", Some("ruby"));
+        assert_eq!(
+            ruby,
+            format!("{KEYWORD}def{RESET} func; {KEYWORD}end{RESET}")
+        );
+        assert_eq!(highlight("if x", None), "if x");
+    }
+}
@@ -0,0 +1,206 @@
+//! Panic reachability: call paths from entry points down to a panic that no
+//! recover on the way stops.
+//!
+//! Sites are recorded at index time (see `languages::panics`). A function that
+//! recovers stops panics raised in it and in everything it calls, so paths never
+//! go through one.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{EdgeKind, PanicKind, PanicSite, Symbol, SymbolKind};
+
+/// A call chain from an entry point to a function that panics.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct PanicPath {
+    /// The entry point first, the panicking function last.
+    pub steps: Vec<PathStep>,
+    /// Panic sites in the last function.
+    pub sites: Vec<PanicSite>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct PathStep {
+    pub symbol: Symbol,
+    /// Line of the call from the previous step; `None` for the entry point.
+    pub line: Option<u32>,
+}
+
+/// The shortest path from each entry point to each function that panics within
+/// `depth` calls, with no recover along it.
+///
+/// `entries` defaults to the call graph's roots: functions and methods nothing in
+/// the index calls.
+pub fn unrecovered(
+    db: &Database,
+    entries: Option<Vec<Symbol>>,
+    depth: u32,
+) -> Result<Vec<PanicPath>> {
+    let mut panics: HashMap<String, Vec<PanicSite>> = HashMap::new();
+    let mut recovers = HashSet::new();
+    for site in db.panic_sites()? {
+        match site.kind {
+            PanicKind::Panic => panics.entry(site.symbol_id.clone()).or_default().push(site),
+            PanicKind::Recover => {
+                recovers.insert(site.symbol_id);
+            }
+        }
+    }
+    if panics.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    let mut calls: HashMap<String, Vec<(String, u32)>> = HashMap::new();
+    for edge in db.all_edges()? {
+        if let (EdgeKind::Calls, Some(target)) = (edge.kind, edge.target_id) {
+            calls
+                .entry(edge.source_id)
+                .or_default()
+                .push((target, edge.line));
+        }
+    }
+    let symbols: HashMap<String, Symbol> = db
+        .all_symbols()?
+        .into_iter()
+        .map(|s| (s.id.clone(), s))
+        .collect();
+    let entries = match entries {
+        Some(entries) => entries,
+        None => {
+            let called: HashSet<&str> = calls.values().flatten().map(|(t, _)| t.as_str()).collect();
+            let mut roots: Vec<Symbol> = symbols
+                .values()
+                .filter(|s| matches!(s.kind, SymbolKind::Function | SymbolKind::Method))
+                .filter(|s| !called.contains(s.id.as_str()))
+                .cloned()
+                .collect();
+            roots.sort_by(|a, b| (&a.file_path, a.start_line).cmp(&(&b.file_path, b.start_line)));
+            roots
+        }
+    };
+
+    let mut paths = Vec::new();
+    for entry in entries {
+        if recovers.contains(&entry.id) {
+            continue;
+        }
+        // Breadth-first, so the first path found to a function is a shortest one.
+        let mut parent: HashMap<&str, (&str, u32)> = HashMap::new();
+        let mut queue = VecDeque::from([(entry.id.as_str(), 0)]);
+        let mut seen = HashSet::from([entry.id.as_str()]);
+        while let Some((id, level)) = queue.pop_front() {
+            if let Some(sites) = panics.get(id) {
+                paths.push(PanicPath {
+                    steps: path_to(id, &entry, &parent, &symbols),
+                    sites: sites.clone(),
+                });
+            }
+            if level == depth {
+                continue;
+            }
+            for (target, line) in calls.get(id).into_iter().flatten() {
+                let target = target.as_str();
+                if !recovers.contains(target) && seen.insert(target) {
+                    parent.insert(target, (id, *line));
+                    queue.push_back((target, level + 1));
+                }
+            }
+        }
+    }
+    Ok(paths)
+}
+
+/// Steps from `entry` to `id`, following `parent` links back.
+fn path_to(
+    id: &str,
+    entry: &Symbol,
+    parent: &HashMap<&str, (&str, u32)>,
+    symbols: &HashMap<String, Symbol>,
+) -> Vec<PathStep> {
+    let mut steps = Vec::new();
+    let mut current = id;
+    while let Some(&(caller, line)) = parent.get(current) {
+        if let Some(symbol) = symbols.get(current) {
+            steps.push(PathStep {
+                symbol: symbol.clone(),
+                line: Some(line),
+            });
+        }
+        current = caller;
+    }
+    steps.push(PathStep {
+        symbol: entry.clone(),
+        line: None,
+    });
+    steps.reverse();
+    steps
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::Edge;
+
+    #[test]
+    fn test_unrecovered_skips_paths_through_a_recover() {
+        let db = Database::open_memory().unwrap();
+        let file = "server/main.go";
+        let sym = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 8, 0, 100)
+        };
+        let main = sym("main", 1);
+        let serve = sym("Serve", 10);
+        let guarded = sym("Guarded", 20);
+        let must_open = sym("mustOpen", 30);
+        db.insert_symbols(&[
+            main.clone(),
+            serve.clone(),
+            guarded.clone(),
+            must_open.clone(),
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "Serve", EdgeKind::Calls, file, 3),
+            Edge::new(&main.id, "Guarded", EdgeKind::Calls, file, 4),
+            Edge::new(&serve.id, "mustOpen", EdgeKind::Calls, file, 12),
+            Edge::new(&guarded.id, "mustOpen", EdgeKind::Calls, file, 22),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let site = |symbol: &Symbol, line, kind, what: &str| PanicSite {
+            symbol_id: symbol.id.clone(),
+            line,
+            kind,
+            what: what.to_string(),
+        };
+        db.insert_panic_sites(
+            file,
+            &[
+                site(&guarded, 21, PanicKind::Recover, "recover"),
+                site(&must_open, 33, PanicKind::Panic, "panic"),
+            ],
+        )
+        .unwrap();
+
+        let paths = unrecovered(&db, None, 10).unwrap();
+        assert_eq!(paths.len(), 1);
+        let steps: Vec<_> = paths[0]
+            .steps
+            .iter()
+            .map(|s| (s.symbol.name.as_str(), s.line))
+            .collect();
+        assert_eq!(
+            steps,
+            [("main", None), ("Serve", Some(3)), ("mustOpen", Some(12))]
+        );
+        assert_eq!(paths[0].sites[0].line, 33);
+
+        assert!(unrecovered(&db, None, 1).unwrap().is_empty());
+        assert!(unrecovered(&db, Some(vec![guarded]), 10)
+            .unwrap()
+            .is_empty());
+    }
+}
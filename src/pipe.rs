@@ -0,0 +1,222 @@
+//! Query pipelining: the symbols one command finds, fed to the next.
+//!
+//! The interchange format is a list of symbol IDs, the `id` of the JSON output
+//! (`path:name:line`), one per line, in result order and without repeats.
+//! Blank lines and lines starting with `#` are skipped, so a list can be saved,
+//! annotated and trimmed by hand before it is fed back.
+//!
+//! `--ids` writes that list in place of a command's usual output. `--stdin-ids`
+//! reads one in place of a symbol name, and answers for all of its symbols at
+//! once. Each ID stands for that one symbol, not for every symbol sharing its
+//! name, so only resolved edges are followed from it:
+//!
+//! ```text
+//! cartog --ids refs Login | cartog impact --stdin-ids
+//! ```
+
+use std::collections::HashSet;
+use std::io::Read;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use anyhow::{Context, Result};
+
+use crate::db::Database;
+
+static IDS: AtomicBool = AtomicBool::new(false);
+
+/// Write symbol IDs instead of the usual output.
+pub fn enable_ids() {
+    IDS.store(true, Ordering::Relaxed);
+}
+
+/// Whether `--ids` was given.
+pub fn ids_enabled() -> bool {
+    IDS.load(Ordering::Relaxed)
+}
+
+/// Print `ids`, one per line, skipping repeats.
+pub fn write_ids<'a>(ids: impl IntoIterator<Item = &'a str>) -> Result<()> {
+    print!("{}", id_list(ids));
+    Ok(())
+}
+
+/// `ids` as an ID list, one per line, skipping repeats.
+fn id_list<'a>(ids: impl IntoIterator<Item = &'a str>) -> String {
+    let mut seen = HashSet::new();
+    ids.into_iter()
+        .filter(|id| seen.insert(*id))
+        .map(|id| format!("{id}\n"))
+        .collect()
+}
+
+/// The IDs of an ID list, without repeats.
+pub fn parse_ids(text: &str) -> Vec<String> {
+    let mut seen = HashSet::new();
+    text.lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty() && !line.starts_with('#'))
+        .filter(|id| seen.insert(*id))
+        .map(str::to_string)
+        .collect()
+}
+
+/// The ID list on standard input.
+pub fn read_stdin_ids() -> Result<Vec<String>> {
+    let mut text = String::new();
+    std::io::stdin()
+        .read_to_string(&mut text)
+        .context("Failed to read symbol IDs from stdin")?;
+    Ok(parse_ids(&text))
+}
+
+/// What a query runs for: every symbol with a name, or one symbol by ID.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Target {
+    Name(String),
+    Id(String),
+}
+
+impl Target {
+    /// The name or ID, for messages.
+    pub fn as_str(&self) -> &str {
+        match self {
+            Self::Name(name) => name,
+            Self::Id(id) => id,
+        }
+    }
+}
+
+/// `ids` as query targets. Every ID must name a symbol of the index: a list
+/// written before the last index run may not.
+pub fn id_targets(db: &Database, ids: &[String]) -> Result<Vec<Target>> {
+    ids.iter()
+        .map(|id| {
+            db.get_symbol(id)?.with_context(|| {
+                format!("no symbol with id '{id}' (was the list written before the last index?)")
+            })?;
+            Ok(Target::Id(id.clone()))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, Symbol, SymbolKind};
+
+    #[test]
+    fn test_id_list_round_trip() {
+        let text = "# refs Login\nauth/login.go:Login:12\n\n  api/handler.go:Handle:40  \nauth/login.go:Login:12\n";
+        let ids = parse_ids(text);
+        assert_eq!(ids, ["auth/login.go:Login:12", "api/handler.go:Handle:40"]);
+
+        let db = Database::open_memory().unwrap();
+        let login = Symbol::new(
+            "Login",
+            SymbolKind::Function,
+            "auth/login.go",
+            12,
+            20,
+            0,
+            100,
+        );
+        let handle = Symbol::new(
+            "Handle",
+            SymbolKind::Method,
+            "api/handler.go",
+            40,
+            60,
+            0,
+            100,
+        );
+        let other = Symbol::new("Login", SymbolKind::Method, "api/session.go", 5, 9, 0, 100);
+        db.insert_symbols(&[login.clone(), handle.clone(), other.clone()])
+            .unwrap();
+        let call = |target: &Symbol, line: u32| Edge {
+            target_id: Some(target.id.clone()),
+            ..Edge::new(&handle.id, "Login", EdgeKind::Calls, "api/handler.go", line)
+        };
+        db.insert_edges(&[call(&login, 42), call(&other, 43)])
+            .unwrap();
+
+        let targets = id_targets(&db, &ids).unwrap();
+        assert_eq!(
+            targets,
+            [Target::Id(login.id.clone()), Target::Id(handle.id.clone())]
+        );
+        // Only the listed `Login` is referenced, not the other one of that name.
+        let refs = db.refs_to_id(targets[0].as_str(), None).unwrap();
+        assert_eq!(refs.len(), 1);
+        assert_eq!(refs[0].0.line, 42);
+        assert_eq!(db.refs(&login.name, None).unwrap().len(), 2);
+
+        let mut ids = ids;
+        ids.push("gone.go:Old:1".to_string());
+        assert!(id_targets(&db, &ids).is_err());
+    }
+
+    #[test]
+    fn test_search_ids_feed_impact_and_callees_per_symbol() {
+        let db = Database::open_memory().unwrap();
+        let symbol = |name: &str, kind, file: &str, line| {
+            Symbol::new(name, kind, file, line, line + 5, 0, 100)
+        };
+        let login = symbol("Login", SymbolKind::Function, "auth/login.go", 12);
+        let session_login = symbol("Login", SymbolKind::Method, "api/session.go", 5);
+        let handle = symbol("Handle", SymbolKind::Function, "api/handler.go", 40);
+        let refresh = symbol("Refresh", SymbolKind::Function, "api/refresh.go", 3);
+        let hash = symbol("hash", SymbolKind::Function, "auth/hash.go", 1);
+        let touch = symbol("touch", SymbolKind::Function, "api/session.go", 20);
+        db.insert_symbols(&[
+            login.clone(),
+            session_login.clone(),
+            handle.clone(),
+            refresh.clone(),
+            hash.clone(),
+            touch.clone(),
+        ])
+        .unwrap();
+        let call = |from: &Symbol, to: &Symbol| Edge {
+            target_id: Some(to.id.clone()),
+            ..Edge::new(
+                &from.id,
+                &to.name,
+                EdgeKind::Calls,
+                &from.file_path,
+                from.start_line + 1,
+            )
+        };
+        db.insert_edges(&[
+            call(&handle, &login),
+            call(&refresh, &session_login),
+            call(&login, &hash),
+            call(&session_login, &touch),
+        ])
+        .unwrap();
+
+        // cartog --ids search Login | cartog impact --stdin-ids (or callees)
+        let found = db.search("Login", None, None, 10).unwrap();
+        let list = id_list(found.iter().map(|s| s.id.as_str()));
+        let targets = id_targets(&db, &parse_ids(&list)).unwrap();
+        assert_eq!(targets.len(), 2);
+
+        for (target, caller, callee) in
+            [(&login, &handle, &hash), (&session_login, &refresh, &touch)]
+        {
+            let id = Target::Id(target.id.clone());
+            assert!(targets.contains(&id), "{} listed", target.id);
+            let impact = db.impact_of_id(id.as_str(), 3).unwrap();
+            let sources: Vec<&str> = impact.iter().map(|(e, _)| e.source_id.as_str()).collect();
+            assert_eq!(sources, [caller.id.as_str()], "impact of {}", target.id);
+            let callees = db.callees_of_id(id.as_str()).unwrap();
+            let called: Vec<Option<&str>> =
+                callees.iter().map(|e| e.target_id.as_deref()).collect();
+            assert_eq!(
+                called,
+                [Some(callee.id.as_str())],
+                "callees of {}",
+                target.id
+            );
+        }
+    }
+}
@@ -0,0 +1,727 @@
+//! Parallel parse stage of indexing.
+//!
+//! A walker thread feeds file paths into a bounded channel; worker threads read,
+//! hash and extract them; the calling thread drains results and owns all database
+//! writes (a `rusqlite::Connection` cannot be shared across threads). Both channels
+//! are bounded, so a slow writer throttles the workers and the walker.
+//!
+//! Parsed results waiting for the writer are charged against a memory budget.
+//! When a worker would exceed it, the result is spilled to a temporary file and
+//! only its path travels through the channel; the writer reloads it on its turn.
+//!
+//! A file whose content was parsed before, on another branch, is not parsed
+//! again: the worker only hashes it and the writer restores the result from the
+//! index's parse cache (see [`Database::save_branch_state`]).
+//!
+//! [`Database::save_branch_state`]: crate::db::Database::save_branch_state
+
+use std::collections::hash_map::Entry;
+use std::collections::{HashMap, HashSet};
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::mpsc::{sync_channel, Receiver, SyncSender};
+use std::sync::{Arc, Mutex, OnceLock};
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use tracing::{debug_span, warn};
+
+use crate::config::Passes;
+use crate::dupes::{self, Fingerprint, SIGNATURE_LEN};
+use crate::indexer::{extract_symbol_content, file_hash, file_modified};
+use crate::languages::{get_extractor, Extractor, LANGUAGES};
+use crate::plugins::PluginRegistry;
+use crate::types::{
+    Complexity, ConfigField, Constant, Construction, ContextSite, Edge, ErrorFlow, FieldUse,
+    LogStatement, PanicSite, Route, Serialization, StructField, Symbol, SymbolKind, SyncSite,
+    SyntaxError, TestDouble, Todo, TypeAssertion, VariableAccess,
+};
+
+/// Default cap on parsed-but-unwritten results, in bytes.
+pub const DEFAULT_MEMORY_CAP: usize = 512 * 1024 * 1024;
+
+/// Rough per-item overhead used when estimating the size of a parsed file.
+const SYMBOL_OVERHEAD: usize = 256;
+const EDGE_OVERHEAD: usize = 128;
+const METRIC_OVERHEAD: usize = 64;
+const FINGERPRINT_OVERHEAD: usize = 96 + SIGNATURE_LEN * 4;
+
+/// Tuning knobs for the parse pipeline.
+#[derive(Debug, Clone)]
+pub struct PipelineConfig {
+    /// Worker threads parsing files.
+    pub jobs: usize,
+    /// Budget for parsed results queued for the writer; beyond it they spill to disk.
+    pub memory_cap: usize,
+}
+
+impl Default for PipelineConfig {
+    fn default() -> Self {
+        Self {
+            jobs: std::thread::available_parallelism().map_or(1, |n| n.get()),
+            memory_cap: DEFAULT_MEMORY_CAP,
+        }
+    }
+}
+
+/// A file the walker wants parsed.
+#[derive(Debug)]
+pub(crate) struct ParseJob {
+    pub rel_path: String,
+    pub path: PathBuf,
+    /// A built-in language or one claimed by an extractor plugin.
+    pub lang: String,
+    /// Optional passes configured for this path (`[[extract.rules]]`).
+    pub passes: Passes,
+    /// Whether `[tags]` rules need the comments above each symbol.
+    pub preambles: bool,
+}
+
+/// A parse cache entry: `(path, content hash, extraction settings)`.
+pub(crate) type CacheKey = (String, String, String);
+
+/// The settings a cached parse result of `job` is valid for: extractors change
+/// between releases, and the passes and preambles decide what is extracted.
+/// `None` for plugin languages, whose extractor can change at any time.
+pub(crate) fn cache_settings(job: &ParseJob) -> Option<String> {
+    LANGUAGES.contains(&job.lang.as_str()).then(|| {
+        format!(
+            "{} {} {:?} {}",
+            env!("CARGO_PKG_VERSION"),
+            job.lang,
+            job.passes,
+            job.preambles
+        )
+    })
+}
+
+/// Everything the writer needs to store one file.
+#[derive(Debug, Serialize, Deserialize)]
+pub(crate) struct ParsedFile {
+    pub rel_path: String,
+    pub lang: String,
+    pub hash: String,
+    pub modified: f64,
+    /// Extraction settings, when the result may go to the parse cache.
+    pub settings: Option<String>,
+    pub symbols: Vec<Symbol>,
+    pub edges: Vec<Edge>,
+    /// `(symbol_id, name, content, header)` rows for the RAG content table.
+    pub contents: Vec<(String, String, String, String)>,
+    /// `(symbol_id, hash)` of every non-import symbol's source, kept even when the
+    /// content pass is skipped so the next run can tell which symbols changed.
+    pub body_hashes: Vec<(String, String)>,
+    /// `(symbol_id, preamble)` for symbols with comments or attributes right above them.
+    pub preambles: Vec<(String, String)>,
+    /// `(symbol_id, complexity)` for functions and methods.
+    pub complexity: Vec<(String, Complexity)>,
+    /// `(symbol_id, fingerprint)` for function and method bodies long enough to compare.
+    pub fingerprints: Vec<(String, Fingerprint)>,
+    /// Ids of functions and methods that return or raise errors of their own.
+    pub fallible: Vec<String>,
+    pub error_flows: Vec<ErrorFlow>,
+    pub panic_sites: Vec<PanicSite>,
+    pub sync_sites: Vec<SyncSite>,
+    pub takes_context: Vec<String>,
+    pub context_sites: Vec<ContextSite>,
+    pub globals: Vec<String>,
+    pub variable_accesses: Vec<VariableAccess>,
+    pub routes: Vec<Route>,
+    pub config_fields: Vec<ConfigField>,
+    pub field_uses: Vec<FieldUse>,
+    pub struct_fields: Vec<StructField>,
+    pub serializations: Vec<Serialization>,
+    pub constants: Vec<Constant>,
+    pub type_assertions: Vec<TypeAssertion>,
+    pub test_doubles: Vec<TestDouble>,
+    pub constructions: Vec<Construction>,
+    pub syntax_errors: Vec<SyntaxError>,
+    pub log_statements: Vec<LogStatement>,
+    pub todos: Vec<Todo>,
+}
+
+impl ParsedFile {
+    /// Approximate heap footprint, used for the memory budget.
+    fn estimated_bytes(&self) -> usize {
+        let contents: usize = self
+            .contents
+            .iter()
+            .map(|(id, name, content, header)| id.len() + name.len() + content.len() + header.len())
+            .sum();
+        let preambles: usize = self
+            .preambles
+            .iter()
+            .map(|(id, text)| id.len() + text.len())
+            .sum();
+        let body_hashes: usize = self
+            .body_hashes
+            .iter()
+            .map(|(id, hash)| id.len() + hash.len())
+            .sum();
+        contents
+            + preambles
+            + body_hashes
+            + self.symbols.len() * SYMBOL_OVERHEAD
+            + self.edges.len() * EDGE_OVERHEAD
+            + self.complexity.len() * METRIC_OVERHEAD
+            + self.fingerprints.len() * FINGERPRINT_OVERHEAD
+            + self.fallible.iter().map(String::len).sum::<usize>()
+            + self.error_flows.len() * EDGE_OVERHEAD
+            + self.panic_sites.len() * EDGE_OVERHEAD
+            + self.sync_sites.len() * EDGE_OVERHEAD
+            + self.takes_context.iter().map(String::len).sum::<usize>()
+            + self.context_sites.len() * EDGE_OVERHEAD
+            + self.globals.iter().map(String::len).sum::<usize>()
+            + self.variable_accesses.len() * EDGE_OVERHEAD
+            + self.routes.len() * EDGE_OVERHEAD
+            + self.config_fields.len() * EDGE_OVERHEAD
+            + self.field_uses.len() * EDGE_OVERHEAD
+            + self.struct_fields.len() * EDGE_OVERHEAD
+            + self.serializations.len() * EDGE_OVERHEAD
+            + self.constants.len() * EDGE_OVERHEAD
+            + self.type_assertions.len() * EDGE_OVERHEAD
+            + self.test_doubles.len() * EDGE_OVERHEAD
+            + self.constructions.len() * EDGE_OVERHEAD
+            + self.syntax_errors.len() * EDGE_OVERHEAD
+            + self.log_statements.len() * EDGE_OVERHEAD
+            + self.todos.len() * EDGE_OVERHEAD
+    }
+}
+
+/// What the writer receives for each job.
+pub(crate) enum ParseOutcome {
+    /// Content hash matches the index; nothing to write.
+    Unchanged,
+    Parsed(ParsedFile),
+    /// Content in the parse cache; the writer restores it from there.
+    Cached(CachedFile),
+}
+
+/// A file to restore from the parse cache.
+#[derive(Debug)]
+pub(crate) struct CachedFile {
+    pub rel_path: String,
+    pub hash: String,
+    pub settings: String,
+    pub modified: f64,
+}
+
+/// Message from a worker to the writer.
+enum WorkerMsg {
+    Unchanged,
+    Parsed(ParsedFile, usize),
+    Spilled(PathBuf),
+    Cached(CachedFile),
+}
+
+/// Byte budget for results in flight between workers and the writer.
+struct MemoryBudget {
+    cap: usize,
+    used: Mutex<usize>,
+}
+
+impl MemoryBudget {
+    fn new(cap: usize) -> Self {
+        Self {
+            cap,
+            used: Mutex::new(0),
+        }
+    }
+
+    /// Reserve `n` bytes if they fit. A single oversized item is admitted when
+    /// nothing else is in flight, so progress is always possible.
+    fn try_acquire(&self, n: usize) -> bool {
+        let mut used = self.used.lock().unwrap_or_else(|e| e.into_inner());
+        if *used == 0 || *used + n <= self.cap {
+            *used += n;
+            true
+        } else {
+            false
+        }
+    }
+
+    fn release(&self, n: usize) {
+        let mut used = self.used.lock().unwrap_or_else(|e| e.into_inner());
+        *used = used.saturating_sub(n);
+    }
+}
+
+/// Lazily created directory for spilled results, removed on drop.
+struct SpillDir {
+    dir: OnceLock<PathBuf>,
+    seq: AtomicUsize,
+}
+
+impl SpillDir {
+    fn new() -> Self {
+        Self {
+            dir: OnceLock::new(),
+            seq: AtomicUsize::new(0),
+        }
+    }
+
+    fn write(&self, parsed: &ParsedFile) -> Result<PathBuf> {
+        let dir = self.dir.get_or_init(|| {
+            std::env::temp_dir().join(format!("cartog-spill-{}", std::process::id()))
+        });
+        std::fs::create_dir_all(dir)
+            .with_context(|| format!("failed to create spill dir {}", dir.display()))?;
+        let path = dir.join(format!("{}.json", self.seq.fetch_add(1, Ordering::Relaxed)));
+        let file = std::fs::File::create(&path)?;
+        serde_json::to_writer(std::io::BufWriter::new(file), parsed)?;
+        Ok(path)
+    }
+
+    fn read(path: &PathBuf) -> Result<ParsedFile> {
+        let file = std::fs::File::open(path)
+            .with_context(|| format!("failed to open spill file {}", path.display()))?;
+        let parsed = serde_json::from_reader(std::io::BufReader::new(file))?;
+        let _ = std::fs::remove_file(path);
+        Ok(parsed)
+    }
+}
+
+impl Drop for SpillDir {
+    fn drop(&mut self) {
+        if let Some(dir) = self.dir.get() {
+            let _ = std::fs::remove_dir_all(dir);
+        }
+    }
+}
+
+/// Run `walk` on its own thread, parse the jobs it emits on `config.jobs` workers,
+/// and hand every outcome to `sink` on the calling thread.
+///
+/// Files whose hash matches `known_hashes` come back as [`ParseOutcome::Unchanged`],
+/// and those with a `cached` result as [`ParseOutcome::Cached`], unless `force` is set. Unreadable, binary or unparsable files are dropped with a
+/// warning. `walk` should stop when `send` fails: the pipeline is shutting down
+/// after a `sink` error. Returns whatever `walk` returned.
+pub(crate) fn run<T: Send>(
+    config: &PipelineConfig,
+    plugins: &PluginRegistry,
+    known_hashes: &HashMap<String, String>,
+    cached: &HashSet<CacheKey>,
+    force: bool,
+    walk: impl FnOnce(&SyncSender<ParseJob>) -> T + Send,
+    mut sink: impl FnMut(ParseOutcome) -> Result<()>,
+) -> Result<T> {
+    let jobs = config.jobs.max(1);
+    let budget = MemoryBudget::new(config.memory_cap);
+    let spill = SpillDir::new();
+
+    std::thread::scope(|scope| {
+        let (job_tx, job_rx) = sync_channel::<ParseJob>(jobs * 2);
+        let (msg_tx, msg_rx) = sync_channel::<WorkerMsg>(jobs * 2);
+        let job_rx = Arc::new(Mutex::new(job_rx));
+
+        let walker = scope.spawn(move || walk(&job_tx));
+
+        for _ in 0..jobs {
+            let job_rx = Arc::clone(&job_rx);
+            let msg_tx = msg_tx.clone();
+            let (budget, spill) = (&budget, &spill);
+            scope.spawn(move || {
+                worker(
+                    &job_rx,
+                    &msg_tx,
+                    plugins,
+                    known_hashes,
+                    cached,
+                    force,
+                    budget,
+                    spill,
+                )
+            });
+        }
+        // Only workers may hold the channel ends, so each side sees the other hang up.
+        drop(job_rx);
+        drop(msg_tx);
+
+        let drained = drain(&msg_rx, &budget, &mut sink);
+        // On error, dropping the receiver makes worker sends fail, which unwinds the pipeline.
+        drop(msg_rx);
+        let walked = walker
+            .join()
+            .map_err(|_| anyhow::anyhow!("file walker panicked"))?;
+        drained.map(|()| walked)
+    })
+}
+
+fn drain(
+    msg_rx: &Receiver<WorkerMsg>,
+    budget: &MemoryBudget,
+    sink: &mut impl FnMut(ParseOutcome) -> Result<()>,
+) -> Result<()> {
+    for msg in msg_rx {
+        match msg {
+            WorkerMsg::Unchanged => sink(ParseOutcome::Unchanged)?,
+            WorkerMsg::Cached(file) => sink(ParseOutcome::Cached(file))?,
+            WorkerMsg::Parsed(parsed, charge) => {
+                let written = sink(ParseOutcome::Parsed(parsed));
+                budget.release(charge);
+                written?;
+            }
+            WorkerMsg::Spilled(path) => sink(ParseOutcome::Parsed(SpillDir::read(&path)?))?,
+        }
+    }
+    Ok(())
+}
+
+fn worker(
+    job_rx: &Mutex<Receiver<ParseJob>>,
+    msg_tx: &SyncSender<WorkerMsg>,
+    plugins: &PluginRegistry,
+    known_hashes: &HashMap<String, String>,
+    cached: &HashSet<CacheKey>,
+    force: bool,
+    budget: &MemoryBudget,
+    spill: &SpillDir,
+) {
+    // One extractor (with its Parser) per language, reused for every file this worker sees.
+    let mut extractors: HashMap<String, Box<dyn Extractor>> = HashMap::new();
+
+    loop {
+        let job = {
+            let rx = job_rx.lock().unwrap_or_else(|e| e.into_inner());
+            match rx.recv() {
+                Ok(job) => job,
+                Err(_) => return, // walker finished
+            }
+        };
+
+        let Some(parsed) = parse(&job, plugins, known_hashes, cached, force, &mut extractors)
+        else {
+            continue;
+        };
+        let msg = match parsed {
+            ParseOutcome::Unchanged => WorkerMsg::Unchanged,
+            ParseOutcome::Cached(file) => WorkerMsg::Cached(file),
+            ParseOutcome::Parsed(parsed) => {
+                let charge = parsed.estimated_bytes();
+                if budget.try_acquire(charge) {
+                    WorkerMsg::Parsed(parsed, charge)
+                } else {
+                    match spill.write(&parsed) {
+                        Ok(path) => WorkerMsg::Spilled(path),
+                        Err(e) => {
+                            // Disk full or unwritable temp dir: fall back to holding it in memory.
+                            warn!(error = %e, "failed to spill parsed file");
+                            WorkerMsg::Parsed(parsed, 0)
+                        }
+                    }
+                }
+            }
+        };
+        if msg_tx.send(msg).is_err() {
+            return; // writer stopped
+        }
+    }
+}
+
+fn parse(
+    job: &ParseJob,
+    plugins: &PluginRegistry,
+    known_hashes: &HashMap<String, String>,
+    cached: &HashSet<CacheKey>,
+    force: bool,
+    extractors: &mut HashMap<String, Box<dyn Extractor>>,
+) -> Option<ParseOutcome> {
+    let _span = debug_span!("parse_file", file = %job.rel_path).entered();
+    let source = match std::fs::read_to_string(&job.path) {
+        Ok(s) => s,
+        Err(e) if e.kind() == std::io::ErrorKind::InvalidData => return None, // binary file
+        Err(e) => {
+            warn!(file = %job.rel_path, error = %e, "cannot read file");
+            return None;
+        }
+    };
+
+    let hash = file_hash(&source);
+
+    // Hash-based check: even for git-detected changes, skip if content is identical
+    // (handles touched-but-not-modified files)
+    if !force && known_hashes.get(&job.rel_path) == Some(&hash) {
+        return Some(ParseOutcome::Unchanged);
+    }
+    let settings = cache_settings(job);
+    if let Some(settings) = settings.as_ref().filter(|_| !force) {
+        let key = (job.rel_path.clone(), hash.clone(), settings.clone());
+        if cached.contains(&key) {
+            let (rel_path, hash, settings) = key;
+            return Some(ParseOutcome::Cached(CachedFile {
+                rel_path,
+                hash,
+                settings,
+                modified: file_modified(&job.path),
+            }));
+        }
+    }
+
+    let extractor = match extractors.entry(job.lang.clone()) {
+        Entry::Occupied(e) => e.into_mut(),
+        Entry::Vacant(e) => e.insert(
+            get_extractor(&job.lang)
+                .or_else(|| plugins.extractor(&job.lang))
+                .expect("lang was validated by detect_language"),
+        ),
+    }
+    .as_mut();
+
+    let mut extraction = match extractor.extract(&source, &job.rel_path) {
+        Ok(e) => e,
+        Err(err) => {
+            warn!(file = %job.rel_path, error = %err, "extraction failed");
+            return None;
+        }
+    };
+    if job.passes != Passes::ALL {
+        extraction.edges.retain(|e| job.passes.edge(e.kind));
+    }
+
+    // Symbol content for RAG/semantic search. Bodies are hashed even when the
+    // content pass is skipped: rename tracking and `on_symbol_changed` need them.
+    let mut contents = Vec::new();
+    let mut body_hashes = Vec::new();
+    for sym in extraction
+        .symbols
+        .iter()
+        .filter(|sym| sym.kind != SymbolKind::Import)
+    {
+        if let Some((content, header)) = extract_symbol_content(&source, sym) {
+            body_hashes.push((sym.id.clone(), file_hash(&content)));
+            if job.passes.content() {
+                contents.push((sym.id.clone(), sym.name.clone(), content, header));
+            }
+        }
+    }
+
+    let preambles = if job.preambles {
+        let lines: Vec<&str> = source.lines().collect();
+        extraction
+            .symbols
+            .iter()
+            .filter_map(|sym| {
+                let text = preamble(&lines, sym.start_line);
+                (!text.is_empty()).then(|| (sym.id.clone(), text))
+            })
+            .collect()
+    } else {
+        Vec::new()
+    };
+
+    let fingerprints = extraction
+        .symbols
+        .iter()
+        .filter(|sym| matches!(sym.kind, SymbolKind::Function | SymbolKind::Method))
+        .filter_map(|sym| {
+            let body = source.get(sym.start_byte as usize..sym.end_byte as usize)?;
+            Some((sym.id.clone(), dupes::fingerprint(body)?))
+        })
+        .collect();
+
+    Some(ParseOutcome::Parsed(ParsedFile {
+        rel_path: job.rel_path.clone(),
+        lang: job.lang.clone(),
+        hash,
+        modified: file_modified(&job.path),
+        settings,
+        symbols: extraction.symbols,
+        edges: extraction.edges,
+        contents,
+        body_hashes,
+        preambles,
+        complexity: extraction.complexity,
+        fingerprints,
+        fallible: extraction.fallible,
+        error_flows: extraction.error_flows,
+        panic_sites: extraction.panic_sites,
+        sync_sites: extraction.sync_sites,
+        takes_context: extraction.takes_context,
+        context_sites: extraction.context_sites,
+        globals: extraction.globals,
+        variable_accesses: extraction.variable_accesses,
+        routes: extraction.routes,
+        config_fields: extraction.config_fields,
+        field_uses: extraction.field_uses,
+        struct_fields: extraction.struct_fields,
+        serializations: extraction.serializations,
+        constants: extraction.constants,
+        type_assertions: extraction.type_assertions,
+        test_doubles: extraction.test_doubles,
+        constructions: extraction.constructions,
+        syntax_errors: extraction.syntax_errors,
+        log_statements: extraction.log_statements,
+        todos: extraction.todos,
+    }))
+}
+
+/// Longest comment or attribute block `preamble` looks at, in lines.
+const MAX_PREAMBLE_LINES: usize = 20;
+
+/// The comment and attribute lines directly above `start_line` (1-based):
+/// `// ...`, `# ...`, `#[...]`, `@decorator`, `/** ... */`, `-- ...`.
+fn preamble(lines: &[&str], start_line: u32) -> String {
+    let end = (start_line as usize).saturating_sub(1).min(lines.len());
+    let mut start = end;
+    while start > 0 && end - start < MAX_PREAMBLE_LINES {
+        let line = lines[start - 1].trim_start();
+        let is_annotation = ["//", "#", "/*", "*", "@", "--"]
+            .iter()
+            .any(|prefix| line.starts_with(prefix));
+        if !is_annotation {
+            break;
+        }
+        start -= 1;
+    }
+    lines[start..end].join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn parsed(name: &str, content_len: usize) -> ParsedFile {
+        ParsedFile {
+            rel_path: format!("{name}.py"),
+            lang: "python".to_string(),
+            hash: "h".to_string(),
+            modified: 0.0,
+            settings: None,
+            symbols: Vec::new(),
+            edges: Vec::new(),
+            contents: vec![(
+                "id".to_string(),
+                name.to_string(),
+                "x".repeat(content_len),
+                String::new(),
+            )],
+            body_hashes: Vec::new(),
+            preambles: Vec::new(),
+            complexity: Vec::new(),
+            fingerprints: Vec::new(),
+            fallible: Vec::new(),
+            error_flows: Vec::new(),
+            panic_sites: Vec::new(),
+            sync_sites: Vec::new(),
+            takes_context: Vec::new(),
+            context_sites: Vec::new(),
+            globals: Vec::new(),
+            variable_accesses: Vec::new(),
+            routes: Vec::new(),
+            config_fields: Vec::new(),
+            field_uses: Vec::new(),
+            struct_fields: Vec::new(),
+            serializations: Vec::new(),
+            constants: Vec::new(),
+            type_assertions: Vec::new(),
+            test_doubles: Vec::new(),
+            constructions: Vec::new(),
+            syntax_errors: Vec::new(),
+            log_statements: Vec::new(),
+            todos: Vec::new(),
+        }
+    }
+
+    #[test]
+    fn test_preamble_stops_at_code_and_blank_lines() {
+        let lines = [
+            "x = 1",
+            "",
+            "# Legacy entry point.",
+            "@deprecated",
+            "@app.get('/users')",
+            "def get_users():",
+        ];
+        assert_eq!(
+            preamble(&lines, 6),
+            "# Legacy entry point.\n@deprecated\n@app.get('/users')"
+        );
+        assert_eq!(preamble(&lines, 1), "");
+        assert_eq!(preamble(&lines, 2), "");
+    }
+
+    #[test]
+    fn test_budget_admits_oversized_item_only_when_idle() {
+        let budget = MemoryBudget::new(100);
+        assert!(budget.try_acquire(500), "idle budget admits anything");
+        assert!(!budget.try_acquire(1));
+        budget.release(500);
+        assert!(budget.try_acquire(60));
+        assert!(budget.try_acquire(40));
+        assert!(!budget.try_acquire(1));
+    }
+
+    #[test]
+    fn test_spill_roundtrip_removes_file() {
+        let spill = SpillDir::new();
+        let path = spill.write(&parsed("big", 1000)).unwrap();
+        assert!(path.exists());
+        let back = SpillDir::read(&path).unwrap();
+        assert_eq!(back.rel_path, "big.py");
+        assert_eq!(back.contents[0].2.len(), 1000);
+        assert!(!path.exists());
+    }
+
+    #[test]
+    fn test_run_parses_and_skips_unchanged() {
+        let fixtures = std::path::Path::new(env!("CARGO_MANIFEST_DIR")).join("tests/fixtures/auth");
+        let Ok(entries) = std::fs::read_dir(&fixtures) else {
+            return;
+        };
+        let files: Vec<(String, PathBuf)> = entries
+            .filter_map(|e| e.ok())
+            .map(|e| e.path())
+            .filter(|p| p.extension().is_some_and(|ext| ext == "py"))
+            .map(|p| (p.file_name().unwrap().to_string_lossy().into_owned(), p))
+            .collect();
+        let (first_name, first_path) = files[0].clone();
+        let known = HashMap::from([(
+            first_name,
+            file_hash(&std::fs::read_to_string(&first_path).unwrap()),
+        )]);
+
+        // A tiny cap forces most results through the spill path.
+        let config = PipelineConfig {
+            jobs: 3,
+            memory_cap: 1,
+        };
+        let (mut parsed, mut unchanged) = (0, 0);
+        let walked = run(
+            &config,
+            &PluginRegistry::default(),
+            &known,
+            &HashSet::new(),
+            false,
+            |tx| {
+                for (rel_path, path) in &files {
+                    let job = ParseJob {
+                        rel_path: rel_path.clone(),
+                        path: path.clone(),
+                        lang: "python".to_string(),
+                        passes: Passes::ALL,
+                        preambles: false,
+                    };
+                    if tx.send(job).is_err() {
+                        break;
+                    }
+                }
+                files.len()
+            },
+            |outcome| {
+                match outcome {
+                    ParseOutcome::Unchanged => unchanged += 1,
+                    ParseOutcome::Parsed(p) => {
+                        assert!(!p.symbols.is_empty());
+                        parsed += 1;
+                    }
+                    ParseOutcome::Cached(_) => unreachable!("nothing is cached"),
+                }
+                Ok(())
+            },
+        )
+        .unwrap();
+        assert_eq!(walked, files.len());
+        assert_eq!(unchanged, 1);
+        assert_eq!(parsed, files.len() - 1);
+    }
+}
@@ -0,0 +1,491 @@
+//! Extractor plugins compiled to WebAssembly (WASI).
+//!
+//! A plugin is a WASI command module, `<name>.wasm`, next to a manifest,
+//! `<name>.toml`, in the plugin directory (`[plugins] dir`, default
+//! [`DEFAULT_PLUGIN_DIR`]):
+//!
+//! ```toml
+//! language = "protobuf"
+//! extensions = ["proto"]
+//! ```
+//!
+//! For every file with a claimed extension, the module runs once in a fresh
+//! sandbox. It has no filesystem, network or environment access, and its memory
+//! and instruction budget are capped. The host writes a [`PluginInput`] to stdin
+//! as JSON. The plugin writes its symbols and edges to stdout (see
+//! [`PluginOutput`]) and exits with status 0.
+//!
+//! Built-in languages keep their extensions: a plugin can only claim extensions
+//! that no built-in extractor handles. Running plugins needs the `plugins` cargo
+//! feature. Without it, discovered plugins are reported and skipped.
+
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use tracing::warn;
+
+use crate::languages::{ExtractionResult, Extractor};
+use crate::types::{Edge, EdgeKind, Symbol, SymbolKind, Visibility};
+
+/// Version of the stdin/stdout contract, sent to the plugin with every file.
+pub const ABI_VERSION: u32 = 1;
+
+/// Plugin directory when `[plugins] dir` is not set, relative to the project root.
+pub const DEFAULT_PLUGIN_DIR: &str = ".cartog/plugins";
+
+/// `<name>.toml` next to `<name>.wasm`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct PluginManifest {
+    /// Stored as the file's language and usable in `[languages]` toggles.
+    pub language: String,
+    /// File extensions without the dot.
+    pub extensions: Vec<String>,
+}
+
+/// Sent to the plugin on stdin.
+#[derive(Debug, Serialize)]
+pub struct PluginInput<'a> {
+    pub abi: u32,
+    pub file_path: &'a str,
+    pub source: &'a str,
+}
+
+/// Read from the plugin's stdout.
+#[derive(Debug, Default, Deserialize)]
+pub struct PluginOutput {
+    #[serde(default)]
+    pub symbols: Vec<PluginSymbol>,
+    #[serde(default)]
+    pub edges: Vec<PluginEdge>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct PluginSymbol {
+    pub name: String,
+    pub kind: SymbolKind,
+    /// 1-based, inclusive.
+    pub start_line: u32,
+    pub end_line: u32,
+    #[serde(default)]
+    pub start_byte: u32,
+    #[serde(default)]
+    pub end_byte: u32,
+    /// Index of the enclosing symbol in `symbols`.
+    #[serde(default)]
+    pub parent: Option<usize>,
+    #[serde(default)]
+    pub signature: Option<String>,
+    #[serde(default)]
+    pub docstring: Option<String>,
+    #[serde(default)]
+    pub visibility: Option<Visibility>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct PluginEdge {
+    /// Index of the symbol the edge starts from in `symbols`.
+    pub source: usize,
+    /// Name to resolve, as for built-in extractors.
+    pub target: String,
+    pub kind: EdgeKind,
+    pub line: u32,
+}
+
+impl PluginOutput {
+    /// Turn indices into symbol IDs, rejecting indices that point nowhere.
+    pub fn into_extraction(self, file_path: &str) -> Result<ExtractionResult> {
+        let ids: Vec<String> = self
+            .symbols
+            .iter()
+            .map(|s| crate::types::symbol_id(file_path, &s.name, s.start_line))
+            .collect();
+        let id_at = |index: usize, what: &str| {
+            ids.get(index)
+                .cloned()
+                .with_context(|| format!("{what} index {index} out of range"))
+        };
+
+        let mut symbols = Vec::with_capacity(self.symbols.len());
+        for s in self.symbols {
+            let parent = s.parent.map(|i| id_at(i, "parent")).transpose()?;
+            symbols.push(
+                Symbol::new(
+                    s.name,
+                    s.kind,
+                    file_path,
+                    s.start_line,
+                    s.end_line.max(s.start_line),
+                    s.start_byte,
+                    s.end_byte.max(s.start_byte),
+                )
+                .with_parent(parent.as_deref())
+                .with_signature(s.signature)
+                .with_docstring(s.docstring)
+                .with_visibility(s.visibility.unwrap_or(Visibility::Public)),
+            );
+        }
+        let edges = self
+            .edges
+            .into_iter()
+            .map(|e| {
+                Ok(Edge::new(
+                    id_at(e.source, "edge source")?,
+                    e.target,
+                    e.kind,
+                    file_path,
+                    e.line,
+                ))
+            })
+            .collect::<Result<_>>()?;
+        // Plugins report no complexity.
+        Ok(ExtractionResult {
+            symbols,
+            edges,
+            complexity: Vec::new(),
+            fallible: Vec::new(),
+            error_flows: Vec::new(),
+            panic_sites: Vec::new(),
+            sync_sites: Vec::new(),
+            takes_context: Vec::new(),
+            context_sites: Vec::new(),
+            globals: Vec::new(),
+            variable_accesses: Vec::new(),
+            routes: Vec::new(),
+            config_fields: Vec::new(),
+            field_uses: Vec::new(),
+            struct_fields: Vec::new(),
+            serializations: Vec::new(),
+            constants: Vec::new(),
+            type_assertions: Vec::new(),
+            test_doubles: Vec::new(),
+            constructions: Vec::new(),
+            syntax_errors: Vec::new(),
+            log_statements: Vec::new(),
+            todos: Vec::new(),
+        })
+    }
+}
+
+/// A discovered plugin. The module is compiled on first use.
+#[derive(Debug)]
+pub struct Plugin {
+    pub name: String,
+    pub manifest: PluginManifest,
+    pub wasm: PathBuf,
+    #[cfg(feature = "plugins")]
+    compiled: std::sync::OnceLock<std::result::Result<runtime::Compiled, String>>,
+}
+
+/// Plugins found in the plugin directory.
+#[derive(Debug, Clone, Default)]
+pub struct PluginRegistry {
+    plugins: Vec<Arc<Plugin>>,
+}
+
+impl PluginRegistry {
+    /// Read every manifest in `dir`. A missing directory means no plugins.
+    ///
+    /// Manifests that fail to parse, lack their `.wasm`, or claim a language or an
+    /// extension a built-in extractor (or an earlier plugin) already has are errors.
+    pub fn discover(dir: &Path) -> Result<Self> {
+        let Ok(entries) = std::fs::read_dir(dir) else {
+            return Ok(Self::default());
+        };
+        let mut manifests: Vec<PathBuf> = entries
+            .filter_map(|e| e.ok())
+            .map(|e| e.path())
+            .filter(|p| p.extension().is_some_and(|ext| ext == "toml"))
+            .collect();
+        manifests.sort();
+
+        let mut plugins: Vec<Arc<Plugin>> = Vec::new();
+        for path in manifests {
+            let raw = std::fs::read_to_string(&path)
+                .with_context(|| format!("failed to read {}", path.display()))?;
+            let manifest: PluginManifest = toml::from_str(&raw)
+                .with_context(|| format!("invalid plugin manifest {}", path.display()))?;
+            let wasm = path.with_extension("wasm");
+            anyhow::ensure!(
+                wasm.is_file(),
+                "plugin manifest {} has no {}",
+                path.display(),
+                wasm.display()
+            );
+            anyhow::ensure!(
+                !crate::languages::LANGUAGES.contains(&manifest.language.as_str()),
+                "plugin {} is named {}, like a built-in language",
+                path.display(),
+                manifest.language
+            );
+            if let Some(other) = plugins
+                .iter()
+                .find(|p| p.manifest.language == manifest.language)
+            {
+                anyhow::bail!(
+                    "plugins {} and {} are both named {}",
+                    other.name,
+                    path.display(),
+                    manifest.language
+                );
+            }
+            for ext in &manifest.extensions {
+                let probe = PathBuf::from(format!("x.{ext}"));
+                if let Some(lang) = crate::languages::detect_language(&probe) {
+                    anyhow::bail!(
+                        "plugin {} claims .{ext}, which the built-in {lang} extractor handles",
+                        path.display()
+                    );
+                }
+                if let Some(other) = plugins.iter().find(|p| p.manifest.extensions.contains(ext)) {
+                    anyhow::bail!(
+                        "plugins {} and {} both claim .{ext}",
+                        other.name,
+                        path.display()
+                    );
+                }
+            }
+            let name = path
+                .file_stem()
+                .map(|s| s.to_string_lossy().into_owned())
+                .unwrap_or_default();
+            plugins.push(Arc::new(Plugin {
+                name,
+                manifest,
+                wasm,
+                #[cfg(feature = "plugins")]
+                compiled: std::sync::OnceLock::new(),
+            }));
+        }
+
+        if cfg!(not(feature = "plugins")) && !plugins.is_empty() {
+            warn!(
+                count = plugins.len(),
+                dir = %dir.display(),
+                "extractor plugins found but cartog was built without the `plugins` feature; skipping"
+            );
+            return Ok(Self::default());
+        }
+        Ok(Self { plugins })
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.plugins.is_empty()
+    }
+
+    pub fn plugins(&self) -> impl Iterator<Item = &Plugin> {
+        self.plugins.iter().map(|p| p.as_ref())
+    }
+
+    /// Language of the plugin claiming `path`'s extension.
+    pub fn detect(&self, path: &Path) -> Option<&str> {
+        let ext = path.extension()?.to_str()?;
+        self.plugins
+            .iter()
+            .find(|p| p.manifest.extensions.iter().any(|e| e == ext))
+            .map(|p| p.manifest.language.as_str())
+    }
+
+    /// Extractor for a plugin language, like [`crate::languages::get_extractor`].
+    pub fn extractor(&self, language: &str) -> Option<Box<dyn Extractor>> {
+        #[cfg(feature = "plugins")]
+        {
+            let plugin = self
+                .plugins
+                .iter()
+                .find(|p| p.manifest.language == language)?;
+            Some(Box::new(runtime::PluginExtractor {
+                plugin: Arc::clone(plugin),
+            }))
+        }
+        #[cfg(not(feature = "plugins"))]
+        {
+            let _ = language;
+            None
+        }
+    }
+}
+
+#[cfg(feature = "plugins")]
+mod runtime {
+    use std::sync::Arc;
+
+    use anyhow::{Context, Result};
+    use wasmtime::{Config, Engine, Linker, Module, Store, StoreLimits, StoreLimitsBuilder};
+    use wasmtime_wasi::pipe::{MemoryInputPipe, MemoryOutputPipe};
+    use wasmtime_wasi::preview1::{self, WasiP1Ctx};
+    use wasmtime_wasi::{I32Exit, WasiCtxBuilder};
+
+    use super::{Plugin, PluginInput, PluginOutput, ABI_VERSION};
+    use crate::languages::{ExtractionResult, Extractor};
+
+    /// Linear memory a plugin may grow to.
+    const MEMORY_LIMIT: usize = 256 * 1024 * 1024;
+    /// Instruction budget per file; stops runaway plugins.
+    const FUEL_PER_FILE: u64 = 10_000_000_000;
+    /// Cap on what a plugin may write to stdout or stderr.
+    const MAX_OUTPUT: usize = 64 * 1024 * 1024;
+
+    pub(super) struct Compiled {
+        engine: Engine,
+        module: Module,
+    }
+
+    impl std::fmt::Debug for Compiled {
+        fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+            f.write_str("Compiled")
+        }
+    }
+
+    struct State {
+        wasi: WasiP1Ctx,
+        limits: StoreLimits,
+    }
+
+    fn compile(plugin: &Plugin) -> Result<Compiled> {
+        let mut config = Config::new();
+        config.consume_fuel(true);
+        let engine = Engine::new(&config)?;
+        let module = Module::from_file(&engine, &plugin.wasm)
+            .with_context(|| format!("failed to compile {}", plugin.wasm.display()))?;
+        Ok(Compiled { engine, module })
+    }
+
+    fn run(compiled: &Compiled, input: Vec<u8>) -> Result<Vec<u8>> {
+        let stdout = MemoryOutputPipe::new(MAX_OUTPUT);
+        let stderr = MemoryOutputPipe::new(MAX_OUTPUT);
+        // No preopened directories, environment or sockets: stdio only.
+        let wasi = WasiCtxBuilder::new()
+            .stdin(MemoryInputPipe::new(input))
+            .stdout(stdout.clone())
+            .stderr(stderr.clone())
+            .build_p1();
+        let limits = StoreLimitsBuilder::new().memory_size(MEMORY_LIMIT).build();
+        let mut store = Store::new(&compiled.engine, State { wasi, limits });
+        store.limiter(|state| &mut state.limits);
+        store.set_fuel(FUEL_PER_FILE)?;
+
+        let mut linker: Linker<State> = Linker::new(&compiled.engine);
+        preview1::add_to_linker_sync(&mut linker, |state| &mut state.wasi)?;
+        let instance = linker.instantiate(&mut store, &compiled.module)?;
+        let start = instance.get_typed_func::<(), ()>(&mut store, "_start")?;
+        if let Err(e) = start.call(&mut store, ()) {
+            match e.downcast_ref::<I32Exit>() {
+                Some(I32Exit(0)) => {}
+                _ => {
+                    let log = String::from_utf8_lossy(&stderr.contents()).into_owned();
+                    let status = e
+                        .downcast_ref::<I32Exit>()
+                        .map_or_else(|| e.to_string(), |exit| format!("exit {}", exit.0));
+                    anyhow::bail!("plugin failed ({status}): {}", log.trim());
+                }
+            }
+        }
+        drop(store);
+        Ok(stdout.contents().to_vec())
+    }
+
+    pub(super) struct PluginExtractor {
+        pub(super) plugin: Arc<Plugin>,
+    }
+
+    impl Extractor for PluginExtractor {
+        fn extract(&mut self, source: &str, file_path: &str) -> Result<ExtractionResult> {
+            let compiled = self
+                .plugin
+                .compiled
+                .get_or_init(|| compile(&self.plugin).map_err(|e| format!("{e:#}")))
+                .as_ref()
+                .map_err(|e| anyhow::anyhow!("{e}"))?;
+            let input = serde_json::to_vec(&PluginInput {
+                abi: ABI_VERSION,
+                file_path,
+                source,
+            })?;
+            let stdout =
+                run(compiled, input).with_context(|| format!("plugin {}", self.plugin.name))?;
+            let output: PluginOutput = serde_json::from_slice(&stdout)
+                .with_context(|| format!("plugin {} wrote invalid output", self.plugin.name))?;
+            output.into_extraction(file_path)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_output_indices_become_symbol_ids() {
+        let output: PluginOutput = serde_json::from_str(
+            r#"{
+                "symbols": [
+                    {"name": "UserService", "kind": "class", "start_line": 3, "end_line": 9},
+                    {"name": "GetUser", "kind": "method", "start_line": 4, "end_line": 4, "parent": 0}
+                ],
+                "edges": [
+                    {"source": 1, "target": "GetUserRequest", "kind": "references", "line": 4}
+                ]
+            }"#,
+        )
+        .unwrap();
+        let result = output.into_extraction("api/user.proto").unwrap();
+        assert_eq!(result.symbols.len(), 2);
+        assert_eq!(
+            result.symbols[1].parent_id.as_deref(),
+            Some("api/user.proto:UserService:3")
+        );
+        assert_eq!(result.edges[0].source_id, "api/user.proto:GetUser:4");
+
+        let dangling: PluginOutput = serde_json::from_str(
+            r#"{"edges": [{"source": 0, "target": "X", "kind": "calls", "line": 1}]}"#,
+        )
+        .unwrap();
+        assert!(dangling.into_extraction("a.proto").is_err());
+    }
+
+    #[test]
+    fn test_discover_rejects_builtin_extensions_and_languages() {
+        let dir = std::env::temp_dir().join(format!("cartog-plugins-{}", std::process::id()));
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(dir.join("proto.wasm"), b"\0asm").unwrap();
+        std::fs::write(
+            dir.join("proto.toml"),
+            "language = \"protobuf\"\nextensions = [\"proto\"]",
+        )
+        .unwrap();
+        let registry = PluginRegistry::discover(&dir).unwrap();
+        if cfg!(feature = "plugins") {
+            assert_eq!(
+                registry.detect(Path::new("api/user.proto")),
+                Some("protobuf")
+            );
+        } else {
+            assert!(registry.is_empty());
+        }
+        assert!(registry.detect(Path::new("main.py")).is_none());
+
+        std::fs::write(dir.join("py.wasm"), b"\0asm").unwrap();
+        std::fs::write(
+            dir.join("py.toml"),
+            "language = \"py2\"\nextensions = [\"py\"]",
+        )
+        .unwrap();
+        let err = PluginRegistry::discover(&dir).unwrap_err();
+        assert!(err.to_string().contains("built-in python"));
+        std::fs::remove_file(dir.join("py.toml")).unwrap();
+
+        std::fs::write(dir.join("rb.wasm"), b"\0asm").unwrap();
+        std::fs::write(
+            dir.join("rb.toml"),
+            "language = \"python\"\nextensions = [\"rbx\"]",
+        )
+        .unwrap();
+        let err = PluginRegistry::discover(&dir).unwrap_err();
+        assert!(err.to_string().contains("like a built-in language"));
+        std::fs::remove_dir_all(&dir).unwrap();
+
+        assert!(PluginRegistry::discover(&dir).unwrap().is_empty());
+    }
+}
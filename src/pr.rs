@@ -0,0 +1,198 @@
+use std::path::{Path, PathBuf};
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::diff::{self, ChangeKind, IndexDiff, SnapshotSource};
+use crate::git;
+use crate::indexer::{self, IndexResult};
+use crate::types::EdgeKind;
+
+/// Directory (under the repository root) holding precomputed review reports.
+pub const REPORT_DIR: &str = ".cartog/pr";
+
+/// A dependent reached while expanding the impact of a changed symbol.
+#[derive(Debug, Clone, Serialize)]
+pub struct Dependent {
+    pub source_id: String,
+    pub kind: EdgeKind,
+    pub file_path: String,
+    pub line: u32,
+    pub depth: u32,
+}
+
+/// Transitive dependents of one modified or removed symbol, in the head index.
+#[derive(Debug, Clone, Serialize)]
+pub struct ChangeImpact {
+    pub change: ChangeKind,
+    pub qualified_name: String,
+    pub file_path: String,
+    pub line: u32,
+    pub dependents: Vec<Dependent>,
+}
+
+/// Everything `cartog pr prepare` precomputes for reviewing `head` against `base`.
+#[derive(Debug, Serialize)]
+pub struct PrReport {
+    pub base: String,
+    pub base_commit: String,
+    pub base_source: SnapshotSource,
+    /// `HEAD` commit; the head index also covers uncommitted changes.
+    pub head_commit: Option<String>,
+    pub head_index: IndexResult,
+    pub diff: IndexDiff,
+    pub impact: Vec<ChangeImpact>,
+    /// Where the report was written.
+    pub report_path: PathBuf,
+}
+
+/// Prepare a review of the working tree against `base`.
+///
+/// Brings the head index (`db`) up to date, makes sure a snapshot exists for the
+/// base commit (local cache, then the shared cache, then a fresh build), diffs the
+/// two and expands the impact of every modified or removed symbol up to `depth`
+/// hops. The report is written under [`REPORT_DIR`] so later tooling can reuse it.
+pub fn prepare(db: &Database, repo: &Path, base: &str, depth: u32) -> Result<PrReport> {
+    let head_index = indexer::index_directory(db, repo, false)?;
+    let head_commit = git::head_commit(repo);
+
+    let (base_commit, base_path, base_source) = diff::ensure_snapshot(repo, base)?;
+    let base_db = Database::open(&base_path)
+        .with_context(|| format!("failed to open snapshot {}", base_path.display()))?;
+
+    let mut index_diff = diff::diff_databases(&base_db, db)?;
+    index_diff.from = base.to_string();
+    index_diff.to = "working tree".to_string();
+
+    let impact = change_impact(db, &index_diff, depth)?;
+
+    let report_path = report_path(repo, &base_commit, head_commit.as_deref());
+    let report = PrReport {
+        base: base.to_string(),
+        base_commit,
+        base_source,
+        head_commit,
+        head_index,
+        diff: index_diff,
+        impact,
+        report_path,
+    };
+    write_report(&report)?;
+    Ok(report)
+}
+
+/// Dependents of each modified or removed symbol. Added symbols have none yet.
+fn change_impact(db: &Database, index_diff: &IndexDiff, depth: u32) -> Result<Vec<ChangeImpact>> {
+    let mut out = Vec::new();
+    for change in &index_diff.symbols {
+        if change.change == ChangeKind::Added {
+            continue;
+        }
+        // Edges target bare names, so `AuthService.login` is looked up as `login`.
+        let name = change
+            .qualified_name
+            .rsplit('.')
+            .next()
+            .unwrap_or(&change.qualified_name);
+        let dependents = db
+            .impact(name, depth)?
+            .into_iter()
+            .map(|(edge, depth)| Dependent {
+                source_id: edge.source_id,
+                kind: edge.kind,
+                file_path: edge.file_path,
+                line: edge.line,
+                depth,
+            })
+            .collect();
+        out.push(ChangeImpact {
+            change: change.change,
+            qualified_name: change.qualified_name.clone(),
+            file_path: change.file_path.clone(),
+            line: change.line,
+            dependents,
+        });
+    }
+    out.sort_by(|a, b| {
+        b.dependents
+            .len()
+            .cmp(&a.dependents.len())
+            .then_with(|| a.qualified_name.cmp(&b.qualified_name))
+    });
+    Ok(out)
+}
+
+fn report_path(repo: &Path, base_commit: &str, head_commit: Option<&str>) -> PathBuf {
+    let short = |c: &str| c[..c.len().min(12)].to_string();
+    let head = head_commit.map_or_else(|| "worktree".to_string(), short);
+    repo.join(REPORT_DIR)
+        .join(format!("{}..{head}.json", short(base_commit)))
+}
+
+fn write_report(report: &PrReport) -> Result<()> {
+    if let Some(dir) = report.report_path.parent() {
+        std::fs::create_dir_all(dir)
+            .with_context(|| format!("failed to create {}", dir.display()))?;
+    }
+    std::fs::write(&report.report_path, serde_json::to_string_pretty(report)?)
+        .with_context(|| format!("failed to write {}", report.report_path.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::diff::SymbolChange;
+    use crate::types::{Edge, Symbol, SymbolKind};
+
+    fn change(kind: ChangeKind, name: &str) -> SymbolChange {
+        SymbolChange {
+            change: kind,
+            qualified_name: name.to_string(),
+            kind: SymbolKind::Method,
+            file_path: "auth.py".to_string(),
+            line: 1,
+            old_signature: None,
+            new_signature: None,
+        }
+    }
+
+    #[test]
+    fn test_change_impact_skips_added_and_uses_bare_name() {
+        let db = Database::open_memory().unwrap();
+        let caller = Symbol::new("handler", SymbolKind::Function, "routes.py", 1, 5, 0, 50);
+        db.insert_symbol(&caller).unwrap();
+        db.insert_edge(&Edge::new(
+            &caller.id,
+            "login",
+            EdgeKind::Calls,
+            "routes.py",
+            3,
+        ))
+        .unwrap();
+
+        let index_diff = IndexDiff {
+            symbols: vec![
+                change(ChangeKind::Added, "AuthService.logout"),
+                change(ChangeKind::SignatureChanged, "AuthService.login"),
+            ],
+            ..Default::default()
+        };
+        let impact = change_impact(&db, &index_diff, 3).unwrap();
+        assert_eq!(impact.len(), 1);
+        assert_eq!(impact[0].qualified_name, "AuthService.login");
+        assert_eq!(impact[0].dependents.len(), 1);
+        assert_eq!(impact[0].dependents[0].file_path, "routes.py");
+    }
+
+    #[test]
+    fn test_report_path_names_both_commits() {
+        let path = report_path(Path::new("repo"), &"a".repeat(40), Some(&"b".repeat(40)));
+        assert_eq!(
+            path,
+            Path::new("repo/.cartog/pr/aaaaaaaaaaaa..bbbbbbbbbbbb.json")
+        );
+        let path = report_path(Path::new("repo"), &"a".repeat(40), None);
+        assert!(path.ends_with("aaaaaaaaaaaa..worktree.json"));
+    }
+}
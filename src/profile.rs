@@ -0,0 +1,569 @@
+//! Built-in profiling for `cartog profile`.
+//!
+//! No external profiler is needed: heap usage comes from [`CountingAlloc`] (the
+//! binary's global allocator when built with the `heap-profile` feature), a
+//! timeline of tracing spans is recorded by [`SpanTrace`] in Chrome trace-event
+//! format (open it in Perfetto or `chrome://tracing`), and process CPU time is
+//! read from `/proc/self/stat` on Linux.
+//!
+//! The CPU profile is per span rather than sampled: each span's thread CPU time,
+//! less its children's, is added up by span stack and written as folded stacks
+//! (`cpu.folded`, for speedscope or inferno). No sampler is linked in, so there
+//! are no pprof protobuf files.
+
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, AtomicIsize, AtomicU64, AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::Instant;
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+use tracing::span;
+use tracing::Subscriber;
+use tracing_subscriber::layer::Context as LayerContext;
+use tracing_subscriber::registry::LookupSpan;
+use tracing_subscriber::Layer;
+
+use crate::explain::StatementStats;
+
+/// Directory (under the repository root) where profiles are written by default.
+pub const PROFILE_DIR: &str = ".cartog/profile";
+
+/// How many spans and SQL statements the summary lists.
+const SUMMARY_TOP: usize = 10;
+
+// ── Heap ──
+
+static TRACKING: AtomicBool = AtomicBool::new(false);
+static CURRENT: AtomicIsize = AtomicIsize::new(0);
+static PEAK: AtomicIsize = AtomicIsize::new(0);
+static ALLOCATED: AtomicUsize = AtomicUsize::new(0);
+static ALLOCATIONS: AtomicUsize = AtomicUsize::new(0);
+
+/// System allocator wrapper that counts bytes while tracking is on.
+///
+/// When tracking is off the only overhead is one relaxed atomic load per call.
+/// The binary installs it only with the `heap-profile` feature ([`HEAP_TRACKED`]).
+pub struct CountingAlloc;
+
+impl CountingAlloc {
+    fn grow(size: usize) {
+        if TRACKING.load(Ordering::Relaxed) {
+            ALLOCATED.fetch_add(size, Ordering::Relaxed);
+            ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+            let now = CURRENT.fetch_add(size as isize, Ordering::Relaxed) + size as isize;
+            PEAK.fetch_max(now, Ordering::Relaxed);
+        }
+    }
+
+    fn shrink(size: usize) {
+        if TRACKING.load(Ordering::Relaxed) {
+            CURRENT.fetch_sub(size as isize, Ordering::Relaxed);
+        }
+    }
+}
+
+unsafe impl GlobalAlloc for CountingAlloc {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc(layout);
+        if !ptr.is_null() {
+            Self::grow(layout.size());
+        }
+        ptr
+    }
+
+    unsafe fn alloc_zeroed(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc_zeroed(layout);
+        if !ptr.is_null() {
+            Self::grow(layout.size());
+        }
+        ptr
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        System.dealloc(ptr, layout);
+        Self::shrink(layout.size());
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        let new_ptr = System.realloc(ptr, layout, new_size);
+        if !new_ptr.is_null() {
+            Self::shrink(layout.size());
+            Self::grow(new_size);
+        }
+        new_ptr
+    }
+}
+
+/// Heap activity since [`start_heap_tracking`].
+///
+/// Memory allocated before tracking started and freed during it lowers the net
+/// figure, so `peak_bytes` is the peak *growth* over the starting point.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct HeapStats {
+    pub peak_bytes: u64,
+    pub allocated_bytes: u64,
+    pub allocations: u64,
+}
+
+/// Whether this build counts heap usage: [`CountingAlloc`] is installed only with
+/// the `heap-profile` feature, so other builds pay nothing per allocation.
+pub const HEAP_TRACKED: bool = cfg!(feature = "heap-profile");
+
+pub fn start_heap_tracking() {
+    CURRENT.store(0, Ordering::Relaxed);
+    PEAK.store(0, Ordering::Relaxed);
+    ALLOCATED.store(0, Ordering::Relaxed);
+    ALLOCATIONS.store(0, Ordering::Relaxed);
+    TRACKING.store(true, Ordering::Relaxed);
+}
+
+/// Stop tracking and return the counters. All zero if [`CountingAlloc`] is not installed.
+pub fn stop_heap_tracking() -> HeapStats {
+    TRACKING.store(false, Ordering::Relaxed);
+    HeapStats {
+        peak_bytes: PEAK.load(Ordering::Relaxed).max(0) as u64,
+        allocated_bytes: ALLOCATED.load(Ordering::Relaxed) as u64,
+        allocations: ALLOCATIONS.load(Ordering::Relaxed) as u64,
+    }
+}
+
+// ── CPU ──
+
+/// Process CPU time, in milliseconds.
+#[derive(Debug, Clone, Copy, Default, Serialize)]
+pub struct CpuTime {
+    pub user_ms: f64,
+    pub system_ms: f64,
+}
+
+/// CPU time the calling thread has used, in nanoseconds. `None` where the
+/// thread clock is not known (other than Linux and macOS).
+#[cfg(any(target_os = "linux", target_os = "macos"))]
+pub fn thread_cpu_ns() -> Option<u64> {
+    use std::os::raw::{c_int, c_long};
+
+    #[repr(C)]
+    struct Timespec {
+        tv_sec: c_long,
+        tv_nsec: c_long,
+    }
+    extern "C" {
+        fn clock_gettime(clock: c_int, time: *mut Timespec) -> c_int;
+    }
+    #[cfg(target_os = "linux")]
+    const CLOCK_THREAD_CPUTIME_ID: c_int = 3;
+    #[cfg(target_os = "macos")]
+    const CLOCK_THREAD_CPUTIME_ID: c_int = 16;
+
+    let mut time = Timespec {
+        tv_sec: 0,
+        tv_nsec: 0,
+    };
+    // SAFETY: `time` is a valid, writable timespec for the duration of the call.
+    if unsafe { clock_gettime(CLOCK_THREAD_CPUTIME_ID, &mut time) } != 0 {
+        return None;
+    }
+    Some(time.tv_sec as u64 * 1_000_000_000 + time.tv_nsec as u64)
+}
+
+#[cfg(not(any(target_os = "linux", target_os = "macos")))]
+pub fn thread_cpu_ns() -> Option<u64> {
+    None
+}
+
+/// Read user/system CPU time for the whole process. `None` off Linux.
+pub fn cpu_time() -> Option<CpuTime> {
+    let stat = std::fs::read_to_string("/proc/self/stat").ok()?;
+    parse_proc_stat(&stat)
+}
+
+/// `utime` and `stime` are fields 14 and 15, counted after the parenthesised
+/// command name (which may itself contain spaces). Linux reports them in
+/// USER_HZ ticks, which is 100 on every mainstream architecture.
+fn parse_proc_stat(stat: &str) -> Option<CpuTime> {
+    const TICK_MS: f64 = 10.0;
+    let after_comm = &stat[stat.rfind(')')? + 1..];
+    let mut fields = after_comm.split_whitespace().skip(11);
+    let utime: f64 = fields.next()?.parse().ok()?;
+    let stime: f64 = fields.next()?.parse().ok()?;
+    Some(CpuTime {
+        user_ms: utime * TICK_MS,
+        system_ms: stime * TICK_MS,
+    })
+}
+
+// ── Span timeline ──
+
+/// One completed span in Chrome trace-event format (`ph: "X"`, times in µs).
+#[derive(Debug, Clone, Serialize)]
+pub struct TraceEvent {
+    pub name: String,
+    pub cat: &'static str,
+    pub ph: &'static str,
+    pub ts: u64,
+    pub dur: u64,
+    pub pid: u32,
+    pub tid: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub args: Option<HashMap<String, String>>,
+}
+
+/// Tracing layer recording every span (regardless of log level) as a trace
+/// event, and its CPU time by span stack.
+///
+/// Cheap to clone; clones share the same buffers.
+#[derive(Clone)]
+pub struct SpanTrace {
+    epoch: Instant,
+    events: Arc<Mutex<Vec<TraceEvent>>>,
+    /// Self CPU nanoseconds by span stack (`index;parse_file`).
+    cpu: Arc<Mutex<HashMap<String, u64>>>,
+}
+
+struct SpanStart {
+    at: Instant,
+    fields: HashMap<String, String>,
+    tid: u64,
+    /// The thread's CPU clock when the span was created.
+    cpu_ns: Option<u64>,
+    /// CPU time of the span's children, taken off its own.
+    child_cpu_ns: u64,
+}
+
+/// Collects a span's fields as strings.
+#[derive(Default)]
+pub(crate) struct FieldVisitor(pub(crate) HashMap<String, String>);
+
+impl tracing::field::Visit for FieldVisitor {
+    fn record_debug(&mut self, field: &tracing::field::Field, value: &dyn std::fmt::Debug) {
+        self.0
+            .insert(field.name().to_string(), format!("{value:?}"));
+    }
+
+    fn record_str(&mut self, field: &tracing::field::Field, value: &str) {
+        self.0.insert(field.name().to_string(), value.to_string());
+    }
+}
+
+impl SpanTrace {
+    pub fn new() -> Self {
+        Self {
+            epoch: Instant::now(),
+            events: Arc::new(Mutex::new(Vec::new())),
+            cpu: Arc::new(Mutex::new(HashMap::new())),
+        }
+    }
+
+    /// Take every event recorded so far.
+    pub fn drain(&self) -> Vec<TraceEvent> {
+        std::mem::take(&mut *self.events.lock().unwrap_or_else(|e| e.into_inner()))
+    }
+
+    /// Take the CPU profile recorded so far: self CPU nanoseconds by span
+    /// stack, in stack order.
+    pub fn drain_cpu(&self) -> Vec<(String, u64)> {
+        let cpu = std::mem::take(&mut *self.cpu.lock().unwrap_or_else(|e| e.into_inner()));
+        let mut stacks: Vec<(String, u64)> = cpu.into_iter().collect();
+        stacks.sort();
+        stacks
+    }
+}
+
+impl Default for SpanTrace {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+fn thread_id() -> u64 {
+    static NEXT: AtomicU64 = AtomicU64::new(1);
+    thread_local! {
+        static ID: u64 = NEXT.fetch_add(1, Ordering::Relaxed);
+    }
+    ID.with(|id| *id)
+}
+
+impl<S> Layer<S> for SpanTrace
+where
+    S: Subscriber + for<'a> LookupSpan<'a>,
+{
+    fn on_new_span(&self, attrs: &span::Attributes<'_>, id: &span::Id, ctx: LayerContext<'_, S>) {
+        let mut visitor = FieldVisitor::default();
+        attrs.record(&mut visitor);
+        if let Some(span) = ctx.span(id) {
+            span.extensions_mut().insert(SpanStart {
+                at: Instant::now(),
+                fields: visitor.0,
+                tid: thread_id(),
+                cpu_ns: thread_cpu_ns(),
+                child_cpu_ns: 0,
+            });
+        }
+    }
+
+    fn on_close(&self, id: span::Id, ctx: LayerContext<'_, S>) {
+        let Some(span) = ctx.span(&id) else {
+            return;
+        };
+        let extensions = span.extensions();
+        let Some(start) = extensions.get::<SpanStart>() else {
+            return;
+        };
+        let tid = thread_id();
+        let event = TraceEvent {
+            name: span.name().to_string(),
+            cat: span.metadata().target(),
+            ph: "X",
+            ts: start.at.duration_since(self.epoch).as_micros() as u64,
+            dur: start.at.elapsed().as_micros() as u64,
+            pid: std::process::id(),
+            tid,
+            args: (!start.fields.is_empty()).then(|| start.fields.clone()),
+        };
+        // A thread's clock only measures the span if it closes where it began.
+        let cpu_ns = start
+            .cpu_ns
+            .filter(|_| start.tid == tid)
+            .zip(thread_cpu_ns())
+            .map(|(before, after)| after.saturating_sub(before));
+        let child_cpu_ns = start.child_cpu_ns;
+        drop(extensions);
+        self.events
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .push(event);
+
+        let Some(cpu_ns) = cpu_ns else {
+            return;
+        };
+        let stack = span
+            .scope()
+            .from_root()
+            .map(|s| s.name())
+            .collect::<Vec<_>>()
+            .join(";");
+        *self
+            .cpu
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .entry(stack)
+            .or_default() += cpu_ns.saturating_sub(child_cpu_ns);
+        if let Some(parent) = span.parent() {
+            if let Some(start) = parent.extensions_mut().get_mut::<SpanStart>() {
+                start.child_cpu_ns += cpu_ns;
+            }
+        }
+    }
+}
+
+// ── Report ──
+
+/// Total time and call count for one span name.
+#[derive(Debug, Clone, Serialize)]
+pub struct SpanSummary {
+    pub name: String,
+    pub calls: u32,
+    pub total_ms: f64,
+}
+
+/// Summary written next to the raw profiles.
+#[derive(Debug, Serialize)]
+pub struct ProfileReport {
+    pub operation: String,
+    pub wall_ms: f64,
+    /// Process CPU time totals (Linux only); not a sampled profile.
+    pub cpu_time: Option<CpuTime>,
+    /// `None` unless built with the `heap-profile` feature.
+    pub heap: Option<HeapStats>,
+    /// Slowest span names by total time.
+    pub spans: Vec<SpanSummary>,
+    /// Slowest SQL statements by total time.
+    pub statements: Vec<StatementStats>,
+    /// Files written for this profile.
+    pub files: Vec<PathBuf>,
+}
+
+impl ProfileReport {
+    pub fn new(
+        operation: &str,
+        wall_ms: f64,
+        cpu_time: Option<CpuTime>,
+        heap: Option<HeapStats>,
+        events: &[TraceEvent],
+        mut statements: Vec<StatementStats>,
+    ) -> Self {
+        statements.sort_by(|a, b| b.total_ms.total_cmp(&a.total_ms));
+        statements.truncate(SUMMARY_TOP);
+        Self {
+            operation: operation.to_string(),
+            wall_ms,
+            cpu_time,
+            heap,
+            spans: summarize_spans(events),
+            statements,
+            files: Vec::new(),
+        }
+    }
+
+    /// Write `trace.json` (span timeline), `cpu.folded` (CPU profile, when one
+    /// was recorded) and `report.json` into `dir`.
+    pub fn write(
+        &mut self,
+        dir: &Path,
+        events: &[TraceEvent],
+        cpu: &[(String, u64)],
+    ) -> Result<()> {
+        std::fs::create_dir_all(dir)
+            .with_context(|| format!("failed to create {}", dir.display()))?;
+        let trace_path = dir.join("trace.json");
+        std::fs::write(
+            &trace_path,
+            serde_json::to_string(&serde_json::json!({ "traceEvents": events }))?,
+        )
+        .with_context(|| format!("failed to write {}", trace_path.display()))?;
+        self.files = vec![trace_path];
+        if !cpu.is_empty() {
+            let cpu_path = dir.join("cpu.folded");
+            std::fs::write(&cpu_path, folded(cpu))
+                .with_context(|| format!("failed to write {}", cpu_path.display()))?;
+            self.files.push(cpu_path);
+        }
+        let report_path = dir.join("report.json");
+        self.files.push(report_path.clone());
+        std::fs::write(&report_path, serde_json::to_string_pretty(self)?)
+            .with_context(|| format!("failed to write {}", report_path.display()))
+    }
+}
+
+/// Folded stacks, one `stack microseconds` line each, leaving out stacks that
+/// used less than a microsecond.
+fn folded(cpu: &[(String, u64)]) -> String {
+    cpu.iter()
+        .map(|(stack, ns)| (stack, ns / 1000))
+        .filter(|(_, us)| *us > 0)
+        .map(|(stack, us)| format!("{stack} {us}\n"))
+        .collect()
+}
+
+fn summarize_spans(events: &[TraceEvent]) -> Vec<SpanSummary> {
+    let mut by_name: HashMap<&str, SpanSummary> = HashMap::new();
+    for event in events {
+        let entry = by_name
+            .entry(event.name.as_str())
+            .or_insert_with(|| SpanSummary {
+                name: event.name.clone(),
+                calls: 0,
+                total_ms: 0.0,
+            });
+        entry.calls += 1;
+        entry.total_ms += event.dur as f64 / 1000.0;
+    }
+    let mut spans: Vec<SpanSummary> = by_name.into_values().collect();
+    spans.sort_by(|a, b| {
+        b.total_ms
+            .total_cmp(&a.total_ms)
+            .then_with(|| a.name.cmp(&b.name))
+    });
+    spans.truncate(SUMMARY_TOP);
+    spans
+}
+
+/// Default output directory for a new profile: `.cartog/profile/<unix-secs>-<operation>`.
+pub fn default_out_dir(operation: &str) -> PathBuf {
+    let secs = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|d| d.as_secs())
+        .unwrap_or(0);
+    Path::new(PROFILE_DIR).join(format!("{secs}-{operation}"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tracing_subscriber::layer::SubscriberExt;
+
+    #[test]
+    fn test_parse_proc_stat_handles_spaces_in_comm() {
+        let stat = "4242 (cartog (x) y) R 1 4242 4242 0 -1 4194304 120 0 0 0 37 5 0 0 20 0 1 0";
+        let cpu = parse_proc_stat(stat).unwrap();
+        assert_eq!(cpu.user_ms, 370.0);
+        assert_eq!(cpu.system_ms, 50.0);
+        assert!(parse_proc_stat("garbage").is_none());
+    }
+
+    #[test]
+    fn test_span_trace_records_closed_spans() {
+        let trace = SpanTrace::new();
+        let subscriber = tracing_subscriber::registry().with(trace.clone());
+        tracing::subscriber::with_default(subscriber, || {
+            tracing::debug_span!("parse_file", file = "auth.py").in_scope(|| {});
+            tracing::info_span!("resolve_edges").in_scope(|| {});
+            tracing::debug_span!("parse_file", file = "views.py").in_scope(|| {});
+        });
+
+        let events = trace.drain();
+        assert_eq!(events.len(), 3);
+        assert_eq!(
+            events[0]
+                .args
+                .as_ref()
+                .and_then(|a| a.get("file"))
+                .map(String::as_str),
+            Some("auth.py")
+        );
+
+        let spans = summarize_spans(&events);
+        let parse = spans.iter().find(|s| s.name == "parse_file").unwrap();
+        assert_eq!(parse.calls, 2);
+    }
+
+    #[test]
+    fn test_profile_writes_its_report_and_cpu_stacks() {
+        let trace = SpanTrace::new();
+        let subscriber = tracing_subscriber::registry().with(trace.clone());
+        tracing::subscriber::with_default(subscriber, || {
+            tracing::info_span!("index").in_scope(|| {
+                tracing::debug_span!("parse_file").in_scope(|| {
+                    let started = Instant::now();
+                    let mut n = 0u64;
+                    while started.elapsed().as_millis() < 5 {
+                        n = std::hint::black_box(n.wrapping_add(1));
+                    }
+                });
+            });
+        });
+        let events = trace.drain();
+        let cpu = trace.drain_cpu();
+        if thread_cpu_ns().is_some() {
+            let stacks: Vec<&str> = cpu.iter().map(|(s, _)| s.as_str()).collect();
+            assert_eq!(stacks, ["index", "index;parse_file"]);
+            assert!(cpu[1].1 > cpu[0].1, "the busy loop is the child's own time");
+        }
+
+        let dir = std::env::temp_dir().join(format!("cartog-profile-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        let mut report = ProfileReport::new("index", 5.0, None, None, &events, Vec::new());
+        report.write(&dir, &events, &cpu).unwrap();
+
+        let written: serde_json::Value =
+            serde_json::from_str(&std::fs::read_to_string(dir.join("report.json")).unwrap())
+                .unwrap();
+        assert_eq!(written["operation"], "index");
+        assert_eq!(written["spans"][0]["name"], "index");
+        let files = written["files"].as_array().unwrap();
+        assert_eq!(files.len(), if cpu.is_empty() { 2 } else { 3 });
+        for file in files {
+            assert!(Path::new(file.as_str().unwrap()).is_file(), "{file}");
+        }
+        let trace_json = std::fs::read_to_string(dir.join("trace.json")).unwrap();
+        assert!(trace_json.contains("\"parse_file\""));
+        if !cpu.is_empty() {
+            let folded = std::fs::read_to_string(dir.join("cpu.folded")).unwrap();
+            assert!(folded.contains("index;parse_file "), "{folded}");
+        }
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
@@ -28,7 +28,7 @@ pub fn model_cache_dir() -> std::path::PathBuf {
     }
 
     // 3. ~/.cache/cartog/models
-    if let Some(home) = home_dir() {
+    if let Some(home) = crate::config::home_dir() {
         return home.join(".cache").join("cartog").join("models");
     }
 
@@ -36,14 +36,6 @@ pub fn model_cache_dir() -> std::path::PathBuf {
     std::path::PathBuf::from(".fastembed_cache")
 }
 
-/// Get the user's home directory (no external dependency needed).
-fn home_dir() -> Option<std::path::PathBuf> {
-    std::env::var("HOME")
-        .or_else(|_| std::env::var("USERPROFILE")) // Windows fallback
-        .ok()
-        .map(std::path::PathBuf::from)
-}
-
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -5,6 +5,7 @@ use serde::Serialize;
 
 use std::sync::Mutex;
 
+use crate::config::ProjectConfig;
 use crate::db::Database;
 use crate::types::{Symbol, SymbolKind};
 
@@ -119,6 +120,20 @@ pub fn hybrid_search(
     query: &str,
     limit: u32,
     kind_filter: Option<SymbolKind>,
+) -> Result<HybridSearchResult> {
+    hybrid_search_with(db, query, limit, kind_filter, &ProjectConfig::default())
+}
+
+/// [`hybrid_search`] with `[ranking] boost` multipliers from the project config.
+///
+/// Boosts scale the fused RRF score, so they decide which candidates reach the
+/// cross-encoder and the order of those it cannot score.
+pub fn hybrid_search_with(
+    db: &Database,
+    query: &str,
+    limit: u32,
+    kind_filter: Option<SymbolKind>,
+    config: &ProjectConfig,
 ) -> Result<HybridSearchResult> {
     let retrieval_limit = (limit * 3).max(20); // Over-retrieve for better merge
 
@@ -173,6 +188,16 @@ pub fn hybrid_search(
         }
     }
 
+    // 4b. Path boosts from `.cartog.toml`.
+    for candidate in &mut candidates {
+        candidate.rrf_score *= config.boost(&candidate.symbol.file_path);
+    }
+    candidates.sort_by(|a, b| {
+        b.rrf_score
+            .partial_cmp(&a.rrf_score)
+            .unwrap_or(std::cmp::Ordering::Equal)
+    });
+
     // 5. Cross-encoder re-ranking (if model is available).
     //    Cap at 50 candidates to bound latency.
     const RERANK_MAX: usize = 50;
@@ -659,6 +684,43 @@ mod tests {
         assert_eq!(result.results[0].symbol.name, "migrate");
     }
 
+    #[test]
+    fn test_config_boost_scales_scores() {
+        let db = Database::open_memory().unwrap();
+        for file in ["legacy/auth.py", "core/auth.py"] {
+            insert_symbol_with_content(
+                &db,
+                "refresh_session",
+                SymbolKind::Function,
+                file,
+                1,
+                "def refresh_session(user):\n    return issue_session(user)",
+            );
+        }
+        let config = ProjectConfig::from_files(vec![(
+            String::new(),
+            crate::config::ConfigFile::parse("[ranking.boost]\n\"core/**\" = 3.0").unwrap(),
+        )])
+        .unwrap();
+
+        let score = |result: &HybridSearchResult, file: &str| {
+            result
+                .results
+                .iter()
+                .find(|r| r.symbol.file_path == file)
+                .map(|r| r.rrf_score)
+                .unwrap()
+        };
+        let plain = hybrid_search(&db, "refresh_session", 10, None).unwrap();
+        let boosted = hybrid_search_with(&db, "refresh_session", 10, None, &config).unwrap();
+        let core = score(&plain, "core/auth.py");
+        assert!((score(&boosted, "core/auth.py") - core * 3.0).abs() < 1e-12);
+        assert_eq!(
+            score(&boosted, "legacy/auth.py"),
+            score(&plain, "legacy/auth.py")
+        );
+    }
+
     // ── Precision and ranking tests ──
 
     #[test]
@@ -0,0 +1,260 @@
+//! Symbol centrality: PageRank and betweenness over the call and reference
+//! graph, computed at the end of each index run.
+//!
+//! A symbol ranks high when many symbols call or reference it, and higher still
+//! when those are themselves central: the helpers and types everything leans
+//! on, as opposed to the ones with the most lines or commits. Scores are scaled
+//! so the average symbol is 1.0. `cartog rank` lists the top ones, and search
+//! uses the score to order matches of the same tier.
+//!
+//! Betweenness instead favors the symbols that connect the rest: the share of
+//! shortest paths between other symbols that pass through one. A thin adapter
+//! between two subsystems scores high though little calls it directly.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+
+use anyhow::Result;
+
+use crate::db::Database;
+use crate::types::{EdgeKind, SymbolKind};
+
+/// Probability of following an edge rather than jumping to any symbol.
+const DAMPING: f64 = 0.85;
+/// Upper bound on power iterations; most graphs converge well before.
+const MAX_ITERATIONS: usize = 100;
+/// Total change in scores below which the iteration stops.
+const TOLERANCE: f64 = 1e-9;
+/// Most start nodes whose shortest paths betweenness counts. Larger graphs are
+/// sampled, since each start costs a walk of the whole graph.
+const MAX_SOURCES: usize = 256;
+
+/// Recompute and store the PageRank and betweenness of every symbol but
+/// imports, over resolved calls and references. Returns the number of symbols
+/// ranked.
+pub fn compute(db: &Database) -> Result<usize> {
+    let symbols: Vec<_> = db
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| s.kind != SymbolKind::Import)
+        .collect();
+    let index: HashMap<&str, usize> = symbols
+        .iter()
+        .enumerate()
+        .map(|(i, s)| (s.id.as_str(), i))
+        .collect();
+    let mut links = HashSet::new();
+    for edge in db.all_edges()? {
+        if !matches!(edge.kind, EdgeKind::Calls | EdgeKind::References) {
+            continue;
+        }
+        let source = index.get(edge.source_id.as_str());
+        let target = edge.target_id.as_deref().and_then(|id| index.get(id));
+        if let (Some(&source), Some(&target)) = (source, target) {
+            if source != target {
+                links.insert((source, target));
+            }
+        }
+    }
+    let mut links: Vec<(usize, usize)> = links.into_iter().collect();
+    links.sort_unstable();
+
+    let scale = symbols.len() as f64;
+    let ranks: Vec<(String, String, f64)> = pagerank(symbols.len(), &links)
+        .into_iter()
+        .zip(&symbols)
+        .map(|(score, s)| (s.id.clone(), s.file_path.clone(), score * scale))
+        .collect();
+    db.replace_ranks(&ranks)?;
+
+    // Normalized by the number of ordered pairs of other symbols.
+    let n = symbols.len() as f64;
+    let pairs = ((n - 1.0) * (n - 2.0)).max(1.0);
+    let between: Vec<(String, String, f64)> = betweenness(symbols.len(), &links, MAX_SOURCES)
+        .into_iter()
+        .zip(&symbols)
+        .filter(|(score, _)| *score > 0.0)
+        .map(|(score, s)| (s.id.clone(), s.file_path.clone(), score / pairs))
+        .collect();
+    db.replace_betweenness(&between)?;
+    Ok(ranks.len())
+}
+
+/// PageRank of `n` nodes linked by `(from, to)` pairs, summing to 1. The rank
+/// of nodes without outgoing links is spread over all nodes.
+pub fn pagerank(n: usize, links: &[(usize, usize)]) -> Vec<f64> {
+    if n == 0 {
+        return Vec::new();
+    }
+    let mut out_degree = vec![0u32; n];
+    for &(from, _) in links {
+        out_degree[from] += 1;
+    }
+    let uniform = 1.0 / n as f64;
+    let mut rank = vec![uniform; n];
+    for _ in 0..MAX_ITERATIONS {
+        let dangling: f64 = (0..n)
+            .filter(|&i| out_degree[i] == 0)
+            .map(|i| rank[i])
+            .sum();
+        let mut next = vec![(1.0 - DAMPING + DAMPING * dangling) * uniform; n];
+        for &(from, to) in links {
+            next[to] += DAMPING * rank[from] / f64::from(out_degree[from]);
+        }
+        let change: f64 = next.iter().zip(&rank).map(|(a, b)| (a - b).abs()).sum();
+        rank = next;
+        if change < TOLERANCE {
+            break;
+        }
+    }
+    rank
+}
+
+/// Betweenness of `n` nodes linked by `(from, to)` pairs, by Brandes' algorithm:
+/// for each node, the fraction of shortest paths through it, summed over the
+/// pairs of other nodes. Paths are counted from at most `max_sources` start
+/// nodes, spread evenly, and scaled up to all `n` when there are more.
+pub fn betweenness(n: usize, links: &[(usize, usize)], max_sources: usize) -> Vec<f64> {
+    let mut score = vec![0.0; n];
+    if n == 0 || max_sources == 0 {
+        return score;
+    }
+    let mut out = vec![Vec::new(); n];
+    for &(from, to) in links {
+        out[from].push(to);
+    }
+    let step = (n + max_sources - 1) / max_sources;
+    let sources: Vec<usize> = (0..n).step_by(step).collect();
+
+    let mut order = Vec::new();
+    let mut queue = VecDeque::new();
+    let mut preds: Vec<Vec<usize>> = vec![Vec::new(); n];
+    let mut paths = vec![0.0; n];
+    let mut dist = vec![usize::MAX; n];
+    let mut delta = vec![0.0; n];
+    for &source in &sources {
+        paths[source] = 1.0;
+        dist[source] = 0;
+        queue.push_back(source);
+        while let Some(v) = queue.pop_front() {
+            order.push(v);
+            for &w in &out[v] {
+                if dist[w] == usize::MAX {
+                    dist[w] = dist[v] + 1;
+                    queue.push_back(w);
+                }
+                if dist[w] == dist[v] + 1 {
+                    paths[w] += paths[v];
+                    preds[w].push(v);
+                }
+            }
+        }
+        // Farthest first, each node passes its dependency back to its predecessors.
+        for &w in order.iter().rev() {
+            for &v in &preds[w] {
+                delta[v] += paths[v] / paths[w] * (1.0 + delta[w]);
+            }
+            if w != source {
+                score[w] += delta[w];
+            }
+        }
+        for &v in &order {
+            preds[v].clear();
+            paths[v] = 0.0;
+            dist[v] = usize::MAX;
+            delta[v] = 0.0;
+        }
+        order.clear();
+    }
+    let scale = n as f64 / sources.len() as f64;
+    for s in &mut score {
+        *s *= scale;
+    }
+    score
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::db::RankMetric;
+    use crate::types::{Edge, Symbol};
+
+    #[test]
+    fn test_pagerank_favors_what_central_symbols_use() {
+        // 0, 1 and 2 call 3, which calls 4; nothing calls 0.
+        let ranks = pagerank(5, &[(0, 3), (1, 3), (2, 3), (3, 4), (4, 1)]);
+        assert!((ranks.iter().sum::<f64>() - 1.0).abs() < 1e-6);
+        assert!(ranks[3] > ranks[4] && ranks[4] > ranks[1] && ranks[1] > ranks[0]);
+        assert!((ranks[0] - ranks[2]).abs() < 1e-12);
+        assert!(pagerank(0, &[]).is_empty());
+    }
+
+    #[test]
+    fn test_betweenness_counts_shortest_paths_through_a_node() {
+        // 0 and 1 reach 3 and 4 only through 2.
+        let hub = [(0, 2), (1, 2), (2, 3), (2, 4)];
+        assert_eq!(betweenness(5, &hub, 16), [0.0, 0.0, 4.0, 0.0, 0.0]);
+        // Two shortest paths from 0 to 3 split the pair between 1 and 2.
+        let diamond = [(0, 1), (0, 2), (1, 3), (2, 3)];
+        assert_eq!(betweenness(4, &diamond, 16), [0.0, 0.5, 0.5, 0.0]);
+        // From the one sampled start, 0, the paths to 3 and 4, scaled to 5 starts.
+        assert_eq!(betweenness(5, &hub, 1), [0.0, 0.0, 10.0, 0.0, 0.0]);
+        assert!(betweenness(0, &[], 16).is_empty());
+    }
+
+    #[test]
+    fn test_compute_stores_scaled_ranks() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, "app.go", line, line + 3, 0, 100)
+        };
+        let (main, run, log) = (func("main", 1), func("run", 10), func("log", 20));
+        db.insert_symbols(&[main.clone(), run.clone(), log.clone()])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "run", EdgeKind::Calls, "app.go", 2),
+            Edge::new(&main.id, "log", EdgeKind::Calls, "app.go", 3),
+            Edge::new(&run.id, "log", EdgeKind::References, "app.go", 11),
+            Edge::new(&run.id, "fmt.Println", EdgeKind::Calls, "app.go", 12),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        assert_eq!(compute(&db).unwrap(), 3);
+        let top = db.top_ranked(None, RankMetric::PageRank, 10).unwrap();
+        let names: Vec<&str> = top.iter().map(|(s, _)| s.name.as_str()).collect();
+        assert_eq!(names, ["log", "run", "main"]);
+        let total: f64 = top.iter().map(|(_, c)| c.pagerank).sum();
+        assert!((total - 3.0).abs() < 1e-6);
+        // main calls log directly, so no shortest path runs through run.
+        assert!(top.iter().all(|(_, c)| c.betweenness == 0.0));
+    }
+
+    #[test]
+    fn test_compute_stores_normalized_betweenness() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, line: u32| {
+            Symbol::new(name, SymbolKind::Function, "app.go", line, line + 3, 0, 100)
+        };
+        let (main, run, log, util) = (
+            func("main", 1),
+            func("run", 10),
+            func("log", 20),
+            func("util", 30),
+        );
+        db.insert_symbols(&[main.clone(), run.clone(), log.clone(), util])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "run", EdgeKind::Calls, "app.go", 2),
+            Edge::new(&run.id, "log", EdgeKind::Calls, "app.go", 11),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        assert_eq!(compute(&db).unwrap(), 4);
+        let top = db.top_ranked(None, RankMetric::Betweenness, 10).unwrap();
+        // main → log is the one path through run, out of 3 × 2 ordered pairs of other symbols.
+        assert_eq!(top[0].0.name, "run");
+        assert!((top[0].1.betweenness - 1.0 / 6.0).abs() < 1e-12);
+        assert!(top[1..].iter().all(|(_, c)| c.betweenness == 0.0));
+    }
+}
@@ -0,0 +1,199 @@
+//! Per-client query limits for a shared `cartog serve`.
+//!
+//! Each client, keyed by its token's name (or by its session when the server
+//! takes no tokens), draws from a bucket refilled at `qps` queries per second and
+//! holding one second's worth, and may run at most `concurrent` queries at once.
+//! A refused query is told how long to wait before retrying, like an HTTP 429
+//! with `Retry-After`.
+
+use std::collections::HashMap;
+use std::fmt;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+use serde::Serialize;
+
+/// Suggested wait when every concurrent slot is taken.
+pub const BUSY_RETRY: Duration = Duration::from_millis(100);
+
+/// Buckets kept before idle ones are dropped.
+const MAX_IDLE_BUCKETS: usize = 1024;
+
+/// How long a bucket with nothing running is kept.
+const IDLE_AFTER: Duration = Duration::from_secs(60);
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Serialize)]
+pub struct Limits {
+    /// Queries per second; unlimited when unset.
+    pub qps: Option<f64>,
+    /// Queries running at once; unlimited when unset.
+    pub concurrent: Option<u32>,
+}
+
+impl Limits {
+    /// These limits, with `fallback`'s where unset.
+    pub fn or(self, fallback: Limits) -> Limits {
+        Limits {
+            qps: self.qps.or(fallback.qps),
+            concurrent: self.concurrent.or(fallback.concurrent),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Refusal {
+    /// Over the query rate.
+    Rate { qps: f64, retry_after: Duration },
+    /// Already running as many queries as allowed.
+    Busy { concurrent: u32 },
+}
+
+impl Refusal {
+    pub fn retry_after(&self) -> Duration {
+        match self {
+            Self::Rate { retry_after, .. } => *retry_after,
+            Self::Busy { .. } => BUSY_RETRY,
+        }
+    }
+}
+
+impl fmt::Display for Refusal {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Self::Rate { qps, .. } => write!(f, "over the limit of {qps} queries per second"),
+            Self::Busy { concurrent } => write!(f, "already running {concurrent} queries"),
+        }?;
+        write!(f, "; retry after {} ms", self.retry_after().as_millis())
+    }
+}
+
+#[derive(Debug)]
+struct Bucket {
+    tokens: f64,
+    refilled: Instant,
+    running: u32,
+}
+
+#[derive(Debug, Default)]
+pub struct RateLimiter {
+    /// Limits of clients whose token sets none.
+    defaults: Limits,
+    buckets: Mutex<HashMap<String, Bucket>>,
+}
+
+impl RateLimiter {
+    pub fn new(defaults: Limits) -> Self {
+        Self {
+            defaults,
+            buckets: Mutex::default(),
+        }
+    }
+
+    pub fn defaults(&self) -> Limits {
+        self.defaults
+    }
+
+    /// Admit one query from `client` under `limits`, or say why not. The query
+    /// counts as running until the permit is dropped.
+    pub fn acquire(
+        self: &Arc<Self>,
+        client: &str,
+        limits: Limits,
+        now: Instant,
+    ) -> Result<Permit, Refusal> {
+        let mut buckets = self.buckets.lock().unwrap_or_else(|e| e.into_inner());
+        if buckets.len() > MAX_IDLE_BUCKETS {
+            buckets.retain(|_, b| b.running > 0 || now.duration_since(b.refilled) < IDLE_AFTER);
+        }
+        let bucket = buckets.entry(client.to_string()).or_insert_with(|| Bucket {
+            tokens: limits.qps.map_or(0.0, |qps| qps.max(1.0)),
+            refilled: now,
+            running: 0,
+        });
+        if let Some(concurrent) = limits.concurrent {
+            if bucket.running >= concurrent {
+                return Err(Refusal::Busy { concurrent });
+            }
+        }
+        if let Some(qps) = limits.qps {
+            let elapsed = now.saturating_duration_since(bucket.refilled).as_secs_f64();
+            bucket.tokens = (bucket.tokens + elapsed * qps).min(qps.max(1.0));
+            bucket.refilled = now;
+            if bucket.tokens < 1.0 {
+                let retry_after = Duration::from_secs_f64((1.0 - bucket.tokens) / qps);
+                return Err(Refusal::Rate { qps, retry_after });
+            }
+            bucket.tokens -= 1.0;
+        }
+        bucket.running += 1;
+        Ok(Permit {
+            limiter: Arc::clone(self),
+            client: client.to_string(),
+        })
+    }
+}
+
+/// A running query; releases its slot when dropped.
+#[derive(Debug)]
+pub struct Permit {
+    limiter: Arc<RateLimiter>,
+    client: String,
+}
+
+impl Drop for Permit {
+    fn drop(&mut self) {
+        let mut buckets = self
+            .limiter
+            .buckets
+            .lock()
+            .unwrap_or_else(|e| e.into_inner());
+        if let Some(bucket) = buckets.get_mut(&self.client) {
+            bucket.running = bucket.running.saturating_sub(1);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rate_and_concurrency_limits() {
+        let limiter = Arc::new(RateLimiter::new(Limits {
+            qps: Some(2.0),
+            concurrent: Some(1),
+        }));
+        let limits = limiter.defaults();
+        let start = Instant::now();
+
+        let first = limiter.acquire("ci", limits, start).unwrap();
+        assert_eq!(
+            limiter.acquire("ci", limits, start).unwrap_err(),
+            Refusal::Busy { concurrent: 1 }
+        );
+        // Another client is not held up.
+        let other = limiter.acquire("alice", limits, start).unwrap();
+        drop(first);
+        drop(other);
+
+        // The burst of two is spent; the next token comes in half a second.
+        let second = limiter.acquire("ci", limits, start).unwrap();
+        drop(second);
+        let refusal = limiter.acquire("ci", limits, start).unwrap_err();
+        assert_eq!(refusal.retry_after(), Duration::from_millis(500));
+        assert_eq!(
+            refusal.to_string(),
+            "over the limit of 2 queries per second; retry after 500 ms"
+        );
+        assert!(limiter
+            .acquire("ci", limits, start + Duration::from_millis(500))
+            .is_ok());
+
+        let unlimited = Limits::default().or(Limits {
+            qps: None,
+            concurrent: Some(4),
+        });
+        assert_eq!(unlimited.concurrent, Some(4));
+        assert_eq!(unlimited.qps, None);
+    }
+}
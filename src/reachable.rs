@@ -0,0 +1,201 @@
+//! `cartog reachable`: the symbols reachable from entry points over resolved
+//! edges, or, inverted, the ones nothing reaches.
+//!
+//! Entry points default to what runs or can be called from outside: `main` and
+//! `init` functions, and the exported API of Go library packages (exported
+//! functions, methods, types and variables outside `main` packages, `internal/`
+//! directories, `vendor/` and `_test.go` files). From there, calls, references
+//! and embedding are followed, and a reachable method reaches its type.
+//!
+//! Methods only called through an interface are not reached: Go has no
+//! implements edges to follow. The unreachable set is a starting point for dead
+//! code and attack surface reviews, not a verdict.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{EdgeKind, Symbol, SymbolKind, Visibility};
+use crate::vendor::is_vendored;
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Reachability {
+    pub entries: Vec<Symbol>,
+    /// The reachable symbols, or the unreachable ones when inverted, by file and line.
+    pub symbols: Vec<Symbol>,
+    pub reachable: usize,
+    /// Functions, methods, types and package-level variables outside tests and vendor.
+    pub total: usize,
+}
+
+/// Symbols reachable from `entries` (default: [`default_entries`]), or those
+/// unreachable when `unreachable` is set.
+pub fn reachable(
+    db: &Database,
+    entries: Option<Vec<Symbol>>,
+    unreachable: bool,
+) -> Result<Reachability> {
+    let symbols = db.all_symbols()?;
+    let entries = entries.unwrap_or_else(|| default_entries(&symbols));
+
+    let mut edges: HashMap<String, Vec<String>> = HashMap::new();
+    for edge in db.all_edges()? {
+        if edge.kind == EdgeKind::Imports {
+            continue;
+        }
+        if let Some(target) = edge.target_id {
+            edges.entry(edge.source_id).or_default().push(target);
+        }
+    }
+    let by_id: HashMap<&str, &Symbol> = symbols.iter().map(|s| (s.id.as_str(), s)).collect();
+
+    let mut seen: HashSet<&str> = entries.iter().map(|e| e.id.as_str()).collect();
+    let mut queue: VecDeque<&str> = seen.iter().copied().collect();
+    while let Some(id) = queue.pop_front() {
+        let parent = by_id
+            .get(id)
+            .filter(|s| s.kind == SymbolKind::Method)
+            .and_then(|s| s.parent_id.as_deref());
+        let targets = edges.get(id).into_iter().flatten().map(String::as_str);
+        for target in targets.chain(parent) {
+            if let Some((&id, _)) = by_id.get_key_value(target) {
+                if seen.insert(id) {
+                    queue.push_back(id);
+                }
+            }
+        }
+    }
+
+    let counted: Vec<&Symbol> = symbols.iter().filter(|s| counts(s)).collect();
+    let reachable = counted
+        .iter()
+        .filter(|s| seen.contains(s.id.as_str()))
+        .count();
+    let mut listed: Vec<Symbol> = counted
+        .iter()
+        .filter(|s| seen.contains(s.id.as_str()) != unreachable)
+        .map(|s| (*s).clone())
+        .collect();
+    listed.sort_by(|a, b| (&a.file_path, a.start_line).cmp(&(&b.file_path, b.start_line)));
+    Ok(Reachability {
+        entries,
+        total: counted.len(),
+        reachable,
+        symbols: listed,
+    })
+}
+
+/// `main` and `init` functions, and the exported API of Go library packages.
+pub fn default_entries(symbols: &[Symbol]) -> Vec<Symbol> {
+    let is_func = |s: &Symbol, name: &str| {
+        s.kind == SymbolKind::Function && s.parent_id.is_none() && s.name == name
+    };
+    let main_dirs: HashSet<&str> = symbols
+        .iter()
+        .filter(|s| is_func(s, "main") && !is_test(&s.file_path))
+        .map(|s| dir_of(&s.file_path))
+        .collect();
+    let mut entries: Vec<Symbol> = symbols
+        .iter()
+        .filter(|s| !is_test(&s.file_path) && !is_vendored(&s.file_path))
+        .filter(|s| {
+            let runs = is_func(s, "main") || is_func(s, "init");
+            let api = s.file_path.ends_with(".go")
+                && s.visibility == Visibility::Public
+                && matches!(
+                    s.kind,
+                    SymbolKind::Function
+                        | SymbolKind::Method
+                        | SymbolKind::Class
+                        | SymbolKind::Variable
+                )
+                && (s.kind == SymbolKind::Method || s.parent_id.is_none())
+                && !main_dirs.contains(dir_of(&s.file_path))
+                && !s.file_path.starts_with("internal/")
+                && !s.file_path.contains("/internal/");
+            runs || api
+        })
+        .cloned()
+        .collect();
+    entries.sort_by(|a, b| (&a.file_path, a.start_line).cmp(&(&b.file_path, b.start_line)));
+    entries
+}
+
+/// Whether `symbol` is part of the reachable-or-not answer.
+fn counts(symbol: &Symbol) -> bool {
+    let kind = match symbol.kind {
+        SymbolKind::Function | SymbolKind::Method | SymbolKind::Class => true,
+        SymbolKind::Variable => symbol.parent_id.is_none(),
+        SymbolKind::Import | SymbolKind::Flag => false,
+    };
+    kind && !is_test(&symbol.file_path) && !is_vendored(&symbol.file_path)
+}
+
+fn is_test(path: &str) -> bool {
+    path.ends_with("_test.go")
+}
+
+fn dir_of(path: &str) -> &str {
+    path.rsplit_once('/').map_or("", |(dir, _)| dir)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::Edge;
+
+    #[test]
+    fn test_reachable_from_mains_and_exported_api() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, file: &str, line: u32| {
+            let sym = Symbol::new(name, SymbolKind::Function, file, line, line + 3, 0, 100);
+            if name.starts_with(char::is_lowercase) {
+                sym.with_visibility(Visibility::Private)
+            } else {
+                sym
+            }
+        };
+        let main = func("main", "cmd/app/main.go", 3);
+        let run = func("run", "cmd/app/main.go", 10);
+        let helper = func("Helper", "cmd/app/main.go", 20);
+        let parse = func("Parse", "pkg/parse/parse.go", 3);
+        let lex = func("lex", "pkg/parse/parse.go", 10);
+        let unused = func("unused", "pkg/parse/parse.go", 20);
+        let hidden = func("Hidden", "internal/util/util.go", 3);
+        let fixture = func("fixture", "pkg/parse/parse_test.go", 3);
+        db.insert_symbols(&[
+            main.clone(),
+            run.clone(),
+            helper,
+            parse.clone(),
+            lex,
+            unused,
+            hidden,
+            fixture,
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(&main.id, "run", EdgeKind::Calls, "cmd/app/main.go", 4),
+            Edge::new(&parse.id, "lex", EdgeKind::Calls, "pkg/parse/parse.go", 4),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let names = |symbols: &[Symbol]| -> Vec<String> {
+            symbols.iter().map(|s| s.name.clone()).collect()
+        };
+        let found = reachable(&db, None, false).unwrap();
+        assert_eq!(names(&found.entries), ["main", "Parse"]);
+        assert_eq!(names(&found.symbols), ["main", "run", "Parse", "lex"]);
+        assert_eq!((found.reachable, found.total), (4, 7));
+
+        // `Helper` is exported, but from a main package; `Hidden` from an internal one.
+        let dead = reachable(&db, None, true).unwrap();
+        assert_eq!(names(&dead.symbols), ["Helper", "Hidden", "unused"]);
+
+        let from_main = reachable(&db, Some(vec![main]), false).unwrap();
+        assert_eq!(names(&from_main.symbols), ["main", "run"]);
+    }
+}
@@ -0,0 +1,166 @@
+//! `cartog recent`: the symbols the last commits changed, with what depends on
+//! them: what moved and what it could break.
+//!
+//! Changed lines come from `git diff` between `HEAD~N` and `HEAD` (the empty
+//! tree when history is shorter), in `HEAD`'s line numbers, and are matched
+//! against the index. A change inside a method counts for the method, not for
+//! the class around it. Removed symbols are not in the index and not listed;
+//! `cartog pr prepare` covers those.
+
+use std::collections::{HashMap, HashSet};
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::git::{self, CommitInfo};
+use crate::types::{Symbol, SymbolKind};
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RecentChange {
+    pub symbol: Symbol,
+    /// Changed lines inside the symbol; a deletion counts as one.
+    pub lines: u32,
+    /// Symbols calling or referencing it.
+    pub direct: usize,
+    /// Symbols depending on it within the depth asked for.
+    pub impact: usize,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Recent {
+    /// Newest first.
+    pub commits: Vec<CommitInfo>,
+    /// Highest impact first.
+    pub changes: Vec<RecentChange>,
+}
+
+/// Symbols changed by the last `commits` commits of the repository at `root`,
+/// with their dependents up to `depth` hops away.
+pub fn recent(db: &Database, root: &Path, commits: u32, depth: u32) -> Result<Recent> {
+    let log = git::recent_commits(root, commits)?;
+    let from = match git::resolve_commit(root, &format!("HEAD~{}", log.len())) {
+        Ok(commit) => commit,
+        Err(_) => git::empty_tree(root)?,
+    };
+
+    let mut changes = Vec::new();
+    let mut dependents: HashMap<String, (usize, usize)> = HashMap::new();
+    for (file, ranges) in git::changed_lines(root, &from)? {
+        let symbols = db.outline(&file)?;
+        for (symbol, lines) in changed_symbols(&symbols, &ranges) {
+            if !dependents.contains_key(&symbol.name) {
+                let found = db.impact(&symbol.name, depth)?;
+                let distinct = |max: u32| {
+                    found
+                        .iter()
+                        .filter(|(_, d)| *d <= max)
+                        .map(|(e, _)| e.source_id.as_str())
+                        .collect::<HashSet<_>>()
+                        .len()
+                };
+                dependents.insert(symbol.name.clone(), (distinct(1), distinct(depth)));
+            }
+            let (direct, impact) = dependents[&symbol.name];
+            changes.push(RecentChange {
+                symbol,
+                lines,
+                direct,
+                impact,
+            });
+        }
+    }
+    changes.sort_by(|a, b| {
+        b.impact.cmp(&a.impact).then_with(|| {
+            (&a.symbol.file_path, a.symbol.start_line)
+                .cmp(&(&b.symbol.file_path, b.symbol.start_line))
+        })
+    });
+    Ok(Recent {
+        commits: log,
+        changes,
+    })
+}
+
+/// The symbols of a file that the changed line `ranges` fall in, innermost
+/// only, with the number of changed lines in each. Imports and variables
+/// declared inside another symbol are left to their parent.
+fn changed_symbols(symbols: &[Symbol], ranges: &[(u32, u32)]) -> Vec<(Symbol, u32)> {
+    let candidates: Vec<&Symbol> = symbols
+        .iter()
+        .filter(|s| match s.kind {
+            SymbolKind::Import => false,
+            SymbolKind::Variable => s.parent_id.is_none(),
+            _ => true,
+        })
+        .collect();
+    let mut changed: Vec<(Symbol, u32)> = Vec::new();
+    for symbol in &candidates {
+        // Lines inside a nested symbol count for that one.
+        let nested: Vec<&&Symbol> = candidates
+            .iter()
+            .filter(|inner| {
+                inner.start_line >= symbol.start_line
+                    && inner.end_line <= symbol.end_line
+                    && (inner.start_line, inner.end_line) != (symbol.start_line, symbol.end_line)
+            })
+            .collect();
+        let mut lines = 0;
+        for &(start, end) in ranges {
+            lines += (start.max(symbol.start_line)..=end.min(symbol.end_line))
+                .filter(|&line| {
+                    !nested
+                        .iter()
+                        .any(|inner| (inner.start_line..=inner.end_line).contains(&line))
+                })
+                .count() as u32;
+        }
+        if lines > 0 {
+            changed.push(((*symbol).clone(), lines));
+        }
+    }
+    changed
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_changed_symbols_are_innermost() {
+        let class = Symbol::new("Session", SymbolKind::Class, "auth.py", 1, 30, 0, 900);
+        let method = |name: &str, start: u32, end: u32| {
+            Symbol::new(name, SymbolKind::Method, "auth.py", start, end, 0, 100)
+                .with_parent(Some(&class.id))
+        };
+        let refresh = method("refresh", 5, 12);
+        let expire = method("expire", 14, 20);
+        let local = Symbol::new("ttl", SymbolKind::Variable, "auth.py", 6, 6, 0, 10)
+            .with_parent(Some(&refresh.id));
+        let import = Symbol::new("os", SymbolKind::Import, "auth.py", 1, 1, 0, 10);
+        let helper = Symbol::new("now", SymbolKind::Function, "auth.py", 40, 42, 0, 50);
+        let symbols = [class, refresh, expire, local, import, helper];
+
+        let names = |ranges: &[(u32, u32)]| -> Vec<(String, u32)> {
+            changed_symbols(&symbols, ranges)
+                .into_iter()
+                .map(|(s, n)| (s.name, n))
+                .collect()
+        };
+        assert_eq!(
+            names(&[(6, 7), (25, 26)]),
+            [("Session".to_string(), 2), ("refresh".to_string(), 2)]
+        );
+        assert_eq!(names(&[(1, 1), (44, 50)]), [("Session".to_string(), 1)]);
+        // Lines 21 to 30 are in the class body, between and after methods.
+        assert_eq!(
+            names(&[(18, 41)]),
+            [
+                ("Session".to_string(), 10),
+                ("expire".to_string(), 3),
+                ("now".to_string(), 2)
+            ]
+        );
+    }
+}
@@ -0,0 +1,314 @@
+//! Sequence diagrams: the calls made from a function, in source order, as a
+//! Mermaid `sequenceDiagram` with one participant per type or package.
+//!
+//! Without a target, resolved callees are walked depth-first, each function
+//! expanded once so recursion and shared helpers are drawn a single time. With a
+//! target, only the shortest call chain from the function to it is drawn.
+//! Methods act as their type (Go methods as their receiver); functions as their
+//! package directory.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+use std::fmt::Write as _;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::doc::package_of;
+use crate::types::{EdgeKind, Symbol};
+
+/// One call in the diagram.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Call {
+    pub caller: String,
+    pub callee: Symbol,
+    /// Participants: the caller's and the callee's type or package.
+    pub from: String,
+    pub to: String,
+    /// Line of the call in the caller's file.
+    pub line: u32,
+    /// 1 for calls made by the starting function.
+    pub depth: u32,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Sequence {
+    pub start: Symbol,
+    /// The starting function's participant.
+    pub actor: String,
+    pub calls: Vec<Call>,
+}
+
+/// Resolved calls and the symbols they connect.
+struct CallGraph {
+    calls: HashMap<String, Vec<(String, u32)>>,
+    symbols: HashMap<String, Symbol>,
+}
+
+impl CallGraph {
+    fn load(db: &Database) -> Result<Self> {
+        let mut calls: HashMap<String, Vec<(String, u32)>> = HashMap::new();
+        for edge in db.all_edges()? {
+            if let (EdgeKind::Calls, Some(target)) = (edge.kind, edge.target_id) {
+                calls
+                    .entry(edge.source_id)
+                    .or_default()
+                    .push((target, edge.line));
+            }
+        }
+        for targets in calls.values_mut() {
+            targets.sort_by_key(|(_, line)| *line);
+        }
+        let symbols = db
+            .all_symbols()?
+            .into_iter()
+            .map(|s| (s.id.clone(), s))
+            .collect();
+        Ok(Self { calls, symbols })
+    }
+
+    /// The participant `symbol` acts as.
+    fn actor(&self, symbol: &Symbol) -> String {
+        match symbol.parent_id.as_deref() {
+            Some(parent) => match self.symbols.get(parent) {
+                Some(parent) => parent.name.clone(),
+                // Go receivers: `file:Type`.
+                None => parent.rsplit(':').next().unwrap_or(parent).to_string(),
+            },
+            None => package_of(&symbol.file_path, 0),
+        }
+    }
+
+    fn call(&self, caller: &str, callee: &str, line: u32, depth: u32) -> Option<Call> {
+        let caller = self.symbols.get(caller)?;
+        let callee = self.symbols.get(callee)?;
+        Some(Call {
+            caller: caller.name.clone(),
+            callee: callee.clone(),
+            from: self.actor(caller),
+            to: self.actor(callee),
+            line,
+            depth,
+        })
+    }
+
+    fn walk(
+        &self,
+        id: &str,
+        level: u32,
+        depth: u32,
+        expanded: &mut HashSet<String>,
+        out: &mut Vec<Call>,
+    ) {
+        if level == depth {
+            return;
+        }
+        for (target, line) in self.calls.get(id).into_iter().flatten() {
+            out.extend(self.call(id, target, *line, level + 1));
+            if expanded.insert(target.clone()) {
+                self.walk(target, level + 1, depth, expanded, out);
+            }
+        }
+    }
+}
+
+/// Calls from the first definition of `name`, up to `depth` calls deep.
+pub fn from_function(db: &Database, name: &str, depth: u32) -> Result<Option<Sequence>> {
+    let Some(start) = db.find_definitions(name)?.into_iter().next() else {
+        return Ok(None);
+    };
+    let graph = CallGraph::load(db)?;
+    let mut calls = Vec::new();
+    let mut expanded = HashSet::from([start.id.clone()]);
+    graph.walk(&start.id, 0, depth, &mut expanded, &mut calls);
+    Ok(Some(Sequence {
+        actor: graph.actor(&start),
+        start,
+        calls,
+    }))
+}
+
+/// The shortest call chain from a definition of `from` to one of `to`, within
+/// `depth` calls. `None` when there is none.
+pub fn path(db: &Database, from: &str, to: &str, depth: u32) -> Result<Option<Sequence>> {
+    let targets: HashSet<String> = db.find_definitions(to)?.into_iter().map(|s| s.id).collect();
+    let graph = CallGraph::load(db)?;
+    for start in db.find_definitions(from)? {
+        // Breadth-first, so the first target reached is a nearest one.
+        let mut parent: HashMap<&str, (&str, u32)> = HashMap::new();
+        let mut queue = VecDeque::from([(start.id.as_str(), 0)]);
+        let mut seen = HashSet::from([start.id.as_str()]);
+        while let Some((id, level)) = queue.pop_front() {
+            if targets.contains(id) && id != start.id {
+                let mut chain = Vec::new();
+                let mut current = id;
+                while let Some(&(caller, line)) = parent.get(current) {
+                    chain.push((caller, current, line));
+                    current = caller;
+                }
+                chain.reverse();
+                let calls = chain
+                    .into_iter()
+                    .zip(1..)
+                    .filter_map(|((caller, callee, line), d)| graph.call(caller, callee, line, d))
+                    .collect();
+                return Ok(Some(Sequence {
+                    actor: graph.actor(&start),
+                    start,
+                    calls,
+                }));
+            }
+            if level == depth {
+                continue;
+            }
+            for (target, line) in graph.calls.get(id).into_iter().flatten() {
+                if seen.insert(target.as_str()) {
+                    parent.insert(target.as_str(), (id, *line));
+                    queue.push_back((target.as_str(), level + 1));
+                }
+            }
+        }
+    }
+    Ok(None)
+}
+
+/// The sequence as a Mermaid `sequenceDiagram`, participants in order of
+/// appearance.
+pub fn render_mermaid(sequence: &Sequence) -> String {
+    let mut ids: Vec<&str> = vec![&sequence.actor];
+    for call in &sequence.calls {
+        for actor in [call.from.as_str(), call.to.as_str()] {
+            if !ids.contains(&actor) {
+                ids.push(actor);
+            }
+        }
+    }
+    let id = |actor: &str| ids.iter().position(|a| *a == actor).unwrap_or(0);
+    let mut out = String::from("sequenceDiagram\n");
+    for (i, actor) in ids.iter().enumerate() {
+        let _ = writeln!(out, "    participant p{i} as {}", actor.replace(';', ","));
+    }
+    for call in &sequence.calls {
+        let _ = writeln!(
+            out,
+            "    p{}->>p{}: {}()",
+            id(&call.from),
+            id(&call.to),
+            call.callee.name
+        );
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, SymbolKind};
+
+    #[test]
+    fn test_sequence_from_function_and_path() {
+        let db = Database::open_memory().unwrap();
+        let login = Symbol::new(
+            "Login",
+            SymbolKind::Function,
+            "routes/auth.go",
+            3,
+            12,
+            0,
+            200,
+        );
+        let service = Symbol::new(
+            "AuthService",
+            SymbolKind::Class,
+            "auth/service.go",
+            3,
+            6,
+            0,
+            60,
+        );
+        let verify = Symbol::new(
+            "Verify",
+            SymbolKind::Method,
+            "auth/service.go",
+            8,
+            14,
+            70,
+            200,
+        )
+        .with_parent(Some("auth/service.go:AuthService"));
+        let find = Symbol::new(
+            "FindUser",
+            SymbolKind::Function,
+            "store/users.go",
+            3,
+            9,
+            0,
+            90,
+        );
+        let audit = Symbol::new("Audit", SymbolKind::Function, "store/audit.go", 3, 9, 0, 90);
+        db.insert_symbols(&[
+            login.clone(),
+            service,
+            verify.clone(),
+            find.clone(),
+            audit.clone(),
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(&login.id, "Audit", EdgeKind::Calls, "routes/auth.go", 9),
+            Edge::new(&login.id, "Verify", EdgeKind::Calls, "routes/auth.go", 5),
+            Edge::new(
+                &verify.id,
+                "FindUser",
+                EdgeKind::Calls,
+                "auth/service.go",
+                10,
+            ),
+            Edge::new(&find.id, "Audit", EdgeKind::Calls, "store/users.go", 6),
+            // Recursion is drawn once.
+            Edge::new(&audit.id, "Audit", EdgeKind::Calls, "store/audit.go", 5),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let sequence = from_function(&db, "Login", 5).unwrap().unwrap();
+        let calls: Vec<_> = sequence
+            .calls
+            .iter()
+            .map(|c| (c.caller.as_str(), c.callee.name.as_str(), c.depth))
+            .collect();
+        assert_eq!(
+            calls,
+            [
+                ("Login", "Verify", 1),
+                ("Verify", "FindUser", 2),
+                ("FindUser", "Audit", 3),
+                ("Audit", "Audit", 4),
+                ("Login", "Audit", 1),
+            ]
+        );
+        assert_eq!(
+            render_mermaid(&sequence),
+            "sequenceDiagram
+    participant p0 as routes
+    participant p1 as AuthService
+    participant p2 as store
+    p0->>p1: Verify()
+    p1->>p2: FindUser()
+    p2->>p2: Audit()
+    p2->>p2: Audit()
+    p0->>p2: Audit()
+"
+        );
+        assert_eq!(
+            from_function(&db, "Login", 1).unwrap().unwrap().calls.len(),
+            2
+        );
+
+        let chain = path(&db, "Login", "FindUser", 5).unwrap().unwrap();
+        let names: Vec<_> = chain.calls.iter().map(|c| c.callee.name.as_str()).collect();
+        assert_eq!(names, ["Verify", "FindUser"]);
+        assert!(path(&db, "Login", "FindUser", 1).unwrap().is_none());
+        assert!(from_function(&db, "Missing", 3).unwrap().is_none());
+    }
+}
@@ -0,0 +1,207 @@
+//! Per-client session state for the MCP server.
+//!
+//! Every client connected to `cartog serve` gets its own session, so agents and
+//! editors sharing one server process don't see each other's settings: a default
+//! path scope and tag for tool calls that give none, a token budget charged for
+//! every query response, and, when asked for, the symbols already returned so
+//! repeated searches only show what is new. Authenticated clients also carry
+//! their token's name and access.
+
+use std::collections::HashSet;
+use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+
+use serde::Serialize;
+
+use crate::auth::{Access, Grant};
+use crate::ratelimit::Limits;
+
+/// Estimated tokens of a response, at four bytes each.
+pub fn estimate_tokens(text: &str) -> u32 {
+    (text.len() as u32).saturating_add(3) / 4
+}
+
+#[derive(Debug, Default)]
+pub struct Session {
+    pub id: u64,
+    /// Name of the token the client presented.
+    pub client: Option<String>,
+    pub access: Access,
+    /// Query rate and concurrency the client is held to.
+    pub limits: Limits,
+    /// Path prefix, relative to the project root, that results are kept within.
+    pub scope: Option<String>,
+    /// Tag applied to tool calls that don't pass one.
+    pub tag: Option<String>,
+    /// Tokens of responses this session may receive; unlimited when unset.
+    pub budget: Option<u32>,
+    /// Estimated tokens of the responses sent so far.
+    pub spent: u32,
+    /// Drop symbols already returned from later search results.
+    pub dedup: bool,
+    seen: HashSet<String>,
+}
+
+/// What `cartog_session` reports.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct SessionStatus {
+    pub id: u64,
+    pub client: Option<String>,
+    pub access: Access,
+    pub limits: Limits,
+    pub scope: Option<String>,
+    pub tag: Option<String>,
+    pub budget: Option<u32>,
+    pub spent: u32,
+    pub dedup: bool,
+    /// Symbols returned so far, when deduplicating.
+    pub seen: usize,
+    /// Sessions open on the server, this one included.
+    pub clients: usize,
+}
+
+impl Session {
+    pub fn new(id: u64) -> Self {
+        Self {
+            id,
+            ..Self::default()
+        }
+    }
+
+    /// Act with what `grant` allows.
+    pub fn authenticate(&mut self, grant: &Grant) {
+        self.client = Some(grant.name.clone());
+        self.access = grant.access;
+        self.limits = grant.limits.or(self.limits);
+    }
+
+    /// What the client is counted as for rate limits: its token, shared by all
+    /// its connections, or else this session.
+    pub fn rate_key(&self) -> String {
+        match &self.client {
+            Some(client) => format!("token:{client}"),
+            None => format!("session:{}", self.id),
+        }
+    }
+
+    /// Forget what was spent and seen; settings are kept.
+    pub fn reset(&mut self) {
+        self.spent = 0;
+        self.seen.clear();
+    }
+
+    /// The session's tag when the call passes none.
+    pub fn tag_or_default(&self, tag: Option<String>) -> Option<String> {
+        tag.or_else(|| self.tag.clone())
+    }
+
+    /// Whether `file` is within the session's scope.
+    pub fn in_scope(&self, file: &str) -> bool {
+        self.scope.as_deref().map_or(true, |scope| {
+            let scope = scope.trim_start_matches("./").trim_end_matches('/');
+            scope.is_empty()
+                || file == scope
+                || file
+                    .strip_prefix(scope)
+                    .is_some_and(|rest| rest.starts_with('/'))
+        })
+    }
+
+    /// With dedup on, whether symbol `id` is new to the session (and remember it);
+    /// always true otherwise.
+    pub fn first_sight(&mut self, id: &str) -> bool {
+        !self.dedup || self.seen.insert(id.to_string())
+    }
+
+    /// Charge a response to the budget. Refused once the budget is spent; the
+    /// response that crosses it still goes through.
+    pub fn charge(&mut self, text: &str) -> Result<(), String> {
+        if let Some(budget) = self.budget {
+            if self.spent >= budget {
+                return Err(format!(
+                    "session token budget of {budget} is spent; raise it or reset with cartog_session"
+                ));
+            }
+        }
+        self.spent = self.spent.saturating_add(estimate_tokens(text));
+        Ok(())
+    }
+
+    pub fn status(&self, clients: usize) -> SessionStatus {
+        SessionStatus {
+            id: self.id,
+            client: self.client.clone(),
+            access: self.access,
+            limits: self.limits,
+            scope: self.scope.clone(),
+            tag: self.tag.clone(),
+            budget: self.budget,
+            spent: self.spent,
+            dedup: self.dedup,
+            seen: self.seen.len(),
+            clients,
+        }
+    }
+}
+
+/// Hands out session ids and counts open sessions.
+#[derive(Debug, Default)]
+pub struct Sessions {
+    next_id: AtomicU64,
+    open: AtomicUsize,
+}
+
+impl Sessions {
+    pub fn open(&self) -> Session {
+        self.open.fetch_add(1, Ordering::Relaxed);
+        Session::new(self.next_id.fetch_add(1, Ordering::Relaxed) + 1)
+    }
+
+    pub fn close(&self) {
+        self.open.fetch_sub(1, Ordering::Relaxed);
+    }
+
+    pub fn count(&self) -> usize {
+        self.open.load(Ordering::Relaxed)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_sessions_are_isolated() {
+        let sessions = Sessions::default();
+        let mut a = sessions.open();
+        let mut b = sessions.open();
+        assert_eq!((a.id, b.id, sessions.count()), (1, 2, 2));
+
+        a.scope = Some("./internal/api/".to_string());
+        assert!(a.in_scope("internal/api/users.go"));
+        assert!(!a.in_scope("internal/apiv2/users.go"));
+        assert!(b.in_scope("cmd/main.go"));
+
+        a.tag = Some("public".to_string());
+        assert_eq!(a.tag_or_default(None).as_deref(), Some("public"));
+        assert_eq!(a.tag_or_default(Some("db".into())).as_deref(), Some("db"));
+        assert_eq!(b.tag_or_default(None), None);
+
+        a.dedup = true;
+        assert!(a.first_sight("x.go:F:1"));
+        assert!(!a.first_sight("x.go:F:1"));
+        assert!(b.first_sight("x.go:F:1"));
+        assert!(b.first_sight("x.go:F:1"));
+
+        a.budget = Some(2);
+        assert!(a.charge("12345678").is_ok());
+        assert!(a.charge("1").is_err());
+        assert!(b.charge("12345678").is_ok());
+        assert_eq!(a.status(sessions.count()).spent, 2);
+        a.reset();
+        assert_eq!(a.status(0).seen, 0);
+        assert!(a.charge("1").is_ok());
+
+        sessions.close();
+        assert_eq!(sessions.count(), 1);
+    }
+}
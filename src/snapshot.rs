@@ -0,0 +1,139 @@
+//! Release snapshots: the package dependency graph stored under a tag inside the
+//! index, so later questions ("when did routes start depending on payment
+//! directly?") can be answered without checking out old releases.
+//!
+//! A snapshot keeps only packages (every directory, see `doc::packages`) with
+//! their file and symbol counts, and the resolved edge counts between them:
+//! small enough to keep one per release.
+
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::doc::{self, Dependency};
+use crate::git;
+
+/// A stored snapshot.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Snapshot {
+    pub tag: String,
+    /// Unix seconds.
+    pub created_at: i64,
+    /// HEAD when the snapshot was taken, if the project is a git repository.
+    pub commit: Option<String>,
+    pub packages: u32,
+    pub dependencies: u32,
+}
+
+/// The dependencies between two packages in one snapshot.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct DependencyPoint {
+    /// `None` for the current index.
+    pub snapshot: Option<Snapshot>,
+    /// Total edges over `pairs`.
+    pub edges: u32,
+    /// Package pairs matching the query, most edges first.
+    pub pairs: Vec<Dependency>,
+}
+
+/// Store the current index's package graph under `tag`.
+pub fn record(db: &Database, root: &Path, tag: &str, now: i64) -> Result<Snapshot> {
+    let packages = doc::packages(db, 0)?;
+    let dependencies = doc::dependencies(db, 0)?;
+    let commit = git::head_commit(root);
+    db.save_snapshot(tag, now, commit.as_deref(), &packages, &dependencies)?;
+    Ok(Snapshot {
+        tag: tag.to_string(),
+        created_at: now,
+        commit,
+        packages: packages.len() as u32,
+        dependencies: dependencies.len() as u32,
+    })
+}
+
+/// Whether `package` is `prefix` or inside it.
+fn within(package: &str, prefix: &str) -> bool {
+    let prefix = prefix.trim_end_matches('/');
+    package == prefix
+        || package
+            .strip_prefix(prefix)
+            .is_some_and(|rest| rest.starts_with('/'))
+}
+
+/// Direct dependencies from packages within `from` to packages within `to`, in
+/// every snapshot oldest first, then in the current index.
+pub fn dependency_history(db: &Database, from: &str, to: &str) -> Result<Vec<DependencyPoint>> {
+    let matching = |deps: Vec<Dependency>| {
+        let pairs: Vec<Dependency> = deps
+            .into_iter()
+            .filter(|d| within(&d.from, from) && within(&d.to, to))
+            .collect();
+        (pairs.iter().map(|d| d.edges).sum::<u32>(), pairs)
+    };
+    let mut points = Vec::new();
+    for snapshot in db.snapshots()? {
+        let (edges, pairs) = matching(db.snapshot_dependencies(&snapshot.tag)?);
+        points.push(DependencyPoint {
+            snapshot: Some(snapshot),
+            edges,
+            pairs,
+        });
+    }
+    let (edges, pairs) = matching(doc::dependencies(db, 0)?);
+    points.push(DependencyPoint {
+        snapshot: None,
+        edges,
+        pairs,
+    });
+    Ok(points)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, FileInfo, Symbol, SymbolKind};
+
+    #[test]
+    fn test_dependency_appears_between_snapshots() {
+        let db = Database::open_memory().unwrap();
+        let files = ["internal/routes/orders.go", "internal/payment/charge.go"];
+        for path in files {
+            db.upsert_file(&FileInfo {
+                path: path.to_string(),
+                last_modified: 0.0,
+                hash: String::new(),
+                language: "go".to_string(),
+                num_symbols: 1,
+            })
+            .unwrap();
+        }
+        let handler = Symbol::new("Orders", SymbolKind::Function, files[0], 3, 9, 0, 90);
+        let charge = Symbol::new("Charge", SymbolKind::Function, files[1], 3, 9, 0, 90);
+        db.insert_symbols(&[handler.clone(), charge]).unwrap();
+        let root = Path::new("/nonexistent");
+
+        let v1 = record(&db, root, "v1.0.0", 100).unwrap();
+        assert_eq!((v1.packages, v1.dependencies), (2, 0));
+
+        db.insert_edges(&[Edge::new(
+            &handler.id,
+            "Charge",
+            EdgeKind::Calls,
+            files[0],
+            5,
+        )])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        record(&db, root, "v1.1.0", 200).unwrap();
+
+        let history = dependency_history(&db, "internal/routes", "internal/payment/").unwrap();
+        let edges: Vec<_> = history
+            .iter()
+            .map(|p| (p.snapshot.as_ref().map(|s| s.tag.as_str()), p.edges))
+            .collect();
+        assert_eq!(edges, [(Some("v1.0.0"), 0), (Some("v1.1.0"), 1), (None, 1)]);
+        assert!(!within("internal/routesx", "internal/routes"));
+    }
+}
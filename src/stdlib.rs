@@ -0,0 +1,235 @@
+//! Go standard library calls: recognised as external symbols instead of being
+//! left unresolved, with a link to their documentation on pkg.go.dev.
+//!
+//! A call `pkg.Name(...)` is a standard library call when `pkg` is the name a
+//! file imports a standard library package as (`go.mod` decides what is
+//! standard, see `GoMod::resolve`). The most used functions also carry their
+//! signature; the others get the link alone. Nothing here reads the Go
+//! installation, so it works without a toolchain.
+
+use std::collections::HashMap;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::deps_usage::{go_local_name, GoMod, GoPackage};
+use crate::types::SymbolKind;
+
+const DOC_URL: &str = "https://pkg.go.dev";
+
+/// Signatures of the most called standard library functions.
+const SIGNATURES: &[(&str, &str, &str)] = &[
+    ("bytes", "Contains", "func Contains(b, subslice []byte) bool"),
+    ("bytes", "Equal", "func Equal(a, b []byte) bool"),
+    ("bytes", "NewBuffer", "func NewBuffer(buf []byte) *Buffer"),
+    ("bytes", "NewReader", "func NewReader(b []byte) *Reader"),
+    ("context", "Background", "func Background() Context"),
+    ("context", "TODO", "func TODO() Context"),
+    ("context", "WithCancel", "func WithCancel(parent Context) (ctx Context, cancel CancelFunc)"),
+    ("context", "WithTimeout", "func WithTimeout(parent Context, timeout time.Duration) (Context, CancelFunc)"),
+    ("context", "WithValue", "func WithValue(parent Context, key, val any) Context"),
+    ("encoding/json", "Marshal", "func Marshal(v any) ([]byte, error)"),
+    ("encoding/json", "MarshalIndent", "func MarshalIndent(v any, prefix, indent string) ([]byte, error)"),
+    ("encoding/json", "NewDecoder", "func NewDecoder(r io.Reader) *Decoder"),
+    ("encoding/json", "NewEncoder", "func NewEncoder(w io.Writer) *Encoder"),
+    ("encoding/json", "Unmarshal", "func Unmarshal(data []byte, v any) error"),
+    ("errors", "As", "func As(err error, target any) bool"),
+    ("errors", "Is", "func Is(err, target error) bool"),
+    ("errors", "Join", "func Join(errs ...error) error"),
+    ("errors", "New", "func New(text string) error"),
+    ("errors", "Unwrap", "func Unwrap(err error) error"),
+    ("fmt", "Errorf", "func Errorf(format string, a ...any) error"),
+    ("fmt", "Fprintf", "func Fprintf(w io.Writer, format string, a ...any) (n int, err error)"),
+    ("fmt", "Fprintln", "func Fprintln(w io.Writer, a ...any) (n int, err error)"),
+    ("fmt", "Print", "func Print(a ...any) (n int, err error)"),
+    ("fmt", "Printf", "func Printf(format string, a ...any) (n int, err error)"),
+    ("fmt", "Println", "func Println(a ...any) (n int, err error)"),
+    ("fmt", "Sprint", "func Sprint(a ...any) string"),
+    ("fmt", "Sprintf", "func Sprintf(format string, a ...any) string"),
+    ("fmt", "Sscanf", "func Sscanf(str string, format string, a ...any) (n int, err error)"),
+    ("io", "Copy", "func Copy(dst Writer, src Reader) (written int64, err error)"),
+    ("io", "ReadAll", "func ReadAll(r Reader) ([]byte, error)"),
+    ("log", "Fatal", "func Fatal(v ...any)"),
+    ("log", "Fatalf", "func Fatalf(format string, v ...any)"),
+    ("log", "Printf", "func Printf(format string, v ...any)"),
+    ("log", "Println", "func Println(v ...any)"),
+    ("net/http", "Error", "func Error(w ResponseWriter, error string, code int)"),
+    ("net/http", "Get", "func Get(url string) (resp *Response, err error)"),
+    ("net/http", "HandleFunc", "func HandleFunc(pattern string, handler func(ResponseWriter, *Request))"),
+    ("net/http", "ListenAndServe", "func ListenAndServe(addr string, handler Handler) error"),
+    ("net/http", "NewRequest", "func NewRequest(method, url string, body io.Reader) (*Request, error)"),
+    ("net/http", "NewRequestWithContext", "func NewRequestWithContext(ctx context.Context, method, url string, body io.Reader) (*Request, error)"),
+    ("net/http", "NewServeMux", "func NewServeMux() *ServeMux"),
+    ("os", "Exit", "func Exit(code int)"),
+    ("os", "Getenv", "func Getenv(key string) string"),
+    ("os", "LookupEnv", "func LookupEnv(key string) (string, bool)"),
+    ("os", "Open", "func Open(name string) (*File, error)"),
+    ("os", "Create", "func Create(name string) (*File, error)"),
+    ("os", "MkdirAll", "func MkdirAll(path string, perm FileMode) error"),
+    ("os", "ReadFile", "func ReadFile(name string) ([]byte, error)"),
+    ("os", "Remove", "func Remove(name string) error"),
+    ("os", "WriteFile", "func WriteFile(name string, data []byte, perm FileMode) error"),
+    ("path/filepath", "Base", "func Base(path string) string"),
+    ("path/filepath", "Dir", "func Dir(path string) string"),
+    ("path/filepath", "Join", "func Join(elem ...string) string"),
+    ("path/filepath", "WalkDir", "func WalkDir(root string, fn fs.WalkDirFunc) error"),
+    ("sort", "Slice", "func Slice(x any, less func(i, j int) bool)"),
+    ("sort", "Strings", "func Strings(x []string)"),
+    ("strconv", "Atoi", "func Atoi(s string) (int, error)"),
+    ("strconv", "FormatInt", "func FormatInt(i int64, base int) string"),
+    ("strconv", "Itoa", "func Itoa(i int) string"),
+    ("strconv", "ParseBool", "func ParseBool(str string) (bool, error)"),
+    ("strconv", "ParseFloat", "func ParseFloat(s string, bitSize int) (float64, error)"),
+    ("strconv", "ParseInt", "func ParseInt(s string, base int, bitSize int) (i int64, err error)"),
+    ("strconv", "Quote", "func Quote(s string) string"),
+    ("strings", "Contains", "func Contains(s, substr string) bool"),
+    ("strings", "EqualFold", "func EqualFold(s, t string) bool"),
+    ("strings", "Fields", "func Fields(s string) []string"),
+    ("strings", "HasPrefix", "func HasPrefix(s, prefix string) bool"),
+    ("strings", "HasSuffix", "func HasSuffix(s, suffix string) bool"),
+    ("strings", "Index", "func Index(s, substr string) int"),
+    ("strings", "Join", "func Join(elems []string, sep string) string"),
+    ("strings", "NewReader", "func NewReader(s string) *Reader"),
+    ("strings", "Repeat", "func Repeat(s string, count int) string"),
+    ("strings", "ReplaceAll", "func ReplaceAll(s, old, new string) string"),
+    ("strings", "Split", "func Split(s, sep string) []string"),
+    ("strings", "SplitN", "func SplitN(s, sep string, n int) []string"),
+    ("strings", "ToLower", "func ToLower(s string) string"),
+    ("strings", "ToUpper", "func ToUpper(s string) string"),
+    ("strings", "TrimPrefix", "func TrimPrefix(s, prefix string) string"),
+    ("strings", "TrimSpace", "func TrimSpace(s string) string"),
+    ("strings", "TrimSuffix", "func TrimSuffix(s, suffix string) string"),
+    ("sync", "OnceFunc", "func OnceFunc(f func()) func()"),
+    ("time", "After", "func After(d Duration) <-chan Time"),
+    ("time", "Now", "func Now() Time"),
+    ("time", "NewTicker", "func NewTicker(d Duration) *Ticker"),
+    ("time", "NewTimer", "func NewTimer(d Duration) *Timer"),
+    ("time", "Parse", "func Parse(layout, value string) (Time, error)"),
+    ("time", "ParseDuration", "func ParseDuration(s string) (Duration, error)"),
+    ("time", "Since", "func Since(t Time) Duration"),
+    ("time", "Sleep", "func Sleep(d Duration)"),
+    ("time", "Unix", "func Unix(sec int64, nsec int64) Time"),
+];
+
+/// A standard library symbol.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct StdSymbol {
+    /// Import path, e.g. `net/http`.
+    pub package: String,
+    pub name: String,
+    pub signature: Option<String>,
+    /// Its documentation on pkg.go.dev.
+    pub url: String,
+}
+
+impl StdSymbol {
+    pub fn new(package: &str, name: &str) -> Self {
+        let signature = SIGNATURES
+            .iter()
+            .find(|(p, n, _)| *p == package && *n == name)
+            .map(|(_, _, s)| s.to_string());
+        Self {
+            package: package.to_string(),
+            name: name.to_string(),
+            signature,
+            url: format!("{DOC_URL}/{package}#{name}"),
+        }
+    }
+}
+
+/// Local name -> import path for the standard library packages `file` imports.
+pub fn file_imports(db: &Database, file: &str, go_mod: &GoMod) -> Result<HashMap<String, String>> {
+    if !file.ends_with(".go") {
+        return Ok(HashMap::new());
+    }
+    let mut imports = HashMap::new();
+    for import in db.outline(file)? {
+        if import.kind != SymbolKind::Import || go_mod.resolve(&import.name) != GoPackage::Stdlib {
+            continue;
+        }
+        if let Some(local) = go_local_name(&import.name, import.signature.as_deref()) {
+            imports.insert(local.to_string(), import.name.clone());
+        }
+    }
+    Ok(imports)
+}
+
+/// The standard library symbol a call goes to, given the file's standard
+/// library imports from [`file_imports`]: `fmt.Sprintf` after `import "fmt"`.
+/// A method on a package-level value (`http.DefaultClient.Do`) links to the value.
+pub fn call_target(target: &str, imports: &HashMap<String, String>) -> Option<StdSymbol> {
+    let call = target.split('(').next().unwrap_or(target);
+    let (qualifier, rest) = call.split_once('.')?;
+    let package = imports.get(qualifier)?;
+    let name = rest.split('.').next().unwrap_or(rest);
+    Some(StdSymbol::new(package, name))
+}
+
+/// Standard library functions a search for `query` probably meant, for when the
+/// index has nothing: `fmt.Sprintf` or `http.Get` names one through its package,
+/// a bare name matches the functions with known signatures.
+pub fn lookup(query: &str) -> Vec<StdSymbol> {
+    if let Some((package, name)) = query.rsplit_once('.') {
+        return SIGNATURES
+            .iter()
+            .filter(|(p, n, _)| {
+                (*p == package || p.rsplit('/').next() == Some(package)) && *n == name
+            })
+            .map(|(p, n, _)| StdSymbol::new(p, n))
+            .collect();
+    }
+    SIGNATURES
+        .iter()
+        .filter(|(_, n, _)| n.eq_ignore_ascii_case(query))
+        .map(|(p, n, _)| StdSymbol::new(p, n))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::deps_usage::parse_go_mod;
+    use crate::types::Symbol;
+
+    #[test]
+    fn test_call_targets_through_std_imports() {
+        let db = Database::open_memory().unwrap();
+        let file = "api/handler.go";
+        let import = |path: &str, spec: &str, line: u32| {
+            Symbol::new(path, SymbolKind::Import, file, line, line, 0, 10)
+                .with_signature(Some(spec.to_string()))
+        };
+        db.insert_symbols(&[
+            import("fmt", "\"fmt\"", 3),
+            import("net/http", "\"net/http\"", 4),
+            import("crypto/rand", "crand \"crypto/rand\"", 5),
+            import("example.com/app/store", "\"example.com/app/store\"", 6),
+            import("shop/internal/auth", "\"shop/internal/auth\"", 7),
+        ])
+        .unwrap();
+        // A module path without a dot is not the standard library.
+        let go_mod = parse_go_mod("module shop\n");
+        let imports = file_imports(&db, file, &go_mod).unwrap();
+        assert_eq!(imports.len(), 3);
+
+        let sprintf = call_target("fmt.Sprintf", &imports).unwrap();
+        assert_eq!(sprintf.url, "https://pkg.go.dev/fmt#Sprintf");
+        assert_eq!(
+            sprintf.signature.as_deref(),
+            Some("func Sprintf(format string, a ...any) string")
+        );
+        let client = call_target("http.DefaultClient.Do", &imports).unwrap();
+        assert_eq!(client.url, "https://pkg.go.dev/net/http#DefaultClient");
+        assert_eq!(client.signature, None);
+        let read = call_target("crand.Read(buf)", &imports).unwrap();
+        assert_eq!(read.package, "crypto/rand");
+        assert_eq!(call_target("auth.Check", &imports), None);
+        assert_eq!(call_target("store.Open", &imports), None);
+
+        let found: Vec<_> = lookup("http.Get").into_iter().map(|s| s.url).collect();
+        assert_eq!(found, ["https://pkg.go.dev/net/http#Get"]);
+        assert_eq!(lookup("sprintf").len(), 1);
+        assert!(lookup("Nope").is_empty());
+    }
+}
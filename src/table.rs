@@ -0,0 +1,71 @@
+//! Delimited output of list commands, for spreadsheets and BI tools: a header
+//! line, then one line per row.
+//!
+//! CSV follows RFC 4180: a field holding a comma, a quote or a line break is
+//! quoted, with its quotes doubled. TSV has no quoting, so tabs and line breaks
+//! inside a field become spaces.
+
+use std::borrow::Cow;
+use std::io::Write;
+
+use anyhow::Result;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TableFormat {
+    Csv,
+    Tsv,
+}
+
+/// Write `header` and `rows` to stdout in `format`.
+pub fn write_table<I>(format: TableFormat, header: &[&str], rows: I) -> Result<()>
+where
+    I: IntoIterator<Item = Vec<String>>,
+{
+    let mut out = std::io::stdout().lock();
+    writeln!(out, "{}", line(format, header.iter().copied()))?;
+    for row in rows {
+        writeln!(out, "{}", line(format, row.iter().map(String::as_str)))?;
+    }
+    Ok(())
+}
+
+fn line<'a>(format: TableFormat, fields: impl Iterator<Item = &'a str>) -> String {
+    let separator = match format {
+        TableFormat::Csv => ",",
+        TableFormat::Tsv => "\t",
+    };
+    fields
+        .map(|f| field(format, f))
+        .collect::<Vec<_>>()
+        .join(separator)
+}
+
+fn field(format: TableFormat, value: &str) -> Cow<'_, str> {
+    match format {
+        TableFormat::Csv if value.contains([',', '"', '\n', '\r']) => {
+            Cow::Owned(format!("\"{}\"", value.replace('"', "\"\"")))
+        }
+        TableFormat::Tsv if value.contains(['\t', '\n', '\r']) => {
+            Cow::Owned(value.replace(['\t', '\n', '\r'], " "))
+        }
+        _ => Cow::Borrowed(value),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_fields_are_escaped_per_format() {
+        let fields = ["method", "Cache.Get", "a,b.go", "say \"hi\"", "x\ty\nz"];
+        assert_eq!(
+            line(TableFormat::Csv, fields.into_iter()),
+            "method,Cache.Get,\"a,b.go\",\"say \"\"hi\"\"\",\"x\ty\nz\""
+        );
+        assert_eq!(
+            line(TableFormat::Tsv, fields.into_iter()),
+            "method\tCache.Get\ta,b.go\tsay \"hi\"\tx y z"
+        );
+    }
+}
@@ -0,0 +1,20 @@
+//! Fixtures shared by the unit tests.
+
+use crate::db::Database;
+
+/// An in-memory index of `files`, given as (path, text) pairs. They are written
+/// under a scratch directory named after `name`, which must be unique among
+/// the tests, and removed again once indexed.
+pub fn index_fixture(name: &str, files: &[(&str, &str)]) -> Database {
+    let dir = std::env::temp_dir().join(format!("cartog-{name}-{}", std::process::id()));
+    let _ = std::fs::remove_dir_all(&dir);
+    for (path, text) in files {
+        let path = dir.join(path);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, text).unwrap();
+    }
+    let db = Database::open_memory().unwrap();
+    crate::indexer::index_directory(&db, &dir, true).unwrap();
+    let _ = std::fs::remove_dir_all(&dir);
+    db
+}
@@ -0,0 +1,327 @@
+//! `cartog tests-for`: the Go tests most likely to exercise a symbol.
+//!
+//! Three signals add up to a test's score:
+//!
+//! - calls: the test reaches the symbol through resolved calls, including those
+//!   made in `t.Run` closures and through test helpers. Direct calls count most.
+//! - name: `TestCharge`, `TestChargeDeclined` and `TestServer_Charge` are named
+//!   after `Charge`.
+//! - place: the test sits in the symbol's package, more so in the `_test.go`
+//!   file named after the symbol's file.
+//!
+//! A test with nothing but the package in common is not listed. When a cover
+//! profile is loaded (`cartog coverage`) and shows the symbol never ran, every
+//! score is halved: the tests found are then likely not exercising it after all.
+
+use std::collections::{BTreeMap, HashMap, VecDeque};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{Coverage, Symbol};
+
+const DIRECT_CALL: f64 = 0.6;
+const INDIRECT_CALL: f64 = 0.4;
+const DISTANT_CALL: f64 = 0.2;
+const NAMED_AFTER: f64 = 0.3;
+const SAME_FILE_PAIR: f64 = 0.1;
+const SAME_PACKAGE: f64 = 0.1;
+/// A score below this is the package alone.
+const MIN_SCORE: f64 = 0.2;
+const UNCOVERED_FACTOR: f64 = 0.5;
+
+/// A test and why it likely exercises the symbol.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct TestMatch {
+    pub symbol: Symbol,
+    /// 0 to 1; higher is more likely.
+    pub score: f64,
+    /// Calls between the test and the symbol, when it reaches it.
+    pub depth: Option<u32>,
+    /// Names from the test's first callee down to the symbol.
+    pub via: Vec<String>,
+    pub reasons: Vec<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct TestsFor {
+    pub definitions: Vec<Symbol>,
+    /// From the loaded cover profile, for the first definition that has some.
+    pub coverage: Option<Coverage>,
+    pub tests: Vec<TestMatch>,
+}
+
+/// Whether `name` is one `go test` runs as a test.
+pub fn is_test_name(name: &str) -> bool {
+    name.strip_prefix("Test")
+        .is_some_and(|rest| !rest.starts_with(|c: char| c.is_lowercase()))
+}
+
+/// The tests of `name`, best first, following calls up to `depth` deep.
+pub fn tests_for(db: &Database, name: &str, depth: u32) -> Result<TestsFor> {
+    let definitions = db.find_definitions(name)?;
+    let tests: HashMap<String, Symbol> = db
+        .go_tests()?
+        .into_iter()
+        .filter(|s| is_test_name(&s.name))
+        .map(|s| (s.id.clone(), s))
+        .collect();
+
+    let mut found: BTreeMap<String, TestMatch> = BTreeMap::new();
+    for definition in &definitions {
+        for (test, d, via) in reaching(db, definition, depth, &tests)? {
+            let (score, reason) = match d {
+                1 => (DIRECT_CALL, "calls it directly".to_string()),
+                2 => (INDIRECT_CALL, format!("calls it through {}", via[0])),
+                _ => (DISTANT_CALL, format!("reaches it in {d} calls")),
+            };
+            found.entry(test.id.clone()).or_insert(TestMatch {
+                symbol: test.clone(),
+                score,
+                depth: Some(d),
+                via,
+                reasons: vec![reason],
+            });
+        }
+        for test in tests.values() {
+            let mut score = 0.0;
+            let mut reasons = Vec::new();
+            if named_after(&test.name, &definition.name) {
+                score += NAMED_AFTER;
+                reasons.push(format!("named after {}", definition.name));
+            }
+            if package_of(&test.file_path) == package_of(&definition.file_path) {
+                score += SAME_PACKAGE;
+                if test.file_path.strip_suffix("_test.go")
+                    == definition.file_path.strip_suffix(".go")
+                {
+                    score += SAME_FILE_PAIR;
+                    reasons.push(format!("in {}", test.file_path));
+                } else {
+                    reasons.push("same package".to_string());
+                }
+            }
+            match found.get_mut(&test.id) {
+                Some(m) => {
+                    m.score += score;
+                    m.reasons.extend(reasons);
+                }
+                None if score >= MIN_SCORE => {
+                    found.insert(
+                        test.id.clone(),
+                        TestMatch {
+                            symbol: test.clone(),
+                            score,
+                            depth: None,
+                            via: Vec::new(),
+                            reasons,
+                        },
+                    );
+                }
+                None => {}
+            }
+        }
+    }
+
+    let ids: Vec<String> = definitions.iter().map(|d| d.id.clone()).collect();
+    let covered = db.coverage_of(&ids)?;
+    let coverage = ids.iter().find_map(|id| covered.get(id).copied());
+    let never_ran = coverage.as_ref().is_some_and(|c| c.covered == 0);
+
+    let mut tests: Vec<TestMatch> = found.into_values().collect();
+    for test in &mut tests {
+        if never_ran {
+            test.score *= UNCOVERED_FACTOR;
+        }
+        test.score = (test.score.min(1.0) * 100.0).round() / 100.0;
+    }
+    tests.sort_by(|a, b| {
+        b.score.total_cmp(&a.score).then_with(|| {
+            (&a.symbol.file_path, a.symbol.start_line)
+                .cmp(&(&b.symbol.file_path, b.symbol.start_line))
+        })
+    });
+    Ok(TestsFor {
+        definitions,
+        coverage,
+        tests,
+    })
+}
+
+/// Tests calling `definition` within `depth` calls, each with its shortest path.
+fn reaching(
+    db: &Database,
+    definition: &Symbol,
+    depth: u32,
+    tests: &HashMap<String, Symbol>,
+) -> Result<Vec<(Symbol, u32, Vec<String>)>> {
+    let mut found = Vec::new();
+    let mut paths: HashMap<String, Vec<String>> =
+        HashMap::from([(definition.id.clone(), vec![definition.name.clone()])]);
+    let mut queue = VecDeque::from([(definition.id.clone(), 0)]);
+    while let Some((id, d)) = queue.pop_front() {
+        if d >= depth {
+            continue;
+        }
+        let via = paths[&id].clone();
+        for (caller, _) in db.callers(&id)? {
+            if paths.contains_key(&caller.id) {
+                continue;
+            }
+            let mut path = vec![caller.name.clone()];
+            path.extend(via.iter().cloned());
+            paths.insert(caller.id.clone(), path);
+            if let Some(test) = tests.get(&caller.id) {
+                found.push((test.clone(), d + 1, via.clone()));
+            }
+            queue.push_back((caller.id, d + 1));
+        }
+    }
+    Ok(found)
+}
+
+/// `TestCharge`, `TestChargeDeclined` or `TestServer_Charge` for `Charge`, and
+/// `TestParse` for the unexported `parse`.
+fn named_after(test: &str, name: &str) -> bool {
+    let mut chars = name.chars();
+    let Some(first) = chars.next() else {
+        return false;
+    };
+    let exported: String = first.to_uppercase().chain(chars).collect();
+    test.strip_prefix("Test")
+        .unwrap_or(test)
+        .split('_')
+        .any(|part| {
+            part.strip_prefix(exported.as_str())
+                .is_some_and(|rest| !rest.starts_with(|c: char| c.is_lowercase()))
+        })
+}
+
+fn package_of(path: &str) -> &str {
+    path.rsplit_once('/').map_or("", |(dir, _)| dir)
+}
+
+/// `go test` invocations running exactly `tests`, one per package directory.
+pub fn run_commands(tests: &[&Symbol]) -> Vec<String> {
+    let mut by_package: BTreeMap<&str, Vec<&str>> = BTreeMap::new();
+    for symbol in tests {
+        let names = by_package.entry(package_of(&symbol.file_path)).or_default();
+        if !names.contains(&symbol.name.as_str()) {
+            names.push(&symbol.name);
+        }
+    }
+    by_package
+        .into_iter()
+        .map(|(dir, names)| {
+            let package = if dir.is_empty() {
+                ".".to_string()
+            } else {
+                format!("./{dir}")
+            };
+            format!("go test -run '^({})$' {package}", names.join("|"))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind, SymbolKind};
+
+    #[test]
+    fn test_tests_ranked_by_calls_name_and_place() {
+        let db = Database::open_memory().unwrap();
+        let func = |name: &str, file: &str, line: u32, signature: &str| {
+            Symbol::new(name, SymbolKind::Function, file, line, line + 5, 0, 100)
+                .with_signature(Some(signature.to_string()))
+        };
+        let charge = func("Charge", "billing/charge.go", 3, "(amount int) error");
+        let checkout = func("Checkout", "billing/checkout.go", 3, "() error");
+        let direct = func("TestCharge", "billing/charge_test.go", 5, "(t *testing.T)");
+        let indirect = func(
+            "TestCheckout",
+            "billing/checkout_test.go",
+            5,
+            "(t *testing.T)",
+        );
+        let by_name = func(
+            "TestChargeRefund",
+            "billing/refund_test.go",
+            5,
+            "(t *testing.T)",
+        );
+        let unrelated = func(
+            "TestInvoice",
+            "billing/invoice_test.go",
+            5,
+            "(t *testing.T)",
+        );
+        let elsewhere = func("TestLogin", "auth/login_test.go", 5, "(t *testing.T)");
+        db.insert_symbols(&[
+            charge,
+            checkout.clone(),
+            direct.clone(),
+            indirect.clone(),
+            by_name,
+            unrelated,
+            elsewhere,
+        ])
+        .unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &direct.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/charge_test.go",
+                7,
+            ),
+            Edge::new(
+                &checkout.id,
+                "Charge",
+                EdgeKind::Calls,
+                "billing/checkout.go",
+                5,
+            ),
+            Edge::new(
+                &indirect.id,
+                "Checkout",
+                EdgeKind::Calls,
+                "billing/checkout_test.go",
+                7,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+
+        let found = tests_for(&db, "Charge", 3).unwrap();
+        let ranked: Vec<_> = found
+            .tests
+            .iter()
+            .map(|t| (t.symbol.name.as_str(), t.score))
+            .collect();
+        assert_eq!(
+            ranked,
+            [
+                ("TestCharge", 1.0),
+                ("TestCheckout", 0.5),
+                ("TestChargeRefund", 0.4),
+            ]
+        );
+        assert_eq!(found.tests[1].via, ["Checkout", "Charge"]);
+        assert_eq!(
+            run_commands(&found.tests.iter().map(|t| &t.symbol).collect::<Vec<_>>()),
+            ["go test -run '^(TestCharge|TestCheckout|TestChargeRefund)$' ./billing"]
+        );
+    }
+
+    #[test]
+    fn test_named_after() {
+        assert!(named_after("TestCharge", "Charge"));
+        assert!(named_after("TestCharge_declined", "Charge"));
+        assert!(named_after("TestServer_Charge", "Charge"));
+        assert!(named_after("TestParse", "parse"));
+        assert!(!named_after("TestChargeback", "Charge"));
+        assert!(!named_after("TestRecharge", "Charge"));
+    }
+}
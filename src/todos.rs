@@ -0,0 +1,157 @@
+//! Tech-debt inventory: TODO, FIXME, HACK and XXX comments with the symbol they
+//! sit in, their age and their owner.
+//!
+//! Comments are recorded at index time (see `languages::todos`). Age and owner come
+//! from `git blame` of the comment's line at query time, so they follow the working
+//! tree without re-indexing; a `TODO(name)` assignee takes precedence as owner.
+
+use std::cmp::Reverse;
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::git::BlameInfo;
+use crate::history::BlameCache;
+use crate::types::{Symbol, Todo};
+
+/// Which comments to list. Empty fields match everything.
+#[derive(Debug, Clone, Default)]
+pub struct TodoFilter {
+    /// `TODO`, `FIXME`, `HACK` or `XXX`, ignoring case.
+    pub marker: Option<String>,
+    /// Part of the assignee, or of the blamed author's name or email, ignoring case.
+    pub owner: Option<String>,
+    /// Only comments last changed at least this many days ago.
+    pub older_than: Option<u32>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct TodoItem {
+    pub file: String,
+    /// The innermost symbol around the comment; `None` at file level.
+    pub symbol: Option<Symbol>,
+    #[serde(flatten)]
+    pub todo: Todo,
+    /// The assignee, else the author of the line's last change.
+    pub owner: Option<String>,
+    pub age_days: Option<u32>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub blame: Option<BlameInfo>,
+}
+
+/// Marker comments matching `filter`, oldest first, then by file and line. Without
+/// `blame`, git is not run: ages are unknown and owners come from assignees only.
+pub fn inventory(
+    db: &Database,
+    root: &Path,
+    filter: &TodoFilter,
+    blame: bool,
+    now: i64,
+) -> Result<Vec<TodoItem>> {
+    let mut cache = blame.then(|| BlameCache::new(root));
+    let mut items = Vec::new();
+    for (file, symbol, todo) in db.todos()? {
+        if filter
+            .marker
+            .as_ref()
+            .is_some_and(|m| !m.eq_ignore_ascii_case(&todo.marker))
+        {
+            continue;
+        }
+        let blame = cache
+            .as_mut()
+            .and_then(|c| c.last_change(&file, todo.line, todo.line));
+        let age_days = blame.as_ref().map(|b| b.age_days(now));
+        if filter
+            .older_than
+            .is_some_and(|days| age_days.map_or(true, |age| age < days))
+        {
+            continue;
+        }
+        if let Some(owner) = &filter.owner {
+            let owner = owner.to_lowercase();
+            let candidates = [
+                todo.assignee.as_deref(),
+                blame.as_ref().map(|b| b.author.as_str()),
+                blame.as_ref().map(|b| b.email.as_str()),
+            ];
+            if !candidates
+                .into_iter()
+                .flatten()
+                .any(|c| c.to_lowercase().contains(&owner))
+            {
+                continue;
+            }
+        }
+        items.push(TodoItem {
+            file,
+            symbol,
+            owner: todo
+                .assignee
+                .clone()
+                .or_else(|| blame.as_ref().map(|b| b.author.clone())),
+            todo,
+            age_days,
+            blame,
+        });
+    }
+    // Stable, so equally old (or unblamed) comments stay in file and line order.
+    items.sort_by_key(|item| Reverse(item.age_days));
+    Ok(items)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::SymbolKind;
+
+    #[test]
+    fn test_inventory_filters_without_blame() {
+        let db = Database::open_memory().unwrap();
+        let charge = Symbol::new("Charge", SymbolKind::Function, "pay.go", 3, 9, 0, 90);
+        db.insert_symbols(&[charge.clone()]).unwrap();
+        let todo = |line, marker: &str, assignee: Option<&str>, symbol_id: Option<&str>| Todo {
+            symbol_id: symbol_id.map(str::to_string),
+            line,
+            marker: marker.to_string(),
+            assignee: assignee.map(str::to_string),
+            text: "x".to_string(),
+        };
+        db.insert_todos(
+            "pay.go",
+            &[
+                todo(1, "TODO", Some("alice"), None),
+                todo(5, "FIXME", None, Some(&charge.id)),
+            ],
+        )
+        .unwrap();
+
+        let root = Path::new(".");
+        let all = inventory(&db, root, &TodoFilter::default(), false, 0).unwrap();
+        let found: Vec<_> = all
+            .iter()
+            .map(|i| (i.todo.line, i.symbol.as_ref().map(|s| s.name.as_str())))
+            .collect();
+        assert_eq!(found, [(1, None), (5, Some("Charge"))]);
+        assert_eq!(all[0].owner.as_deref(), Some("alice"));
+
+        let filter = TodoFilter {
+            marker: Some("fixme".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(inventory(&db, root, &filter, false, 0).unwrap().len(), 1);
+        let filter = TodoFilter {
+            owner: Some("ALI".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(inventory(&db, root, &filter, false, 0).unwrap().len(), 1);
+        // Without blame no age is known, so nothing is old enough.
+        let filter = TodoFilter {
+            older_than: Some(30),
+            ..Default::default()
+        };
+        assert!(inventory(&db, root, &filter, false, 0).unwrap().is_empty());
+    }
+}
@@ -0,0 +1,335 @@
+//! Onboarding tour: an ordered reading list of entry points, core services and
+//! data models with short source excerpts, cut to a token budget.
+//!
+//! Entry points are `main` functions and resolved HTTP route handlers. Services
+//! and models are the types ranked by references from other files (see
+//! `doc::architecture`), split by whether they have methods; Go methods count
+//! towards their receiver type, matched by name within the package. Stops are
+//! picked from the three sections in turn, so a small budget still covers each
+//! of them, and skipped when their rendering would overrun the budget. Tokens
+//! are estimated at four bytes each.
+
+use std::collections::{HashMap, HashSet};
+use std::fmt::Write as _;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::doc::{self, package_of};
+use crate::types::{Symbol, SymbolKind};
+
+/// Source lines shown per stop.
+pub const EXCERPT_LINES: usize = 12;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Section {
+    EntryPoints,
+    Services,
+    Models,
+}
+
+impl Section {
+    pub fn title(self) -> &'static str {
+        match self {
+            Self::EntryPoints => "Entry points",
+            Self::Services => "Core services",
+            Self::Models => "Data models",
+        }
+    }
+}
+
+/// One symbol to read.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Stop {
+    pub section: Section,
+    pub symbol: Symbol,
+    /// Why it is on the tour.
+    pub reason: String,
+    /// The first `EXCERPT_LINES` lines of its source, or its signature.
+    pub excerpt: String,
+    /// Estimated tokens of the rendered stop.
+    pub tokens: u32,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Tour {
+    pub budget: u32,
+    /// Estimated tokens of the stops taken.
+    pub tokens: u32,
+    /// Stops by section, then rank.
+    pub stops: Vec<Stop>,
+    /// Candidates left out for the budget.
+    pub skipped: u32,
+}
+
+fn estimate_tokens(text: &str) -> u32 {
+    (text.len() as u32 + 3) / 4
+}
+
+/// The first `EXCERPT_LINES` lines of `content`, marking a cut.
+fn excerpt(content: &str) -> String {
+    let mut lines: Vec<&str> = content.lines().take(EXCERPT_LINES + 1).collect();
+    if lines.len() > EXCERPT_LINES {
+        lines.truncate(EXCERPT_LINES);
+        lines.push("…");
+    }
+    lines.join("\n")
+}
+
+/// Candidate stops per section, best first.
+fn candidates(db: &Database) -> Result<Vec<(Section, Symbol, String)>> {
+    let symbols = db.all_symbols()?;
+    let mut found = Vec::new();
+    let mut seen = HashSet::new();
+
+    for s in symbols
+        .iter()
+        .filter(|s| s.kind == SymbolKind::Function && s.name == "main")
+    {
+        seen.insert(s.id.clone());
+        found.push((
+            Section::EntryPoints,
+            s.clone(),
+            "program entry point".to_string(),
+        ));
+    }
+    for (route, _, handler) in db.routes(None)? {
+        if let Some(handler) = handler {
+            if seen.insert(handler.id.clone()) {
+                let reason = format!("handles `{} {}`", route.method, route.path);
+                found.push((Section::EntryPoints, handler, reason));
+            }
+        }
+    }
+
+    // Methods per type: by parent id, or for Go receivers (`file:Type`) by name
+    // within the package.
+    let ids: HashSet<&str> = symbols.iter().map(|s| s.id.as_str()).collect();
+    let mut methods: HashMap<String, u32> = HashMap::new();
+    for s in symbols.iter().filter(|s| s.kind == SymbolKind::Method) {
+        let Some(parent) = s.parent_id.as_deref() else {
+            continue;
+        };
+        let key = match parent.rsplit_once(':') {
+            Some((file, name)) if !ids.contains(parent) => {
+                format!("{}:{name}", package_of(file, 0))
+            }
+            _ => parent.to_string(),
+        };
+        *methods.entry(key).or_default() += 1;
+    }
+    let edges = db.edges_with_endpoints()?;
+    for t in doc::rank_types(&symbols, &edges, usize::MAX) {
+        let by_receiver = format!("{}:{}", package_of(&t.symbol.file_path, 0), t.symbol.name);
+        let count = methods.get(&t.symbol.id).or(methods.get(&by_receiver));
+        let (section, reason) = match count {
+            Some(n) => (
+                Section::Services,
+                format!("{} references from other files, {n} methods", t.dependents),
+            ),
+            None => (
+                Section::Models,
+                format!("{} references from other files", t.dependents),
+            ),
+        };
+        found.push((section, t.symbol, reason));
+    }
+    Ok(found)
+}
+
+/// Pick stops within `budget` tokens.
+pub fn plan(db: &Database, budget: u32) -> Result<Tour> {
+    let mut queues: [Vec<(Symbol, String)>; 3] = Default::default();
+    for (section, symbol, reason) in candidates(db)? {
+        queues[section as usize].push((symbol, reason));
+    }
+    let sections = [Section::EntryPoints, Section::Services, Section::Models];
+    let ids: Vec<String> = queues.iter().flatten().map(|(s, _)| s.id.clone()).collect();
+    let mut contents = db.get_symbol_contents_batch(&ids)?;
+
+    let mut stops: Vec<(usize, Stop)> = Vec::new();
+    let mut tokens = 0;
+    let mut skipped = 0;
+    let mut next = [0; 3];
+    // One candidate from each section in turn, until all are considered.
+    while next.iter().zip(&queues).any(|(i, q)| *i < q.len()) {
+        for (i, section) in sections.iter().enumerate() {
+            let Some((symbol, reason)) = queues[i].get(next[i]) else {
+                continue;
+            };
+            let rank = next[i];
+            next[i] += 1;
+            let excerpt = match contents.remove(&symbol.id) {
+                Some((content, _)) => excerpt(&content),
+                None => format!(
+                    "{}{}",
+                    symbol.name,
+                    symbol.signature.as_deref().unwrap_or("")
+                ),
+            };
+            let mut stop = Stop {
+                section: *section,
+                symbol: symbol.clone(),
+                reason: reason.clone(),
+                excerpt,
+                tokens: 0,
+            };
+            stop.tokens = estimate_tokens(&render_stop(&stop));
+            if tokens + stop.tokens > budget {
+                skipped += 1;
+                continue;
+            }
+            tokens += stop.tokens;
+            stops.push((rank, stop));
+        }
+    }
+    stops.sort_by_key(|(rank, stop)| (stop.section, *rank));
+    Ok(Tour {
+        budget,
+        tokens,
+        stops: stops.into_iter().map(|(_, stop)| stop).collect(),
+        skipped,
+    })
+}
+
+fn render_stop(stop: &Stop) -> String {
+    let s = &stop.symbol;
+    let mut out = format!(
+        "### `{}` ({}:{})\n\n{}: {}.\n",
+        s.name, s.file_path, s.start_line, s.kind, stop.reason
+    );
+    if let Some(doc) = s.docstring.as_deref().and_then(|d| d.lines().next()) {
+        let _ = writeln!(out, "\n> {}", doc.trim());
+    }
+    let language = s.file_path.rsplit_once('.').map_or("", |(_, ext)| ext);
+    let _ = write!(out, "\n```{language}\n{}\n```\n", stop.excerpt);
+    out
+}
+
+/// The tour as a Markdown document.
+pub fn render_markdown(tour: &Tour) -> String {
+    let mut out = String::from("# Codebase tour\n\n");
+    out.push_str("<!-- Generated by `cartog tour`; regenerate instead of editing. -->\n\n");
+    let _ = writeln!(
+        out,
+        "Read in order: {} stops, about {} tokens.",
+        tour.stops.len(),
+        tour.tokens
+    );
+    let mut section = None;
+    for stop in &tour.stops {
+        if section != Some(stop.section) {
+            section = Some(stop.section);
+            let _ = write!(out, "\n## {}\n", stop.section.title());
+        }
+        out.push('\n');
+        out.push_str(&render_stop(stop));
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{Edge, EdgeKind};
+
+    #[test]
+    fn test_tour_sections_and_budget() {
+        let db = Database::open_memory().unwrap();
+        let main = Symbol::new(
+            "main",
+            SymbolKind::Function,
+            "cmd/server/main.go",
+            5,
+            25,
+            0,
+            400,
+        );
+        let store = Symbol::new(
+            "Store",
+            SymbolKind::Class,
+            "internal/store/store.go",
+            3,
+            6,
+            0,
+            60,
+        )
+        .with_docstring(Some("Store persists users.".to_string()));
+        // A Go method in another file, linked to Store by its receiver.
+        let find = Symbol::new(
+            "Find",
+            SymbolKind::Method,
+            "internal/store/find.go",
+            3,
+            9,
+            0,
+            90,
+        )
+        .with_parent(Some("internal/store/find.go:Store"));
+        let user = Symbol::new(
+            "User",
+            SymbolKind::Class,
+            "internal/store/user.go",
+            3,
+            8,
+            0,
+            80,
+        );
+        db.insert_symbols(&[main.clone(), store.clone(), find.clone(), user.clone()])
+            .unwrap();
+        db.insert_edges(&[
+            Edge::new(
+                &main.id,
+                "Store",
+                EdgeKind::References,
+                "cmd/server/main.go",
+                7,
+            ),
+            Edge::new(
+                &find.id,
+                "User",
+                EdgeKind::References,
+                "internal/store/find.go",
+                5,
+            ),
+        ])
+        .unwrap();
+        db.resolve_edges().unwrap();
+        let body: Vec<String> = (0..20).map(|i| format!("\tstep{i}()")).collect();
+        db.insert_symbol_contents(&[(
+            main.id.clone(),
+            format!("func main() {{\n{}\n}}", body.join("\n")),
+            String::new(),
+            "main".to_string(),
+        )])
+        .unwrap();
+
+        let tour = plan(&db, 10_000).unwrap();
+        let stops: Vec<_> = tour
+            .stops
+            .iter()
+            .map(|s| (s.section, s.symbol.name.as_str()))
+            .collect();
+        assert_eq!(
+            stops,
+            [
+                (Section::EntryPoints, "main"),
+                (Section::Services, "Store"),
+                (Section::Models, "User"),
+            ]
+        );
+        assert_eq!(tour.stops[0].excerpt.lines().count(), EXCERPT_LINES + 1);
+        assert!(tour.stops[0].excerpt.ends_with("\n…"));
+        assert_eq!(tour.stops[2].excerpt, "User");
+
+        let markdown = render_markdown(&tour);
+        assert!(markdown.contains("\n## Core services\n\n### `Store` (internal/store/store.go:3)\n\nclass: 1 references from other files, 1 methods.\n\n> Store persists users.\n"), "{markdown}");
+
+        let small = plan(&db, tour.stops[0].tokens).unwrap();
+        assert!(small.tokens <= tour.stops[0].tokens);
+        assert_eq!(small.stops[0].symbol.name, "main");
+        assert_eq!(small.stops.len() as u32 + small.skipped, 3);
+    }
+}
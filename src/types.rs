@@ -1,6 +1,6 @@
-use serde::Serialize;
+use serde::{Deserialize, Serialize};
 
-#[derive(Debug, Clone, PartialEq, Serialize)]
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub struct Symbol {
     pub id: String,
     pub name: String,
@@ -82,7 +82,7 @@ impl Symbol {
     }
 }
 
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
 #[serde(rename_all = "snake_case")]
 pub enum SymbolKind {
     Function,
@@ -90,6 +90,8 @@ pub enum SymbolKind {
     Method,
     Variable,
     Import,
+    /// A feature flag, at its first check in the file.
+    Flag,
 }
 
 impl SymbolKind {
@@ -100,6 +102,7 @@ impl SymbolKind {
             Self::Method => "method",
             Self::Variable => "variable",
             Self::Import => "import",
+            Self::Flag => "flag",
         }
     }
 }
@@ -114,6 +117,7 @@ impl std::str::FromStr for SymbolKind {
             "method" => Ok(Self::Method),
             "variable" => Ok(Self::Variable),
             "import" => Ok(Self::Import),
+            "flag" => Ok(Self::Flag),
             _ => Err(anyhow::anyhow!("unknown symbol kind: '{s}'")),
         }
     }
@@ -125,7 +129,7 @@ impl std::fmt::Display for SymbolKind {
     }
 }
 
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
 #[serde(rename_all = "snake_case")]
 pub enum Visibility {
     Public,
@@ -158,7 +162,559 @@ impl std::fmt::Display for Visibility {
     }
 }
 
-#[derive(Debug, Clone, PartialEq, Serialize)]
+/// Complexity of a function or method body.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, Serialize, Deserialize)]
+pub struct Complexity {
+    /// McCabe: one plus the number of branches.
+    pub cyclomatic: u32,
+    /// SonarSource: breaks in linear flow, weighted by nesting.
+    pub cognitive: u32,
+}
+
+/// Centrality of a symbol in the call and reference graph.
+#[derive(Debug, Clone, Copy, PartialEq, Default, Serialize, Deserialize)]
+pub struct Centrality {
+    /// PageRank, scaled so the average symbol is 1.0.
+    pub pagerank: f64,
+    /// Betweenness: the share of shortest paths between other symbols that pass through it.
+    pub betweenness: f64,
+}
+
+/// Statement coverage of a function or method, from a test coverage profile.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, Serialize, Deserialize)]
+pub struct Coverage {
+    /// Statements run at least once.
+    pub covered: u32,
+    pub total: u32,
+}
+
+impl Coverage {
+    /// Covered statements as a percentage; a body without statements counts as covered.
+    pub fn percent(&self) -> f64 {
+        if self.total == 0 {
+            return 100.0;
+        }
+        f64::from(self.covered) * 100.0 / f64::from(self.total)
+    }
+}
+
+/// What a caller does with an error coming out of a call.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ErrorHandling {
+    /// Returned or re-raised unchanged.
+    Propagates,
+    /// Returned inside another error that keeps it (`%w`, `map_err`, `raise ... from`).
+    Wraps,
+    /// A different error is returned or raised; the original is lost.
+    Replaces,
+    /// Ignored, or handled without failing.
+    Swallows,
+}
+
+impl ErrorHandling {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Propagates => "propagates",
+            Self::Wraps => "wraps",
+            Self::Replaces => "replaces",
+            Self::Swallows => "swallows",
+        }
+    }
+
+    /// Whether a failure still leaves the caller.
+    pub fn escapes(self) -> bool {
+        self != Self::Swallows
+    }
+}
+
+impl std::str::FromStr for ErrorHandling {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "propagates" => Ok(Self::Propagates),
+            "wraps" => Ok(Self::Wraps),
+            "replaces" => Ok(Self::Replaces),
+            "swallows" => Ok(Self::Swallows),
+            _ => Err(anyhow::anyhow!("unknown error handling: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for ErrorHandling {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// How the function `source_id` handles errors from the call at `line` to `target_name`.
+/// Keys match the call's [`Edge`].
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ErrorFlow {
+    pub source_id: String,
+    pub target_name: String,
+    pub line: u32,
+    pub handling: ErrorHandling,
+}
+
+/// Whether a panic site raises a panic or stops one.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum PanicKind {
+    /// `panic(...)`, `panic!`, `unwrap()` and the like.
+    Panic,
+    /// A deferred `recover()` or `catch_unwind`: panics below it stop there.
+    Recover,
+}
+
+impl PanicKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Panic => "panic",
+            Self::Recover => "recover",
+        }
+    }
+}
+
+impl std::str::FromStr for PanicKind {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "panic" => Ok(Self::Panic),
+            "recover" => Ok(Self::Recover),
+            _ => Err(anyhow::anyhow!("unknown panic kind: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for PanicKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A panic or recover inside the function `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct PanicSite {
+    pub symbol_id: String,
+    pub line: u32,
+    pub kind: PanicKind,
+    /// The construct as written: `panic`, `unwrap`, `todo!`, `recover`...
+    pub what: String,
+}
+
+/// What a function does with a channel or sync primitive.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum SyncOp {
+    /// `make(chan T)`.
+    Make,
+    Send,
+    Receive,
+    Close,
+    Lock,
+    Unlock,
+    #[serde(rename = "rlock")]
+    RLock,
+    #[serde(rename = "runlock")]
+    RUnlock,
+    /// `WaitGroup.Add`.
+    Add,
+    /// `WaitGroup.Done`.
+    Done,
+    /// `WaitGroup.Wait`.
+    Wait,
+}
+
+impl SyncOp {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Make => "make",
+            Self::Send => "send",
+            Self::Receive => "receive",
+            Self::Close => "close",
+            Self::Lock => "lock",
+            Self::Unlock => "unlock",
+            Self::RLock => "rlock",
+            Self::RUnlock => "runlock",
+            Self::Add => "add",
+            Self::Done => "done",
+            Self::Wait => "wait",
+        }
+    }
+}
+
+impl std::str::FromStr for SyncOp {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "make" => Ok(Self::Make),
+            "send" => Ok(Self::Send),
+            "receive" => Ok(Self::Receive),
+            "close" => Ok(Self::Close),
+            "lock" => Ok(Self::Lock),
+            "unlock" => Ok(Self::Unlock),
+            "rlock" => Ok(Self::RLock),
+            "runlock" => Ok(Self::RUnlock),
+            "add" => Ok(Self::Add),
+            "done" => Ok(Self::Done),
+            "wait" => Ok(Self::Wait),
+            _ => Err(anyhow::anyhow!("unknown sync op: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for SyncOp {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A use of a channel, mutex or wait group inside the function `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SyncSite {
+    pub symbol_id: String,
+    pub line: u32,
+    pub op: SyncOp,
+    /// The channel or primitive as written (`jobs`, `s.mu`).
+    pub object: String,
+}
+
+/// How a call loses the caller's `context.Context`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ContextLoss {
+    /// A new root context: `context.Background()` or `context.TODO()`.
+    Fresh,
+    /// I/O through an API that takes no context (`http.Get`, `db.Query`).
+    Io,
+}
+
+impl ContextLoss {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Fresh => "fresh",
+            Self::Io => "io",
+        }
+    }
+}
+
+impl std::str::FromStr for ContextLoss {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "fresh" => Ok(Self::Fresh),
+            "io" => Ok(Self::Io),
+            _ => Err(anyhow::anyhow!("unknown context loss: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for ContextLoss {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A call inside the function `symbol_id` that runs without the caller's context.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ContextSite {
+    pub symbol_id: String,
+    pub line: u32,
+    pub loss: ContextLoss,
+    /// The callee as written (`context.Background`, `db.Query`).
+    pub callee: String,
+}
+
+/// A read or write of a variable declared outside the function `symbol_id`.
+///
+/// Recorded by name: whether it names a package-level variable is settled at
+/// query time, when every file of the package is indexed.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct VariableAccess {
+    pub symbol_id: String,
+    pub line: u32,
+    pub name: String,
+    /// Package alias of a qualified access (`config` in `config.Debug`).
+    pub qualifier: Option<String>,
+    /// Assigned, incremented or had its address taken (directly or through a
+    /// field or index).
+    pub write: bool,
+}
+
+/// An HTTP route registered inside the function `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Route {
+    pub symbol_id: String,
+    pub line: u32,
+    /// `GET`, `POST,PUT` for several, `ANY` when the route takes every method.
+    pub method: String,
+    /// Full path, with the prefixes of enclosing groups and subrouters.
+    pub path: String,
+    /// The handler as written (`h.GetUser`); `None` for an inline function.
+    pub handler: Option<String>,
+    /// Middleware in the order it runs: router-wide, group, then route.
+    pub middleware: Vec<String>,
+}
+
+/// A field of the configuration struct `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct ConfigField {
+    pub symbol_id: String,
+    pub name: String,
+    pub line: u32,
+    /// Key named by its `yaml`/`toml`/`mapstructure`/`json`/`env` tag, if any.
+    pub key: Option<String>,
+}
+
+/// A field access `receiver.field`, or a `Type{Field: ...}` key, inside the function
+/// `symbol_id`.
+///
+/// Recorded without the receiver's type: which struct it belongs to is settled at
+/// query time, by field name.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct FieldUse {
+    pub symbol_id: String,
+    pub line: u32,
+    pub field: String,
+    /// The operand as written (`cfg`, `s.config`), or the literal's type.
+    pub receiver: String,
+    /// Assigned, incremented, had its address taken or set in a literal.
+    pub write: bool,
+}
+
+/// Severity of a log statement, least severe first.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum LogLevel {
+    Trace,
+    Debug,
+    /// Also plain `Print`/`console.log` output.
+    Info,
+    Warn,
+    Error,
+    /// Logs then exits (`log.Fatal`, `critical`).
+    Fatal,
+    /// Logs then panics (`log.Panic`, zap `DPanic`).
+    Panic,
+}
+
+impl LogLevel {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Trace => "trace",
+            Self::Debug => "debug",
+            Self::Info => "info",
+            Self::Warn => "warn",
+            Self::Error => "error",
+            Self::Fatal => "fatal",
+            Self::Panic => "panic",
+        }
+    }
+}
+
+impl std::str::FromStr for LogLevel {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "trace" => Ok(Self::Trace),
+            "debug" => Ok(Self::Debug),
+            "info" => Ok(Self::Info),
+            "warn" => Ok(Self::Warn),
+            "error" => Ok(Self::Error),
+            "fatal" => Ok(Self::Fatal),
+            "panic" => Ok(Self::Panic),
+            _ => Err(anyhow::anyhow!("unknown log level: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for LogLevel {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A logger call inside the symbol `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct LogStatement {
+    pub symbol_id: String,
+    pub line: u32,
+    pub level: LogLevel,
+    /// The component the logger is named for, when the call names one
+    /// (`Named("billing")`, a `"component"` field, Rust's `target:`).
+    pub component: Option<String>,
+    /// The message as written, placeholders included (`retry %d of %s`).
+    pub template: String,
+}
+
+/// A Go constant and the value its declaration works out to.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Constant {
+    pub symbol_id: String,
+    pub line: u32,
+    /// The declared type, or the one implied by a conversion (`Level(3)`), a typed
+    /// constant it names, or an earlier spec of its block that it repeats.
+    pub type_name: Option<String>,
+    /// `2`, `1.5`, `"text"` or `true`; `None` when it depends on something the
+    /// file does not define (another package, `unsafe.Sizeof`).
+    pub value: Option<String>,
+    /// The initializer as written; for a spec that repeats the one before, the
+    /// repeated expression (`iota`).
+    pub expr: String,
+    /// Position of its spec in the `const` block: the value of `iota`.
+    pub iota: u32,
+}
+
+/// A Go type assertion or type switch case in the function `symbol_id`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TypeAssertion {
+    pub symbol_id: String,
+    pub line: u32,
+    /// The declared type of the asserted value, when the function shows it.
+    pub interface: Option<String>,
+    /// The type asserted to, without pointer or package qualifier.
+    pub type_name: String,
+    /// A case of a type switch rather than an `x.(T)` expression.
+    pub type_switch: bool,
+}
+
+/// A place the parser could not make sense of. Extraction goes on around it, so
+/// the file's symbols and edges may be incomplete.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SyntaxError {
+    pub line: u32,
+    /// `unexpected `...`` for text the grammar rejects, `missing `)``
+    /// for a token the parser had to assume.
+    pub message: String,
+}
+
+/// A generated Go mock or fake: the struct `symbol_id` fakes `interface`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TestDouble {
+    pub symbol_id: String,
+    /// The faked interface, without package qualifier.
+    pub interface: String,
+    /// `gomock`, `mockery` or `counterfeiter`.
+    pub generator: String,
+}
+
+/// A value of the named type `type_name` created in `symbol_id`: a function,
+/// method or package-level variable (Go).
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Construction {
+    pub symbol_id: String,
+    pub line: u32,
+    /// The type created, without pointer, package qualifier or type arguments.
+    pub type_name: String,
+    pub kind: ConstructionKind,
+}
+
+/// How a value is created.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum ConstructionKind {
+    /// `User{...}`, or `{...}` inside a `[]User` literal.
+    Literal,
+    /// `&User{...}`, or `{...}` inside a `[]*User` literal.
+    Pointer,
+    /// `new(User)`.
+    New,
+}
+
+impl ConstructionKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Literal => "literal",
+            Self::Pointer => "pointer",
+            Self::New => "new",
+        }
+    }
+}
+
+impl std::str::FromStr for ConstructionKind {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        match s {
+            "literal" => Ok(Self::Literal),
+            "pointer" => Ok(Self::Pointer),
+            "new" => Ok(Self::New),
+            _ => Err(anyhow::anyhow!("unknown construction kind: '{s}'")),
+        }
+    }
+}
+
+impl std::fmt::Display for ConstructionKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// A field of the Go struct `symbol_id` with its tags.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct StructField {
+    pub symbol_id: String,
+    pub name: String,
+    pub line: u32,
+    /// `(tag, value)` pairs as written: `("json", "email,omitempty")`.
+    pub tags: Vec<(String, String)>,
+}
+
+impl StructField {
+    /// The key this field goes by in `format` (`json`, `yaml`, `xml`, `toml`,
+    /// `db`), or `None` when it is left out: unexported or tagged `-`. Untagged
+    /// fields keep their name, lowercased for `db` as sqlx does.
+    pub fn wire_key(&self, format: &str) -> Option<String> {
+        if !self.name.starts_with(|c: char| c.is_uppercase()) {
+            return None;
+        }
+        let tagged = self
+            .tags
+            .iter()
+            .find(|(tag, _)| tag == format)
+            .map(|(_, value)| value.split(',').next().unwrap_or(value));
+        match tagged {
+            Some("-") => None,
+            Some("") | None if format == "db" => Some(self.name.to_lowercase()),
+            Some("") | None => Some(self.name.clone()),
+            Some(key) => Some(key.to_string()),
+        }
+    }
+}
+
+/// A call inside the function `symbol_id` that encodes or decodes a value of the
+/// type `type_name`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Serialization {
+    pub symbol_id: String,
+    pub line: u32,
+    pub type_name: String,
+    /// `json`, `yaml`, `xml`, `toml` or `db`.
+    pub format: String,
+    /// Reads into the value (unmarshal, bind, scan) rather than writing it out.
+    pub decode: bool,
+}
+
+/// A TODO, FIXME, HACK or XXX comment.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Todo {
+    /// The innermost symbol around the comment; `None` at file level.
+    pub symbol_id: Option<String>,
+    pub line: u32,
+    pub marker: String,
+    /// The name in `TODO(name)`.
+    pub assignee: Option<String>,
+    /// The rest of the comment line.
+    pub text: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
 pub struct Edge {
     pub source_id: String,
     pub target_name: String,
@@ -188,7 +744,7 @@ impl Edge {
     }
 }
 
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
 #[serde(rename_all = "snake_case")]
 pub enum EdgeKind {
     Calls,
@@ -0,0 +1,222 @@
+//! `unsafe`, `reflect` and `//go:linkname` audit: where Go code steps outside the
+//! type system or links to another package's unexported symbols, and who calls it.
+//!
+//! Read from the index: calls through the `unsafe` and `reflect` packages, under
+//! whatever name the file imports them as (`unsafe.Pointer(p)`,
+//! `r.ValueOf(v).Elem()`), and functions whose doc comment carries a
+//! `//go:linkname` directive. Methods called on a `reflect.Value` held in a
+//! variable, and the packages' types in signatures, are not calls through the
+//! package and are not seen.
+
+use std::collections::{BTreeMap, HashMap, HashSet};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::logs::{self, CallStep};
+use crate::types::{EdgeKind, Symbol, SymbolKind};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum UnsafeKind {
+    Unsafe,
+    Reflect,
+    Linkname,
+}
+
+impl UnsafeKind {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Unsafe => "unsafe",
+            Self::Reflect => "reflect",
+            Self::Linkname => "linkname",
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct UnsafeUse {
+    pub kind: UnsafeKind,
+    /// The call as written up to its arguments (`unsafe.Pointer`), or the symbol a
+    /// `//go:linkname` links to (`runtime.nanotime`).
+    pub target: String,
+    pub line: u32,
+}
+
+/// A function with its uses, and the calls that lead to it.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct UnsafeFinding {
+    pub function: Symbol,
+    pub uses: Vec<UnsafeUse>,
+    pub callers: Vec<CallStep>,
+}
+
+/// Every function using `unsafe`, `reflect` or `//go:linkname`, each with its
+/// callers up to `depth` calls away. Ordered by file and line.
+pub fn audit(db: &Database, depth: u32) -> Result<Vec<UnsafeFinding>> {
+    let symbols: Vec<Symbol> = db
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| s.file_path.ends_with(".go"))
+        .collect();
+
+    // The name each file refers to the packages by.
+    let mut imported: HashMap<&str, Vec<(String, UnsafeKind)>> = HashMap::new();
+    for sym in symbols.iter().filter(|s| s.kind == SymbolKind::Import) {
+        let kind = match sym.name.as_str() {
+            "unsafe" => UnsafeKind::Unsafe,
+            "reflect" => UnsafeKind::Reflect,
+            _ => continue,
+        };
+        let local = import_alias(sym.signature.as_deref().unwrap_or(""))
+            .unwrap_or(sym.name.as_str())
+            .to_string();
+        if local != "_" && local != "." {
+            imported
+                .entry(sym.file_path.as_str())
+                .or_default()
+                .push((local, kind));
+        }
+    }
+
+    let mut uses: BTreeMap<&str, Vec<UnsafeUse>> = BTreeMap::new();
+    for sym in &symbols {
+        if let Some(target) = sym.docstring.as_deref().and_then(linkname_target) {
+            uses.entry(sym.id.as_str()).or_default().push(UnsafeUse {
+                kind: UnsafeKind::Linkname,
+                target,
+                line: sym.start_line,
+            });
+        }
+    }
+    let edges = db.all_edges()?;
+    for edge in edges.iter().filter(|e| e.kind == EdgeKind::Calls) {
+        let Some(packages) = imported.get(edge.file_path.as_str()) else {
+            continue;
+        };
+        let call = edge.target_name.split('(').next().unwrap_or("");
+        let Some((qualifier, _)) = call.split_once('.') else {
+            continue;
+        };
+        let Some((_, kind)) = packages.iter().find(|(local, _)| local == qualifier) else {
+            continue;
+        };
+        let found = uses.entry(edge.source_id.as_str()).or_default();
+        if !found
+            .iter()
+            .any(|u| u.line == edge.line && u.target == call)
+        {
+            found.push(UnsafeUse {
+                kind: *kind,
+                target: call.to_string(),
+                line: edge.line,
+            });
+        }
+    }
+
+    let by_id: HashMap<&str, &Symbol> = symbols.iter().map(|s| (s.id.as_str(), s)).collect();
+    let mut findings = Vec::new();
+    for (id, mut found) in uses {
+        let Some(function) = by_id.get(id) else {
+            continue;
+        };
+        found.sort_by_key(|u| u.line);
+        let mut seen = HashSet::from([id.to_string()]);
+        findings.push(UnsafeFinding {
+            function: (*function).clone(),
+            uses: found,
+            callers: logs::callers(db, id, depth, &mut seen)?,
+        });
+    }
+    findings.sort_by(|a, b| {
+        (&a.function.file_path, a.function.start_line)
+            .cmp(&(&b.function.file_path, b.function.start_line))
+    });
+    Ok(findings)
+}
+
+/// `r` for the import spec `r "reflect"`; `None` without an alias.
+fn import_alias(spec: &str) -> Option<&str> {
+    let spec = spec.trim();
+    if spec.starts_with('"') || spec.starts_with('`') {
+        return None;
+    }
+    spec.split_whitespace().next()
+}
+
+/// What a doc comment's `//go:linkname local [remote]` links to: the remote
+/// symbol, or the local name when it is exported for others to link.
+fn linkname_target(doc: &str) -> Option<String> {
+    let (_, rest) = doc.split_once("go:linkname ")?;
+    let mut words = rest.split_whitespace();
+    let local = words.next()?;
+    let target = words.next().filter(|w| w.contains('.')).unwrap_or(local);
+    Some(target.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::index_fixture;
+
+    #[test]
+    fn test_audit_finds_unsafe_reflect_and_linkname() {
+        let db = index_fixture(
+            "unsafe",
+            &[
+                (
+                    "conv/conv.go",
+                    "package conv\n\nimport (\n\t\"unsafe\"\n\tr \"reflect\"\n)\n\n//go:linkname nanotime runtime.nanotime\nfunc nanotime() int64\n\nfunc Bytes(s string) []byte {\n\treturn unsafe.Slice(unsafe.StringData(s), len(s))\n}\n\nfunc Kind(v any) string {\n\treturn r.ValueOf(v).Kind().String()\n}\n",
+                ),
+                (
+                    "api/api.go",
+                    "package api\n\nimport \"example.com/app/conv\"\n\nfunc Handle(s string) {\n\tconv.Bytes(s)\n}\n\n// reflect.ValueOf in a file without the import is not a use.\nfunc Other() {\n\treflect.ValueOf(1)\n}\n",
+                ),
+            ],
+        );
+        let findings = audit(&db, 2).unwrap();
+        let found: Vec<_> = findings
+            .iter()
+            .map(|f| {
+                let uses: Vec<_> = f
+                    .uses
+                    .iter()
+                    .map(|u| (u.kind.as_str(), u.target.as_str(), u.line))
+                    .collect();
+                (f.function.name.as_str(), uses)
+            })
+            .collect();
+        assert_eq!(
+            found,
+            [
+                ("nanotime", vec![("linkname", "runtime.nanotime", 9)]),
+                (
+                    "Bytes",
+                    vec![
+                        ("unsafe", "unsafe.Slice", 12),
+                        ("unsafe", "unsafe.StringData", 12)
+                    ]
+                ),
+                ("Kind", vec![("reflect", "r.ValueOf", 16)]),
+            ]
+        );
+        assert_eq!(findings[1].callers[0].caller.name, "Handle");
+    }
+
+    #[test]
+    fn test_linkname_target() {
+        assert_eq!(
+            linkname_target("go:linkname nanotime runtime.nanotime").as_deref(),
+            Some("runtime.nanotime")
+        );
+        assert_eq!(
+            linkname_target("Exported for the runtime. go:linkname fastrand used by others")
+                .as_deref(),
+            Some("fastrand")
+        );
+        assert_eq!(linkname_target("Nothing special."), None);
+        assert_eq!(import_alias("r \"reflect\""), Some("r"));
+        assert_eq!(import_alias("\"reflect\""), None);
+    }
+}
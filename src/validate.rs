@@ -0,0 +1,636 @@
+//! `cartog config validate`: checks every `.cartog.toml` under a project.
+//!
+//! Serde accepts unknown keys and most typos silently, and an ignore glob that
+//! matches nothing looks exactly like one that works. This module parses each
+//! file on its own, so one bad file does not hide problems in the others, and
+//! reports:
+//!
+//! - syntax and type errors, and keys the schema does not define,
+//! - settings that contradict each other (`enable` and `disable` of the same
+//!   language, a pass both skipped and kept by one rule),
+//! - globs that match no file, unknown languages, and root-only sections in
+//!   nested files.
+//!
+//! The schema for editors is `docs/cartog.schema.json` ([`SCHEMA`]).
+
+use std::collections::BTreeSet;
+use std::path::Path;
+
+use anyhow::Result;
+use serde::Serialize;
+use toml::{Table, Value};
+use walkdir::WalkDir;
+
+use crate::aliases;
+use crate::config::{
+    apply_overrides, discover, env_overrides, read_table, ConfigFile, ProjectConfig, CONFIG_FILE,
+};
+use crate::indexer::is_ignored_dirname;
+use crate::languages::get_extractor;
+use crate::plugins::PluginRegistry;
+
+/// JSON Schema of `.cartog.toml`, printed by `cartog config schema`.
+pub const SCHEMA: &str = include_str!("../docs/cartog.schema.json");
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Severity {
+    Error,
+    Warning,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Diagnostic {
+    pub severity: Severity,
+    /// Config file relative to the root, or the variable for environment overrides.
+    pub file: String,
+    /// Dotted key path, e.g. `hooks.on_index_complete[1].url`. Empty for the whole file.
+    pub key: String,
+    pub message: String,
+}
+
+#[derive(Debug, Serialize)]
+pub struct EffectiveFile {
+    pub dir: String,
+    pub config: ConfigFile,
+}
+
+#[derive(Debug, Serialize)]
+pub struct Report {
+    pub files: Vec<String>,
+    pub diagnostics: Vec<Diagnostic>,
+    /// Resolved configuration per file, root first. Empty when any file has errors.
+    pub effective: Vec<EffectiveFile>,
+}
+
+impl Report {
+    pub fn count(&self, severity: Severity) -> usize {
+        self.diagnostics
+            .iter()
+            .filter(|d| d.severity == severity)
+            .count()
+    }
+}
+
+/// Validate the user config and every config file under `root`, with `CARTOG_*`
+/// variables applied.
+pub fn validate(root: &Path) -> Result<Report> {
+    validate_with(root, user_config_path().as_deref(), std::env::vars())
+}
+
+pub fn validate_with(
+    root: &Path,
+    user: Option<&Path>,
+    vars: impl IntoIterator<Item = (String, String)>,
+) -> Result<Report> {
+    let mut diags = Vec::new();
+    let overrides = match env_overrides(vars) {
+        Ok(overrides) => overrides,
+        Err(e) => {
+            diags.push(Diagnostic {
+                severity: Severity::Error,
+                file: "environment".to_string(),
+                key: String::new(),
+                message: format!("{e:#}"),
+            });
+            Table::new()
+        }
+    };
+
+    let mut found = discover(root);
+    found.sort();
+    let files = walk_files(root);
+    let mut languages: BTreeSet<String> = BTreeSet::new();
+
+    // The user config first, checked on its own: `dir` is `None` for it.
+    let user = user.filter(|path| path.is_file());
+    let sources = user
+        .map(|path| (None, path.to_path_buf()))
+        .into_iter()
+        .chain(
+            found
+                .iter()
+                .map(|(dir, path)| (Some(dir.as_str()), path.clone())),
+        );
+
+    let mut parsed = Vec::new();
+    for (dir, path) in sources {
+        let name = dir.map_or_else(|| path.display().to_string(), display_path);
+        let mut table = match read_table(&path) {
+            Ok(table) => table,
+            Err(e) => {
+                diags.push(error(&name, "", e.root_cause().to_string()));
+                continue;
+            }
+        };
+        if dir == Some("") {
+            apply_overrides(&mut table, &overrides);
+        }
+        let file = match ConfigFile::from_table(table.clone(), &path) {
+            Ok(file) => file,
+            Err(e) => {
+                diags.push(error(&name, "", e.root_cause().to_string()));
+                continue;
+            }
+        };
+        if let Ok(known) = Table::try_from(&file) {
+            for key in unknown_keys(&table, &known, "") {
+                diags.push(error(&name, &key, "unknown key".to_string()));
+            }
+        }
+        if dir == Some("") {
+            languages.extend(
+                PluginRegistry::discover(&root.join(&file.plugins.dir))
+                    .map(|r| {
+                        r.plugins()
+                            .map(|p| p.manifest.language.clone())
+                            .collect::<Vec<_>>()
+                    })
+                    .unwrap_or_default(),
+            );
+        }
+        parsed.push((name, dir, file));
+    }
+
+    for (name, dir, file) in &parsed {
+        check_file(name, *dir, file, &files, &languages, &mut diags);
+    }
+
+    let has_errors = diags.iter().any(|d| d.severity == Severity::Error);
+    let effective = if has_errors {
+        Vec::new()
+    } else {
+        ProjectConfig::load_with(root, user, &overrides)?
+            .files()
+            .map(|(dir, config)| EffectiveFile {
+                dir: dir.to_string(),
+                config: config.clone(),
+            })
+            .collect()
+    };
+
+    Ok(Report {
+        files: parsed.iter().map(|(name, _, _)| name.clone()).collect(),
+        diagnostics: diags,
+        effective,
+    })
+}
+
+fn display_path(dir: &str) -> String {
+    if dir.is_empty() {
+        CONFIG_FILE.to_string()
+    } else {
+        format!("{dir}/{CONFIG_FILE}")
+    }
+}
+
+fn error(file: &str, key: &str, message: String) -> Diagnostic {
+    Diagnostic {
+        severity: Severity::Error,
+        file: file.to_string(),
+        key: key.to_string(),
+        message,
+    }
+}
+
+fn warning(file: &str, key: &str, message: String) -> Diagnostic {
+    Diagnostic {
+        severity: Severity::Warning,
+        ..error(file, key, message)
+    }
+}
+
+/// Keys of `raw` that did not survive deserializing into [`ConfigFile`] and back.
+fn unknown_keys(raw: &Table, known: &Table, prefix: &str) -> Vec<String> {
+    let mut unknown = Vec::new();
+    for (key, value) in raw {
+        let path = if prefix.is_empty() {
+            key.clone()
+        } else {
+            format!("{prefix}.{key}")
+        };
+        match (value, known.get(key)) {
+            (_, None) => unknown.push(path),
+            (Value::Table(raw), Some(Value::Table(known))) => {
+                unknown.extend(unknown_keys(raw, known, &path));
+            }
+            (Value::Array(raw), Some(Value::Array(known))) => {
+                for (i, (raw, known)) in raw.iter().zip(known).enumerate() {
+                    if let (Value::Table(raw), Value::Table(known)) = (raw, known) {
+                        unknown.extend(unknown_keys(raw, known, &format!("{path}[{i}]")));
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+    unknown
+}
+
+/// Files the indexer could see, relative to `root`.
+fn walk_files(root: &Path) -> Vec<String> {
+    WalkDir::new(root)
+        .follow_links(true)
+        .into_iter()
+        .filter_entry(|e| {
+            e.depth() == 0
+                || !e.file_type().is_dir()
+                || !is_ignored_dirname(&e.file_name().to_string_lossy())
+        })
+        .filter_map(|e| e.ok())
+        .filter(|e| e.file_type().is_file())
+        .filter_map(|e| {
+            e.path()
+                .strip_prefix(root)
+                .ok()
+                .map(|p| p.to_string_lossy().replace('\\', "/"))
+        })
+        .collect()
+}
+
+/// `dir` is the file's directory relative to the root, `None` for the user config.
+fn check_file(
+    name: &str,
+    dir: Option<&str>,
+    file: &ConfigFile,
+    files: &[String],
+    plugin_languages: &BTreeSet<String>,
+    diags: &mut Vec<Diagnostic>,
+) {
+    // The user config applies to every project, so its globs may match nothing here.
+    let local: Option<Vec<&str>> = dir.map(|dir| {
+        files
+            .iter()
+            .filter_map(|f| {
+                if dir.is_empty() {
+                    Some(f.as_str())
+                } else {
+                    f.strip_prefix(dir).and_then(|rest| rest.strip_prefix('/'))
+                }
+            })
+            .collect()
+    });
+
+    let check_glob = |key: String, pattern: &str, diags: &mut Vec<Diagnostic>| {
+        let glob = match globset::GlobBuilder::new(pattern)
+            .literal_separator(true)
+            .build()
+        {
+            Ok(glob) => glob.compile_matcher(),
+            Err(e) => {
+                diags.push(error(name, &key, format!("invalid glob '{pattern}': {e}")));
+                return;
+            }
+        };
+        let Some(local) = &local else { return };
+        if local.iter().any(|f| glob.is_match(f)) {
+            return;
+        }
+        let trimmed = pattern.trim_end_matches('/');
+        let is_dir = local
+            .iter()
+            .any(|f| f.strip_prefix(trimmed).is_some_and(|r| r.starts_with('/')));
+        let message = if is_dir {
+            format!("'{pattern}' matches no files; '{trimmed}' is a directory, use '{trimmed}/**'")
+        } else {
+            format!("'{pattern}' matches no files")
+        };
+        diags.push(warning(name, &key, message));
+    };
+
+    for (i, pattern) in file.index.ignore.iter().enumerate() {
+        check_glob(format!("index.ignore[{i}]"), pattern, diags);
+    }
+    for pattern in file.ranking.boost.keys() {
+        check_glob(format!("ranking.boost.\"{pattern}\""), pattern, diags);
+    }
+    for (i, rule) in file.extract.rules.iter().enumerate() {
+        for (j, pattern) in rule.paths.iter().enumerate() {
+            check_glob(format!("extract.rules[{i}].paths[{j}]"), pattern, diags);
+        }
+    }
+    for (label, rule) in &file.tags {
+        for (j, pattern) in rule.paths.iter().enumerate() {
+            check_glob(format!("tags.{label}.paths[{j}]"), pattern, diags);
+        }
+    }
+    for (i, rule) in file.arch.rules.iter().enumerate() {
+        for (j, pattern) in rule.from.iter().enumerate() {
+            check_glob(format!("arch.rules[{i}].from[{j}]"), pattern, diags);
+        }
+    }
+
+    let known_language =
+        |lang: &str| get_extractor(lang).is_some() || plugin_languages.contains(lang);
+    let langs = &file.languages;
+    for (key, list) in [
+        ("languages.enable", &langs.enable),
+        ("languages.disable", &langs.disable),
+    ] {
+        for lang in list.iter().filter(|l| !known_language(l)) {
+            diags.push(warning(name, key, format!("unknown language '{lang}'")));
+        }
+    }
+    for lang in langs.enable.iter().filter(|l| langs.disable.contains(l)) {
+        diags.push(error(
+            name,
+            "languages",
+            format!("'{lang}' is both enabled and disabled"),
+        ));
+    }
+
+    for (pattern, weight) in &file.ranking.boost {
+        if !weight.is_finite() || *weight <= 0.0 {
+            diags.push(error(
+                name,
+                &format!("ranking.boost.\"{pattern}\""),
+                format!("boost must be a positive number, got {weight}"),
+            ));
+        }
+    }
+
+    for (i, rule) in file.extract.rules.iter().enumerate() {
+        let key = format!("extract.rules[{i}]");
+        if rule.skip.is_empty() && rule.keep.is_empty() {
+            diags.push(warning(
+                name,
+                &key,
+                "rule neither skips nor keeps a pass".into(),
+            ));
+        }
+        for pass in rule.skip.iter().filter(|p| rule.keep.contains(p)) {
+            diags.push(error(
+                name,
+                &key,
+                format!("'{}' is both skipped and kept", pass.as_str()),
+            ));
+        }
+        for lang in rule.languages.iter().filter(|l| !known_language(l)) {
+            diags.push(warning(name, &key, format!("unknown language '{lang}'")));
+        }
+    }
+
+    for (label, rule) in &file.tags {
+        let key = format!("tags.{label}");
+        if rule.is_empty() {
+            diags.push(warning(
+                name,
+                &key,
+                "tag has no criteria and matches nothing".into(),
+            ));
+        }
+        for pattern in &rule.names {
+            if let Err(e) = globset::Glob::new(pattern) {
+                diags.push(error(name, &key, format!("invalid glob '{pattern}': {e}")));
+            }
+        }
+    }
+
+    for (i, rule) in file.arch.rules.iter().enumerate() {
+        let key = format!("arch.rules[{i}]");
+        if rule.deny.is_empty() && rule.allow.is_empty() {
+            diags.push(warning(
+                name,
+                &key,
+                "rule neither denies nor allows anything".into(),
+            ));
+        }
+        // `deny` may name modules outside the index, so only syntax is checked.
+        for pattern in rule.deny.iter().chain(&rule.allow) {
+            if let Err(e) = globset::Glob::new(pattern) {
+                diags.push(error(name, &key, format!("invalid glob '{pattern}': {e}")));
+            }
+        }
+    }
+
+    for (i, pattern) in file.alerts.packages.iter().enumerate() {
+        if let Err(e) = globset::Glob::new(pattern) {
+            diags.push(error(
+                name,
+                &format!("alerts.packages[{i}]"),
+                format!("invalid glob '{pattern}': {e}"),
+            ));
+        }
+    }
+
+    for (event, hooks) in [
+        ("on_index_complete", &file.hooks.on_index_complete),
+        ("on_symbol_changed", &file.hooks.on_symbol_changed),
+        ("on_watched_impact", &file.hooks.on_watched_impact),
+    ] {
+        for (i, hook) in hooks.iter().enumerate() {
+            if hook.command.is_none() && hook.webhook.is_none() {
+                diags.push(error(
+                    name,
+                    &format!("hooks.{event}[{i}]"),
+                    "hook has neither a command nor a webhook".into(),
+                ));
+            }
+        }
+    }
+
+    for (alias, definition) in &file.aliases {
+        let key = format!("aliases.{alias}");
+        match aliases::words(definition) {
+            Ok(words) if words.is_empty() => {
+                diags.push(error(name, &key, "alias is empty".into()));
+            }
+            Ok(_) => {}
+            Err(e) => diags.push(error(name, &key, e.to_string())),
+        }
+    }
+
+    for (macro_name, def) in &file.macros {
+        let key = format!("macros.{macro_name}");
+        if def.steps.is_empty() {
+            diags.push(warning(name, &key, "macro has no steps".into()));
+        }
+        let used = serde_json::to_string(&def.steps).unwrap_or_default();
+        for param in def
+            .params
+            .iter()
+            .filter(|p| !used.contains(&format!("{{{p}}}")))
+        {
+            diags.push(warning(
+                name,
+                &key,
+                format!("parameter '{param}' is never used"),
+            ));
+        }
+    }
+
+    // Sections read from one place only, set somewhere else.
+    let defaults = ConfigFile::default();
+    let nested = dir.is_some_and(|d| !d.is_empty());
+    let root_only = format!("the root {CONFIG_FILE}");
+    let misplaced = [
+        (
+            "plugins",
+            nested && file.plugins != defaults.plugins,
+            root_only.as_str(),
+        ),
+        (
+            "macros",
+            nested && !file.macros.is_empty(),
+            root_only.as_str(),
+        ),
+        (
+            "hooks",
+            nested && !file.hooks.is_empty(),
+            root_only.as_str(),
+        ),
+        (
+            "alerts",
+            nested && file.alerts != defaults.alerts,
+            root_only.as_str(),
+        ),
+        (
+            "aliases",
+            nested && !file.aliases.is_empty(),
+            root_only.as_str(),
+        ),
+        (
+            "index.vendor",
+            nested && file.index.vendor,
+            root_only.as_str(),
+        ),
+        (
+            "output",
+            dir.is_some() && file.output != defaults.output,
+            "the user config",
+        ),
+        (
+            "editor",
+            dir.is_some() && file.editor != defaults.editor,
+            "the user config",
+        ),
+    ];
+    for (section, _, place) in misplaced.iter().filter(|(_, set, _)| *set) {
+        diags.push(warning(
+            name,
+            section,
+            format!("[{section}] is only read from {place}"),
+        ));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::keys;
+
+    fn project(name: &str, files: &[(&str, &str)]) -> std::path::PathBuf {
+        let root =
+            std::env::temp_dir().join(format!("cartog-validate-{name}-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        for (path, raw) in files {
+            let path = root.join(path);
+            std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+            std::fs::write(path, raw).unwrap();
+        }
+        root
+    }
+
+    #[test]
+    fn test_schema_covers_every_key() {
+        let schema: serde_json::Value = serde_json::from_str(SCHEMA).unwrap();
+        for (section, key, _) in keys() {
+            assert!(
+                !schema["properties"][&section]["properties"][&key].is_null(),
+                "{section}.{key} missing from docs/cartog.schema.json"
+            );
+        }
+    }
+
+    #[test]
+    fn test_flags_unknown_keys_conflicts_and_dead_globs() {
+        let root = project(
+            "broken",
+            &[
+                (
+                    ".cartog.toml",
+                    r#"
+                [index]
+                ignore = ["generated", "src/*.py"]
+                ignroe = ["typo"]
+
+                [languages]
+                enable = ["python"]
+                disable = ["python", "cobol"]
+
+                [tags.legacy]
+
+                [[arch.rules]]
+                from = ["models/**"]
+
+                [[hooks.on_index_complete]]
+                url = "http://localhost/x"
+                "#,
+                ),
+                ("generated/client.py", ""),
+                ("src/app.py", ""),
+                ("web/.cartog.toml", "[macros.x]\nsteps = []"),
+            ],
+        );
+        let report = validate_with(&root, None, Vec::new()).unwrap();
+        let find = |key: &str| {
+            report
+                .diagnostics
+                .iter()
+                .find(|d| d.key == key)
+                .unwrap_or_else(|| panic!("no diagnostic for {key}: {:#?}", report.diagnostics))
+        };
+
+        assert_eq!(find("index.ignroe").severity, Severity::Error);
+        assert_eq!(
+            find("hooks.on_index_complete[0].url").message,
+            "unknown key"
+        );
+        assert!(find("index.ignore[0]")
+            .message
+            .contains("use 'generated/**'"));
+        assert!(find("languages")
+            .message
+            .contains("both enabled and disabled"));
+        assert!(find("languages.disable").message.contains("cobol"));
+        assert_eq!(find("tags.legacy").severity, Severity::Warning);
+        assert!(find("arch.rules[0]")
+            .message
+            .contains("neither denies nor allows"));
+        assert!(find("arch.rules[0].from[0]")
+            .message
+            .contains("matches no files"));
+        assert_eq!(find("macros").file, "web/.cartog.toml");
+        assert!(!report
+            .diagnostics
+            .iter()
+            .any(|d| d.key == "index.ignore[1]"));
+        assert!(
+            report.effective.is_empty(),
+            "errors hide the effective config"
+        );
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+
+    #[test]
+    fn test_clean_config_reports_effective_layers() {
+        let root = project(
+            "clean",
+            &[
+                (".cartog.toml", "[index]\nignore = [\"gen/**\"]"),
+                ("gen/a.py", ""),
+            ],
+        );
+        let vars = [("CARTOG_LANGUAGES_DISABLE".to_string(), "ruby".to_string())];
+        let report = validate_with(&root, None, vars).unwrap();
+        assert_eq!(
+            report.count(Severity::Error),
+            0,
+            "{:#?}",
+            report.diagnostics
+        );
+        assert_eq!(report.effective.len(), 1);
+        assert_eq!(report.effective[0].config.languages.disable, ["ruby"]);
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+}
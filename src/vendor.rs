@@ -0,0 +1,141 @@
+//! Go `vendor/` as an external tier of the index, for `[index] vendor = true`.
+//!
+//! The vendored packages are indexed like project code, but kept apart when
+//! edges resolve: a project call never lands in `vendor/` by name alone, so a
+//! dependency's `Open` cannot capture a project's unresolved `Open`. Calls into
+//! a dependency resolve through the file's imports instead: `client.Dial` after
+//! `import "github.com/acme/client"` goes to the package-level `Dial` in
+//! `vendor/github.com/acme/client/`. Vendored packages call each other the same
+//! way, so `callees` and `sequence` follow a call into dependencies as deep as
+//! they go. Methods on a dependency's types are not resolved.
+
+use std::collections::HashMap;
+
+use anyhow::Result;
+
+use crate::db::Database;
+use crate::deps_usage::go_local_name;
+use crate::types::{Edge, EdgeKind, SymbolKind};
+
+/// The directory Go vendors dependencies into, at the project root.
+pub const VENDOR_DIR: &str = "vendor";
+
+/// Whether `file_path` is a vendored copy of a dependency.
+pub fn is_vendored(file_path: &str) -> bool {
+    file_path
+        .strip_prefix(VENDOR_DIR)
+        .is_some_and(|rest| rest.starts_with('/'))
+}
+
+/// Resolve calls and references into vendored packages through Go imports.
+/// Returns the number of edges resolved.
+pub fn resolve(db: &Database) -> Result<u32> {
+    let symbols = db.all_symbols()?;
+
+    // (import path, name) -> package-level symbol of a vendored package.
+    let mut exported: HashMap<(&str, &str), &str> = HashMap::new();
+    for sym in &symbols {
+        if sym.parent_id.is_some() || sym.kind == SymbolKind::Import {
+            continue;
+        }
+        let Some(package) = sym
+            .file_path
+            .strip_prefix("vendor/")
+            .and_then(|rest| rest.rsplit_once('/'))
+            .map(|(dir, _)| dir)
+        else {
+            continue;
+        };
+        exported
+            .entry((package, sym.name.as_str()))
+            .or_insert(sym.id.as_str());
+    }
+    if exported.is_empty() {
+        return Ok(0);
+    }
+
+    // File -> local name -> import path, for imports of vendored packages.
+    let vendored: std::collections::HashSet<&str> = exported.keys().map(|(p, _)| *p).collect();
+    let mut imported: HashMap<&str, HashMap<&str, &str>> = HashMap::new();
+    for import in symbols
+        .iter()
+        .filter(|s| s.kind == SymbolKind::Import && vendored.contains(s.name.as_str()))
+    {
+        if let Some(local) = go_local_name(&import.name, import.signature.as_deref()) {
+            imported
+                .entry(import.file_path.as_str())
+                .or_default()
+                .insert(local, import.name.as_str());
+        }
+    }
+
+    let mut targets: Vec<(Edge, String)> = Vec::new();
+    for edge in db.all_edges()? {
+        if edge.target_id.is_some() || !matches!(edge.kind, EdgeKind::Calls | EdgeKind::References)
+        {
+            continue;
+        }
+        let Some(names) = imported.get(edge.file_path.as_str()) else {
+            continue;
+        };
+        let call = edge.target_name.split('(').next().unwrap_or("");
+        let Some((qualifier, rest)) = call.split_once('.') else {
+            continue;
+        };
+        let name = rest.split('.').next().unwrap_or(rest);
+        let target = names
+            .get(qualifier)
+            .and_then(|package| exported.get(&(*package, name)));
+        if let Some(target) = target {
+            let target = target.to_string();
+            targets.push((edge, target));
+        }
+    }
+    db.set_edge_targets(&targets)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::test_support::index_fixture;
+
+    #[test]
+    fn test_calls_resolve_into_vendored_packages() {
+        let db = index_fixture(
+            "vendor",
+            &[
+                (".cartog.toml", "[index]\nvendor = true\n"),
+                ("go.mod", "module example.com/app\n"),
+                (
+                    "main.go",
+                    "package main\n\nimport c \"github.com/acme/client\"\n\nfunc main() {\n\tc.Dial(\"x\")\n\tOpen()\n}\n",
+                ),
+                (
+                    "vendor/github.com/acme/client/client.go",
+                    "package client\n\nimport \"github.com/acme/wire\"\n\nfunc Dial(addr string) {\n\twire.Connect(addr)\n}\n\nfunc Open() {}\n",
+                ),
+                (
+                    "vendor/github.com/acme/wire/wire.go",
+                    "package wire\n\nfunc Connect(addr string) {}\n",
+                ),
+                ("vendor/modules.txt", "# github.com/acme/client v1.0.0\n"),
+            ],
+        );
+
+        let callees = db.callees("main").unwrap();
+        let dial = callees.iter().find(|e| e.target_name.starts_with("c.Dial"));
+        assert_eq!(
+            dial.and_then(|e| e.target_id.as_deref()),
+            Some("vendor/github.com/acme/client/client.go:Dial:5")
+        );
+        // A bare name in project code does not resolve into a dependency.
+        let open = callees.iter().find(|e| e.target_name.starts_with("Open"));
+        assert_eq!(open.and_then(|e| e.target_id.as_deref()), None);
+        // Vendored packages call each other through their imports too.
+        let connect = db.callees("Dial").unwrap();
+        assert_eq!(
+            connect[0].target_id.as_deref(),
+            Some("vendor/github.com/acme/wire/wire.go:Connect:3")
+        );
+    }
+}
@@ -0,0 +1,321 @@
+//! Warm snapshots for the MCP server.
+//!
+//! Two things carry over from one server run to the next:
+//!
+//! - The [`IndexSnapshot`]: the symbol table and the file import graph, saved to
+//!   [`SNAPSHOT_FILE`] on shutdown. On the next start a background thread loads it
+//!   back into memory, and outlines and file dependencies are answered from it for
+//!   as long as the index is not rebuilt.
+//! - The hot set: the files and names the tools were asked about, saved to
+//!   [`WARM_FILE`]. On start a background thread walks the symbol-table and graph
+//!   indexes, then replays the hot set, so the pages the other tools need are
+//!   already resident. This follows the same idea as a database "autoprewarm": it
+//!   restores the working set, not the data.
+
+use std::collections::{BTreeMap, VecDeque};
+use std::path::Path;
+use std::time::Instant;
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use tracing::debug;
+
+use crate::db::Database;
+use crate::indexer;
+use crate::types::{Edge, Symbol};
+
+/// Hot set location, relative to the project root.
+pub const WARM_FILE: &str = ".cartog/warm.json";
+
+/// Index snapshot location, relative to the project root.
+pub const SNAPSHOT_FILE: &str = ".cartog/snapshot.json";
+
+/// Entries kept per kind (files, names). Older entries fall off first.
+pub const HOT_SET_CAPACITY: usize = 256;
+
+/// The persisted hot set, most recent first.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct WarmSnapshot {
+    pub files: Vec<String>,
+    pub names: Vec<String>,
+}
+
+impl WarmSnapshot {
+    /// Load a snapshot; a missing or unreadable file is an empty snapshot.
+    pub fn load(path: &Path) -> Self {
+        std::fs::read_to_string(path)
+            .ok()
+            .and_then(|raw| serde_json::from_str(&raw).ok())
+            .unwrap_or_default()
+    }
+
+    pub fn save(&self, path: &Path) -> Result<()> {
+        if let Some(dir) = path.parent() {
+            std::fs::create_dir_all(dir)
+                .with_context(|| format!("failed to create {}", dir.display()))?;
+        }
+        std::fs::write(path, serde_json::to_string(self)?)
+            .with_context(|| format!("failed to write {}", path.display()))
+    }
+}
+
+/// The symbol table and import graph of one build of the index, held in memory.
+///
+/// It applies while the index's stamp ([`indexer::index_stamp`]) is the one it was
+/// taken at; any index run, including a watcher's, makes it stale.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct IndexSnapshot {
+    stamp: String,
+    files: BTreeMap<String, SnapshotFile>,
+}
+
+/// One file's symbols, by line, and its import edges.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+struct SnapshotFile {
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    symbols: Vec<Symbol>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    imports: Vec<Edge>,
+}
+
+impl IndexSnapshot {
+    /// Snapshot the index; `None` when it predates index stamps.
+    pub fn build(db: &Database) -> Result<Option<Self>> {
+        let Some(stamp) = indexer::index_stamp(db)? else {
+            return Ok(None);
+        };
+        let mut files: BTreeMap<String, SnapshotFile> = BTreeMap::new();
+        for symbol in db.all_symbols()? {
+            files
+                .entry(symbol.file_path.clone())
+                .or_default()
+                .symbols
+                .push(symbol);
+        }
+        for edge in db.import_edges()? {
+            files
+                .entry(edge.file_path.clone())
+                .or_default()
+                .imports
+                .push(edge);
+        }
+        Ok(Some(Self { stamp, files }))
+    }
+
+    /// Load a snapshot; a missing or unreadable file is no snapshot.
+    pub fn load(path: &Path) -> Option<Self> {
+        let raw = std::fs::read(path).ok()?;
+        serde_json::from_slice(&raw).ok()
+    }
+
+    pub fn save(&self, path: &Path) -> Result<()> {
+        if let Some(dir) = path.parent() {
+            std::fs::create_dir_all(dir)
+                .with_context(|| format!("failed to create {}", dir.display()))?;
+        }
+        std::fs::write(path, serde_json::to_vec(self)?)
+            .with_context(|| format!("failed to write {}", path.display()))
+    }
+
+    /// Whether `db` is still the build this snapshot was taken from.
+    pub fn is_current(&self, db: &Database) -> Result<bool> {
+        Ok(indexer::index_stamp(db)?.as_deref() == Some(self.stamp.as_str()))
+    }
+
+    /// Same as [`Database::outline`].
+    pub fn outline(&self, file: &str) -> Vec<Symbol> {
+        self.files
+            .get(file)
+            .map(|f| f.symbols.clone())
+            .unwrap_or_default()
+    }
+
+    /// Same as [`Database::file_deps`].
+    pub fn file_deps(&self, file: &str) -> Vec<Edge> {
+        self.files
+            .get(file)
+            .map(|f| f.imports.clone())
+            .unwrap_or_default()
+    }
+
+    pub fn symbol_count(&self) -> usize {
+        self.files.values().map(|f| f.symbols.len()).sum()
+    }
+}
+
+/// Bounded, deduplicated recency lists of files and names touched by tool calls.
+#[derive(Debug, Clone)]
+pub struct HotSet {
+    files: VecDeque<String>,
+    names: VecDeque<String>,
+    capacity: usize,
+}
+
+impl HotSet {
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            files: VecDeque::new(),
+            names: VecDeque::new(),
+            capacity,
+        }
+    }
+
+    /// Seed from a previous session so the working set carries over.
+    pub fn from_snapshot(snapshot: &WarmSnapshot, capacity: usize) -> Self {
+        let mut hot = Self::new(capacity);
+        // Oldest first, so the snapshot's most recent entries stay at the front.
+        for file in snapshot.files.iter().rev() {
+            hot.touch_file(file);
+        }
+        for name in snapshot.names.iter().rev() {
+            hot.touch_name(name);
+        }
+        hot
+    }
+
+    pub fn touch_file(&mut self, file: &str) {
+        touch(&mut self.files, file, self.capacity);
+    }
+
+    pub fn touch_name(&mut self, name: &str) {
+        touch(&mut self.names, name, self.capacity);
+    }
+
+    pub fn snapshot(&self) -> WarmSnapshot {
+        WarmSnapshot {
+            files: self.files.iter().cloned().collect(),
+            names: self.names.iter().cloned().collect(),
+        }
+    }
+}
+
+fn touch(list: &mut VecDeque<String>, item: &str, capacity: usize) {
+    if let Some(pos) = list.iter().position(|x| x == item) {
+        list.remove(pos);
+    }
+    list.push_front(item.to_string());
+    list.truncate(capacity);
+}
+
+/// What a warm-up pass touched.
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct WarmStats {
+    pub files: usize,
+    pub names: usize,
+    pub elapsed_ms: f64,
+}
+
+/// Fault in the symbol-table and graph indexes, then the hot set's pages.
+///
+/// Lookups that fail (say, for a file that has since been deleted) are skipped.
+/// Warming is best-effort.
+pub fn warm(db: &Database, snapshot: &WarmSnapshot) -> Result<WarmStats> {
+    let started = Instant::now();
+    db.touch_graph_indexes()?;
+    let mut stats = WarmStats::default();
+    for file in &snapshot.files {
+        match db.outline(file) {
+            Ok(_) => stats.files += 1,
+            Err(e) => debug!(file, error = %e, "skipping warm file"),
+        }
+    }
+    for name in &snapshot.names {
+        match db.refs(name, None) {
+            Ok(_) => stats.names += 1,
+            Err(e) => debug!(name, error = %e, "skipping warm name"),
+        }
+    }
+    stats.elapsed_ms = started.elapsed().as_secs_f64() * 1000.0;
+    Ok(stats)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{EdgeKind, SymbolKind};
+
+    #[test]
+    fn test_hot_set_is_bounded_and_most_recent_first() {
+        let mut hot = HotSet::new(2);
+        hot.touch_name("a");
+        hot.touch_name("b");
+        hot.touch_name("a");
+        hot.touch_name("c");
+        assert_eq!(hot.snapshot().names, vec!["c", "a"]);
+    }
+
+    #[test]
+    fn test_snapshot_roundtrip_preserves_order() {
+        let snapshot = WarmSnapshot {
+            files: vec!["auth.py".into(), "views.py".into()],
+            names: vec!["login".into()],
+        };
+        let dir = std::env::temp_dir().join(format!("cartog-warm-{}", std::process::id()));
+        let path = dir.join("warm.json");
+        snapshot.save(&path).unwrap();
+        let loaded = WarmSnapshot::load(&path);
+        assert_eq!(loaded, snapshot);
+        assert_eq!(
+            HotSet::from_snapshot(&loaded, HOT_SET_CAPACITY).snapshot(),
+            snapshot
+        );
+        std::fs::remove_dir_all(&dir).unwrap();
+
+        assert_eq!(WarmSnapshot::load(&path), WarmSnapshot::default());
+    }
+
+    #[test]
+    fn test_index_snapshot_answers_like_the_index() {
+        let db = Database::open_memory().unwrap();
+        assert_eq!(IndexSnapshot::build(&db).unwrap(), None);
+
+        let login = Symbol::new("login", SymbolKind::Function, "auth.py", 3, 5, 0, 50);
+        let logout = Symbol::new("logout", SymbolKind::Function, "auth.py", 7, 9, 60, 90);
+        db.insert_symbol(&logout).unwrap();
+        db.insert_symbol(&login).unwrap();
+        let import = Edge::new(&login.id, "os", EdgeKind::Imports, "auth.py", 1);
+        db.insert_edge(&import).unwrap();
+        db.insert_edge(&Edge::new(&login.id, "hash", EdgeKind::Calls, "auth.py", 4))
+            .unwrap();
+        db.set_metadata(indexer::META_INDEX_STAMP, "1").unwrap();
+
+        let snapshot = IndexSnapshot::build(&db).unwrap().unwrap();
+        assert_eq!(snapshot.symbol_count(), 2);
+        assert_eq!(snapshot.outline("auth.py"), db.outline("auth.py").unwrap());
+        assert_eq!(snapshot.file_deps("auth.py"), vec![import]);
+        assert!(snapshot.outline("gone.py").is_empty());
+
+        let dir = std::env::temp_dir().join(format!("cartog-snapshot-{}", std::process::id()));
+        let path = dir.join("snapshot.json");
+        snapshot.save(&path).unwrap();
+        let loaded = IndexSnapshot::load(&path).unwrap();
+        std::fs::remove_dir_all(&dir).unwrap();
+        assert_eq!(loaded, snapshot);
+        assert!(loaded.is_current(&db).unwrap());
+
+        db.set_metadata(indexer::META_INDEX_STAMP, "2").unwrap();
+        assert!(!loaded.is_current(&db).unwrap());
+    }
+
+    #[test]
+    fn test_warm_replays_hot_set() {
+        let db = Database::open_memory().unwrap();
+        db.insert_symbol(&Symbol::new(
+            "login",
+            SymbolKind::Function,
+            "auth.py",
+            1,
+            5,
+            0,
+            50,
+        ))
+        .unwrap();
+        let snapshot = WarmSnapshot {
+            files: vec!["auth.py".into(), "gone.py".into()],
+            names: vec!["login".into()],
+        };
+        let stats = warm(&db, &snapshot).unwrap();
+        assert_eq!(stats.files, 2);
+        assert_eq!(stats.names, 1);
+    }
+}
@@ -7,9 +7,13 @@ use anyhow::{Context, Result};
 use notify_debouncer_mini::{new_debouncer, DebouncedEventKind};
 use tracing::{debug, info, warn};
 
+use crate::alerts;
+use crate::config::ProjectConfig;
 use crate::db::Database;
+use crate::hooks::SymbolChange;
 use crate::indexer::{self, is_ignored_dirname};
 use crate::languages::detect_language;
+use crate::pipeline::PipelineConfig;
 use crate::rag;
 
 /// Configuration for the watch loop.
@@ -139,7 +143,11 @@ fn watch_loop(
     );
 
     // Initial incremental index to ensure DB is current
-    match indexer::index_directory(&db, root, false) {
+    let initial = {
+        let _writer = indexer::write_lock();
+        indexer::index_directory(&db, root, false)
+    };
+    match initial {
         Ok(r) => info!(
             files = r.files_indexed,
             skipped = r.files_skipped,
@@ -190,8 +198,18 @@ fn watch_loop(
                         count = events.len(),
                         "file change events received, re-indexing"
                     );
-                    match indexer::index_directory(&db, root, false) {
-                        Ok(r) => {
+                    // Waits out a swapped rebuild, then indexes against the new generation.
+                    let indexed = {
+                        let _writer = indexer::write_lock();
+                        indexer::index_directory_changes(
+                            &db,
+                            root,
+                            false,
+                            &PipelineConfig::default(),
+                        )
+                    };
+                    match indexed {
+                        Ok((r, changes)) => {
                             if r.files_indexed > 0 || r.files_removed > 0 {
                                 info!(
                                     files = r.files_indexed,
@@ -201,6 +219,9 @@ fn watch_loop(
                                     "re-indexed"
                                 );
                             }
+                            if !changes.is_empty() {
+                                check_alerts(&db, root, &changes);
+                            }
                             // Check if RAG embedding is needed
                             if config.rag {
                                 match db.symbols_needing_embeddings() {
@@ -235,7 +256,11 @@ fn watch_loop(
                     if let Some(last) = last_index_time {
                         if last.elapsed() >= config.rag_delay {
                             info!("RAG delay elapsed, embedding pending symbols");
-                            match rag::indexer::index_embeddings(&db, false) {
+                            let embedded = {
+                                let _writer = indexer::write_lock();
+                                rag::indexer::index_embeddings(&db, false)
+                            };
+                            match embedded {
                                 Ok(r) => {
                                     info!(
                                         embedded = r.symbols_embedded,
@@ -263,6 +288,7 @@ fn watch_loop(
     // Flush pending RAG embeddings on shutdown
     if config.rag && rag_pending {
         info!("flushing pending RAG embeddings before shutdown");
+        let _writer = indexer::write_lock();
         match rag::indexer::index_embeddings(&db, false) {
             Ok(r) => info!(embedded = r.symbols_embedded, "final RAG flush complete"),
             Err(e) => warn!(error = %e, "final RAG flush failed"),
@@ -273,6 +299,25 @@ fn watch_loop(
     Ok(())
 }
 
+/// Raise `[alerts]` for the watched symbols `changes` reach.
+fn check_alerts(db: &Database, root: &Path, changes: &[SymbolChange]) {
+    let project = match ProjectConfig::load(root) {
+        Ok(project) => project,
+        Err(e) => {
+            warn!(error = %e, "cannot read config for alerts");
+            return;
+        }
+    };
+    let config = project.alerts();
+    if config.is_empty() {
+        return;
+    }
+    match alerts::find(db, config, changes) {
+        Ok(found) => alerts::raise(project.hooks(), config, root, &found),
+        Err(e) => warn!(error = %format!("{e:#}"), "failed to check watched symbols"),
+    }
+}
+
 /// Check if a path is relevant for indexing: supported language + not in ignored directory.
 ///
 /// Returns `false` for:
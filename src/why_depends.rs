@@ -0,0 +1,249 @@
+//! `cartog why-depends`: the shortest import chains from one Go package to
+//! another, for the dependency nobody remembers adding.
+//!
+//! The graph is built from the import symbols of non-test `.go` files: a
+//! package is a directory, and an import leads to the project directory it
+//! names (through `go.mod`, as in `cartog inits`), to the vendored copy under
+//! `vendor/` when there is one, or else to the import path itself, which goes
+//! no further. The target is a project package, or any import path: an external
+//! one also matches the packages below it, so `github.com/aws/aws-sdk-go-v2`
+//! covers each of its service packages.
+
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::deps_usage::GoMod;
+use crate::inits::{dir_of, is_go_source, local_dir};
+use crate::types::SymbolKind;
+
+/// One import on a chain: `from` imports `to`, first at `file:line`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ImportHop {
+    /// Directory of the importing package.
+    pub from: String,
+    /// Directory of the imported package, or its import path when it is not
+    /// in the index.
+    pub to: String,
+    /// The import path as written.
+    pub path: String,
+    pub file: String,
+    pub line: u32,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct WhyDepends {
+    pub from: String,
+    pub to: String,
+    /// The shortest chains, all of the same length; empty when `from` does
+    /// not depend on `to`.
+    pub chains: Vec<Vec<ImportHop>>,
+}
+
+/// Up to `limit` shortest import chains from package `from` to `to`.
+///
+/// Packages are given as directories (`.` for the root) or import paths; `from`
+/// must be a Go package of the project or of `vendor/`.
+pub fn why_depends(
+    db: &Database,
+    go_mod: &GoMod,
+    from: &str,
+    to: &str,
+    limit: usize,
+) -> Result<WhyDepends> {
+    let mut imports: Vec<_> = db
+        .all_symbols()?
+        .into_iter()
+        .filter(|s| s.kind == SymbolKind::Import && is_go_source(&s.file_path))
+        .collect();
+    imports.sort_by(|a, b| (&a.file_path, a.start_line).cmp(&(&b.file_path, b.start_line)));
+    let dirs: BTreeSet<String> = db
+        .all_files()?
+        .into_iter()
+        .filter(|f| is_go_source(f))
+        .map(|f| dir_of(&f).to_string())
+        .collect();
+    let package = |name: &str| -> Option<String> {
+        let name = name.trim_end_matches('/');
+        let name = if name == "." { "" } else { name };
+        if dirs.contains(name) {
+            return Some(name.to_string());
+        }
+        local_dir(name, go_mod, &dirs)
+            .map(str::to_string)
+            .or_else(|| Some(format!("vendor/{name}")).filter(|d| dirs.contains(d)))
+    };
+
+    let start = package(from).ok_or_else(|| anyhow::anyhow!("no Go package {from}"))?;
+    let target = package(to);
+    anyhow::ensure!(
+        target.as_deref() != Some(start.as_str()),
+        "{from} and {to} are the same package"
+    );
+    let reaches = |hop: &ImportHop| match &target {
+        Some(dir) => hop.to == *dir,
+        None => {
+            let to = to.trim_end_matches('/');
+            hop.path == to || hop.path.starts_with(&format!("{to}/"))
+        }
+    };
+
+    // The first import of each package by each other one.
+    let mut edges: BTreeMap<&str, BTreeMap<String, ImportHop>> = BTreeMap::new();
+    for sym in &imports {
+        let dir = dir_of(&sym.file_path);
+        let node = package(&sym.name).unwrap_or_else(|| sym.name.clone());
+        if node == dir {
+            continue;
+        }
+        edges
+            .entry(dir)
+            .or_default()
+            .entry(node.clone())
+            .or_insert_with(|| ImportHop {
+                from: dir.to_string(),
+                to: node,
+                path: sym.name.clone(),
+                file: sym.file_path.clone(),
+                line: sym.start_line,
+            });
+    }
+
+    // Breadth-first by layer, keeping every hop that reaches a package at its
+    // shortest distance. The target is `None`, and is not expanded.
+    let mut distance: HashMap<Option<&str>, usize> = HashMap::from([(Some(start.as_str()), 0)]);
+    let mut parents: HashMap<Option<&str>, Vec<&ImportHop>> = HashMap::new();
+    let mut frontier = vec![start.as_str()];
+    let mut level = 0;
+    while !frontier.is_empty() && !distance.contains_key(&None) {
+        level += 1;
+        let mut next = Vec::new();
+        for node in frontier {
+            for hop in edges.get(node).into_iter().flat_map(|e| e.values()) {
+                let key = if reaches(hop) {
+                    None
+                } else {
+                    Some(hop.to.as_str())
+                };
+                let known = *distance.entry(key).or_insert(level);
+                if known != level {
+                    continue;
+                }
+                let hops = parents.entry(key).or_default();
+                if hops.is_empty() {
+                    next.extend(key);
+                }
+                if !hops.iter().any(|h| h.from == hop.from) {
+                    hops.push(hop);
+                }
+            }
+        }
+        frontier = next;
+    }
+
+    let mut chains = Vec::new();
+    collect(&parents, None, &start, &mut Vec::new(), limit, &mut chains);
+    Ok(WhyDepends {
+        from: start,
+        to: target.unwrap_or_else(|| to.trim_end_matches('/').to_string()),
+        chains,
+    })
+}
+
+/// Chains from `start` to `node`, walking the recorded hops backwards.
+fn collect<'a>(
+    parents: &HashMap<Option<&str>, Vec<&'a ImportHop>>,
+    node: Option<&str>,
+    start: &str,
+    suffix: &mut Vec<&'a ImportHop>,
+    limit: usize,
+    chains: &mut Vec<Vec<ImportHop>>,
+) {
+    if chains.len() >= limit {
+        return;
+    }
+    if node == Some(start) {
+        chains.push(suffix.iter().rev().map(|h| (*h).clone()).collect());
+        return;
+    }
+    for hop in parents.get(&node).into_iter().flatten() {
+        suffix.push(hop);
+        collect(parents, Some(&hop.from), start, suffix, limit, chains);
+        suffix.pop();
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::deps_usage::parse_go_mod;
+    use crate::test_support::index_fixture;
+
+    #[test]
+    fn test_shortest_chains_to_a_package_and_an_import_path() {
+        let db = index_fixture(
+            "why-chains",
+            &[
+                (
+                    "cmd/server/main.go",
+                    "package main\n\nimport (\n\t\"example.com/app/api\"\n\t\"example.com/app/jobs\"\n)\n\nfunc main() {}\n",
+                ),
+                (
+                    "api/api.go",
+                    "package api\n\nimport \"example.com/app/store\"\n",
+                ),
+                (
+                    "jobs/jobs.go",
+                    "package jobs\n\nimport (\n\t\"example.com/app/api\"\n\t\"example.com/app/store\"\n)\n",
+                ),
+                (
+                    "store/store.go",
+                    "package store\n\nimport \"github.com/aws/aws-sdk-go-v2/service/s3\"\n",
+                ),
+                (
+                    "store/store_test.go",
+                    "package store\n\nimport \"github.com/stretchr/testify/assert\"\n",
+                ),
+            ],
+        );
+        let go_mod = parse_go_mod("module example.com/app\n");
+        let paths = |found: &WhyDepends| -> Vec<Vec<String>> {
+            found
+                .chains
+                .iter()
+                .map(|c| c.iter().map(|h| h.to.clone()).collect())
+                .collect()
+        };
+
+        let found = why_depends(&db, &go_mod, "cmd/server/", "store", 10).unwrap();
+        assert_eq!(found.to, "store");
+        assert_eq!(paths(&found), [["api", "store"], ["jobs", "store"]]);
+        let hop = &found.chains[0][0];
+        assert_eq!((hop.file.as_str(), hop.line), ("cmd/server/main.go", 4));
+        assert_eq!(hop.path, "example.com/app/api");
+
+        let found = why_depends(&db, &go_mod, "jobs", "github.com/aws/aws-sdk-go-v2", 10).unwrap();
+        assert_eq!(
+            paths(&found),
+            [["store", "github.com/aws/aws-sdk-go-v2/service/s3"]]
+        );
+        assert_eq!(
+            why_depends(&db, &go_mod, "cmd/server", "store", 1)
+                .unwrap()
+                .chains
+                .len(),
+            1
+        );
+
+        // Test-only imports are not dependencies of the package.
+        let found = why_depends(&db, &go_mod, "api", "github.com/stretchr/testify", 10).unwrap();
+        assert!(found.chains.is_empty());
+        assert!(why_depends(&db, &go_mod, "store", "api", 10)
+            .unwrap()
+            .chains
+            .is_empty());
+        assert!(why_depends(&db, &go_mod, "nowhere", "store", 10).is_err());
+    }
+}
@@ -0,0 +1,112 @@
+//! Wire-format surface of struct fields: where a field leaves or enters the
+//! process, and under which key.
+//!
+//! Struct tags and serialization calls are recorded at index time (see
+//! `languages::serialize`). A field shows up in every encode or decode of its
+//! struct, in each format under the key its tag gives it; formats that leave it
+//! out (`json:"-"`, unexported fields) are skipped.
+
+use std::collections::HashSet;
+
+use anyhow::Result;
+use serde::Serialize;
+
+use crate::db::Database;
+use crate::types::{Serialization, Symbol};
+
+/// A function that serializes the field, and the key it goes by there.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct WireSite {
+    pub function: Symbol,
+    #[serde(flatten)]
+    pub serialization: Serialization,
+    pub key: String,
+}
+
+/// Where `target`, a `Type.Field` name, is encoded or decoded, by file and line.
+/// Any other name has no sites.
+pub fn field_sites(db: &Database, target: &str) -> Result<Vec<WireSite>> {
+    let Some((type_name, field)) = target.rsplit_once('.') else {
+        return Ok(Vec::new());
+    };
+    let mut sites = Vec::new();
+    let mut seen = HashSet::new();
+    for (_, field) in db.struct_field(type_name, field)? {
+        for (function, serialization) in db.serializations_of(type_name)? {
+            let Some(key) = field.wire_key(&serialization.format) else {
+                continue;
+            };
+            // Same-named structs in several packages share their serializations.
+            if seen.insert((function.id.clone(), serialization.line, key.clone())) {
+                sites.push(WireSite {
+                    function,
+                    serialization,
+                    key,
+                });
+            }
+        }
+    }
+    Ok(sites)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::{StructField, SymbolKind};
+
+    #[test]
+    fn test_field_sites_use_the_tagged_key() {
+        let db = Database::open_memory().unwrap();
+        let file = "api/users.go";
+        let user = Symbol::new("User", SymbolKind::Class, file, 3, 7, 0, 90);
+        let handler = Symbol::new("GetUser", SymbolKind::Function, file, 9, 16, 100, 300);
+        db.insert_symbols(&[user.clone(), handler.clone()]).unwrap();
+        let field = |name: &str, line, tags: &[(&str, &str)]| StructField {
+            symbol_id: user.id.clone(),
+            name: name.to_string(),
+            line,
+            tags: tags
+                .iter()
+                .map(|(t, v)| (t.to_string(), v.to_string()))
+                .collect(),
+        };
+        let serialization = |line, format: &str, decode| Serialization {
+            symbol_id: handler.id.clone(),
+            line,
+            type_name: "User".to_string(),
+            format: format.to_string(),
+            decode,
+        };
+        db.insert_serializations(
+            file,
+            &[
+                field("Email", 4, &[("json", "email,omitempty")]),
+                field("Password", 5, &[("json", "-")]),
+            ],
+            &[
+                serialization(11, "db", true),
+                serialization(14, "json", false),
+            ],
+        )
+        .unwrap();
+
+        let sites = field_sites(&db, "User.Email").unwrap();
+        let found: Vec<_> = sites
+            .iter()
+            .map(|s| {
+                (
+                    s.serialization.line,
+                    s.key.as_str(),
+                    s.function.name.as_str(),
+                )
+            })
+            .collect();
+        assert_eq!(found, [(11, "email", "GetUser"), (14, "email", "GetUser")]);
+
+        // Left out of the JSON, but still read from the database.
+        let sites = field_sites(&db, "User.Password").unwrap();
+        assert_eq!(sites.len(), 1);
+        assert_eq!(sites[0].serialization.format, "db");
+        assert!(field_sites(&db, "User").unwrap().is_empty());
+    }
+}
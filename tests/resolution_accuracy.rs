@@ -0,0 +1,399 @@
+//! Resolution accuracy benchmark.
+//!
+//! Indexes the Go benchmark fixtures and checks graph queries against golden
+//! answers worked out by hand from the fixture source: who calls a function,
+//! what a call resolves to, which types implement an interface. An answer only
+//! counts when the edge resolved to the golden definition, so a call bound to
+//! the wrong `Login` is a miss, not a hit. `webapp_go` is ordinary application
+//! code; `edgecases_go` isolates one hard feature per package (generics,
+//! embedding, build tags, dot imports, closures in maps).
+//!
+//! Run with: `cargo test --test resolution_accuracy -- --nocapture`
+//!
+//! Each case has precision and recall floors at what the resolver achieves
+//! today; the test fails when a change drops below them. Known gaps (calls
+//! inside Go function literals, Go interface satisfaction) keep their floors
+//! below 100% so the score shows when they are closed: raise the floor then.
+
+use std::path::Path;
+
+use cartog::db::Database;
+use cartog::indexer::index_directory;
+use cartog::types::EdgeKind;
+
+/// A symbol in a golden answer: name and defining file.
+type Item = (&'static str, &'static str);
+
+enum Query {
+    /// Sources of `kind` edges that resolved to `definition`.
+    Refs { definition: Item, kind: EdgeKind },
+    /// Definitions the calls made by `caller` resolved to.
+    Callees { caller: Item },
+    /// Types declaring `parent` as a parent: implementations, embedders.
+    Children { parent: &'static str },
+    /// Types `child` declares as parents.
+    Parents { child: &'static str },
+    /// Every definition with this exact name.
+    Definitions { name: &'static str },
+}
+
+struct Case {
+    question: &'static str,
+    query: Query,
+    expected: &'static [Item],
+    min_precision: f64,
+    min_recall: f64,
+}
+
+fn setup_db(fixture: &str) -> Database {
+    let fixture_dir = Path::new(env!("CARGO_MANIFEST_DIR"))
+        .join("benchmarks")
+        .join("fixtures")
+        .join(fixture);
+
+    let db = Database::open_memory().expect("open in-memory DB");
+    index_directory(&db, &fixture_dir, true).expect("index fixture");
+    db
+}
+
+/// `(name, file)` of the symbol an edge resolved to, if it did.
+fn resolved(db: &Database, target_id: Option<&str>) -> Option<(String, String)> {
+    let symbol = db.get_symbol(target_id?).expect("get symbol")?;
+    Some((symbol.name, symbol.file_path))
+}
+
+fn answer(db: &Database, query: &Query) -> Vec<(String, String)> {
+    let mut got: Vec<(String, String)> = match query {
+        Query::Refs { definition, kind } => db
+            .refs(definition.0, Some(*kind))
+            .expect("refs")
+            .into_iter()
+            .filter(|(edge, _)| {
+                resolved(db, edge.target_id.as_deref())
+                    .is_some_and(|(n, f)| (n.as_str(), f.as_str()) == *definition)
+            })
+            .filter_map(|(_, source)| source.map(|s| (s.name, s.file_path)))
+            .collect(),
+        Query::Callees { caller } => db
+            .callees(caller.0)
+            .expect("callees")
+            .into_iter()
+            .filter(|edge| edge.file_path == caller.1)
+            .filter_map(|edge| resolved(db, edge.target_id.as_deref()))
+            .collect(),
+        // Hierarchy pairs carry names only; the file is left empty and ignored.
+        Query::Children { parent } => db
+            .hierarchy(parent)
+            .expect("hierarchy")
+            .into_iter()
+            .filter(|(_, p)| p == parent)
+            .map(|(child, _)| (child, String::new()))
+            .collect(),
+        Query::Parents { child } => db
+            .hierarchy(child)
+            .expect("hierarchy")
+            .into_iter()
+            .filter(|(c, _)| c == child)
+            .map(|(_, parent)| (parent, String::new()))
+            .collect(),
+        Query::Definitions { name } => db
+            .search(name, None, None, 100)
+            .expect("search")
+            .into_iter()
+            .filter(|s| s.name == *name)
+            .map(|s| (s.name, s.file_path))
+            .collect(),
+    };
+    got.sort();
+    got.dedup();
+    got
+}
+
+fn matches(got: &(String, String), expected: &Item) -> bool {
+    got.0 == expected.0 && (got.1.is_empty() || got.1 == expected.1)
+}
+
+#[test]
+fn resolution_accuracy_benchmark() {
+    let db = setup_db("webapp_go");
+
+    let cases = [
+        Case {
+            question: "callers of ConnectionPool.GetConnection",
+            query: Query::Refs {
+                definition: ("GetConnection", "internal/database/pool.go"),
+                kind: EdgeKind::Calls,
+            },
+            expected: &[
+                ("ExecuteQuery", "internal/database/connection.go"),
+                ("Authenticate", "internal/services/authentication.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 1.0,
+        },
+        Case {
+            question: "callers of auth.GenerateToken",
+            query: Query::Refs {
+                definition: ("GenerateToken", "internal/auth/tokens.go"),
+                kind: EdgeKind::Calls,
+            },
+            expected: &[
+                ("RefreshToken", "internal/auth/tokens.go"),
+                ("Login", "internal/auth/service.go"),
+                ("Authenticate", "internal/services/authentication.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 1.0,
+        },
+        // Two of the calls are inside the closures the middlewares return;
+        // calls in function literals are not extracted.
+        Case {
+            question: "callers of auth.ValidateToken",
+            query: Query::Refs {
+                definition: ("ValidateToken", "internal/auth/tokens.go"),
+                kind: EdgeKind::Calls,
+            },
+            expected: &[
+                ("RefreshToken", "internal/auth/tokens.go"),
+                ("FindByToken", "internal/auth/tokens.go"),
+                ("GetCurrentUser", "internal/auth/service.go"),
+                ("AuthRequired", "internal/auth/middleware.go"),
+                ("AuthMiddleware", "internal/middleware/auth.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 0.6,
+        },
+        Case {
+            question: "code constructing auth.TokenError",
+            query: Query::Refs {
+                definition: ("TokenError", "internal/auth/tokens.go"),
+                kind: EdgeKind::References,
+            },
+            expected: &[
+                ("ValidateToken", "internal/auth/tokens.go"),
+                ("RevokeToken", "internal/auth/tokens.go"),
+            ],
+            min_precision: 0.5,
+            min_recall: 1.0,
+        },
+        // `authLog.Error` is ambiguous by name (four `Error` definitions), so
+        // it stays unresolved rather than bound to the wrong one.
+        Case {
+            question: "what AuthenticationService.Authenticate calls",
+            query: Query::Callees {
+                caller: ("Authenticate", "internal/services/authentication.go"),
+            },
+            expected: &[
+                ("Info", "pkg/logger/logger.go"),
+                ("Error", "pkg/logger/logger.go"),
+                ("Login", "internal/auth/service.go"),
+                ("GenerateToken", "internal/auth/tokens.go"),
+                ("GetConnection", "internal/database/pool.go"),
+                ("ReleaseConnection", "internal/database/pool.go"),
+                ("ExecuteQuery", "internal/database/connection.go"),
+            ],
+            min_precision: 0.8,
+            min_recall: 0.8,
+        },
+        // Go interfaces are satisfied implicitly and no edge records it.
+        Case {
+            question: "implementations of auth.AuthProvider",
+            query: Query::Children {
+                parent: "AuthProvider",
+            },
+            expected: &[("AuthService", "internal/auth/service.go")],
+            min_precision: 0.0,
+            min_recall: 0.0,
+        },
+    ];
+
+    score(&db, &cases);
+}
+
+#[test]
+fn edge_case_accuracy_benchmark() {
+    let db = setup_db("edgecases_go");
+
+    let cases = [
+        Case {
+            question: "what generics.Totals calls (instantiations)",
+            query: Query::Callees {
+                caller: ("Totals", "generics/fn.go"),
+            },
+            expected: &[
+                ("NewStack", "generics/stack.go"),
+                ("Push", "generics/stack.go"),
+                ("Len", "generics/stack.go"),
+                ("Map", "generics/fn.go"),
+                ("Sum", "generics/fn.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 1.0,
+        },
+        Case {
+            question: "interfaces embedded in ReadWriter",
+            query: Query::Parents {
+                child: "ReadWriter",
+            },
+            expected: &[("Reader", "embedding/io.go"), ("Writer", "embedding/io.go")],
+            min_precision: 1.0,
+            min_recall: 1.0,
+        },
+        // Struct embedding records no edge.
+        Case {
+            question: "structs embedding embedding.Base",
+            query: Query::Children { parent: "Base" },
+            expected: &[("Service", "embedding/service.go")],
+            min_precision: 0.0,
+            min_recall: 0.0,
+        },
+        // `svc.Describe()` in main is the promoted method, but by name it is
+        // ambiguous with legacy.Describe and stays unresolved.
+        Case {
+            question: "callers of promoted Base.Describe",
+            query: Query::Refs {
+                definition: ("Describe", "embedding/service.go"),
+                kind: EdgeKind::Calls,
+            },
+            expected: &[
+                ("Start", "embedding/service.go"),
+                ("main", "cmd/edge/main.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 0.5,
+        },
+        Case {
+            question: "definitions of platformName (build tags)",
+            query: Query::Definitions {
+                name: "platformName",
+            },
+            expected: &[
+                ("platformName", "buildtags/name_linux.go"),
+                ("platformName", "buildtags/name_other.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 1.0,
+        },
+        // The dot import is not used to resolve: Greet is ambiguous with
+        // legacy.Greet, Shout is unique project-wide.
+        Case {
+            question: "what dotimport.Welcome calls",
+            query: Query::Callees {
+                caller: ("Welcome", "dotimport/welcome.go"),
+            },
+            expected: &[("Greet", "textutil/text.go"), ("Shout", "textutil/text.go")],
+            min_precision: 1.0,
+            min_recall: 0.5,
+        },
+        // The call in the "audit" handler is inside a function literal.
+        Case {
+            question: "callers of closures.audit",
+            query: Query::Refs {
+                definition: ("audit", "closures/handlers.go"),
+                kind: EdgeKind::Calls,
+            },
+            expected: &[
+                ("handlers", "closures/handlers.go"),
+                ("Dispatch", "closures/handlers.go"),
+            ],
+            min_precision: 1.0,
+            min_recall: 0.5,
+        },
+    ];
+
+    score(&db, &cases);
+}
+
+/// Print precision, recall and F1 per case and fail on any case below its floors.
+fn score(db: &Database, cases: &[Case]) {
+    println!();
+    println!(
+        "  {:<45} {:>10} {:>10} {:>8}",
+        "Question", "Precision", "Recall", "F1"
+    );
+    println!("  {}", "-".repeat(76));
+
+    let mut total_p = 0.0;
+    let mut total_r = 0.0;
+    let mut total_f1 = 0.0;
+    let mut regressions = Vec::new();
+    let n = cases.len() as f64;
+
+    for case in cases {
+        let got = answer(db, &case.query);
+        let hits = case
+            .expected
+            .iter()
+            .filter(|e| got.iter().any(|g| matches(g, e)))
+            .count() as f64;
+        let correct = got
+            .iter()
+            .filter(|g| case.expected.iter().any(|e| matches(g, e)))
+            .count() as f64;
+        let precision = if got.is_empty() {
+            0.0
+        } else {
+            correct / got.len() as f64
+        };
+        let recall = hits / case.expected.len() as f64;
+        let f1 = if precision + recall == 0.0 {
+            0.0
+        } else {
+            2.0 * precision * recall / (precision + recall)
+        };
+
+        total_p += precision;
+        total_r += recall;
+        total_f1 += f1;
+
+        println!(
+            "  {:<45} {:>9.1}% {:>9.1}% {:>8.3}",
+            case.question,
+            precision * 100.0,
+            recall * 100.0,
+            f1
+        );
+        let got_str = if got.is_empty() {
+            "(nothing)".to_string()
+        } else {
+            got.iter()
+                .map(|(name, file)| {
+                    if case
+                        .expected
+                        .iter()
+                        .any(|e| matches(&(name.clone(), file.clone()), e))
+                    {
+                        format!("[{name}]")
+                    } else {
+                        format!("{name} ({file})")
+                    }
+                })
+                .collect::<Vec<_>>()
+                .join(", ")
+        };
+        println!("    got: {got_str}");
+
+        if precision < case.min_precision || recall < case.min_recall {
+            regressions.push(format!(
+                "{}: precision {:.2} (floor {:.2}), recall {:.2} (floor {:.2})",
+                case.question, precision, case.min_precision, recall, case.min_recall
+            ));
+        }
+    }
+
+    println!("  {}", "-".repeat(76));
+    println!(
+        "  {:<45} {:>9.1}% {:>9.1}% {:>8.3}",
+        "MEAN",
+        total_p / n * 100.0,
+        total_r / n * 100.0,
+        total_f1 / n,
+    );
+    println!();
+
+    assert!(
+        regressions.is_empty(),
+        "resolution accuracy regressed:\n  {}",
+        regressions.join("\n  ")
+    );
+}